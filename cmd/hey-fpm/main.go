@@ -10,6 +10,7 @@ import (
 
 	"github.com/urfave/cli/v3"
 	"github.com/wudi/hey/pkg/fpm/master"
+	"github.com/wudi/hey/pkg/fpm/opcache"
 	"github.com/wudi/hey/pkg/fpm/pool"
 	"github.com/wudi/hey/version"
 )
@@ -70,6 +71,21 @@ func main() {
 				Usage: "Number of requests each worker handles before respawning",
 				Value: 500,
 			},
+			&cli.BoolFlag{
+				Name:  "opcache",
+				Usage: "Cache compiled scripts between requests",
+				Value: true,
+			},
+			&cli.IntFlag{
+				Name:  "opcache-max-files",
+				Usage: "Maximum number of scripts kept in the opcache",
+				Value: 10000,
+			},
+			&cli.BoolFlag{
+				Name:  "opcache-validate-timestamps",
+				Usage: "Recompile cached scripts when they change on disk",
+				Value: true,
+			},
 			&cli.BoolFlag{
 				Name:    "test",
 				Aliases: []string{"t"},
@@ -111,6 +127,11 @@ func runFPM(ctx context.Context, cmd *cli.Command) error {
 		ErrorLog:   "/var/log/hey-fpm.log",
 		LogLevel:   "notice",
 		PoolConfig: poolConfig,
+		Opcache: &opcache.OpcacheConfig{
+			Enabled:            cmd.Bool("opcache"),
+			MaxEntries:         cmd.Int("opcache-max-files"),
+			ValidateTimestamps: cmd.Bool("opcache-validate-timestamps"),
+		},
 	}
 
 	m := master.NewMaster(masterConfig)
@@ -15,6 +15,12 @@ import (
 	"github.com/wudi/hey/compiler"
 	"github.com/wudi/hey/compiler/lexer"
 	"github.com/wudi/hey/compiler/parser"
+	"github.com/wudi/hey/pkg/coverage"
+	"github.com/wudi/hey/pkg/dap"
+	"github.com/wudi/hey/pkg/dbgp"
+	"github.com/wudi/hey/pkg/devserver"
+	"github.com/wudi/hey/pkg/fpm/opcache"
+	"github.com/wudi/hey/pkg/profile"
 	"github.com/wudi/hey/runtime"
 	"github.com/wudi/hey/values"
 	"github.com/wudi/hey/version"
@@ -32,7 +38,7 @@ func main() {
 			if _, err := os.Stat(filename); err == nil {
 				// File exists, execute it directly with all arguments
 				scriptArgs := append([]string{filename}, os.Args[2:]...)
-				if err := parseAndExecuteFileWithArgs(filename, scriptArgs); err != nil {
+				if err := parseAndExecuteFileWithArgs(filename, scriptArgs, runOptions{}); err != nil {
 					reportError(err)
 					os.Exit(1)
 				}
@@ -64,7 +70,7 @@ func main() {
 				Aliases: []string{"r"},
 				Usage:   "Run PHP <code> without using script tags <?..?>",
 				Action: func(ctx context.Context, cmd *cli.Command, s string) error {
-					return parseAndExecuteCode(s, true)
+					return parseAndExecuteCode(s, true, newRunOptions(cmd))
 				},
 			},
 			&cli.BoolFlag{
@@ -79,7 +85,7 @@ func main() {
 				Aliases: []string{"f"},
 				Usage:   "Parse and execute <file>.",
 				Action: func(ctx context.Context, cmd *cli.Command, s string) error {
-					return parseAndExecuteFile(s)
+					return parseAndExecuteFile(s, newRunOptions(cmd))
 				},
 			},
 			&cli.StringFlag{
@@ -87,9 +93,44 @@ func main() {
 				Local: true,
 				Usage: "<addr>:<port> Run with built-in web server.",
 				Action: func(ctx context.Context, cmd *cli.Command, s string) error {
-					return runWebServer(s)
+					return runWebServer(s, cmd.String("t"), cmd.Args().First())
 				},
 			},
+			&cli.StringFlag{
+				Name:  "t",
+				Local: true,
+				Usage: "<docroot> Specify document root <docroot> for built-in web server.",
+			},
+			&cli.BoolFlag{
+				Name:  "dap",
+				Local: true,
+				Usage: "Run as a Debug Adapter Protocol server on stdin and stdout",
+			},
+			&cli.StringFlag{
+				Name:  "profile",
+				Local: true,
+				Usage: "Profile the script to <file>, in pprof format for .pprof files and callgrind format otherwise",
+			},
+			&cli.StringFlag{
+				Name:  "coverage-clover",
+				Local: true,
+				Usage: "Write the code coverage of the script to <file> as a Clover XML report",
+			},
+			&cli.StringFlag{
+				Name:  "coverage-cobertura",
+				Local: true,
+				Usage: "Write the code coverage of the script to <file> as a Cobertura XML report",
+			},
+			&cli.BoolFlag{
+				Name:  "coverage-branches",
+				Local: true,
+				Usage: "Record which way conditions go in the coverage reports",
+			},
+			&cli.BoolFlag{
+				Name:  "optimize",
+				Local: true,
+				Usage: "Run the bytecode optimizer over the script and the code it includes",
+			},
 		},
 		Action: func(ctx context.Context, cmd *cli.Command) error {
 			// Check if version is requested
@@ -105,6 +146,22 @@ func main() {
 				return runInteractiveShell()
 			}
 
+			if cmd.Bool("dap") {
+				return runDebugAdapter()
+			}
+
+			opts := newRunOptions(cmd)
+			if (opts.profile != "" || opts.clover != "" || opts.cobertura != "") && cmd.Args().Len() == 0 {
+				return errors.New("--profile and --coverage-* need a script to run")
+			}
+			if cmd.Args().Len() > 0 {
+				filename := cmd.Args().First()
+				if _, err := os.Stat(filename); err != nil {
+					return fmt.Errorf("file not found: %s", filename)
+				}
+				return parseAndExecuteFileWithArgs(filename, cmd.Args().Slice(), opts)
+			}
+
 			// This path is no longer reached for PHP files as they're handled before CLI parsing
 			// This remains for backward compatibility with non-file arguments
 			if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
@@ -118,7 +175,7 @@ func main() {
 				return err
 			}
 
-			return parseAndExecuteCode(string(code), false)
+			return parseAndExecuteCode(string(code), false, opts)
 		},
 	}
 
@@ -129,27 +186,61 @@ func main() {
 	}
 }
 
-func parseAndExecuteFile(filename string) error {
+// runOptions are the command line options for running a script
+type runOptions struct {
+	// profile is the file given with --profile
+	profile string
+	// clover and cobertura are the coverage reports asked for with
+	// --coverage-clover and --coverage-cobertura, with branches recorded
+	// when coverageBranches is set
+	clover, cobertura string
+	coverageBranches  bool
+	// optimize is set with --optimize
+	optimize bool
+}
+
+// newRunOptions reads the options for running a script from cmd
+func newRunOptions(cmd *cli.Command) runOptions {
+	return runOptions{
+		profile:          cmd.String("profile"),
+		clover:           cmd.String("coverage-clover"),
+		cobertura:        cmd.String("coverage-cobertura"),
+		coverageBranches: cmd.Bool("coverage-branches"),
+		optimize:         cmd.Bool("optimize"),
+	}
+}
+
+// newCompiler returns a compiler that optimizes when asked with --optimize
+func (opts runOptions) newCompiler() *compiler.Compiler {
+	comp := compiler.NewCompiler()
+	comp.SetOptimize(opts.optimize)
+	return comp
+}
+
+func parseAndExecuteFile(filename string, opts runOptions) error {
 	code, err := os.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-	return parseAndExecuteCodeWithFile(string(code), false, filename)
+	return parseAndExecuteCodeWithFile(string(code), false, filename, opts)
 }
 
-func parseAndExecuteFileWithArgs(filename string, args []string) error {
+func parseAndExecuteFileWithArgs(filename string, args []string, opts runOptions) error {
+	if dir := os.Getenv("HEY_OPCACHE_DIR"); dir != "" {
+		return executeCachedFileWithArgs(filename, dir, args, opts)
+	}
 	code, err := os.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-	return parseAndExecuteCodeWithFileAndArgs(string(code), false, filename, args)
+	return parseAndExecuteCodeWithFileAndArgs(string(code), false, filename, args, opts)
 }
 
-func parseAndExecuteCodeWithFile(code string, inScript bool, filename string) error {
-	return parseAndExecuteCodeWithFileAndArgs(code, inScript, filename, []string{filename})
+func parseAndExecuteCodeWithFile(code string, inScript bool, filename string, opts runOptions) error {
+	return parseAndExecuteCodeWithFileAndArgs(code, inScript, filename, []string{filename}, opts)
 }
 
-func parseAndExecuteCodeWithFileAndArgs(code string, inScript bool, filename string, args []string) error {
+func parseAndExecuteCodeWithFileAndArgs(code string, inScript bool, filename string, args []string, opts runOptions) error {
 	var l *lexer.Lexer
 	if inScript {
 		l = lexer.NewInScripting(code)
@@ -172,7 +263,7 @@ func parseAndExecuteCodeWithFileAndArgs(code string, inScript bool, filename str
 		os.Exit(1)
 	}
 
-	comp := compiler.NewCompiler()
+	comp := opts.newCompiler()
 	// Set the current file for magic constants
 	if filename != "" {
 		comp.SetCurrentFile(filename)
@@ -182,6 +273,60 @@ func parseAndExecuteCodeWithFileAndArgs(code string, inScript bool, filename str
 		os.Exit(1)
 	}
 
+	// Create VM with pre-configured compiler callback
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return opts.newCompiler()
+	})
+
+	return executeScript(factory, &opcache.CompiledScript{
+		Instructions: comp.GetBytecode(),
+		Constants:    comp.GetConstants(),
+		Functions:    comp.Functions(),
+		Classes:      comp.Classes(),
+		Interfaces:   comp.Interfaces(),
+		Traits:       comp.Traits(),
+	}, args, opts)
+}
+
+// executeCachedFileWithArgs runs filename through the on-disk bytecode cache
+// in dir, so the script and everything it includes are only recompiled when
+// their source or the interpreter changes.
+func executeCachedFileWithArgs(filename string, dir string, args []string, opts runOptions) error {
+	// Initialize runtime first so constants are registered
+	if err := runtime.Bootstrap(); err != nil {
+		fmt.Println("Failed to bootstrap runtime:", err)
+		os.Exit(1)
+	}
+
+	cache, err := opcache.NewFileCache(dir)
+	if err != nil {
+		return err
+	}
+	if opts.optimize {
+		cache.SetCompileOptions("optimize")
+	}
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return opts.newCompiler()
+	})
+	factory.SetOpcache(cache)
+
+	compiled, err := factory.CompileFile(filename)
+	if err != nil {
+		var parseErr *vmfactory.ParseError
+		if errors.As(err, &parseErr) {
+			for _, msg := range parseErr.Errors {
+				fmt.Println(msg)
+			}
+			os.Exit(1)
+		}
+		return err
+	}
+
+	return executeScript(factory, compiled, args, opts)
+}
+
+// executeScript runs a compiled script with $argc and $argv set from args
+func executeScript(factory *vmfactory.VMFactory, compiled *opcache.CompiledScript, args []string, opts runOptions) error {
 	// Initialize VM integration
 	if err := runtime.InitializeVMIntegration(); err != nil {
 		fmt.Println("Failed to initialize VM integration:", err)
@@ -209,18 +354,73 @@ func parseAndExecuteCodeWithFileAndArgs(code string, inScript bool, filename str
 		vmCtx.GlobalVars.Store("$argv", argv)
 	}
 
-	// Create VM with pre-configured compiler callback
-	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
-		return compiler.NewCompiler()
-	})
 	vmachine := factory.CreateVM()
 
-	// Execute the program
+	// Profile the script when asked with --profile or xdebug.mode=profile
+	var profiler *vm.Profiler
+	profilePath := opts.profile
+	if profilePath == "" && len(args) > 0 && profile.Enabled() {
+		profilePath = profile.OutputFile(args[0], "")
+	}
+	if profilePath != "" {
+		profiler = vm.NewProfiler()
+		vmachine.SetProfiler(profiler)
+	}
+	writeProfile := func() {
+		if profiler == nil {
+			return
+		}
+		if err := profile.WriteFile(profilePath, profiler.Stop(), strings.Join(args, " ")); err != nil {
+			fmt.Fprintln(os.Stderr, "Profiler:", err)
+		}
+	}
+
+	// Record the lines run when a coverage report is asked for
+	var recorder *vm.Coverage
+	if opts.clover != "" || opts.cobertura != "" {
+		recorder = vm.NewCoverage(opts.coverageBranches)
+		vmachine.SetCoverage(recorder)
+	}
+	writeCoverage := func() {
+		if recorder == nil {
+			return
+		}
+		files := recorder.Files()
+		for format, path := range map[coverage.Format]string{coverage.Clover: opts.clover, coverage.Cobertura: opts.cobertura} {
+			if path == "" {
+				continue
+			}
+			if err := coverage.WriteFile(path, format, files); err != nil {
+				fmt.Fprintln(os.Stderr, "Coverage:", err)
+			}
+		}
+	}
+
+	// Connect to the DBGp client when step debugging is enabled
+	var session *dbgp.Session
+	if config := dbgp.LoadConfig(); len(args) > 0 && config.ShouldStart(dbgp.EnvTriggered()) {
+		var err error
+		if session, err = dbgp.Start(config, factory, vmachine, args[0]); err != nil {
+			fmt.Fprintln(os.Stderr, "Xdebug:", err)
+		}
+	}
+
 	// Execute the script
-	err := vmachine.Execute(vmCtx, comp.GetBytecode(), comp.GetConstants(), comp.Functions(), comp.Classes(), comp.Interfaces(), comp.Traits())
+	err := vmachine.Execute(vmCtx, compiled.Instructions, compiled.Constants,
+		compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+	if session != nil {
+		session.Finish()
+	}
+	if errors.Is(err, vm.ErrDebuggerTerminated) {
+		writeProfile()
+		writeCoverage()
+		return nil
+	}
 
 	// Call destructors on all remaining objects at script end
 	vmachine.CallAllDestructors(vmCtx)
+	writeProfile()
+	writeCoverage()
 
 	// Check if exit() or die() was called
 	if vmCtx.Halted {
@@ -234,7 +434,7 @@ func parseAndExecuteCodeWithFileAndArgs(code string, inScript bool, filename str
 	return err
 }
 
-func parseAndExecuteCode(code string, inScript bool) error {
+func parseAndExecuteCode(code string, inScript bool, opts runOptions) error {
 	var l *lexer.Lexer
 	if inScript {
 		l = lexer.NewInScripting(code)
@@ -257,7 +457,7 @@ func parseAndExecuteCode(code string, inScript bool) error {
 		os.Exit(1)
 	}
 
-	comp := compiler.NewCompiler()
+	comp := opts.newCompiler()
 	if err := comp.Compile(prog); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -280,7 +480,7 @@ func parseAndExecuteCode(code string, inScript bool) error {
 
 	// Create VM with pre-configured compiler callback
 	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
-		return compiler.NewCompiler()
+		return opts.newCompiler()
 	})
 	vmachine := factory.CreateVM()
 
@@ -303,10 +503,49 @@ func parseAndExecuteCode(code string, inScript bool) error {
 	return err
 }
 
-func runWebServer(addr string) error {
-	// Placeholder for built-in web server functionality
-	fmt.Printf("Starting built-in web server at %s (not yet implemented)\n", addr)
-	return nil
+func runWebServer(addr string, docRoot string, router string) error {
+	if err := runtime.Bootstrap(); err != nil {
+		return fmt.Errorf("failed to bootstrap runtime: %w", err)
+	}
+	if err := runtime.InitializeVMIntegration(); err != nil {
+		return fmt.Errorf("failed to initialize VM integration: %w", err)
+	}
+
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return compiler.NewCompiler()
+	})
+	// Scripts are recompiled as soon as they are edited
+	factory.SetOpcache(opcache.NewOpcacheManager(&opcache.OpcacheConfig{
+		Enabled:            true,
+		ValidateTimestamps: true,
+	}))
+
+	server, err := devserver.NewServer(devserver.Config{
+		Addr:      addr,
+		DocRoot:   docRoot,
+		Router:    router,
+		AccessLog: os.Stderr,
+	}, factory)
+	if err != nil {
+		return err
+	}
+	return server.ListenAndServe()
+}
+
+// runDebugAdapter serves a debugging session to an editor over stdin and
+// stdout; the script to debug comes with the session's launch request
+func runDebugAdapter() error {
+	if err := runtime.Bootstrap(); err != nil {
+		return fmt.Errorf("failed to bootstrap runtime: %w", err)
+	}
+	if err := runtime.InitializeVMIntegration(); err != nil {
+		return fmt.Errorf("failed to initialize VM integration: %w", err)
+	}
+
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return compiler.NewCompiler()
+	})
+	return dap.NewServer(factory).Serve(os.Stdin, os.Stdout)
 }
 
 type errorFrame struct {
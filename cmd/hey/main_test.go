@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+
+	"github.com/wudi/hey/compiler/lexer"
+	"github.com/wudi/hey/compiler/parser"
 )
 
 func TestFormatErrorMessageIncludeStack(t *testing.T) {
@@ -43,3 +46,15 @@ func TestFormatErrorMessageExecutionFallback(t *testing.T) {
 		t.Fatalf("unexpected execution fallback output\nwant:\n%s\n\ngot:\n%s", want, got)
 	}
 }
+
+func TestNewCompilerOptimizes(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		comp := runOptions{optimize: enabled}.newCompiler()
+		if err := comp.Compile(parser.New(lexer.New("<?php $x = 2 * 3 + 1;")).ParseProgram()); err != nil {
+			t.Fatal(err)
+		}
+		if ran := comp.OptimizationStats().Iterations > 0; ran != enabled {
+			t.Fatalf("with --optimize %v the optimizer ran: %v", enabled, ran)
+		}
+	}
+}
@@ -3,69 +3,202 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/urfave/cli/v3"
+	"github.com/wudi/hey/pkg/composer"
 )
 
+// workingDirFlag is shared by the composer commands
+func workingDirFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "working-dir",
+		Aliases: []string{"d"},
+		Usage:   "If specified, use the given directory as working directory",
+		Value:   ".",
+	}
+}
+
+func newComposer(cmd *cli.Command) (*composer.Composer, error) {
+	return composer.New(cmd.String("working-dir"), composer.Options{Output: os.Stderr})
+}
+
 var initCommand = &cli.Command{
-	Name:   "init",
-	Usage:  "Creates a composer.json file in the current directory",
-	Flags:  []cli.Flag{},
+	Name:  "init",
+	Usage: "Creates a composer.json file in the current directory",
+	Flags: []cli.Flag{
+		workingDirFlag(),
+		&cli.StringFlag{Name: "name", Usage: "Name of the package"},
+		&cli.StringFlag{Name: "description", Usage: "Description of package"},
+		&cli.StringSliceFlag{Name: "author", Usage: "Author name of package"},
+		&cli.StringFlag{Name: "type", Usage: "Type of package (e.g. library, project, metapackage, composer-plugin)"},
+		&cli.StringFlag{Name: "homepage", Usage: "Homepage of package"},
+		&cli.StringSliceFlag{Name: "require", Usage: "Package to require with a version constraint, e.g. foo/bar:1.0.0"},
+		&cli.StringSliceFlag{Name: "require-dev", Usage: "Package to require for development with a version constraint"},
+		&cli.StringFlag{Name: "stability", Aliases: []string{"s"}, Usage: "Minimum stability (empty or one of: stable, RC, beta, alpha, dev)"},
+		&cli.StringFlag{Name: "license", Aliases: []string{"l"}, Usage: "License of package"},
+		&cli.StringFlag{Name: "autoload", Aliases: []string{"a"}, Usage: "Add PSR-4 autoload mapping. Maps your package's namespace to the provided directory"},
+	},
 	Action: initAction,
 }
 
 func initAction(ctx context.Context, cmd *cli.Command) error {
-	fmt.Println("Creates a composer.json file in the current directory")
-	return nil
+	c, err := newComposer(cmd)
+	if err != nil {
+		return err
+	}
+	return c.Init(composer.InitOptions{
+		Name:             cmd.String("name"),
+		Description:      cmd.String("description"),
+		Type:             cmd.String("type"),
+		Homepage:         cmd.String("homepage"),
+		License:          cmd.String("license"),
+		Authors:          cmd.StringSlice("author"),
+		Require:          cmd.StringSlice("require"),
+		RequireDev:       cmd.StringSlice("require-dev"),
+		MinimumStability: cmd.String("stability"),
+		Autoload:         cmd.String("autoload"),
+	})
 }
 
 var requireCommand = &cli.Command{
-	Name:   "require",
-	Usage:  "Adds required packages to your composer.json and installs them",
-	Flags:  []cli.Flag{},
+	Name:      "require",
+	Usage:     "Adds required packages to your composer.json and installs them",
+	ArgsUsage: "<vendor/package[:constraint]>...",
+	Flags: []cli.Flag{
+		workingDirFlag(),
+		&cli.BoolFlag{Name: "dev", Usage: "Add requirement to require-dev"},
+		&cli.BoolFlag{Name: "no-update", Usage: "Disables the automatic update of the dependencies"},
+		&cli.BoolFlag{Name: "no-install", Usage: "Skip the install step after updating the composer.lock file"},
+		&cli.BoolFlag{Name: "update-no-dev", Usage: "Run the dependency update with the --no-dev option"},
+	},
 	Action: requireAction,
 }
 
 func requireAction(ctx context.Context, cmd *cli.Command) error {
-	fmt.Println("Adds required packages to your composer.json and installs them")
-	return nil
+	if cmd.Args().Len() == 0 {
+		return fmt.Errorf("not enough arguments (missing: \"packages\")")
+	}
+	c, err := newComposer(cmd)
+	if err != nil {
+		return err
+	}
+	return c.Require(cmd.Args().Slice(), composer.RequireOptions{
+		Dev:      cmd.Bool("dev"),
+		NoUpdate: cmd.Bool("no-update"),
+		InstallOptions: composer.InstallOptions{
+			NoDev:     cmd.Bool("update-no-dev"),
+			NoInstall: cmd.Bool("no-install"),
+		},
+	})
 }
 
 var installCommand = &cli.Command{
 	Name:    "install",
 	Aliases: []string{"i"},
-	Usage:   "Installs your composer.json and installs them",
-	Flags:   []cli.Flag{},
-	Action:  installAction,
+	Usage:   "Installs the project dependencies from the composer.lock file if present, or falls back on the composer.json",
+	Flags: []cli.Flag{
+		workingDirFlag(),
+		&cli.BoolFlag{Name: "no-dev", Usage: "Disables installation of require-dev packages"},
+	},
+	Action: installAction,
 }
 
 func installAction(ctx context.Context, cmd *cli.Command) error {
-	fmt.Println("Installs the project dependencies from the composer.lock file if present, or falls back on the composer.json")
-	return nil
+	c, err := newComposer(cmd)
+	if err != nil {
+		return err
+	}
+	return c.Install(composer.InstallOptions{NoDev: cmd.Bool("no-dev")})
 }
 
 var updateCommand = &cli.Command{
-	Name:    "update",
-	Aliases: []string{"u"},
-	Usage:   "Updates your dependencies to the latest version according to composer.json, and updates the composer.lock file",
-	Flags:   []cli.Flag{},
-	Action:  updateAction,
+	Name:      "update",
+	Aliases:   []string{"u"},
+	Usage:     "Updates your dependencies to the latest version according to composer.json, and updates the composer.lock file",
+	ArgsUsage: "[<vendor/package>...]",
+	Flags: []cli.Flag{
+		workingDirFlag(),
+		&cli.BoolFlag{Name: "no-dev", Usage: "Disables installation of require-dev packages"},
+		&cli.BoolFlag{Name: "no-install", Usage: "Skip the install step after updating the composer.lock file"},
+	},
+	Action: updateAction,
 }
 
 func updateAction(ctx context.Context, cmd *cli.Command) error {
-	fmt.Println("Updates your dependencies to the latest version according to composer.json, and updates the composer.lock file")
-	return nil
+	c, err := newComposer(cmd)
+	if err != nil {
+		return err
+	}
+	return c.Update(cmd.Args().Slice(), composer.InstallOptions{
+		NoDev:     cmd.Bool("no-dev"),
+		NoInstall: cmd.Bool("no-install"),
+	})
 }
 
 var validateCommand = &cli.Command{
-	Name:   "validate",
-	Usage:  "Validates a composer.json file",
-	Flags:  []cli.Flag{},
+	Name:  "validate",
+	Usage: "Validates a composer.json and composer.lock",
+	Flags: []cli.Flag{
+		workingDirFlag(),
+		&cli.BoolFlag{Name: "strict", Usage: "Return a non-zero exit code for warnings as well as errors"},
+		&cli.BoolFlag{Name: "no-check-lock", Usage: "Do not check if lock file is up to date"},
+		&cli.BoolFlag{Name: "no-check-publish", Usage: "Do not check for publish errors"},
+	},
 	Action: validateAction,
 }
 
+// validateAction prints the validation result like Composer and exits with
+// 1 for warnings under --strict, 2 for errors and 3 for an unreadable file.
 func validateAction(ctx context.Context, cmd *cli.Command) error {
-	fmt.Println("Validates a composer.json file")
+	c, err := newComposer(cmd)
+	if err != nil {
+		return err
+	}
+	result, err := c.Validate(composer.ValidateOptions{
+		NoCheckLock:    cmd.Bool("no-check-lock"),
+		NoCheckPublish: cmd.Bool("no-check-publish"),
+	})
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("./composer.json could not be read: %v", err), 3)
+	}
+
+	hasErrors := len(result.Errors) > 0 || len(result.LockErrors) > 0
+	switch {
+	case hasErrors:
+		fmt.Fprintln(os.Stderr, "./composer.json is invalid, the following errors/warnings were found:")
+	case len(result.PublishErrors) > 0:
+		fmt.Fprintln(os.Stderr, "./composer.json is valid for simple usage with Composer but has")
+		fmt.Fprintln(os.Stderr, "strict errors that make it unable to be published as a package")
+	case len(result.Warnings) > 0:
+		fmt.Fprintln(os.Stderr, "./composer.json is valid, but with a few warnings")
+	default:
+		fmt.Fprintln(os.Stderr, "./composer.json is valid")
+	}
+	for _, section := range []struct {
+		title    string
+		messages []string
+	}{
+		{"General errors", result.Errors},
+		{"Publish errors", result.PublishErrors},
+		{"Lock file errors", result.LockErrors},
+		{"General warnings", result.Warnings},
+	} {
+		if len(section.messages) == 0 {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "# %s\n", section.title)
+		for _, msg := range section.messages {
+			fmt.Fprintf(os.Stderr, "- %s\n", msg)
+		}
+	}
+
+	switch {
+	case hasErrors:
+		return cli.Exit("", 2)
+	case cmd.Bool("strict") && (len(result.Warnings) > 0 || len(result.PublishErrors) > 0):
+		return cli.Exit("", 1)
+	}
 	return nil
 }
 
@@ -2006,6 +2006,7 @@ type FunctionDeclaration struct {
 	IsAbstract  bool              `json:"isAbstract,omitempty"`  // abstract function
 	IsFinal     bool              `json:"isFinal,omitempty"`     // final function
 	Attributes  []*AttributeGroup `json:"attributes,omitempty"`  // #[...] attributes
+	DocComment  string            `json:"docComment,omitempty"`  // /** ... */ preceding the declaration
 }
 
 type Parameter struct {
@@ -2621,6 +2622,7 @@ type PropertyDeclaration struct {
 	Name         string            `json:"name"` // Property name without $
 	DefaultValue Expression        `json:"defaultValue,omitempty"`
 	Attributes   []*AttributeGroup `json:"attributes,omitempty"` // #[...] attributes
+	DocComment   string            `json:"docComment,omitempty"` // /** ... */ preceding the declaration
 }
 
 func NewPropertyDeclaration(pos lexer.Position, visibility, name string, static, readOnly bool, typeHint *TypeHint, defaultValue Expression) *PropertyDeclaration {
@@ -5025,6 +5027,7 @@ type ClassExpression struct {
 	Implements []Expression      `json:"implements"`
 	Body       []Statement       `json:"body"`
 	Attributes []*AttributeGroup `json:"attributes,omitempty"` // class attributes #[Attr]
+	DocComment string            `json:"docComment,omitempty"` // /** ... */ preceding the declaration
 }
 
 func NewClassExpression(pos lexer.Position, name, extends Expression, implements []Expression, final, readOnly, abstract bool) *ClassExpression {
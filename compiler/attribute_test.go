@@ -0,0 +1,101 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestReflectionAttributes verifies that #[...] attributes can be read back
+// and instantiated through ReflectionAttribute
+func TestReflectionAttributes(t *testing.T) {
+	const classes = `#[Attribute(Attribute::TARGET_METHOD | Attribute::TARGET_FUNCTION)]
+class AttrTestRoute {
+    public function __construct(public string $path, public array $methods = ['GET'], public ?string $name = null) {}
+}
+#[Attribute(Attribute::TARGET_PROPERTY | Attribute::IS_REPEATABLE)]
+class AttrTestRule { public function __construct(public string $rule) {} }
+#[Attribute]
+class AttrTestMarker {}
+class AttrTestPlain {}
+
+#[AttrTestMarker]
+class AttrTestController {
+    #[AttrTestRule('notBlank'), AttrTestRule('email')]
+    public string $email = '';
+    #[AttrTestRoute('/users', methods: ['GET', 'POST'], name: 'users')]
+    public function list(#[AttrTestMarker] $filter = null) {}
+    #[AttrTestRoute(name: 'other', path: '/other')]
+    #[AttrTestPlain]
+    public function other() {}
+}
+`
+
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "arguments keep positional and named keys",
+			code: classes + `$attrs = (new ReflectionMethod('AttrTestController', 'list'))->getAttributes();
+var_dump(count($attrs), $attrs[0]->getName(), $attrs[0]->getTarget() === Attribute::TARGET_METHOD);
+var_dump($attrs[0]->getArguments());`,
+			expected: "int(1)\nstring(13) \"AttrTestRoute\"\nbool(true)\n" +
+				"array(3) {\n  [0]=>\n  string(6) \"/users\"\n  [\"methods\"]=>\n  array(2) {\n    [0]=>\n    string(3) \"GET\"\n    [1]=>\n    string(4) \"POST\"\n  }\n  [\"name\"]=>\n  string(5) \"users\"\n}\n",
+		},
+		{
+			name: "newInstance maps named arguments onto the constructor",
+			code: classes + `$route = (new ReflectionMethod('AttrTestController', 'other'))->getAttributes(AttrTestRoute::class)[0]->newInstance();
+echo $route->path, " ", $route->name, " ", implode(",", $route->methods), "\n";`,
+			expected: "/other other GET\n",
+		},
+		{
+			name: "filtering by name and by instanceof",
+			code: classes + `$rc = new ReflectionClass('AttrTestController');
+var_dump(count($rc->getAttributes('attrtestmarker')), count($rc->getAttributes('AttrTestRoute')));
+var_dump(count($rc->getMethod('other')->getAttributes(AttrTestRoute::class, ReflectionAttribute::IS_INSTANCEOF)));
+var_dump(count($rc->getMethod('list')->getParameters()[0]->getAttributes()));`,
+			expected: "int(1)\nint(0)\nint(1)\nint(1)\n",
+		},
+		{
+			name: "repeatable property attributes",
+			code: classes + `foreach ((new ReflectionProperty('AttrTestController', 'email'))->getAttributes() as $attr) {
+    echo var_export($attr->isRepeated(), true), " ", $attr->newInstance()->rule, "\n";
+}`,
+			expected: "true notBlank\ntrue email\n",
+		},
+		{
+			name: "Attribute flags are validated",
+			code: classes + `#[AttrTestRoute('/bad')]
+class AttrTestMisplaced {}
+#[AttrTestMarker, AttrTestMarker]
+class AttrTestTwice {}
+#[AttrTestMissing]
+function attr_test_missing() {}
+$tries = [
+    fn() => (new ReflectionClass('AttrTestMisplaced'))->getAttributes()[0]->newInstance(),
+    fn() => (new ReflectionClass('AttrTestTwice'))->getAttributes()[0]->newInstance(),
+    fn() => (new ReflectionMethod('AttrTestController', 'other'))->getAttributes()[1]->newInstance(),
+    fn() => (new ReflectionFunction('attr_test_missing'))->getAttributes()[0]->newInstance(),
+];
+foreach ($tries as $try) {
+    try { $try(); } catch (Error $e) { echo $e->getMessage(), "\n"; }
+}
+var_dump((new ReflectionClass('AttrTestRoute'))->getAttributes()[0]->newInstance()->flags);`,
+			expected: "Attribute \"AttrTestRoute\" cannot target class (allowed targets: function, method)\n" +
+				"Attribute \"AttrTestMarker\" must not be repeated\n" +
+				"Attempting to use non-attribute class \"AttrTestPlain\" as attribute\n" +
+				"Attribute class \"AttrTestMissing\" not found\n" +
+				"int(6)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := compileAndExecute(t, "<?php\n"+tt.code)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, output)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/compiler/lexer"
+	"github.com/wudi/hey/compiler/parser"
+	"github.com/wudi/hey/registry"
+	runtime2 "github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vm"
+	"github.com/wudi/hey/vmfactory"
+)
+
+// TestSplAutoload verifies that class lookups fall back to the autoloaders
+// registered with spl_autoload_register
+func TestSplAutoload(t *testing.T) {
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime2.Bootstrap())
+	if runtime2.GlobalVMIntegration == nil {
+		require.NoError(t, runtime2.InitializeVMIntegration())
+	}
+
+	// Class definitions outlive a single execution, so every case loads its
+	// classes from its own namespace (NS below is replaced per case).
+	sources := map[string]string{
+		"Base.php": `<?php
+namespace NS;
+abstract class Base {
+    const KIND = "base";
+    public static function make() { return new static(); }
+}`,
+		"Named.php": `<?php
+namespace NS;
+interface Named { public function name(); }`,
+		"User.php": `<?php
+namespace NS;
+class User extends Base implements Named {
+    public function name() { return "user"; }
+}`,
+		"Chained.php": `<?php
+namespace NS;
+class Chained {}`,
+	}
+
+	const loader = `class LoaderNS {
+    public function load($class) {
+        echo "load $class\n";
+        $file = DIR . '/' . str_replace('\\', '/', $class) . '.php';
+        if (file_exists($file)) { require $file; }
+    }
+}
+spl_autoload_register([new LoaderNS(), 'load']);
+`
+
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "new loads class and parent",
+			code: loader + `$u = new \NS\User();
+echo $u->name(), "\n";`,
+			expected: "load NS\\User\nload NS\\Base\nuser\n",
+		},
+		{
+			name:     "class constant",
+			code:     loader + `echo \NS\User::KIND, "\n";`,
+			expected: "load NS\\User\nload NS\\Base\nbase\n",
+		},
+		{
+			name:     "static call",
+			code:     loader + `echo get_class(\NS\User::make()), "\n";`,
+			expected: "load NS\\User\nload NS\\Base\nNS\\User\n",
+		},
+		{
+			name: "instanceof checks the autoloaded hierarchy",
+			code: loader + `$u = new \NS\User();
+var_dump($u instanceof \NS\Named, $u instanceof \NS\Base);`,
+			expected: "load NS\\User\nload NS\\Base\nbool(true)\nbool(true)\n",
+		},
+		{
+			name: "class_exists honours autoload flag",
+			code: loader + `var_dump(class_exists('NS\User', false));
+var_dump(class_exists('NS\User'));
+var_dump(class_exists('NS\Missing'));`,
+			expected: "bool(false)\nload NS\\User\nload NS\\Base\nbool(true)\nload NS\\Missing\nbool(false)\n",
+		},
+		{
+			name: "loaders run in order until the class exists",
+			code: `spl_autoload_register(function ($c) { echo "first $c\n"; });
+spl_autoload_register(function ($c) { echo "second $c\n"; require DIR . '/NS/Chained.php'; });
+spl_autoload_register(function ($c) { echo "third $c\n"; });
+var_dump(class_exists('NS\Chained'));`,
+			expected: "first NS\\Chained\nsecond NS\\Chained\nbool(true)\n",
+		},
+		{
+			name: "recursive lookups do not re-enter the loader",
+			code: `spl_autoload_register(function ($c) {
+    echo "load $c\n";
+    var_dump(class_exists($c));
+});
+var_dump(class_exists('NS\Recursive'));`,
+			expected: "load NS\\Recursive\nbool(false)\nbool(false)\n",
+		},
+		{
+			name: "each lookup of a missing class runs the loaders once",
+			code: loader + `try { new \NS\Missing(); } catch (Error $e) { echo $e->getMessage(), "\n"; }
+var_dump(class_exists('NS\Missing'));
+try { new \NS\Missing(); } catch (Error $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "load NS\\Missing\nClass \"NS\\Missing\" not found\n" +
+				"load NS\\Missing\nbool(false)\n" +
+				"load NS\\Missing\nClass \"NS\\Missing\" not found\n",
+		},
+		{
+			name: "spl_autoload_call",
+			code: `spl_autoload_register(function ($c) { echo "load $c\n"; });
+spl_autoload_call('NS\Called');`,
+			expected: "load NS\\Called\n",
+		},
+		{
+			name: "exceptions thrown by loaders reach the caller",
+			code: `spl_autoload_register(function ($c) { throw new RuntimeException("cannot load $c"); });
+spl_autoload_register(function ($c) { echo "not reached\n"; });
+try { new \NS\Broken(); } catch (RuntimeException $e) { echo $e->getMessage(), "\n"; }
+try { var_dump(class_exists('NS\Broken')); } catch (RuntimeException $e) { echo "class_exists: ", $e->getMessage(), "\n"; }
+function make() { return \NS\Broken::make(); }
+try { make(); } catch (RuntimeException $e) { echo "static call: ", $e->getMessage(), "\n"; }`,
+			expected: "cannot load NS\\Broken\nclass_exists: cannot load NS\\Broken\nstatic call: cannot load NS\\Broken\n",
+		},
+	}
+
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return NewCompiler()
+	})
+	run := func(t *testing.T, code string) string {
+		p := parser.New(lexer.New("<?php\n" + code))
+		prog := p.ParseProgram()
+		require.Empty(t, p.Errors(), "Parser errors: %v", p.Errors())
+
+		comp := NewCompiler()
+		require.NoError(t, comp.Compile(prog))
+
+		vmCtx := vm.NewExecutionContext()
+		var buf bytes.Buffer
+		vmCtx.SetOutputWriter(&buf)
+
+		err := factory.CreateVM().Execute(vmCtx, comp.GetBytecode(), comp.GetConstants(), comp.Functions(), comp.Classes(), comp.Interfaces(), comp.Traits())
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	tmpDir := t.TempDir()
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := fmt.Sprintf("AutoloadCase%d", i)
+			for name, content := range sources {
+				path := filepath.Join(tmpDir, ns, name)
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+				require.NoError(t, os.WriteFile(path, []byte(strings.ReplaceAll(content, "NS", ns)), 0644))
+			}
+			code := strings.NewReplacer("NS", ns, "DIR", "'"+tmpDir+"'").Replace(tt.code)
+			require.Equal(t, strings.ReplaceAll(tt.expected, "NS", ns), run(t, code))
+		})
+	}
+}
+
+// TestSplAutoloadChainPerExecution verifies that autoloaders registered by
+// one execution are not seen by the next
+func TestSplAutoloadChainPerExecution(t *testing.T) {
+	register := `spl_autoload_register(function ($c) { echo "leaked $c\n"; });
+echo count(spl_autoload_functions()), "\n";`
+	check := `echo count(spl_autoload_functions()), "\n";
+var_dump(class_exists('PerExecutionMissing'));`
+
+	for _, tt := range []struct{ code, expected string }{
+		{register, "1\n"},
+		{register, "1\n"},
+		{check, "0\nbool(false)\n"},
+	} {
+		output, err := compileAndExecute(t, "<?php\n"+tt.code)
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, output)
+	}
+}
@@ -0,0 +1,114 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestClosures covers $this and scope binding, the Closure class methods and
+// first-class callable syntax
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "this and use variables",
+			code: `class ClosureCounter {
+    private $step = 3;
+    function adder() { return function ($x) { return $x + $this->step; }; }
+    function mapped() { return array_map(function ($x) { return $x * $this->step; }, [1, 2]); }
+}
+function closureUse() {
+    $base = 10;
+    $byValue = function ($x) use ($base) { return $base + $x; };
+    $total = 0;
+    $byRef = function ($x) use (&$total) { $total += $x; };
+    $base = 99;
+    $byRef(1);
+    $byRef(2);
+    return $byValue(1) . " " . $total;
+}
+$c = new ClosureCounter();
+echo $c->adder()(1), " ", implode(",", $c->mapped()), " ", closureUse(), "\n";
+$unbound = function () { return isset($this) ? "bound" : "unbound"; };
+echo $unbound(), "\n";`,
+			expected: "4 3,6 11 3\nunbound\n",
+		},
+		{
+			name: "bind, bindTo and call",
+			code: `class ClosureBox { private $v = 1; private static $hidden = "h"; }
+class ClosureOther { private $v = 2; }
+$get = function () { return $this->v; };
+echo $get->bindTo(new ClosureBox(), ClosureBox::class)(), " ";
+echo Closure::bind($get, new ClosureOther(), ClosureOther::class)(), " ";
+echo $get->call(new ClosureOther()), " ";
+echo Closure::bind(static function () { return ClosureBox::$hidden; }, null, ClosureBox::class)(), "\n";
+$add = function ($a, $b) { return $this->v + $a + $b; };
+echo $add->call(new ClosureBox(), 10, 20), "\n";
+var_dump(@(static function () {})->bindTo(new ClosureBox()));
+try { $get->call(5); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "1 2 2 h\n31\nNULL\n" +
+				"Closure::call(): Argument #1 ($newThis) must be of type object, int given\n",
+		},
+		{
+			name: "fromCallable",
+			code: `class ClosureTarget {
+    function greet($n) { return "hi $n"; }
+    static function shout($n) { return strtoupper($n); }
+    function __invoke($n) { return "invoked $n"; }
+}
+function closureTwice($x) { return $x * 2; }
+$t = new ClosureTarget();
+echo Closure::fromCallable('closureTwice')(4), " ", Closure::fromCallable('strrev')("ab"), "\n";
+echo Closure::fromCallable([$t, 'greet'])("a"), " ", Closure::fromCallable('ClosureTarget::shout')("b"), " ";
+echo Closure::fromCallable(['ClosureTarget', 'shout'])("c"), " ", Closure::fromCallable($t)("d"), "\n";
+try { Closure::fromCallable('closureMissing'); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+try { Closure::fromCallable([$t, 'missing']); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "8 ba\nhi a B C invoked d\n" +
+				"Closure::fromCallable(): Argument #1 ($callback) must be a valid callback, function \"closureMissing\" not found or invalid function name\n" +
+				"Closure::fromCallable(): Argument #1 ($callback) must be a valid callback, class ClosureTarget does not have a method \"missing\"\n",
+		},
+		{
+			name: "first-class callables",
+			code: `class ClosureFcc {
+    function greet($n) { return "hi $n"; }
+    static function make() { return static::name(...); }
+    static function name() { return static::class; }
+    function own() { return self::name(...); }
+}
+class ClosureFccChild extends ClosureFcc {}
+$o = new ClosureFcc();
+$len = strlen(...);
+$greet = $o->greet(...);
+$method = 'greet';
+$dynamic = $o->$method(...);
+$fn = 'strtoupper';
+echo $len("abc"), " ", $greet("x"), " ", $dynamic("y"), " ", $fn(...)("z"), " ", 'strrev'(...)("ab"), "\n";
+echo ClosureFcc::name(...)(), " ", ClosureFccChild::make()(), " ", $o->own()(), "\n";
+echo implode(",", array_map(strtoupper(...), ["a", "b"])), "\n";
+try { closureUndefined(...); } catch (Error $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "3 hi x hi y Z ba\nClosureFcc ClosureFccChild ClosureFcc\nA,B\n" +
+				"Call to undefined function closureUndefined()\n",
+		},
+		{
+			name: "closures are Closure objects",
+			code: `$f = function ($x) { return $x + 1; };
+echo gettype($f), " ", get_class($f), " ", $f->__invoke(1), "\n";
+var_dump($f instanceof Closure, is_object($f), is_callable($f), $f instanceof stdClass);
+try { $f->missing(); } catch (Error $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "object Closure 2\nbool(true)\nbool(true)\nbool(true)\nbool(false)\n" +
+				"Call to undefined method Closure::missing()\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := compileAndExecute(t, "<?php\n"+tt.code)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, output)
+		})
+	}
+}
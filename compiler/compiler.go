@@ -5,16 +5,25 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/wudi/hey/compiler/ast"
 	"github.com/wudi/hey/compiler/lexer"
 	"github.com/wudi/hey/opcodes"
+	"github.com/wudi/hey/optimizer"
 	"github.com/wudi/hey/registry"
 	"github.com/wudi/hey/values"
 )
 
+// traitMemberNames holds the names of the methods and properties a class
+// gets from its traits
+type traitMemberNames struct {
+	methods    []string
+	properties []string
+}
+
 // ForwardJump represents a jump that needs to be resolved later
 type ForwardJump struct {
 	instructionIndex int
@@ -38,10 +47,16 @@ type Compiler struct {
 	interfaces       map[string]*registry.Interface
 	traits           map[string]*registry.Trait
 	currentClass     *registry.Class // Current class being compiled
+	traitMembers     map[*registry.Class]*traitMemberNames // Members traits add to classes being compiled
 	currentFunction  *registry.Function // Current function being compiled
 	currentFile      string // Current file being compiled
 	currentNamespace string // Current namespace being compiled
+	useAliases       map[string]string // Lowercased alias -> class name imported by use statements
+	useFunctions     map[string]string // Lowercased alias -> function name imported by use function
 	currentPosition  lexer.Position // Current source position being compiled
+	optimize         bool // Run the bytecode optimizer on each compiled unit
+	optimizerStats   optimizer.OptimizationStats // Accumulated optimizer statistics
+	strictTypes      bool // declare(strict_types=1) seen in the current file
 }
 
 // Scope represents a compilation scope (function, block, etc.)
@@ -91,6 +106,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 	if len(c.instructions) == 0 || c.instructions[len(c.instructions)-1].Opcode != opcodes.OP_RETURN {
 		c.emit(opcodes.OP_RETURN, opcodes.IS_CONST, c.addConstant(values.NewNull()), 0, 0, 0, 0)
 	}
+	c.optimizeCurrentUnit()
 
 	c.popScope()
 	return nil
@@ -229,41 +245,41 @@ func (c *Compiler) compileNode(node ast.Node) error {
 
 	// Statements
 	case *ast.ExpressionStatement:
-		return c.compileExpressionStatement(n)
+		return c.releasingTemps(func() error { return c.compileExpressionStatement(n) })
 	case *ast.EchoStatement:
-		return c.compileEcho(n)
+		return c.releasingTemps(func() error { return c.compileEcho(n) })
 	case *ast.PrintStatement:
-		return c.compilePrintStatement(n)
+		return c.releasingTemps(func() error { return c.compilePrintStatement(n) })
 	case *ast.ReturnStatement:
-		return c.compileReturn(n)
+		return c.releasingTemps(func() error { return c.compileReturn(n) })
 	case *ast.IfStatement:
-		return c.compileIf(n)
+		return c.releasingTemps(func() error { return c.compileIf(n) })
 	case *ast.WhileStatement:
-		return c.compileWhile(n)
+		return c.releasingTemps(func() error { return c.compileWhile(n) })
 	case *ast.ForStatement:
-		return c.compileFor(n)
+		return c.releasingTemps(func() error { return c.compileFor(n) })
 	case *ast.ForeachStatement:
-		return c.compileForeach(n)
+		return c.releasingTemps(func() error { return c.compileForeach(n) })
 	case *ast.SwitchStatement:
-		return c.compileSwitch(n)
+		return c.releasingTemps(func() error { return c.compileSwitch(n) })
 	case *ast.BreakStatement:
 		return c.compileBreak(n)
 	case *ast.ContinueStatement:
 		return c.compileContinue(n)
 	case *ast.TryStatement:
-		return c.compileTry(n)
+		return c.releasingTemps(func() error { return c.compileTry(n) })
 	case *ast.ThrowStatement:
-		return c.compileThrow(n)
+		return c.releasingTemps(func() error { return c.compileThrow(n) })
 	case *ast.BlockStatement:
 		return c.compileBlock(n)
 	case *ast.GlobalStatement:
 		return c.compileGlobalStatement(n)
 	case *ast.StaticStatement:
-		return c.compileStaticStatement(n)
+		return c.releasingTemps(func() error { return c.compileStaticStatement(n) })
 	case *ast.UnsetStatement:
-		return c.compileUnsetStatement(n)
+		return c.releasingTemps(func() error { return c.compileUnsetStatement(n) })
 	case *ast.DoWhileStatement:
-		return c.compileDoWhileStatement(n)
+		return c.releasingTemps(func() error { return c.compileDoWhileStatement(n) })
 	case *ast.GotoStatement:
 		return c.compileGotoStatement(n)
 	case *ast.LabelStatement:
@@ -277,13 +293,13 @@ func (c *Compiler) compileNode(node ast.Node) error {
 	case *ast.UseStatement:
 		return c.compileUseStatement(n)
 	case *ast.AlternativeIfStatement:
-		return c.compileAlternativeIfStatement(n)
+		return c.releasingTemps(func() error { return c.compileAlternativeIfStatement(n) })
 	case *ast.AlternativeWhileStatement:
-		return c.compileAlternativeWhileStatement(n)
+		return c.releasingTemps(func() error { return c.compileAlternativeWhileStatement(n) })
 	case *ast.AlternativeForStatement:
-		return c.compileAlternativeForStatement(n)
+		return c.releasingTemps(func() error { return c.compileAlternativeForStatement(n) })
 	case *ast.AlternativeForeachStatement:
-		return c.compileAlternativeForeachStatement(n)
+		return c.releasingTemps(func() error { return c.compileAlternativeForeachStatement(n) })
 
 	// Declarations
 	case *ast.FunctionDeclaration:
@@ -514,7 +530,7 @@ func (c *Compiler) compileStaticPropIncDec(expr *ast.UnaryExpression, staticProp
 	switch class := staticProp.Class.(type) {
 	case *ast.IdentifierNode:
 		// Static class name like MyClass::$prop
-		classOperand = c.addConstant(values.NewString(class.Name))
+		classOperand = c.addConstant(values.NewString(c.resolveClassName(class.Name)))
 		classOperandType = opcodes.IS_CONST
 	case *ast.Variable:
 		// Handle self::$prop, static::$prop, parent::$prop etc
@@ -827,7 +843,78 @@ func (c *Compiler) compileAssign(expr *ast.AssignmentExpression) error {
 	} else if propAccess, ok := expr.Left.(*ast.PropertyAccessExpression); ok {
 		// Handle property assignment: $obj->prop = value or $obj->prop += value
 		return c.compilePropertyAssignment(propAccess, valueResult, expr.Operator)
+	} else if staticProp, ok := expr.Left.(*ast.StaticPropertyAccessExpression); ok {
+		// Handle static property assignment: Class::$prop = value
+		return c.compileStaticPropertyAssignment(staticProp.Class, staticProp.Property, valueResult, expr.Operator)
+	} else if staticAccess, ok := expr.Left.(*ast.StaticAccessExpression); ok {
+		if _, isVar := staticAccess.Property.(*ast.Variable); isVar {
+			// Handle static property assignment: self::$prop = value
+			return c.compileStaticPropertyAssignment(staticAccess.Class, staticAccess.Property, valueResult, expr.Operator)
+		}
+	}
+	return nil
+}
+
+// compileStaticPropertyAssignment handles Class::$prop = value and compound
+// assignments like Class::$prop .= value. The value is written through
+// FETCH_STATIC_PROP_W, which stores its result operand in the property.
+func (c *Compiler) compileStaticPropertyAssignment(classExpr ast.Expression, propExpr ast.Expression, valueResult uint32, operator string) error {
+	var classOperandType opcodes.OpType
+	var classOperand uint32
+	switch class := classExpr.(type) {
+	case *ast.IdentifierNode:
+		classOperand = c.addConstant(values.NewString(c.resolveClassName(class.Name)))
+		classOperandType = opcodes.IS_CONST
+	case *ast.Variable:
+		if strings.HasPrefix(class.Name, "$") {
+			// $className::$prop
+			if err := c.compileNode(class); err != nil {
+				return err
+			}
+			classOperand = c.nextTemp - 1
+			classOperandType = opcodes.IS_TMP_VAR
+			break
+		}
+		// self::$prop, static::$prop and parent::$prop
+		classOperand = c.addConstant(values.NewString(class.Name))
+		classOperandType = opcodes.IS_CONST
+	default:
+		if err := c.compileNode(class); err != nil {
+			return fmt.Errorf("failed to compile class expression in static property assignment: %w", err)
+		}
+		classOperand = c.nextTemp - 1
+		classOperandType = opcodes.IS_TMP_VAR
+	}
+
+	var propOperandType opcodes.OpType
+	var propOperand uint32
+	if property, ok := propExpr.(*ast.Variable); ok {
+		propOperand = c.addConstant(values.NewString(strings.TrimPrefix(property.Name, "$")))
+		propOperandType = opcodes.IS_CONST
+	} else {
+		if err := c.compileNode(propExpr); err != nil {
+			return fmt.Errorf("failed to compile property expression in static property assignment: %w", err)
+		}
+		propOperand = c.nextTemp - 1
+		propOperandType = opcodes.IS_TMP_VAR
 	}
+
+	if operator != "=" {
+		currentVal := c.allocateTemp()
+		c.emit(opcodes.OP_FETCH_STATIC_PROP_R,
+			classOperandType, classOperand,
+			propOperandType, propOperand,
+			opcodes.IS_TMP_VAR, currentVal)
+		newVal := c.allocateTemp()
+		c.emit(c.getOpcodeForBinaryOperator(strings.TrimSuffix(operator, "=")),
+			opcodes.IS_TMP_VAR, currentVal, opcodes.IS_TMP_VAR, valueResult, opcodes.IS_TMP_VAR, newVal)
+		valueResult = newVal
+	}
+
+	c.emit(opcodes.OP_FETCH_STATIC_PROP_W,
+		classOperandType, classOperand,
+		propOperandType, propOperand,
+		opcodes.IS_TMP_VAR, valueResult)
 	return nil
 }
 
@@ -1184,7 +1271,7 @@ func (c *Compiler) compileIdentifier(expr *ast.IdentifierNode) error {
 		// For other identifiers, check if it's a registered constant
 		// PHP constants are case-sensitive (except true/false/null)
 		if registry.GlobalRegistry != nil {
-			if constDesc, ok := registry.GlobalRegistry.GetConstant(expr.Name); ok {
+			if constDesc, ok := registry.GlobalRegistry.GetConstant(strings.TrimPrefix(expr.Name, "\\")); ok {
 				// Found exact match - use the constant value
 				constant = c.addConstant(constDesc.Value)
 			} else {
@@ -1435,35 +1522,36 @@ func (c *Compiler) compileFunctionCall(expr *ast.CallExpression) error {
 		return c.compileStaticMethodCall(expr, staticAccess)
 	}
 
-	// Compile callee expression for regular function calls
-	prevTemp := c.nextTemp
-	err := c.compileNode(expr.Callee)
-	if err != nil {
-		return err
-	}
-
-
-	// Verify that compileNode allocated a temp variable
-	// If not (which shouldn't happen but can due to compiler bugs), allocate one explicitly
+	// A function named in the code is called by its resolved name
+	initOpcode := opcodes.OP_INIT_FCALL
 	var calleeResult uint32
-	if c.nextTemp > prevTemp {
-		// Normal case: compileNode allocated a temp
-		calleeResult = c.nextTemp - 1
-	} else {
-		// Defensive: No temp was allocated - this indicates a compiler bug
-		// Generate explicit temp allocation to prevent crash
-		fmt.Fprintf(os.Stderr, "[COMPILER BUG] compileFunctionCall: compileNode did not allocate temp!\n")
-		fmt.Fprintf(os.Stderr, "  prevTemp=%d, nextTemp=%d\n", prevTemp, c.nextTemp)
-		if ident, ok := expr.Callee.(*ast.IdentifierNode); ok {
-			fmt.Fprintf(os.Stderr, "  callee identifier=%s\n", ident.Name)
+	if ident, ok := expr.Callee.(*ast.IdentifierNode); ok {
+		name, fallback := c.resolveFunctionName(ident.Name)
+		if fallback {
+			initOpcode = opcodes.OP_INIT_FCALL_BY_NAME
 		}
 		calleeResult = c.allocateTemp()
-		// Emit a move to ensure the callee value is in the temp
-		// This is a workaround and shouldn't normally execute
-		if ident, ok := expr.Callee.(*ast.IdentifierNode); ok {
-			// For identifier callee, emit a constant load
-			nameConst := c.addConstant(values.NewString(ident.Name))
-			c.emit(opcodes.OP_QM_ASSIGN, opcodes.IS_CONST, nameConst, 0, 0, opcodes.IS_TMP_VAR, calleeResult)
+		c.emit(opcodes.OP_QM_ASSIGN, opcodes.IS_CONST, c.addConstant(values.NewString(name)), 0, 0, opcodes.IS_TMP_VAR, calleeResult)
+	} else {
+		// Compile callee expression for regular function calls
+		prevTemp := c.nextTemp
+		err := c.compileNode(expr.Callee)
+		if err != nil {
+			return err
+		}
+
+
+		// Verify that compileNode allocated a temp variable
+		// If not (which shouldn't happen but can due to compiler bugs), allocate one explicitly
+		if c.nextTemp > prevTemp {
+			// Normal case: compileNode allocated a temp
+			calleeResult = c.nextTemp - 1
+		} else {
+			// Defensive: No temp was allocated - this indicates a compiler bug
+			// Generate explicit temp allocation to prevent crash
+			fmt.Fprintf(os.Stderr, "[COMPILER BUG] compileFunctionCall: compileNode did not allocate temp!\n")
+			fmt.Fprintf(os.Stderr, "  prevTemp=%d, nextTemp=%d\n", prevTemp, c.nextTemp)
+			calleeResult = c.allocateTemp()
 		}
 	}
 	// Use the temp that was allocated
@@ -1477,7 +1565,7 @@ func (c *Compiler) compileFunctionCall(expr *ast.CallExpression) error {
 		numArgs = 0
 	}
 
-	c.emit(opcodes.OP_INIT_FCALL, opcodes.IS_TMP_VAR, calleeResult, opcodes.IS_CONST, c.addConstant(values.NewInt(int64(numArgs))), 0, 0)
+	c.emit(initOpcode, opcodes.IS_TMP_VAR, calleeResult, opcodes.IS_CONST, c.addConstant(values.NewInt(int64(numArgs))), 0, 0)
 
 	// Compile and send arguments
 	if expr.Arguments != nil {
@@ -1552,7 +1640,7 @@ func (c *Compiler) compileStaticMethodCall(callExpr *ast.CallExpression, staticA
 	var className string
 	switch class := staticAccess.Class.(type) {
 	case *ast.IdentifierNode:
-		className = class.Name
+		className = c.resolveClassName(class.Name)
 	case *ast.Variable:
 		// Handle parent::, self::, static::
 		className = class.Name
@@ -1756,6 +1844,48 @@ func (c *Compiler) compileExpressionStatement(stmt *ast.ExpressionStatement) err
 	return c.compileNode(stmt.Expression)
 }
 
+// releasingTemps compiles a statement with compile and then releases every
+// temporary it used, so that values held only by the statement, such as
+// conditions and call arguments, do not outlive it.
+func (c *Compiler) releasingTemps(compile func() error) error {
+	first, start := c.nextTemp, len(c.instructions)
+	if err := compile(); err != nil {
+		return err
+	}
+	c.freeTemps(first, start)
+	return nil
+}
+
+// freeTemps releases the temporaries a statement used once it is done with
+// them. Temporaries are allocated in order, so they are the ones from first
+// up that the statement's instructions, from start on, refer to.
+func (c *Compiler) freeTemps(first uint32, start int) {
+	if end := c.tempsEnd(first, start); end > first {
+		c.emit(opcodes.OP_FREE, opcodes.IS_UNUSED, first, opcodes.IS_UNUSED, end, opcodes.IS_UNUSED, 0)
+	}
+}
+
+// tempsEnd returns the slot after the last temporary from first up that the
+// instructions from start on refer to.
+func (c *Compiler) tempsEnd(first uint32, start int) uint32 {
+	end := first
+	for _, inst := range c.instructions[start:] {
+		for _, operand := range []struct {
+			typ  opcodes.OpType
+			slot uint32
+		}{
+			{opcodes.DecodeOpType1(inst.OpType1), inst.Op1},
+			{opcodes.DecodeOpType2(inst.OpType1), inst.Op2},
+			{opcodes.DecodeResultType(inst.OpType2), inst.Result},
+		} {
+			if operand.typ == opcodes.IS_TMP_VAR && operand.slot >= end {
+				end = operand.slot + 1
+			}
+		}
+	}
+	return end
+}
+
 func (c *Compiler) compileEcho(stmt *ast.EchoStatement) error {
 	if stmt.Arguments != nil {
 		for _, expr := range stmt.Arguments.Arguments {
@@ -1819,6 +1949,7 @@ func (c *Compiler) compileReturn(stmt *ast.ReturnStatement) error {
 
 func (c *Compiler) compileIf(stmt *ast.IfStatement) error {
 	// Compile condition
+	first, start := c.nextTemp, len(c.instructions)
 	err := c.compileNode(stmt.Test)
 	if err != nil {
 		return err
@@ -1832,6 +1963,7 @@ func (c *Compiler) compileIf(stmt *ast.IfStatement) error {
 
 	// Jump to else if condition is false
 	c.emitJumpZ(opcodes.IS_TMP_VAR, condResult, elseLabel)
+	c.freeTemps(first, start)
 
 	// Compile consequence
 	for _, s := range stmt.Consequent {
@@ -1847,6 +1979,7 @@ func (c *Compiler) compileIf(stmt *ast.IfStatement) error {
 	// Else branch
 	c.placeLabel(elseLabel)
 	if len(stmt.Alternate) > 0 {
+		c.freeTemps(first, start)
 		for _, s := range stmt.Alternate {
 			err = c.compileNode(s)
 			if err != nil {
@@ -1876,6 +2009,7 @@ func (c *Compiler) compileWhile(stmt *ast.WhileStatement) error {
 	c.placeLabel(startLabel)
 
 	// Compile condition
+	first, start := c.nextTemp, len(c.instructions)
 	err := c.compileNode(stmt.Test)
 	if err != nil {
 		return err
@@ -1885,6 +2019,7 @@ func (c *Compiler) compileWhile(stmt *ast.WhileStatement) error {
 
 	// Jump to end if condition is false
 	c.emitJumpZ(opcodes.IS_TMP_VAR, condResult, endLabel)
+	c.freeTemps(first, start)
 
 	// Compile body
 	for _, s := range stmt.Body {
@@ -2412,6 +2547,7 @@ func (c *Compiler) compileMatch(expr *ast.MatchExpression) error {
 func (c *Compiler) compileNew(expr *ast.NewExpression) error {
 	// Get the class name - for now we only support simple class names
 	var className string
+	var arguments []ast.Expression
 	switch class := expr.Class.(type) {
 	case *ast.CallExpression:
 		// new Exception("message") - CallExpression with constructor arguments
@@ -2420,41 +2556,9 @@ func (c *Compiler) compileNew(expr *ast.NewExpression) error {
 		} else {
 			return fmt.Errorf("unsupported class expression in new")
 		}
-
-		// Create object first
-		resolvedClassName := c.resolveClassName(className)
-		classConstant := c.addConstant(values.NewString(resolvedClassName))
-		result := c.allocateTemp()
-		c.emit(opcodes.OP_NEW, opcodes.IS_CONST, classConstant, 0, 0, opcodes.IS_TMP_VAR, result)
-
-		// Call constructor if arguments are provided
-		if class.Arguments != nil && len(class.Arguments.Arguments) > 0 {
-			// Setup method call to __construct
-			methodName := c.addConstant(values.NewString("__construct"))
-			c.emit(opcodes.OP_INIT_METHOD_CALL, opcodes.IS_TMP_VAR, result, opcodes.IS_CONST, methodName, 0, 0)
-
-			// Compile and send constructor arguments
-			for _, arg := range class.Arguments.Arguments {
-				err := c.compileNode(arg)
-				if err != nil {
-					return err
-				}
-				argResult := c.nextTemp - 1
-				c.emit(opcodes.OP_SEND_VAL, opcodes.IS_UNUSED, 0, opcodes.IS_TMP_VAR, argResult, 0, 0)
-			}
-
-			// Execute constructor call (store result in separate temp to avoid overwriting object)
-			constructorResult := c.allocateTemp()
-			c.emit(opcodes.OP_DO_FCALL, opcodes.IS_TMP_VAR, constructorResult, 0, 0, 0, 0)
-
-			// Constructor calls in PHP don't return anything useful - keep the original object
-			// Copy the object back to be the final result of the expression
-			finalResult := c.allocateTemp()
-			c.emit(opcodes.OP_QM_ASSIGN, opcodes.IS_TMP_VAR, result, 0, 0, opcodes.IS_TMP_VAR, finalResult)
+		if class.Arguments != nil {
+			arguments = class.Arguments.Arguments
 		}
-
-		return nil
-
 	case *ast.IdentifierNode:
 		// new Exception - simple class instantiation
 		className = class.Name
@@ -2468,15 +2572,102 @@ func (c *Compiler) compileNew(expr *ast.NewExpression) error {
 	result := c.allocateTemp()
 	c.emit(opcodes.OP_NEW, opcodes.IS_CONST, classConstant, 0, 0, opcodes.IS_TMP_VAR, result)
 
-	// Always call constructor, even for parameterless instantiation
+	// As in PHP, the arguments are not evaluated without a constructor
+	if !c.needsConstructorCall(resolvedClassName) {
+		return nil
+	}
+
+	// Setup method call to __construct
 	methodName := c.addConstant(values.NewString("__construct"))
 	c.emit(opcodes.OP_INIT_METHOD_CALL, opcodes.IS_TMP_VAR, result, opcodes.IS_CONST, methodName, 0, 0)
+
+	// Compile and send constructor arguments
+	for _, arg := range arguments {
+		err := c.compileNode(arg)
+		if err != nil {
+			return err
+		}
+		argResult := c.nextTemp - 1
+		c.emit(opcodes.OP_SEND_VAL, opcodes.IS_UNUSED, 0, opcodes.IS_TMP_VAR, argResult, 0, 0)
+	}
+
+	// Execute constructor call (store result in separate temp to avoid overwriting object)
 	constructorResult := c.allocateTemp()
 	c.emit(opcodes.OP_DO_FCALL, opcodes.IS_TMP_VAR, constructorResult, 0, 0, 0, 0)
 
+	// Constructor calls in PHP don't return anything useful - keep the original object
+	// Copy the object back to be the final result of the expression
+	finalResult := c.allocateTemp()
+	c.emit(opcodes.OP_QM_ASSIGN, opcodes.IS_TMP_VAR, result, 0, 0, opcodes.IS_TMP_VAR, finalResult)
+
 	return nil
 }
 
+// appendMemberName adds name to a member declaration order unless a member
+// of that name was declared before
+func appendMemberName(order []string, name string) []string {
+	if slices.Contains(order, name) {
+		return order
+	}
+	return append(order, name)
+}
+
+// addTraitMember records a method or property that a trait adds to the
+// current class
+func (c *Compiler) addTraitMember(name string, property bool) {
+	if c.traitMembers == nil {
+		c.traitMembers = make(map[*registry.Class]*traitMemberNames)
+	}
+	bound := c.traitMembers[c.currentClass]
+	if bound == nil {
+		bound = &traitMemberNames{}
+		c.traitMembers[c.currentClass] = bound
+	}
+	if property {
+		bound.properties = appendMemberName(bound.properties, name)
+	} else {
+		bound.methods = appendMemberName(bound.methods, name)
+	}
+}
+
+// bindTraitMemberOrder puts the members that traits add to class after its
+// own, as PHP binds traits once the class body is complete
+func (c *Compiler) bindTraitMemberOrder(class *registry.Class) {
+	bound := c.traitMembers[class]
+	if bound == nil {
+		return
+	}
+	delete(c.traitMembers, class)
+	for _, name := range bound.methods {
+		class.MethodOrder = appendMemberName(class.MethodOrder, name)
+	}
+	for _, name := range bound.properties {
+		class.PropertyOrder = appendMemberName(class.PropertyOrder, name)
+	}
+}
+
+// needsConstructorCall reports whether new className has to call a
+// constructor. Only a class declared earlier in the file, along with all its
+// parents, is known to have none.
+func (c *Compiler) needsConstructorCall(className string) bool {
+	for depth := 0; depth < 64; depth++ {
+		class, ok := c.classes[className]
+		if !ok {
+			return true
+		}
+		for name := range class.Methods {
+			if strings.EqualFold(name, "__construct") {
+				return true
+			}
+		}
+		if class.Parent == "" {
+			return false
+		}
+		className = class.Parent
+	}
+	return true
+}
+
 func (c *Compiler) compileFor(stmt *ast.ForStatement) error {
 	// Create labels for the loop
 	testLabel := c.generateLabel()
@@ -2503,6 +2694,7 @@ func (c *Compiler) compileFor(stmt *ast.ForStatement) error {
 
 	// Compile test condition (if exists)
 	if stmt.Test != nil {
+		first, start := c.nextTemp, len(c.instructions)
 		err := c.compileNode(stmt.Test)
 		if err != nil {
 			return err
@@ -2511,6 +2703,7 @@ func (c *Compiler) compileFor(stmt *ast.ForStatement) error {
 		condResult := c.allocateTemp()
 		c.emitMove(condResult)
 		c.emitJumpZ(opcodes.IS_TMP_VAR, condResult, endLabel)
+		c.freeTemps(first, start)
 	}
 
 	// Body label - start of loop body
@@ -2638,6 +2831,7 @@ func (c *Compiler) compileForeach(stmt *ast.ForeachStatement) error {
 
 func (c *Compiler) compileSwitch(stmt *ast.SwitchStatement) error {
 	// Compile the discriminant (the switch expression)
+	first, start := c.nextTemp, len(c.instructions)
 	err := c.compileNode(stmt.Discriminant)
 	if err != nil {
 		return err
@@ -2693,9 +2887,11 @@ func (c *Compiler) compileSwitch(stmt *ast.SwitchStatement) error {
 		c.emitJump(opcodes.OP_JMP, opcodes.IS_CONST, 0, endLabel)
 	}
 
-	// Emit case bodies
+	// Emit case bodies, releasing the discriminant and the comparisons
+	// once a case is entered
 	for i, switchCase := range stmt.Cases {
 		c.placeLabel(caseLabels[i])
+		c.freeTemps(first, start)
 
 		// Compile case body
 		for _, stmt := range switchCase.Body {
@@ -2960,6 +3156,10 @@ func (c *Compiler) compileFunctionDeclaration(decl *ast.FunctionDeclaration) err
 		return fmt.Errorf("invalid function name type")
 	}
 	funcName := nameNode.Name
+	if c.currentClass == nil {
+		// Functions belong to the namespace they are declared in
+		funcName = c.buildFullyQualifiedName(funcName)
+	}
 
 	// Check if function already exists
 	// For class methods, check within the current class; for global functions, check globally
@@ -2984,7 +3184,20 @@ func (c *Compiler) compileFunctionDeclaration(decl *ast.FunctionDeclaration) err
 		IsVariadic:        false,
 		IsGenerator:       false,
 		IsAbstract:        decl.IsAbstract,
+		IsStatic:          decl.IsStatic,
+		IsFinal:           decl.IsFinal,
 		ReturnsByReference: decl.ByReference,
+		StrictTypes:       c.strictTypes,
+		DocComment:        decl.DocComment,
+	}
+	if decl.ReturnType != nil {
+		function.ReturnType = c.typeHintName(decl.ReturnType)
+	}
+	if c.currentClass != nil {
+		function.Visibility = decl.Visibility
+		if function.Visibility == "" {
+			function.Visibility = "public"
+		}
 	}
 
 	// Set current function for generator detection
@@ -3009,18 +3222,19 @@ func (c *Compiler) compileFunctionDeclaration(decl *ast.FunctionDeclaration) err
 				Name:        paramName,
 				IsReference: param.ByReference,
 				HasDefault:  param.DefaultValue != nil,
+				IsPromoted:  c.currentClass != nil && funcName == "__construct" && param.Visibility != "",
 			}
 
 			// Handle parameter type
 			if param.Type != nil {
-				compilerParam.Type = param.Type.String()
+				compilerParam.Type = c.typeHintName(param.Type)
 			}
 
 			// Handle default value
 			if param.DefaultValue != nil {
 				compilerParam.HasDefault = true
 				// Evaluate the default value expression at compile time
-				defaultValue := c.evaluateConstantExpression(param.DefaultValue)
+				defaultValue := c.evaluateStaticExpression(param.DefaultValue)
 				if defaultValue != nil {
 					compilerParam.DefaultValue = defaultValue
 				} else {
@@ -3115,6 +3329,7 @@ func (c *Compiler) compileFunctionDeclaration(decl *ast.FunctionDeclaration) err
 	}
 
 	// Store compiled function
+	c.optimizeCurrentUnit()
 	function.Instructions = c.instructions
 	function.Constants = c.constants
 
@@ -3143,6 +3358,7 @@ func (c *Compiler) compileFunctionDeclaration(decl *ast.FunctionDeclaration) err
 	if c.currentClass != nil {
 		// Store as a class method
 		c.currentClass.Methods[funcName] = function
+		c.currentClass.MethodOrder = appendMemberName(c.currentClass.MethodOrder, funcName)
 
 		// Also register in unified registry if available
 		if registry.GlobalRegistry != nil {
@@ -3184,10 +3400,10 @@ func (c *Compiler) compileFunctionDeclaration(decl *ast.FunctionDeclaration) err
 			// Register method in class
 			classDesc.Methods[funcName] = &registry.MethodDescriptor{
 				Name:           funcName,
-				Visibility:     "public", // TODO: Parse actual visibility from decl
-				IsStatic:       false,    // TODO: Parse actual static modifier from decl
-				IsAbstract:     false,
-				IsFinal:        false,
+				Visibility:     function.Visibility,
+				IsStatic:       function.IsStatic,
+				IsAbstract:     function.IsAbstract,
+				IsFinal:        function.IsFinal,
 				Parameters:     registryParams,
 				Implementation: methodImpl,
 				IsVariadic:     function.IsVariadic,
@@ -3225,6 +3441,12 @@ func (c *Compiler) compileAnonymousFunction(expr *ast.AnonymousFunctionExpressio
 		Parameters:   make([]*registry.Parameter, 0),
 		IsVariadic:   false,
 		IsGenerator:  false,
+		IsAnonymous:  true,
+		IsStatic:     expr.Static,
+		StrictTypes:  c.strictTypes,
+	}
+	if expr.ReturnType != nil {
+		function.ReturnType = c.typeHintName(expr.ReturnType)
 	}
 
 	// Compile parameters
@@ -3246,12 +3468,16 @@ func (c *Compiler) compileAnonymousFunction(expr *ast.AnonymousFunctionExpressio
 
 			// Handle parameter type
 			if param.Type != nil {
-				compilerParam.Type = param.Type.String()
+				compilerParam.Type = c.typeHintName(param.Type)
 			}
 
 			// Handle default value
 			if param.DefaultValue != nil {
 				compilerParam.HasDefault = true
+				compilerParam.DefaultValue = c.evaluateStaticExpression(param.DefaultValue)
+				if compilerParam.DefaultValue == nil {
+					compilerParam.DefaultValue = values.NewNull()
+				}
 			}
 
 			// Check for variadic
@@ -3309,6 +3535,7 @@ func (c *Compiler) compileAnonymousFunction(expr *ast.AnonymousFunctionExpressio
 	}
 
 	// Store compiled function
+	c.optimizeCurrentUnit()
 	function.Instructions = c.instructions
 	function.Constants = c.constants
 
@@ -3355,18 +3582,13 @@ func (c *Compiler) compileAnonymousFunction(expr *ast.AnonymousFunctionExpressio
 			} else if refExpr, ok := useVar.(*ast.ReferenceExpression); ok {
 				// Reference variable binding (&$var)
 				if varExpr, ok := refExpr.Expression.(*ast.Variable); ok {
-					// Get the variable value from current scope
-					err := c.compileNode(varExpr)
-					if err != nil {
-						return fmt.Errorf("error compiling reference use variable %s: %v", varExpr.Name, err)
-					}
-					varResult := c.allocateTemp()
-					c.emitMove(varResult)
+					// The closure shares the variable itself, so pass its slot
+					varSlot := c.getOrCreateVariable(varExpr.Name)
 
 					// Bind the variable to the closure with reference flag
 					varNameConstant := c.addConstant(values.NewString(varExpr.Name))
-					opType1, opType2 := opcodes.EncodeOpTypesWithFlags(opcodes.IS_TMP_VAR, opcodes.IS_CONST, opcodes.IS_TMP_VAR, opcodes.EXT_FLAG_REFERENCE)
-					c.emitWithTypes(opcodes.OP_BIND_USE_VAR, opType1, opType2, closureResult, varNameConstant, varResult)
+					opType1, opType2 := opcodes.EncodeOpTypesWithFlags(opcodes.IS_TMP_VAR, opcodes.IS_CONST, opcodes.IS_CV, opcodes.EXT_FLAG_REFERENCE)
+					c.emitWithTypes(opcodes.OP_BIND_USE_VAR, opType1, opType2, closureResult, varNameConstant, varSlot)
 				}
 			}
 		}
@@ -3467,6 +3689,7 @@ func (c *Compiler) compileClassDeclaration(decl *ast.AnonymousClass) error {
 	}
 
 	// Store compiled class
+	c.bindTraitMemberOrder(class)
 	c.classes[className] = class
 	c.currentClass = oldCurrentClass
 
@@ -3537,11 +3760,12 @@ func (c *Compiler) compilePropertyDeclaration(decl *ast.PropertyDeclaration) err
 		Visibility: decl.Visibility, // public, private, protected
 		IsStatic:   decl.Static,
 		IsReadonly: decl.ReadOnly,
+		DocComment: decl.DocComment,
 	}
 
 	// Handle type hint
 	if decl.Type != nil {
-		property.Type = decl.Type.String()
+		property.Type = c.typeHintName(decl.Type)
 	}
 
 	// Handle default value
@@ -3589,6 +3813,7 @@ func (c *Compiler) compilePropertyDeclaration(decl *ast.PropertyDeclaration) err
 
 	// Add property to current class
 	c.currentClass.Properties[propName] = property
+	c.currentClass.PropertyOrder = appendMemberName(c.currentClass.PropertyOrder, propName)
 
 	// Emit property declaration instruction
 	classNameConstant := c.addConstant(values.NewString(c.currentClass.Name))
@@ -3685,6 +3910,7 @@ func (c *Compiler) compileClassConstant(decl *ast.ClassConstantDeclaration) erro
 
 		// Add constant to current class
 		c.currentClass.Constants[constName] = classConstant
+		c.currentClass.ConstantOrder = appendMemberName(c.currentClass.ConstantOrder, constName)
 
 		// Emit opcode to register the class constant in the runtime registry
 		classNameConst := c.addConstant(values.NewString(c.currentClass.Name))
@@ -3734,17 +3960,14 @@ func (c *Compiler) evaluateClassConstantExpression(expr ast.Expression) (*values
 					}
 					// Convert key to appropriate type for array indexing
 					switch keyVal.Type {
-					case values.TypeString:
-						keyValue = keyVal.Data.(string)
-					case values.TypeInt:
-						keyValue = keyVal.Data.(int64)
+					case values.TypeString, values.TypeInt, values.TypeFloat, values.TypeBool, values.TypeNull:
+						keyValue = values.ArrayKey(keyVal)
 					default:
 						return nil, fmt.Errorf("unsupported array key type: %s", keyVal.Type)
 					}
 				} else {
 					// Auto-index
-					keyValue = arrayData.NextIndex
-					arrayData.NextIndex++
+					keyValue = arrayData.NextIndex()
 				}
 
 				// Evaluate the value
@@ -3754,7 +3977,7 @@ func (c *Compiler) evaluateClassConstantExpression(expr ast.Expression) (*values
 				}
 
 				// Set the element
-				arrayData.Elements[keyValue] = valueVal
+				arrayData.Set(keyValue, valueVal)
 			} else {
 				// Direct element (not ArrayElementExpression) - use auto-indexing
 				valueVal, err := c.evaluateClassConstantExpression(element)
@@ -3762,8 +3985,7 @@ func (c *Compiler) evaluateClassConstantExpression(expr ast.Expression) (*values
 					return nil, fmt.Errorf("failed to evaluate array element: %w", err)
 				}
 
-				arrayData.Elements[arrayData.NextIndex] = valueVal
-				arrayData.NextIndex++
+				arrayData.Append(valueVal)
 			}
 		}
 
@@ -3778,6 +4000,11 @@ func (c *Compiler) evaluateClassConstantExpression(expr ast.Expression) (*values
 		case "null":
 			return values.NewNull(), nil
 		default:
+			if registry.GlobalRegistry != nil {
+				if constDesc, ok := registry.GlobalRegistry.GetConstant(val.Name); ok && constDesc.Value != nil {
+					return constDesc.Value, nil
+				}
+			}
 			return nil, fmt.Errorf("undefined constant reference: %s", val.Name)
 		}
 
@@ -4014,7 +4241,7 @@ func (c *Compiler) isTruthy(value *values.Value) bool {
 		return false
 	case values.TypeArray:
 		arr := value.Data.(*values.Array)
-		return len(arr.Elements) > 0
+		return arr.Len() > 0
 	default:
 		return true // Objects and other types are truthy
 	}
@@ -4037,6 +4264,9 @@ func (c *Compiler) evaluateClassConstantAccess(expr *ast.ClassConstantAccessExpr
 			if c.currentClass == nil {
 				return nil, fmt.Errorf("cannot use self:: outside of class context")
 			}
+			if strings.EqualFold(constName.Name, "class") {
+				return values.NewString(c.currentClass.Name), nil
+			}
 			targetClass = c.currentClass
 		case "parent":
 			if c.currentClass == nil {
@@ -4047,11 +4277,24 @@ func (c *Compiler) evaluateClassConstantAccess(expr *ast.ClassConstantAccessExpr
 			return nil, fmt.Errorf("parent:: constant access not yet implemented in constant expressions")
 		default:
 			// Named class constant access
-			className := classExpr.Name
-			// Look up the class - for now we'll only support constants from the current class
-			if c.currentClass != nil && c.currentClass.Name == className {
+			className := c.resolveClassName(classExpr.Name)
+			if strings.EqualFold(constName.Name, "class") {
+				return values.NewString(className), nil
+			}
+			// Look up the class among the current, compiled and builtin classes
+			if c.currentClass != nil && strings.EqualFold(c.currentClass.Name, className) {
 				targetClass = c.currentClass
-			} else {
+			} else if class, exists := c.classes[className]; exists {
+				targetClass = class
+			} else if registry.GlobalRegistry != nil {
+				if classDesc, err := registry.GlobalRegistry.GetClass(className); err == nil && classDesc != nil {
+					if constDesc, found := classDesc.Constants[constName.Name]; found && constDesc != nil {
+						return constDesc.Value, nil
+					}
+					return nil, fmt.Errorf("undefined class constant %s::%s", classDesc.Name, constName.Name)
+				}
+			}
+			if targetClass == nil {
 				return nil, fmt.Errorf("class constant access to external class %s not yet implemented in constant expressions", className)
 			}
 		}
@@ -4280,6 +4523,7 @@ func (c *Compiler) compileRegularClassDeclaration(decl *ast.ClassExpression) err
 		Constants:  make(map[string]*registry.ClassConstant),
 		IsAbstract: decl.Abstract,
 		IsFinal:    decl.Final,
+		DocComment: decl.DocComment,
 	}
 
 	// Handle extends
@@ -4342,6 +4586,7 @@ func (c *Compiler) compileRegularClassDeclaration(decl *ast.ClassExpression) err
 	}
 
 	// Store compiled class
+	c.bindTraitMemberOrder(class)
 	c.classes[fullyQualifiedName] = class
 	c.currentClass = oldCurrentClass
 
@@ -4362,7 +4607,7 @@ func (c *Compiler) compileClassConstantAccess(expr *ast.ClassConstantAccessExpre
 	var isLateStaticBinding bool
 	switch class := expr.Class.(type) {
 	case *ast.IdentifierNode:
-		className = class.Name
+		className = c.resolveClassName(class.Name)
 		isLateStaticBinding = (className == "static")
 	case *ast.Variable:
 		// Handle self::, static::, parent:: etc
@@ -4416,7 +4661,7 @@ func (c *Compiler) compileStaticPropertyAccess(expr *ast.StaticPropertyAccessExp
 	switch class := expr.Class.(type) {
 	case *ast.IdentifierNode:
 		// Static class name like MyClass::$prop
-		classOperand = c.addConstant(values.NewString(class.Name))
+		classOperand = c.addConstant(values.NewString(c.resolveClassName(class.Name)))
 		classOperandType = opcodes.IS_CONST
 	case *ast.Variable:
 		// Handle self::$prop, static::$prop, parent::$prop etc
@@ -4478,7 +4723,7 @@ func (c *Compiler) compileStaticAccess(expr *ast.StaticAccessExpression) error {
 	switch class := expr.Class.(type) {
 	case *ast.IdentifierNode:
 		// Static class name like MyClass:: or static::
-		className := class.Name
+		className := c.resolveClassName(class.Name)
 		classOperand = c.addConstant(values.NewString(className))
 		classOperandType = opcodes.IS_CONST
 		isLateStaticBinding = (className == "static")
@@ -4706,6 +4951,21 @@ func (c *Compiler) compileInstanceofExpression(expr *ast.InstanceofExpression) e
 
 	leftOperand := c.nextTemp - 1
 
+	// A class name is resolved against the namespace and imports
+	if ident, ok := expr.Right.(*ast.IdentifierNode); ok {
+		switch strings.ToLower(ident.Name) {
+		case "self", "static", "parent":
+		default:
+			classConstant := c.addConstant(values.NewString(c.resolveClassName(ident.Name)))
+			result := c.allocateTemp()
+			c.emit(opcodes.OP_INSTANCEOF,
+				opcodes.IS_TMP_VAR, leftOperand,
+				opcodes.IS_CONST, classConstant,
+				opcodes.IS_TMP_VAR, result)
+			return nil
+		}
+	}
+
 	// Compile the right expression (class)
 	if err := c.compileNode(expr.Right); err != nil {
 		return err
@@ -5664,19 +5924,18 @@ func (c *Compiler) compileReferenceAssignment(left ast.Expression, right ast.Exp
 		}
 		arrayTemp := c.nextTemp - 1
 
+		// No index appends to the array
+		indexType, indexTemp := opcodes.IS_UNUSED, uint32(0)
 		if leftExpr.Index != nil {
 			if err := c.compileNode(*leftExpr.Index); err != nil {
 				return err
 			}
-		} else {
-			// No index provided, append to array
-			c.emit(opcodes.OP_QM_ASSIGN, opcodes.IS_CONST, c.addConstant(values.NewNull()), opcodes.IS_UNUSED, 0, opcodes.IS_TMP_VAR, c.allocateTemp())
+			indexType, indexTemp = opcodes.IS_TMP_VAR, c.nextTemp-1
 		}
-		indexTemp := c.nextTemp - 1
 
 		c.emit(opcodes.OP_ASSIGN_DIM_REF,
 			opcodes.IS_TMP_VAR, arrayTemp,     // Array
-			opcodes.IS_TMP_VAR, indexTemp,     // Index
+			indexType, indexTemp,              // Index
 			opcodes.IS_TMP_VAR, rightTemp)     // Source value
 
 	default:
@@ -5773,24 +6032,21 @@ func (c *Compiler) compileAssignRef(expr *ast.AssignRefExpression) error {
 		}
 		arrayTemp := c.nextTemp - 1
 
+		// No index appends to the array
+		indexType, indexTemp := opcodes.IS_UNUSED, uint32(0)
 		if left.Index != nil {
 			if err := c.compileNode(*left.Index); err != nil {
 				return err
 			}
-		} else {
-			// No index, use null to indicate append
-			nullConst := c.addConstant(values.NewNull())
-			tempSlot := c.allocateTemp()
-			c.emit(opcodes.OP_QM_ASSIGN, opcodes.IS_CONST, nullConst, opcodes.IS_UNUSED, 0, opcodes.IS_TMP_VAR, tempSlot)
+			indexType, indexTemp = opcodes.IS_TMP_VAR, c.nextTemp-1
 		}
-		indexTemp := c.nextTemp - 1
 
 		result := c.allocateTemp()
 
 		// Emit array element reference assignment
 		c.emit(opcodes.OP_ASSIGN_DIM_REF,
 			opcodes.IS_TMP_VAR, arrayTemp,
-			opcodes.IS_TMP_VAR, indexTemp,
+			indexType, indexTemp,
 			opcodes.IS_TMP_VAR, rightTemp)
 
 		c.emit(opcodes.OP_QM_ASSIGN,
@@ -5844,6 +6100,12 @@ func (c *Compiler) compileArrowFunctionExpression(expr *ast.ArrowFunctionExpress
 		Parameters:   make([]*registry.Parameter, 0),
 		IsVariadic:   false,
 		IsGenerator:  false,
+		IsAnonymous:  true,
+		IsStatic:     expr.Static,
+		StrictTypes:  c.strictTypes,
+	}
+	if expr.ReturnType != nil {
+		function.ReturnType = c.typeHintName(expr.ReturnType)
 	}
 
 	// Compile parameters
@@ -5864,12 +6126,16 @@ func (c *Compiler) compileArrowFunctionExpression(expr *ast.ArrowFunctionExpress
 
 			// Handle parameter type
 			if param.Type != nil {
-				compilerParam.Type = param.Type.String()
+				compilerParam.Type = c.typeHintName(param.Type)
 			}
 
 			// Handle default value
 			if param.DefaultValue != nil {
 				compilerParam.HasDefault = true
+				compilerParam.DefaultValue = c.evaluateStaticExpression(param.DefaultValue)
+				if compilerParam.DefaultValue == nil {
+					compilerParam.DefaultValue = values.NewNull()
+				}
 			}
 
 			// Check for variadic
@@ -5935,6 +6201,7 @@ func (c *Compiler) compileArrowFunctionExpression(expr *ast.ArrowFunctionExpress
 	}
 
 	// Store compiled function
+	c.optimizeCurrentUnit()
 	function.Instructions = c.instructions
 	function.Constants = c.constants
 
@@ -6001,7 +6268,14 @@ func (c *Compiler) compileFirstClassCallable(expr *ast.FirstClassCallable) error
 		return c.compileFirstClassStaticAccess(callable, result)
 
 	default:
-		return fmt.Errorf("unsupported first-class callable type: %T", callable)
+		// Any other callable value: $closure(...), 'strlen'(...), [$obj, 'm'](...)
+		if err := c.compileNode(callable); err != nil {
+			return err
+		}
+		callableResult := c.nextTemp - 1
+		result := c.allocateTemp()
+		c.emit(opcodes.OP_CREATE_FUNC_CALLABLE, opcodes.IS_TMP_VAR, callableResult, 0, 0, opcodes.IS_TMP_VAR, result)
+		return nil
 	}
 }
 
@@ -6021,19 +6295,21 @@ func (c *Compiler) compileFirstClassMethod(methodAccess *ast.PropertyAccessExpre
 	}
 	objectResult := c.nextTemp - 1
 
-	// Get method name
-	var methodName string
+	// Get method name, a constant unless it is dynamic as in $obj->$name(...)
+	methodType, methodOperand := opcodes.IS_CONST, uint32(0)
 	if prop, ok := methodAccess.Property.(*ast.IdentifierNode); ok {
-		methodName = prop.Name
+		methodOperand = c.addConstant(values.NewString(prop.Name))
 	} else {
-		return fmt.Errorf("invalid method name in first-class callable")
+		if err := c.compileNode(methodAccess.Property); err != nil {
+			return err
+		}
+		methodType, methodOperand = opcodes.IS_TMP_VAR, c.nextTemp-1
 	}
 
 	// Allocate the result temp AFTER all internal compilation is done
 	result := c.allocateTemp()
 
-	methodNameConstant := c.addConstant(values.NewString(methodName))
-	c.emit(opcodes.OP_CREATE_METHOD_CALLABLE, opcodes.IS_TMP_VAR, objectResult, opcodes.IS_CONST, methodNameConstant, opcodes.IS_TMP_VAR, result)
+	c.emit(opcodes.OP_CREATE_METHOD_CALLABLE, opcodes.IS_TMP_VAR, objectResult, methodType, methodOperand, opcodes.IS_TMP_VAR, result)
 	return nil
 }
 
@@ -6328,8 +6604,11 @@ func (c *Compiler) compileDoWhileStatement(stmt *ast.DoWhileStatement) error {
 	c.currentScope().breakLabel = endLabel
 	c.currentScope().continueLabel = startLabel
 
-	// Start of loop body
+	// Start of loop body, which first releases the condition of the
+	// previous iteration once its temporaries are known
 	c.placeLabel(startLabel)
+	freeIndex := len(c.instructions)
+	c.emit(opcodes.OP_FREE, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
 
 	// Compile body
 	if err := c.compileNode(stmt.Body); err != nil {
@@ -6337,6 +6616,7 @@ func (c *Compiler) compileDoWhileStatement(stmt *ast.DoWhileStatement) error {
 	}
 
 	// Compile condition
+	first, start := c.nextTemp, len(c.instructions)
 	if err := c.compileNode(stmt.Condition); err != nil {
 		return err
 	}
@@ -6347,6 +6627,9 @@ func (c *Compiler) compileDoWhileStatement(stmt *ast.DoWhileStatement) error {
 
 	// Jump back to start if condition is true
 	c.emitJumpNZ(opcodes.IS_TMP_VAR, condResult, startLabel)
+	c.instructions[freeIndex].Op1 = first
+	c.instructions[freeIndex].Op2 = c.tempsEnd(first, start)
+	c.freeTemps(first, start)
 
 	// End label
 	c.placeLabel(endLabel)
@@ -6430,6 +6713,9 @@ func (c *Compiler) compileDeclareStatement(stmt *ast.DeclareStatement) error {
 			case "strict_types":
 				// strict_types=1 enables strict type checking for the current file
 				// This affects how type declarations are enforced
+				if value := c.evaluateStaticExpression(assignment.Right); value != nil {
+					c.strictTypes = value.ToInt() == 1
+				}
 				c.emitDeclareDirective("strict_types", valueTemp)
 
 			case "ticks":
@@ -6513,6 +6799,9 @@ func (c *Compiler) compileNamespaceStatement(stmt *ast.NamespaceStatement) error
 		// Global namespace
 		c.currentNamespace = ""
 	}
+	// Imports only apply to the namespace they appear in
+	c.useAliases = nil
+	c.useFunctions = nil
 
 	// Compile namespace body if present
 	if stmt.Body != nil {
@@ -6542,13 +6831,106 @@ func (c *Compiler) resolveClassName(name string) string {
 	if strings.HasPrefix(name, "\\") {
 		return name[1:] // Remove leading backslash
 	}
+	// self, static and parent are resolved at runtime
+	switch strings.ToLower(name) {
+	case "self", "static", "parent":
+		return name
+	}
+	// A leading segment imported by a use statement is replaced by the
+	// imported name: with "use Foo\Bar;", Bar\Baz is Foo\Bar\Baz
+	first, rest, qualified := strings.Cut(name, "\\")
+	if imported, ok := c.useAliases[strings.ToLower(first)]; ok {
+		if qualified {
+			return imported + "\\" + rest
+		}
+		return imported
+	}
 	// Otherwise, make it relative to current namespace
 	return c.buildFullyQualifiedName(name)
 }
 
+// resolveFunctionName resolves the name of a called function against the
+// current namespace and use imports. An unqualified name in a namespace may
+// still name a global function, which is decided at runtime: fallback
+// reports that the call should fall back to the global function when the
+// namespaced one does not exist.
+func (c *Compiler) resolveFunctionName(name string) (resolved string, fallback bool) {
+	if strings.HasPrefix(name, "\\") {
+		return name[1:], false
+	}
+	if strings.Contains(name, "\\") {
+		return c.resolveClassName(name), false
+	}
+	if imported, ok := c.useFunctions[strings.ToLower(name)]; ok {
+		return imported, false
+	}
+	return c.buildFullyQualifiedName(name), c.currentNamespace != ""
+}
+
+// typeHintName renders a type declaration with class names resolved against
+// the current namespace and use imports. Intersections inside a union are
+// parenthesized, as in (A&B)|null.
+func (c *Compiler) typeHintName(th *ast.TypeHint) string {
+	var name string
+	switch {
+	case len(th.UnionTypes) > 0:
+		parts := make([]string, len(th.UnionTypes))
+		for i, t := range th.UnionTypes {
+			parts[i] = c.typeHintName(t)
+			if len(t.IntersectionTypes) > 0 {
+				parts[i] = "(" + parts[i] + ")"
+			}
+		}
+		name = strings.Join(parts, "|")
+	case len(th.IntersectionTypes) > 0:
+		parts := make([]string, len(th.IntersectionTypes))
+		for i, t := range th.IntersectionTypes {
+			parts[i] = c.typeHintName(t)
+		}
+		name = strings.Join(parts, "&")
+	case builtinTypeNames[strings.ToLower(th.Name)]:
+		name = th.Name
+	default:
+		name = c.resolveClassName(th.Name)
+	}
+	if th.Nullable {
+		name = "?" + name
+	}
+	return name
+}
+
+// builtinTypeNames are the type declarations that do not name a class
+var builtinTypeNames = map[string]bool{
+	"int": true, "float": true, "string": true, "bool": true, "array": true,
+	"object": true, "mixed": true, "callable": true, "iterable": true,
+	"void": true, "never": true, "null": true, "false": true, "true": true,
+	"self": true, "static": true, "parent": true,
+}
+
 func (c *Compiler) compileUseStatement(stmt *ast.UseStatement) error {
 	// Use statements are handled at compile time
 	// They affect name resolution but don't generate runtime code
+	for _, use := range stmt.Uses {
+		if use.Name == nil || (use.Type != "" && use.Type != "class" && use.Type != "function") {
+			continue
+		}
+		name := strings.TrimPrefix(strings.Join(use.Name.Parts, "\\"), "\\")
+		alias := use.Alias
+		if alias == "" {
+			alias = use.Name.Parts[len(use.Name.Parts)-1]
+		}
+		if use.Type == "function" {
+			if c.useFunctions == nil {
+				c.useFunctions = make(map[string]string)
+			}
+			c.useFunctions[strings.ToLower(alias)] = name
+			continue
+		}
+		if c.useAliases == nil {
+			c.useAliases = make(map[string]string)
+		}
+		c.useAliases[strings.ToLower(alias)] = name
+	}
 	return nil
 }
 
@@ -6557,6 +6939,7 @@ func (c *Compiler) compileAlternativeIfStatement(stmt *ast.AlternativeIfStatemen
 	// This is functionally identical to regular if statements
 
 	// Compile condition
+	first, start := c.nextTemp, len(c.instructions)
 	if err := c.compileNode(stmt.Condition); err != nil {
 		return err
 	}
@@ -6569,6 +6952,7 @@ func (c *Compiler) compileAlternativeIfStatement(stmt *ast.AlternativeIfStatemen
 	condResult := c.allocateTemp()
 	c.emitMove(condResult)
 	c.emitJumpZ(opcodes.IS_TMP_VAR, condResult, elseLabel)
+	c.freeTemps(first, start)
 
 	// Compile then block
 	for _, thenStmt := range stmt.Then {
@@ -6583,6 +6967,7 @@ func (c *Compiler) compileAlternativeIfStatement(stmt *ast.AlternativeIfStatemen
 	// Handle elseif clauses
 	for _, elseif := range stmt.ElseIfs {
 		c.placeLabel(elseLabel)
+		c.freeTemps(first, start)
 		elseLabel = c.generateLabel() // New label for next elseif/else
 
 		// Compile elseif condition
@@ -6593,6 +6978,7 @@ func (c *Compiler) compileAlternativeIfStatement(stmt *ast.AlternativeIfStatemen
 		condResult := c.allocateTemp()
 		c.emitMove(condResult)
 		c.emitJumpZ(opcodes.IS_TMP_VAR, condResult, elseLabel)
+		c.freeTemps(first, start)
 
 		// Compile elseif body
 		for _, elseifStmt := range elseif.Body {
@@ -6607,6 +6993,7 @@ func (c *Compiler) compileAlternativeIfStatement(stmt *ast.AlternativeIfStatemen
 	// Handle else clause
 	c.placeLabel(elseLabel)
 	if stmt.Else != nil {
+		c.freeTemps(first, start)
 		for _, elseStmt := range stmt.Else {
 			if err := c.compileNode(elseStmt); err != nil {
 				return err
@@ -6638,6 +7025,7 @@ func (c *Compiler) compileAlternativeWhileStatement(stmt *ast.AlternativeWhileSt
 	c.placeLabel(startLabel)
 
 	// Compile condition
+	first, start := c.nextTemp, len(c.instructions)
 	err := c.compileNode(stmt.Condition)
 	if err != nil {
 		return err
@@ -6647,6 +7035,7 @@ func (c *Compiler) compileAlternativeWhileStatement(stmt *ast.AlternativeWhileSt
 
 	// Jump to end if condition is false
 	c.emitJumpZ(opcodes.IS_TMP_VAR, condResult, endLabel)
+	c.freeTemps(first, start)
 
 	// Compile body
 	for _, bodyStmt := range stmt.Body {
@@ -6697,6 +7086,7 @@ func (c *Compiler) compileAlternativeForStatement(stmt *ast.AlternativeForStatem
 	// Compile condition expressions (all must be true)
 	if len(stmt.Condition) > 0 {
 		for _, cond := range stmt.Condition {
+			first, start := c.nextTemp, len(c.instructions)
 			if err := c.compileNode(cond); err != nil {
 				return err
 			}
@@ -6705,6 +7095,7 @@ func (c *Compiler) compileAlternativeForStatement(stmt *ast.AlternativeForStatem
 
 			// Jump to end if any condition is false
 			c.emitJumpZ(opcodes.IS_TMP_VAR, condResult, endLabel)
+			c.freeTemps(first, start)
 		}
 	}
 
@@ -6898,7 +7289,7 @@ func (c *Compiler) compileInterfaceDeclaration(decl *ast.InterfaceDeclaration) e
 				// Handle default value evaluation
 				if param.DefaultValue != nil {
 					// Evaluate the default value expression at compile time
-					defaultValue := c.evaluateConstantExpression(param.DefaultValue)
+					defaultValue := c.evaluateStaticExpression(param.DefaultValue)
 					if defaultValue != nil {
 						compilerParam.DefaultValue = defaultValue
 					} else {
@@ -6975,81 +7366,146 @@ func (c *Compiler) compileTraitDeclaration(decl *ast.TraitDeclaration) error {
 	return nil
 }
 
+// enumForbiddenMagicMethods lists the magic methods an enum may not declare
+var enumForbiddenMagicMethods = map[string]bool{
+	"__construct": true, "__destruct": true, "__clone": true,
+	"__get": true, "__set": true, "__isset": true, "__unset": true,
+	"__tostring": true, "__debuginfo": true, "__serialize": true, "__unserialize": true,
+	"__sleep": true, "__wakeup": true, "__set_state": true,
+}
+
 func (c *Compiler) compileEnumDeclaration(decl *ast.EnumDeclaration) error {
 	if decl.Name == nil {
 		return fmt.Errorf("enum declaration missing name")
 	}
 
 	enumName := decl.Name.Name
+	fullyQualifiedName := c.buildFullyQualifiedName(enumName)
 
 	// Check if enum already exists (enums are stored as classes in the VM)
-	if _, exists := c.classes[enumName]; exists {
-		return fmt.Errorf("enum %s already declared", enumName)
+	if _, exists := c.classes[fullyQualifiedName]; exists {
+		return fmt.Errorf("enum %s already declared", fullyQualifiedName)
+	}
+
+	backingType := ""
+	if decl.BackingType != nil {
+		backingType = strings.ToLower(decl.BackingType.Name)
+		if backingType != "int" && backingType != "string" {
+			return fmt.Errorf("enum backing type must be int or string, %s given", decl.BackingType.Name)
+		}
 	}
 
-	// Create enum as a special class
+	// Enums are final classes implementing UnitEnum, and BackedEnum when
+	// they have a backing type
 	enumClass := &registry.Class{
-		Name:       enumName,
-		Parent:     "",
-		Properties: make(map[string]*registry.Property),
-		Methods:    make(map[string]*registry.Function),
-		Constants:  make(map[string]*registry.ClassConstant),
-		IsAbstract: false,
-		IsFinal:    true, // Enums are final by default
+		Name:            fullyQualifiedName,
+		Parent:          "",
+		Interfaces:      []string{"UnitEnum"},
+		Properties:      make(map[string]*registry.Property),
+		Methods:         make(map[string]*registry.Function),
+		Constants:       make(map[string]*registry.ClassConstant),
+		IsAbstract:      false,
+		IsFinal:         true,
+		IsEnum:          true,
+		EnumBackingType: backingType,
+	}
+	if backingType != "" {
+		enumClass.Interfaces = append(enumClass.Interfaces, "BackedEnum")
+	}
+	for _, iface := range decl.Implements {
+		enumClass.Interfaces = append(enumClass.Interfaces, c.resolveClassName(iface.Name))
+	}
+	if len(decl.Attributes) > 0 {
+		enumClass.Attributes = c.compileAttributes(decl.Attributes)
+	}
+
+	// Every case exposes readonly name and, for backed enums, value
+	enumClass.Properties["name"] = &registry.Property{Name: "name", Visibility: "public", IsReadonly: true, Type: "string"}
+	enumClass.PropertyOrder = append(enumClass.PropertyOrder, "name")
+	if backingType != "" {
+		enumClass.Properties["value"] = &registry.Property{Name: "value", Visibility: "public", IsReadonly: true, Type: backingType}
+		enumClass.PropertyOrder = append(enumClass.PropertyOrder, "value")
 	}
 
-	// Add enum cases as constants
+	oldCurrentClass := c.currentClass
+	c.currentClass = enumClass
+	defer func() { c.currentClass = oldCurrentClass }()
+
+	// Cases are compiled to class constants holding a case object; the VM
+	// replaces them with one shared instance per case at runtime
+	caseByValue := make(map[string]string)
 	for _, enumCase := range decl.Cases {
 		if enumCase.Name == nil {
 			continue
 		}
-
 		caseName := enumCase.Name.Name
-		// Create enum case object with name property
-		enumCaseObj := &values.Object{
-			ClassName:  enumName,
-			Properties: make(map[string]*values.Value),
-		}
-		enumCaseObj.Properties["name"] = values.NewString(caseName)
-
-		var caseValue *values.Value
-		if enumCase.Value != nil {
-			// Backed enum - evaluate the backing value
-			backingValue := c.evaluateConstantExpression(enumCase.Value)
-			if backingValue != nil {
-				enumCaseObj.Properties["value"] = backingValue
+		if _, exists := enumClass.Constants[caseName]; exists {
+			return fmt.Errorf("cannot redefine class constant %s::%s", fullyQualifiedName, caseName)
+		}
+
+		caseObj := &values.Object{
+			ClassName:  fullyQualifiedName,
+			Properties: map[string]*values.Value{"name": values.NewString(caseName)},
+			EnumCase:   true,
+		}
+		switch {
+		case backingType == "" && enumCase.Value != nil:
+			return fmt.Errorf("case %s of non-backed enum %s must not have a value", caseName, fullyQualifiedName)
+		case backingType != "" && enumCase.Value == nil:
+			return fmt.Errorf("case %s of backed enum %s must have a value", caseName, fullyQualifiedName)
+		case backingType != "":
+			backingValue, err := c.evaluateClassConstantExpression(enumCase.Value)
+			if err != nil {
+				return fmt.Errorf("failed to evaluate enum case %s::%s: %v", fullyQualifiedName, caseName, err)
 			}
-		}
-
-		caseValue = &values.Value{
-			Type: values.TypeObject,
-			Data: enumCaseObj,
+			if backingValue.Type.String() != backingType {
+				return fmt.Errorf("enum case type %s does not match enum backing type %s", backingValue.Type, backingType)
+			}
+			key := backingValue.ToString()
+			if other, exists := caseByValue[key]; exists {
+				return fmt.Errorf("duplicate value in enum %s for cases %s and %s", fullyQualifiedName, other, caseName)
+			}
+			caseByValue[key] = caseName
+			caseObj.Properties["value"] = backingValue
 		}
 
 		enumClass.Constants[caseName] = &registry.ClassConstant{
 			Name:       caseName,
-			Value:      caseValue,
+			Value:      &values.Value{Type: values.TypeObject, Data: caseObj},
 			Visibility: "public",
 			IsFinal:    true,
 		}
+		enumClass.EnumCases = append(enumClass.EnumCases, caseName)
+		enumClass.ConstantOrder = append(enumClass.ConstantOrder, caseName)
+	}
+
+	nameConstant := c.addConstant(values.NewString(fullyQualifiedName))
+	c.emit(opcodes.OP_INIT_CLASS_TABLE, opcodes.IS_CONST, nameConstant, 0, 0, 0, 0)
+	c.emit(opcodes.OP_SET_CURRENT_CLASS, opcodes.IS_CONST, nameConstant, 0, 0, 0, 0)
+
+	for _, constant := range decl.Constants {
+		if err := c.compileClassConstant(constant); err != nil {
+			return fmt.Errorf("error compiling enum %s: %v", enumName, err)
+		}
 	}
 
-	// Add enum methods if any
 	for _, method := range decl.Methods {
+		if method.Name != nil {
+			if methodName, ok := method.Name.(*ast.IdentifierNode); ok && enumForbiddenMagicMethods[strings.ToLower(methodName.Name)] {
+				return fmt.Errorf("enum %s cannot include magic method %s", fullyQualifiedName, methodName.Name)
+			}
+		}
 		if err := c.compileEnumMethod(enumClass, method); err != nil {
 			return err
 		}
 	}
 
 	// Store enum as class
-	c.classes[enumName] = enumClass
+	c.bindTraitMemberOrder(enumClass)
+	c.classes[fullyQualifiedName] = enumClass
 
-	// Emit class declaration opcode (reuse existing class opcodes)
-	nameConstant := c.addConstant(values.NewString(enumName))
-	c.emit(opcodes.OP_DECLARE_CLASS,
-		opcodes.IS_CONST, nameConstant,
-		opcodes.IS_UNUSED, 0,
-		opcodes.IS_UNUSED, 0)
+	c.emit(opcodes.OP_CLEAR_CURRENT_CLASS, 0, 0, 0, 0, 0, 0)
+	c.emit(opcodes.OP_DECLARE_CLASS, opcodes.IS_CONST, nameConstant, 0, 0, 0, 0)
 
 	return nil
 }
@@ -7076,7 +7532,8 @@ func (c *Compiler) compileUseTraitStatement(stmt *ast.UseTraitStatement) error {
 
 		// Copy trait methods into current class
 		// This implements the PHP behavior where trait methods become class methods
-		for methodName, traitMethod := range trait.Methods {
+		for _, methodName := range registry.OrderedNames(trait.MethodOrder, trait.Methods) {
+			traitMethod := trait.Methods[methodName]
 			// Check for method conflicts
 			if _, exists := c.currentClass.Methods[methodName]; exists {
 				// In a full implementation, we would handle method precedence rules here
@@ -7089,8 +7546,10 @@ func (c *Compiler) compileUseTraitStatement(stmt *ast.UseTraitStatement) error {
 				Instructions: make([]*opcodes.Instruction, len(traitMethod.Instructions)),
 				Constants:    make([]*values.Value, len(traitMethod.Constants)),
 				Parameters:   make([]*registry.Parameter, len(traitMethod.Parameters)),
+				ReturnType:   traitMethod.ReturnType,
 				IsVariadic:   traitMethod.IsVariadic,
 				IsGenerator:  traitMethod.IsGenerator,
+				StrictTypes:  traitMethod.StrictTypes,
 			}
 
 			// Deep copy instructions
@@ -7104,6 +7563,7 @@ func (c *Compiler) compileUseTraitStatement(stmt *ast.UseTraitStatement) error {
 
 			// Add the method to the current class
 			c.currentClass.Methods[methodName] = classMethod
+			c.addTraitMember(methodName, false)
 
 			// Also register in unified registry if available
 			if registry.GlobalRegistry != nil {
@@ -7177,7 +7637,8 @@ func (c *Compiler) compileUseTraitStatement(stmt *ast.UseTraitStatement) error {
 		}
 
 		// Copy trait properties into current class
-		for propName, traitProp := range trait.Properties {
+		for _, propName := range registry.OrderedNames(trait.PropertyOrder, trait.Properties) {
+			traitProp := trait.Properties[propName]
 			// Check for property conflicts
 			if _, exists := c.currentClass.Properties[propName]; exists {
 				// In a full implementation, we would handle property conflicts
@@ -7194,6 +7655,7 @@ func (c *Compiler) compileUseTraitStatement(stmt *ast.UseTraitStatement) error {
 
 			// Add the property to the current class
 			c.currentClass.Properties[propName] = classProp
+			c.addTraitMember(propName, true)
 		}
 
 		// Emit USE_TRAIT opcode for runtime tracking
@@ -7286,20 +7748,8 @@ func (c *Compiler) evaluateConstantArrayExpression(arrExpr *ast.ArrayExpression)
 				}
 				// Convert to appropriate Go type for map key
 				switch keyConst.Type {
-				case values.TypeString:
-					keyValue = keyConst.Data.(string)
-				case values.TypeInt:
-					keyValue = keyConst.Data.(int64)
-				case values.TypeFloat:
-					// PHP converts float keys to integers
-					keyValue = int64(keyConst.Data.(float64))
-				case values.TypeBool:
-					// PHP converts bool keys to integers
-					if keyConst.Data.(bool) {
-						keyValue = int64(1)
-					} else {
-						keyValue = int64(0)
-					}
+				case values.TypeString, values.TypeInt, values.TypeFloat, values.TypeBool, values.TypeNull:
+					keyValue = values.ArrayKey(keyConst)
 				default:
 					return nil, fmt.Errorf("invalid array key type: %v", keyConst.Type)
 				}
@@ -7329,17 +7779,10 @@ func (c *Compiler) evaluateConstantArrayExpression(arrExpr *ast.ArrayExpression)
 			// Add to array
 			if keyValue != nil {
 				// Keyed element
-				arrayData.Elements[keyValue] = valueValue
+				arrayData.Set(keyValue, valueValue)
 			} else {
-				// Auto-indexed element - find next integer key
-				nextIndex := int64(len(arrayData.Elements))
-				for {
-					if _, exists := arrayData.Elements[nextIndex]; !exists {
-						break
-					}
-					nextIndex++
-				}
-				arrayData.Elements[nextIndex] = valueValue
+				// Auto-indexed element
+				arrayData.Append(valueValue)
 			}
 
 		default:
@@ -7363,14 +7806,7 @@ func (c *Compiler) evaluateConstantArrayExpression(arrExpr *ast.ArrayExpression)
 			}
 
 			// Auto-indexed element
-			nextIndex := int64(len(arrayData.Elements))
-			for {
-				if _, exists := arrayData.Elements[nextIndex]; !exists {
-					break
-				}
-				nextIndex++
-			}
-			arrayData.Elements[nextIndex] = valueValue
+			arrayData.Append(valueValue)
 		}
 	}
 
@@ -7388,6 +7824,7 @@ func (c *Compiler) compileTraitProperty(trait *registry.Trait, prop *ast.Propert
 	}
 
 	trait.Properties[prop.Name] = property
+	trait.PropertyOrder = appendMemberName(trait.PropertyOrder, prop.Name)
 	return nil
 }
 
@@ -7416,6 +7853,18 @@ func (c *Compiler) compileTraitMethod(trait *registry.Trait, method *ast.Functio
 		Parameters:   make([]*registry.Parameter, 0),
 		IsVariadic:   false,
 		IsGenerator:  false,
+		IsAbstract:   method.IsAbstract,
+		IsStatic:     method.IsStatic,
+		IsFinal:      method.IsFinal,
+		Visibility:   method.Visibility,
+		StrictTypes:  c.strictTypes,
+		DocComment:   method.DocComment,
+	}
+	if function.Visibility == "" {
+		function.Visibility = "public"
+	}
+	if method.ReturnType != nil {
+		function.ReturnType = c.typeHintName(method.ReturnType)
 	}
 
 	// Compile parameters
@@ -7436,7 +7885,7 @@ func (c *Compiler) compileTraitMethod(trait *registry.Trait, method *ast.Functio
 
 			// Handle parameter type
 			if param.Type != nil {
-				compilerParam.Type = param.Type.String()
+				compilerParam.Type = c.typeHintName(param.Type)
 			}
 
 			// Handle default value
@@ -7444,7 +7893,7 @@ func (c *Compiler) compileTraitMethod(trait *registry.Trait, method *ast.Functio
 				compilerParam.HasDefault = true
 				// Compile the default value expression
 				// For now, we'll evaluate simple default values at compile time
-				defaultValue := c.evaluateConstantExpression(param.DefaultValue)
+				defaultValue := c.evaluateStaticExpression(param.DefaultValue)
 				if defaultValue != nil {
 					compilerParam.DefaultValue = defaultValue
 				} else {
@@ -7513,6 +7962,7 @@ func (c *Compiler) compileTraitMethod(trait *registry.Trait, method *ast.Functio
 	}
 
 	// Store compiled method
+	c.optimizeCurrentUnit()
 	function.Instructions = c.instructions
 	function.Constants = c.constants
 
@@ -7528,6 +7978,7 @@ func (c *Compiler) compileTraitMethod(trait *registry.Trait, method *ast.Functio
 
 	// Store the method in the trait
 	trait.Methods[methodName] = function
+	trait.MethodOrder = appendMemberName(trait.MethodOrder, methodName)
 
 	// Restore compiler state
 	c.popScope()
@@ -7574,9 +8025,46 @@ func (c *Compiler) Traits() map[string]*registry.Trait {
 
 // SetCurrentFile sets the current file being compiled for magic constants
 func (c *Compiler) SetCurrentFile(filePath string) {
+	// Files are reported by their absolute path, as PHP does
+	if absPath, err := filepath.Abs(filePath); err == nil {
+		filePath = absPath
+	}
 	c.currentFile = filePath
 }
 
+// SetOptimize enables the bytecode optimizer for the main script and every
+// function and method compiled afterwards
+func (c *Compiler) SetOptimize(enabled bool) {
+	c.optimize = enabled
+}
+
+// OptimizationStats returns the optimizer statistics accumulated over all
+// units compiled with optimization enabled
+func (c *Compiler) OptimizationStats() optimizer.OptimizationStats {
+	return c.optimizerStats
+}
+
+// optimizeCurrentUnit runs the optimizer over the instructions and constants
+// of the unit being compiled, if optimization is enabled
+func (c *Compiler) optimizeCurrentUnit() {
+	if !c.optimize {
+		return
+	}
+	instructions, constants, stats := optimizer.NewOptimizer().Optimize(c.instructions, c.constants)
+	c.instructions = instructions
+	c.constants = constants
+
+	c.optimizerStats.OriginalSize += stats.OriginalSize
+	c.optimizerStats.OptimizedSize += stats.OptimizedSize
+	c.optimizerStats.Iterations += stats.Iterations
+	if c.optimizerStats.PassStats == nil {
+		c.optimizerStats.PassStats = make(map[string]int)
+	}
+	for name, count := range stats.PassStats {
+		c.optimizerStats.PassStats[name] += count
+	}
+}
+
 // validateAbstractMethodImplementation checks if a class properly implements all abstract methods from its parent
 func (c *Compiler) validateAbstractMethodImplementation(class *registry.Class) error {
 	if class.Parent == "" {
@@ -7712,19 +8200,24 @@ func (c *Compiler) compileAttributes(attributeGroups []*ast.AttributeGroup) []*r
 		for _, attr := range group.Attributes {
 			if attr.Name != nil {
 				compiledAttr := &registry.Attribute{
-					Name:      attr.Name.Name,
+					Name:      c.resolveClassName(attr.Name.Name),
 					Arguments: make([]*values.Value, 0),
 				}
 
 				// Compile attribute arguments
 				for _, arg := range attr.Arguments {
-					argValue := c.evaluateConstantExpression(arg)
-					if argValue != nil {
-						compiledAttr.Arguments = append(compiledAttr.Arguments, argValue)
-					} else {
+					argName := ""
+					if named, ok := arg.(*ast.NamedArgument); ok {
+						argName = named.Name.Name
+						arg = named.Value
+					}
+					argValue := c.evaluateStaticExpression(arg)
+					if argValue == nil {
 						// If we can't evaluate at compile time, use null
-						compiledAttr.Arguments = append(compiledAttr.Arguments, values.NewNull())
+						argValue = values.NewNull()
 					}
+					compiledAttr.Arguments = append(compiledAttr.Arguments, argValue)
+					compiledAttr.ArgumentNames = append(compiledAttr.ArgumentNames, argName)
 				}
 
 				result = append(result, compiledAttr)
@@ -7735,6 +8228,16 @@ func (c *Compiler) compileAttributes(attributeGroups []*ast.AttributeGroup) []*r
 	return result
 }
 
+// evaluateStaticExpression evaluates a constant expression such as a
+// parameter default or attribute argument, returning nil if it cannot be
+// evaluated at compile time
+func (c *Compiler) evaluateStaticExpression(expr ast.Expression) *values.Value {
+	if value, err := c.evaluateClassConstantExpression(expr); err == nil && value != nil {
+		return value
+	}
+	return c.evaluateConstantExpression(expr)
+}
+
 // declarePromotedProperty automatically declares a property from a promoted constructor parameter
 func (c *Compiler) declarePromotedProperty(param *ast.ParameterNode) error {
 	if c.currentClass == nil {
@@ -7767,12 +8270,12 @@ func (c *Compiler) declarePromotedProperty(param *ast.ParameterNode) error {
 
 	// Handle type hint
 	if param.Type != nil {
-		property.Type = param.Type.String()
+		property.Type = c.typeHintName(param.Type)
 	}
 
 	// Handle default value
 	if param.DefaultValue != nil {
-		defaultValue := c.evaluateConstantExpression(param.DefaultValue)
+		defaultValue := c.evaluateStaticExpression(param.DefaultValue)
 		if defaultValue != nil {
 			property.DefaultValue = defaultValue
 		} else {
@@ -7787,6 +8290,7 @@ func (c *Compiler) declarePromotedProperty(param *ast.ParameterNode) error {
 
 	// Add property to current class
 	c.currentClass.Properties[propName] = property
+	c.currentClass.PropertyOrder = appendMemberName(c.currentClass.PropertyOrder, propName)
 
 	return nil
 }
@@ -7907,6 +8411,7 @@ func (c *Compiler) processTraitPrecedence(stmt *ast.TraitPrecedenceStatement, tr
 	if winningMethod, exists := winningTrait.Methods[methodName]; exists {
 		// Ensure this method is the one used in the class
 		c.currentClass.Methods[methodName] = winningMethod
+		c.addTraitMember(methodName, false)
 
 		// Also update in registry if available
 		if registry.GlobalRegistry != nil {
@@ -7970,8 +8475,10 @@ func (c *Compiler) processTraitAlias(stmt *ast.TraitAliasStatement, traitMap map
 		Instructions: make([]*opcodes.Instruction, len(sourceMethod.Instructions)),
 		Constants:    make([]*values.Value, len(sourceMethod.Constants)),
 		Parameters:   make([]*registry.Parameter, len(sourceMethod.Parameters)),
+		ReturnType:   sourceMethod.ReturnType,
 		IsVariadic:   sourceMethod.IsVariadic,
 		IsGenerator:  sourceMethod.IsGenerator,
+		StrictTypes:  sourceMethod.StrictTypes,
 	}
 
 	// Deep copy the method data
@@ -7981,6 +8488,7 @@ func (c *Compiler) processTraitAlias(stmt *ast.TraitAliasStatement, traitMap map
 
 	// Add the alias method to the current class
 	c.currentClass.Methods[aliasMethodName] = aliasMethod
+	c.addTraitMember(aliasMethodName, false)
 
 	// Handle visibility changes if specified
 	visibility := "public" // Default visibility for trait methods
@@ -1766,6 +1766,27 @@ func TestArrayAccessEdgeCases(t *testing.T) {
 	}
 }
 
+// TestArrayNullKey tests that a null key is the key "" in array writes,
+// literals and constant expressions, while $arr[] appends
+func TestArrayNullKey(t *testing.T) {
+	code := `<?php
+$a = [];
+$a[null] = 1;
+$a[] = 2;
+$a[null][] = 3;
+$x = 4;
+$a[] = &$x;
+$r = &$a[null];
+echo implode(",", array_map("json_encode", array_keys($a))), "\n";
+echo implode(",", array_map("json_encode", array_keys([null => 7, 5 => 8, "3" => 9, true => 10]))), "\n";
+class NullKeyConstants { const KEYS = [null => 1, "2" => 2]; }
+echo implode(",", array_map("json_encode", array_keys(NullKeyConstants::KEYS))), "\n";
+`
+	output, err := compileAndExecute(t, code)
+	require.NoError(t, err)
+	require.Equal(t, "\"\",0,1\n\"\",5,3,1\n\"\",2\n", output)
+}
+
 // TestArrayAccessOutsideInterpolation tests that array access works outside interpolated strings
 func TestArrayAccessOutsideInterpolation(t *testing.T) {
 	tests := []struct {
@@ -3330,6 +3351,30 @@ func TestStaticPropertyAccessExpression(t *testing.T) {
 			echo TestClass::$counter;`,
 			"0",
 		},
+		{
+			"Static property assignment",
+			`<?php
+			class TestClass {
+				public static $prop;
+			}
+			TestClass::$prop = "assigned";
+			echo TestClass::$prop;`,
+			"assigned",
+		},
+		{
+			"Static property compound assignment from self",
+			`<?php
+			class TestClass {
+				private static $log = "a";
+				public static function append($s) {
+					self::$log .= $s;
+					return self::$log;
+				}
+			}
+			TestClass::append("b");
+			echo TestClass::append("c");`,
+			"abc",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -7024,7 +7069,6 @@ func TestGoidFunctionConsistency(t *testing.T) {
 // TestGeneratorImplementation tests comprehensive generator functionality including loops
 // TODO: Generator implementation is incomplete and needs proper yield/resume logic
 func TestGeneratorImplementation(t *testing.T) {
-	t.Skip("Generator implementation requires VM architecture changes for proper yield/resume - see runtime/generator.go for details")
 	tests := []struct {
 		name           string
 		phpCode        string
@@ -8070,10 +8114,24 @@ func TestUnsetCompilationOnly(t *testing.T) {
 
 // Helper function to compile and execute PHP code with output capture
 func compileAndExecute(t *testing.T, code string) (string, error) {
+	// Initialize runtime if not already done, as compiling resolves the
+	// constants of builtin classes
+	if runtime2.GlobalRegistry == nil {
+		err := runtime2.Bootstrap()
+		require.NoError(t, err, "Failed to bootstrap runtime")
+	}
+
+	// Initialize VM integration
+	if runtime2.GlobalVMIntegration == nil {
+		err := runtime2.InitializeVMIntegration()
+		require.NoError(t, err, "Failed to initialize VM integration")
+	}
+
 	// Parse the code
 	p := parser.New(lexer.New(code))
 	prog := p.ParseProgram()
 	require.NotNil(t, prog, "Failed to parse program")
+	require.Empty(t, p.Errors(), "Parser errors: %v", p.Errors())
 
 	// Compile the code
 	comp := NewCompiler()
@@ -8085,18 +8143,6 @@ func compileAndExecute(t *testing.T, code string) (string, error) {
 	vmCtx := vm.NewExecutionContext()
 	vmCtx.SetOutputWriter(&buf)
 
-	// Initialize runtime if not already done
-	if runtime2.GlobalRegistry == nil {
-		err := runtime2.Bootstrap()
-		require.NoError(t, err, "Failed to bootstrap runtime")
-	}
-
-	// Initialize VM integration
-	if runtime2.GlobalVMIntegration == nil {
-		err := runtime2.InitializeVMIntegration()
-		require.NoError(t, err, "Failed to initialize VM integration")
-	}
-
 	// Execute
 	vmachine := vm.NewVirtualMachine()
 	err = vmachine.Execute(vmCtx, comp.GetBytecode(), comp.GetConstants(), comp.Functions(), comp.Classes(), comp.Interfaces(), comp.Traits())
@@ -8911,3 +8957,96 @@ func TestVersionCompare(t *testing.T) {
 		})
 	}
 }
+
+func TestNamespaceClassNameResolution(t *testing.T) {
+	code := `<?php
+namespace ResolveLib\Util {
+	class Str {
+		const SEP = "-";
+		public static $count = 0;
+		public static function up($s) { self::$count++; return strtoupper($s); }
+	}
+	function shout($s) { return Str::up($s) . "!"; }
+}
+namespace ResolveApp {
+	use ResolveLib\Util\Str;
+	use ResolveLib\Util as U;
+	echo Str::up("a"), Str::SEP, U\Str::up("b"), Str::SEP, \ResolveLib\Util\shout("c"), "\n";
+	echo Str::$count, " ", Str::class, " ", U\Str::class, "\n";
+	var_dump(new Str instanceof Str, new Str instanceof \ResolveLib\Util\Str);
+}`
+
+	p := parser.New(lexer.New(code))
+	prog := p.ParseProgram()
+	require.Empty(t, p.Errors())
+
+	comp := NewCompiler()
+	require.NoError(t, comp.Compile(prog))
+
+	vmCtx := vm.NewExecutionContext()
+	var buf bytes.Buffer
+	vmCtx.SetOutputWriter(&buf)
+	err := vm.NewVirtualMachine().Execute(vmCtx, comp.GetBytecode(), comp.GetConstants(), comp.Functions(), comp.Classes(), comp.Interfaces(), comp.Traits())
+	require.NoError(t, err)
+
+	assert.Equal(t, "A-B-C!\n3 ResolveLib\\Util\\Str ResolveLib\\Util\\Str\nbool(true)\nbool(true)\n", buf.String())
+}
+
+func TestNamespacedFunctionResolution(t *testing.T) {
+	code := `<?php
+namespace FnA {
+	function name() { return "A"; }
+	function len($s) { return "A" . strlen($s); }
+}
+namespace FnB {
+	function name() { return "B"; }
+	echo name(), \FnA\name(), \FnA\len("xyz"), "\n";
+}
+namespace FnC {
+	use function FnA\name;
+	use function FnB\name as bname;
+	echo name(), bname(), strtoupper("c"), "\n";
+	var_dump(function_exists('name'), function_exists('FnA\name'));
+}`
+
+	p := parser.New(lexer.New(code))
+	prog := p.ParseProgram()
+	require.Empty(t, p.Errors())
+
+	comp := NewCompiler()
+	require.NoError(t, comp.Compile(prog))
+
+	vmCtx := vm.NewExecutionContext()
+	var buf bytes.Buffer
+	vmCtx.SetOutputWriter(&buf)
+	err := vm.NewVirtualMachine().Execute(vmCtx, comp.GetBytecode(), comp.GetConstants(), comp.Functions(), comp.Classes(), comp.Interfaces(), comp.Traits())
+	require.NoError(t, err)
+
+	assert.Equal(t, "BAA3\nABC\nbool(false)\nbool(true)\n", buf.String())
+}
+
+func TestNewWithoutConstructor(t *testing.T) {
+	code := `<?php
+class NoCtorBase {}
+class NoCtorChild extends NoCtorBase {}
+class WithCtor extends NoCtorBase { public function __construct($v = "default") { echo "ctor $v\n"; } }
+function noCtorArg() { echo "evaluated\n"; return 1; }
+$a = new NoCtorChild(noCtorArg());
+$b = new WithCtor;
+$c = new WithCtor(noCtorArg());
+echo get_class($a), "\n";`
+
+	comp, err := parseAndCompileOnly(t, code)
+	require.NoError(t, err)
+	calls := 0
+	for _, inst := range comp.GetBytecode() {
+		if inst.Opcode == opcodes.OP_INIT_METHOD_CALL {
+			calls++
+		}
+	}
+	assert.Equal(t, 2, calls, "only classes with a constructor should call it")
+
+	output, err := compileAndExecute(t, code)
+	require.NoError(t, err)
+	assert.Equal(t, "ctor default\nevaluated\nctor 1\nNoCtorChild\n", output)
+}
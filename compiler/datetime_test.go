@@ -0,0 +1,191 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestDateTimeClasses covers DateTime, DateTimeImmutable, DateTimeZone,
+// DateInterval and DatePeriod, and the procedural functions aliasing them
+func TestDateTimeClasses(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "timezones",
+			code: `$d = new DateTime('2024-01-15 10:30:00', new DateTimeZone('America/New_York'));
+echo $d->format('Y-m-d H:i:s T P e'), "\n";
+$d->setTimezone(new DateTimeZone('Europe/Paris'));
+echo $d->format(DateTimeInterface::ATOM), ' ', $d->getOffset(), "\n";
+$d->setTimezone(new DateTimeZone('+05:30'));
+echo $d->format(DATE_RFC2822), ' ', $d->getTimezone()->getName(), "\n";
+$d->setTimezone(new DateTimeZone('EST'));
+echo $d->format('H:i T'), "\n";`,
+			expected: "2024-01-15 10:30:00 EST -05:00 America/New_York\n" +
+				"2024-01-15T16:30:00+01:00 3600\n" +
+				"Mon, 15 Jan 2024 21:00:00 +0530 +05:30\n" +
+				"10:30 EST\n",
+		},
+		{
+			name: "immutable",
+			code: `$i = new DateTimeImmutable('2024-03-31', new DateTimeZone('UTC'));
+$j = $i->modify('+1 day')->setTime(8, 15);
+$k = $i->sub(new DateInterval('P1M'));
+echo $i->format('Y-m-d H:i'), ' ', $j->format('Y-m-d H:i'), ' ', $k->format('Y-m-d'), "\n";
+$m = DateTime::createFromImmutable($i);
+$m->add(new DateInterval('PT36H'));
+echo get_class($m), ' ', $m->format('Y-m-d H:i'), ' ', $i->format('H:i'), "\n";
+$back = DateTimeImmutable::createFromInterface($m);
+echo get_class($back), ' ', $back->getTimestamp() === $m->getTimestamp() ? 'same' : 'differs', "\n";`,
+			expected: "2024-03-31 00:00 2024-04-01 08:15 2024-03-02\n" +
+				"DateTime 2024-04-01 12:00 00:00\n" +
+				"DateTimeImmutable same\n",
+		},
+		{
+			name: "setters",
+			code: `$d = new DateTime('2000-01-01 00:00:00', new DateTimeZone('UTC'));
+echo $d->setDate(2020, 2, 29)->setTime(23, 59, 59)->format('c'), "\n";
+echo $d->setISODate(2025, 3, 1)->format('Y-m-d l'), "\n";
+echo $d->setTimestamp(1700000000)->format('Y-m-d H:i:s U'), "\n";
+echo $d->setDate(2021, 14, 35)->format('Y-m-d'), "\n";`,
+			expected: "2020-02-29T23:59:59+00:00\n" +
+				"2025-01-13 Monday\n" +
+				"2023-11-14 22:13:20 1700000000\n" +
+				"2022-03-07\n",
+		},
+		{
+			name: "diff and intervals",
+			code: `$utc = new DateTimeZone('UTC');
+$a = new DateTime('2024-01-31', $utc);
+$b = new DateTime('2024-03-15 12:00', $utc);
+$diff = $a->diff($b);
+echo $diff->format('%R %y %m %d %h %a'), ' ', $diff->days, "\n";
+echo $b->diff($a)->format('%R%a days %H:%I:%S'), ' ', $b->diff($a, true)->format('%R'), "\n";
+$i = new DateInterval('P1Y2M3W4DT5H6M7S');
+echo $i->format('%Y-%M-%D %h:%i:%s %a %%'), "\n";
+echo (new DateInterval('P0001-02-03T04:05:06'))->format('%y %m %d %h %i %s'), "\n";
+try { new DateInterval('P1X'); } catch (Exception $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "+ 0 1 15 12 44 44\n" +
+				"-44 days 12:00:00 +\n" +
+				"01-02-25 5:6:7 (unknown) %\n" +
+				"1 2 3 4 5 6\n" +
+				"DateInterval::__construct(): Unknown or bad format (P1X)\n",
+		},
+		{
+			name: "comparison by instant",
+			code: `$a = new DateTime('2024-01-01 12:00', new DateTimeZone('UTC'));
+$b = new DateTimeImmutable('2024-01-01 13:00', new DateTimeZone('Europe/Paris'));
+$c = new DateTime('2024-01-02', new DateTimeZone('America/New_York'));
+var_dump($a == $b, $a === $b, $a < $c, $c > $b, $b >= $a, $a <=> $c);
+echo max($c, $a)->format('Y-m-d'), ' ', min([$c, $b])->format('Y-m-d'), "\n";
+echo (new DateInterval('P123Y'))->format('%Y %y'), "\n";`,
+			expected: "bool(true)\nbool(false)\nbool(true)\nbool(true)\nbool(true)\nint(-1)\n" +
+				"2024-01-02 2024-01-01\n" +
+				"123 123\n",
+		},
+		{
+			name: "createFromFormat",
+			code: `$c = DateTime::createFromFormat('d/m/Y H:i', '15/08/2023 14:45', new DateTimeZone('Europe/Berlin'));
+echo $c->format('Y-m-d H:i:s T'), "\n";
+$c = DateTimeImmutable::createFromFormat('!Y-m-d', '2023-08-15');
+echo $c->format('H:i:s'), "\n";
+var_dump(DateTime::createFromFormat('Y-m-d', '2023-08'));
+$errors = DateTime::getLastErrors();
+echo $errors['error_count'], ' ', $errors['errors'][7], "\n";`,
+			expected: "2023-08-15 14:45:00 CEST\n" +
+				"00:00:00\n" +
+				"bool(false)\n" +
+				"1 Not enough data available to satisfy format\n",
+		},
+		{
+			name: "DateTimeZone",
+			code: `$tz = new DateTimeZone('Europe/London');
+echo $tz->getName(), ' ', $tz->getOffset(new DateTime('2024-07-01')), ' ', $tz->getOffset(new DateTime('2024-01-01')), "\n";
+$tr = $tz->getTransitions(1700000000, 1720000000);
+echo count($tr), ' ', $tr[0]['ts'], ' ', $tr[1]['time'], ' ', $tr[1]['abbr'], ' ', var_export($tr[1]['isdst'], true), "\n";
+$loc = $tz->getLocation();
+echo $loc['country_code'], "\n";
+$ids = DateTimeZone::listIdentifiers(DateTimeZone::EUROPE);
+echo in_array('Europe/Paris', $ids) ? 'yes' : 'no', ' ', in_array('Asia/Tokyo', $ids) ? 'yes' : 'no', "\n";
+echo implode(',', DateTimeZone::listIdentifiers(DateTimeZone::PER_COUNTRY, 'NZ')), "\n";
+try { new DateTimeZone('Mars/Olympus'); } catch (Exception $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "Europe/London 3600 0\n" +
+				"2 1700000000 2024-03-31T01:00:00+0000 BST true\n" +
+				"GB\n" +
+				"yes no\n" +
+				"Pacific/Auckland,Pacific/Chatham\n" +
+				"DateTimeZone::__construct(): Unknown or bad timezone (Mars/Olympus)\n",
+		},
+		{
+			name: "DatePeriod",
+			code: `$utc = new DateTimeZone('UTC');
+$p = new DatePeriod(new DateTimeImmutable('2024-01-01', $utc), new DateInterval('P1W'), 3);
+foreach ($p as $k => $day) { echo $k, ':', get_class($day), ':', $day->format('m/d'), ' '; }
+echo $p->getRecurrences(), "\n";
+$p = new DatePeriod(new DateTime('2024-01-30', $utc), new DateInterval('P1D'), new DateTime('2024-02-02', $utc), DatePeriod::EXCLUDE_START_DATE);
+foreach ($p as $day) { echo $day->format('m/d'), ' '; }
+echo $p->getEndDate()->format('m/d'), "\n";
+foreach (new DatePeriod('R2/2012-07-01T00:00:00Z/P7D') as $day) { echo $day->format('Y-m-d '); }
+echo "\n";`,
+			expected: "0:DateTimeImmutable:01/01 1:DateTimeImmutable:01/08 2:DateTimeImmutable:01/15 3:DateTimeImmutable:01/22 3\n" +
+				"01/31 02/01 02/02\n" +
+				"2012-07-01 2012-07-08 2012-07-15 \n",
+		},
+		{
+			name: "DateTimeInterface and clone",
+			code: `function year(DateTimeInterface $d): string { return $d->format('Y'); }
+$d = new DateTime('2020-05-05', new DateTimeZone('UTC'));
+echo year($d), year(new DateTimeImmutable('2021-01-01')), "\n";
+var_dump($d instanceof DateTimeInterface, new DateTimeZone('UTC') instanceof DateTimeInterface);
+$e = clone $d;
+$e->modify('+1 year');
+echo $d->format('Y'), ' ', $e->format('Y'), "\n";
+class MyDate extends DateTimeImmutable {}
+$m = new MyDate('2022-02-02');
+echo get_class($m->modify('+1 day')), "\n";`,
+			expected: "20202021\nbool(true)\nbool(false)\n2020 2021\nMyDate\n",
+		},
+		{
+			name: "procedural aliases",
+			code: `$d = date_create('2021-05-05', timezone_open('Asia/Tokyo'));
+echo date_format($d, 'D Y-m-d T'), ' ', date_offset_get($d), "\n";
+date_modify($d, '+1 day');
+date_timezone_set($d, timezone_open('UTC'));
+echo date_format($d, 'Y-m-d H:i'), ' ', timezone_name_get(date_timezone_get($d)), "\n";
+var_dump(date_create('not a date'), timezone_open('Nowhere/Special'));
+echo date_interval_format(date_diff(date_create('2020-01-01'), date_create('2020-01-04')), '%a'), "\n";`,
+			expected: "Wed 2021-05-05 JST 32400\n" +
+				"2021-05-05 15:00 UTC\n" +
+				"bool(false)\nbool(false)\n" +
+				"3\n",
+		},
+		{
+			name: "relative formats",
+			code: `$d = new DateTimeImmutable('2024-01-15 10:30:00', new DateTimeZone('Europe/Paris'));
+echo $d->modify('last day of next month')->format('Y-m-d H:i'), ' ', $d->modify('2025-03-01')->format('Y-m-d H:i'), "\n";
+echo $d->modify('@1700000000')->format('Y-m-d H:i:s e'), "\n";
+echo date('Y-m-d', strtotime('first monday of january 2025')), ' ', strtotime('+1 week 2 days', 0), "\n";
+var_dump(strtotime('next blursday'), $d->modify('10:00 10:00'));
+echo $d->add(DateInterval::createFromDateString('3 days ago'))->format('m/d'), "\n";
+$p = date_parse('2024-02-30 10:00 EST +1 week');
+echo $p['warning_count'], ' ', $p['warnings'][29], ' ', $p['zone'], ' ', $p['tz_abbr'], ' ', $p['relative']['day'], "\n";`,
+			expected: "2024-02-29 10:30 2025-03-01 10:30\n" +
+				"2023-11-14 22:13:20 +00:00\n" +
+				"2025-01-06 777600\n" +
+				"bool(false)\nbool(false)\n" +
+				"01/12\n" +
+				"1 The parsed date was invalid -18000 EST 7\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := compileAndExecute(t, "<?php\n"+tt.code)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, output)
+		})
+	}
+}
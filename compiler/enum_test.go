@@ -0,0 +1,135 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/compiler/lexer"
+	"github.com/wudi/hey/compiler/parser"
+)
+
+// TestEnumRuntime verifies case identity, the UnitEnum and BackedEnum
+// methods and the errors enums raise
+func TestEnumRuntime(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "case identity and interfaces",
+			code: `enum EnumRtSuit { case Hearts; case Spades; }
+$h = EnumRtSuit::Hearts;
+var_dump($h === EnumRtSuit::Hearts, $h === EnumRtSuit::Spades, $h == EnumRtSuit::Hearts);
+var_dump($h instanceof EnumRtSuit, $h instanceof UnitEnum, $h instanceof BackedEnum);
+echo $h->name, "\n";
+var_dump($h);`,
+			expected: "bool(true)\nbool(false)\nbool(true)\nbool(true)\nbool(true)\nbool(false)\nHearts\nenum(EnumRtSuit::Hearts)\n",
+		},
+		{
+			name: "cases preserve declaration order",
+			code: `enum EnumRtOrder { case Z; case A; case M; }
+foreach (EnumRtOrder::cases() as $case) { echo $case->name; }
+var_dump(EnumRtOrder::cases()[1] === EnumRtOrder::A);`,
+			expected: "ZAMbool(true)\n",
+		},
+		{
+			name: "from and tryFrom",
+			code: `enum EnumRtStatus: string {
+    case Active = 'active';
+    case Archived = 'archived';
+    const DEFAULT = self::Active;
+    public function label(): string { return ucfirst($this->value); }
+}
+enum EnumRtLevel: int { case Low = 1; case High = 10; }
+var_dump(EnumRtStatus::from('archived') === EnumRtStatus::Archived, EnumRtStatus::tryFrom('nope'));
+var_dump(EnumRtStatus::DEFAULT === EnumRtStatus::Active, EnumRtLevel::from('10') === EnumRtLevel::High);
+echo EnumRtStatus::Archived->label(), " ", EnumRtLevel::High->value, "\n";
+var_dump(EnumRtStatus::Active instanceof BackedEnum);`,
+			expected: "bool(true)\nNULL\nbool(true)\nbool(true)\nArchived 10\nbool(true)\n",
+		},
+		{
+			name: "catchable errors",
+			code: `enum EnumRtColor: string { case Red = 'red'; }
+enum EnumRtNumber: int { case One = 1; }
+try { EnumRtColor::from('blue'); } catch (ValueError $e) { echo $e->getMessage(), "\n"; }
+try { EnumRtNumber::from(2); } catch (ValueError $e) { echo $e->getMessage(), "\n"; }
+try { EnumRtNumber::from('two'); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+try { new EnumRtColor(); } catch (Error $e) { echo $e->getMessage(), "\n"; }
+try { clone EnumRtColor::Red; } catch (Error $e) { echo $e->getMessage(), "\n"; }
+$red = EnumRtColor::Red;
+try { $red->value = 'x'; } catch (Error $e) { echo $e->getMessage(), "\n"; }
+try { $red->shade = 'dark'; } catch (Error $e) { echo $e->getMessage(), "\n"; }
+echo $red->value, "\n";`,
+			expected: "\"blue\" is not a valid backing value for enum EnumRtColor\n" +
+				"2 is not a valid backing value for enum EnumRtNumber\n" +
+				"EnumRtNumber::from(): Argument #1 ($value) must be of type int, string given\n" +
+				"Cannot instantiate enum EnumRtColor\n" +
+				"Trying to clone an uncloneable object of class EnumRtColor\n" +
+				"Cannot modify readonly property EnumRtColor::$value\n" +
+				"Cannot create dynamic property EnumRtColor::$shade\n" +
+				"red\n",
+		},
+		{
+			name: "serialization",
+			code: `enum EnumRtState: string { case On = 'on'; case Off = 'off'; }
+enum EnumRtPure { case Only; }
+echo json_encode(['state' => EnumRtState::On, 'all' => EnumRtState::cases()]), "\n";
+var_dump(json_encode(EnumRtPure::Only));
+$s = serialize(EnumRtState::Off);
+echo $s, "\n";
+var_dump(unserialize($s) === EnumRtState::Off, unserialize('E:15:"EnumRtState:Dim";'));
+var_dump(enum_exists('EnumRtState'), enum_exists('Exception'), interface_exists('BackedEnum'));`,
+			expected: "{\"state\":\"on\",\"all\":[\"on\",\"off\"]}\nbool(false)\nE:15:\"EnumRtState:Off\";\nbool(true)\nbool(false)\nbool(true)\nbool(false)\nbool(true)\n",
+		},
+		{
+			name: "namespaced enum",
+			code: `namespace App\Model;
+interface HasColor { public function color(): string; }
+enum EnumRtCard: string implements HasColor {
+    case Hearts = 'H';
+    public function color(): string { return 'Red'; }
+    public static function fromChar(string $c): self { return self::from($c); }
+}
+$c = EnumRtCard::fromChar('H');
+echo get_class($c), " ", $c->color(), "\n";
+var_dump($c instanceof HasColor, $c === \App\Model\EnumRtCard::Hearts);`,
+			expected: "App\\Model\\EnumRtCard Red\nbool(true)\nbool(true)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := compileAndExecute(t, "<?php\n"+tt.code)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, output)
+		})
+	}
+}
+
+// TestEnumCompileErrors checks the declarations PHP rejects at compile time
+func TestEnumCompileErrors(t *testing.T) {
+	tests := []struct {
+		code string
+		err  string
+	}{
+		{`enum E1: float { case A = 1.5; }`, "enum backing type must be int or string, float given"},
+		{`enum E2 { case A = 1; }`, "case A of non-backed enum E2 must not have a value"},
+		{`enum E3: int { case A; }`, "case A of backed enum E3 must have a value"},
+		{`enum E4: int { case A = 'a'; }`, "enum case type string does not match enum backing type int"},
+		{`enum E5: string { case A = 'x'; case B = 'x'; }`, "duplicate value in enum E5 for cases A and B"},
+		{`enum E6 { case A; case A; }`, "cannot redefine class constant E6::A"},
+		{`enum E7 { case A; public function __construct() {} }`, "enum E7 cannot include magic method __construct"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err, func(t *testing.T) {
+			p := parser.New(lexer.New("<?php\n" + tt.code))
+			prog := p.ParseProgram()
+			require.Empty(t, p.Errors(), "Parser errors: %v", p.Errors())
+
+			err := NewCompiler().Compile(prog)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package compiler
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/registry"
+	runtime2 "github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vm"
+)
+
+// TestFibers covers switching between fibers and their resumers, including
+// suspension from nested calls, and the FiberError state checks
+func TestFibers(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "start suspend resume",
+			code: `$f = new Fiber(function (string $greeting) {
+    $name = Fiber::suspend("$greeting?");
+    $age = Fiber::suspend("name=$name");
+    return "$name is $age";
+});
+var_dump($f->isStarted());
+echo $f->start("hi"), "\n";
+var_dump($f->isSuspended(), $f->isRunning());
+echo $f->resume("Ann"), "\n";
+var_dump($f->resume(30), $f->isTerminated());
+echo $f->getReturn(), "\n";`,
+			expected: "bool(false)\nhi?\nbool(true)\nbool(false)\nname=Ann\nNULL\nbool(true)\nAnn is 30\n",
+		},
+		{
+			name: "suspend from nested calls",
+			code: `function fiberTestPull($n) { return Fiber::suspend($n) * 2; }
+class FiberTestWorker {
+    public function run($limit) {
+        $sum = 0;
+        for ($i = 1; $i <= $limit; $i++) { $sum += fiberTestPull($i); }
+        return $sum + array_sum(array_map(fn($x) => Fiber::suspend("map$x"), [1, 2]));
+    }
+}
+$f = new Fiber([new FiberTestWorker(), 'run']);
+echo $f->start(3);
+while (!$f->isTerminated()) { echo ",", $f->resume(10); }
+echo " => ", $f->getReturn(), "\n";`,
+			expected: "1,2,3,map1,map2, => 80\n",
+		},
+		{
+			name: "exceptions",
+			code: `$f = new Fiber(function () {
+    try { Fiber::suspend(1); } catch (LogicException $e) { echo "inside: ", $e->getMessage(), "\n"; }
+    Fiber::suspend(2);
+    throw new RuntimeException("escaped");
+});
+$f->start();
+var_dump($f->throw(new LogicException("thrown in")));
+try { $f->resume(); } catch (RuntimeException $e) { echo "resumer: ", $e->getMessage(), "\n"; }
+try { $f->getReturn(); } catch (FiberError $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "inside: thrown in\nint(2)\nresumer: escaped\nCannot get fiber return value: The fiber threw an exception\n",
+		},
+		{
+			name: "invalid state changes",
+			code: `$f = new Fiber(function () { Fiber::suspend(); });
+try { $f->resume(); } catch (FiberError $e) { echo get_class($e), ": ", $e->getMessage(), "\n"; }
+try { $f->getReturn(); } catch (FiberError $e) { echo $e->getMessage(), "\n"; }
+$f->start();
+try { $f->start(); } catch (FiberError $e) { echo $e->getMessage(), "\n"; }
+try { $f->getReturn(); } catch (FiberError $e) { echo $e->getMessage(), "\n"; }
+try { Fiber::suspend(); } catch (Error $e) { echo $e->getMessage(), "\n"; }
+$f->resume();
+try { $f->resume(); } catch (FiberError $e) { echo $e->getMessage(), "\n"; }
+try { new Fiber('fiberTestMissing'); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "FiberError: Cannot resume a fiber that is not suspended\n" +
+				"Cannot get fiber return value: The fiber has not been started\n" +
+				"Cannot start a fiber that has already been started\n" +
+				"Cannot get fiber return value: The fiber has not returned\n" +
+				"Cannot suspend outside of fiber\n" +
+				"Cannot resume a fiber that is not suspended\n" +
+				"Fiber::__construct(): Argument #1 ($callback) must be a valid callback, function \"fiberTestMissing\" not found or invalid function name\n",
+		},
+		{
+			name: "current and nested fibers",
+			code: `var_dump(Fiber::getCurrent());
+$outer = new Fiber(function () use (&$outer) {
+    $inner = new Fiber(function () {
+        $self = Fiber::getCurrent();
+        return Fiber::suspend($self) . "!";
+    });
+    var_dump($inner->start() === $inner, Fiber::getCurrent() === $outer);
+    $inner->resume(Fiber::suspend("outer suspended"));
+    return $inner->getReturn();
+});
+echo $outer->start(), "\n";
+$outer->resume("done");
+echo $outer->getReturn(), "\n";`,
+			expected: "NULL\nbool(true)\nbool(true)\nouter suspended\ndone!\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := compileAndExecute(t, "<?php\n"+tt.code)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, output)
+		})
+	}
+}
+
+// TestFiberTeardown verifies that fibers still suspended when the request
+// ends run their finally blocks and end their goroutines
+func TestFiberTeardown(t *testing.T) {
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime2.Bootstrap())
+	if runtime2.GlobalVMIntegration == nil {
+		require.NoError(t, runtime2.InitializeVMIntegration())
+	}
+
+	code := `<?php
+function fiberTeardownWait($i) {
+    try {
+        Fiber::suspend($i);
+        echo "resumed $i\n";
+    } catch (Throwable $e) {
+        echo "caught $i\n";
+    } finally {
+        echo "finally $i\n";
+        try { Fiber::suspend(); } catch (FiberError $e) { echo $e->getMessage(), "\n"; }
+    }
+}
+for ($i = 0; $i < 2; $i++) {
+    $f = new Fiber('fiberTeardownWait');
+    echo $f->start($i), "\n";
+}
+echo "end\n";`
+
+	comp, err := parseAndCompileOnly(t, code)
+	require.NoError(t, err)
+
+	vmCtx := vm.NewExecutionContext()
+	var buf bytes.Buffer
+	vmCtx.SetOutputWriter(&buf)
+
+	before := runtime.NumGoroutine()
+	vmachine := vm.NewVirtualMachine()
+	err = vmachine.Execute(vmCtx, comp.GetBytecode(), comp.GetConstants(), comp.Functions(), comp.Classes(), comp.Interfaces(), comp.Traits())
+	require.NoError(t, err)
+	vmachine.CallAllDestructors(vmCtx)
+	require.Equal(t, "0\n1\nend\n"+
+		"finally 0\nCannot suspend in a force-closed fiber\n"+
+		"finally 1\nCannot suspend in a force-closed fiber\n", buf.String())
+
+	// Their goroutines end right after handing back control
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
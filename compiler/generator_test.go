@@ -0,0 +1,278 @@
+package compiler
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/registry"
+	runtime2 "github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vm"
+)
+
+// TestGeneratorAPI covers send(), throw() and getReturn(), the result of
+// yield from, key handling and the errors of misused generators
+func TestGeneratorAPI(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "send and getReturn",
+			code: `function genApiLogger() {
+    $count = 0;
+    while (($line = yield $count) !== "end") {
+        echo "log: $line\n";
+        $count++;
+    }
+    return $count;
+}
+$g = genApiLogger();
+echo $g->current(), "\n";
+echo $g->send("first"), "\n";
+echo $g->send("second"), "\n";
+$g->send("end");
+var_dump($g->valid(), $g->current(), $g->getReturn());`,
+			expected: "0\nlog: first\n1\nlog: second\n2\nbool(false)\nNULL\nint(2)\n",
+		},
+		{
+			name: "send runs to the first yield",
+			code: `function genApiFirst() {
+    echo "start\n";
+    $x = yield 1;
+    echo "got $x\n";
+    yield 2;
+}
+$g = genApiFirst();
+echo $g->send("a"), "\n";`,
+			expected: "start\ngot a\n2\n",
+		},
+		{
+			name: "throw",
+			code: `function genApiCatcher() {
+    while (true) {
+        try {
+            yield "waiting";
+        } catch (InvalidArgumentException $e) {
+            echo "handled ", $e->getMessage(), "\n";
+        }
+    }
+}
+$g = genApiCatcher();
+echo $g->throw(new InvalidArgumentException("one")), "\n";
+try {
+    $g->throw(new RuntimeException("two"));
+} catch (RuntimeException $e) {
+    echo "escaped ", $e->getMessage(), "\n";
+}
+var_dump($g->valid());
+try {
+    $g->throw(new LogicException("three"));
+} catch (LogicException $e) {
+    echo "finished ", $e->getMessage(), "\n";
+}`,
+			expected: "handled one\nwaiting\nescaped two\nbool(false)\nfinished three\n",
+		},
+		{
+			name: "yield from returns the inner return value",
+			code: `function genApiInner($n) {
+    $sum = 0;
+    for ($i = 1; $i <= $n; $i++) {
+        $sum += yield $i;
+    }
+    return $sum;
+}
+function genApiOuter() {
+    $a = yield from genApiInner(2);
+    $b = yield from genApiInner(1);
+    return $a + $b;
+}
+$g = genApiOuter();
+foreach ($g as $k => $v) {
+    echo "$k=$v\n";
+    $g->send($v * 10);
+}
+$g = genApiOuter();
+$g->current();
+while ($g->valid()) {
+    $g->send($g->current() * 10);
+}
+echo $g->getReturn(), "\n";
+function genApiArray() { $r = yield from ["x" => 1, "y" => 2]; var_dump($r); yield 3; }
+foreach (genApiArray() as $k => $v) echo "$k=$v\n";`,
+			expected: "0=1\n0=1\n40\nx=1\ny=2\nNULL\n0=3\n",
+		},
+		{
+			name: "yield from iterators",
+			code: `class GenApiPairs implements IteratorAggregate {
+    public function getIterator(): Iterator { return new ArrayIterator(["p" => 1, "q" => 2]); }
+}
+function genApiIterators() {
+    $r = yield from new ArrayIterator([5, 6]);
+    var_dump($r);
+    yield from new GenApiPairs();
+    try {
+        yield from new ArrayIterator(["t" => 7]);
+    } catch (Exception $e) {
+        echo "caught ", $e->getMessage(), "\n";
+    }
+}
+$g = genApiIterators();
+foreach ($g as $k => $v) {
+    echo "$k=$v\n";
+    if ($v === 7) { $g->throw(new Exception("thrown")); }
+}`,
+			expected: "0=5\n1=6\nNULL\np=1\nq=2\nt=7\ncaught thrown\n",
+		},
+		{
+			name: "keys",
+			code: `function genApiKeys() {
+    yield "a";
+    yield 10 => "b";
+    yield "c";
+    yield "k" => "d";
+    yield 3 => "e";
+    yield "f";
+}
+foreach (genApiKeys() as $k => $v) echo "$k=$v\n";`,
+			expected: "0=a\n10=b\n11=c\nk=d\n3=e\n12=f\n",
+		},
+		{
+			name: "methods and closures",
+			code: `class GenApiBag {
+    private $items = ["p", "q"];
+    public function each() { foreach ($this->items as $i => $item) { yield $i => strtoupper($item); } }
+}
+foreach ((new GenApiBag())->each() as $k => $v) echo "$k=$v\n";
+$range = function (int $from, int $to) { for ($i = $from; $i <= $to; $i++) { yield $i; } };
+foreach ($range("2", 3) as $v) echo $v, "\n";`,
+			expected: "0=P\n1=Q\n2\n3\n",
+		},
+		{
+			name: "errors",
+			code: `function genApiTwo() { yield 1; yield 2; return 3; }
+$g = genApiTwo();
+try { $g->getReturn(); } catch (Exception $e) { echo $e->getMessage(), "\n"; }
+$g->next();
+try { $g->rewind(); } catch (Exception $e) { echo $e->getMessage(), "\n"; }
+while ($g->valid()) { $g->next(); }
+try { foreach ($g as $v) {} } catch (Exception $e) { echo $e->getMessage(), "\n"; }
+function genApiBadFrom() { yield from 42; }
+try { genApiBadFrom()->current(); } catch (Error $e) { echo $e->getMessage(), "\n"; }
+function genApiSelf() { global $genApiSelf; yield 1; $genApiSelf->next(); }
+$genApiSelf = genApiSelf();
+$genApiSelf->current();
+try { $genApiSelf->next(); } catch (Error $e) { echo $e->getMessage(), "\n"; }
+function genApiFails() { yield 1; throw new DomainException("failed"); }
+try { foreach (genApiFails() as $v) echo $v, "\n"; } catch (DomainException $e) { echo "caught ", $e->getMessage(), "\n"; }`,
+			expected: "Cannot get return value of a generator that hasn't returned\n" +
+				"Cannot rewind a generator that was already run\n" +
+				"Cannot traverse an already closed generator\n" +
+				"Can use \"yield from\" only with arrays and Traversables\n" +
+				"Cannot resume an already running generator\n" +
+				"1\ncaught failed\n",
+		},
+		{
+			name: "generators inside fibers",
+			code: `function genApiTicks() { for ($i = 0; ; $i++) { yield $i; } }
+$f = new Fiber(function () {
+    foreach (genApiTicks() as $tick) {
+        if ($tick == 2) {
+            return "ticked";
+        }
+        Fiber::suspend($tick);
+    }
+});
+echo $f->start(), $f->resume(), "\n";
+$f->resume();
+echo $f->getReturn(), "\n";`,
+			expected: "01\nticked\n",
+		},
+		{
+			name: "finally runs when the last reference is dropped",
+			code: `function genApiFinally($n) {
+    try {
+        yield 1;
+        yield 2;
+    } finally {
+        echo "finally $n\n";
+    }
+}
+$g = genApiFinally(1); $g->current(); unset($g); echo "unset\n";
+$g = genApiFinally(2); $g->current(); $g = null; echo "overwritten\n";
+$g = genApiFinally(3); $h = $g; $g->current(); unset($g); echo "still held\n"; $h = 0; echo "dropped\n";
+function genApiFinallyScope() { $g = genApiFinally(4); $g->current(); }
+genApiFinallyScope(); echo "returned\n";
+foreach (genApiFinally(5) as $v) { break; } echo "foreach\n";`,
+			expected: "finally 1\nunset\nfinally 2\noverwritten\nstill held\nfinally 3\ndropped\n" +
+				"finally 4\nreturned\nfinally 5\nforeach\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := compileAndExecute(t, "<?php\n"+tt.code)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, output)
+		})
+	}
+}
+
+// TestGeneratorTeardown verifies that generators left suspended are torn
+// down, running their finally blocks and ending their goroutines
+func TestGeneratorTeardown(t *testing.T) {
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime2.Bootstrap())
+	if runtime2.GlobalVMIntegration == nil {
+		require.NoError(t, runtime2.InitializeVMIntegration())
+	}
+
+	code := `<?php
+function genTeardown($n) {
+    try {
+        yield $n;
+        yield $n + 1;
+    } finally {
+        if ($n === 0) { echo "finally $n\n"; }
+    }
+}
+for ($i = 1; $i <= 1000; $i++) {
+    foreach (genTeardown($i) as $v) { break; }
+}
+$dropped = genTeardown(0);
+$dropped->current();
+$dropped = null;
+gc_collect_cycles();
+echo "collected\n";
+$kept = genTeardown(0);
+echo $kept->current(), "\n";`
+
+	comp, err := parseAndCompileOnly(t, code)
+	require.NoError(t, err)
+
+	vmCtx := vm.NewExecutionContext()
+	var buf bytes.Buffer
+	vmCtx.SetOutputWriter(&buf)
+
+	before := runtime.NumGoroutine()
+	vmachine := vm.NewVirtualMachine()
+	err = vmachine.Execute(vmCtx, comp.GetBytecode(), comp.GetConstants(), comp.Functions(), comp.Classes(), comp.Interfaces(), comp.Traits())
+	require.NoError(t, err)
+	// Abandoned generators are torn down as more are started
+	require.Less(t, runtime.NumGoroutine()-before, 200)
+
+	// The rest are torn down when the request ends
+	vmachine.CallAllDestructors(vmCtx)
+	require.Equal(t, "finally 0\ncollected\n0\nfinally 0\n", buf.String())
+	// Their goroutines end right after handing back control
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
@@ -0,0 +1,213 @@
+package compiler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/pkg/fpm/opcache"
+	"github.com/wudi/hey/registry"
+	runtime2 "github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vm"
+	"github.com/wudi/hey/vmfactory"
+)
+
+// TestVMFactoryOpcache checks that scripts and their includes are compiled
+// once and then served from the opcache until they change
+func TestVMFactoryOpcache(t *testing.T) {
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime2.Bootstrap())
+	if runtime2.GlobalVMIntegration == nil {
+		require.NoError(t, runtime2.InitializeVMIntegration())
+	}
+
+	dir := t.TempDir()
+	mainFile := filepath.Join(dir, "index.php")
+	libFile := filepath.Join(dir, "lib.php")
+	require.NoError(t, os.WriteFile(mainFile, []byte(`<?php
+$greeting = include __DIR__ . '/lib.php';
+echo $greeting, " ", $name, "\n";`), 0644))
+	require.NoError(t, os.WriteFile(libFile, []byte(`<?php
+$name = "opcache";
+return "hello";`), 0644))
+
+	compiles := 0
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		compiles++
+		return NewCompiler()
+	})
+	cache := opcache.NewOpcacheManager(&opcache.OpcacheConfig{Enabled: true, MaxEntries: 10, ValidateTimestamps: true})
+	factory.SetOpcache(cache)
+
+	run := func() string {
+		compiled, err := factory.CompileFile(mainFile)
+		require.NoError(t, err)
+
+		vmCtx := vm.NewExecutionContext()
+		var buf bytes.Buffer
+		vmCtx.SetOutputWriter(&buf)
+		err = factory.CreateVM().Execute(vmCtx, compiled.Instructions, compiled.Constants,
+			compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	require.Equal(t, "hello opcache\n", run())
+	require.Equal(t, 2, compiles)
+	require.Equal(t, "hello opcache\n", run())
+	require.Equal(t, 2, compiles)
+	require.Equal(t, 2, cache.Stats()["cached_scripts"])
+
+	require.NoError(t, os.WriteFile(libFile, []byte(`<?php
+$name = "changed";
+return "hi";`), 0644))
+	require.Equal(t, "hi changed\n", run())
+	require.Equal(t, 3, compiles)
+
+	require.NoError(t, os.WriteFile(mainFile, []byte(`<?php echo "unterminated`), 0644))
+	_, err := factory.CompileFile(mainFile)
+	var parseErr *vmfactory.ParseError
+	require.ErrorAs(t, err, &parseErr)
+	require.NotEmpty(t, parseErr.Errors)
+}
+
+// TestVMFactoryFileCache checks that scripts served from the on-disk cache
+// behave exactly like freshly compiled ones
+func TestVMFactoryFileCache(t *testing.T) {
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime2.Bootstrap())
+	if runtime2.GlobalVMIntegration == nil {
+		require.NoError(t, runtime2.InitializeVMIntegration())
+	}
+
+	dir := t.TempDir()
+	mainFile := filepath.Join(dir, "index.php")
+	require.NoError(t, os.WriteFile(mainFile, []byte(`<?php
+interface FileCacheShape { public function area(): float; }
+trait FileCacheNamed {
+    public function name(): string { return get_class($this) . ":" . strtolower("SHAPE"); }
+}
+final class FileCacheCircle implements FileCacheShape {
+    use FileCacheNamed;
+    const PI = 3.5;
+    public static $defaults = [1.5, null, true, 'r' => "x"];
+    public function __construct(private float $r = 2.0) {}
+    public function area(): float { return self::PI * $this->r ** 2; }
+}
+enum FileCacheUnit: string {
+    case Cm = 'cm';
+    case Inch = 'in';
+    const DEFAULT = self::Cm;
+}
+function fileCacheTotal(...$shapes): float {
+    $sum = 0.0;
+    foreach ($shapes as $shape) { $sum += $shape->area(); }
+    return $sum;
+}
+$double = fn($x) => $x * 2;
+$c = new FileCacheCircle();
+echo $c->name(), " ", $c->area(), " ", fileCacheTotal($c, new FileCacheCircle(1.0)), " ", $double(21), "\n";
+$defaults = FileCacheCircle::$defaults;
+echo count($defaults), $defaults['r'], " ", __LINE__, "\n";
+var_dump(FileCacheUnit::from('in') === FileCacheUnit::Inch, FileCacheUnit::DEFAULT === FileCacheUnit::Cm);`), 0644))
+
+	cacheDir := filepath.Join(dir, "cache")
+	compiles := 0
+	run := func() string {
+		// Each run uses a fresh factory and cache, as a new process would
+		factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+			compiles++
+			return NewCompiler()
+		})
+		cache, err := opcache.NewFileCache(cacheDir)
+		require.NoError(t, err)
+		factory.SetOpcache(cache)
+
+		compiled, err := factory.CompileFile(mainFile)
+		require.NoError(t, err)
+
+		vmCtx := vm.NewExecutionContext()
+		var buf bytes.Buffer
+		vmCtx.SetOutputWriter(&buf)
+		err = factory.CreateVM().Execute(vmCtx, compiled.Instructions, compiled.Constants,
+			compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+		require.NoError(t, err)
+		return buf.String()
+	}
+
+	expected := "FileCacheCircle:shape 14 17.5 42\n4x 27\nbool(true)\nbool(true)\n"
+	require.Equal(t, expected, run())
+	require.Equal(t, 1, compiles)
+	require.Equal(t, expected, run())
+	require.Equal(t, 1, compiles)
+}
+
+// TestVMFactoryOpcacheConcurrent runs one cached script in concurrent
+// requests, which share its compiled classes
+func TestVMFactoryOpcacheConcurrent(t *testing.T) {
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime2.Bootstrap())
+	if runtime2.GlobalVMIntegration == nil {
+		require.NoError(t, runtime2.InitializeVMIntegration())
+	}
+
+	dir := t.TempDir()
+	mainFile := filepath.Join(dir, "index.php")
+	require.NoError(t, os.WriteFile(mainFile, []byte(`<?php
+class OpcacheConcurrentPoint {
+    const ORIGIN = 0;
+    public $x = 1;
+    private $y = 2;
+    public function sum() { return $this->x + $this->y + self::ORIGIN; }
+}
+$p = new OpcacheConcurrentPoint();
+$p->x = 40;
+echo $p->sum(), "\n";`), 0644))
+
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return NewCompiler()
+	})
+	factory.SetOpcache(opcache.NewOpcacheManager(&opcache.OpcacheConfig{Enabled: true, MaxEntries: 10}))
+
+	const requests = 16
+	machines := make([]*vm.VirtualMachine, requests)
+	for i := range machines {
+		machines[i] = factory.CreateVM()
+	}
+
+	var wg sync.WaitGroup
+	outputs := make([]string, requests)
+	errs := make([]error, requests)
+	for i := range machines {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			compiled, err := factory.CompileFile(mainFile)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			vmCtx := vm.NewExecutionContext()
+			var buf bytes.Buffer
+			vmCtx.SetOutputWriter(&buf)
+			errs[i] = machines[i].Execute(vmCtx, compiled.Instructions, compiled.Constants,
+				compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+			outputs[i] = buf.String()
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range machines {
+		require.NoError(t, errs[i])
+		require.Equal(t, "42\n", outputs[i])
+	}
+}
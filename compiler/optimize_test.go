@@ -0,0 +1,73 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/compiler/lexer"
+	"github.com/wudi/hey/compiler/parser"
+	"github.com/wudi/hey/optimizer"
+)
+
+// TestOptimizedCompilation checks that scripts compiled with the optimizer
+// enabled produce the same output in fewer instructions
+func TestOptimizedCompilation(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+		pass     string
+	}{
+		{
+			name:     "literal arithmetic and concatenation",
+			code:     `$a = 1 + 2 * 3; $s = "foo" . "bar" . 42; echo $a, " ", $s, " ", -5 + 1, "\n";`,
+			expected: "7 foobar42 -4\n",
+			pass:     optimizer.PassConstantFolding,
+		},
+		{
+			name:     "code after return",
+			code:     `function opt_test_early($x) { return $x * 2; echo "dead"; } echo opt_test_early(21), "\n"; return; echo "after";`,
+			expected: "42\n",
+			pass:     optimizer.PassDeadCode,
+		},
+		{
+			name:     "constant conditions",
+			code:     `if (false) { echo "never\n"; } while (true) { echo "once\n"; break; } echo "done\n";`,
+			expected: "once\ndone\n",
+			pass:     optimizer.PassConstantFolding,
+		},
+		{
+			name: "loops with continue and break",
+			code: `for ($i = 0; $i < 5; $i++) { if ($i == 1) { continue; } if ($i == 3) { break; } echo $i, "\n"; }
+$n = 0; do { $n++; } while ($n < 3); echo $n, "\n";`,
+			expected: "0\n2\n3\n",
+			pass:     optimizer.PassJumpThreading,
+		},
+		{
+			name:     "exception handlers",
+			code:     `try { throw new Exception("boom" . "!"); echo "dead"; } catch (Exception $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "boom!\n",
+			pass:     optimizer.PassDeadCode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parser.New(lexer.New("<?php\n" + tt.code))
+			prog := p.ParseProgram()
+			require.Empty(t, p.Errors(), "Parser errors: %v", p.Errors())
+
+			comp := NewCompiler()
+			comp.SetOptimize(true)
+			require.NoError(t, comp.Compile(prog))
+
+			stats := comp.OptimizationStats()
+			require.Positive(t, stats.PassStats[tt.pass])
+			require.Less(t, stats.OptimizedSize, stats.OriginalSize)
+
+			output, err := executeAndCaptureOutput(t, comp)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, output)
+		})
+	}
+}
@@ -351,6 +351,8 @@ type Parser struct {
 	currentToken lexer.Token
 	peekToken    lexer.Token
 	errors       []string
+	// docComment 保存最近跳过的文档注释，遇到 ; { } 时清空
+	docComment string
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -362,11 +364,13 @@ func New(l *lexer.Lexer) *Parser {
 	// 初始化时也需要跳过非语法token
 	p.currentToken = l.NextToken()
 	for isNonSyntacticToken(p.currentToken.Type) {
+		p.rememberDocComment(p.currentToken)
 		p.currentToken = l.NextToken()
 	}
 
 	p.peekToken = l.NextToken()
 	for isNonSyntacticToken(p.peekToken.Type) {
+		p.rememberDocComment(p.peekToken)
 		p.peekToken = l.NextToken()
 	}
 
@@ -380,14 +384,44 @@ func (p *Parser) addError(msg string) {
 // nextToken 前进到下一个语法有意义的token，自动跳过注释等无意义token
 func (p *Parser) nextToken() {
 	p.currentToken = p.peekToken
+	switch p.currentToken.Type {
+	case lexer.TOKEN_SEMICOLON, lexer.TOKEN_LBRACE, lexer.TOKEN_RBRACE:
+		// 文档注释只属于紧随其后的声明
+		p.docComment = ""
+	}
 	p.peekToken = p.lexer.NextToken()
 
 	// 自动跳过语法解析中无意义的token (仅对peekToken)
 	for isNonSyntacticToken(p.peekToken.Type) {
+		p.rememberDocComment(p.peekToken)
 		p.peekToken = p.lexer.NextToken()
 	}
 }
 
+// rememberDocComment 记录被跳过的文档注释，供随后的声明使用
+func (p *Parser) rememberDocComment(tok lexer.Token) {
+	if tok.Type == lexer.T_DOC_COMMENT {
+		p.docComment = tok.Value
+	}
+}
+
+// attachDocComment 将声明前的文档注释附加到函数、类和属性节点
+func attachDocComment(stmt ast.Statement, doc string) {
+	if doc == "" || stmt == nil {
+		return
+	}
+	switch node := stmt.(type) {
+	case *ast.FunctionDeclaration:
+		node.DocComment = doc
+	case *ast.PropertyDeclaration:
+		node.DocComment = doc
+	case *ast.ExpressionStatement:
+		if class, ok := node.Expression.(*ast.ClassExpression); ok {
+			class.DocComment = doc
+		}
+	}
+}
+
 // peekTokenAt 查看指定位置的 token（不跳过任何 token）
 func (p *Parser) peekTokenAt(offset int) lexer.Token {
 	// 保存当前词法分析器状态
@@ -816,7 +850,10 @@ func (p *Parser) noPrefixParseFnError(t lexer.TokenType) {
 }
 
 // parseStatement 解析语句
-func parseStatement(p *Parser) ast.Statement {
+func parseStatement(p *Parser) (stmt ast.Statement) {
+	doc := p.docComment
+	defer func() { attachDocComment(stmt, doc) }()
+
 	switch p.currentToken.Type {
 	case lexer.T_ATTRIBUTE:
 		// Handle attributed statements: #[Attr] class/function/etc.
@@ -5779,7 +5816,10 @@ func parseVisibilityStaticFunction(p *Parser, visibility string, pos lexer.Posit
 
 // parseClassStatement 解析类体内的语句（属性和方法）
 // 基于 PHP 官方语法实现：先识别修饰符序列，再根据后续 token 确定声明类型
-func parseClassStatement(p *Parser) ast.Statement {
+func parseClassStatement(p *Parser) (stmt ast.Statement) {
+	doc := p.docComment
+	defer func() { attachDocComment(stmt, doc) }()
+
 	switch p.currentToken.Type {
 	case lexer.T_ATTRIBUTE:
 		// Parse attributes for class members #[Attr] private $prop; #[Attr] public function() {}
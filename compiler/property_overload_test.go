@@ -0,0 +1,168 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPropertyOverloading verifies that __get, __set, __isset and __unset
+// handle undefined and inaccessible properties
+func TestPropertyOverloading(t *testing.T) {
+	const bag = `class OverloadBag {
+    private $data = [];
+    private $secret = "hidden";
+    public $real = "real";
+    public function __get($name) { echo "get $name\n"; return $this->data[$name] ?? null; }
+    public function __set($name, $value) { echo "set $name\n"; $this->data[$name] = $value; }
+    public function __isset($name) { echo "isset $name\n"; return isset($this->data[$name]); }
+    public function __unset($name) { echo "unset $name\n"; unset($this->data[$name]); }
+}
+$b = new OverloadBag();
+`
+
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "get and set undefined property",
+			code: bag + `$b->foo = 1;
+echo $b->foo, "\n";`,
+			expected: "set foo\nget foo\n1\n",
+		},
+		{
+			name: "declared public property bypasses magic",
+			code: bag + `echo $b->real, "\n";
+$b->real = "changed";
+echo $b->real, "\n";`,
+			expected: "real\nchanged\n",
+		},
+		{
+			name:     "private property is inaccessible from outside",
+			code:     bag + `var_dump($b->secret);`,
+			expected: "get secret\nNULL\n",
+		},
+		{
+			name: "parent private property is inaccessible from a subclass",
+			code: `class OverloadBase {
+    private $secret = "base secret";
+    public function __get($name) { return "magic $name"; }
+    public function own() { return $this->secret; }
+}
+class OverloadSub extends OverloadBase {
+    public function inherited() { return $this->secret; }
+}
+$s = new OverloadSub();
+echo $s->inherited(), "\n", $s->own(), "\n";`,
+			expected: "magic secret\nbase secret\n",
+		},
+		{
+			name: "inaccessible property without magic throws",
+			code: `class OverloadSealed {
+    private $x = "ax";
+    protected $y = "ay";
+}
+$o = new OverloadSealed();
+try { echo $o->x; } catch (Error $e) { echo $e->getMessage(), "\n"; }
+try { $o->x = 1; } catch (Error $e) { echo $e->getMessage(), "\n"; }
+try { echo $o->y; } catch (Error $e) { echo $e->getMessage(), "\n"; }
+try { $o->y .= "!"; } catch (Error $e) { echo $e->getMessage(), "\n"; }
+try { unset($o->x); } catch (Error $e) { echo $e->getMessage(), "\n"; }
+var_dump(isset($o->x));`,
+			expected: "Cannot access private property OverloadSealed::$x\n" +
+				"Cannot access private property OverloadSealed::$x\n" +
+				"Cannot access protected property OverloadSealed::$y\n" +
+				"Cannot access protected property OverloadSealed::$y\n" +
+				"Cannot access private property OverloadSealed::$x\n" +
+				"bool(false)\n",
+		},
+		{
+			name: "parent private property stays hidden from a subclass without magic",
+			code: `class OverloadHiddenBase {
+    private $x = "ax";
+    public function own() { return $this->x; }
+}
+class OverloadHiddenSub extends OverloadHiddenBase {
+    public function get() { return $this->x; }
+}
+$h = new OverloadHiddenSub();
+var_dump($h->get(), isset($h->x));
+echo $h->own(), "\n";`,
+			expected: "NULL\nbool(false)\nax\n",
+		},
+		{
+			name: "compound assignment reads and writes through magic",
+			code: bag + `$b->n = 1;
+$b->n += 5;
+$b->s = "a";
+$b->s .= "b";
+echo $b->n, $b->s, "\n";`,
+			expected: "set n\nget n\nset n\nset s\nget s\nset s\nget n\n6get s\nab\n",
+		},
+		{
+			name: "increment",
+			code: bag + `$b->n = 1;
+$b->n++;
+echo $b->n, "\n";`,
+			expected: "set n\nget n\nset n\nget n\n2\n",
+		},
+		{
+			name: "array append on overloaded property",
+			code: bag + `$b->list = [];
+$b->list[] = 1;
+echo count($b->list), "\n";`,
+			// __get returns a copy of the array, so the append is lost as in PHP
+			expected: "set list\nget list\nget list\n0\n",
+		},
+		{
+			name: "isset and unset",
+			code: bag + `$b->foo = 1;
+var_dump(isset($b->foo), isset($b->missing));
+unset($b->foo);
+var_dump(isset($b->foo));`,
+			expected: "set foo\nisset foo\nisset missing\nbool(true)\nbool(false)\nunset foo\nisset foo\nbool(false)\n",
+		},
+		{
+			name: "unset without __unset removes the property",
+			code: `class OverloadPlain { public $a = 1; }
+$p = new OverloadPlain();
+unset($p->a);
+var_dump(isset($p->a));`,
+			expected: "bool(false)\n",
+		},
+		{
+			name: "lazy initialisation after unset in constructor",
+			code: `class OverloadLazy {
+    public $value;
+    public function __construct() { unset($this->value); }
+    public function __get($name) { echo "init $name\n"; $this->$name = 42; return $this->$name; }
+}
+$l = new OverloadLazy();
+echo $l->value, "\n";
+echo $l->value, "\n";`,
+			expected: "init value\n42\n42\n",
+		},
+		{
+			name: "recursion guard reaches the real property",
+			code: `class OverloadGuard {
+    public function __get($name) { echo "get $name\n"; return $this->$name; }
+    public function __set($name, $value) { echo "set $name\n"; $this->$name = $value; }
+}
+$g = new OverloadGuard();
+var_dump($g->x);
+$g->y = 2;
+echo $g->y, "\n";`,
+			expected: "get x\nNULL\nset y\n2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := compileAndExecute(t, "<?php\n"+tt.code)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, output)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestReflection exercises the Reflection classes against user-defined
+// classes and functions
+func TestReflection(t *testing.T) {
+	const classes = `interface ReflTestShape { function area(): float; }
+/**
+ * Base shape.
+ */
+abstract class ReflTestBase implements ReflTestShape {
+    const SIDES = 0;
+    /** The x coordinate */
+    protected int $x = 1;
+    private $hidden;
+    public static $count = 0;
+    public function area(): float { return 0.0; }
+    abstract protected function describe();
+}
+final class ReflTestPoint extends ReflTestBase {
+    public readonly string $label;
+    public function __construct(public int $y = 2, ?string $label = null, int ...$rest) { $this->label = $label ?? "p"; }
+    /** Moves the point */
+    public function move(int|string $dx, $dy = [1, 2]): static { $this->x += $dx; return $this; }
+    protected function describe() { return "point"; }
+    private static function secret($a) { return "secret $a"; }
+}
+`
+
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "class information",
+			code: classes + `$rc = new ReflectionClass('ReflTestPoint');
+var_dump($rc->getName(), $rc->isFinal(), $rc->isAbstract(), $rc->isInstantiable());
+var_dump($rc->getParentClass()->getName(), $rc->getParentClass()->isAbstract());
+var_dump($rc->implementsInterface('ReflTestShape'), $rc->isSubclassOf('ReflTestBase'));
+var_dump($rc->getDocComment(), $rc->getParentClass()->getDocComment());
+var_dump($rc->hasProperty('hidden'), $rc->getParentClass()->hasProperty('hidden'));
+var_dump($rc->getConstant('SIDES'), (new ReflectionClass('ReflTestShape'))->isInterface());`,
+			expected: "string(13) \"ReflTestPoint\"\nbool(true)\nbool(false)\nbool(true)\n" +
+				"string(12) \"ReflTestBase\"\nbool(true)\n" +
+				"bool(true)\nbool(true)\n" +
+				"bool(false)\nstring(22) \"/**\\n * Base shape.\\n */\"\n" +
+				"bool(false)\nbool(true)\n" +
+				"int(0)\nbool(true)\n",
+		},
+		{
+			name: "methods and modifiers",
+			code: classes + `$rc = new ReflectionClass('ReflTestPoint');
+foreach ($rc->getMethods() as $m) { echo $m->class, "::", $m->getName(), " ", $m->getModifiers(), "\n"; }
+echo count($rc->getMethods(ReflectionMethod::IS_STATIC)), "\n";
+$m = $rc->getMethod('move');
+var_dump($m->getDocComment(), $m->getReturnType()->getName(), $m->isPublic(), $m->isStatic());`,
+			expected: "ReflTestPoint::__construct 1\nReflTestPoint::move 1\nReflTestPoint::describe 2\nReflTestPoint::secret 20\nReflTestBase::area 1\n" +
+				"1\n" +
+				"string(22) \"/** Moves the point */\"\nstring(6) \"static\"\nbool(true)\nbool(false)\n",
+		},
+		{
+			name: "members in declaration order",
+			code: `trait ReflTestOrderTrait { public $fromTrait; function traitMethod() {} }
+class ReflTestOrderBase { const Z = 1; public $zulu; function zulu() {} }
+class ReflTestOrder extends ReflTestOrderBase {
+    const C = 1;
+    const A = 2;
+    public $pear;
+    use ReflTestOrderTrait;
+    public $apple;
+    function pear() {}
+    function apple() {}
+}
+$rc = new ReflectionClass('ReflTestOrder');
+echo implode(" ", array_map(fn($m) => $m->getName(), $rc->getMethods())), "\n";
+echo implode(" ", array_map(fn($p) => $p->getName(), $rc->getProperties())), "\n";
+echo implode(" ", array_keys($rc->getConstants())), "\n";`,
+			expected: "pear apple traitMethod zulu\n" +
+				"pear apple fromTrait zulu\n" +
+				"C A Z\n",
+		},
+		{
+			name: "parameters",
+			code: classes + `foreach ((new ReflectionClass('ReflTestPoint'))->getConstructor()->getParameters() as $p) {
+    echo $p->getPosition(), " ", $p->getName(), " ", $p->getType()->getName(), " ";
+    echo var_export($p->isOptional(), true), " ", var_export($p->isVariadic(), true), " ", var_export($p->allowsNull(), true);
+    echo " ", var_export($p->isPromoted(), true);
+    if ($p->isDefaultValueAvailable()) { echo " ", var_export($p->getDefaultValue(), true); }
+    echo "\n";
+}
+$params = (new ReflectionMethod('ReflTestPoint::move'))->getParameters();
+echo get_class($params[0]->getType()), " ", var_export($params[1]->hasType(), true), " ", var_export($params[0]->isPromoted(), true), "\n";`,
+			expected: "0 y int true false false true 2\n1 label string true false true false NULL\n2 rest int true true false false\n" +
+				"ReflectionUnionType false false\n",
+		},
+		{
+			name: "instantiate and invoke",
+			code: classes + `$rc = new ReflectionClass('ReflTestPoint');
+$p = $rc->newInstanceArgs([5, "hello"]);
+var_dump($p->y, $p->label, $rc->newInstance(7)->y);
+var_dump((new ReflectionMethod('ReflTestPoint', 'secret'))->invoke(null, "x"));
+var_dump($rc->getMethod('move')->invoke($p, 3) === $p);
+try { $rc->getMethod('move')->invoke(null, 1); } catch (ReflectionException $e) { echo $e->getMessage(), "\n"; }
+try { (new ReflectionClass('ReflTestBase'))->newInstance(); } catch (Error $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "int(5)\nstring(5) \"hello\"\nint(7)\nstring(8) \"secret x\"\nbool(true)\n" +
+				"Trying to invoke non static method ReflTestPoint::move() without an object\n" +
+				"Cannot instantiate abstract class ReflTestBase\n",
+		},
+		{
+			name: "properties",
+			code: classes + `$p = new ReflTestPoint();
+$x = new ReflectionProperty('ReflTestPoint', 'x');
+var_dump($x->getValue($p), $x->getDocComment(), $x->getType()->getName(), $x->isProtected());
+$x->setValue($p, 10);
+var_dump($x->getValue($p));
+$label = new ReflectionProperty($p, 'label');
+var_dump($label->isReadOnly(), $label->getModifiers());
+try { $label->setValue($p, "z"); } catch (Error $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "int(1)\nstring(23) \"/** The x coordinate */\"\nstring(3) \"int\"\nbool(true)\n" +
+				"int(10)\n" +
+				"bool(true)\nint(129)\n" +
+				"Cannot modify readonly property ReflTestPoint::$label\n",
+		},
+		{
+			name: "functions and closures",
+			code: `/** Adds two numbers */
+function refl_test_add(int $a, int $b = 3): int { return $a + $b; }
+$f = new ReflectionFunction('refl_test_add');
+var_dump($f->getName(), $f->getNumberOfParameters(), $f->getNumberOfRequiredParameters(), $f->getDocComment());
+var_dump($f->invoke(1), $f->invokeArgs([1, 1]));
+$c = new ReflectionFunction(function (int $n) { return $n * 2; });
+var_dump($c->getName(), $c->isClosure(), $c->getParameters()[0]->getName(), $c->invoke(21));`,
+			expected: "string(13) \"refl_test_add\"\nint(2)\nint(1)\nstring(23) \"/** Adds two numbers */\"\n" +
+				"int(4)\nint(2)\n" +
+				"string(9) \"{closure}\"\nbool(true)\nstring(1) \"n\"\nint(42)\n",
+		},
+		{
+			name: "missing symbols throw ReflectionException",
+			code: `try { new ReflectionClass('ReflTestMissing'); } catch (ReflectionException $e) { echo $e->getMessage(), "\n"; }
+try { new ReflectionFunction('refl_test_missing'); } catch (ReflectionException $e) { echo $e->getMessage(), "\n"; }
+try { new ReflectionMethod('ArrayObject', 'nope'); } catch (ReflectionException $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "Class \"ReflTestMissing\" does not exist\nFunction refl_test_missing() does not exist\nMethod ArrayObject::nope() does not exist\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := compileAndExecute(t, "<?php\n"+tt.code)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, output)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package compiler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/registry"
+	runtime2 "github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vm"
+	"github.com/wudi/hey/vmfactory"
+)
+
+// TestTypeDeclarations covers weak-mode coercion and strict_types checks of
+// parameters, return values and typed properties
+func TestTypeDeclarations(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "weak mode coerces scalars",
+			code: `function typesWeakF(int $i, float $f, string $s, bool $b, ?int $n = null) { var_dump($i, $f, $s, $b, $n); }
+typesWeakF("5", 2, 3.5, 1);
+function typesWeakUnion(int|float $a, int|string $b) { var_dump($a, $b); }
+typesWeakUnion("1.5", 2.5);
+$double = fn(int $x = 3): string => $x * 2;
+var_dump($double(), $double("4"));
+var_dump(array_map(fn(int $v) => $v + 1, ["1", "2"]));`,
+			expected: "int(5)\nfloat(2)\nstring(3) \"3.5\"\nbool(true)\nNULL\n" +
+				"float(1.5)\nstring(3) \"2.5\"\n" +
+				"string(1) \"6\"\nstring(1) \"8\"\n" +
+				"array(2) {\n  [0]=>\n  int(2)\n  [1]=>\n  int(3)\n}\n",
+		},
+		{
+			name: "weak mode errors are catchable",
+			code: `function typesWeakErr(int $x) { return $x; }
+try { typesWeakErr("abc"); } catch (TypeError $e) { echo get_class($e), ": ", $e->getMessage(), "\n"; }
+try { typesWeakErr(null); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+function typesWeakRet(): int { return "x"; }
+try { typesWeakRet(); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+function typesWeakVariadic(int ...$xs) { var_dump($xs); }
+typesWeakVariadic(1, "2");
+try { typesWeakVariadic(1, []); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "TypeError: typesWeakErr(): Argument #1 ($x) must be of type int, string given\n" +
+				"typesWeakErr(): Argument #1 ($x) must be of type int, null given\n" +
+				"typesWeakRet(): Return value must be of type int, string returned\n" +
+				"array(2) {\n  [0]=>\n  int(1)\n  [1]=>\n  int(2)\n}\n" +
+				"typesWeakVariadic(): Argument #2 ($xs) must be of type int, array given\n",
+		},
+		{
+			name: "strict mode",
+			code: `declare(strict_types=1);
+function typesStrictF(int $x): float { return $x; }
+var_dump(typesStrictF(3));
+try { typesStrictF("3"); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+try { typesStrictF(1.0); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+function typesStrictRet(): string { return 1; }
+try { typesStrictRet(); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "float(3)\n" +
+				"typesStrictF(): Argument #1 ($x) must be of type int, string given\n" +
+				"typesStrictF(): Argument #1 ($x) must be of type int, float given\n" +
+				"typesStrictRet(): Return value must be of type string, int returned\n",
+		},
+		{
+			name: "strict mode builtins",
+			code: `declare(strict_types=1);
+try { strlen(5); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+try { str_repeat("a", "3"); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+var_dump(strlen("abc"), str_repeat("ab", 2), max(1, 2.5));`,
+			expected: "strlen(): Argument #1 ($str) must be of type string, int given\n" +
+				"str_repeat(): Argument #2 ($multiplier) must be of type int, string given\n" +
+				"int(3)\nstring(4) \"abab\"\nfloat(2.5)\n",
+		},
+		{
+			name: "strict mode callbacks",
+			code: `declare(strict_types=1);
+try { array_map("strlen", [5]); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+var_dump(array_map("strlen", ["abc"]));
+$typesStrictClosure = function (int $x) { return $x; };
+try { $typesStrictClosure("1"); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "strlen(): Argument #1 ($str) must be of type string, int given\n" +
+				"array(1) {\n  [0]=>\n  int(3)\n}\n" +
+				"{closure}(): Argument #1 ($x) must be of type int, string given\n",
+		},
+		{
+			name:     "weak mode builtins",
+			code:     `var_dump(strlen(5), str_repeat("a", "3"));`,
+			expected: "int(1)\nstring(3) \"aaa\"\n",
+		},
+		{
+			name: "typed properties",
+			code: `class TypesNode { public int $n = 0; public ?TypesNode $next = null; public float $f = 0.0; }
+$node = new TypesNode();
+$node->n = "12";
+$node->f = 2;
+var_dump($node->n, $node->f);
+try { $node->n = "twelve"; } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+try { $node->next = new stdClass(); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+$node->next = new TypesNode();
+$node->n += 1;
+var_dump($node->n);`,
+			expected: "int(12)\nfloat(2)\n" +
+				"Cannot assign string to property TypesNode::$n of type int\n" +
+				"Cannot assign stdClass to property TypesNode::$next of type ?TypesNode\n" +
+				"int(13)\n",
+		},
+		{
+			name: "class types",
+			code: `namespace App\Types;
+interface TypesShape {}
+interface TypesNamed extends TypesShape {}
+class TypesBase implements TypesNamed {
+    public static function make(): static { return new static(); }
+    public function me(): self { return $this; }
+}
+class TypesChild extends TypesBase {}
+function typesArea(TypesShape $s): string { return get_class($s); }
+echo typesArea(new TypesChild()), " ", get_class(TypesChild::make()), " ", get_class((new TypesChild())->me()), "\n";
+function typesCall(callable $c, iterable $items): array { return array_map($c, $items); }
+echo implode(",", typesCall(fn($x) => $x * 2, [1, 2])), "\n";
+try { typesArea(new \stdClass()); } catch (\TypeError $e) { echo $e->getMessage(), "\n"; }`,
+			expected: "App\\Types\\TypesChild App\\Types\\TypesChild App\\Types\\TypesChild\n2,4\n" +
+				"App\\Types\\typesArea(): Argument #1 ($s) must be of type App\\Types\\TypesShape, stdClass given\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := compileAndExecute(t, "<?php\n"+tt.code)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, output)
+		})
+	}
+}
+
+// TestStrictTypesPerFile checks that strict_types follows the file making
+// the call, not the file declaring the function
+func TestStrictTypesPerFile(t *testing.T) {
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime2.Bootstrap())
+	if runtime2.GlobalVMIntegration == nil {
+		require.NoError(t, runtime2.InitializeVMIntegration())
+	}
+
+	dir := t.TempDir()
+	mainFile := filepath.Join(dir, "main.php")
+	require.NoError(t, os.WriteFile(mainFile, []byte(`<?php
+declare(strict_types=1);
+require __DIR__ . '/lib.php';
+function strictFileTarget(int $x): int { return $x; }
+try { weakFileTarget("1"); } catch (TypeError $e) { echo "strict caller\n"; }
+var_dump(weakFileReturn(), weakFileCaller());
+try { strictFileTarget("2"); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "lib.php"), []byte(`<?php
+function weakFileTarget(int $x) { return $x; }
+function weakFileReturn(): int { return "5"; }
+function weakFileCaller() { return strictFileTarget("9"); }
+`), 0644))
+
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return NewCompiler()
+	})
+	// Messages name the file by its absolute path however it was given
+	t.Chdir(dir)
+	compiled, err := factory.CompileFile("main.php")
+	require.NoError(t, err)
+
+	vmCtx := vm.NewExecutionContext()
+	var buf bytes.Buffer
+	vmCtx.SetOutputWriter(&buf)
+	err = factory.CreateVM().Execute(vmCtx, compiled.Instructions, compiled.Constants,
+		compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+	require.NoError(t, err)
+	require.Equal(t, "strict caller\nint(5)\nint(9)\n"+
+		"strictFileTarget(): Argument #1 ($x) must be of type int, string given, called in "+mainFile+" on line 7\n", buf.String())
+}
@@ -0,0 +1,175 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestWeakReferences covers WeakReference and WeakMap, including the release
+// of their objects by gc_collect_cycles(), and the ArrayAccess and
+// IteratorAggregate support they rely on
+func TestWeakReferences(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{
+			name: "WeakReference",
+			code: `function weakRefs() {
+    $o = new stdClass();
+    $ref = WeakReference::create($o);
+    var_dump($ref->get() === $o, WeakReference::create($o) === $ref);
+    return $ref;
+}
+$ref = weakRefs();
+gc_collect_cycles();
+var_dump($ref->get());`,
+			expected: "bool(true)\nbool(true)\nNULL\n",
+		},
+		{
+			name: "WeakMap entries",
+			code: `class WeakEntity { public $id; function __construct($id) { $this->id = $id; } }
+class WeakCache {
+    private WeakMap $meta;
+    function __construct() { $this->meta = new WeakMap(); }
+    function tag(object $o, string $t) { $this->meta[$o] = $t; }
+    function size() { return count($this->meta); }
+    function tags() {
+        $out = [];
+        foreach ($this->meta as $o => $t) { $out[] = $o->id . "=" . $t; }
+        return implode(",", $out);
+    }
+}
+function fill(WeakCache $c) {
+    $keep = new WeakEntity(1);
+    $c->tag($keep, "kept");
+    $c->tag(new WeakEntity(2), "temp");
+    echo $c->size(), " ", $c->tags(), "\n";
+    return $keep;
+}
+$c = new WeakCache();
+$kept = fill($c);
+gc_collect_cycles();
+echo $c->size(), " ", $c->tags(), "\n";
+
+function lookups() {
+    $m = new WeakMap();
+    $a = new stdClass();
+    $b = new stdClass();
+    $m[$a] = 1;
+    $m[$b] = null;
+    var_dump(isset($m[$a]), isset($m[$b]), $m->offsetExists($b), $m[$a]);
+    unset($m[$a]);
+    echo count($m), "\n";
+}
+lookups();`,
+			expected: "2 1=kept,2=temp\n1 1=kept\nbool(true)\nbool(false)\nbool(false)\nint(1)\n1\n",
+		},
+		{
+			name: "released by unset and null",
+			code: `$o = new stdClass();
+$ref = WeakReference::create($o);
+$m = new WeakMap();
+$m[$o] = "global";
+unset($o);
+gc_collect_cycles();
+var_dump($ref->get(), count($m));
+$p = new stdClass();
+$ref = WeakReference::create($p);
+$m[$p] = "global";
+$p = null;
+gc_collect_cycles();
+var_dump($ref->get(), count($m));
+function releases(WeakMap $m) {
+    $o = new stdClass();
+    $ref = WeakReference::create($o);
+    $m[$o] = "local";
+    unset($o);
+    gc_collect_cycles();
+    var_dump($ref->get(), count($m));
+    $p = new stdClass();
+    $ref = WeakReference::create($p);
+    $m[$p] = "local";
+    foreach ([$p] as $item) {}
+    $p = $item = null;
+    gc_collect_cycles();
+    var_dump($ref->get(), count($m));
+}
+releases($m);`,
+			expected: "NULL\nint(0)\nNULL\nint(0)\nNULL\nint(0)\nNULL\nint(0)\n",
+		},
+		{
+			name: "released after conditions and call arguments",
+			code: `function weakSame($o) { return $o; }
+function weakCheck(WeakReference $ref, WeakMap $m) {
+    gc_collect_cycles();
+    var_dump($ref->get(), count($m));
+}
+$m = new WeakMap();
+$o = new stdClass();
+$ref = WeakReference::create($o);
+$m[$o] = "if";
+if (weakSame($o)) { echo "if\n"; }
+unset($o);
+weakCheck($ref, $m);
+$o = new stdClass();
+$ref = WeakReference::create($o);
+$m[$o] = "while";
+while (weakSame($o) === null) {}
+switch (weakSame($o)) { default: echo "switch\n"; }
+unset($o);
+weakCheck($ref, $m);
+$o = new stdClass();
+$ref = WeakReference::create($o);
+$m[$o] = "arguments";
+$name = strtolower(get_class(weakSame(weakSame($o))));
+weakSame(weakSame($o));
+unset($o);
+weakCheck($ref, $m);`,
+			expected: "if\nNULL\nint(0)\nswitch\nNULL\nint(0)\nNULL\nint(0)\n",
+		},
+		{
+			name: "errors",
+			code: `$m = new WeakMap();
+try { $m["key"] = 1; } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+try { $m[] = 1; } catch (Error $e) { echo $e->getMessage(), "\n"; }
+try { $m[new stdClass()]; } catch (Error $e) { echo $e->getMessage(), "\n"; }
+try { new WeakReference(); } catch (Error $e) { echo $e->getMessage(), "\n"; }
+try { WeakReference::create(1); } catch (TypeError $e) { echo $e->getMessage(), "\n"; }
+var_dump($m instanceof Countable, $m instanceof ArrayAccess, $m instanceof IteratorAggregate);`,
+			expected: "WeakMap key must be an object\nCannot append to WeakMap\n" +
+				"Object stdClass not contained in WeakMap\n" +
+				"Direct instantiation of WeakReference is not allowed, use WeakReference::create instead\n" +
+				"WeakReference::create(): Argument #1 ($object) must be of type object, int given\n" +
+				"bool(true)\nbool(true)\nbool(true)\n",
+		},
+		{
+			name: "user ArrayAccess and IteratorAggregate",
+			code: `class WeakBag implements ArrayAccess, IteratorAggregate {
+    private $items = [];
+    function offsetExists($k): bool { return isset($this->items[$k]); }
+    function offsetGet($k): mixed { return $this->items[$k]; }
+    function offsetSet($k, $v): void { if ($k === null) { $this->items[] = $v; } else { $this->items[$k] = $v; } }
+    function offsetUnset($k): void { unset($this->items[$k]); }
+    function getIterator(): Iterator { return new ArrayIterator($this->items); }
+}
+$bag = new WeakBag();
+$bag["a"] = 1;
+$bag[] = 2;
+unset($bag["a"]);
+var_dump(isset($bag["a"]), $bag[0]);
+foreach ($bag as $k => $v) { echo $k, "=", $v, "\n"; }`,
+			expected: "bool(false)\nint(2)\n0=2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := compileAndExecute(t, "<?php\n"+tt.code)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, output)
+		})
+	}
+}
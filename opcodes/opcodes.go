@@ -82,6 +82,9 @@ const (
 	// Type casting and conversion operations
 	OP_CAST // Type casting (int, float, string, array, object)
 	OP_BOOL // Boolean conversion
+
+	// Temporary cleanup
+	OP_FREE // Release the temporaries from op1 up to op2
 )
 
 // Variable Operations (60-91)
@@ -454,6 +457,9 @@ var opcodeNames = map[Opcode]string{
 	OP_CAST: "CAST",
 	OP_BOOL: "BOOL",
 
+	// Temporary cleanup
+	OP_FREE: "FREE",
+
 	// Variables and Assignment
 	OP_ASSIGN:                "ASSIGN",
 	OP_ASSIGN_DIM:            "ASSIGN_DIM",
@@ -5,6 +5,18 @@ import (
 	"github.com/wudi/hey/values"
 )
 
+// Pass names used as keys in OptimizationStats.PassStats.
+const (
+	PassConstantFolding   = "constant_folding"
+	PassDeadCode          = "dead_code_elimination"
+	PassJumpThreading     = "jump_threading"
+	PassUnusedTemporaries = "unused_temporaries"
+)
+
+// defaultMaxIterations bounds how many times the pass pipeline is re-run
+// while it keeps finding work.
+const defaultMaxIterations = 10
+
 // OptimizationStats captures bookkeeping for optimizer runs.
 type OptimizationStats struct {
 	OriginalSize  int
@@ -13,17 +25,37 @@ type OptimizationStats struct {
 	PassStats     map[string]int
 }
 
-// Optimizer is a placeholder optimization pipeline.
-type Optimizer struct{}
+// pass is a single transformation over a unit. It returns the number of
+// rewrites it applied.
+type pass struct {
+	name string
+	run  func(u *unit) int
+}
+
+// Optimizer runs a fixed pipeline of bytecode passes until it reaches a
+// fixed point or MaxIterations.
+type Optimizer struct {
+	MaxIterations int
+	passes        []pass
+}
 
 // NewOptimizer constructs a new optimizer instance.
 func NewOptimizer() *Optimizer {
-	return &Optimizer{}
+	return &Optimizer{
+		MaxIterations: defaultMaxIterations,
+		passes: []pass{
+			{PassConstantFolding, foldConstants},
+			{PassJumpThreading, threadJumps},
+			{PassDeadCode, eliminateDeadCode},
+			{PassUnusedTemporaries, eliminateUnusedTemporaries},
+		},
+	}
 }
 
-// OptimizeWithStats currently performs no transformations and returns the
-// original instruction stream along with trivial statistics. The API mirrors
-// what a future optimizer would provide so callers remain stable.
+// OptimizeWithStats optimizes one compilation unit (the main script or a
+// single function body) and reports what each pass did. The input slices
+// are not modified; the returned constant pool may grow with folded values
+// and retargeted jump addresses, but existing constant indices stay valid.
 func (o *Optimizer) OptimizeWithStats(instr []opcodes.Instruction, consts []*values.Value) ([]opcodes.Instruction, []*values.Value, OptimizationStats) {
 	stats := OptimizationStats{
 		OriginalSize:  len(instr),
@@ -31,5 +63,213 @@ func (o *Optimizer) OptimizeWithStats(instr []opcodes.Instruction, consts []*val
 		Iterations:    0,
 		PassStats:     make(map[string]int),
 	}
-	return instr, consts, stats
+	for _, p := range o.passes {
+		stats.PassStats[p.name] = 0
+	}
+	if len(instr) == 0 {
+		return instr, consts, stats
+	}
+
+	u := &unit{
+		code:   append([]opcodes.Instruction(nil), instr...),
+		consts: append([]*values.Value(nil), consts...),
+	}
+	for stats.Iterations < o.MaxIterations {
+		stats.Iterations++
+		changed := 0
+		for _, p := range o.passes {
+			n := p.run(u)
+			stats.PassStats[p.name] += n
+			changed += n
+		}
+		if changed == 0 {
+			break
+		}
+	}
+
+	stats.OptimizedSize = len(u.code)
+	return u.code, u.consts, stats
+}
+
+// Optimize is OptimizeWithStats for callers holding instruction pointers,
+// such as the compiler and registry.Function.
+func (o *Optimizer) Optimize(instr []*opcodes.Instruction, consts []*values.Value) ([]*opcodes.Instruction, []*values.Value, OptimizationStats) {
+	flat := make([]opcodes.Instruction, len(instr))
+	for i, inst := range instr {
+		flat[i] = *inst
+	}
+	optimized, newConsts, stats := o.OptimizeWithStats(flat, consts)
+	result := make([]*opcodes.Instruction, len(optimized))
+	for i := range optimized {
+		result[i] = &optimized[i]
+	}
+	return result, newConsts, stats
+}
+
+// unit is the instruction stream and constant pool being optimized.
+type unit struct {
+	code   []opcodes.Instruction
+	consts []*values.Value
+}
+
+func op1Type(inst *opcodes.Instruction) opcodes.OpType {
+	return opcodes.DecodeOpType1(inst.OpType1)
+}
+
+func op2Type(inst *opcodes.Instruction) opcodes.OpType {
+	return opcodes.DecodeOpType2(inst.OpType1)
+}
+
+func resultType(inst *opcodes.Instruction) opcodes.OpType {
+	return opcodes.DecodeResultType(inst.OpType2)
+}
+
+// setOpTypes re-encodes the operand types, preserving the extended flags.
+func setOpTypes(inst *opcodes.Instruction, op1, op2, result opcodes.OpType) {
+	inst.OpType1, inst.OpType2 = opcodes.EncodeOpTypesWithFlags(op1, op2, result, opcodes.DecodeExtendedFlags(inst.OpType2))
+}
+
+func isJump(op opcodes.Opcode) bool {
+	return op == opcodes.OP_JMP || op == opcodes.OP_JMPZ || op == opcodes.OP_JMPNZ
+}
+
+// isTerminator reports whether control never falls through to the next
+// instruction.
+func isTerminator(op opcodes.Opcode) bool {
+	switch op {
+	case opcodes.OP_JMP, opcodes.OP_RETURN, opcodes.OP_RETURN_BY_REF, opcodes.OP_THROW, opcodes.OP_EXIT:
+		return true
+	}
+	return false
+}
+
+// jumpOperand returns the constant index holding a jump's target address.
+func jumpOperand(inst *opcodes.Instruction) (uint32, opcodes.OpType) {
+	if inst.Opcode == opcodes.OP_JMP {
+		return inst.Op1, op1Type(inst)
+	}
+	return inst.Op2, op2Type(inst)
+}
+
+// jumpTarget returns the instruction index a jump transfers control to.
+func (u *unit) jumpTarget(inst *opcodes.Instruction) (int, bool) {
+	idx, typ := jumpOperand(inst)
+	if typ != opcodes.IS_CONST || int(idx) >= len(u.consts) || u.consts[idx] == nil || !u.consts[idx].IsInt() {
+		return 0, false
+	}
+	return int(u.consts[idx].ToInt()), true
+}
+
+// resolved reports whether every jump target is a known address, which the
+// passes that move instructions around rely on.
+func (u *unit) resolved() bool {
+	for i := range u.code {
+		if !isJump(u.code[i].Opcode) {
+			continue
+		}
+		target, ok := u.jumpTarget(&u.code[i])
+		if !ok || target < 0 || target > len(u.code) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasExceptionHandlers reports whether the unit contains try blocks. Jumps
+// out of a try block pop its handler in the VM, so passes that change which
+// instruction a jump lands on leave these units alone.
+func (u *unit) hasExceptionHandlers() bool {
+	for i := range u.code {
+		if u.code[i].Opcode == opcodes.OP_CATCH {
+			return true
+		}
+	}
+	return false
+}
+
+// addConstant appends a value to the constant pool and returns its index.
+func (u *unit) addConstant(v *values.Value) uint32 {
+	u.consts = append(u.consts, v)
+	return uint32(len(u.consts) - 1)
+}
+
+// constantUses counts the operands referring to each constant index.
+func (u *unit) constantUses() map[uint32]int {
+	uses := make(map[uint32]int)
+	for i := range u.code {
+		inst := &u.code[i]
+		if inst.Opcode == opcodes.OP_CATCH {
+			// Catch operands are raw addresses, not constant indices
+			continue
+		}
+		if op1Type(inst) == opcodes.IS_CONST {
+			uses[inst.Op1]++
+		}
+		if op2Type(inst) == opcodes.IS_CONST {
+			uses[inst.Op2]++
+		}
+	}
+	return uses
+}
+
+// setJumpTarget points a jump at target. The constant holding the old
+// address is rewritten in place when nothing else refers to it.
+func (u *unit) setJumpTarget(inst *opcodes.Instruction, target int, uses map[uint32]int) {
+	idx, _ := jumpOperand(inst)
+	if uses[idx] == 1 {
+		u.consts[idx] = values.NewInt(int64(target))
+	} else {
+		uses[idx]--
+		idx = u.addConstant(values.NewInt(int64(target)))
+		uses[idx] = 1
+	}
+	if inst.Opcode == opcodes.OP_JMP {
+		inst.Op1 = idx
+	} else {
+		inst.Op2 = idx
+	}
+}
+
+// remove deletes the instructions marked dead and rewrites jump targets and
+// exception handler addresses to match. A jump to a removed instruction
+// lands on the next surviving one.
+func (u *unit) remove(dead []bool) int {
+	n := len(u.code)
+	remap := make([]int, n+1)
+	kept := 0
+	for i := 0; i < n; i++ {
+		remap[i] = kept
+		if !dead[i] {
+			kept++
+		}
+	}
+	remap[n] = kept
+	if kept == n {
+		return 0
+	}
+
+	uses := u.constantUses()
+	code := make([]opcodes.Instruction, 0, kept)
+	for i := 0; i < n; i++ {
+		if dead[i] {
+			continue
+		}
+		inst := u.code[i]
+		switch {
+		case isJump(inst.Opcode):
+			if target, ok := u.jumpTarget(&inst); ok && target >= 0 && target <= n && remap[target] != target {
+				u.setJumpTarget(&inst, remap[target], uses)
+			}
+		case inst.Opcode == opcodes.OP_CATCH:
+			if inst.Op1 != 0 && int(inst.Op1) <= n {
+				inst.Op1 = uint32(remap[inst.Op1])
+			}
+			if inst.Op2 != 0 && int(inst.Op2) <= n {
+				inst.Op2 = uint32(remap[inst.Op2])
+			}
+		}
+		code = append(code, inst)
+	}
+	u.code = code
+	return n - kept
 }
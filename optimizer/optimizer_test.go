@@ -0,0 +1,230 @@
+package optimizer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/opcodes"
+	"github.com/wudi/hey/values"
+)
+
+// program builds an instruction stream and constant pool for tests
+type program struct {
+	code   []opcodes.Instruction
+	consts []*values.Value
+}
+
+func (p *program) constant(v *values.Value) uint32 {
+	p.consts = append(p.consts, v)
+	return uint32(len(p.consts) - 1)
+}
+
+func (p *program) emit(op opcodes.Opcode, t1 opcodes.OpType, op1 uint32, t2 opcodes.OpType, op2 uint32, tr opcodes.OpType, result uint32) int {
+	inst := opcodes.Instruction{Opcode: op, Op1: op1, Op2: op2, Result: result}
+	inst.OpType1, inst.OpType2 = opcodes.EncodeOpTypes(t1, t2, tr)
+	p.code = append(p.code, inst)
+	return len(p.code) - 1
+}
+
+func (p *program) literal(v *values.Value, tmp uint32) {
+	p.emit(opcodes.OP_QM_ASSIGN, opcodes.IS_CONST, p.constant(v), opcodes.IS_UNUSED, 0, opcodes.IS_TMP_VAR, tmp)
+}
+
+func (p *program) jump(op opcodes.Opcode, cond uint32, target int) int {
+	idx := p.constant(values.NewInt(int64(target)))
+	if op == opcodes.OP_JMP {
+		return p.emit(op, opcodes.IS_CONST, idx, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+	}
+	return p.emit(op, opcodes.IS_TMP_VAR, cond, opcodes.IS_CONST, idx, opcodes.IS_UNUSED, 0)
+}
+
+func (p *program) echo(tmp uint32) {
+	p.emit(opcodes.OP_ECHO, opcodes.IS_TMP_VAR, tmp, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+}
+
+func (p *program) ret() {
+	p.emit(opcodes.OP_RETURN, opcodes.IS_CONST, p.constant(values.NewNull()), opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+}
+
+func opcodesOf(code []opcodes.Instruction) []opcodes.Opcode {
+	ops := make([]opcodes.Opcode, len(code))
+	for i, inst := range code {
+		ops[i] = inst.Opcode
+	}
+	return ops
+}
+
+func targetOf(t *testing.T, code []opcodes.Instruction, consts []*values.Value, i int) int {
+	u := &unit{code: code, consts: consts}
+	target, ok := u.jumpTarget(&code[i])
+	require.True(t, ok)
+	return target
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       opcodes.Opcode
+		left     *values.Value
+		right    *values.Value
+		expected *values.Value
+	}{
+		{"int addition", opcodes.OP_ADD, values.NewInt(2), values.NewInt(3), values.NewInt(5)},
+		{"float multiplication", opcodes.OP_MUL, values.NewFloat(1.5), values.NewInt(4), values.NewFloat(6)},
+		{"exact division stays int", opcodes.OP_DIV, values.NewInt(10), values.NewInt(2), values.NewInt(5)},
+		{"modulo", opcodes.OP_MOD, values.NewInt(7), values.NewInt(3), values.NewInt(1)},
+		{"concatenation", opcodes.OP_CONCAT, values.NewString("foo"), values.NewInt(42), values.NewString("foo42")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &program{}
+			p.literal(tt.left, 1)
+			p.literal(tt.right, 2)
+			p.emit(tt.op, opcodes.IS_TMP_VAR, 1, opcodes.IS_TMP_VAR, 2, opcodes.IS_TMP_VAR, 3)
+			p.echo(3)
+			p.ret()
+
+			code, consts, stats := NewOptimizer().OptimizeWithStats(p.code, p.consts)
+			require.Equal(t, []opcodes.Opcode{opcodes.OP_QM_ASSIGN, opcodes.OP_ECHO, opcodes.OP_RETURN}, opcodesOf(code))
+			folded := consts[code[0].Op1]
+			require.Equal(t, tt.expected.Type, folded.Type)
+			require.Equal(t, tt.expected.ToString(), folded.ToString())
+			require.Equal(t, uint32(3), code[0].Result)
+			require.Equal(t, 1, stats.PassStats[PassConstantFolding])
+			require.Equal(t, 2, stats.PassStats[PassUnusedTemporaries])
+			require.Equal(t, 5, stats.OriginalSize)
+			require.Equal(t, 3, stats.OptimizedSize)
+		})
+	}
+
+	t.Run("division by zero is left for run time", func(t *testing.T) {
+		p := &program{}
+		p.literal(values.NewInt(1), 1)
+		p.literal(values.NewInt(0), 2)
+		p.emit(opcodes.OP_DIV, opcodes.IS_TMP_VAR, 1, opcodes.IS_TMP_VAR, 2, opcodes.IS_TMP_VAR, 3)
+		p.echo(3)
+		p.ret()
+
+		code, _, stats := NewOptimizer().OptimizeWithStats(p.code, p.consts)
+		require.Len(t, code, 5)
+		require.Equal(t, 0, stats.PassStats[PassConstantFolding])
+	})
+
+	t.Run("temporaries written twice are not literals", func(t *testing.T) {
+		p := &program{}
+		p.literal(values.NewInt(1), 1)
+		p.literal(values.NewInt(2), 1)
+		p.literal(values.NewInt(3), 2)
+		p.emit(opcodes.OP_ADD, opcodes.IS_TMP_VAR, 1, opcodes.IS_TMP_VAR, 2, opcodes.IS_TMP_VAR, 3)
+		p.echo(3)
+		p.ret()
+
+		code, _, _ := NewOptimizer().OptimizeWithStats(p.code, p.consts)
+		require.Contains(t, opcodesOf(code), opcodes.OP_ADD)
+	})
+
+	t.Run("literal conditions resolve jumps", func(t *testing.T) {
+		// if (false) { echo "a"; } echo "b";
+		p := &program{}
+		p.literal(values.NewBool(false), 1)
+		p.jump(opcodes.OP_JMPZ, 1, 5)
+		p.literal(values.NewString("a"), 2)
+		p.echo(2)
+		p.jump(opcodes.OP_JMP, 0, 5)
+		p.literal(values.NewString("b"), 3)
+		p.echo(3)
+		p.ret()
+
+		code, consts, stats := NewOptimizer().OptimizeWithStats(p.code, p.consts)
+		require.Equal(t, []opcodes.Opcode{opcodes.OP_QM_ASSIGN, opcodes.OP_ECHO, opcodes.OP_RETURN}, opcodesOf(code))
+		require.Equal(t, "b", consts[code[0].Op1].ToString())
+		require.Equal(t, 1, stats.PassStats[PassConstantFolding])
+		require.Positive(t, stats.PassStats[PassDeadCode])
+	})
+}
+
+func TestDeadCodeElimination(t *testing.T) {
+	// Code after each RETURN is unreachable; the JMPZ target is remapped
+	p := &program{}
+	p.emit(opcodes.OP_ECHO, opcodes.IS_CV, 0, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+	p.jump(opcodes.OP_JMPZ, 5, 4)
+	p.emit(opcodes.OP_ECHO, opcodes.IS_CV, 1, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+	p.ret()
+	p.emit(opcodes.OP_ECHO, opcodes.IS_CV, 2, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+	p.ret()
+	p.emit(opcodes.OP_ECHO, opcodes.IS_CV, 3, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+	p.emit(opcodes.OP_ECHO, opcodes.IS_CV, 4, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+
+	code, consts, stats := NewOptimizer().OptimizeWithStats(p.code, p.consts)
+	require.Len(t, code, 6)
+	require.Equal(t, 2, stats.PassStats[PassDeadCode])
+	require.Equal(t, 4, targetOf(t, code, consts, 1))
+}
+
+func TestDeadCodeKeepsExceptionHandlers(t *testing.T) {
+	// try { throw $e; echo "dead"; } catch (...) { echo $x; }
+	p := &program{}
+	p.emit(opcodes.OP_CATCH, opcodes.IS_CONST, 3, opcodes.IS_CONST, 0, opcodes.IS_UNUSED, 0)
+	p.emit(opcodes.OP_THROW, opcodes.IS_CV, 0, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+	p.emit(opcodes.OP_ECHO, opcodes.IS_CV, 1, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+	p.emit(opcodes.OP_ECHO, opcodes.IS_CV, 2, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+	p.ret()
+
+	code, _, stats := NewOptimizer().OptimizeWithStats(p.code, p.consts)
+	require.Equal(t, []opcodes.Opcode{opcodes.OP_CATCH, opcodes.OP_THROW, opcodes.OP_ECHO, opcodes.OP_RETURN}, opcodesOf(code))
+	require.Equal(t, 1, stats.PassStats[PassDeadCode])
+	require.Equal(t, uint32(2), code[0].Op1)
+	require.Equal(t, uint32(0), code[0].Op2)
+	require.Equal(t, uint32(2), code[2].Op1)
+}
+
+func TestJumpThreading(t *testing.T) {
+	p := &program{}
+	p.emit(opcodes.OP_FETCH_R, opcodes.IS_CV, 0, opcodes.IS_UNUSED, 0, opcodes.IS_TMP_VAR, 1)
+	p.jump(opcodes.OP_JMPNZ, 1, 4)
+	p.emit(opcodes.OP_ECHO, opcodes.IS_CV, 1, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+	p.jump(opcodes.OP_JMP, 0, 4)
+	p.jump(opcodes.OP_JMP, 0, 6)
+	p.emit(opcodes.OP_ECHO, opcodes.IS_CV, 2, opcodes.IS_UNUSED, 0, opcodes.IS_UNUSED, 0)
+	p.ret()
+
+	code, consts, stats := NewOptimizer().OptimizeWithStats(p.code, p.consts)
+	// Both jumps are threaded to the RETURN, which leaves the JMP at 3
+	// pointing at the next instruction once the dead block is gone
+	require.Equal(t, []opcodes.Opcode{opcodes.OP_FETCH_R, opcodes.OP_JMPNZ, opcodes.OP_ECHO, opcodes.OP_RETURN}, opcodesOf(code))
+	require.Equal(t, 3, targetOf(t, code, consts, 1))
+	require.Equal(t, 3, stats.PassStats[PassJumpThreading])
+	require.Equal(t, 2, stats.PassStats[PassDeadCode])
+}
+
+func TestJumpToNextInstruction(t *testing.T) {
+	p := &program{}
+	p.emit(opcodes.OP_FETCH_R, opcodes.IS_CV, 0, opcodes.IS_UNUSED, 0, opcodes.IS_TMP_VAR, 1)
+	p.jump(opcodes.OP_JMPZ, 1, 2)
+	p.jump(opcodes.OP_JMP, 0, 3)
+	p.ret()
+
+	code, _, stats := NewOptimizer().OptimizeWithStats(p.code, p.consts)
+	require.Equal(t, []opcodes.Opcode{opcodes.OP_FETCH_R, opcodes.OP_RETURN}, opcodesOf(code))
+	require.Equal(t, 3, stats.PassStats[PassJumpThreading])
+}
+
+func TestInputIsNotModified(t *testing.T) {
+	p := &program{}
+	p.literal(values.NewInt(1), 1)
+	p.literal(values.NewInt(2), 2)
+	p.emit(opcodes.OP_ADD, opcodes.IS_TMP_VAR, 1, opcodes.IS_TMP_VAR, 2, opcodes.IS_TMP_VAR, 3)
+	p.jump(opcodes.OP_JMP, 0, 5)
+	p.echo(3)
+	p.echo(3)
+	p.ret()
+	original := append([]opcodes.Instruction(nil), p.code...)
+	constants := append([]*values.Value(nil), p.consts...)
+
+	_, _, stats := NewOptimizer().OptimizeWithStats(p.code, p.consts)
+	require.Less(t, stats.OptimizedSize, stats.OriginalSize)
+	require.Equal(t, original, p.code)
+	require.Equal(t, constants, p.consts)
+	require.Equal(t, int64(5), p.consts[2].ToInt())
+}
@@ -0,0 +1,334 @@
+package optimizer
+
+import (
+	"github.com/wudi/hey/opcodes"
+	"github.com/wudi/hey/values"
+)
+
+// constantTemps finds the temporaries that hold a single literal for the
+// whole unit: written exactly once, by a QM_ASSIGN of a constant or of
+// another such temporary.
+func (u *unit) constantTemps() map[uint32]*values.Value {
+	writes := make(map[uint32]int)
+	literal := make(map[uint32]*values.Value)
+	copies := make(map[uint32]uint32)
+	for i := range u.code {
+		inst := &u.code[i]
+		if resultType(inst) == opcodes.IS_TMP_VAR {
+			writes[inst.Result]++
+			if inst.Opcode == opcodes.OP_QM_ASSIGN {
+				switch op1Type(inst) {
+				case opcodes.IS_CONST:
+					if int(inst.Op1) < len(u.consts) {
+						literal[inst.Result] = u.consts[inst.Op1]
+					}
+				case opcodes.IS_TMP_VAR:
+					copies[inst.Result] = inst.Op1
+				}
+			}
+		}
+		switch inst.Opcode {
+		case opcodes.OP_ASSIGN, opcodes.OP_ASSIGN_OP, opcodes.OP_ASSIGN_REF,
+			opcodes.OP_PRE_INC, opcodes.OP_PRE_DEC, opcodes.OP_POST_INC, opcodes.OP_POST_DEC:
+			if op1Type(inst) == opcodes.IS_TMP_VAR {
+				writes[inst.Op1]++
+			}
+		case opcodes.OP_FE_FETCH:
+			if op2Type(inst) == opcodes.IS_TMP_VAR {
+				writes[inst.Op2]++
+			}
+		}
+	}
+	for slot := range literal {
+		if writes[slot] != 1 {
+			delete(literal, slot)
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for dst, src := range copies {
+			if v, ok := literal[src]; ok && writes[dst] == 1 {
+				literal[dst] = v
+				delete(copies, dst)
+				changed = true
+			}
+		}
+	}
+	return literal
+}
+
+// literalOperand returns the compile-time value of an operand, if it has one.
+func (u *unit) literalOperand(typ opcodes.OpType, operand uint32, temps map[uint32]*values.Value) (*values.Value, bool) {
+	var v *values.Value
+	switch typ {
+	case opcodes.IS_CONST:
+		if int(operand) < len(u.consts) {
+			v = u.consts[operand]
+		}
+	case opcodes.IS_TMP_VAR:
+		v = temps[operand]
+	}
+	if v == nil {
+		return nil, false
+	}
+	switch v.Type {
+	case values.TypeNull, values.TypeBool, values.TypeInt, values.TypeFloat, values.TypeString:
+		return v, true
+	}
+	return nil, false
+}
+
+func isNumber(v *values.Value) bool {
+	return v.IsInt() || v.IsFloat()
+}
+
+// foldBinary evaluates a binary operation on literals with the same value
+// methods the VM uses. Operations that would warn or throw at run time are
+// left alone.
+func foldBinary(op opcodes.Opcode, left, right *values.Value) (*values.Value, bool) {
+	if op == opcodes.OP_CONCAT {
+		// Float formatting depends on the precision setting at run time
+		if left.IsFloat() || right.IsFloat() {
+			return nil, false
+		}
+		return left.Concat(right), true
+	}
+	if !isNumber(left) || !isNumber(right) {
+		return nil, false
+	}
+	switch op {
+	case opcodes.OP_ADD:
+		return left.Add(right), true
+	case opcodes.OP_SUB:
+		return left.Subtract(right), true
+	case opcodes.OP_MUL:
+		return left.Multiply(right), true
+	case opcodes.OP_DIV:
+		if right.ToFloat() == 0 {
+			return nil, false
+		}
+		return left.Divide(right), true
+	case opcodes.OP_MOD:
+		if right.ToInt() == 0 {
+			return nil, false
+		}
+		return left.Modulo(right), true
+	}
+	return nil, false
+}
+
+func foldUnary(op opcodes.Opcode, v *values.Value) (*values.Value, bool) {
+	if !isNumber(v) {
+		return nil, false
+	}
+	switch op {
+	case opcodes.OP_PLUS:
+		return v, true
+	case opcodes.OP_MINUS:
+		if v.IsFloat() {
+			return values.NewFloat(-v.ToFloat()), true
+		}
+		return values.NewInt(-v.ToInt()), true
+	}
+	return nil, false
+}
+
+// foldConstants evaluates arithmetic and concatenation whose operands are
+// literals, replacing each with a QM_ASSIGN of the result, and resolves
+// conditional jumps on literal conditions.
+func foldConstants(u *unit) int {
+	temps := u.constantTemps()
+	handlers := u.hasExceptionHandlers()
+	dead := make([]bool, len(u.code))
+	folded := 0
+
+	for i := range u.code {
+		inst := &u.code[i]
+		switch inst.Opcode {
+		case opcodes.OP_ADD, opcodes.OP_SUB, opcodes.OP_MUL, opcodes.OP_DIV, opcodes.OP_MOD, opcodes.OP_CONCAT:
+			if resultType(inst) != opcodes.IS_TMP_VAR {
+				continue
+			}
+			left, ok := u.literalOperand(op1Type(inst), inst.Op1, temps)
+			if !ok {
+				continue
+			}
+			right, ok := u.literalOperand(op2Type(inst), inst.Op2, temps)
+			if !ok {
+				continue
+			}
+			if v, ok := foldBinary(inst.Opcode, left, right); ok {
+				u.replaceWithLiteral(inst, v)
+				folded++
+			}
+		case opcodes.OP_PLUS, opcodes.OP_MINUS:
+			if resultType(inst) != opcodes.IS_TMP_VAR {
+				continue
+			}
+			operand, ok := u.literalOperand(op1Type(inst), inst.Op1, temps)
+			if !ok {
+				continue
+			}
+			if v, ok := foldUnary(inst.Opcode, operand); ok {
+				u.replaceWithLiteral(inst, v)
+				folded++
+			}
+		case opcodes.OP_JMPZ, opcodes.OP_JMPNZ:
+			cond, ok := u.literalOperand(op1Type(inst), inst.Op1, temps)
+			if !ok {
+				continue
+			}
+			jump := cond.ToBool()
+			if inst.Opcode == opcodes.OP_JMPZ {
+				jump = !jump
+			}
+			if !jump {
+				dead[i] = true
+				folded++
+			} else if !handlers {
+				inst.Opcode = opcodes.OP_JMP
+				inst.Op1, inst.Op2 = inst.Op2, 0
+				setOpTypes(inst, opcodes.IS_CONST, opcodes.IS_UNUSED, opcodes.IS_UNUSED)
+				folded++
+			}
+		}
+	}
+
+	u.remove(dead)
+	return folded
+}
+
+// replaceWithLiteral turns inst into a QM_ASSIGN of v to its result.
+func (u *unit) replaceWithLiteral(inst *opcodes.Instruction, v *values.Value) {
+	inst.Opcode = opcodes.OP_QM_ASSIGN
+	inst.Op1 = u.addConstant(v)
+	inst.Op2 = 0
+	setOpTypes(inst, opcodes.IS_CONST, opcodes.IS_UNUSED, resultType(inst))
+}
+
+// eliminateDeadCode removes instructions that cannot be reached from the
+// unit's entry point, such as code after an unconditional JMP or RETURN.
+func eliminateDeadCode(u *unit) int {
+	if !u.resolved() {
+		return 0
+	}
+	n := len(u.code)
+	reachable := make([]bool, n)
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		if i < 0 || i >= n || reachable[i] {
+			continue
+		}
+		reachable[i] = true
+		inst := &u.code[i]
+		if isJump(inst.Opcode) {
+			target, _ := u.jumpTarget(inst)
+			work = append(work, target)
+		}
+		if inst.Opcode == opcodes.OP_CATCH {
+			if inst.Op1 != 0 {
+				work = append(work, int(inst.Op1))
+			}
+			if inst.Op2 != 0 {
+				work = append(work, int(inst.Op2))
+			}
+		}
+		if !isTerminator(inst.Opcode) {
+			work = append(work, i+1)
+		}
+	}
+
+	dead := make([]bool, n)
+	for i := range dead {
+		dead[i] = !reachable[i]
+	}
+	return u.remove(dead)
+}
+
+// threadJumps retargets jumps that land on an unconditional JMP to that
+// JMP's destination, and drops jumps to the very next instruction.
+func threadJumps(u *unit) int {
+	if u.hasExceptionHandlers() || !u.resolved() {
+		return 0
+	}
+	n := len(u.code)
+	uses := u.constantUses()
+	threaded := 0
+
+	for i := range u.code {
+		inst := &u.code[i]
+		if !isJump(inst.Opcode) {
+			continue
+		}
+		target, _ := u.jumpTarget(inst)
+		final := target
+		for steps := 0; final < n && u.code[final].Opcode == opcodes.OP_JMP && steps < n; steps++ {
+			next, _ := u.jumpTarget(&u.code[final])
+			if next == final {
+				break
+			}
+			final = next
+		}
+		if final != target {
+			u.setJumpTarget(inst, final, uses)
+			threaded++
+		}
+	}
+
+	dead := make([]bool, n)
+	for i := range u.code {
+		inst := &u.code[i]
+		if !isJump(inst.Opcode) {
+			continue
+		}
+		if target, _ := u.jumpTarget(inst); target != i+1 {
+			continue
+		}
+		// Reading a CV may warn about an undefined variable, so only
+		// conditions held in constants and temporaries can be dropped
+		if inst.Opcode != opcodes.OP_JMP {
+			if typ := op1Type(inst); typ != opcodes.IS_CONST && typ != opcodes.IS_TMP_VAR {
+				continue
+			}
+		}
+		dead[i] = true
+		threaded++
+	}
+	u.remove(dead)
+	return threaded
+}
+
+// eliminateUnusedTemporaries removes QM_ASSIGNs of constants and
+// temporaries into temporaries that nothing reads.
+func eliminateUnusedTemporaries(u *unit) int {
+	refs := make(map[uint32]int)
+	for i := range u.code {
+		inst := &u.code[i]
+		if op1Type(inst) == opcodes.IS_TMP_VAR {
+			refs[inst.Op1]++
+		}
+		if op2Type(inst) == opcodes.IS_TMP_VAR {
+			refs[inst.Op2]++
+		}
+		if resultType(inst) == opcodes.IS_TMP_VAR {
+			refs[inst.Result]++
+		}
+	}
+
+	dead := make([]bool, len(u.code))
+	for i := range u.code {
+		inst := &u.code[i]
+		if inst.Opcode != opcodes.OP_QM_ASSIGN || resultType(inst) != opcodes.IS_TMP_VAR {
+			continue
+		}
+		if typ := op1Type(inst); typ != opcodes.IS_CONST && typ != opcodes.IS_TMP_VAR {
+			continue
+		}
+		if refs[inst.Result] == 1 {
+			dead[i] = true
+		}
+	}
+	return u.remove(dead)
+}
@@ -0,0 +1,466 @@
+package composer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/wudi/hey/compiler/lexer"
+)
+
+// autoloadPackage is a package whose autoload rules go into the generated
+// autoloader, with its directory relative to the project root or vendor dir
+type autoloadPackage struct {
+	name     string
+	autoload *Autoload
+	// root is true for the project's own package, whose paths are relative
+	// to $baseDir rather than $vendorDir
+	root bool
+}
+
+// autoloadGenerator writes vendor/autoload.php and the maps in
+// vendor/composer that it loads.
+type autoloadGenerator struct {
+	rootDir   string
+	vendorDir string
+}
+
+// generate writes the autoloader for the root manifest and the installed
+// packages. The root's autoload-dev rules are included when dev is set.
+func (g *autoloadGenerator) generate(manifest *Manifest, packages []*Package, dev bool) error {
+	var entries []autoloadPackage
+	for _, pkg := range sortByDependencies(packages) {
+		if pkg.Autoload != nil {
+			entries = append(entries, autoloadPackage{name: pkg.Name, autoload: pkg.Autoload})
+		}
+	}
+	// The root package goes last so its files are included after those of
+	// its dependencies, and first when looking up classes
+	root := &Autoload{}
+	if manifest.Autoload != nil {
+		root = mergeAutoload(root, manifest.Autoload)
+	}
+	if dev && manifest.AutoloadDev != nil {
+		root = mergeAutoload(root, manifest.AutoloadDev)
+	}
+	entries = append(entries, autoloadPackage{name: manifest.Name, autoload: root, root: true})
+
+	composerDir := filepath.Join(g.vendorDir, "composer")
+	if err := os.MkdirAll(composerDir, 0755); err != nil {
+		return err
+	}
+
+	psr4 := g.namespaceMap(entries, func(a *Autoload) map[string]PathList { return a.PSR4 })
+	psr0 := g.namespaceMap(entries, func(a *Autoload) map[string]PathList { return a.PSR0 })
+	classmap, err := g.classMap(entries)
+	if err != nil {
+		return err
+	}
+	files := g.filesList(entries)
+
+	suffix := g.loaderSuffix()
+	outputs := map[string]string{
+		"autoload_psr4.php":       g.phpMapFile("autoload_psr4.php", psr4),
+		"autoload_namespaces.php": g.phpMapFile("autoload_namespaces.php", psr0),
+		"autoload_classmap.php":   g.phpMapFile("autoload_classmap.php", classmap),
+		"autoload_files.php":      g.phpMapFile("autoload_files.php", files),
+		"autoload_real.php":       fmt.Sprintf(autoloadRealTemplate, suffix),
+		"ClassLoader.php":         classLoaderSource,
+	}
+	for _, name := range sortedStrings(outputs) {
+		if err := os.WriteFile(filepath.Join(composerDir, name), []byte(outputs[name]), 0644); err != nil {
+			return err
+		}
+	}
+	autoload := fmt.Sprintf(autoloadTemplate, suffix)
+	return os.WriteFile(filepath.Join(g.vendorDir, "autoload.php"), []byte(autoload), 0644)
+}
+
+func mergeAutoload(dst, src *Autoload) *Autoload {
+	merged := *dst
+	merged.PSR4 = mergeNamespaces(dst.PSR4, src.PSR4)
+	merged.PSR0 = mergeNamespaces(dst.PSR0, src.PSR0)
+	merged.Classmap = append(append([]string(nil), dst.Classmap...), src.Classmap...)
+	merged.Files = append(append([]string(nil), dst.Files...), src.Files...)
+	merged.ExcludeFromClassmap = append(append([]string(nil), dst.ExcludeFromClassmap...), src.ExcludeFromClassmap...)
+	return &merged
+}
+
+func mergeNamespaces(a, b map[string]PathList) map[string]PathList {
+	merged := make(map[string]PathList, len(a)+len(b))
+	for prefix, paths := range a {
+		merged[prefix] = append(PathList(nil), paths...)
+	}
+	for prefix, paths := range b {
+		merged[prefix] = append(merged[prefix], paths...)
+	}
+	return merged
+}
+
+// sortByDependencies orders packages so that each comes after the
+// packages it requires, which is the order their files must be included in
+func sortByDependencies(packages []*Package) []*Package {
+	byName := make(map[string]*Package, len(packages))
+	names := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		name := strings.ToLower(pkg.Name)
+		byName[name] = pkg
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sorted []*Package
+	visited := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		pkg, ok := byName[name]
+		if !ok || visited[name] {
+			return
+		}
+		visited[name] = true
+		for _, dep := range sortedStrings(pkg.Require) {
+			visit(strings.ToLower(dep))
+		}
+		sorted = append(sorted, pkg)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return sorted
+}
+
+// phpPath is a path in a generated file: a PHP expression relative to
+// $vendorDir or $baseDir
+type phpPath struct {
+	base string // "$vendorDir" or "$baseDir"
+	rel  string // slash-separated, without a leading slash
+}
+
+func (p phpPath) expr() string {
+	if p.rel == "" {
+		return p.base
+	}
+	return p.base + " . " + phpString("/"+p.rel)
+}
+
+// resolve returns the absolute path for p
+func (g *autoloadGenerator) resolve(p phpPath) string {
+	base := g.rootDir
+	if p.base == "$vendorDir" {
+		base = g.vendorDir
+	}
+	return filepath.Join(base, filepath.FromSlash(p.rel))
+}
+
+// packagePath converts a path from a package's autoload section
+func (g *autoloadGenerator) packagePath(entry autoloadPackage, path string) phpPath {
+	path = strings.TrimPrefix(filepath.ToSlash(path), "./")
+	path = strings.TrimRight(path, "/")
+	if path == "." {
+		path = ""
+	}
+	if entry.root {
+		return phpPath{base: "$baseDir", rel: path}
+	}
+	rel := entry.name
+	if path != "" {
+		rel += "/" + path
+	}
+	return phpPath{base: "$vendorDir", rel: rel}
+}
+
+// mapEntry is a key of a generated PHP array with its values, one path for
+// the class map and the files list, a list of paths for namespaces
+type mapEntry struct {
+	key   string
+	paths []phpPath
+	list  bool
+}
+
+// namespaceMap collects PSR-4 or PSR-0 rules. Prefixes are sorted in
+// reverse like Composer's krsort, so a longer prefix comes before the
+// namespace that contains it, and the root package's paths come first.
+func (g *autoloadGenerator) namespaceMap(entries []autoloadPackage, rules func(*Autoload) map[string]PathList) []mapEntry {
+	paths := make(map[string][]phpPath)
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		for _, prefix := range sortedStrings(rules(entry.autoload)) {
+			for _, path := range rules(entry.autoload)[prefix] {
+				paths[prefix] = append(paths[prefix], g.packagePath(entry, path))
+			}
+		}
+	}
+	prefixes := sortedStrings(paths)
+	sort.SliceStable(prefixes, func(i, j int) bool { return prefixes[i] > prefixes[j] })
+	result := make([]mapEntry, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		result = append(result, mapEntry{key: prefix, paths: paths[prefix], list: true})
+	}
+	return result
+}
+
+// filesList collects the files to include, keyed by the identifier Composer
+// uses to include each file only once
+func (g *autoloadGenerator) filesList(entries []autoloadPackage) []mapEntry {
+	var result []mapEntry
+	for _, entry := range entries {
+		for _, file := range entry.autoload.Files {
+			path := g.packagePath(entry, file)
+			result = append(result, mapEntry{key: md5Hex(entry.name + ":" + file), paths: []phpPath{path}})
+		}
+	}
+	return result
+}
+
+// classMap scans the classmap paths of every package for class, interface,
+// trait and enum declarations. PSR-4 and PSR-0 classes are resolved at
+// runtime and are not included.
+func (g *autoloadGenerator) classMap(entries []autoloadPackage) ([]mapEntry, error) {
+	classes := make(map[string]phpPath)
+	// The root package is scanned first so its classes win
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		var excludes []string
+		for _, pattern := range entry.autoload.ExcludeFromClassmap {
+			excludes = append(excludes, g.resolve(g.packagePath(entry, pattern)))
+		}
+		for _, path := range entry.autoload.Classmap {
+			base := g.packagePath(entry, path)
+			err := g.scanClassmapPath(g.resolve(base), base, excludes, classes)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	result := make([]mapEntry, 0, len(classes))
+	for _, class := range sortedStrings(classes) {
+		result = append(result, mapEntry{key: class, paths: []phpPath{classes[class]}})
+	}
+	return result, nil
+}
+
+func (g *autoloadGenerator) scanClassmapPath(dir string, base phpPath, excludes []string, classes map[string]phpPath) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, exclude := range excludes {
+			if path == exclude || strings.HasPrefix(path, exclude+string(filepath.Separator)) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if d.IsDir() || (path != dir && !isPHPFile(path)) {
+			return nil
+		}
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		filePath := base
+		if rel != "." {
+			filePath.rel = strings.TrimPrefix(base.rel+"/"+filepath.ToSlash(rel), "/")
+		}
+		for _, class := range findClasses(string(source)) {
+			if _, exists := classes[class]; !exists {
+				classes[class] = filePath
+			}
+		}
+		return nil
+	})
+}
+
+func isPHPFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".php", ".inc", ".hh":
+		return true
+	}
+	return false
+}
+
+// findClasses returns the fully qualified names of the classes, interfaces,
+// traits and enums declared in PHP source.
+func findClasses(source string) []string {
+	lex := lexer.New(source)
+	var classes []string
+	namespace := ""
+	prev := lexer.T_EOF
+
+	next := func() lexer.Token {
+		for {
+			tok := lex.NextToken()
+			switch tok.Type {
+			case lexer.T_WHITESPACE, lexer.T_COMMENT, lexer.T_DOC_COMMENT:
+				continue
+			}
+			return tok
+		}
+	}
+
+	for tok := next(); tok.Type != lexer.T_EOF; tok = next() {
+		switch tok.Type {
+		case lexer.T_NAMESPACE:
+			if prev == lexer.T_PAAMAYIM_NEKUDOTAYIM {
+				break
+			}
+			name := next()
+			switch name.Type {
+			case lexer.T_STRING, lexer.T_NAME_QUALIFIED:
+				namespace = name.Value + "\\"
+			default:
+				// namespace { ... } declares the global namespace
+				namespace = ""
+			}
+			tok = name
+		case lexer.T_CLASS, lexer.T_INTERFACE, lexer.T_TRAIT, lexer.T_ENUM:
+			// Foo::class and anonymous classes declare nothing
+			if prev == lexer.T_PAAMAYIM_NEKUDOTAYIM || prev == lexer.T_NEW ||
+				prev == lexer.T_OBJECT_OPERATOR || prev == lexer.T_NULLSAFE_OBJECT_OPERATOR {
+				break
+			}
+			name := next()
+			if name.Type == lexer.T_STRING {
+				classes = append(classes, namespace+name.Value)
+			}
+			tok = name
+		}
+		prev = tok.Type
+	}
+	return classes
+}
+
+// phpMapFile renders one of the vendor/composer/autoload_*.php files
+func (g *autoloadGenerator) phpMapFile(name string, entries []mapEntry) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<?php\n\n// %s @generated by Composer\n\n", name)
+	sb.WriteString("$vendorDir = dirname(__DIR__);\n")
+	sb.WriteString("$baseDir = " + g.baseDirExpr() + ";\n\n")
+	sb.WriteString("return array(\n")
+	for _, entry := range entries {
+		values := make([]string, len(entry.paths))
+		for i, path := range entry.paths {
+			values[i] = path.expr()
+		}
+		value := values[0]
+		if entry.list {
+			value = "array(" + strings.Join(values, ", ") + ")"
+		}
+		fmt.Fprintf(&sb, "    %s => %s,\n", phpString(entry.key), value)
+	}
+	sb.WriteString(");\n")
+	return sb.String()
+}
+
+// baseDirExpr returns the PHP expression for the project root in terms of
+// $vendorDir
+func (g *autoloadGenerator) baseDirExpr() string {
+	rel, err := filepath.Rel(g.vendorDir, g.rootDir)
+	if err != nil {
+		return phpString(filepath.ToSlash(g.rootDir))
+	}
+	expr := "$vendorDir"
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		switch part {
+		case ".":
+		case "..":
+			expr = "dirname(" + expr + ")"
+		default:
+			return phpString(filepath.ToSlash(g.rootDir))
+		}
+	}
+	return expr
+}
+
+var loaderSuffixRegex = regexp.MustCompile(`ComposerAutoloaderInit([0-9a-f]+)::`)
+
+// loaderSuffix reuses the class name suffix of an existing autoloader, so
+// regenerating it does not change autoload.php, or picks a new random one
+func (g *autoloadGenerator) loaderSuffix() string {
+	if data, err := os.ReadFile(filepath.Join(g.vendorDir, "autoload.php")); err == nil {
+		if m := loaderSuffixRegex.FindSubmatch(data); m != nil {
+			return string(m[1])
+		}
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// phpString quotes s as a single-quoted PHP string
+func phpString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+const autoloadTemplate = `<?php
+
+// autoload.php @generated by Composer
+
+require_once __DIR__ . '/composer/autoload_real.php';
+
+return ComposerAutoloaderInit%[1]s::getLoader();
+`
+
+const autoloadRealTemplate = `<?php
+
+// autoload_real.php @generated by Composer
+
+class ComposerAutoloaderInit%[1]s
+{
+    private static $loader;
+
+    public static function getLoader()
+    {
+        if (null !== self::$loader) {
+            return self::$loader;
+        }
+
+        require __DIR__ . '/ClassLoader.php';
+        self::$loader = $loader = new \Composer\Autoload\ClassLoader(dirname(__DIR__));
+
+        $map = require __DIR__ . '/autoload_namespaces.php';
+        foreach ($map as $namespace => $path) {
+            $loader->set($namespace, $path);
+        }
+
+        $map = require __DIR__ . '/autoload_psr4.php';
+        foreach ($map as $namespace => $path) {
+            $loader->setPsr4($namespace, $path);
+        }
+
+        $classMap = require __DIR__ . '/autoload_classmap.php';
+        if ($classMap) {
+            $loader->addClassMap($classMap);
+        }
+
+        $loader->register(true);
+
+        $files = require __DIR__ . '/autoload_files.php';
+        foreach ($files as $fileIdentifier => $file) {
+            composerRequire%[1]s($fileIdentifier, $file);
+        }
+
+        return $loader;
+    }
+}
+
+function composerRequire%[1]s($fileIdentifier, $file)
+{
+    if (empty($GLOBALS['__composer_autoload_files'][$fileIdentifier])) {
+        $GLOBALS['__composer_autoload_files'][$fileIdentifier] = true;
+
+        require $file;
+    }
+}
+`
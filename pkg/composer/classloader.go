@@ -0,0 +1,243 @@
+package composer
+
+// classLoaderSource is vendor/composer/ClassLoader.php, a PSR-4, PSR-0 and
+// class map autoloader with the API of Composer's Composer\Autoload\ClassLoader.
+const classLoaderSource = `<?php
+
+/*
+ * This file is @generated by hey. It implements the API of Composer's
+ * Composer\Autoload\ClassLoader.
+ */
+
+namespace Composer\Autoload;
+
+class ClassLoader
+{
+    private $vendorDir;
+    private $prefixDirsPsr4 = array();
+    private $fallbackDirsPsr4 = array();
+    private $prefixesPsr0 = array();
+    private $fallbackDirsPsr0 = array();
+    private $classMap = array();
+    private $missingClasses = array();
+    private $useIncludePath = false;
+    private $classMapAuthoritative = false;
+
+    public function __construct($vendorDir = null)
+    {
+        $this->vendorDir = $vendorDir;
+    }
+
+    public function getPrefixes()
+    {
+        return $this->prefixesPsr0;
+    }
+
+    public function getPrefixesPsr4()
+    {
+        return $this->prefixDirsPsr4;
+    }
+
+    public function getFallbackDirs()
+    {
+        return $this->fallbackDirsPsr0;
+    }
+
+    public function getFallbackDirsPsr4()
+    {
+        return $this->fallbackDirsPsr4;
+    }
+
+    public function getClassMap()
+    {
+        return $this->classMap;
+    }
+
+    public function addClassMap(array $classMap)
+    {
+        $this->classMap = array_merge($this->classMap, $classMap);
+    }
+
+    public function add($prefix, $paths, $prepend = false)
+    {
+        $paths = (array) $paths;
+        if (!$prefix) {
+            if ($prepend) {
+                $this->fallbackDirsPsr0 = array_merge($paths, $this->fallbackDirsPsr0);
+            } else {
+                $this->fallbackDirsPsr0 = array_merge($this->fallbackDirsPsr0, $paths);
+            }
+            return;
+        }
+        if (!isset($this->prefixesPsr0[$prefix])) {
+            $this->prefixesPsr0[$prefix] = $paths;
+            return;
+        }
+        if ($prepend) {
+            $this->prefixesPsr0[$prefix] = array_merge($paths, $this->prefixesPsr0[$prefix]);
+        } else {
+            $this->prefixesPsr0[$prefix] = array_merge($this->prefixesPsr0[$prefix], $paths);
+        }
+    }
+
+    public function addPsr4($prefix, $paths, $prepend = false)
+    {
+        $paths = (array) $paths;
+        if (!$prefix) {
+            if ($prepend) {
+                $this->fallbackDirsPsr4 = array_merge($paths, $this->fallbackDirsPsr4);
+            } else {
+                $this->fallbackDirsPsr4 = array_merge($this->fallbackDirsPsr4, $paths);
+            }
+            return;
+        }
+        if (substr($prefix, -1) !== '\\') {
+            throw new \InvalidArgumentException("A non-empty PSR-4 prefix must end with a namespace separator.");
+        }
+        if (!isset($this->prefixDirsPsr4[$prefix])) {
+            $this->prefixDirsPsr4[$prefix] = $paths;
+            return;
+        }
+        if ($prepend) {
+            $this->prefixDirsPsr4[$prefix] = array_merge($paths, $this->prefixDirsPsr4[$prefix]);
+        } else {
+            $this->prefixDirsPsr4[$prefix] = array_merge($this->prefixDirsPsr4[$prefix], $paths);
+        }
+    }
+
+    public function set($prefix, $paths)
+    {
+        if (!$prefix) {
+            $this->fallbackDirsPsr0 = (array) $paths;
+        } else {
+            $this->prefixesPsr0[$prefix] = (array) $paths;
+        }
+    }
+
+    public function setPsr4($prefix, $paths)
+    {
+        if (!$prefix) {
+            $this->fallbackDirsPsr4 = (array) $paths;
+            return;
+        }
+        if (substr($prefix, -1) !== '\\') {
+            throw new \InvalidArgumentException("A non-empty PSR-4 prefix must end with a namespace separator.");
+        }
+        $this->prefixDirsPsr4[$prefix] = (array) $paths;
+    }
+
+    public function setUseIncludePath($useIncludePath)
+    {
+        $this->useIncludePath = $useIncludePath;
+    }
+
+    public function getUseIncludePath()
+    {
+        return $this->useIncludePath;
+    }
+
+    public function setClassMapAuthoritative($classMapAuthoritative)
+    {
+        $this->classMapAuthoritative = $classMapAuthoritative;
+    }
+
+    public function isClassMapAuthoritative()
+    {
+        return $this->classMapAuthoritative;
+    }
+
+    public function register($prepend = false)
+    {
+        spl_autoload_register(array($this, 'loadClass'), true, $prepend);
+    }
+
+    public function unregister()
+    {
+        spl_autoload_unregister(array($this, 'loadClass'));
+    }
+
+    public function loadClass($class)
+    {
+        $file = $this->findFile($class);
+        if ($file !== false) {
+            self::includeFile($file);
+            return true;
+        }
+        return null;
+    }
+
+    public function findFile($class)
+    {
+        if ($class[0] === '\\') {
+            $class = substr($class, 1);
+        }
+        if (isset($this->classMap[$class])) {
+            return $this->classMap[$class];
+        }
+        if ($this->classMapAuthoritative || isset($this->missingClasses[$class])) {
+            return false;
+        }
+
+        $file = $this->findFileWithExtension($class, '.php');
+        if ($file === false) {
+            $this->missingClasses[$class] = true;
+        }
+        return $file;
+    }
+
+    private function findFileWithExtension($class, $ext)
+    {
+        $logicalPathPsr4 = strtr($class, '\\', DIRECTORY_SEPARATOR) . $ext;
+
+        foreach ($this->prefixDirsPsr4 as $prefix => $dirs) {
+            if (strpos($class, $prefix) === 0) {
+                $relative = substr($logicalPathPsr4, strlen($prefix));
+                foreach ($dirs as $dir) {
+                    $file = $dir . DIRECTORY_SEPARATOR . $relative;
+                    if (file_exists($file)) {
+                        return $file;
+                    }
+                }
+            }
+        }
+        foreach ($this->fallbackDirsPsr4 as $dir) {
+            $file = $dir . DIRECTORY_SEPARATOR . $logicalPathPsr4;
+            if (file_exists($file)) {
+                return $file;
+            }
+        }
+
+        $pos = strrpos($class, '\\');
+        if ($pos !== false) {
+            $logicalPathPsr0 = substr($logicalPathPsr4, 0, $pos + 1)
+                . strtr(substr($logicalPathPsr4, $pos + 1), '_', DIRECTORY_SEPARATOR);
+        } else {
+            $logicalPathPsr0 = strtr($class, '_', DIRECTORY_SEPARATOR) . $ext;
+        }
+
+        foreach ($this->prefixesPsr0 as $prefix => $dirs) {
+            if (strpos($class, $prefix) === 0) {
+                foreach ($dirs as $dir) {
+                    $file = $dir . DIRECTORY_SEPARATOR . $logicalPathPsr0;
+                    if (file_exists($file)) {
+                        return $file;
+                    }
+                }
+            }
+        }
+        foreach ($this->fallbackDirsPsr0 as $dir) {
+            $file = $dir . DIRECTORY_SEPARATOR . $logicalPathPsr0;
+            if (file_exists($file)) {
+                return $file;
+            }
+        }
+
+        return false;
+    }
+
+    private static function includeFile($file)
+    {
+        include $file;
+    }
+}
+`
@@ -0,0 +1,465 @@
+// Package composer implements the dependency manager behind `hey init`,
+// `hey require`, `hey install`, `hey update` and `hey validate`. It reads
+// the same composer.json and composer.lock files as Composer, resolves
+// version constraints against path, git and Composer repositories, installs
+// packages into the vendor directory and generates vendor/autoload.php.
+package composer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Options configures a Composer instance.
+type Options struct {
+	// Output receives progress messages; nil discards them
+	Output io.Writer
+	// Packagist is the default registry, used unless composer.json
+	// disables it. Nil means repo.packagist.org.
+	Packagist Repository
+	// CacheDir holds git mirrors; it defaults to $COMPOSER_CACHE_DIR or
+	// the user cache directory
+	CacheDir string
+	// HTTPClient downloads metadata and archives
+	HTTPClient *http.Client
+}
+
+// Composer manages the dependencies of the project in one directory.
+type Composer struct {
+	dir  string
+	opts Options
+	out  io.Writer
+}
+
+// New creates a Composer for the project in dir.
+func New(dir string, opts Options) (*Composer, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if opts.CacheDir == "" {
+		opts.CacheDir = os.Getenv("COMPOSER_CACHE_DIR")
+	}
+	if opts.CacheDir == "" {
+		if userCache, err := os.UserCacheDir(); err == nil {
+			opts.CacheDir = filepath.Join(userCache, "hey", "composer")
+		} else {
+			opts.CacheDir = filepath.Join(os.TempDir(), "hey-composer")
+		}
+	}
+	out := opts.Output
+	if out == nil {
+		out = io.Discard
+	}
+	return &Composer{dir: abs, opts: opts, out: out}, nil
+}
+
+func (c *Composer) manifestPath() string {
+	return filepath.Join(c.dir, "composer.json")
+}
+
+func (c *Composer) lockPath() string {
+	return filepath.Join(c.dir, "composer.lock")
+}
+
+func (c *Composer) readManifest() (*Manifest, error) {
+	manifest, err := ReadManifest(c.manifestPath())
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("composer.json could not be found in %s", c.dir)
+	}
+	return manifest, err
+}
+
+func (c *Composer) vendorDir(manifest *Manifest) string {
+	dir := filepath.FromSlash(manifest.vendorDir())
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(c.dir, dir)
+}
+
+// repositories builds the repositories composer.json declares, followed by
+// Packagist unless it is disabled
+func (c *Composer) repositories(manifest *Manifest) ([]Repository, error) {
+	var repos []Repository
+	packagist := true
+	for _, config := range manifest.Repositories {
+		if config.Disabled != "" {
+			if config.Disabled == "packagist.org" || config.Disabled == "packagist" {
+				packagist = false
+			}
+			continue
+		}
+		switch config.Type {
+		case "path":
+			repos = append(repos, NewPathRepository(config.URL, c.dir, config.Options))
+		case "vcs", "git":
+			url := config.URL
+			if !strings.Contains(url, "://") && !strings.Contains(url, "@") && !filepath.IsAbs(url) {
+				url = filepath.Join(c.dir, url)
+			}
+			repos = append(repos, NewVCSRepository(url, c.opts.CacheDir))
+		case "composer":
+			repos = append(repos, NewComposerRepository(config.URL, c.opts.HTTPClient))
+		default:
+			return nil, fmt.Errorf("repository type %q is not supported", config.Type)
+		}
+	}
+	if packagist {
+		if c.opts.Packagist != nil {
+			repos = append(repos, c.opts.Packagist)
+		} else {
+			repos = append(repos, NewComposerRepository(PackagistURL, c.opts.HTTPClient))
+		}
+	}
+	return repos, nil
+}
+
+// InstallOptions configures Install and Update.
+type InstallOptions struct {
+	// NoDev skips the packages only required by require-dev
+	NoDev bool
+	// NoInstall only updates composer.lock
+	NoInstall bool
+}
+
+// Install installs the packages locked in composer.lock, or resolves and
+// locks them first when there is no lock file.
+func (c *Composer) Install(opts InstallOptions) error {
+	manifest, err := c.readManifest()
+	if err != nil {
+		return err
+	}
+	lock, err := ReadLock(c.lockPath())
+	if os.IsNotExist(err) {
+		fmt.Fprintln(c.out, "No composer.lock file present. Updating dependencies to latest instead of installing from lock file. See https://getcomposer.org/install for more information.")
+		return c.update(manifest, nil, opts)
+	} else if err != nil {
+		return err
+	}
+	if hash, err := ContentHash(manifest.raw); err == nil && hash != lock.ContentHash {
+		fmt.Fprintln(c.out, "Warning: The lock file is not up to date with the latest changes in composer.json. You may be getting outdated dependencies. It is recommended that you run `composer update` or `composer update <package name>`.")
+	}
+	return c.installLocked(manifest, lock, opts.NoDev)
+}
+
+// Update resolves the requirements of composer.json, writes composer.lock
+// and installs the result. When packages are named, the other locked
+// packages are kept at their versions where possible.
+func (c *Composer) Update(packages []string, opts InstallOptions) error {
+	manifest, err := c.readManifest()
+	if err != nil {
+		return err
+	}
+	return c.update(manifest, packages, opts)
+}
+
+func (c *Composer) update(manifest *Manifest, only []string, opts InstallOptions) error {
+	repos, err := c.repositories(manifest)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "Loading composer repositories with package information")
+	fmt.Fprintln(c.out, "Updating dependencies")
+
+	var old *Lock
+	if lock, err := ReadLock(c.lockPath()); err == nil {
+		old = lock
+	}
+
+	s := newSolver(repos, manifest)
+	if old != nil && len(only) > 0 {
+		unlocked := make(map[string]bool, len(only))
+		for _, name := range only {
+			unlocked[strings.ToLower(name)] = true
+		}
+		for _, pkg := range old.allPackages() {
+			if !unlocked[strings.ToLower(pkg.Name)] {
+				s.preferred[strings.ToLower(pkg.Name)] = pkg
+			}
+		}
+	}
+
+	reqs, err := requirements(manifest.Require, nil)
+	if err != nil {
+		return err
+	}
+	devReqs, err := requirements(manifest.RequireDev, nil)
+	if err != nil {
+		return err
+	}
+	resolved, err := s.solve(append(reqs, devReqs...))
+	if err != nil {
+		return err
+	}
+
+	lock, err := newLock(manifest, resolved)
+	if err != nil {
+		return err
+	}
+	c.reportLockChanges(old, lock)
+	fmt.Fprintln(c.out, "Writing lock file")
+	if err := lock.Write(c.lockPath()); err != nil {
+		return err
+	}
+	if opts.NoInstall {
+		return nil
+	}
+	return c.installLocked(manifest, lock, opts.NoDev)
+}
+
+// reportLockChanges prints the lock file operations between two locks
+func (c *Composer) reportLockChanges(old, lock *Lock) {
+	before := make(map[string]*Package)
+	if old != nil {
+		for _, pkg := range old.allPackages() {
+			before[strings.ToLower(pkg.Name)] = pkg
+		}
+	}
+	after := make(map[string]*Package)
+	for _, pkg := range lock.allPackages() {
+		after[strings.ToLower(pkg.Name)] = pkg
+	}
+
+	var lines []string
+	installs, updates, removals := 0, 0, 0
+	for _, name := range sortedStrings(after) {
+		pkg := after[name]
+		prev, ok := before[name]
+		switch {
+		case !ok:
+			installs++
+			lines = append(lines, "  - Locking "+pkg.PrettyName())
+		case prev.Version != pkg.Version || prev.Reference() != pkg.Reference():
+			updates++
+			verb := "Upgrading"
+			if prev.version.Compare(pkg.version) > 0 {
+				verb = "Downgrading"
+			}
+			lines = append(lines, fmt.Sprintf("  - %s %s (%s => %s)", verb, pkg.Name, prev.Version, pkg.Version))
+		}
+	}
+	for _, name := range sortedStrings(before) {
+		if _, ok := after[name]; !ok {
+			removals++
+			lines = append(lines, "  - Removing "+before[name].PrettyName())
+		}
+	}
+	if len(lines) == 0 {
+		fmt.Fprintln(c.out, "Nothing to modify in lock file")
+		return
+	}
+	fmt.Fprintf(c.out, "Lock file operations: %s, %s, %s\n",
+		plural(installs, "install"), plural(updates, "update"), plural(removals, "removal"))
+	for _, line := range lines {
+		fmt.Fprintln(c.out, line)
+	}
+}
+
+// installLocked installs the locked packages and generates the autoloader
+func (c *Composer) installLocked(manifest *Manifest, lock *Lock, noDev bool) error {
+	packages := append([]*Package(nil), lock.Packages...)
+	var devNames []string
+	if noDev {
+		fmt.Fprintln(c.out, "Installing dependencies from lock file")
+	} else {
+		fmt.Fprintln(c.out, "Installing dependencies from lock file (including require-dev)")
+		packages = append(packages, lock.PackagesDev...)
+		for _, pkg := range lock.PackagesDev {
+			devNames = append(devNames, pkg.Name)
+		}
+	}
+	sortPackages(packages)
+
+	vendorDir := c.vendorDir(manifest)
+	in := &installer{rootDir: c.dir, vendorDir: vendorDir, client: c.opts.HTTPClient, out: c.out}
+	if err := in.install(packages, devNames, !noDev); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.out, "Generating autoload files")
+	gen := &autoloadGenerator{rootDir: c.dir, vendorDir: vendorDir}
+	return gen.generate(manifest, packages, !noDev)
+}
+
+// RequireOptions configures Require.
+type RequireOptions struct {
+	// Dev adds the packages to require-dev
+	Dev bool
+	// NoUpdate only edits composer.json
+	NoUpdate bool
+	InstallOptions
+}
+
+// Require adds packages to composer.json and updates them. Each argument
+// is "vendor/package" or "vendor/package:constraint"; without a constraint
+// the best available version is looked up and a caret constraint used. If
+// the update fails, composer.json is restored.
+func (c *Composer) Require(packages []string, opts RequireOptions) error {
+	original, err := os.ReadFile(c.manifestPath())
+	if os.IsNotExist(err) {
+		original = []byte("{\n}\n")
+		if err := os.WriteFile(c.manifestPath(), original, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, "./composer.json has been created")
+	} else if err != nil {
+		return err
+	}
+	manifest, err := ParseManifest(original)
+	if err != nil {
+		return err
+	}
+	links, err := c.parseRequirements(manifest, packages)
+	if err != nil {
+		return err
+	}
+
+	section := "require"
+	if opts.Dev {
+		section = "require-dev"
+	}
+	updated, err := addLinks(original, section, links, manifest.Config != nil && manifest.Config.SortPackages)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.manifestPath(), updated, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "./composer.json has been updated")
+	if opts.NoUpdate {
+		return nil
+	}
+
+	manifest, err = ParseManifest(updated)
+	if err == nil {
+		names := make([]string, len(links))
+		for i, link := range links {
+			names[i] = link.key
+		}
+		err = c.update(manifest, names, opts.InstallOptions)
+	}
+	if err != nil {
+		fmt.Fprintln(c.out, "\nInstallation failed, reverting ./composer.json to its original content.")
+		if restoreErr := os.WriteFile(c.manifestPath(), original, 0644); restoreErr != nil {
+			return errors.Join(err, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// parseRequirements splits package arguments into names and constraints,
+// picking a constraint for packages given without one
+func (c *Composer) parseRequirements(manifest *Manifest, args []string) ([]orderedPair, error) {
+	var links []orderedPair
+	for _, arg := range args {
+		name, constraint, _ := strings.Cut(arg, ":")
+		if constraint == "" {
+			name, constraint, _ = strings.Cut(arg, "=")
+		}
+		if constraint == "" {
+			name, constraint, _ = strings.Cut(strings.TrimSpace(arg), " ")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !packageNameRegex.MatchString(name) && !isPlatformPackage(name) {
+			return nil, fmt.Errorf("%q is not a valid package name", name)
+		}
+		constraint = strings.TrimSpace(constraint)
+		if constraint == "" {
+			recommended, err := c.recommendVersion(manifest, name)
+			if err != nil {
+				return nil, err
+			}
+			constraint = recommended
+			fmt.Fprintf(c.out, "Using version %s for %s\n", constraint, name)
+		} else if _, err := ParseConstraint(constraint); err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q for %s: %w", constraint, name, err)
+		}
+		links = append(links, orderedPair{key: name, value: constraint})
+	}
+	return links, nil
+}
+
+// recommendVersion finds the best version of name allowed by the
+// project's minimum stability and returns a constraint for it
+func (c *Composer) recommendVersion(manifest *Manifest, name string) (string, error) {
+	repos, err := c.repositories(manifest)
+	if err != nil {
+		return "", err
+	}
+	s := newSolver(repos, manifest)
+	candidates, err := s.findCandidates(name)
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("Could not find package %s.", name)
+	}
+	best := make([]*Package, 0, len(candidates))
+	for _, pkg := range candidates {
+		if s.stabilityAllowed(name, pkg.version) {
+			best = append(best, pkg)
+		}
+	}
+	if len(best) == 0 {
+		return "", fmt.Errorf("Could not find a version of package %s matching your minimum-stability (%s). Require it with an explicit version constraint allowing its desired stability.", name, manifest.minimumStability())
+	}
+	// Prefer stable releases when there are any, as Composer does here
+	sortCandidates(best, true)
+	return recommendedConstraint(best[0].version), nil
+}
+
+// addLinks adds or replaces entries of the require or require-dev section
+// of composer.json, keeping the rest of the file's layout
+func addLinks(composerJSON []byte, section string, links []orderedPair, sortPackages bool) ([]byte, error) {
+	root := newJSONObject()
+	if err := root.UnmarshalJSON(composerJSON); err != nil {
+		return nil, fmt.Errorf("composer.json does not contain a JSON object: %w", err)
+	}
+	requires := newJSONObject()
+	if raw := root.Get(section); raw != nil {
+		if err := requires.UnmarshalJSON(raw); err != nil {
+			return nil, fmt.Errorf("%s must be an object", section)
+		}
+	}
+	for _, link := range links {
+		if err := requires.SetValue(link.key, link.value); err != nil {
+			return nil, err
+		}
+	}
+	if sortPackages {
+		sort.SliceStable(requires.keys, func(i, j int) bool {
+			return sortPackagesKey(requires.keys[i]) < sortPackagesKey(requires.keys[j])
+		})
+	}
+	if err := root.SetValue(section, requires); err != nil {
+		return nil, err
+	}
+	return formatJSON(root)
+}
+
+// sortPackagesKey orders php first, then extensions and libraries, then
+// packages, as Composer's sort-packages does
+func sortPackagesKey(name string) string {
+	switch {
+	case name == "php":
+		return "0-" + name
+	case strings.HasPrefix(name, "php-"), strings.HasPrefix(name, "hhvm"):
+		return "1-" + name
+	case strings.HasPrefix(name, "ext-"):
+		return "2-" + name
+	case strings.HasPrefix(name, "lib-"):
+		return "3-" + name
+	}
+	return "4-" + name
+}
@@ -0,0 +1,495 @@
+package composer
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/compiler"
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vm"
+	"github.com/wudi/hey/vmfactory"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	}
+}
+
+// newRegistry lays out a DirectoryRepository used in place of Packagist
+func newRegistry(t *testing.T) (string, *DirectoryRepository) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"acme/strings/1.0.0/composer.json": `{"name": "acme/strings", "autoload": {"psr-4": {"CmpStrings\\": "src/"}}}`,
+		"acme/strings/1.0.0/src/Text.php": `<?php
+namespace CmpStrings;
+class Text { public static function shout($s) { return strtoupper($s) . "!"; } }`,
+		"acme/greeter/1.0.0/composer.json": `{"name": "acme/greeter", "require": {"acme/strings": "^1.0"},
+			"autoload": {"psr-0": {"CmpGreeter_": "lib/"}, "files": ["functions.php"]}}`,
+		"acme/greeter/1.0.0/lib/CmpGreeter/Hello.php": `<?php
+class CmpGreeter_Hello { public function greet($name) { return "hello " . $name; } }`,
+		"acme/greeter/1.0.0/functions.php": `<?php
+function cmp_greet($name) { return (new CmpGreeter_Hello)->greet($name); }`,
+		"acme/greeter/1.1.0/composer.json": `{"name": "acme/greeter", "require": {"acme/strings": "^1.0", "php": ">=7.4"},
+			"autoload": {"psr-0": {"CmpGreeter_": "lib/"}, "files": ["functions.php"], "classmap": ["legacy/"]}}`,
+		"acme/greeter/1.1.0/lib/CmpGreeter/Hello.php": `<?php
+class CmpGreeter_Hello { public function greet($name) { return "hello " . \CmpStrings\Text::shout($name); } }`,
+		"acme/greeter/1.1.0/functions.php": `<?php
+function cmp_greet($name) { return (new CmpGreeter_Hello)->greet($name); }`,
+		"acme/greeter/1.1.0/legacy/all.php": `<?php
+namespace CmpLegacy;
+interface Named {}
+class Widget implements Named { public function name() { return "widget"; } }`,
+		"acme/greeter/2.0.0-beta1/composer.json": `{"name": "acme/greeter", "require": {"acme/strings": "^2.0"}}`,
+		"acme/tools/0.3.1/composer.json":         `{"name": "acme/tools"}`,
+		"acme/future/1.0.0/composer.json":        `{"name": "acme/future", "require": {"php": ">=9.0"}}`,
+	})
+	return dir, NewDirectoryRepository(dir)
+}
+
+func newTestComposer(t *testing.T, repo Repository, composerJSON string) (*Composer, string, *bytes.Buffer) {
+	t.Helper()
+	dir := t.TempDir()
+	if composerJSON != "" {
+		writeFiles(t, dir, map[string]string{"composer.json": composerJSON})
+	}
+	var out bytes.Buffer
+	c, err := New(dir, Options{Output: &out, Packagist: repo, CacheDir: t.TempDir()})
+	require.NoError(t, err)
+	return c, dir, &out
+}
+
+func lockedVersions(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	lock, err := ReadLock(filepath.Join(dir, "composer.lock"))
+	require.NoError(t, err)
+	versions := make(map[string]string)
+	for _, pkg := range lock.allPackages() {
+		versions[pkg.Name] = pkg.Version
+	}
+	return versions
+}
+
+// runPHP executes a script and returns its output
+func runPHP(t *testing.T, file string) string {
+	t.Helper()
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime.Bootstrap())
+	require.NoError(t, runtime.InitializeVMIntegration())
+
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return compiler.NewCompiler()
+	})
+	compiled, err := factory.CompileFile(file)
+	require.NoError(t, err)
+
+	vmCtx := vm.NewExecutionContext()
+	var out bytes.Buffer
+	vmCtx.OutputWriter = &out
+	err = factory.CreateVM().Execute(vmCtx, compiled.Instructions, compiled.Constants,
+		compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+	require.NoError(t, err, out.String())
+	return out.String()
+}
+
+func TestInstallResolvesLocksAndAutoloads(t *testing.T) {
+	_, repo := newRegistry(t)
+	c, dir, out := newTestComposer(t, repo, `{
+    "name": "acme/app",
+    "require": {"acme/greeter": "^1.0"},
+    "require-dev": {"acme/tools": "^0.3"},
+    "autoload": {"psr-4": {"CmpApp\\": "src/"}},
+    "autoload-dev": {"classmap": ["tests/"]}
+}`)
+	writeFiles(t, dir, map[string]string{
+		"src/Main.php": `<?php
+namespace CmpApp;
+use CmpLegacy\Widget;
+class Main {
+    public function run() { return cmp_greet("world") . " " . (new Widget)->name(); }
+}`,
+		"tests/Fixture.php": `<?php
+final class CmpFixture {}`,
+		"run.php": `<?php
+$loader = require __DIR__ . '/vendor/autoload.php';
+echo (new CmpApp\Main)->run(), "\n";
+var_dump(class_exists('CmpFixture'), $loader === require __DIR__ . '/vendor/autoload.php');`,
+	})
+
+	require.NoError(t, c.Install(InstallOptions{}))
+	require.Contains(t, out.String(), "No composer.lock file present")
+	require.Contains(t, out.String(), "Lock file operations: 3 installs, 0 updates, 0 removals")
+	require.Contains(t, out.String(), "  - Installing acme/greeter (1.1.0): Mirroring from")
+	require.Contains(t, out.String(), "Generating autoload files")
+
+	// The beta of 2.0 is skipped at the default minimum stability
+	require.Equal(t, map[string]string{"acme/greeter": "1.1.0", "acme/strings": "1.0.0", "acme/tools": "0.3.1"}, lockedVersions(t, dir))
+
+	lock, err := ReadLock(filepath.Join(dir, "composer.lock"))
+	require.NoError(t, err)
+	require.Len(t, lock.Packages, 2)
+	require.Len(t, lock.PackagesDev, 1)
+	require.Equal(t, "acme/tools", lock.PackagesDev[0].Name)
+	data, err := os.ReadFile(filepath.Join(dir, "composer.json"))
+	require.NoError(t, err)
+	hash, err := ContentHash(data)
+	require.NoError(t, err)
+	require.Equal(t, hash, lock.ContentHash)
+
+	require.FileExists(t, filepath.Join(dir, "vendor/acme/strings/src/Text.php"))
+	require.Equal(t, "hello WORLD! widget\nbool(true)\nbool(true)\n", runPHP(t, filepath.Join(dir, "run.php")))
+
+	// A second install has nothing to do, and --no-dev removes dev packages
+	out.Reset()
+	require.NoError(t, c.Install(InstallOptions{}))
+	require.Contains(t, out.String(), "Nothing to install, update or remove")
+	out.Reset()
+	require.NoError(t, c.Install(InstallOptions{NoDev: true}))
+	require.Contains(t, out.String(), "  - Removing acme/tools (0.3.1)")
+	require.NoDirExists(t, filepath.Join(dir, "vendor/acme/tools"))
+	classmap, err := os.ReadFile(filepath.Join(dir, "vendor/composer/autoload_classmap.php"))
+	require.NoError(t, err)
+	require.NotContains(t, string(classmap), "CmpFixture")
+	require.Contains(t, string(classmap), `'CmpLegacy\\Widget' => $vendorDir . '/acme/greeter/legacy/all.php',`)
+}
+
+func TestRequireAndPartialUpdate(t *testing.T) {
+	registryDir, repo := newRegistry(t)
+	c, dir, out := newTestComposer(t, repo, `{
+    "name": "acme/app",
+    "config": {"sort-packages": true}
+}`)
+
+	require.NoError(t, c.Require([]string{"acme/strings", "acme/tools"}, RequireOptions{}))
+	require.Contains(t, out.String(), "Using version ^1.0 for acme/strings")
+	require.Contains(t, out.String(), "Using version ^0.3 for acme/tools")
+	manifest, err := ReadManifest(filepath.Join(dir, "composer.json"))
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"acme/strings": "^1.0", "acme/tools": "^0.3"}, manifest.Require)
+
+	// A new release is only picked up by updates that include the package
+	writeFiles(t, registryDir, map[string]string{
+		"acme/strings/1.3.0/composer.json": `{"name": "acme/strings"}`,
+	})
+	require.NoError(t, c.Require([]string{"acme/greeter:^1.0"}, RequireOptions{}))
+	require.Equal(t, "1.0.0", lockedVersions(t, dir)["acme/strings"])
+
+	out.Reset()
+	require.NoError(t, c.Update([]string{"acme/strings"}, InstallOptions{}))
+	require.Contains(t, out.String(), "  - Upgrading acme/strings (1.0.0 => 1.3.0)")
+	require.Equal(t, "1.3.0", lockedVersions(t, dir)["acme/strings"])
+
+	// Requirements are kept sorted with sort-packages
+	data, err := os.ReadFile(filepath.Join(dir, "composer.json"))
+	require.NoError(t, err)
+	require.Equal(t, `{
+    "name": "acme/app",
+    "config": {
+        "sort-packages": true
+    },
+    "require": {
+        "acme/greeter": "^1.0",
+        "acme/strings": "^1.0",
+        "acme/tools": "^0.3"
+    }
+}
+`, string(data))
+
+	// A failing require restores composer.json
+	err = c.Require([]string{"acme/greeter:^3.0"}, RequireOptions{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Root composer.json requires acme/greeter ^3.0 -> found acme/greeter[1.0.0, 1.1.0, 2.0.0-beta1] but it does not match the constraint.")
+	after, err := os.ReadFile(filepath.Join(dir, "composer.json"))
+	require.NoError(t, err)
+	require.Equal(t, string(data), string(after))
+
+	err = c.Require([]string{"acme/future:^1.0"}, RequireOptions{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "acme/future 1.0.0 requires php >=9.0 but your php version (8.0.30) does not satisfy that requirement.")
+}
+
+func TestStabilityFlags(t *testing.T) {
+	_, repo := newRegistry(t)
+	c, dir, _ := newTestComposer(t, repo, `{"require": {"acme/greeter": "^2.0@beta", "acme/strings": "^2.0"}}`)
+	err := c.Update(nil, InstallOptions{NoInstall: true})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "acme/strings[1.0.0] but it does not match the constraint")
+
+	c, dir, _ = newTestComposer(t, repo, `{"require": {"acme/greeter": "^1.0 || ^2.0@beta"}, "minimum-stability": "beta"}`)
+	require.NoError(t, c.Update(nil, InstallOptions{NoInstall: true}))
+	// 2.0.0-beta1 needs acme/strings ^2.0, so the solver falls back to 1.1.0
+	require.Equal(t, "1.1.0", lockedVersions(t, dir)["acme/greeter"])
+	require.NoFileExists(t, filepath.Join(dir, "vendor/autoload.php"))
+}
+
+func TestPathAndGitRepositories(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"packages/logger/composer.json": `{"name": "acme/logger", "version": "1.0.0", "autoload": {"psr-4": {"CmpLogger\\": ""}}}`,
+		"packages/logger/Log.php": `<?php
+namespace CmpLogger;
+class Log { public static function line($s) { return "[log] " . $s; } }`,
+		"lib/composer.json": `{"name": "acme/lib", "require": {"acme/logger": "*"}, "autoload": {"psr-4": {"CmpLib\\": "src/"}}}`,
+		"lib/src/Version.php": `<?php
+namespace CmpLib;
+class Version { const NAME = "v1"; }`,
+	})
+	gitDir := filepath.Join(root, "lib")
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = gitDir
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	git("init", "--quiet", "--initial-branch=main")
+	git("add", "-A")
+	git("commit", "--quiet", "-m", "v1")
+	git("tag", "v1.0.0")
+	writeFiles(t, gitDir, map[string]string{"src/Version.php": `<?php
+namespace CmpLib;
+class Version { const NAME = "v2"; }`})
+	git("commit", "--quiet", "-am", "v2")
+	git("tag", "-a", "v2.0.0", "-m", "release 2")
+	writeFiles(t, gitDir, map[string]string{"src/Version.php": `<?php
+namespace CmpLib;
+class Version { const NAME = "main"; }`})
+	git("commit", "--quiet", "-am", "wip")
+
+	c, dir, out := newTestComposer(t, nil, `{
+    "repositories": [
+        {"type": "path", "url": "`+filepath.ToSlash(filepath.Join(root, "packages/*"))+`"},
+        {"type": "vcs", "url": "`+filepath.ToSlash(gitDir)+`"},
+        {"packagist.org": false}
+    ],
+    "require": {"acme/lib": "^1.0"}
+}`)
+	writeFiles(t, dir, map[string]string{"run.php": `<?php
+require __DIR__ . '/vendor/autoload.php';
+echo \CmpLogger\Log::line(\CmpLib\Version::NAME);`})
+
+	require.NoError(t, c.Install(InstallOptions{}))
+	require.Equal(t, map[string]string{"acme/lib": "1.0.0", "acme/logger": "1.0.0"}, lockedVersions(t, dir))
+	require.Contains(t, out.String(), "  - Installing acme/logger (1.0.0): Symlinking from")
+	info, err := os.Lstat(filepath.Join(dir, "vendor/acme/logger"))
+	require.NoError(t, err)
+	require.NotZero(t, info.Mode()&os.ModeSymlink)
+	require.Equal(t, "[log] v1", runPHP(t, filepath.Join(dir, "run.php")))
+
+	lock, err := ReadLock(filepath.Join(dir, "composer.lock"))
+	require.NoError(t, err)
+	require.Equal(t, "git", lock.Packages[0].Source.Type)
+	require.Len(t, lock.Packages[0].Source.Reference, 40)
+
+	// Annotated tags and branches are versions too
+	manifest, err := ReadManifest(filepath.Join(dir, "composer.json"))
+	require.NoError(t, err)
+	repos, err := c.repositories(manifest)
+	require.NoError(t, err)
+	versions, err := repos[1].FindPackages("acme/lib")
+	require.NoError(t, err)
+	var names []string
+	for _, pkg := range versions {
+		names = append(names, pkg.Version)
+	}
+	require.ElementsMatch(t, []string{"1.0.0", "2.0.0", "dev-main"}, names)
+
+	// Checked-out sources follow the lock on update
+	data, err := os.ReadFile(filepath.Join(dir, "composer.json"))
+	require.NoError(t, err)
+	writeFiles(t, dir, map[string]string{"composer.json": strings.Replace(string(data), `"^1.0"`, `"dev-main"`, 1)})
+	out.Reset()
+	require.NoError(t, c.Update(nil, InstallOptions{}))
+	require.Contains(t, out.String(), "  - Downgrading acme/lib (1.0.0 => dev-main)")
+	version, err := os.ReadFile(filepath.Join(dir, "vendor/acme/lib/src/Version.php"))
+	require.NoError(t, err)
+	require.Contains(t, string(version), `const NAME = "main";`)
+}
+
+func TestInit(t *testing.T) {
+	_, repo := newRegistry(t)
+	c, dir, _ := newTestComposer(t, repo, "")
+	require.NoError(t, c.Init(InitOptions{
+		Name:        "acme/my-lib",
+		Description: "A library",
+		Type:        "library",
+		License:     "MIT",
+		Authors:     []string{"Jane Doe <jane@example.com>"},
+		Require:     []string{"acme/strings:^1.0", "acme/tools"},
+		Autoload:    "src",
+	}))
+	data, err := os.ReadFile(filepath.Join(dir, "composer.json"))
+	require.NoError(t, err)
+	require.Equal(t, `{
+    "name": "acme/my-lib",
+    "description": "A library",
+    "type": "library",
+    "license": "MIT",
+    "autoload": {
+        "psr-4": {
+            "Acme\\MyLib\\": "src/"
+        }
+    },
+    "authors": [
+        {
+            "name": "Jane Doe",
+            "email": "jane@example.com"
+        }
+    ],
+    "require": {
+        "acme/strings": "^1.0",
+        "acme/tools": "^0.3"
+    }
+}
+`, string(data))
+	result := ValidateManifest(data)
+	require.Empty(t, result.Errors)
+	require.Empty(t, result.PublishErrors)
+
+	err = c.Init(InitOptions{Name: "acme/other"})
+	require.ErrorContains(t, err, "composer.json already exists")
+
+	c, _, _ = newTestComposer(t, repo, "")
+	require.ErrorContains(t, c.Init(InitOptions{Name: "Not Valid"}), "is invalid")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		json          string
+		errors        []string
+		publishErrors []string
+		warnings      []string
+	}{
+		{
+			name: "valid",
+			json: `{"name": "acme/app", "description": "An app", "license": "MIT", "require": {"php": ">=8.0 <9.0", "acme/lib": "^1.2"}}`,
+		},
+		{
+			name:          "missing fields",
+			json:          `{"require": {"acme/lib": "*"}}`,
+			publishErrors: []string{"name : The property name is required", "description : The property description is required"},
+			warnings:      []string{"No license specified", "require.acme/lib : unbound version constraints (*) should be avoided"},
+		},
+		{
+			name:     "version field",
+			json:     `{"name": "acme/app", "description": "d", "license": "MIT", "version": "1.0.0"}`,
+			warnings: []string{"The version field is present"},
+		},
+		{
+			name:          "uppercase name",
+			json:          `{"name": "Acme/App", "description": "d", "license": "MIT"}`,
+			publishErrors: []string{"name : Acme/App is invalid, it should not contain uppercase characters. We suggest using acme/app instead."},
+		},
+		{
+			name: "schema errors",
+			json: `{"name": "acme/app", "description": "d", "license": "MIT", "require": "acme/lib",
+				"minimum-stability": "nightly", "prefer-stable": "yes",
+				"autoload": {"psr-4": {"Acme": "src/"}, "psr-5": {}},
+				"repositories": [{"type": "path"}, {"type": "svnx", "url": "x"}]}`,
+			errors: []string{
+				"require : String value found, but an object is required",
+				"prefer-stable : String value found, but a boolean is required",
+				"minimum-stability : Does not have a value in the enumeration",
+				`autoload.psr-4 : invalid value (Acme), namespaces must end with a namespace separator, should be Acme\\`,
+				"autoload : The property psr-5 is not defined",
+				"repositories[0].url : The property url is required",
+				"repositories[1].type : Does not have a value in the enumeration of repository types (svnx)",
+			},
+		},
+		{
+			name:   "invalid constraint",
+			json:   `{"name": "acme/app", "description": "d", "license": "MIT", "require": {"acme/lib": "^^1", "acme/app": "^1.0"}}`,
+			errors: []string{"require.acme/lib : invalid version constraint", "require.acme/app : a package cannot set a require on itself"},
+		},
+		{
+			name:   "invalid json",
+			json:   `{"name": `,
+			errors: []string{"composer.json does not contain valid JSON"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ValidateManifest([]byte(tt.json))
+			assertMessages(t, tt.errors, result.Errors)
+			assertMessages(t, tt.publishErrors, result.PublishErrors)
+			assertMessages(t, tt.warnings, result.Warnings)
+		})
+	}
+}
+
+func assertMessages(t *testing.T, expected []string, actual []string) {
+	t.Helper()
+	require.Len(t, actual, len(expected), "messages: %q", actual)
+	for _, want := range expected {
+		found := false
+		for _, msg := range actual {
+			if strings.Contains(msg, want) {
+				found = true
+				break
+			}
+		}
+		require.True(t, found, "expected a message containing %q in %q", want, actual)
+	}
+}
+
+func TestValidateDetectsStaleLock(t *testing.T) {
+	_, repo := newRegistry(t)
+	c, dir, _ := newTestComposer(t, repo, `{"name": "acme/app", "description": "d", "license": "MIT", "require": {"acme/tools": "^0.3"}}`)
+	require.NoError(t, c.Update(nil, InstallOptions{NoInstall: true}))
+	result, err := c.Validate(ValidateOptions{})
+	require.NoError(t, err)
+	require.Empty(t, result.LockErrors)
+
+	writeFiles(t, dir, map[string]string{"composer.json": `{"name": "acme/app", "description": "d", "license": "MIT", "require": {"acme/tools": "^0.3.1"}}`})
+	result, err = c.Validate(ValidateOptions{})
+	require.NoError(t, err)
+	require.Len(t, result.LockErrors, 1)
+	require.Contains(t, result.LockErrors[0], "The lock file is not up to date")
+
+	// Changes that do not affect resolution keep the lock fresh
+	require.NoError(t, c.Update(nil, InstallOptions{NoInstall: true}))
+	writeFiles(t, dir, map[string]string{"composer.json": `{"name": "acme/app", "description": "changed", "license": "MIT", "require": {"acme/tools": "^0.3.1"}}`})
+	result, err = c.Validate(ValidateOptions{})
+	require.NoError(t, err)
+	require.Empty(t, result.LockErrors)
+}
+
+func TestFindClasses(t *testing.T) {
+	source := `<?php
+namespace Foo\Bar;
+
+use Other\Thing;
+
+/** class InDocComment */
+interface Shape {}
+abstract class Base implements Shape {}
+final class Circle extends Base {
+    public function name() { return static::class . Thing::class; }
+    public function make() { return new class extends Base {}; }
+}
+trait Helper {}
+enum Suit { case Hearts; }
+
+namespace Global\Stuff;
+class Other {}
+`
+	require.Equal(t, []string{
+		`Foo\Bar\Shape`, `Foo\Bar\Base`, `Foo\Bar\Circle`, `Foo\Bar\Helper`, `Foo\Bar\Suit`, `Global\Stuff\Other`,
+	}, findClasses(source))
+}
@@ -0,0 +1,194 @@
+package composer
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// InitOptions are the fields of a new composer.json. Empty fields are left
+// out, except for the name, which defaults to <user>/<directory>.
+type InitOptions struct {
+	Name        string
+	Description string
+	Type        string
+	Homepage    string
+	License     string
+	// Authors are "Name <email>" strings
+	Authors []string
+	// Require and RequireDev are "vendor/package:constraint" strings
+	Require          []string
+	RequireDev       []string
+	MinimumStability string
+	// Autoload is a directory mapped to the package's PSR-4 namespace
+	Autoload string
+}
+
+var authorRegex = regexp.MustCompile(`^(.+?)\s*<(.+)>$`)
+
+// Init writes a new composer.json. It fails if one already exists.
+func (c *Composer) Init(opts InitOptions) error {
+	if _, err := os.Stat(c.manifestPath()); err == nil {
+		return fmt.Errorf("composer.json already exists in %s", c.dir)
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = defaultPackageName(c.dir)
+	}
+	if !packageNameRegex.MatchString(name) {
+		return fmt.Errorf("the package name %s is invalid, it should be lowercase and have a vendor name, a forward slash, and a package name, matching: [a-z0-9_.-]+/[a-z0-9_.-]+", name)
+	}
+	if opts.MinimumStability != "" {
+		if _, ok := ParseStability(opts.MinimumStability); !ok {
+			return fmt.Errorf("invalid minimum stability %q, must be one of stable, RC, beta, alpha, dev", opts.MinimumStability)
+		}
+	}
+
+	root := newJSONObject()
+	set := func(key string, value interface{}) error {
+		return root.SetValue(key, value)
+	}
+	if err := set("name", name); err != nil {
+		return err
+	}
+	for _, field := range []struct{ key, value string }{
+		{"description", opts.Description},
+		{"type", opts.Type},
+		{"homepage", opts.Homepage},
+		{"license", opts.License},
+	} {
+		if field.value != "" {
+			if err := set(field.key, field.value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.Autoload != "" {
+		dir := strings.TrimSuffix(filepath.ToSlash(opts.Autoload), "/") + "/"
+		autoload := newJSONObject()
+		psr4 := newJSONObject()
+		if err := psr4.SetValue(namespaceFromName(name), dir); err != nil {
+			return err
+		}
+		if err := autoload.SetValue("psr-4", psr4); err != nil {
+			return err
+		}
+		if err := set("autoload", autoload); err != nil {
+			return err
+		}
+	}
+
+	if len(opts.Authors) > 0 {
+		var authors []Author
+		for _, author := range opts.Authors {
+			m := authorRegex.FindStringSubmatch(author)
+			if m == nil {
+				return fmt.Errorf("invalid author string %q, it must be in the format: John Smith <john@example.com>", author)
+			}
+			authors = append(authors, Author{Name: m[1], Email: m[2]})
+		}
+		if err := set("authors", authors); err != nil {
+			return err
+		}
+	}
+	if opts.MinimumStability != "" {
+		if err := set("minimum-stability", opts.MinimumStability); err != nil {
+			return err
+		}
+	}
+
+	manifest := &Manifest{}
+	manifest.Name = name
+	manifest.MinimumStability = opts.MinimumStability
+	for _, section := range []struct {
+		key  string
+		args []string
+	}{{"require", opts.Require}, {"require-dev", opts.RequireDev}} {
+		links, err := c.parseRequirements(manifest, section.args)
+		if err != nil {
+			return err
+		}
+		requires := newJSONObject()
+		for _, link := range links {
+			if err := requires.SetValue(link.key, link.value); err != nil {
+				return err
+			}
+		}
+		if section.key == "require" || len(links) > 0 {
+			if err := set(section.key, requires); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := formatJSON(root)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.manifestPath(), data, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Writing ./composer.json\n")
+	if opts.Autoload != "" {
+		fmt.Fprintf(c.out, "PSR-4 autoloading configured. Use \"namespace %s;\" in %s\n",
+			strings.TrimSuffix(namespaceFromName(name), "\\"), opts.Autoload)
+	}
+	return nil
+}
+
+// defaultPackageName derives <user>/<directory> from the environment
+func defaultPackageName(dir string) string {
+	vendor := os.Getenv("COMPOSER_DEFAULT_VENDOR")
+	if vendor == "" {
+		if u, err := user.Current(); err == nil {
+			vendor = u.Username
+		}
+	}
+	return sanitizeNamePart(vendor, "vendor") + "/" + sanitizeNamePart(filepath.Base(dir), "package")
+}
+
+var nameSeparatorRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+func sanitizeNamePart(s, fallback string) string {
+	// CamelCase becomes camel-case, as Composer does
+	var sb strings.Builder
+	for i, r := range s {
+		if r >= 'A' && r <= 'Z' && i > 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteRune(r)
+	}
+	part := strings.Trim(nameSeparatorRegex.ReplaceAllString(strings.ToLower(sb.String()), "-"), "-")
+	if part == "" {
+		return fallback
+	}
+	return part
+}
+
+// namespaceFromName turns acme/my-lib into Acme\MyLib\
+func namespaceFromName(name string) string {
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		var sb strings.Builder
+		upper := true
+		for _, r := range part {
+			if r == '-' || r == '_' || r == '.' {
+				upper = true
+				continue
+			}
+			if upper {
+				sb.WriteString(strings.ToUpper(string(r)))
+				upper = false
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+		parts = append(parts, sb.String())
+	}
+	return strings.Join(parts, "\\") + "\\"
+}
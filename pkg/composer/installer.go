@@ -0,0 +1,334 @@
+package composer
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// installedFile is vendor/composer/installed.json, which records what is
+// installed in the vendor directory.
+type installedFile struct {
+	Packages        []*installedPackage `json:"packages"`
+	Dev             bool                `json:"dev"`
+	DevPackageNames []string            `json:"dev-package-names"`
+}
+
+// installedPackage is a locked package with its install path relative to
+// vendor/composer
+type installedPackage struct {
+	*Package
+	InstallPath string `json:"install-path"`
+}
+
+// installer brings the vendor directory in line with a set of packages.
+type installer struct {
+	rootDir   string
+	vendorDir string
+	client    *http.Client
+	out       io.Writer
+}
+
+func readInstalled(vendorDir string) map[string]*Package {
+	installed := make(map[string]*Package)
+	data, err := os.ReadFile(filepath.Join(vendorDir, "composer", "installed.json"))
+	if err != nil {
+		return installed
+	}
+	var file installedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return installed
+	}
+	for _, entry := range file.Packages {
+		if entry.Package != nil {
+			installed[strings.ToLower(entry.Name)] = entry.Package
+		}
+	}
+	return installed
+}
+
+// install installs packages, updating or removing whatever the vendor
+// directory held before, and records the result in installed.json.
+func (in *installer) install(packages []*Package, devNames []string, dev bool) error {
+	installed := readInstalled(in.vendorDir)
+
+	var installs, updates, removals []string
+	wanted := make(map[string]bool, len(packages))
+	type operation struct {
+		pkg     *Package
+		message string
+	}
+	var ops []operation
+	for _, pkg := range packages {
+		name := strings.ToLower(pkg.Name)
+		wanted[name] = true
+		old, ok := installed[name]
+		dir := packageDir(in.vendorDir, pkg.Name)
+		_, statErr := os.Lstat(dir)
+		switch {
+		case !ok || statErr != nil:
+			installs = append(installs, name)
+			ops = append(ops, operation{pkg, fmt.Sprintf("Installing %s", pkg.PrettyName())})
+		case old.Version != pkg.Version || old.Reference() != pkg.Reference():
+			updates = append(updates, name)
+			verb := "Upgrading"
+			if oldVersion, err := ParseVersion(old.Version); err == nil && oldVersion.Compare(pkg.version) > 0 {
+				verb = "Downgrading"
+			}
+			ops = append(ops, operation{pkg, fmt.Sprintf("%s %s (%s => %s)", verb, pkg.Name, old.Version, pkg.Version)})
+		}
+	}
+	for _, name := range sortedStrings(installed) {
+		if !wanted[name] {
+			removals = append(removals, name)
+		}
+	}
+
+	if len(ops) == 0 && len(removals) == 0 {
+		fmt.Fprintln(in.out, "Nothing to install, update or remove")
+	} else {
+		fmt.Fprintf(in.out, "Package operations: %s, %s, %s\n",
+			plural(len(installs), "install"), plural(len(updates), "update"), plural(len(removals), "removal"))
+	}
+	for _, name := range removals {
+		fmt.Fprintf(in.out, "  - Removing %s\n", installed[name].PrettyName())
+		if err := os.RemoveAll(packageDir(in.vendorDir, installed[name].Name)); err != nil {
+			return err
+		}
+	}
+	for _, op := range ops {
+		method, err := in.installPackage(op.pkg)
+		if err != nil {
+			return fmt.Errorf("failed to install %s: %w", op.pkg.PrettyName(), err)
+		}
+		fmt.Fprintf(in.out, "  - %s: %s\n", op.message, method)
+	}
+
+	return in.writeInstalled(packages, devNames, dev)
+}
+
+func (in *installer) writeInstalled(packages []*Package, devNames []string, dev bool) error {
+	file := installedFile{Packages: []*installedPackage{}, Dev: dev, DevPackageNames: devNames}
+	if file.DevPackageNames == nil {
+		file.DevPackageNames = []string{}
+	}
+	for _, pkg := range packages {
+		file.Packages = append(file.Packages, &installedPackage{Package: pkg, InstallPath: "../" + pkg.Name})
+	}
+	if err := os.MkdirAll(filepath.Join(in.vendorDir, "composer"), 0755); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(in.vendorDir, "composer", "installed.json"), file)
+}
+
+// installPackage puts pkg's files into the vendor directory, returning how
+// it was done for the progress message
+func (in *installer) installPackage(pkg *Package) (string, error) {
+	dir := packageDir(in.vendorDir, pkg.Name)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+
+	useSource := pkg.Source != nil && (pkg.Dist == nil || pkg.version.IsBranch())
+	switch {
+	case useSource:
+		if pkg.Source.Type != "git" {
+			return "", fmt.Errorf("unsupported source type %q", pkg.Source.Type)
+		}
+		return "Cloning " + shortReference(pkg.Source.Reference), in.cloneGit(pkg.Source, dir)
+	case pkg.Dist == nil:
+		return "", fmt.Errorf("package has no source or dist")
+	case pkg.Dist.Type == "path":
+		return in.installPath(pkg, dir)
+	case pkg.Dist.Type == "zip":
+		return "Extracting archive", in.installZip(pkg.Dist, dir)
+	}
+	return "", fmt.Errorf("unsupported dist type %q", pkg.Dist.Type)
+}
+
+func shortReference(ref string) string {
+	if len(ref) > 10 {
+		return ref[:10]
+	}
+	return ref
+}
+
+func (in *installer) cloneGit(source *Source, dir string) error {
+	if _, err := runGit("", "clone", "--quiet", "--no-checkout", source.URL, dir); err != nil {
+		return err
+	}
+	_, err := runGit(dir, "checkout", "--quiet", source.Reference)
+	return err
+}
+
+// installPath symlinks or copies a path repository package
+func (in *installer) installPath(pkg *Package, dir string) (string, error) {
+	source := filepath.FromSlash(pkg.Dist.URL)
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(in.rootDir, source)
+	}
+	if _, err := os.Stat(source); err != nil {
+		return "", fmt.Errorf("source path %q is not found", pkg.Dist.URL)
+	}
+
+	opts := pkg.TransportOptions
+	if opts == nil || opts.Symlink == nil || *opts.Symlink {
+		target, err := filepath.Rel(filepath.Dir(dir), source)
+		if err != nil {
+			target = source
+		}
+		if err := os.Symlink(target, dir); err == nil {
+			return "Symlinking from " + pkg.Dist.URL, nil
+		} else if opts != nil && opts.Symlink != nil {
+			return "", err
+		}
+	}
+	return "Mirroring from " + pkg.Dist.URL, copyTree(source, dir)
+}
+
+// copyTree copies the directory src to dst, skipping VCS metadata
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (d.Name() == ".git" || d.Name() == ".hg" || d.Name() == ".svn") {
+			return filepath.SkipDir
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, info.Mode().Perm())
+	})
+}
+
+// installZip downloads and extracts a zip dist. Like Composer, a single
+// top-level directory in the archive is stripped.
+func (in *installer) installZip(dist *Dist, dir string) error {
+	data, err := in.download(dist.URL)
+	if err != nil {
+		return err
+	}
+	if dist.Shasum != "" {
+		sum := sha1.Sum(data)
+		if hex.EncodeToString(sum[:]) != dist.Shasum {
+			return fmt.Errorf("the checksum verification of %s failed", dist.URL)
+		}
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("%s is not a valid zip archive: %w", dist.URL, err)
+	}
+
+	prefix := ""
+	for i, f := range archive.File {
+		top, _, _ := strings.Cut(f.Name, "/")
+		if i == 0 {
+			prefix = top + "/"
+		}
+		if !strings.HasPrefix(f.Name, prefix) || f.Name == top {
+			prefix = ""
+			break
+		}
+	}
+
+	for _, f := range archive.File {
+		name := strings.TrimPrefix(f.Name, prefix)
+		if name == "" {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %q escapes the package directory", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := extractFile(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, target string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	mode := f.Mode().Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	w, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (in *installer) download(url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return os.ReadFile(strings.TrimPrefix(url, "file://"))
+	}
+	resp, err := in.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s failed: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// plural formats "1 install" and "2 installs"
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
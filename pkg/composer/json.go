@@ -0,0 +1,311 @@
+package composer
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonObject is a JSON object that remembers the order of its keys, so
+// composer.json can be edited without reshuffling it.
+type jsonObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]json.RawMessage)}
+}
+
+func (o *jsonObject) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("expected a JSON object")
+	}
+	o.keys = nil
+	o.values = make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		o.Set(key, raw)
+	}
+	_, err = dec.Token()
+	return err
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := marshalJSON(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(o.values[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (o *jsonObject) Has(key string) bool {
+	_, ok := o.values[key]
+	return ok
+}
+
+func (o *jsonObject) Get(key string) json.RawMessage {
+	return o.values[key]
+}
+
+// Set stores raw under key, appending the key if it is new.
+func (o *jsonObject) Set(key string, raw json.RawMessage) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = raw
+}
+
+// SetValue marshals v and stores it under key.
+func (o *jsonObject) SetValue(key string, v interface{}) error {
+	raw, err := marshalJSON(v)
+	if err != nil {
+		return err
+	}
+	o.Set(key, raw)
+	return nil
+}
+
+func (o *jsonObject) Delete(key string) {
+	if _, ok := o.values[key]; !ok {
+		return
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// marshalJSON encodes v without Go's HTML escaping, as Composer writes
+// "<" and "&" literally
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// formatJSON pretty-prints v with four-space indentation and a trailing
+// newline, the layout Composer uses for composer.json and composer.lock.
+func formatJSON(v interface{}) ([]byte, error) {
+	raw, err := marshalJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "    "); err != nil {
+		return nil, err
+	}
+	// Composer writes empty objects and arrays as {} and [] on one line,
+	// which json.Indent already does
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := formatJSON(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// orderedPair is one member of a decoded object in decodeOrdered's output
+type orderedPair struct {
+	key   string
+	value interface{}
+}
+
+// decodeOrdered decodes JSON into nil, bool, json.Number, string,
+// []interface{} and []orderedPair values, keeping object key order.
+func decodeOrdered(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeOrderedValue(dec)
+}
+
+func decodeOrderedValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+	switch delim {
+	case '{':
+		pairs := []orderedPair{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, orderedPair{key: keyTok.(string), value: value})
+		}
+		_, err := dec.Token()
+		return pairs, err
+	case '[':
+		list := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrderedValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := dec.Token()
+		return list, err
+	}
+	return nil, fmt.Errorf("unexpected JSON delimiter %v", delim)
+}
+
+// phpJSONEncode encodes a decodeOrdered value the way PHP's json_encode
+// does with no flags: slashes and non-ASCII characters are escaped and
+// objects decoded as associative arrays are written as lists when they are
+// empty. Composer hashes this encoding for the lock file's content-hash.
+func phpJSONEncode(v interface{}) string {
+	var sb strings.Builder
+	writePHPJSON(&sb, v)
+	return sb.String()
+}
+
+func writePHPJSON(sb *strings.Builder, v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		sb.WriteString("null")
+	case bool:
+		if val {
+			sb.WriteString("true")
+		} else {
+			sb.WriteString("false")
+		}
+	case json.Number:
+		sb.WriteString(val.String())
+	case string:
+		writePHPJSONString(sb, val)
+	case []interface{}:
+		sb.WriteByte('[')
+		for i, item := range val {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writePHPJSON(sb, item)
+		}
+		sb.WriteByte(']')
+	case []orderedPair:
+		if len(val) == 0 {
+			sb.WriteString("[]")
+			return
+		}
+		sb.WriteByte('{')
+		for i, pair := range val {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writePHPJSONString(sb, pair.key)
+			sb.WriteByte(':')
+			writePHPJSON(sb, pair.value)
+		}
+		sb.WriteByte('}')
+	}
+}
+
+func writePHPJSONString(sb *strings.Builder, s string) {
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '/':
+			sb.WriteString(`\/`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			switch {
+			case r < 0x20:
+				fmt.Fprintf(sb, `\u%04x`, r)
+			case r < utf8.RuneSelf:
+				sb.WriteRune(r)
+			case r > 0xFFFF:
+				r -= 0x10000
+				fmt.Fprintf(sb, `\u%04x\u%04x`, 0xD800+(r>>10), 0xDC00+(r&0x3FF))
+			default:
+				fmt.Fprintf(sb, `\u%04x`, r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+}
+
+// sortedStrings returns the keys of m in order
+func sortedStrings[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortPairs orders object members by key, like PHP's ksort
+func sortPairs(pairs []orderedPair) {
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].key < pairs[j].key
+	})
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
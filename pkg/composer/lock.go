@@ -0,0 +1,150 @@
+package composer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// lockReadme is the notice at the top of every composer.lock
+var lockReadme = []string{
+	"This file locks the dependencies of your project to a known state",
+	"Read more about it at https://getcomposer.org/doc/01-basic-usage.md#installing-dependencies",
+	"This file is @generated automatically",
+}
+
+// Lock is the contents of composer.lock.
+type Lock struct {
+	Readme           []string          `json:"_readme"`
+	ContentHash      string            `json:"content-hash"`
+	Packages         []*Package        `json:"packages"`
+	PackagesDev      []*Package        `json:"packages-dev"`
+	Aliases          []interface{}     `json:"aliases"`
+	MinimumStability string            `json:"minimum-stability"`
+	StabilityFlags   phpMap[Stability] `json:"stability-flags"`
+	PreferStable     bool              `json:"prefer-stable"`
+	PreferLowest     bool              `json:"prefer-lowest"`
+	Platform         phpMap[string]    `json:"platform"`
+	PlatformDev      phpMap[string]    `json:"platform-dev"`
+	PluginAPIVersion string            `json:"plugin-api-version,omitempty"`
+}
+
+// phpMap is a string-keyed map that is written as [] when empty, as PHP
+// encodes an empty array.
+type phpMap[T any] map[string]T
+
+func (m phpMap[T]) MarshalJSON() ([]byte, error) {
+	if len(m) == 0 {
+		return []byte("[]"), nil
+	}
+	return marshalJSON(map[string]T(m))
+}
+
+func (m *phpMap[T]) UnmarshalJSON(data []byte) error {
+	if strings.TrimSpace(string(data)) == "[]" {
+		*m = nil
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]T)(m))
+}
+
+// ReadLock reads and decodes a composer.lock file.
+func ReadLock(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lock := &Lock{}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("%s does not contain valid JSON: %w", path, err)
+	}
+	for _, pkg := range lock.allPackages() {
+		if err := pkg.setVersion(pkg.Version); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, pkg.Name, err)
+		}
+	}
+	return lock, nil
+}
+
+// Write writes the lock file to path.
+func (l *Lock) Write(path string) error {
+	return writeJSONFile(path, l)
+}
+
+func (l *Lock) allPackages() []*Package {
+	return append(append([]*Package(nil), l.Packages...), l.PackagesDev...)
+}
+
+// newLock builds the lock for a resolved package set. Packages reachable
+// from the root's require section are locked as packages, the rest as
+// packages-dev.
+func newLock(manifest *Manifest, resolved []*Package) (*Lock, error) {
+	hash, err := ContentHash(manifest.raw)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*Package, len(resolved))
+	for _, pkg := range resolved {
+		byName[strings.ToLower(pkg.Name)] = pkg
+		for _, links := range []map[string]string{pkg.Replace, pkg.Provide} {
+			for name := range links {
+				if _, ok := byName[strings.ToLower(name)]; !ok {
+					byName[strings.ToLower(name)] = pkg
+				}
+			}
+		}
+	}
+	prod := make(map[*Package]bool)
+	var visit func(links map[string]string)
+	visit = func(links map[string]string) {
+		for name := range links {
+			if pkg, ok := byName[strings.ToLower(name)]; ok && !prod[pkg] {
+				prod[pkg] = true
+				visit(pkg.Require)
+			}
+		}
+	}
+	visit(manifest.Require)
+
+	lock := &Lock{
+		Readme:           lockReadme,
+		ContentHash:      hash,
+		Packages:         []*Package{},
+		PackagesDev:      []*Package{},
+		Aliases:          []interface{}{},
+		MinimumStability: manifest.minimumStability().String(),
+		StabilityFlags:   phpMap[Stability](manifest.stabilityFlags()),
+		PreferStable:     manifest.PreferStable,
+		Platform:         platformRequirements(manifest.Require),
+		PlatformDev:      platformRequirements(manifest.RequireDev),
+		PluginAPIVersion: "2.6.0",
+	}
+	for _, pkg := range resolved {
+		if prod[pkg] {
+			lock.Packages = append(lock.Packages, pkg)
+		} else {
+			lock.PackagesDev = append(lock.PackagesDev, pkg)
+		}
+	}
+	sortPackages(lock.Packages)
+	sortPackages(lock.PackagesDev)
+	return lock, nil
+}
+
+// platformRequirements returns the php and extension requirements of links
+func platformRequirements(links map[string]string) phpMap[string] {
+	platform := phpMap[string]{}
+	for name, constraint := range links {
+		if isPlatformPackage(name) {
+			platform[strings.ToLower(name)] = constraint
+		}
+	}
+	return platform
+}
+
+func sortPackages(packages []*Package) {
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+}
@@ -0,0 +1,329 @@
+package composer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Package is a version of a package, as described by its composer.json and
+// recorded in composer.lock.
+type Package struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version,omitempty"`
+	Source      *Source           `json:"source,omitempty"`
+	Dist        *Dist             `json:"dist,omitempty"`
+	Require     map[string]string `json:"require,omitempty"`
+	RequireDev  map[string]string `json:"require-dev,omitempty"`
+	Conflict    map[string]string `json:"conflict,omitempty"`
+	Replace     map[string]string `json:"replace,omitempty"`
+	Provide     map[string]string `json:"provide,omitempty"`
+	Suggest     map[string]string `json:"suggest,omitempty"`
+	Bin         []string          `json:"bin,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Extra       json.RawMessage   `json:"extra,omitempty"`
+	Autoload    *Autoload         `json:"autoload,omitempty"`
+	AutoloadDev *Autoload         `json:"autoload-dev,omitempty"`
+	License     StringList        `json:"license,omitempty"`
+	Authors     []Author          `json:"authors,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Homepage    string            `json:"homepage,omitempty"`
+	Keywords    []string          `json:"keywords,omitempty"`
+	Time        string            `json:"time,omitempty"`
+
+	// TransportOptions carries installation options of path repositories
+	TransportOptions *TransportOptions `json:"transport-options,omitempty"`
+
+	// version is the parsed Version
+	version Version
+}
+
+// Source locates a package's VCS checkout.
+type Source struct {
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+	Reference string `json:"reference"`
+}
+
+// Dist locates a package archive or directory.
+type Dist struct {
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+	Reference string `json:"reference,omitempty"`
+	Shasum    string `json:"shasum,omitempty"`
+}
+
+// TransportOptions are the options of the repository a package came from
+// that affect how it is installed.
+type TransportOptions struct {
+	// Symlink selects symlinking (true) or copying (false) path packages;
+	// when unset a symlink is tried first
+	Symlink *bool `json:"symlink,omitempty"`
+	// Relative records that the dist URL is relative to the project root
+	Relative bool `json:"relative,omitempty"`
+}
+
+// Author is an entry of the authors list.
+type Author struct {
+	Name     string `json:"name"`
+	Email    string `json:"email,omitempty"`
+	Homepage string `json:"homepage,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+// Autoload is the autoload or autoload-dev section of a package.
+type Autoload struct {
+	PSR4                map[string]PathList `json:"psr-4,omitempty"`
+	PSR0                map[string]PathList `json:"psr-0,omitempty"`
+	Classmap            []string            `json:"classmap,omitempty"`
+	Files               []string            `json:"files,omitempty"`
+	ExcludeFromClassmap []string            `json:"exclude-from-classmap,omitempty"`
+}
+
+// StringList is a list of strings that may be written as a single string,
+// as licenses and autoload paths can be.
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = StringList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// PathList is a list of autoload paths. Like StringList it may be written
+// as a single string, and a single path is written back that way.
+type PathList []string
+
+func (l *PathList) UnmarshalJSON(data []byte) error {
+	return (*StringList)(l).UnmarshalJSON(data)
+}
+
+func (l PathList) MarshalJSON() ([]byte, error) {
+	if len(l) == 1 {
+		return marshalJSON(l[0])
+	}
+	return marshalJSON([]string(l))
+}
+
+// PrettyName returns "name (version)" for messages
+func (p *Package) PrettyName() string {
+	return fmt.Sprintf("%s (%s)", p.Name, p.Version)
+}
+
+// ParsedVersion returns the package's normalized version.
+func (p *Package) ParsedVersion() Version {
+	return p.version
+}
+
+// setVersion sets the pretty version and caches its parsed form
+func (p *Package) setVersion(version string) error {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return err
+	}
+	p.Version = version
+	p.version = v
+	return nil
+}
+
+// Reference returns the source or dist reference identifying the exact
+// contents of the package.
+func (p *Package) Reference() string {
+	if p.Source != nil && p.Source.Reference != "" {
+		return p.Source.Reference
+	}
+	if p.Dist != nil {
+		return p.Dist.Reference
+	}
+	return ""
+}
+
+// Manifest is the root composer.json.
+type Manifest struct {
+	Package
+	MinimumStability string            `json:"minimum-stability,omitempty"`
+	PreferStable     bool              `json:"prefer-stable,omitempty"`
+	Repositories     RepositoryConfigs `json:"repositories,omitempty"`
+	Config           *Config           `json:"config,omitempty"`
+
+	// raw is the file as written, for editing and the content hash
+	raw []byte
+}
+
+// Config is the config section of composer.json.
+type Config struct {
+	VendorDir    string            `json:"vendor-dir,omitempty"`
+	SortPackages bool              `json:"sort-packages,omitempty"`
+	Platform     map[string]string `json:"platform,omitempty"`
+}
+
+// RepositoryConfig is an entry of the repositories section.
+type RepositoryConfig struct {
+	Type    string                 `json:"type"`
+	URL     string                 `json:"url"`
+	Options map[string]interface{} `json:"options,omitempty"`
+	// Disabled marks a {"packagist.org": false} entry
+	Disabled string `json:"-"`
+}
+
+// RepositoryConfigs accepts repositories written as a list or as an object
+// keyed by name.
+type RepositoryConfigs []RepositoryConfig
+
+func (r *RepositoryConfigs) UnmarshalJSON(data []byte) error {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		var named jsonObject
+		if err := named.UnmarshalJSON(data); err != nil {
+			return fmt.Errorf("repositories must be an array or an object")
+		}
+		for _, key := range named.keys {
+			entry, err := parseRepositoryConfig(key, named.values[key])
+			if err != nil {
+				return err
+			}
+			*r = append(*r, entry)
+		}
+		return nil
+	}
+	for _, raw := range entries {
+		entry, err := parseRepositoryConfig("", raw)
+		if err != nil {
+			return err
+		}
+		*r = append(*r, entry)
+	}
+	return nil
+}
+
+func parseRepositoryConfig(name string, raw json.RawMessage) (RepositoryConfig, error) {
+	var disabled bool
+	if err := json.Unmarshal(raw, &disabled); err == nil {
+		if disabled {
+			return RepositoryConfig{}, fmt.Errorf("repository %q must be an object or false", name)
+		}
+		return RepositoryConfig{Disabled: name}, nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return RepositoryConfig{}, fmt.Errorf("repositories must be objects")
+	}
+	if len(object) == 1 {
+		for key, value := range object {
+			if string(value) == "false" {
+				return RepositoryConfig{Disabled: key}, nil
+			}
+		}
+	}
+	var entry RepositoryConfig
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return RepositoryConfig{}, err
+	}
+	return entry, nil
+}
+
+// ReadManifest reads and decodes a composer.json file.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(data)
+}
+
+// ParseManifest decodes the contents of a composer.json file.
+func ParseManifest(data []byte) (*Manifest, error) {
+	m := &Manifest{raw: data}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("composer.json does not contain valid JSON: %w", err)
+	}
+	return m, nil
+}
+
+// vendorDir returns the vendor directory relative to the project root
+func (m *Manifest) vendorDir() string {
+	if m.Config != nil && m.Config.VendorDir != "" {
+		return m.Config.VendorDir
+	}
+	return "vendor"
+}
+
+// minimumStability returns the parsed minimum-stability, stable by default
+func (m *Manifest) minimumStability() Stability {
+	if s, ok := ParseStability(m.MinimumStability); ok {
+		return s
+	}
+	return StabilityStable
+}
+
+// stabilityFlags returns the stabilities root requirements explicitly
+// allow, like "^2.0@beta" or "dev-main"
+func (m *Manifest) stabilityFlags() map[string]Stability {
+	flags := make(map[string]Stability)
+	for _, reqs := range []map[string]string{m.Require, m.RequireDev} {
+		for name, constraint := range reqs {
+			if s, ok := constraintStability(constraint); ok {
+				flags[strings.ToLower(name)] = s
+			}
+		}
+	}
+	return flags
+}
+
+// ContentHash returns the hash composer.lock records to detect changes to
+// the parts of composer.json that affect dependency resolution. It matches
+// Composer's Locker::getContentHash.
+func ContentHash(composerJSON []byte) (string, error) {
+	decoded, err := decodeOrdered(composerJSON)
+	if err != nil {
+		return "", err
+	}
+	pairs, ok := decoded.([]orderedPair)
+	if !ok {
+		return "", fmt.Errorf("composer.json must contain a JSON object")
+	}
+	relevantKeys := map[string]bool{
+		"name": true, "version": true, "require": true, "require-dev": true,
+		"conflict": true, "replace": true, "provide": true, "minimum-stability": true,
+		"prefer-stable": true, "repositories": true, "extra": true,
+	}
+	var relevant []orderedPair
+	for _, pair := range pairs {
+		if relevantKeys[pair.key] {
+			relevant = append(relevant, pair)
+		}
+		if pair.key == "config" {
+			if config, ok := pair.value.([]orderedPair); ok {
+				for _, setting := range config {
+					if setting.key == "platform" {
+						relevant = append(relevant, orderedPair{key: "config", value: []orderedPair{setting}})
+					}
+				}
+			}
+		}
+	}
+	sortPairs(relevant)
+	return md5Hex(phpJSONEncode(relevant)), nil
+}
+
+func isPlatformPackage(name string) bool {
+	name = strings.ToLower(name)
+	return name == "php" || strings.HasPrefix(name, "php-") || strings.HasPrefix(name, "ext-") ||
+		strings.HasPrefix(name, "lib-") || name == "composer" || name == "composer-plugin-api" ||
+		name == "composer-runtime-api" || name == "hhvm"
+}
+
+// packageDir returns where name is installed below vendorDir
+func packageDir(vendorDir string, name string) string {
+	return filepath.Join(vendorDir, filepath.FromSlash(name))
+}
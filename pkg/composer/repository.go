@@ -0,0 +1,433 @@
+package composer
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Repository supplies package versions. Implementations cover local paths,
+// git repositories and Composer (Packagist-style) registries; tests can use
+// a DirectoryRepository in place of Packagist.
+type Repository interface {
+	// Name describes the repository in messages
+	Name() string
+	// FindPackages returns every version of the named package the
+	// repository provides, or none if it does not know the package
+	FindPackages(name string) ([]*Package, error)
+}
+
+// decodePackage decodes a package's composer.json and sets its version
+func decodePackage(data []byte, version string) (*Package, error) {
+	pkg := &Package{}
+	if err := json.Unmarshal(data, pkg); err != nil {
+		return nil, err
+	}
+	if version == "" {
+		version = pkg.Version
+	}
+	if err := pkg.setVersion(version); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// PathRepository provides the packages found in local directories matched
+// by a glob, each at the version declared in its composer.json, or
+// dev-main. By default they are installed as symlinks.
+type PathRepository struct {
+	url      string
+	baseDir  string
+	symlink  *bool
+	versions map[string]string
+
+	once     sync.Once
+	packages map[string][]*Package
+	err      error
+}
+
+// NewPathRepository creates a path repository for url, which may be a
+// glob relative to baseDir. Options follow Composer's: "symlink" (bool)
+// and "versions" (package name to version).
+func NewPathRepository(url string, baseDir string, options map[string]interface{}) *PathRepository {
+	repo := &PathRepository{url: url, baseDir: baseDir, versions: make(map[string]string)}
+	if symlink, ok := options["symlink"].(bool); ok {
+		repo.symlink = &symlink
+	}
+	if versions, ok := options["versions"].(map[string]interface{}); ok {
+		for name, version := range versions {
+			if s, ok := version.(string); ok {
+				repo.versions[strings.ToLower(name)] = s
+			}
+		}
+	}
+	return repo
+}
+
+func (r *PathRepository) Name() string {
+	return "path repository " + r.url
+}
+
+func (r *PathRepository) FindPackages(name string) ([]*Package, error) {
+	r.once.Do(r.load)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.packages[strings.ToLower(name)], nil
+}
+
+func (r *PathRepository) load() {
+	r.packages = make(map[string][]*Package)
+	pattern := r.url
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(r.baseDir, pattern)
+	}
+	dirs, err := filepath.Glob(pattern)
+	if err != nil {
+		r.err = err
+		return
+	}
+	for _, dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(dir, "composer.json"))
+		if err != nil {
+			continue
+		}
+		var header struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(data, &header); err != nil || header.Name == "" {
+			continue
+		}
+		version := r.versions[strings.ToLower(header.Name)]
+		if version == "" {
+			if err := json.Unmarshal(data, &struct {
+				Version *string `json:"version"`
+			}{&version}); err != nil || version == "" {
+				version = "dev-main"
+			}
+		}
+		pkg, err := decodePackage(data, version)
+		if err != nil {
+			r.err = fmt.Errorf("%s: %w", filepath.Join(dir, "composer.json"), err)
+			return
+		}
+
+		url, relative := dir, false
+		if !filepath.IsAbs(r.url) {
+			if rel, err := filepath.Rel(r.baseDir, dir); err == nil {
+				url, relative = filepath.ToSlash(rel), true
+			}
+		}
+		pkg.Dist = &Dist{Type: "path", URL: url, Reference: sha1Hex(data)}
+		pkg.TransportOptions = &TransportOptions{Symlink: r.symlink, Relative: relative}
+		key := strings.ToLower(pkg.Name)
+		r.packages[key] = append(r.packages[key], pkg)
+	}
+}
+
+// DirectoryRepository is a local package registry laid out as
+// <dir>/<vendor>/<package>/<version>/, each version directory holding the
+// package's files and composer.json. Packages are installed by copying.
+type DirectoryRepository struct {
+	dir string
+}
+
+// NewDirectoryRepository creates a registry over dir.
+func NewDirectoryRepository(dir string) *DirectoryRepository {
+	return &DirectoryRepository{dir: dir}
+}
+
+func (r *DirectoryRepository) Name() string {
+	return "directory repository " + r.dir
+}
+
+func (r *DirectoryRepository) FindPackages(name string) ([]*Package, error) {
+	base := filepath.Join(r.dir, filepath.FromSlash(strings.ToLower(name)))
+	entries, err := os.ReadDir(base)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var packages []*Package
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(base, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, "composer.json"))
+		if err != nil {
+			continue
+		}
+		pkg, err := decodePackage(data, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+		pkg.Dist = &Dist{Type: "path", URL: dir, Reference: sha1Hex(data)}
+		symlink := false
+		pkg.TransportOptions = &TransportOptions{Symlink: &symlink}
+		packages = append(packages, pkg)
+	}
+	return packages, nil
+}
+
+// VCSRepository provides the tags and branches of a git repository as
+// package versions. The repository is mirrored into a cache directory.
+type VCSRepository struct {
+	url      string
+	cacheDir string
+
+	once     sync.Once
+	packages map[string][]*Package
+	err      error
+}
+
+// NewVCSRepository creates a git repository for url, mirrored below
+// cacheDir.
+func NewVCSRepository(url string, cacheDir string) *VCSRepository {
+	return &VCSRepository{url: url, cacheDir: cacheDir}
+}
+
+func (r *VCSRepository) Name() string {
+	return "git repository " + r.url
+}
+
+func (r *VCSRepository) FindPackages(name string) ([]*Package, error) {
+	r.once.Do(r.load)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.packages[strings.ToLower(name)], nil
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+// mirror clones or refreshes the bare mirror of the repository
+func (r *VCSRepository) mirror() (string, error) {
+	dir := filepath.Join(r.cacheDir, "vcs", sha1Hex([]byte(r.url)))
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); err == nil {
+		_, err := runGit(dir, "remote", "update", "--prune")
+		return dir, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	_, err := runGit("", "clone", "--mirror", "--quiet", r.url, dir)
+	return dir, err
+}
+
+func (r *VCSRepository) load() {
+	r.packages = make(map[string][]*Package)
+	dir, err := r.mirror()
+	if err != nil {
+		r.err = err
+		return
+	}
+	refs, err := runGit(dir, "for-each-ref", "--format=%(objectname) %(*objectname) %(refname)", "refs/tags", "refs/heads")
+	if err != nil {
+		r.err = err
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSpace(refs), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		commit, ref := fields[0], fields[len(fields)-1]
+		if len(fields) == 3 {
+			// Annotated tags point at the tag object; use its commit
+			commit = fields[1]
+		}
+
+		var version string
+		switch {
+		case strings.HasPrefix(ref, "refs/tags/"):
+			version = PrettyVersion(strings.TrimPrefix(ref, "refs/tags/"))
+			if v, err := ParseVersion(version); err != nil || v.IsBranch() {
+				continue
+			}
+		case strings.HasPrefix(ref, "refs/heads/"):
+			branch := strings.TrimPrefix(ref, "refs/heads/")
+			version = "dev-" + branch
+			if m := branchRegex.FindStringSubmatch(PrettyVersion(branch)); m != nil {
+				// Numbered branches like 1.x become 1.x-dev
+				version = PrettyVersion(branch)
+				if !strings.ContainsAny(version, "x*") {
+					version += ".x"
+				}
+				version += "-dev"
+			}
+		default:
+			continue
+		}
+
+		data, err := runGit(dir, "show", commit+":composer.json")
+		if err != nil {
+			continue
+		}
+		pkg, err := decodePackage([]byte(data), version)
+		if err != nil || pkg.Name == "" {
+			continue
+		}
+		pkg.Source = &Source{Type: "git", URL: r.url, Reference: commit}
+		if date, err := runGit(dir, "log", "-1", "--format=%cI", commit); err == nil {
+			if t, err := time.Parse(time.RFC3339, strings.TrimSpace(date)); err == nil {
+				pkg.Time = t.UTC().Format("2006-01-02T15:04:05-07:00")
+			}
+		}
+		key := strings.ToLower(pkg.Name)
+		r.packages[key] = append(r.packages[key], pkg)
+	}
+}
+
+// ComposerRepository reads package metadata from a Composer v2 registry
+// such as Packagist, using the /p2/<vendor>/<package>.json endpoint.
+type ComposerRepository struct {
+	url    string
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string][]*Package
+}
+
+// PackagistURL is the default Composer registry.
+const PackagistURL = "https://repo.packagist.org"
+
+// NewComposerRepository creates a registry client for url.
+func NewComposerRepository(url string, client *http.Client) *ComposerRepository {
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	return &ComposerRepository{url: strings.TrimRight(url, "/"), client: client, cache: make(map[string][]*Package)}
+}
+
+func (r *ComposerRepository) Name() string {
+	return "composer repository " + r.url
+}
+
+func (r *ComposerRepository) FindPackages(name string) ([]*Package, error) {
+	name = strings.ToLower(name)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if packages, ok := r.cache[name]; ok {
+		return packages, nil
+	}
+
+	resp, err := r.client.Get(r.url + "/p2/" + name + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s metadata from %s: %w", name, r.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		r.cache[name] = nil
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s metadata from %s: %s", name, r.url, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata struct {
+		Packages map[string][]map[string]json.RawMessage `json:"packages"`
+		Minified string                                  `json:"minified"`
+	}
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata for %s from %s: %w", name, r.url, err)
+	}
+	versions := metadata.Packages[name]
+	if metadata.Minified == "composer/2.0" {
+		versions = expandMinified(versions)
+	}
+
+	var packages []*Package
+	for _, fields := range versions {
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		var header struct {
+			Version string `json:"version"`
+		}
+		json.Unmarshal(data, &header)
+		pkg, err := decodePackage(data, header.Version)
+		if err != nil {
+			// Skip versions Composer could not parse either
+			continue
+		}
+		if pkg.Name == "" {
+			pkg.Name = name
+		}
+		packages = append(packages, pkg)
+	}
+	r.cache[name] = packages
+	return packages, nil
+}
+
+// expandMinified undoes the composer/2.0 minification, in which each
+// version lists only the fields that differ from the previous one and
+// "__unset" removes a field
+func expandMinified(versions []map[string]json.RawMessage) []map[string]json.RawMessage {
+	var expanded []map[string]json.RawMessage
+	current := map[string]json.RawMessage{}
+	for _, version := range versions {
+		next := make(map[string]json.RawMessage, len(current))
+		for key, value := range current {
+			next[key] = value
+		}
+		for key, value := range version {
+			if string(value) == `"__unset"` {
+				delete(next, key)
+			} else {
+				next[key] = value
+			}
+		}
+		expanded = append(expanded, next)
+		current = next
+	}
+	return expanded
+}
+
+// sortCandidates orders versions from most to least preferred: highest
+// first, stable releases ahead of unstable ones when preferStable is set
+func sortCandidates(packages []*Package, preferStable bool) {
+	sort.SliceStable(packages, func(i, j int) bool {
+		vi, vj := packages[i].version, packages[j].version
+		if preferStable {
+			si, sj := vi.Stability() == StabilityStable, vj.Stability() == StabilityStable
+			if si != sj {
+				return si
+			}
+		}
+		return vi.Compare(vj) > 0
+	})
+}
@@ -0,0 +1,595 @@
+package composer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Stability ranks how finished a release is. Lower values are more stable,
+// matching Composer's BasePackage::STABILITIES.
+type Stability int
+
+const (
+	StabilityStable Stability = 0
+	StabilityRC     Stability = 5
+	StabilityBeta   Stability = 10
+	StabilityAlpha  Stability = 15
+	StabilityDev    Stability = 20
+)
+
+var stabilityNames = map[Stability]string{
+	StabilityStable: "stable",
+	StabilityRC:     "RC",
+	StabilityBeta:   "beta",
+	StabilityAlpha:  "alpha",
+	StabilityDev:    "dev",
+}
+
+func (s Stability) String() string {
+	return stabilityNames[s]
+}
+
+// ParseStability parses a minimum-stability value or @flag.
+func ParseStability(s string) (Stability, bool) {
+	switch strings.ToLower(s) {
+	case "stable":
+		return StabilityStable, true
+	case "rc":
+		return StabilityRC, true
+	case "beta":
+		return StabilityBeta, true
+	case "alpha":
+		return StabilityAlpha, true
+	case "dev":
+		return StabilityDev, true
+	}
+	return 0, false
+}
+
+// Version is a normalized package version such as 1.2.0.0, 2.0.0.0-beta1 or
+// a dev branch like dev-main.
+type Version struct {
+	parts     [4]int64
+	stability Stability
+	// pre orders pre-releases of the same stability, as in beta1 < beta2;
+	// for patch releases it is the patch number
+	pre   int64
+	patch bool
+	// branch is set for non-numeric dev branches, which only match
+	// constraints naming the same branch
+	branch string
+}
+
+const branchAliasPart = 9999999
+
+var (
+	versionRegex  = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:\.(\d+))?(?:[.\-_+]?(stable|beta|b|rc|alpha|a|patch|pl|p)((?:[.\-]?\d+)*))?(?:[.\-_+]?(dev))?$`)
+	branchRegex   = regexp.MustCompile(`^v?(\d+)(?:\.(\d+|[x*]))?(?:\.(\d+|[x*]))?(?:\.(\d+|[x*]))?$`)
+	buildMetadata = regexp.MustCompile(`\+[0-9A-Za-z.\-]*$`)
+)
+
+// ParseVersion parses and normalizes a version string as Composer's
+// VersionParser::normalize does.
+func ParseVersion(s string) (Version, error) {
+	orig := s
+	s = strings.TrimSpace(s)
+	// Inline aliases such as "dev-main as 1.0.x-dev" resolve to the real version
+	if idx := strings.Index(s, " as "); idx >= 0 {
+		s = strings.TrimSpace(s[:idx])
+	}
+	// A "#ref" suffix pins a commit and is not part of the version
+	if idx := strings.Index(s, "#"); idx >= 0 {
+		s = s[:idx]
+	}
+	lower := strings.ToLower(s)
+
+	if strings.HasPrefix(lower, "dev-") {
+		return Version{stability: StabilityDev, branch: s[4:]}, nil
+	}
+	if lower == "master" || lower == "trunk" || lower == "default" {
+		return Version{stability: StabilityDev, branch: s}, nil
+	}
+
+	// Numeric branches: 1.x-dev, 1.0.*-dev and the like
+	if strings.HasSuffix(lower, "-dev") || strings.HasSuffix(lower, ".x-dev") {
+		if m := branchRegex.FindStringSubmatch(strings.TrimSuffix(lower, "-dev")); m != nil && strings.ContainsAny(lower, "x*") {
+			v := Version{stability: StabilityDev}
+			wild := false
+			for i := 0; i < 4; i++ {
+				part := m[i+1]
+				if wild || part == "" || part == "x" || part == "*" {
+					wild = true
+					v.parts[i] = branchAliasPart
+					continue
+				}
+				v.parts[i], _ = strconv.ParseInt(part, 10, 64)
+			}
+			return v, nil
+		}
+	}
+
+	lower = buildMetadata.ReplaceAllString(lower, "")
+	m := versionRegex.FindStringSubmatch(lower)
+	if m == nil {
+		return Version{}, fmt.Errorf("invalid version string %q", orig)
+	}
+	var v Version
+	for i := 0; i < 4; i++ {
+		if m[i+1] != "" {
+			v.parts[i], _ = strconv.ParseInt(m[i+1], 10, 64)
+		}
+	}
+	switch m[5] {
+	case "beta", "b":
+		v.stability = StabilityBeta
+	case "rc":
+		v.stability = StabilityRC
+	case "alpha", "a":
+		v.stability = StabilityAlpha
+	case "patch", "pl", "p":
+		v.patch = true
+	}
+	if digits := strings.Trim(m[6], ".-"); digits != "" {
+		digits = strings.NewReplacer(".", "", "-", "").Replace(digits)
+		v.pre, _ = strconv.ParseInt(digits, 10, 64)
+	}
+	if m[7] == "dev" {
+		v.stability = StabilityDev
+		v.patch = false
+	}
+	return v, nil
+}
+
+// IsBranch reports whether v is a named dev branch such as dev-main.
+func (v Version) IsBranch() bool {
+	return v.branch != ""
+}
+
+// Stability returns the release stability of v.
+func (v Version) Stability() Stability {
+	return v.stability
+}
+
+// Parts returns the four numeric components of v.
+func (v Version) Parts() [4]int64 {
+	return v.parts
+}
+
+// String returns the normalized form, e.g. 1.2.0.0 or 2.0.0.0-RC1.
+func (v Version) String() string {
+	if v.branch != "" {
+		return "dev-" + v.branch
+	}
+	s := fmt.Sprintf("%d.%d.%d.%d", v.parts[0], v.parts[1], v.parts[2], v.parts[3])
+	switch {
+	case v.patch:
+		s += fmt.Sprintf("-patch%d", v.pre)
+	case v.stability == StabilityDev:
+		s += "-dev"
+	case v.stability != StabilityStable:
+		s += "-" + v.stability.String()
+		if v.pre != 0 {
+			s += strconv.FormatInt(v.pre, 10)
+		}
+	}
+	return s
+}
+
+// rank orders releases of the same numeric version: dev < alpha < beta <
+// RC < stable < patch
+func (v Version) rank() int {
+	if v.patch {
+		return 1
+	}
+	return -int(v.stability)
+}
+
+// Compare returns -1, 0 or 1 as v is lower than, equal to or higher than o.
+// Named branches sort below every numbered version.
+func (v Version) Compare(o Version) int {
+	if v.branch != "" || o.branch != "" {
+		switch {
+		case v.branch == o.branch:
+			return 0
+		case v.branch == "":
+			return 1
+		case o.branch == "":
+			return -1
+		}
+		return strings.Compare(v.branch, o.branch)
+	}
+	for i := range v.parts {
+		if v.parts[i] != o.parts[i] {
+			return cmpInt(v.parts[i], o.parts[i])
+		}
+	}
+	if r1, r2 := v.rank(), o.rank(); r1 != r2 {
+		return cmpInt(int64(r1), int64(r2))
+	}
+	return cmpInt(v.pre, o.pre)
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Constraint is a parsed version constraint such as "^1.2 || ~2.0".
+type Constraint interface {
+	Matches(v Version) bool
+	String() string
+}
+
+type operator string
+
+const (
+	opEQ operator = "=="
+	opNE operator = "!="
+	opLT operator = "<"
+	opLE operator = "<="
+	opGT operator = ">"
+	opGE operator = ">="
+)
+
+// simpleConstraint compares against a single version
+type simpleConstraint struct {
+	op      operator
+	version Version
+}
+
+func (c *simpleConstraint) Matches(v Version) bool {
+	if c.version.IsBranch() || v.IsBranch() {
+		// Branches only ever equal themselves
+		same := c.version.IsBranch() && v.IsBranch() && c.version.branch == v.branch
+		switch c.op {
+		case opEQ:
+			return same
+		case opNE:
+			return !same
+		}
+		return false
+	}
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case opEQ:
+		return cmp == 0
+	case opNE:
+		return cmp != 0
+	case opLT:
+		return cmp < 0
+	case opLE:
+		return cmp <= 0
+	case opGT:
+		return cmp > 0
+	case opGE:
+		return cmp >= 0
+	}
+	return false
+}
+
+func (c *simpleConstraint) String() string {
+	return string(c.op) + " " + c.version.String()
+}
+
+// multiConstraint combines constraints with AND or OR
+type multiConstraint struct {
+	constraints []Constraint
+	conjunctive bool
+}
+
+func (c *multiConstraint) Matches(v Version) bool {
+	for _, sub := range c.constraints {
+		if sub.Matches(v) != c.conjunctive {
+			return !c.conjunctive
+		}
+	}
+	return c.conjunctive
+}
+
+func (c *multiConstraint) String() string {
+	parts := make([]string, len(c.constraints))
+	for i, sub := range c.constraints {
+		parts[i] = sub.String()
+	}
+	if c.conjunctive {
+		return "[" + strings.Join(parts, " ") + "]"
+	}
+	return "[" + strings.Join(parts, " || ") + "]"
+}
+
+// anyConstraint matches every numbered version
+type anyConstraint struct{}
+
+func (anyConstraint) Matches(v Version) bool { return !v.IsBranch() }
+func (anyConstraint) String() string         { return "*" }
+
+var (
+	orSplitRegex      = regexp.MustCompile(`\s*\|\|?\s*`)
+	operatorSpace     = regexp.MustCompile(`(^|[\s,])(<>|!=|>=?|<=?|==?)\s+`)
+	andSplitRegex     = regexp.MustCompile(`\s*,\s*|\s+`)
+	hyphenRangeRegex  = regexp.MustCompile(`^(\S+)\s+-\s+(\S+)$`)
+	stabilityFlag     = regexp.MustCompile(`(?i)@(stable|rc|beta|alpha|dev)$`)
+	wildcardRegex     = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?\.[*x]$`)
+	partialRegex      = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:\.(\d+))?`)
+	versionModifier   = regexp.MustCompile(`(?i)[.\-_]?(stable|beta|b|rc|alpha|a|patch|pl|p|dev)\d*$`)
+	constraintOpRegex = regexp.MustCompile(`^(<>|!=|>=?|<=?|==?)?\s*(.+)$`)
+)
+
+// ParseConstraint parses a Composer version constraint.
+func ParseConstraint(s string) (Constraint, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("empty version constraint")
+	}
+	var ors []Constraint
+	for _, orPart := range orSplitRegex.Split(s, -1) {
+		orPart = strings.TrimSpace(orPart)
+		if orPart == "" {
+			return nil, fmt.Errorf("invalid version constraint %q", s)
+		}
+		c, err := parseAndConstraint(orPart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %w", s, err)
+		}
+		ors = append(ors, c)
+	}
+	if len(ors) == 1 {
+		return ors[0], nil
+	}
+	return &multiConstraint{constraints: ors}, nil
+}
+
+func parseAndConstraint(s string) (Constraint, error) {
+	if m := hyphenRangeRegex.FindStringSubmatch(s); m != nil {
+		return parseHyphenRange(m[1], m[2])
+	}
+	s = operatorSpace.ReplaceAllString(s, "$1$2")
+	var ands []Constraint
+	for _, part := range andSplitRegex.Split(s, -1) {
+		if part == "" {
+			continue
+		}
+		c, err := parseSingleConstraint(part)
+		if err != nil {
+			return nil, err
+		}
+		ands = append(ands, c...)
+	}
+	if len(ands) == 0 {
+		return nil, fmt.Errorf("empty constraint")
+	}
+	if len(ands) == 1 {
+		return ands[0], nil
+	}
+	return &multiConstraint{constraints: ands, conjunctive: true}, nil
+}
+
+// devBound returns the lowest version of the numeric prefix, including its
+// pre-releases, e.g. 1.2.0.0-dev
+func devBound(parts [4]int64) Version {
+	return Version{parts: parts, stability: StabilityDev}
+}
+
+// bump returns the first version above every release starting with the
+// first position+1 components of parts
+func bump(parts [4]int64, position int) [4]int64 {
+	var out [4]int64
+	copy(out[:], parts[:position+1])
+	out[position]++
+	return out
+}
+
+func parsePartial(s string) ([4]int64, int, bool) {
+	m := partialRegex.FindStringSubmatch(s)
+	if m == nil {
+		return [4]int64{}, 0, false
+	}
+	var parts [4]int64
+	n := 0
+	for i := 0; i < 4; i++ {
+		if m[i+1] == "" {
+			break
+		}
+		parts[i], _ = strconv.ParseInt(m[i+1], 10, 64)
+		n++
+	}
+	return parts, n, true
+}
+
+func rangeConstraint(lower Version, upper Version) Constraint {
+	return &multiConstraint{
+		constraints: []Constraint{
+			&simpleConstraint{op: opGE, version: lower},
+			&simpleConstraint{op: opLT, version: upper},
+		},
+		conjunctive: true,
+	}
+}
+
+func parseSingleConstraint(s string) ([]Constraint, error) {
+	s = stabilityFlag.ReplaceAllString(s, "")
+	if s == "" {
+		// A bare "@dev" means any version of that stability
+		return []Constraint{anyConstraint{}}, nil
+	}
+	if s == "*" || s == "*.*" || s == "x" || s == "X" || s == "*.*.*" {
+		return []Constraint{anyConstraint{}}, nil
+	}
+	if strings.HasPrefix(strings.ToLower(s), "dev-") {
+		v, err := ParseVersion(s)
+		if err != nil {
+			return nil, err
+		}
+		return []Constraint{&simpleConstraint{op: opEQ, version: v}}, nil
+	}
+
+	switch s[0] {
+	case '~', '^':
+		body := s[1:]
+		if body != "" && body[0] == '>' {
+			// "~>" is accepted as an alias of "~"
+			body = body[1:]
+		}
+		parts, n, ok := parsePartial(body)
+		if !ok {
+			return nil, fmt.Errorf("could not parse %q", s)
+		}
+		lower := devBound(parts)
+		if rest := body[len(partialRegex.FindString(body)):]; rest != "" {
+			v, err := ParseVersion(body)
+			if err != nil {
+				return nil, err
+			}
+			lower = v
+		}
+		var position int
+		if s[0] == '~' {
+			position = n - 2
+			if position < 0 {
+				position = 0
+			}
+		} else {
+			switch {
+			case parts[0] != 0 || n == 1:
+				position = 0
+			case parts[1] != 0 || n == 2:
+				position = 1
+			default:
+				position = 2
+			}
+		}
+		return []Constraint{rangeConstraint(lower, devBound(bump(parts, position)))}, nil
+	}
+
+	if m := wildcardRegex.FindStringSubmatch(s); m != nil {
+		parts, n, _ := parsePartial(s)
+		return []Constraint{rangeConstraint(devBound(parts), devBound(bump(parts, n-1)))}, nil
+	}
+
+	m := constraintOpRegex.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("could not parse %q", s)
+	}
+	op := operator(m[1])
+	switch op {
+	case "", "=":
+		op = opEQ
+	case "<>":
+		op = opNE
+	}
+	v, err := ParseVersion(m[2])
+	if err != nil {
+		return nil, err
+	}
+	// As in Composer, ">=1.0" includes 1.0's pre-releases and "<2.0"
+	// excludes 2.0's, unless a stability is spelled out
+	if (op == opGE || op == opLT) && !v.IsBranch() && !versionModifier.MatchString(m[2]) {
+		v.stability = StabilityDev
+	}
+	return []Constraint{&simpleConstraint{op: op, version: v}}, nil
+}
+
+func parseHyphenRange(from, to string) (Constraint, error) {
+	lower, err := ParseVersion(from)
+	if err != nil {
+		return nil, err
+	}
+	if !versionModifier.MatchString(from) {
+		lower.stability = StabilityDev
+	}
+	parts, n, ok := parsePartial(to)
+	if !ok {
+		return nil, fmt.Errorf("could not parse %q", to)
+	}
+	if n < 3 && !versionModifier.MatchString(to) {
+		// A partial upper bound includes every release it prefixes
+		return rangeConstraint(lower, devBound(bump(parts, n-1))), nil
+	}
+	upper, err := ParseVersion(to)
+	if err != nil {
+		return nil, err
+	}
+	return &multiConstraint{
+		constraints: []Constraint{
+			&simpleConstraint{op: opGE, version: lower},
+			&simpleConstraint{op: opLE, version: upper},
+		},
+		conjunctive: true,
+	}, nil
+}
+
+// constraintStability returns the stability a root requirement explicitly
+// allows, from an @flag or an unstable version it names, and whether it
+// names one at all.
+func constraintStability(s string) (Stability, bool) {
+	found := false
+	lowest := StabilityStable
+	for _, orPart := range orSplitRegex.Split(strings.TrimSpace(s), -1) {
+		var stab Stability
+		ok := false
+		if m := stabilityFlag.FindStringSubmatch(orPart); m != nil {
+			stab, ok = ParseStability(m[1])
+		} else if strings.HasPrefix(strings.ToLower(orPart), "dev-") || strings.HasSuffix(strings.ToLower(orPart), "-dev") {
+			stab, ok = StabilityDev, true
+		} else if m := versionModifier.FindStringSubmatch(orPart); m != nil {
+			switch strings.ToLower(m[1]) {
+			case "beta", "b":
+				stab, ok = StabilityBeta, true
+			case "rc":
+				stab, ok = StabilityRC, true
+			case "alpha", "a":
+				stab, ok = StabilityAlpha, true
+			case "dev":
+				stab, ok = StabilityDev, true
+			}
+		}
+		if ok {
+			found = true
+			if stab > lowest {
+				lowest = stab
+			}
+		}
+	}
+	return lowest, found
+}
+
+// PrettyVersion turns a tag or branch name into the version shown to users,
+// stripping a leading "v" from tags
+func PrettyVersion(s string) string {
+	if len(s) > 1 && (s[0] == 'v' || s[0] == 'V') && s[1] >= '0' && s[1] <= '9' {
+		return s[1:]
+	}
+	return s
+}
+
+// recommendedConstraint returns the constraint `require` adds for a
+// package when none is given, as Composer's VersionSelector does: ^1.2 for
+// 1.2.3, ^0.3 for 0.3.1 and ^1.0@beta for 1.0.0-beta2.
+func recommendedConstraint(v Version) string {
+	if v.IsBranch() {
+		return "dev-" + v.branch
+	}
+	if v.stability == StabilityDev && v.parts[1] == branchAliasPart {
+		return fmt.Sprintf("%d.x-dev", v.parts[0])
+	}
+	var constraint string
+	if v.parts[0] == 0 {
+		constraint = fmt.Sprintf("^0.%d", v.parts[1])
+		if v.parts[1] == 0 {
+			constraint = fmt.Sprintf("^0.0.%d", v.parts[2])
+		}
+	} else {
+		constraint = fmt.Sprintf("^%d.%d", v.parts[0], v.parts[1])
+	}
+	if v.stability != StabilityStable {
+		constraint += "@" + v.stability.String()
+	}
+	return constraint
+}
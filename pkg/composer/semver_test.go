@@ -0,0 +1,121 @@
+package composer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1.0", "1.0.0.0"},
+		{"v1.2.3", "1.2.3.0"},
+		{"1.2.3.4", "1.2.3.4"},
+		{"1.0.0-beta2", "1.0.0.0-beta2"},
+		{"1.0.0-b.2", "1.0.0.0-beta2"},
+		{"2.0-RC1", "2.0.0.0-RC1"},
+		{"1.0.0-alpha", "1.0.0.0-alpha"},
+		{"1.0.0-dev", "1.0.0.0-dev"},
+		{"1.0.0-pl3", "1.0.0.0-patch3"},
+		{"1.0.0+build.5", "1.0.0.0"},
+		{"1.x-dev", "1.9999999.9999999.9999999-dev"},
+		{"2.1.x-dev", "2.1.9999999.9999999-dev"},
+		{"dev-main", "dev-main"},
+		{"dev-feature/login", "dev-feature/login"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			v, err := ParseVersion(tt.input)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, v.String())
+		})
+	}
+
+	for _, bad := range []string{"", "abc", "1.0.0-foo", "1..2"} {
+		_, err := ParseVersion(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	ordered := []string{"dev-main", "1.0.0-dev", "1.0.0-alpha1", "1.0.0-beta1", "1.0.0-beta2", "1.0.0-RC1", "1.0.0", "1.0.0-p1", "1.0.1", "1.10.0", "2.0"}
+	for i := 0; i+1 < len(ordered); i++ {
+		a, err := ParseVersion(ordered[i])
+		require.NoError(t, err)
+		b, err := ParseVersion(ordered[i+1])
+		require.NoError(t, err)
+		require.Equal(t, -1, a.Compare(b), "%s < %s", ordered[i], ordered[i+1])
+		require.Equal(t, 1, b.Compare(a), "%s > %s", ordered[i+1], ordered[i])
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+	}{
+		{"*", []string{"0.1", "1.0", "9.9.9"}, []string{"dev-main"}},
+		{"1.2.3", []string{"1.2.3", "v1.2.3.0"}, []string{"1.2.4", "1.2.3-beta1"}},
+		{"^1.2", []string{"1.2.0", "1.9.9", "1.2.0-beta1"}, []string{"1.1.9", "2.0.0", "2.0.0-beta1"}},
+		{"^0.3", []string{"0.3.0", "0.3.9"}, []string{"0.4.0", "0.2.9"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"~1.2", []string{"1.2.0", "1.9.0"}, []string{"2.0.0", "1.1.0"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0", "1.2.2"}},
+		{"~1", []string{"1.0.0", "1.5.0"}, []string{"2.0.0"}},
+		{"1.2.*", []string{"1.2.0", "1.2.99"}, []string{"1.3.0", "1.1.0"}},
+		{"1.*", []string{"1.0", "1.99"}, []string{"2.0"}},
+		{">=1.0 <2.0", []string{"1.0", "1.5"}, []string{"0.9", "2.0"}},
+		{">=1.0, <2.0", []string{"1.5"}, []string{"2.0"}},
+		{">= 1.0 < 2.0", []string{"1.5"}, []string{"2.0"}},
+		{">1.0 <=2.0", []string{"1.0.1", "2.0"}, []string{"1.0", "2.0.1"}},
+		{"!=1.5", []string{"1.4"}, []string{"1.5"}},
+		{"^1.0 || ^3.0", []string{"1.1", "3.2"}, []string{"2.0"}},
+		{"^1.0 | ^3.0", []string{"3.2"}, []string{"2.0"}},
+		{"1.0 - 2.0", []string{"1.0", "2.0.5"}, []string{"2.1", "0.9"}},
+		{"1.0.0 - 2.1.0", []string{"2.1.0"}, []string{"2.1.1"}},
+		{"dev-main", []string{"dev-main"}, []string{"dev-other", "1.0"}},
+		{"^1.0@beta", []string{"1.0.0-beta1", "1.4"}, []string{"2.0"}},
+		{"@dev", []string{"1.0"}, nil},
+		{"~>1.2", []string{"1.3"}, []string{"2.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			c, err := ParseConstraint(tt.constraint)
+			require.NoError(t, err)
+			for _, s := range tt.matches {
+				v, err := ParseVersion(s)
+				require.NoError(t, err)
+				require.True(t, c.Matches(v), "%s should match %s (%s)", tt.constraint, s, c)
+			}
+			for _, s := range tt.rejects {
+				v, err := ParseVersion(s)
+				require.NoError(t, err)
+				require.False(t, c.Matches(v), "%s should not match %s (%s)", tt.constraint, s, c)
+			}
+		})
+	}
+
+	for _, bad := range []string{"", "^", "foo", ">=", "1.0 ||"} {
+		_, err := ParseConstraint(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestRecommendedConstraint(t *testing.T) {
+	tests := map[string]string{
+		"1.2.3":       "^1.2",
+		"0.3.1":       "^0.3",
+		"0.0.4":       "^0.0.4",
+		"1.0.0-beta2": "^1.0@beta",
+		"dev-main":    "dev-main",
+	}
+	for input, expected := range tests {
+		v, err := ParseVersion(input)
+		require.NoError(t, err)
+		require.Equal(t, expected, recommendedConstraint(v), input)
+	}
+}
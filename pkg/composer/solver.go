@@ -0,0 +1,311 @@
+package composer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PHPVersion is the PHP version the interpreter reports, used for "php"
+// requirements unless config.platform overrides it.
+const PHPVersion = "8.0.30"
+
+// maxSolverSteps bounds the backtracking search
+const maxSolverSteps = 200000
+
+// UnresolvableError is returned when no set of package versions satisfies
+// the requirements.
+type UnresolvableError struct {
+	// Problem describes the conflict found deepest in the search
+	Problem string
+}
+
+func (e *UnresolvableError) Error() string {
+	return "Your requirements could not be resolved to an installable set of packages.\n\n  Problem 1\n    - " + e.Problem
+}
+
+// requirement is a link from a package, or the root, to another package
+type requirement struct {
+	name       string
+	constraint Constraint
+	pretty     string
+	// source is the requiring package, nil for the root package
+	source *Package
+}
+
+func (r requirement) describe() string {
+	if r.source == nil {
+		return fmt.Sprintf("Root composer.json requires %s %s", r.name, r.pretty)
+	}
+	return fmt.Sprintf("%s %s requires %s %s", r.source.Name, r.source.Version, r.name, r.pretty)
+}
+
+// solver picks one version of every package reachable from the root
+// requirements with a depth-first search that tries the most preferred
+// version first and backtracks on conflicts.
+type solver struct {
+	repos        []Repository
+	minStability Stability
+	flags        map[string]Stability
+	preferStable bool
+	platform     map[string]string
+	// preferred holds locked packages to keep when they still satisfy the
+	// requirements, for partial updates
+	preferred map[string]*Package
+
+	candidates map[string][]*Package
+	selected   map[string]*Package
+	steps      int
+
+	// problem is the failure found deepest in the search, which is the
+	// most useful one to report
+	problem      string
+	problemDepth int
+}
+
+func newSolver(repos []Repository, manifest *Manifest) *solver {
+	s := &solver{
+		repos:        repos,
+		minStability: manifest.minimumStability(),
+		flags:        manifest.stabilityFlags(),
+		preferStable: manifest.PreferStable,
+		platform:     map[string]string{"php": PHPVersion},
+		preferred:    make(map[string]*Package),
+		candidates:   make(map[string][]*Package),
+		selected:     make(map[string]*Package),
+		problemDepth: -1,
+	}
+	if manifest.Config != nil {
+		for name, version := range manifest.Config.Platform {
+			s.platform[strings.ToLower(name)] = version
+		}
+	}
+	return s
+}
+
+// requirements converts a require map into sorted requirement links
+func requirements(links map[string]string, source *Package) ([]requirement, error) {
+	var reqs []requirement
+	for _, name := range sortedStrings(links) {
+		constraint, err := ParseConstraint(links[name])
+		if err != nil {
+			owner := "composer.json"
+			if source != nil {
+				owner = source.Name
+			}
+			return nil, fmt.Errorf("%s: invalid constraint %q for %s: %w", owner, links[name], name, err)
+		}
+		reqs = append(reqs, requirement{name: strings.ToLower(name), constraint: constraint, pretty: links[name], source: source})
+	}
+	return reqs, nil
+}
+
+// solve resolves reqs, returning the selected packages sorted by name
+func (s *solver) solve(reqs []requirement) ([]*Package, error) {
+	if !s.search(reqs) {
+		if s.steps > maxSolverSteps {
+			return nil, &UnresolvableError{Problem: fmt.Sprintf("dependency resolution gave up after %d steps", maxSolverSteps)}
+		}
+		return nil, &UnresolvableError{Problem: s.problem}
+	}
+	packages := make([]*Package, 0, len(s.selected))
+	for _, pkg := range s.selected {
+		packages = append(packages, pkg)
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+	return packages, nil
+}
+
+func (s *solver) fail(msg string) bool {
+	if depth := len(s.selected); depth > s.problemDepth {
+		s.problem = msg
+		s.problemDepth = depth
+	}
+	return false
+}
+
+func (s *solver) search(pending []requirement) bool {
+	s.steps++
+	if s.steps > maxSolverSteps {
+		return false
+	}
+	if len(pending) == 0 {
+		return true
+	}
+	req, rest := pending[0], pending[1:]
+
+	if isPlatformPackage(req.name) {
+		if !s.platformSatisfies(req) {
+			return false
+		}
+		return s.search(rest)
+	}
+
+	if pkg, ok := s.selected[req.name]; ok {
+		if !req.constraint.Matches(pkg.version) {
+			return s.fail(fmt.Sprintf("%s -> found %s but it conflicts with another require.", req.describe(), pkg.PrettyName()))
+		}
+		return s.search(rest)
+	}
+	if s.providedBySelected(req) {
+		return s.search(rest)
+	}
+
+	all, err := s.findCandidates(req.name)
+	if err != nil {
+		return s.fail(err.Error())
+	}
+	if len(all) == 0 {
+		return s.fail(fmt.Sprintf("%s, it could not be found in any version, there may be a typo in the package name.", req.describe()))
+	}
+
+	var matching []*Package
+	unstable := false
+	for _, pkg := range all {
+		if !req.constraint.Matches(pkg.version) {
+			continue
+		}
+		if !s.stabilityAllowed(req.name, pkg.version) {
+			unstable = true
+			continue
+		}
+		matching = append(matching, pkg)
+	}
+	if len(matching) == 0 {
+		if unstable {
+			return s.fail(fmt.Sprintf("%s -> found %s but it does not match your minimum-stability.", req.describe(), versionList(req.name, all)))
+		}
+		return s.fail(fmt.Sprintf("%s -> found %s but it does not match the constraint.", req.describe(), versionList(req.name, all)))
+	}
+
+	for _, pkg := range matching {
+		if msg := s.conflicts(pkg); msg != "" {
+			s.fail(msg)
+			continue
+		}
+		deps, err := requirements(pkg.Require, pkg)
+		if err != nil {
+			s.fail(err.Error())
+			continue
+		}
+		s.selected[req.name] = pkg
+		next := make([]requirement, 0, len(rest)+len(deps))
+		next = append(next, rest...)
+		next = append(next, deps...)
+		if s.search(next) {
+			return true
+		}
+		delete(s.selected, req.name)
+		if s.steps > maxSolverSteps {
+			return false
+		}
+	}
+	return false
+}
+
+// findCandidates returns every known version of name in preference order
+func (s *solver) findCandidates(name string) ([]*Package, error) {
+	if packages, ok := s.candidates[name]; ok {
+		return packages, nil
+	}
+	var packages []*Package
+	for _, repo := range s.repos {
+		found, err := repo.FindPackages(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) > 0 {
+			// Like Composer, the first repository providing a package wins
+			packages = append([]*Package(nil), found...)
+			break
+		}
+	}
+	sortCandidates(packages, s.preferStable)
+	if locked, ok := s.preferred[name]; ok {
+		for i, pkg := range packages {
+			if pkg.Version == locked.Version {
+				packages = append([]*Package{pkg}, append(packages[:i:i], packages[i+1:]...)...)
+				break
+			}
+		}
+	}
+	s.candidates[name] = packages
+	return packages, nil
+}
+
+func (s *solver) stabilityAllowed(name string, v Version) bool {
+	allowed := s.minStability
+	if flag, ok := s.flags[name]; ok && flag > allowed {
+		allowed = flag
+	}
+	return v.Stability() <= allowed
+}
+
+// providedBySelected reports whether a selected package replaces or
+// provides the required package
+func (s *solver) providedBySelected(req requirement) bool {
+	for _, pkg := range s.selected {
+		for _, links := range []map[string]string{pkg.Replace, pkg.Provide} {
+			for name, constraint := range links {
+				if strings.ToLower(name) != req.name {
+					continue
+				}
+				if constraint == "self.version" {
+					constraint = pkg.Version
+				}
+				if v, err := ParseVersion(PrettyVersion(strings.TrimLeft(constraint, "=^~ "))); err == nil && req.constraint.Matches(v) {
+					return true
+				}
+				if constraint == "*" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// conflicts checks the conflict rules between pkg and the selected
+// packages, returning a description of the first violation
+func (s *solver) conflicts(pkg *Package) string {
+	for name, pretty := range pkg.Conflict {
+		if other, ok := s.selected[strings.ToLower(name)]; ok {
+			if c, err := ParseConstraint(pretty); err == nil && c.Matches(other.version) {
+				return fmt.Sprintf("%s conflicts with %s.", pkg.PrettyName(), other.PrettyName())
+			}
+		}
+	}
+	for _, other := range s.selected {
+		if pretty, ok := other.Conflict[pkg.Name]; ok {
+			if c, err := ParseConstraint(pretty); err == nil && c.Matches(pkg.version) {
+				return fmt.Sprintf("%s conflicts with %s.", other.PrettyName(), pkg.PrettyName())
+			}
+		}
+	}
+	return ""
+}
+
+func (s *solver) platformSatisfies(req requirement) bool {
+	pretty, ok := s.platform[req.name]
+	if !ok {
+		// Extensions and libraries are not checked
+		return true
+	}
+	v, err := ParseVersion(pretty)
+	if err != nil || req.constraint.Matches(v) {
+		return true
+	}
+	return s.fail(fmt.Sprintf("%s but your %s version (%s) does not satisfy that requirement.", req.describe(), req.name, pretty))
+}
+
+// versionList formats versions as Composer does in problem reports:
+// vendor/name[1.0.0, 1.1.0]
+func versionList(name string, packages []*Package) string {
+	sorted := append([]*Package(nil), packages...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].version.Compare(sorted[j].version) < 0 })
+	versions := make([]string, len(sorted))
+	for i, pkg := range sorted {
+		versions[i] = pkg.Version
+	}
+	return fmt.Sprintf("%s[%s]", name, strings.Join(versions, ", "))
+}
@@ -0,0 +1,319 @@
+package composer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// packageNameRegex is the name pattern of Composer's JSON schema
+var packageNameRegex = regexp.MustCompile(`^[a-z0-9]([_.-]?[a-z0-9]+)*/[a-z0-9](([_.]|-{1,2})?[a-z0-9]+)*$`)
+
+// ValidationResult lists the problems found in composer.json.
+type ValidationResult struct {
+	// Errors make the file unusable
+	Errors []string
+	// PublishErrors only matter for packages published to a registry
+	PublishErrors []string
+	// Warnings point out practices that should be avoided
+	Warnings []string
+	// LockErrors report a composer.lock that is out of date
+	LockErrors []string
+}
+
+// ValidateOptions configures Validate.
+type ValidateOptions struct {
+	// NoCheckPublish reports publish errors as warnings
+	NoCheckPublish bool
+	// NoCheckLock skips checking composer.lock
+	NoCheckLock bool
+}
+
+// jsonKind names the JSON type of a value decoded into interface{} the way
+// JSON schema errors do
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		return "Boolean"
+	case float64:
+		return "Number"
+	case string:
+		return "String"
+	case []interface{}:
+		return "Array"
+	}
+	return "Object"
+}
+
+// schemaTypes lists the expected types of the composer.json properties the
+// validator checks
+var schemaTypes = map[string][]string{
+	"name":                 {"String"},
+	"description":          {"String"},
+	"version":              {"String"},
+	"type":                 {"String"},
+	"keywords":             {"Array"},
+	"homepage":             {"String"},
+	"readme":               {"String"},
+	"time":                 {"String"},
+	"license":              {"String", "Array"},
+	"authors":              {"Array"},
+	"support":              {"Object"},
+	"funding":              {"Array"},
+	"require":              {"Object"},
+	"require-dev":          {"Object"},
+	"conflict":             {"Object"},
+	"replace":              {"Object"},
+	"provide":              {"Object"},
+	"suggest":              {"Object"},
+	"autoload":             {"Object"},
+	"autoload-dev":         {"Object"},
+	"include-path":         {"Array"},
+	"target-dir":           {"String"},
+	"minimum-stability":    {"String"},
+	"prefer-stable":        {"Boolean"},
+	"repositories":         {"Object", "Array"},
+	"config":               {"Object"},
+	"scripts":              {"Object"},
+	"scripts-descriptions": {"Object"},
+	"extra":                {"Object", "Array"},
+	"bin":                  {"String", "Array"},
+	"archive":              {"Object"},
+	"abandoned":            {"Boolean", "String"},
+	"non-feature-branches": {"Array"},
+	"default-branch":       {"Boolean"},
+	"notification-url":     {"String"},
+	"_comment":             {"String", "Array"},
+}
+
+var linkSections = []string{"require", "require-dev", "conflict", "replace", "provide"}
+
+// Validate checks composer.json against Composer's schema and rules, and
+// composer.lock against composer.json.
+func (c *Composer) Validate(opts ValidateOptions) (*ValidationResult, error) {
+	data, err := os.ReadFile(c.manifestPath())
+	if err != nil {
+		return nil, err
+	}
+	result := ValidateManifest(data)
+	if opts.NoCheckPublish {
+		result.Warnings = append(result.Warnings, result.PublishErrors...)
+		result.PublishErrors = nil
+	}
+	if !opts.NoCheckLock && len(result.Errors) == 0 {
+		if lock, err := ReadLock(c.lockPath()); err == nil {
+			if hash, err := ContentHash(data); err == nil && hash != lock.ContentHash {
+				result.LockErrors = append(result.LockErrors, "The lock file is not up to date with the latest changes in composer.json, it is recommended that you run `composer update` or `composer update <package name>`.")
+			}
+		} else if !os.IsNotExist(err) {
+			result.LockErrors = append(result.LockErrors, err.Error())
+		}
+	}
+	return result, nil
+}
+
+// ValidateManifest checks the contents of a composer.json file.
+func ValidateManifest(data []byte) *ValidationResult {
+	result := &ValidationResult{}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("composer.json does not contain valid JSON: %v", err))
+		return result
+	}
+
+	for _, key := range sortedStrings(doc) {
+		expected, ok := schemaTypes[key]
+		if !ok {
+			continue
+		}
+		kind := jsonKind(doc[key])
+		if !containsString(expected, kind) {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s : %s value found, but %s is required", key, kind, describeKinds(expected)))
+			delete(doc, key)
+		}
+	}
+
+	if name, ok := doc["name"].(string); !ok {
+		result.PublishErrors = append(result.PublishErrors, "name : The property name is required")
+	} else if !packageNameRegex.MatchString(name) {
+		if packageNameRegex.MatchString(strings.ToLower(name)) {
+			result.PublishErrors = append(result.PublishErrors, fmt.Sprintf("name : %s is invalid, it should not contain uppercase characters. We suggest using %s instead.", name, strings.ToLower(name)))
+		} else {
+			result.Errors = append(result.Errors, fmt.Sprintf("name : Does not match the regex pattern %s", packageNameRegex.String()))
+		}
+	}
+	if _, ok := doc["description"]; !ok {
+		result.PublishErrors = append(result.PublishErrors, "description : The property description is required")
+	}
+	if _, ok := doc["license"]; !ok {
+		result.Warnings = append(result.Warnings, "No license specified, it is recommended to do so. For closed-source software you may use \"proprietary\" as license.")
+	}
+	if _, ok := doc["version"]; ok {
+		result.Warnings = append(result.Warnings, "The version field is present, it is recommended to leave it out if the package is published on Packagist.")
+	}
+
+	if stability, ok := doc["minimum-stability"].(string); ok {
+		if _, valid := ParseStability(stability); !valid {
+			result.Errors = append(result.Errors, `minimum-stability : Does not have a value in the enumeration ["dev","alpha","beta","rc","RC","stable"]`)
+		}
+	}
+
+	validateLinks(doc, result)
+	for _, section := range []string{"autoload", "autoload-dev"} {
+		if autoload, ok := doc[section].(map[string]interface{}); ok {
+			validateAutoload(section, autoload, result)
+		}
+	}
+	validateRepositories(doc["repositories"], result)
+	return result
+}
+
+func validateLinks(doc map[string]interface{}, result *ValidationResult) {
+	name, _ := doc["name"].(string)
+	required := make(map[string]bool)
+	for _, section := range linkSections {
+		links, ok := doc[section].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, pkg := range sortedStrings(links) {
+			constraint, ok := links[pkg].(string)
+			if !ok {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s.%s : %s value found, but a string is required", section, pkg, jsonKind(links[pkg])))
+				continue
+			}
+			if !packageNameRegex.MatchString(strings.ToLower(pkg)) && !isPlatformPackage(pkg) {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s.%s : invalid key, package names must be in the format vendor/package", section, pkg))
+			}
+			if constraint == "self.version" && (section == "replace" || section == "provide" || section == "conflict") {
+				continue
+			}
+			if _, err := ParseConstraint(constraint); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s.%s : invalid version constraint (%v)", section, pkg, err))
+				continue
+			}
+			if section == "require" || section == "require-dev" {
+				if strings.EqualFold(pkg, name) {
+					result.Errors = append(result.Errors, fmt.Sprintf("%s.%s : a package cannot set a %s on itself", section, pkg, section))
+				}
+				if strings.TrimSpace(constraint) == "*" || strings.HasPrefix(strings.TrimSpace(constraint), ">") && !strings.ContainsAny(constraint, "<,| ") {
+					result.Warnings = append(result.Warnings, fmt.Sprintf("%s.%s : unbound version constraints (%s) should be avoided", section, pkg, constraint))
+				}
+				if section == "require-dev" && required[strings.ToLower(pkg)] {
+					result.Warnings = append(result.Warnings, fmt.Sprintf("%s is required both in require and require-dev, this can lead to unexpected behavior", pkg))
+				}
+				if section == "require" {
+					required[strings.ToLower(pkg)] = true
+				}
+			}
+		}
+	}
+}
+
+func validateAutoload(section string, autoload map[string]interface{}, result *ValidationResult) {
+	for _, kind := range sortedStrings(autoload) {
+		value := autoload[kind]
+		switch kind {
+		case "psr-4", "psr-0":
+			prefixes, ok := value.(map[string]interface{})
+			if !ok {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s.%s : %s value found, but an object is required", section, kind, jsonKind(value)))
+				continue
+			}
+			for _, prefix := range sortedStrings(prefixes) {
+				switch prefixes[prefix].(type) {
+				case string, []interface{}:
+				default:
+					result.Errors = append(result.Errors, fmt.Sprintf("%s.%s.%s : %s value found, but a string or an array is required", section, kind, prefix, jsonKind(prefixes[prefix])))
+				}
+				if kind == "psr-4" && prefix != "" && !strings.HasSuffix(prefix, "\\") {
+					result.Errors = append(result.Errors, fmt.Sprintf("%s.psr-4 : invalid value (%s), namespaces must end with a namespace separator, should be %s\\\\", section, prefix, prefix))
+				}
+			}
+		case "classmap", "files", "exclude-from-classmap":
+			if _, ok := value.([]interface{}); !ok {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s.%s : %s value found, but an array is required", section, kind, jsonKind(value)))
+			}
+		default:
+			result.Errors = append(result.Errors, fmt.Sprintf("%s : The property %s is not defined and the definition does not allow additional properties", section, kind))
+		}
+	}
+}
+
+func validateRepositories(value interface{}, result *ValidationResult) {
+	var entries []interface{}
+	switch repos := value.(type) {
+	case []interface{}:
+		entries = repos
+	case map[string]interface{}:
+		for _, key := range sortedStrings(repos) {
+			entries = append(entries, repos[key])
+		}
+	default:
+		return
+	}
+	for i, entry := range entries {
+		if entry == false {
+			continue
+		}
+		repo, ok := entry.(map[string]interface{})
+		if !ok {
+			result.Errors = append(result.Errors, fmt.Sprintf("repositories[%d] : %s value found, but an object is required", i, jsonKind(entry)))
+			continue
+		}
+		if len(repo) == 1 {
+			if _, disabled := firstValue(repo).(bool); disabled {
+				continue
+			}
+		}
+		repoType, _ := repo["type"].(string)
+		switch repoType {
+		case "path", "vcs", "git", "github", "gitlab", "bitbucket", "composer", "package", "artifact", "pear", "svn", "hg", "fossil":
+		case "":
+			result.Errors = append(result.Errors, fmt.Sprintf("repositories[%d].type : The property type is required", i))
+			continue
+		default:
+			result.Errors = append(result.Errors, fmt.Sprintf("repositories[%d].type : Does not have a value in the enumeration of repository types (%s)", i, repoType))
+			continue
+		}
+		if _, ok := repo["url"].(string); !ok && repoType != "package" {
+			result.Errors = append(result.Errors, fmt.Sprintf("repositories[%d].url : The property url is required", i))
+		}
+	}
+}
+
+func firstValue(m map[string]interface{}) interface{} {
+	for _, v := range m {
+		return v
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// describeKinds renders expected JSON types as "a string" or "a string or
+// an array"
+func describeKinds(kinds []string) string {
+	described := make([]string, len(kinds))
+	for i, kind := range kinds {
+		article := "a"
+		if strings.ContainsAny(kind[:1], "AEIOU") {
+			article = "an"
+		}
+		described[i] = article + " " + strings.ToLower(kind)
+	}
+	sort.Strings(described)
+	return strings.Join(described, " or ")
+}
@@ -0,0 +1,225 @@
+package coverage
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/wudi/hey/vm"
+)
+
+type cloverReport struct {
+	XMLName   xml.Name      `xml:"coverage"`
+	Generated int64         `xml:"generated,attr"`
+	Project   cloverProject `xml:"project"`
+}
+
+type cloverProject struct {
+	Timestamp int64         `xml:"timestamp,attr"`
+	Files     []cloverFile  `xml:"file"`
+	Metrics   cloverMetrics `xml:"metrics"`
+}
+
+type cloverFile struct {
+	Name    string        `xml:"name,attr"`
+	Classes []cloverClass `xml:"class"`
+	Lines   []cloverLine  `xml:"line"`
+	Metrics cloverMetrics `xml:"metrics"`
+}
+
+type cloverClass struct {
+	Name      string        `xml:"name,attr"`
+	Namespace string        `xml:"namespace,attr"`
+	Metrics   cloverMetrics `xml:"metrics"`
+}
+
+type cloverLine struct {
+	Num        int     `xml:"num,attr"`
+	Type       string  `xml:"type,attr"`
+	Name       string  `xml:"name,attr,omitempty"`
+	Visibility string  `xml:"visibility,attr,omitempty"`
+	Complexity *int    `xml:"complexity,attr,omitempty"`
+	Crap       *string `xml:"crap,attr,omitempty"`
+	Count      int     `xml:"count,attr"`
+	TrueCount  *int    `xml:"truecount,attr,omitempty"`
+	FalseCount *int    `xml:"falsecount,attr,omitempty"`
+}
+
+// cloverMetrics are the totals of a class, a file or the project. The
+// attributes a level does not have are left out.
+type cloverMetrics struct {
+	Files               *int `xml:"files,attr,omitempty"`
+	Loc                 *int `xml:"loc,attr,omitempty"`
+	Ncloc               *int `xml:"ncloc,attr,omitempty"`
+	Classes             *int `xml:"classes,attr,omitempty"`
+	Complexity          *int `xml:"complexity,attr,omitempty"`
+	Methods             int  `xml:"methods,attr"`
+	CoveredMethods      int  `xml:"coveredmethods,attr"`
+	Conditionals        int  `xml:"conditionals,attr"`
+	CoveredConditionals int  `xml:"coveredconditionals,attr"`
+	Statements          int  `xml:"statements,attr"`
+	CoveredStatements   int  `xml:"coveredstatements,attr"`
+	Elements            int  `xml:"elements,attr"`
+	CoveredElements     int  `xml:"coveredelements,attr"`
+}
+
+func (m *cloverMetrics) add(other cloverMetrics) {
+	m.Methods += other.Methods
+	m.CoveredMethods += other.CoveredMethods
+	m.Conditionals += other.Conditionals
+	m.CoveredConditionals += other.CoveredConditionals
+	m.Statements += other.Statements
+	m.CoveredStatements += other.CoveredStatements
+	m.Elements += other.Elements
+	m.CoveredElements += other.CoveredElements
+}
+
+func (m *cloverMetrics) addFunction(f *function) {
+	m.Methods++
+	if f.hits > 0 {
+		m.CoveredMethods++
+	}
+	m.Conditionals += f.conditionals
+	m.CoveredConditionals += f.coveredConditionals
+	m.Statements += f.statements
+	m.CoveredStatements += f.coveredStatements
+}
+
+// sumElements counts methods, conditionals and statements as elements
+func (m *cloverMetrics) sumElements() {
+	m.Elements = m.Methods + m.Conditionals + m.Statements
+	m.CoveredElements = m.CoveredMethods + m.CoveredConditionals + m.CoveredStatements
+}
+
+// WriteClover writes the coverage of files as a Clover XML report, laid out
+// as PHPUnit's --coverage-clover report. Functions and methods get a method
+// line at the first line of their body, and lines with conditional jumps a
+// cond line when branches were recorded.
+func WriteClover(w io.Writer, files []vm.FileCoverage) error {
+	timestamp := now().Unix()
+	report := cloverReport{Generated: timestamp, Project: cloverProject{Timestamp: timestamp}}
+	total := cloverMetrics{Files: new(int), Loc: new(int), Ncloc: new(int), Classes: new(int)}
+
+	for _, file := range files {
+		out := cloverFile{Name: file.File}
+		functions := analyze(file)
+
+		// Methods are totalled by class, and functions only for the file
+		var classOrder []string
+		classes := make(map[string]*cloverMetrics)
+		starts := make(map[int][]*function)
+		for _, f := range functions {
+			starts[f.Line] = append(starts[f.Line], f)
+			if f.Class == "" {
+				continue
+			}
+			metrics, ok := classes[f.Class]
+			if !ok {
+				metrics = &cloverMetrics{Complexity: new(int)}
+				classes[f.Class] = metrics
+				classOrder = append(classOrder, f.Class)
+			}
+			metrics.addFunction(f)
+			*metrics.Complexity += f.complexity()
+		}
+		for _, name := range classOrder {
+			metrics := classes[name]
+			metrics.sumElements()
+			namespace, _ := splitClass(name)
+			out.Classes = append(out.Classes, cloverClass{Name: name, Namespace: namespace, Metrics: *metrics})
+		}
+
+		branches := make(map[int][]vm.BranchCoverage)
+		for _, branch := range file.Branches {
+			branches[branch.Line] = append(branches[branch.Line], branch)
+		}
+		metrics := cloverMetrics{Loc: new(int), Ncloc: new(int), Classes: new(int)}
+		*metrics.Classes = len(classOrder)
+		for _, line := range sortedLines(file) {
+			hits := file.Lines[line]
+			for _, f := range starts[line] {
+				complexity, crap := f.complexity(), formatCrap(f.crap())
+				out.Lines = append(out.Lines, cloverLine{Num: line, Type: "method", Name: f.Name,
+					Visibility: visibility(f.Visibility), Complexity: &complexity, Crap: &crap, Count: f.hits})
+				metrics.Methods++
+				if f.hits > 0 {
+					metrics.CoveredMethods++
+				}
+			}
+
+			metrics.Statements++
+			if hits > 0 {
+				metrics.CoveredStatements++
+			}
+			if len(branches[line]) == 0 {
+				out.Lines = append(out.Lines, cloverLine{Num: line, Type: "stmt", Count: hits})
+				continue
+			}
+			trueCount, falseCount := 0, 0
+			for _, branch := range branches[line] {
+				trueCount += branch.True
+				falseCount += branch.False
+				metrics.Conditionals += 2
+				metrics.CoveredConditionals += branchesCovered(branch)
+			}
+			out.Lines = append(out.Lines, cloverLine{Num: line, Type: "cond", Count: hits,
+				TrueCount: &trueCount, FalseCount: &falseCount})
+		}
+		metrics.sumElements()
+		*metrics.Loc, *metrics.Ncloc = countLines(file)
+		out.Metrics = metrics
+
+		*total.Files++
+		*total.Loc += *metrics.Loc
+		*total.Ncloc += *metrics.Ncloc
+		*total.Classes += *metrics.Classes
+		total.add(metrics)
+		report.Project.Files = append(report.Project.Files, out)
+	}
+	report.Project.Metrics = total
+	return writeXML(w, report)
+}
+
+// visibility defaults to public, as for functions
+func visibility(v string) string {
+	if v == "" {
+		return "public"
+	}
+	return strings.ToLower(v)
+}
+
+func formatCrap(crap float64) string {
+	return strconv.FormatFloat(math.Round(crap*100)/100, 'f', -1, 64)
+}
+
+// countLines counts the lines of file, and those that are neither blank nor
+// only a comment. Files that cannot be read count up to their last
+// executable line.
+func countLines(file vm.FileCoverage) (loc, ncloc int) {
+	data, err := os.ReadFile(file.File)
+	if err != nil {
+		for line := range file.Lines {
+			loc = max(loc, line)
+		}
+		return loc, loc
+	}
+	text := strings.TrimSuffix(string(data), "\n")
+	inComment := false
+	for _, line := range strings.Split(text, "\n") {
+		loc++
+		line = strings.TrimSpace(line)
+		switch {
+		case inComment:
+			inComment = !strings.Contains(line, "*/")
+		case strings.HasPrefix(line, "/*"):
+			inComment = !strings.Contains(line, "*/")
+		case line == "", strings.HasPrefix(line, "//"), strings.HasPrefix(line, "#"):
+		default:
+			ncloc++
+		}
+	}
+	return loc, ncloc
+}
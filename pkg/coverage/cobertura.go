@@ -0,0 +1,224 @@
+package coverage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wudi/hey/vm"
+)
+
+const coberturaDoctype = `<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`
+
+type coberturaReport struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      int                `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity int              `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string            `xml:"name,attr"`
+	Filename   string            `xml:"filename,attr"`
+	LineRate   string            `xml:"line-rate,attr"`
+	BranchRate string            `xml:"branch-rate,attr"`
+	Complexity int               `xml:"complexity,attr"`
+	Methods    []coberturaMethod `xml:"methods>method"`
+	Lines      []coberturaLine   `xml:"lines>line"`
+}
+
+type coberturaMethod struct {
+	Name       string          `xml:"name,attr"`
+	Signature  string          `xml:"signature,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity int             `xml:"complexity,attr"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              int    `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+
+	// conditions counts the ways the line's conditional jumps can go, and
+	// covered those they went
+	conditions, covered int
+}
+
+// lineCounts totals the lines and branches of part of a report
+type lineCounts struct {
+	lines, coveredLines       int
+	branches, coveredBranches int
+}
+
+func (c *lineCounts) add(other lineCounts) {
+	c.lines += other.lines
+	c.coveredLines += other.coveredLines
+	c.branches += other.branches
+	c.coveredBranches += other.coveredBranches
+}
+
+func (c lineCounts) lineRate() string {
+	return rate(c.coveredLines, c.lines)
+}
+
+func (c lineCounts) branchRate() string {
+	return rate(c.coveredBranches, c.branches)
+}
+
+// rate formats the share of covered out of total, which is complete when
+// there is nothing to cover
+func rate(covered, total int) string {
+	if total == 0 {
+		return "1"
+	}
+	return strconv.FormatFloat(float64(covered)/float64(total), 'f', -1, 64)
+}
+
+// WriteCobertura writes the coverage of files as a Cobertura XML report,
+// laid out as PHPUnit's --coverage-cobertura report: each file is a package
+// holding its classes, and the code outside of classes is a class named
+// after the file.
+func WriteCobertura(w io.Writer, files []vm.FileCoverage) error {
+	report := coberturaReport{Version: "0.4", Timestamp: now().Unix()}
+	source := commonDir(files)
+	report.Sources = []string{source}
+
+	var total lineCounts
+	for _, file := range files {
+		relative := file.File
+		if rel, err := filepath.Rel(source, file.File); err == nil && !strings.HasPrefix(rel, "..") {
+			relative = rel
+		}
+		lines := coberturaLines(file)
+		functions := analyze(file)
+
+		pkg := coberturaPackage{Name: relative}
+		var classOrder []string
+		classes := make(map[string]*coberturaClass)
+		owned := make(map[int]bool)
+		for _, f := range functions {
+			class, ok := classes[f.Class]
+			if !ok {
+				name := f.Class
+				if name == "" {
+					name = relative
+				}
+				class = &coberturaClass{Name: name, Filename: relative}
+				classes[f.Class] = class
+				classOrder = append(classOrder, f.Class)
+			}
+			method := coberturaMethod{Name: f.Name, Complexity: f.complexity(),
+				LineRate: rate(f.coveredStatements, f.statements), BranchRate: rate(f.coveredConditionals, f.conditionals)}
+			for _, line := range f.Lines {
+				method.Lines = append(method.Lines, lines[line])
+				class.Lines = append(class.Lines, lines[line])
+				owned[line] = true
+			}
+			class.Methods = append(class.Methods, method)
+			class.Complexity += f.complexity()
+		}
+
+		// The lines outside of methods belong to the file's own class
+		for _, line := range sortedLines(file) {
+			if owned[line] {
+				continue
+			}
+			class, ok := classes[""]
+			if !ok {
+				class = &coberturaClass{Name: relative, Filename: relative}
+				classes[""] = class
+				classOrder = append(classOrder, "")
+			}
+			class.Lines = append(class.Lines, lines[line])
+		}
+
+		var counts lineCounts
+		for _, name := range classOrder {
+			class := classes[name]
+			sort.Slice(class.Lines, func(i, j int) bool { return class.Lines[i].Number < class.Lines[j].Number })
+			var classCounts lineCounts
+			for _, line := range class.Lines {
+				classCounts.add(countLine(line))
+			}
+			class.LineRate, class.BranchRate = classCounts.lineRate(), classCounts.branchRate()
+			counts.add(classCounts)
+			pkg.Complexity += class.Complexity
+			pkg.Classes = append(pkg.Classes, *class)
+		}
+		pkg.LineRate, pkg.BranchRate = counts.lineRate(), counts.branchRate()
+		total.add(counts)
+		report.Complexity += pkg.Complexity
+		report.Packages = append(report.Packages, pkg)
+	}
+
+	report.LineRate, report.BranchRate = total.lineRate(), total.branchRate()
+	report.LinesCovered, report.LinesValid = total.coveredLines, total.lines
+	report.BranchesCovered, report.BranchesValid = total.coveredBranches, total.branches
+	return writeXML(w, report, coberturaDoctype)
+}
+
+// coberturaLines renders the executable lines of file, with the share of
+// branches taken on lines with conditional jumps
+func coberturaLines(file vm.FileCoverage) map[int]coberturaLine {
+	conditions := make(map[int][2]int)
+	for _, branch := range file.Branches {
+		c := conditions[branch.Line]
+		conditions[branch.Line] = [2]int{c[0] + branchesCovered(branch), c[1] + 2}
+	}
+	lines := make(map[int]coberturaLine, len(file.Lines))
+	for line, hits := range file.Lines {
+		l := coberturaLine{Number: line, Hits: hits}
+		if c, ok := conditions[line]; ok {
+			l.Branch = true
+			l.ConditionCoverage = fmt.Sprintf("%d%% (%d/%d)", c[0]*100/c[1], c[0], c[1])
+			l.covered, l.conditions = c[0], c[1]
+		}
+		lines[line] = l
+	}
+	return lines
+}
+
+func countLine(line coberturaLine) lineCounts {
+	counts := lineCounts{lines: 1, branches: line.conditions, coveredBranches: line.covered}
+	if line.Hits > 0 {
+		counts.coveredLines = 1
+	}
+	return counts
+}
+
+// commonDir returns the deepest directory holding all of files
+func commonDir(files []vm.FileCoverage) string {
+	if len(files) == 0 {
+		return "."
+	}
+	dir := filepath.Dir(files[0].File)
+	for _, file := range files[1:] {
+		for dir != filepath.Dir(dir) && !strings.HasPrefix(file.File, dir+string(filepath.Separator)) {
+			dir = filepath.Dir(dir)
+		}
+	}
+	return dir
+}
@@ -0,0 +1,156 @@
+// Package coverage writes the code coverage recorded by vm.Coverage as the
+// Clover and Cobertura XML reports PHPUnit produces, which CI services and
+// IDEs read.
+package coverage
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wudi/hey/vm"
+)
+
+// Format is a coverage report format
+type Format int
+
+const (
+	Clover Format = iota
+	Cobertura
+)
+
+// now is the time reports are generated at, replaced by tests
+var now = time.Now
+
+// WriteFile writes the coverage of files to path in format.
+func WriteFile(path string, format Format, files []vm.FileCoverage) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case Clover:
+		err = WriteClover(f, files)
+	case Cobertura:
+		err = WriteCobertura(f, files)
+	default:
+		err = fmt.Errorf("unknown coverage format %d", format)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeXML writes report as an indented XML document, after the header
+// lines given
+func writeXML(w io.Writer, report interface{}, header ...string) error {
+	out := bufio.NewWriter(w)
+	out.WriteString(xml.Header)
+	for _, line := range header {
+		out.WriteString(line + "\n")
+	}
+	encoder := xml.NewEncoder(out)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	out.WriteString("\n")
+	return out.Flush()
+}
+
+// function is a function or method of a file with the counts of its lines
+// and branches
+type function struct {
+	vm.FunctionCoverage
+	statements, coveredStatements     int
+	conditionals, coveredConditionals int
+	// hits counts the calls, as the runs of its first line
+	hits int
+}
+
+// complexity is the cyclomatic complexity of the function, as one more than
+// its decisions
+func (f *function) complexity() int {
+	return 1 + f.conditionals/2
+}
+
+// crap is the Change Risk Anti-Patterns score of the function, which grows
+// with its complexity and falls as its lines are covered
+func (f *function) crap() float64 {
+	complexity := float64(f.complexity())
+	covered := 0.0
+	if f.statements > 0 {
+		covered = float64(f.coveredStatements) / float64(f.statements)
+	}
+	return complexity*complexity*math.Pow(1-covered, 3) + complexity
+}
+
+// analyze counts the lines and branches of the functions of file. Closures
+// count toward the lines of the file but are not functions of their own.
+func analyze(file vm.FileCoverage) []*function {
+	branches := make(map[int][]vm.BranchCoverage)
+	for _, branch := range file.Branches {
+		branches[branch.Line] = append(branches[branch.Line], branch)
+	}
+	var functions []*function
+	for _, fn := range file.Functions {
+		if fn.Name == "{closure}" {
+			continue
+		}
+		f := &function{FunctionCoverage: fn}
+		for i, line := range fn.Lines {
+			hits := file.Lines[line]
+			if i == 0 {
+				f.hits = hits
+			}
+			f.statements++
+			if hits > 0 {
+				f.coveredStatements++
+			}
+			for _, branch := range branches[line] {
+				f.conditionals += 2
+				f.coveredConditionals += branchesCovered(branch)
+			}
+		}
+		functions = append(functions, f)
+	}
+	return functions
+}
+
+// branchesCovered counts the values the condition of branch had, out of two
+func branchesCovered(branch vm.BranchCoverage) int {
+	covered := 0
+	if branch.True > 0 {
+		covered++
+	}
+	if branch.False > 0 {
+		covered++
+	}
+	return covered
+}
+
+// sortedLines returns the executable lines of file in order
+func sortedLines(file vm.FileCoverage) []int {
+	lines := make([]int, 0, len(file.Lines))
+	for line := range file.Lines {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// splitClass separates the namespace of a class name, which is "global" for
+// classes outside of any namespace as in PHPUnit's reports
+func splitClass(name string) (namespace, class string) {
+	if i := strings.LastIndex(name, "\\"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "global", name
+}
@@ -0,0 +1,174 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/compiler"
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vm"
+	"github.com/wudi/hey/vmfactory"
+)
+
+const testScript = `<?php
+class Greeter {
+    public function greet($name) {
+        if ($name === "") {
+            return "nobody";
+        }
+        return "hello $name";
+    }
+}
+
+function unused() {
+    return 1;
+}
+
+echo (new Greeter)->greet("world"), "\n";
+`
+
+// runScript runs source with coverage recorded, and returns its output and
+// the path it was run from
+func runScript(t *testing.T, source string, coverage *vm.Coverage) (string, string) {
+	t.Helper()
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime.Bootstrap())
+	require.NoError(t, runtime.InitializeVMIntegration())
+
+	script := filepath.Join(t.TempDir(), "covered.php")
+	require.NoError(t, os.WriteFile(script, []byte(source), 0644))
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return compiler.NewCompiler()
+	})
+	compiled, err := factory.CompileFile(script)
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	ctx := vm.NewExecutionContext()
+	ctx.OutputWriter = &output
+	vmachine := factory.CreateVM()
+	if coverage != nil {
+		vmachine.SetCoverage(coverage)
+	}
+	require.NoError(t, vmachine.Execute(ctx, compiled.Instructions, compiled.Constants,
+		compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits))
+	return output.String(), script
+}
+
+func TestCoverage(t *testing.T) {
+	coverage := vm.NewCoverage(true)
+	output, script := runScript(t, testScript, coverage)
+	require.Equal(t, "hello world\n", output)
+
+	files := coverage.Files()
+	require.Len(t, files, 1)
+	file := files[0]
+	require.Equal(t, script, file.File)
+	require.Equal(t, map[int]int{4: 1, 5: 0, 7: 1, 12: 0, 15: 1}, file.Lines)
+	require.Equal(t, []vm.BranchCoverage{{Line: 4, True: 0, False: 1}}, file.Branches)
+	require.Len(t, file.Functions, 2)
+	require.Equal(t, vm.FunctionCoverage{Name: "greet", Class: "Greeter", Visibility: "public",
+		Line: 4, EndLine: 7, Lines: []int{4, 5, 7}}, file.Functions[0])
+	require.Equal(t, "unused", file.Functions[1].Name)
+}
+
+func TestWriteClover(t *testing.T) {
+	now = func() time.Time { return time.Unix(1700000000, 0) }
+	t.Cleanup(func() { now = time.Now })
+	coverage := vm.NewCoverage(true)
+	_, script := runScript(t, testScript, coverage)
+
+	var out bytes.Buffer
+	require.NoError(t, WriteClover(&out, coverage.Files()))
+	text := out.String()
+	require.Contains(t, text, `<coverage generated="1700000000">`)
+	require.Contains(t, text, `<file name="`+script+`">`)
+	require.Contains(t, text, `<class name="Greeter" namespace="global">`)
+	require.Contains(t, text, `<line num="4" type="method" name="greet" visibility="public" complexity="2" crap="2.15" count="1">`)
+	require.Contains(t, text, `<line num="4" type="cond" count="1" truecount="0" falsecount="1">`)
+	require.Contains(t, text, `<line num="12" type="method" name="unused" visibility="public" complexity="1" crap="2" count="0">`)
+	require.Contains(t, text, `<line num="5" type="stmt" count="0">`)
+	require.Contains(t, text, `<metrics files="1" loc="15" ncloc="13" classes="1" methods="2" coveredmethods="1" conditionals="2" coveredconditionals="1" statements="5" coveredstatements="3" elements="9" coveredelements="5">`)
+}
+
+func TestWriteCobertura(t *testing.T) {
+	coverage := vm.NewCoverage(true)
+	_, script := runScript(t, testScript, coverage)
+
+	var out bytes.Buffer
+	require.NoError(t, WriteCobertura(&out, coverage.Files()))
+	var report coberturaReport
+	require.NoError(t, xml.Unmarshal(out.Bytes(), &report))
+
+	require.Equal(t, []string{filepath.Dir(script)}, report.Sources)
+	require.Equal(t, []int{3, 5, 1, 2}, []int{report.LinesCovered, report.LinesValid, report.BranchesCovered, report.BranchesValid})
+	require.Len(t, report.Packages, 1)
+	classes := report.Packages[0].Classes
+	require.Len(t, classes, 2)
+	require.Equal(t, "Greeter", classes[0].Name)
+	require.Equal(t, "greet", classes[0].Methods[0].Name)
+	require.Equal(t, "50% (1/2)", classes[0].Lines[0].ConditionCoverage)
+
+	// Functions and the code outside of classes belong to the file's class
+	require.Equal(t, "covered.php", classes[1].Name)
+	require.Equal(t, "unused", classes[1].Methods[0].Name)
+	require.Len(t, classes[1].Lines, 2)
+}
+
+func TestCoverageFunctions(t *testing.T) {
+	output, _ := runScript(t, `<?php
+function double($x) {
+    return $x * 2;
+}
+\pcov\start();
+double(1);
+\pcov\stop();
+double(2);
+$covered = \pcov\collect(\pcov\inclusive, [__FILE__]);
+$lines = $covered[__FILE__];
+echo count($lines), " ", $lines[3], " ", $lines[5], " ", $lines[6], " ", $lines[8], "\n";
+echo count(\pcov\collect(\pcov\exclusive, [__FILE__])), "\n";
+xdebug_start_code_coverage(XDEBUG_CC_UNUSED);
+var_dump(xdebug_code_coverage_started());
+xdebug_stop_code_coverage();
+var_dump(xdebug_code_coverage_started(), \pcov\waiting());
+`, nil)
+	require.Equal(t, `13 1 -1 1 -1
+0
+bool(true)
+bool(false)
+array(0) {
+}
+`, output)
+}
+
+// TestCoverageFunctionsWithRecorder tests that pcov only reports the lines
+// run while it was started when a recorder is attached as well
+func TestCoverageFunctionsWithRecorder(t *testing.T) {
+	coverage := vm.NewCoverage(false)
+	output, script := runScript(t, `<?php
+$before = 1;
+\pcov\start();
+$during = 2;
+\pcov\stop();
+$after = 3;
+$covered = \pcov\collect(\pcov\all);
+echo implode(",", array_keys($covered)), "\n";
+echo implode(",", array_keys(array_filter($covered[__FILE__], fn($hits) => $hits > 0))), "\n";
+`, coverage)
+	require.Equal(t, script+"\n4,5\n", output)
+
+	files := coverage.Files()
+	require.Len(t, files, 1)
+	for _, line := range []int{2, 3, 4, 5, 6, 7} {
+		require.Positive(t, files[0].Lines[line], "line %d", line)
+	}
+}
@@ -0,0 +1,498 @@
+// Package dap implements the Debug Adapter Protocol server started by
+// `hey --dap`. Editors launch scripts through it and debug them with line
+// and conditional breakpoints, stepping, call stacks and variable
+// inspection, all driven by the VM's Debugger.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/wudi/hey/pkg/fpm/opcache"
+	"github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/values"
+	"github.com/wudi/hey/vm"
+	"github.com/wudi/hey/vmfactory"
+)
+
+// threadID identifies the script's only thread
+const threadID = 1
+
+// Server is a debug adapter for a single debugging session: it launches one
+// script and controls it through a vm.Debugger.
+type Server struct {
+	vmFactory *vmfactory.VMFactory
+	debugger  *vm.Debugger
+
+	out     io.Writer
+	writeMu sync.Mutex
+	seq     int
+
+	launch   *launchArguments
+	compiled *opcache.CompiledScript
+	started  bool
+
+	mu            sync.Mutex
+	paused        bool
+	breakpointIDs map[*vm.Breakpoint]int
+	nextID        int
+
+	// commands carries requests that inspect or resume the stopped script
+	// to the script's goroutine, which serves them while it is stopped
+	commands chan command
+	// handles are the values behind variablesReference numbers, which are
+	// valid until the script resumes. Only the script's goroutine uses them.
+	handles []handle
+}
+
+// command is run on the script's goroutine while it is stopped. It returns
+// true with the action to take when the script should resume.
+type command struct {
+	run  func(stop *vm.DebugStop) (vm.DebugAction, bool)
+	done chan struct{}
+}
+
+// handle is what a variablesReference expands to: the locals of a frame,
+// the globals, or the elements of an array or object
+type handle struct {
+	frame   int
+	globals bool
+	value   *values.Value
+}
+
+// NewServer creates a debug adapter that compiles scripts with vmFactory.
+func NewServer(vmFactory *vmfactory.VMFactory) *Server {
+	s := &Server{
+		vmFactory:     vmFactory,
+		debugger:      vm.NewDebugger(),
+		breakpointIDs: make(map[*vm.Breakpoint]int),
+		commands:      make(chan command),
+	}
+	s.debugger.Compile = vmFactory.CompileExpression
+	s.debugger.Stopped = s.stopped
+	return s
+}
+
+// Serve reads requests from in and writes responses and events to out until
+// the client disconnects or in is closed.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	s.out = out
+	reader := bufio.NewReader(in)
+	for {
+		content, err := readMessage(reader)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				s.terminate()
+				return nil
+			}
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			return fmt.Errorf("invalid message: %w", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+		if s.handle(&req) {
+			return nil
+		}
+	}
+}
+
+// handle answers req, reporting true when the session is over
+func (s *Server) handle(req *request) bool {
+	var body interface{}
+	var err error
+	switch req.Command {
+	case "initialize":
+		s.respond(req, capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsConditionalBreakpoints:   true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}, nil)
+		s.send("initialized", nil)
+		return false
+	case "launch":
+		err = s.onLaunch(req.Arguments)
+	case "setBreakpoints":
+		body, err = s.onSetBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints", "setFunctionBreakpoints":
+		body = map[string]interface{}{"breakpoints": []breakpoint{}}
+	case "configurationDone":
+		err = s.start()
+	case "threads":
+		body = map[string]interface{}{"threads": []thread{{ID: threadID, Name: "main"}}}
+	case "stackTrace":
+		body, err = s.onStackTrace(req.Arguments)
+	case "scopes":
+		body, err = s.onScopes(req.Arguments)
+	case "variables":
+		body, err = s.onVariables(req.Arguments)
+	case "evaluate":
+		body, err = s.onEvaluate(req.Arguments)
+	case "continue":
+		s.resume(vm.DebugContinue)
+		body = map[string]interface{}{"allThreadsContinued": true}
+	case "next":
+		s.resume(vm.DebugStepOver)
+	case "stepIn":
+		s.resume(vm.DebugStepIn)
+	case "stepOut":
+		s.resume(vm.DebugStepOut)
+	case "pause":
+		s.debugger.Pause()
+	case "terminate":
+		s.terminate()
+	case "disconnect":
+		s.terminate()
+		s.respond(req, nil, nil)
+		return true
+	default:
+		err = fmt.Errorf("unsupported request %q", req.Command)
+	}
+	s.respond(req, body, err)
+	return false
+}
+
+func (s *Server) onLaunch(arguments json.RawMessage) error {
+	var args launchArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return err
+	}
+	if args.Program == "" {
+		return errors.New("no program to launch")
+	}
+	program := args.Program
+	if !filepath.IsAbs(program) && args.Cwd != "" {
+		program = filepath.Join(args.Cwd, program)
+	}
+	program, err := filepath.Abs(program)
+	if err != nil {
+		return err
+	}
+	compiled, err := s.vmFactory.CompileFile(program)
+	if err != nil {
+		return err
+	}
+	args.Program = program
+	s.launch, s.compiled = &args, compiled
+	if args.StopOnEntry {
+		s.debugger.StopOnEntry()
+	}
+	return nil
+}
+
+func (s *Server) onSetBreakpoints(arguments json.RawMessage) (interface{}, error) {
+	var args setBreakpointsArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	breakpoints := make([]*vm.Breakpoint, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		breakpoints[i] = &vm.Breakpoint{Line: bp.Line, Condition: bp.Condition}
+	}
+	s.debugger.SetBreakpoints(args.Source.Path, breakpoints)
+
+	result := make([]breakpoint, len(breakpoints))
+	s.mu.Lock()
+	for i, bp := range breakpoints {
+		s.nextID++
+		s.breakpointIDs[bp] = s.nextID
+		result[i] = breakpoint{ID: s.nextID, Verified: bp.Err == nil, Line: bp.Line, Source: args.Source}
+		if bp.Err != nil {
+			result[i].Message = bp.Err.Error()
+		}
+	}
+	s.mu.Unlock()
+	return map[string]interface{}{"breakpoints": result}, nil
+}
+
+// start runs the launched script in the background
+func (s *Server) start() error {
+	if s.compiled == nil {
+		return errors.New("no program was launched")
+	}
+	if !s.started {
+		s.started = true
+		go s.run()
+	}
+	return nil
+}
+
+func (s *Server) run() {
+	ctx := vm.NewExecutionContext()
+	ctx.OutputWriter = &outputWriter{server: s, category: "stdout"}
+	for name, value := range runtime.GlobalVMIntegration.GetAllVariables() {
+		ctx.GlobalVars.Store(name, value)
+	}
+	args := append([]string{s.launch.Program}, s.launch.Args...)
+	argv := values.NewArray()
+	for i, arg := range args {
+		argv.ArraySet(values.NewInt(int64(i)), values.NewString(arg))
+	}
+	ctx.GlobalVars.Store("$argc", values.NewInt(int64(len(args))))
+	ctx.GlobalVars.Store("$argv", argv)
+
+	vmachine := s.vmFactory.CreateVM()
+	if !s.launch.NoDebug {
+		vmachine.SetDebugger(s.debugger)
+	}
+	compiled := s.compiled
+	err := vmachine.Execute(ctx, compiled.Instructions, compiled.Constants,
+		compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+	exitCode := ctx.ExitCode
+	if !errors.Is(err, vm.ErrDebuggerTerminated) {
+		vmachine.CallAllDestructors(ctx)
+		if err != nil {
+			s.send("output", outputEvent{Category: "stderr", Output: fmt.Sprintf("Fatal error: %v\n", err)})
+			exitCode = 255
+		}
+	}
+	s.send("exited", map[string]interface{}{"exitCode": exitCode})
+	s.send("terminated", nil)
+}
+
+// stopped serves the requests inspecting the stopped script until one of
+// them resumes it
+func (s *Server) stopped(stop *vm.DebugStop) vm.DebugAction {
+	s.mu.Lock()
+	s.paused = true
+	hit := stoppedEvent{Reason: stop.Reason, ThreadID: threadID, AllThreadsStopped: true}
+	if id, ok := s.breakpointIDs[stop.Breakpoint]; ok && stop.Breakpoint != nil {
+		hit.HitBreakpointIDs = []int{id}
+	}
+	s.mu.Unlock()
+	s.send("stopped", hit)
+
+	for cmd := range s.commands {
+		action, resume := cmd.run(stop)
+		if resume {
+			s.handles = nil
+			s.mu.Lock()
+			s.paused = false
+			s.mu.Unlock()
+		}
+		close(cmd.done)
+		if resume {
+			return action
+		}
+	}
+	return vm.DebugContinue
+}
+
+// whileStopped runs fn on the script's goroutine and waits for it,
+// reporting false when the script is not stopped
+func (s *Server) whileStopped(fn func(stop *vm.DebugStop) (vm.DebugAction, bool)) bool {
+	s.mu.Lock()
+	paused := s.paused
+	s.mu.Unlock()
+	if !paused {
+		return false
+	}
+	cmd := command{run: fn, done: make(chan struct{})}
+	s.commands <- cmd
+	<-cmd.done
+	return true
+}
+
+// inspect runs fn on the stopped script
+func (s *Server) inspect(fn func(stop *vm.DebugStop)) error {
+	ok := s.whileStopped(func(stop *vm.DebugStop) (vm.DebugAction, bool) {
+		fn(stop)
+		return vm.DebugContinue, false
+	})
+	if !ok {
+		return errors.New("the script is not stopped")
+	}
+	return nil
+}
+
+func (s *Server) resume(action vm.DebugAction) {
+	s.whileStopped(func(*vm.DebugStop) (vm.DebugAction, bool) {
+		return action, true
+	})
+}
+
+func (s *Server) terminate() {
+	s.debugger.Terminate()
+	s.resume(vm.DebugContinue)
+}
+
+func (s *Server) onStackTrace(arguments json.RawMessage) (interface{}, error) {
+	var args stackTraceArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	var frames []vm.DebugFrame
+	if err := s.inspect(func(stop *vm.DebugStop) { frames = stop.Frames() }); err != nil {
+		return nil, err
+	}
+
+	total := len(frames)
+	start := min(max(args.StartFrame, 0), total)
+	end := total
+	if args.Levels > 0 {
+		end = min(start+args.Levels, total)
+	}
+	stackFrames := make([]stackFrame, 0, end-start)
+	for i := start; i < end; i++ {
+		frame := stackFrame{ID: i + 1, Name: frames[i].Name, Line: frames[i].Line, Column: 1}
+		if frames[i].File != "" {
+			frame.Source = &source{Name: filepath.Base(frames[i].File), Path: frames[i].File}
+		}
+		stackFrames = append(stackFrames, frame)
+	}
+	return map[string]interface{}{"stackFrames": stackFrames, "totalFrames": total}, nil
+}
+
+func (s *Server) onScopes(arguments json.RawMessage) (interface{}, error) {
+	var args scopesArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	var scopes []scope
+	err := s.inspect(func(*vm.DebugStop) {
+		scopes = []scope{
+			{Name: "Locals", VariablesReference: s.newHandle(handle{frame: args.FrameID - 1})},
+			{Name: "Globals", VariablesReference: s.newHandle(handle{globals: true}), Expensive: true},
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+func (s *Server) onVariables(arguments json.RawMessage) (interface{}, error) {
+	var args variablesArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	result := []variable{}
+	err := s.inspect(func(stop *vm.DebugStop) {
+		if args.VariablesReference < 1 || args.VariablesReference > len(s.handles) {
+			return
+		}
+		var children []vm.DebugVariable
+		switch h := s.handles[args.VariablesReference-1]; {
+		case h.value != nil:
+			children = vm.DebugChildren(h.value)
+		case h.globals:
+			children = stop.Globals()
+		default:
+			children = stop.Locals(h.frame)
+		}
+		for _, child := range children {
+			result = append(result, s.newVariable(child.Name, child.Value))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"variables": result}, nil
+}
+
+func (s *Server) onEvaluate(arguments json.RawMessage) (interface{}, error) {
+	var args evaluateArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil, err
+	}
+	frame := 0
+	if args.FrameID > 0 {
+		frame = args.FrameID - 1
+	}
+	var result variable
+	var evalErr error
+	err := s.inspect(func(stop *vm.DebugStop) {
+		var value *values.Value
+		if value, evalErr = stop.Evaluate(frame, args.Expression); evalErr == nil {
+			result = s.newVariable(args.Expression, value)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if evalErr != nil {
+		return nil, evalErr
+	}
+	return map[string]interface{}{
+		"result":             result.Value,
+		"type":               result.Type,
+		"variablesReference": result.VariablesReference,
+	}, nil
+}
+
+// newHandle returns the variablesReference of h
+func (s *Server) newHandle(h handle) int {
+	s.handles = append(s.handles, h)
+	return len(s.handles)
+}
+
+// newVariable describes value, giving arrays and objects a handle to
+// expand them with
+func (s *Server) newVariable(name string, value *values.Value) variable {
+	value = value.Deref()
+	v := variable{Name: name, Type: value.TypeName()}
+	switch {
+	case value.IsNull():
+		v.Value = "null"
+	case value.IsBool():
+		v.Value = strconv.FormatBool(value.ToBool())
+	case value.IsString():
+		v.Value = strconv.Quote(value.ToString())
+	case value.IsArray():
+		v.Value = fmt.Sprintf("array(%d)", value.ArrayCount())
+	case value.IsObject():
+		v.Value = value.Data.(*values.Object).ClassName
+		v.Type = v.Value
+	case value.IsCallable():
+		v.Value, v.Type = "Closure", "Closure"
+	default:
+		v.Value = value.ToString()
+	}
+	if (value.IsArray() && value.ArrayCount() > 0) || (value.IsObject() && len(value.Data.(*values.Object).Properties) > 0) {
+		v.VariablesReference = s.newHandle(handle{value: value})
+	}
+	return v
+}
+
+func (s *Server) respond(req *request, body interface{}, err error) {
+	resp := response{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	s.write(&resp.Seq, &resp)
+}
+
+func (s *Server) send(name string, body interface{}) {
+	ev := event{Type: "event", Event: name, Body: body}
+	s.write(&ev.Seq, &ev)
+}
+
+// write numbers message through seq and sends it
+func (s *Server) write(seq *int, message interface{}) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.seq++
+	*seq = s.seq
+	_ = writeMessage(s.out, message)
+}
+
+// outputWriter forwards the script's output to the client as output events
+type outputWriter struct {
+	server   *Server
+	category string
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.server.send("output", outputEvent{Category: w.category, Output: string(p)})
+	return len(p), nil
+}
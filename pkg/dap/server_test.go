@@ -0,0 +1,224 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/compiler"
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vmfactory"
+)
+
+const testScript = `<?php
+function add($a, $b) {
+    $sum = $a + $b;
+    return $sum;
+}
+$total = 0;
+for ($i = 1; $i <= 3; $i++) {
+    $total = add($total, $i);
+}
+echo "total=$total\n";
+`
+
+// testClient drives a Server through the protocol
+type testClient struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	messages chan map[string]interface{}
+	seq      int
+	output   strings.Builder
+	// pending holds the events received while waiting for a response
+	pending []map[string]interface{}
+}
+
+func newTestClient(t *testing.T) (*testClient, string) {
+	t.Helper()
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime.Bootstrap())
+	require.NoError(t, runtime.InitializeVMIntegration())
+
+	script := filepath.Join(t.TempDir(), "add.php")
+	require.NoError(t, os.WriteFile(script, []byte(testScript), 0644))
+
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return compiler.NewCompiler()
+	})
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	go func() {
+		_ = NewServer(factory).Serve(serverIn, serverOut)
+		serverOut.Close()
+	}()
+
+	c := &testClient{t: t, in: clientOut, messages: make(chan map[string]interface{}, 64)}
+	go func() {
+		defer close(c.messages)
+		reader := bufio.NewReader(clientIn)
+		for {
+			content, err := readMessage(reader)
+			if err != nil {
+				return
+			}
+			var message map[string]interface{}
+			if json.Unmarshal(content, &message) == nil {
+				c.messages <- message
+			}
+		}
+	}()
+	t.Cleanup(func() { clientOut.Close() })
+	return c, script
+}
+
+// request sends a request and returns the body of its response, skipping
+// the events sent meanwhile
+func (c *testClient) request(command string, arguments interface{}) map[string]interface{} {
+	c.t.Helper()
+	c.seq++
+	require.NoError(c.t, writeMessage(c.in, map[string]interface{}{
+		"seq": c.seq, "type": "request", "command": command, "arguments": arguments,
+	}))
+	for {
+		message := c.next()
+		if message["type"] == "response" && int(message["request_seq"].(float64)) == c.seq {
+			require.True(c.t, message["success"].(bool), "%s failed: %v", command, message["message"])
+			body, _ := message["body"].(map[string]interface{})
+			return body
+		}
+		c.pending = append(c.pending, message)
+	}
+}
+
+// event waits for the event name and returns its body
+func (c *testClient) event(name string) map[string]interface{} {
+	c.t.Helper()
+	for {
+		var message map[string]interface{}
+		if len(c.pending) > 0 {
+			message, c.pending = c.pending[0], c.pending[1:]
+		} else {
+			message = c.next()
+		}
+		if message["type"] == "event" && message["event"] == name {
+			body, _ := message["body"].(map[string]interface{})
+			return body
+		}
+	}
+}
+
+func (c *testClient) next() map[string]interface{} {
+	c.t.Helper()
+	select {
+	case message, ok := <-c.messages:
+		require.True(c.t, ok, "the server closed the connection")
+		if message["event"] == "output" {
+			c.output.WriteString(message["body"].(map[string]interface{})["output"].(string))
+		}
+		return message
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the server")
+		return nil
+	}
+}
+
+// stopped waits for the script to stop and returns the reason and the
+// top frame's line
+func (c *testClient) stopped() (string, int, string) {
+	c.t.Helper()
+	reason := c.event("stopped")["reason"].(string)
+	frames := c.request("stackTrace", map[string]interface{}{"threadId": 1})["stackFrames"].([]interface{})
+	top := frames[0].(map[string]interface{})
+	return reason, int(top["line"].(float64)), top["name"].(string)
+}
+
+func (c *testClient) variables(reference interface{}) map[string]string {
+	c.t.Helper()
+	result := make(map[string]string)
+	for _, v := range c.request("variables", map[string]interface{}{"variablesReference": reference})["variables"].([]interface{}) {
+		variable := v.(map[string]interface{})
+		result[variable["name"].(string)] = variable["value"].(string)
+	}
+	return result
+}
+
+func TestDebugSession(t *testing.T) {
+	c, script := newTestClient(t)
+
+	c.request("initialize", map[string]interface{}{"adapterID": "hey"})
+	c.event("initialized")
+	c.request("launch", map[string]interface{}{"program": script})
+	breakpoints := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": script},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 3, "condition": "$a == 3"}},
+	})["breakpoints"].([]interface{})
+	require.True(t, breakpoints[0].(map[string]interface{})["verified"].(bool))
+	c.request("configurationDone", nil)
+
+	// The condition holds on the third call only
+	reason, line, name := c.stopped()
+	require.Equal(t, []interface{}{"breakpoint", 3, "add"}, []interface{}{reason, line, name})
+	frames := c.request("stackTrace", map[string]interface{}{"threadId": 1})
+	require.EqualValues(t, 2, frames["totalFrames"])
+	caller := frames["stackFrames"].([]interface{})[1].(map[string]interface{})
+	require.Equal(t, "{main}", caller["name"])
+	require.EqualValues(t, 8, caller["line"])
+
+	scopes := c.request("scopes", map[string]interface{}{"frameId": 1})["scopes"].([]interface{})
+	locals := c.variables(scopes[0].(map[string]interface{})["variablesReference"])
+	require.Equal(t, "3", locals["$a"])
+	require.Equal(t, "3", locals["$b"])
+	require.Equal(t, "6", c.request("evaluate", map[string]interface{}{"expression": "$a + $b", "frameId": 1})["result"])
+	require.Equal(t, "3", c.request("evaluate", map[string]interface{}{"expression": "$total", "frameId": 2})["result"])
+
+	c.request("next", map[string]interface{}{"threadId": 1})
+	reason, line, _ = c.stopped()
+	require.Equal(t, []interface{}{"step", 4}, []interface{}{reason, line})
+
+	c.request("stepOut", map[string]interface{}{"threadId": 1})
+	reason, _, name = c.stopped()
+	require.Equal(t, []interface{}{"step", "{main}"}, []interface{}{reason, name})
+
+	c.request("continue", map[string]interface{}{"threadId": 1})
+	require.EqualValues(t, 0, c.event("exited")["exitCode"])
+	c.event("terminated")
+	require.Equal(t, "total=6\n", c.output.String())
+}
+
+func TestDebugStepping(t *testing.T) {
+	c, script := newTestClient(t)
+
+	c.request("initialize", map[string]interface{}{"adapterID": "hey"})
+	c.request("launch", map[string]interface{}{"program": script, "stopOnEntry": true})
+	c.request("configurationDone", nil)
+
+	reason, line, _ := c.stopped()
+	require.Equal(t, []interface{}{"entry", 6}, []interface{}{reason, line})
+
+	// Stepping over the call stays in the main script
+	c.request("next", map[string]interface{}{"threadId": 1})
+	_, line, _ = c.stopped()
+	require.Equal(t, 7, line)
+	c.request("next", map[string]interface{}{"threadId": 1})
+	_, line, _ = c.stopped()
+	require.Equal(t, 8, line)
+	c.request("stepIn", map[string]interface{}{"threadId": 1})
+	_, line, name := c.stopped()
+	require.Equal(t, []interface{}{3, "add"}, []interface{}{line, name})
+
+	scopes := c.request("scopes", map[string]interface{}{"frameId": 2})["scopes"].([]interface{})
+	globals := c.variables(scopes[1].(map[string]interface{})["variablesReference"])
+	require.Equal(t, "0", globals["$total"])
+	require.Equal(t, "1", globals["$i"])
+
+	c.request("disconnect", nil)
+}
@@ -0,0 +1,128 @@
+package dbgp
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/values"
+	"github.com/wudi/hey/vm"
+)
+
+// triggers are the names of the GET, POST and cookie parameters, and of
+// the environment variables for CLI scripts, that start a session when
+// xdebug.start_with_request is "trigger"
+var triggers = []string{"XDEBUG_SESSION", "XDEBUG_SESSION_START", "XDEBUG_TRIGGER"}
+
+// Config says whether and where to debug scripts. It follows Xdebug's
+// settings, so IDEs set up for Xdebug work unchanged.
+type Config struct {
+	// Mode is the xdebug.mode list; sessions start only in "debug" mode
+	Mode string
+	// StartWithRequest is "yes", "no", or "trigger" to start sessions
+	// only for triggered requests; "default" means "trigger"
+	StartWithRequest string
+	ClientHost       string
+	ClientPort       int
+	IDEKey           string
+}
+
+// LoadConfig reads the xdebug.* ini settings. As in Xdebug, the
+// XDEBUG_MODE environment variable overrides xdebug.mode, and XDEBUG_CONFIG
+// can override the others with "client_host=... client_port=..." pairs.
+func LoadConfig() Config {
+	setting := func(name string) string {
+		value, _ := runtime.GetIniValue("xdebug." + name)
+		return value
+	}
+	settings := map[string]string{
+		"mode":               setting("mode"),
+		"start_with_request": setting("start_with_request"),
+		"client_host":        setting("client_host"),
+		"client_port":        setting("client_port"),
+		"idekey":             setting("idekey"),
+	}
+	for _, pair := range strings.Fields(os.Getenv("XDEBUG_CONFIG")) {
+		if name, value, ok := strings.Cut(pair, "="); ok {
+			if _, known := settings[name]; known && name != "mode" {
+				settings[name] = value
+			}
+		}
+	}
+	if mode, ok := os.LookupEnv("XDEBUG_MODE"); ok {
+		settings["mode"] = mode
+	}
+
+	port, err := strconv.Atoi(settings["client_port"])
+	if err != nil {
+		port = 9003
+	}
+	return Config{
+		Mode:             settings["mode"],
+		StartWithRequest: settings["start_with_request"],
+		ClientHost:       settings["client_host"],
+		ClientPort:       port,
+		IDEKey:           settings["idekey"],
+	}
+}
+
+// Addr returns the host:port of the debugging client
+func (c Config) Addr() string {
+	return net.JoinHostPort(c.ClientHost, strconv.Itoa(c.ClientPort))
+}
+
+// ShouldStart reports whether a script should be debugged, given whether
+// its request or environment carries a trigger
+func (c Config) ShouldStart(triggered bool) bool {
+	debug := false
+	for _, mode := range strings.Split(c.Mode, ",") {
+		if strings.TrimSpace(mode) == "debug" {
+			debug = true
+		}
+	}
+	if !debug {
+		return false
+	}
+	switch c.StartWithRequest {
+	case "yes":
+		return true
+	case "no":
+		return false
+	}
+	return triggered
+}
+
+// EnvTriggered reports whether the environment of a CLI script carries a
+// trigger
+func EnvTriggered() bool {
+	for _, name := range triggers {
+		if _, ok := os.LookupEnv(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestTriggered reports whether the GET, POST or cookie parameters of
+// the request set up in ctx carry a trigger
+func RequestTriggered(ctx *vm.ExecutionContext) bool {
+	for _, superglobal := range []string{"$_GET", "$_POST", "$_COOKIE"} {
+		stored, ok := ctx.GlobalVars.Load(superglobal)
+		if !ok {
+			continue
+		}
+		params, ok := stored.(*values.Value)
+		if !ok || !params.IsArray() {
+			continue
+		}
+		arr := params.Data.(*values.Array)
+		for _, name := range triggers {
+			if arr.Has(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
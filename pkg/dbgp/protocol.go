@@ -0,0 +1,240 @@
+package dbgp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/wudi/hey/values"
+	"github.com/wudi/hey/vm"
+)
+
+// The engine sends XML packets prefixed with their length, both followed
+// by a NUL byte. The client sends commands such as
+// "breakpoint_set -i 4 -t line -f file:///a.php -n 3", each ended by a NUL
+// byte; a command's data comes base64 encoded after "--".
+
+const xmlHeader = `<?xml version="1.0" encoding="iso-8859-1"?>` + "\n"
+
+// Error codes of the protocol
+const (
+	errorParse               = 1
+	errorInvalidOptions      = 3
+	errorUnimplemented       = 4
+	errorCommandUnavailable  = 5
+	errorBreakpointType      = 201
+	errorNoSuchBreakpoint    = 205
+	errorEvaluation          = 206
+	errorPropertyUnavailable = 300
+	errorNoSuchStackDepth    = 301
+	errorNoSuchContext       = 302
+)
+
+// command is a command received from the client
+type command struct {
+	name string
+	args map[string]string
+	data string
+}
+
+func (c *command) arg(name string) string {
+	return c.args[name]
+}
+
+func (c *command) intArg(name string, fallback int) int {
+	if value, err := strconv.Atoi(c.args[name]); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// parseCommand splits a command line into its name, "-x value" arguments
+// and decoded data. Values may be double-quoted with backslash escapes.
+func parseCommand(line string) (*command, error) {
+	cmd := &command{args: make(map[string]string)}
+	if before, after, ok := strings.Cut(line, " -- "); ok {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(after))
+		if err != nil {
+			return nil, fmt.Errorf("invalid data: %w", err)
+		}
+		line, cmd.data = before, string(data)
+	}
+
+	tokens, err := splitArguments(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	cmd.name = tokens[0]
+	for i := 1; i < len(tokens); i++ {
+		if !strings.HasPrefix(tokens[i], "-") || i+1 >= len(tokens) {
+			return nil, fmt.Errorf("invalid argument %q", tokens[i])
+		}
+		cmd.args[tokens[i][1:]] = tokens[i+1]
+		i++
+	}
+	return cmd, nil
+}
+
+func splitArguments(line string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	inToken, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(line):
+			i++
+			current.WriteByte(line[i])
+		case c == '"':
+			quoted, inToken = !quoted, true
+		case c == ' ' && !quoted:
+			if inToken {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				inToken = false
+			}
+		default:
+			current.WriteByte(c)
+			inToken = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inToken {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
+
+// writePacket sends an XML document to w
+func writePacket(w io.Writer, document string) error {
+	document = xmlHeader + document
+	_, err := fmt.Fprintf(w, "%d\x00%s\x00", len(document), document)
+	return err
+}
+
+var attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+
+// attrs renders name/value pairs as XML attributes
+func attrs(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		fmt.Fprintf(&b, ` %s="%s"`, pairs[i], attrEscaper.Replace(pairs[i+1]))
+	}
+	return b.String()
+}
+
+// cdata wraps text in a CDATA section
+func cdata(text string) string {
+	return "<![CDATA[" + strings.ReplaceAll(text, "]]>", "]]]]><![CDATA[>") + "]]>"
+}
+
+// fileURI and filePath convert between paths and the file:// URIs the
+// protocol identifies files by
+func fileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+func filePath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return uri
+}
+
+// propertyOptions limit how much of a value a property element shows, as
+// set by the max_children, max_data and max_depth features
+type propertyOptions struct {
+	maxChildren int
+	maxData     int
+	maxDepth    int
+}
+
+// property renders value as a property element. name is empty for eval
+// results. Arrays and objects list their children, page by page, until
+// depth reaches the maximum.
+func property(name, fullname string, value *values.Value, options propertyOptions, depth, page int) string {
+	value = value.Deref()
+	pairs := []string{}
+	if name != "" {
+		pairs = append(pairs, "name", name, "fullname", fullname)
+	}
+
+	var content string
+	var children []vm.DebugVariable
+	switch {
+	case value.IsNull():
+		pairs = append(pairs, "type", "null")
+	case value.IsBool():
+		pairs = append(pairs, "type", "bool")
+		content = "0"
+		if value.ToBool() {
+			content = "1"
+		}
+	case value.IsInt():
+		pairs = append(pairs, "type", "int")
+		content = value.ToString()
+	case value.IsFloat():
+		pairs = append(pairs, "type", "float")
+		content = value.ToString()
+	case value.IsString():
+		data := value.ToString()
+		pairs = append(pairs, "type", "string", "size", strconv.Itoa(len(data)), "encoding", "base64")
+		if options.maxData > 0 && len(data) > options.maxData {
+			data = data[:options.maxData]
+		}
+		content = base64.StdEncoding.EncodeToString([]byte(data))
+	case value.IsArray():
+		children = vm.DebugChildren(value)
+		pairs = append(pairs, "type", "array", "children", boolAttr(len(children) > 0), "numchildren", strconv.Itoa(len(children)))
+	case value.IsObject():
+		children = vm.DebugChildren(value)
+		pairs = append(pairs, "type", "object", "classname", value.Data.(*values.Object).ClassName,
+			"children", boolAttr(len(children) > 0), "numchildren", strconv.Itoa(len(children)))
+	case value.IsCallable():
+		pairs = append(pairs, "type", "object", "classname", "Closure", "children", "0", "numchildren", "0")
+	default:
+		pairs = append(pairs, "type", value.TypeName())
+		content = value.ToString()
+	}
+
+	if children != nil && depth < options.maxDepth {
+		pairs = append(pairs, "page", strconv.Itoa(page), "pagesize", strconv.Itoa(options.maxChildren))
+		start := min(page*options.maxChildren, len(children))
+		end := min(start+options.maxChildren, len(children))
+		var b strings.Builder
+		for _, child := range children[start:end] {
+			b.WriteString(property(child.Name, childFullname(fullname, value, child.Name), child.Value, options, depth+1, 0))
+		}
+		content = b.String()
+	} else if content != "" {
+		content = cdata(content)
+	}
+	return "<property" + attrs(pairs...) + ">" + content + "</property>"
+}
+
+// childFullname is the PHP expression reading the element or property
+// name of parent, which property_get evaluates
+func childFullname(parent string, value *values.Value, name string) string {
+	if value.IsObject() {
+		return parent + "->" + name
+	}
+	if _, err := strconv.ParseInt(name, 10, 64); err == nil {
+		return parent + "[" + name + "]"
+	}
+	return parent + "['" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(name) + "']"
+}
+
+func boolAttr(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,539 @@
+// Package dbgp debugs scripts from IDEs over the DBGp protocol, as Xdebug
+// does: when a script starts, the engine connects out to the IDE listening
+// on xdebug.client_host and xdebug.client_port, then answers its commands
+// to set breakpoints, step and inspect variables through the VM's Debugger.
+package dbgp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wudi/hey/version"
+	"github.com/wudi/hey/vm"
+	"github.com/wudi/hey/vmfactory"
+)
+
+// connectTimeout bounds how long a script waits for the IDE to accept the
+// connection
+const connectTimeout = 200 * time.Millisecond
+
+// Session states as reported by the status attribute of responses
+const (
+	statusStarting = "starting"
+	statusBreak    = "break"
+	statusStopping = "stopping"
+	statusStopped  = "stopped"
+)
+
+// Session is the connection to the IDE debugging one script. The script's
+// goroutine serves the IDE's commands each time the script stops, so a
+// Session needs no locking.
+type Session struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	vm       *vm.VirtualMachine
+	debugger *vm.Debugger
+	script   string
+	status   string
+	options  propertyOptions
+
+	breakpoints map[int]*breakpoint
+	nextID      int
+
+	// resume is the run or step command waiting for the script to stop
+	resume *command
+	// detached is set once the IDE stopped debugging, whether it detached,
+	// stopped the script or closed the connection
+	detached bool
+}
+
+// breakpoint is a breakpoint set by the IDE, which can be disabled
+// without being removed
+type breakpoint struct {
+	id      int
+	kind    string
+	file    string
+	enabled bool
+	bp      *vm.Breakpoint
+}
+
+// Start connects to the IDE configured by config and attaches a debugger
+// to vmachine for the script, which must then be run with Execute and
+// followed by Finish. The IDE gets to set breakpoints before Start returns.
+func Start(config Config, factory *vmfactory.VMFactory, vmachine *vm.VirtualMachine, script string) (*Session, error) {
+	conn, err := net.DialTimeout("tcp", config.Addr(), connectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to debugging client at %s: %w", config.Addr(), err)
+	}
+	if abs, err := filepath.Abs(script); err == nil {
+		script = abs
+	}
+
+	s := &Session{
+		conn:        conn,
+		reader:      bufio.NewReader(conn),
+		vm:          vmachine,
+		debugger:    vm.NewDebugger(),
+		script:      script,
+		status:      statusStarting,
+		options:     propertyOptions{maxChildren: 32, maxData: 1024, maxDepth: 1},
+		breakpoints: make(map[int]*breakpoint),
+	}
+	s.debugger.Compile = factory.CompileExpression
+	s.debugger.Stopped = s.stopped
+
+	err = s.send("<init" + attrs(
+		"xmlns", "urn:debugger_protocol_v1",
+		"xmlns:xdebug", "https://xdebug.org/dbgp/xdebug",
+		"fileuri", fileURI(script),
+		"language", "PHP",
+		"protocol_version", "1.0",
+		"appid", strconv.Itoa(os.Getpid()),
+		"idekey", config.IDEKey,
+	) + "><engine" + attrs("version", version.Version()) + ">" + cdata("hey") + "</engine></init>")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Serve the IDE until it runs the script
+	s.serve(nil)
+	if s.detached {
+		conn.Close()
+		return nil, errors.New("the debugging client closed the session")
+	}
+	s.status = statusBreak
+	vmachine.SetDebugger(s.debugger)
+	return s, nil
+}
+
+// Finish ends the session once the script has run: it reports the end of
+// the script to the IDE, which can still inspect it until it stops the
+// session.
+func (s *Session) Finish() {
+	if !s.detached {
+		s.status = statusStopping
+		if s.resume != nil {
+			s.respondStatus(s.resume)
+			s.resume = nil
+		}
+		s.serve(nil)
+	}
+	s.vm.SetDebugger(nil)
+	s.conn.Close()
+}
+
+// stopped reports the stop to the IDE and serves its commands until one
+// resumes the script
+func (s *Session) stopped(stop *vm.DebugStop) vm.DebugAction {
+	if s.detached {
+		return vm.DebugContinue
+	}
+	if s.resume != nil {
+		s.respond(s.resume, attrs("status", statusBreak, "reason", "ok"),
+			"<xdebug:message"+attrs("filename", fileURI(stop.File), "lineno", strconv.Itoa(stop.Line))+"></xdebug:message>")
+		s.resume = nil
+	}
+	return s.serve(stop)
+}
+
+// serve answers commands until one resumes the script, or the session
+// ends. stop is nil before the script runs and after it has finished.
+func (s *Session) serve(stop *vm.DebugStop) vm.DebugAction {
+	for !s.detached {
+		line, err := s.reader.ReadString(0)
+		if err != nil {
+			// The IDE went away
+			s.detach()
+			break
+		}
+		cmd, err := parseCommand(strings.TrimSuffix(line, "\x00"))
+		if err != nil {
+			s.respondError(&command{}, errorParse, err.Error())
+			continue
+		}
+		if action, resume := s.handle(cmd, stop); resume {
+			return action
+		}
+	}
+	return vm.DebugContinue
+}
+
+// handle answers cmd, reporting true with the action to take when it
+// resumes the script
+func (s *Session) handle(cmd *command, stop *vm.DebugStop) (vm.DebugAction, bool) {
+	switch cmd.name {
+	case "status":
+		s.respondStatus(cmd)
+	case "feature_get":
+		s.onFeatureGet(cmd)
+	case "feature_set":
+		s.onFeatureSet(cmd)
+	case "breakpoint_set":
+		s.onBreakpointSet(cmd)
+	case "breakpoint_get":
+		if bp, ok := s.breakpoints[cmd.intArg("d", 0)]; ok {
+			s.respond(cmd, "", s.breakpointElement(bp))
+		} else {
+			s.respondError(cmd, errorNoSuchBreakpoint, "no such breakpoint")
+		}
+	case "breakpoint_update":
+		s.onBreakpointUpdate(cmd)
+	case "breakpoint_remove":
+		s.onBreakpointRemove(cmd)
+	case "breakpoint_list":
+		s.onBreakpointList(cmd)
+	case "run", "step_into", "step_over", "step_out":
+		return s.onResume(cmd, stop)
+	case "stack_depth":
+		if stop == nil {
+			s.respondError(cmd, errorCommandUnavailable, "the script is not running")
+			break
+		}
+		s.respond(cmd, attrs("depth", strconv.Itoa(len(stop.Frames()))), "")
+	case "stack_get":
+		s.onStackGet(cmd, stop)
+	case "context_names":
+		s.respond(cmd, "", `<context name="Locals" id="0"></context><context name="Superglobals" id="1"></context>`)
+	case "context_get":
+		s.onContextGet(cmd, stop)
+	case "property_get", "property_value":
+		s.onPropertyGet(cmd, stop)
+	case "eval":
+		s.onEval(cmd, stop)
+	case "stop":
+		s.status = statusStopped
+		s.respondStatus(cmd)
+		s.debugger.Terminate()
+		s.detach()
+	case "detach":
+		s.status = statusStopped
+		s.respondStatus(cmd)
+		s.detach()
+	default:
+		s.respondError(cmd, errorUnimplemented, fmt.Sprintf("unimplemented command %q", cmd.name))
+	}
+	return vm.DebugContinue, false
+}
+
+// detach lets the script run to its end without stopping again
+func (s *Session) detach() {
+	s.detached = true
+	s.vm.SetDebugger(nil)
+}
+
+func (s *Session) onResume(cmd *command, stop *vm.DebugStop) (vm.DebugAction, bool) {
+	if s.status == statusStopping {
+		// Resuming a finished script ends the session
+		s.status = statusStopped
+		s.respondStatus(cmd)
+		s.detach()
+		return vm.DebugContinue, true
+	}
+	s.status = statusBreak
+	s.resume = cmd
+	switch cmd.name {
+	case "step_into":
+		if stop == nil {
+			s.debugger.StopOnEntry()
+		}
+		return vm.DebugStepIn, true
+	case "step_over":
+		if stop == nil {
+			s.debugger.StopOnEntry()
+		}
+		return vm.DebugStepOver, true
+	case "step_out":
+		return vm.DebugStepOut, true
+	}
+	return vm.DebugContinue, true
+}
+
+func (s *Session) onFeatureGet(cmd *command) {
+	name := cmd.arg("n")
+	supported, value := true, ""
+	switch name {
+	case "language_name":
+		value = "PHP"
+	case "language_version":
+		value = "8.0.30"
+	case "protocol_version":
+		value = "1"
+	case "language_supports_threads":
+		value = "0"
+	case "encoding":
+		value = "iso-8859-1"
+	case "supports_async":
+		value = "0"
+	case "breakpoint_types":
+		value = "line conditional"
+	case "max_children":
+		value = strconv.Itoa(s.options.maxChildren)
+	case "max_data":
+		value = strconv.Itoa(s.options.maxData)
+	case "max_depth":
+		value = strconv.Itoa(s.options.maxDepth)
+	default:
+		supported = false
+	}
+	s.respond(cmd, attrs("feature_name", name, "supported", boolAttr(supported)), cdata(value))
+}
+
+func (s *Session) onFeatureSet(cmd *command) {
+	name := cmd.arg("n")
+	value, err := strconv.Atoi(cmd.arg("v"))
+	success := err == nil
+	switch name {
+	case "max_children":
+		if success && value > 0 {
+			s.options.maxChildren = value
+		}
+	case "max_data":
+		if success {
+			s.options.maxData = value
+		}
+	case "max_depth":
+		if success {
+			s.options.maxDepth = value
+		}
+	default:
+		// Other features, such as notify_ok, are accepted and ignored
+		success = true
+	}
+	s.respond(cmd, attrs("feature", name, "success", boolAttr(success)), "")
+}
+
+func (s *Session) onBreakpointSet(cmd *command) {
+	kind := cmd.arg("t")
+	if kind != "line" && kind != "conditional" {
+		s.respondError(cmd, errorBreakpointType, fmt.Sprintf("breakpoint type %q is not supported", kind))
+		return
+	}
+	file := s.script
+	if uri := cmd.arg("f"); uri != "" {
+		file = filePath(uri)
+	}
+	line := cmd.intArg("n", 0)
+	if line <= 0 {
+		s.respondError(cmd, errorInvalidOptions, "a breakpoint needs a line number")
+		return
+	}
+
+	s.nextID++
+	bp := &breakpoint{
+		id:      s.nextID,
+		kind:    kind,
+		file:    file,
+		enabled: cmd.arg("s") != "disabled",
+		bp:      &vm.Breakpoint{Line: line, Condition: cmd.data},
+	}
+	s.breakpoints[bp.id] = bp
+	s.updateBreakpoints(file)
+	if bp.bp.Err != nil {
+		delete(s.breakpoints, bp.id)
+		s.updateBreakpoints(file)
+		s.respondError(cmd, errorEvaluation, bp.bp.Err.Error())
+		return
+	}
+	s.respond(cmd, attrs("state", breakpointState(bp), "id", strconv.Itoa(bp.id)), "")
+}
+
+func (s *Session) onBreakpointUpdate(cmd *command) {
+	bp, ok := s.breakpoints[cmd.intArg("d", 0)]
+	if !ok {
+		s.respondError(cmd, errorNoSuchBreakpoint, "no such breakpoint")
+		return
+	}
+	switch cmd.arg("s") {
+	case "enabled":
+		bp.enabled = true
+	case "disabled":
+		bp.enabled = false
+	}
+	if line := cmd.intArg("n", 0); line > 0 {
+		bp.bp.Line = line
+	}
+	s.updateBreakpoints(bp.file)
+	s.respond(cmd, "", "")
+}
+
+func (s *Session) onBreakpointRemove(cmd *command) {
+	bp, ok := s.breakpoints[cmd.intArg("d", 0)]
+	if !ok {
+		s.respondError(cmd, errorNoSuchBreakpoint, "no such breakpoint")
+		return
+	}
+	delete(s.breakpoints, bp.id)
+	s.updateBreakpoints(bp.file)
+	s.respond(cmd, "", s.breakpointElement(bp))
+}
+
+func (s *Session) onBreakpointList(cmd *command) {
+	ids := make([]int, 0, len(s.breakpoints))
+	for id := range s.breakpoints {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var b strings.Builder
+	for _, id := range ids {
+		b.WriteString(s.breakpointElement(s.breakpoints[id]))
+	}
+	s.respond(cmd, "", b.String())
+}
+
+// updateBreakpoints hands the enabled breakpoints of file to the debugger
+func (s *Session) updateBreakpoints(file string) {
+	var enabled []*vm.Breakpoint
+	for _, bp := range s.breakpoints {
+		if bp.file == file && bp.enabled {
+			enabled = append(enabled, bp.bp)
+		}
+	}
+	s.debugger.SetBreakpoints(file, enabled)
+}
+
+func (s *Session) breakpointElement(bp *breakpoint) string {
+	content := ""
+	if bp.bp.Condition != "" {
+		content = "<expression>" + cdata(bp.bp.Condition) + "</expression>"
+	}
+	return "<breakpoint" + attrs(
+		"id", strconv.Itoa(bp.id),
+		"type", bp.kind,
+		"state", breakpointState(bp),
+		"filename", fileURI(bp.file),
+		"lineno", strconv.Itoa(bp.bp.Line),
+		"hit_count", strconv.Itoa(bp.bp.Hits),
+	) + ">" + content + "</breakpoint>"
+}
+
+func breakpointState(bp *breakpoint) string {
+	if bp.enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+func (s *Session) onStackGet(cmd *command, stop *vm.DebugStop) {
+	if stop == nil {
+		s.respondError(cmd, errorCommandUnavailable, "the script is not running")
+		return
+	}
+	frames := stop.Frames()
+	start, end := 0, len(frames)
+	if _, ok := cmd.args["d"]; ok {
+		start = cmd.intArg("d", 0)
+		if start < 0 || start >= len(frames) {
+			s.respondError(cmd, errorNoSuchStackDepth, "no such stack depth")
+			return
+		}
+		end = start + 1
+	}
+	var b strings.Builder
+	for level := start; level < end; level++ {
+		b.WriteString("<stack" + attrs(
+			"where", frames[level].Name,
+			"level", strconv.Itoa(level),
+			"type", "file",
+			"filename", fileURI(frames[level].File),
+			"lineno", strconv.Itoa(frames[level].Line),
+		) + "></stack>")
+	}
+	s.respond(cmd, "", b.String())
+}
+
+// frame returns the stack depth the -d argument of cmd refers to, and the
+// variables of its context. The superglobals context lists the globals.
+func (s *Session) frame(cmd *command, stop *vm.DebugStop) (int, []vm.DebugVariable, bool) {
+	if stop == nil {
+		s.respondError(cmd, errorCommandUnavailable, "the script is not running")
+		return 0, nil, false
+	}
+	depth := cmd.intArg("d", 0)
+	if depth < 0 || depth >= len(stop.Frames()) {
+		s.respondError(cmd, errorNoSuchStackDepth, "no such stack depth")
+		return 0, nil, false
+	}
+	switch cmd.intArg("c", 0) {
+	case 0:
+		return depth, stop.Locals(depth), true
+	case 1:
+		return len(stop.Frames()) - 1, stop.Globals(), true
+	}
+	s.respondError(cmd, errorNoSuchContext, "no such context")
+	return 0, nil, false
+}
+
+func (s *Session) onContextGet(cmd *command, stop *vm.DebugStop) {
+	_, variables, ok := s.frame(cmd, stop)
+	if !ok {
+		return
+	}
+	var b strings.Builder
+	for _, variable := range variables {
+		b.WriteString(property(variable.Name, variable.Name, variable.Value, s.options, 0, 0))
+	}
+	s.respond(cmd, attrs("context", strconv.Itoa(cmd.intArg("c", 0))), b.String())
+}
+
+func (s *Session) onPropertyGet(cmd *command, stop *vm.DebugStop) {
+	depth, _, ok := s.frame(cmd, stop)
+	if !ok {
+		return
+	}
+	name := cmd.arg("n")
+	value, err := stop.Evaluate(depth, name)
+	if err != nil {
+		s.respondError(cmd, errorPropertyUnavailable, err.Error())
+		return
+	}
+	options := s.options
+	if maxData, ok := cmd.args["m"]; ok {
+		options.maxData, _ = strconv.Atoi(maxData)
+	}
+	s.respond(cmd, "", property(name, name, value, options, 0, cmd.intArg("p", 0)))
+}
+
+func (s *Session) onEval(cmd *command, stop *vm.DebugStop) {
+	if stop == nil {
+		s.respondError(cmd, errorCommandUnavailable, "the script is not running")
+		return
+	}
+	value, err := stop.Evaluate(cmd.intArg("d", 0), cmd.data)
+	if err != nil {
+		s.respondError(cmd, errorEvaluation, err.Error())
+		return
+	}
+	s.respond(cmd, "", property("", "", value, s.options, 0, 0))
+}
+
+func (s *Session) respondStatus(cmd *command) {
+	s.respond(cmd, attrs("status", s.status, "reason", "ok"), "")
+}
+
+func (s *Session) respondError(cmd *command, code int, message string) {
+	s.respond(cmd, "", "<error"+attrs("code", strconv.Itoa(code))+"><message>"+cdata(message)+"</message></error>")
+}
+
+// respond answers cmd with a response element carrying extra attributes
+// and content
+func (s *Session) respond(cmd *command, extra string, content string) {
+	_ = s.send("<response" + attrs(
+		"xmlns", "urn:debugger_protocol_v1",
+		"xmlns:xdebug", "https://xdebug.org/dbgp/xdebug",
+		"command", cmd.name,
+		"transaction_id", cmd.arg("i"),
+	) + extra + ">" + content + "</response>")
+}
+
+func (s *Session) send(document string) error {
+	return writePacket(s.conn, document)
+}
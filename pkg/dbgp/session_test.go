@@ -0,0 +1,281 @@
+package dbgp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/compiler"
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vm"
+	"github.com/wudi/hey/vmfactory"
+)
+
+const testScript = `<?php
+function add($a, $b) {
+    $sum = $a + $b;
+    return $sum;
+}
+$list = [1, 'two' => 2];
+$total = 0;
+for ($i = 1; $i <= 3; $i++) {
+    $total = add($total, $i);
+}
+echo "total=$total\n";
+`
+
+// packet is an init or response packet sent by the engine
+type packet struct {
+	XMLName       xml.Name
+	FileURI       string         `xml:"fileuri,attr"`
+	Language      string         `xml:"language,attr"`
+	Command       string         `xml:"command,attr"`
+	TransactionID string         `xml:"transaction_id,attr"`
+	Status        string         `xml:"status,attr"`
+	State         string         `xml:"state,attr"`
+	ID            string         `xml:"id,attr"`
+	Message       *stopLocation  `xml:"message"`
+	Stack         []stopLocation `xml:"stack"`
+	Properties    []testProperty `xml:"property"`
+	Error         *struct {
+		Code string `xml:"code,attr"`
+	} `xml:"error"`
+}
+
+type stopLocation struct {
+	Where    string `xml:"where,attr"`
+	Filename string `xml:"filename,attr"`
+	Lineno   int    `xml:"lineno,attr"`
+}
+
+type testProperty struct {
+	Name        string         `xml:"name,attr"`
+	Fullname    string         `xml:"fullname,attr"`
+	Type        string         `xml:"type,attr"`
+	NumChildren int            `xml:"numchildren,attr"`
+	Encoding    string         `xml:"encoding,attr"`
+	Value       string         `xml:",chardata"`
+	Children    []testProperty `xml:"property"`
+}
+
+// value decodes the content of a scalar property
+func (p testProperty) value(t *testing.T) string {
+	if p.Encoding != "base64" {
+		return p.Value
+	}
+	decoded, err := base64.StdEncoding.DecodeString(p.Value)
+	require.NoError(t, err)
+	return string(decoded)
+}
+
+func find(t *testing.T, properties []testProperty, name string) testProperty {
+	t.Helper()
+	for _, p := range properties {
+		if p.Name == name {
+			return p
+		}
+	}
+	t.Fatalf("no property %s in %+v", name, properties)
+	return testProperty{}
+}
+
+// testClient plays the IDE the engine connects to
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	txid   int
+}
+
+// debugScript runs testScript with a session connected to a new
+// testClient. The returned channel delivers the script's output and error.
+func debugScript(t *testing.T) (*testClient, string, <-chan error, *bytes.Buffer) {
+	t.Helper()
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime.Bootstrap())
+	require.NoError(t, runtime.InitializeVMIntegration())
+
+	script := filepath.Join(t.TempDir(), "add.php")
+	require.NoError(t, os.WriteFile(script, []byte(testScript), 0644))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	config := Config{Mode: "debug", StartWithRequest: "yes", ClientHost: "127.0.0.1",
+		ClientPort: listener.Addr().(*net.TCPAddr).Port}
+
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return compiler.NewCompiler()
+	})
+	var output bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		compiled, err := factory.CompileFile(script)
+		if err != nil {
+			done <- err
+			return
+		}
+		ctx := vm.NewExecutionContext()
+		ctx.OutputWriter = &output
+		vmachine := factory.CreateVM()
+		session, err := Start(config, factory, vmachine, script)
+		if err != nil {
+			done <- err
+			return
+		}
+		err = vmachine.Execute(ctx, compiled.Instructions, compiled.Constants,
+			compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+		session.Finish()
+		done <- err
+	}()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}, script, done, &output
+}
+
+// read reads the next packet
+func (c *testClient) read() *packet {
+	c.t.Helper()
+	length, err := c.reader.ReadString(0)
+	require.NoError(c.t, err)
+	size, err := strconv.Atoi(strings.TrimSuffix(length, "\x00"))
+	require.NoError(c.t, err)
+	data := make([]byte, size+1)
+	_, err = io.ReadFull(c.reader, data)
+	require.NoError(c.t, err)
+
+	decoder := xml.NewDecoder(bytes.NewReader(data[:size]))
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	var p packet
+	require.NoError(c.t, decoder.Decode(&p))
+	return &p
+}
+
+// send sends a command and returns its response
+func (c *testClient) send(format string, args ...interface{}) *packet {
+	c.t.Helper()
+	c.txid++
+	name, rest, _ := strings.Cut(fmt.Sprintf(format, args...), " ")
+	line := fmt.Sprintf("%s -i %d %s", name, c.txid, rest)
+	_, err := c.conn.Write([]byte(strings.TrimSpace(line) + "\x00"))
+	require.NoError(c.t, err)
+
+	p := c.read()
+	require.Equal(c.t, name, p.Command)
+	require.Equal(c.t, strconv.Itoa(c.txid), p.TransactionID)
+	return p
+}
+
+func encode(data string) string {
+	return base64.StdEncoding.EncodeToString([]byte(data))
+}
+
+func TestDebugSession(t *testing.T) {
+	c, script, done, output := debugScript(t)
+
+	init := c.read()
+	require.Equal(t, "init", init.XMLName.Local)
+	require.Equal(t, fileURI(script), init.FileURI)
+	require.Equal(t, "PHP", init.Language)
+
+	c.send("feature_set -n max_children -v 10")
+	bp := c.send("breakpoint_set -t conditional -f %s -n 3 -- %s", fileURI(script), encode("$a == 3"))
+	require.Equal(t, []string{"enabled", "1"}, []string{bp.State, bp.ID})
+	bp = c.send("breakpoint_set -t line -f %s -n 11", fileURI(script))
+	require.Equal(t, "2", bp.ID)
+	require.Equal(t, "201", c.send("breakpoint_set -t exception -x Exception").Error.Code)
+
+	// The condition holds on the third call only
+	brk := c.send("run")
+	require.Equal(t, "break", brk.Status)
+	require.Equal(t, 3, brk.Message.Lineno)
+	require.Equal(t, fileURI(script), brk.Message.Filename)
+
+	stack := c.send("stack_get").Stack
+	require.Len(t, stack, 2)
+	require.Equal(t, stopLocation{"add", fileURI(script), 3}, stack[0])
+	require.Equal(t, stopLocation{"{main}", fileURI(script), 9}, stack[1])
+
+	locals := c.send("context_get -d 0 -c 0").Properties
+	require.Equal(t, "3", find(t, locals, "$a").value(t))
+	require.Equal(t, "int", find(t, locals, "$b").Type)
+
+	list := find(t, c.send("context_get -d 1 -c 0").Properties, "$list")
+	require.Equal(t, []interface{}{"array", 2}, []interface{}{list.Type, list.NumChildren})
+	require.Equal(t, "$list['two']", find(t, list.Children, "two").Fullname)
+	require.Equal(t, "$list[0]", find(t, list.Children, "0").Fullname)
+
+	two := c.send("property_get -d 1 -n \"$list['two']\"").Properties
+	require.Equal(t, "2", two[0].value(t))
+	require.Equal(t, "6", c.send("eval -- %s", encode("$a + $b")).Properties[0].value(t))
+	require.Equal(t, "300", c.send("property_get -n $missing->x").Error.Code)
+
+	brk = c.send("step_over")
+	require.Equal(t, 4, brk.Message.Lineno)
+
+	brk = c.send("run")
+	require.Equal(t, 11, brk.Message.Lineno)
+	require.Equal(t, "6", find(t, c.send("context_get -c 1").Properties, "$total").value(t))
+
+	require.Equal(t, "stopping", c.send("run").Status)
+	require.Equal(t, "stopped", c.send("stop").Status)
+	require.NoError(t, <-done)
+	require.Equal(t, "total=6\n", output.String())
+}
+
+func TestDebugStepping(t *testing.T) {
+	c, _, done, output := debugScript(t)
+	c.read()
+
+	// Stepping into the script stops at its first line
+	require.Equal(t, 6, c.send("step_into").Message.Lineno)
+	require.Equal(t, 7, c.send("step_over").Message.Lineno)
+	require.Equal(t, 8, c.send("step_over").Message.Lineno)
+	require.Equal(t, 9, c.send("step_over").Message.Lineno)
+	brk := c.send("step_into")
+	require.Equal(t, 3, brk.Message.Lineno)
+	require.Equal(t, "add", c.send("stack_get -d 0").Stack[0].Where)
+	c.send("step_out")
+	require.Equal(t, "{main}", c.send("stack_get -d 0").Stack[0].Where)
+
+	// Stopping ends the script
+	require.Equal(t, "stopped", c.send("stop").Status)
+	require.ErrorIs(t, <-done, vm.ErrDebuggerTerminated)
+	require.Empty(t, output.String())
+}
+
+func TestShouldStart(t *testing.T) {
+	tests := []struct {
+		mode, start string
+		triggered   bool
+		want        bool
+	}{
+		{"develop", "yes", true, false},
+		{"develop,debug", "yes", false, true},
+		{"debug", "no", true, false},
+		{"debug", "trigger", false, false},
+		{"debug", "trigger", true, true},
+		{"debug", "default", true, true},
+	}
+	for _, tt := range tests {
+		config := Config{Mode: tt.mode, StartWithRequest: tt.start}
+		require.Equal(t, tt.want, config.ShouldStart(tt.triggered), "%+v", tt)
+	}
+}
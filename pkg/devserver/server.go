@@ -0,0 +1,359 @@
+// Package devserver implements the built-in development web server started
+// by `hey -S`. Like PHP's, it serves static files from a document root, runs
+// PHP scripts in a fresh execution context per request and can route every
+// request through a router script.
+package devserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wudi/hey/pkg/fpm/handler"
+	"github.com/wudi/hey/values"
+	"github.com/wudi/hey/version"
+	"github.com/wudi/hey/vm"
+	"github.com/wudi/hey/vmfactory"
+)
+
+// indexFiles are tried in order when a request maps to a directory
+var indexFiles = []string{"index.php", "index.html"}
+
+// Config configures a development server.
+type Config struct {
+	// Addr is the host:port to listen on
+	Addr string
+	// DocRoot is the directory files are served from; defaults to the
+	// working directory
+	DocRoot string
+	// Router is an optional script run for every request. When it returns
+	// false the request is served as if there were no router.
+	Router string
+	// AccessLog receives one line per request; nil disables logging
+	AccessLog io.Writer
+}
+
+// Server is the development web server. It implements http.Handler.
+type Server struct {
+	config    Config
+	vmFactory *vmfactory.VMFactory
+	logMu     sync.Mutex
+}
+
+// NewServer creates a server that compiles scripts with vmFactory.
+func NewServer(config Config, vmFactory *vmfactory.VMFactory) (*Server, error) {
+	if config.DocRoot == "" {
+		config.DocRoot = "."
+	}
+	docRoot, err := filepath.Abs(config.DocRoot)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(docRoot); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("directory %s does not exist", config.DocRoot)
+	}
+	config.DocRoot = docRoot
+
+	if config.Router != "" {
+		router, err := filepath.Abs(config.Router)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(router); err != nil {
+			return nil, fmt.Errorf("cannot open router script %s", config.Router)
+		}
+		config.Router = router
+	}
+
+	return &Server{config: config, vmFactory: vmFactory}, nil
+}
+
+// ListenAndServe listens on the configured address and serves requests
+// until the listener fails.
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.Addr, err)
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on listener, handling each request in its own
+// goroutine.
+func (s *Server) Serve(listener net.Listener) error {
+	s.logf("Hey %s Development Server (http://%s) started", version.FullVersion(), listener.Addr())
+	s.logf("Document root is %s", s.config.DocRoot)
+	s.logf("Press Ctrl-C to quit.")
+	return http.Serve(listener, s)
+}
+
+// resolved describes the file a request URI maps to
+type resolved struct {
+	file       string // absolute path, empty when nothing matched
+	scriptName string // URI path of file
+	pathInfo   string // trailing URI path after scriptName
+}
+
+// resolve maps a URI path onto the document root. A path that names a
+// directory is served by its index file. When nothing matches, parent
+// directories are searched for an index file and the unmatched part becomes
+// PATH_INFO, except for paths with a file extension, which are not found.
+func (s *Server) resolve(uriPath string) resolved {
+	clean := path.Clean("/" + uriPath)
+	for dir, rest := clean, ""; ; {
+		file := filepath.Join(s.config.DocRoot, filepath.FromSlash(dir))
+		if info, err := os.Stat(file); err == nil {
+			if !info.IsDir() {
+				return resolved{file: file, scriptName: dir, pathInfo: rest}
+			}
+			for _, index := range indexFiles {
+				indexFile := filepath.Join(file, index)
+				if info, err := os.Stat(indexFile); err == nil && !info.IsDir() {
+					return resolved{file: indexFile, scriptName: path.Join(dir, index), pathInfo: rest}
+				}
+			}
+		} else if rest == "" && path.Ext(dir) != "" {
+			return resolved{}
+		}
+		if dir == "/" {
+			return resolved{}
+		}
+		rest = path.Join("/", path.Base(dir), rest)
+		if rest == "/" {
+			rest = ""
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// ServeHTTP runs the router or the requested script, or serves a static
+// file.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	s.serve(rec, r)
+	s.logf("%s [%d]: %s %s", r.RemoteAddr, rec.status, r.Method, r.URL.RequestURI())
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	target := s.resolve(r.URL.Path)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if s.config.Router != "" {
+		routed := target
+		if routed.file == "" {
+			routed = resolved{file: s.config.Router, scriptName: r.URL.Path}
+		}
+		if handled := s.runScript(w, r, body, s.config.Router, routed); handled {
+			return
+		}
+	}
+
+	switch {
+	case target.file == "":
+		s.notFound(w, r)
+	case strings.EqualFold(filepath.Ext(target.file), ".php"):
+		s.runScript(w, r, body, target.file, target)
+	default:
+		s.serveStatic(w, r, target.file)
+	}
+}
+
+// serveStatic sends a file with the MIME type of its extension.
+func (s *Server) serveStatic(w http.ResponseWriter, r *http.Request, file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		s.notFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		s.notFound(w, r)
+		return
+	}
+	// ServeContent picks the Content-Type from the file name
+	http.ServeContent(w, r, file, info.ModTime(), f)
+}
+
+func (s *Server) notFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, "<!doctype html><html><head><title>404 Not Found</title></head>"+
+		"<body><h1>Not Found</h1><p>The requested resource <code>%s</code> was not found on this server.</p></body></html>",
+		htmlEscape(r.URL.Path))
+}
+
+// runScript executes script for the request and writes its response. It
+// returns false without writing anything when the script is a router that
+// returned false.
+func (s *Server) runScript(w http.ResponseWriter, r *http.Request, body []byte, script string, target resolved) bool {
+	compiled, err := s.vmFactory.CompileFile(script)
+	if err != nil {
+		var parseErr *vmfactory.ParseError
+		if errors.As(err, &parseErr) {
+			s.serverError(w, strings.Join(parseErr.Errors, "\n"))
+		} else {
+			s.serverError(w, fmt.Sprintf("Compilation error: %v", err))
+		}
+		return true
+	}
+
+	vmCtx := vm.NewExecutionContext()
+	var outBuf bytes.Buffer
+	vmCtx.OutputWriter = &outBuf
+
+	handler.SetupRequest(vmCtx, s.cgiParams(r, target), body)
+	defer handler.CleanupRequest(vmCtx)
+
+	vmachine := s.vmFactory.CreateVM()
+	err = vmachine.Execute(vmCtx, compiled.Instructions, compiled.Constants,
+		compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+
+	if err == nil && script == s.config.Router && routerDeclined(vmCtx) {
+		return false
+	}
+
+	vmachine.CallAllDestructors(vmCtx)
+
+	if err != nil {
+		s.logf("%s PHP Fatal error:  %v", r.RemoteAddr, err)
+		fmt.Fprintf(&outBuf, "\nFatal error: %v", err)
+	}
+
+	s.writeResponse(w, vmCtx.HTTPContext, outBuf.Bytes(), err != nil)
+	return true
+}
+
+// routerDeclined reports whether the router script ended with `return false`
+func routerDeclined(vmCtx *vm.ExecutionContext) bool {
+	if len(vmCtx.Stack) == 0 {
+		return false
+	}
+	result := vmCtx.Stack[len(vmCtx.Stack)-1]
+	return result != nil && result.Type == values.TypeBool && !result.ToBool()
+}
+
+// writeResponse sends the headers and status set by the script, then its
+// output.
+func (s *Server) writeResponse(w http.ResponseWriter, httpCtx *vm.HTTPContext, output []byte, failed bool) {
+	header := w.Header()
+	for _, h := range httpCtx.GetHeaders() {
+		header.Add(h.Name, h.Value)
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "text/html; charset=UTF-8")
+	}
+
+	status := httpCtx.GetResponseCode()
+	switch {
+	case failed && status == http.StatusOK:
+		status = http.StatusInternalServerError
+	case header.Get("Location") != "" && status == http.StatusOK:
+		// PHP turns a bare Location header into a redirect
+		status = http.StatusFound
+	}
+	header.Set("Content-Length", strconv.Itoa(len(output)))
+	w.WriteHeader(status)
+	w.Write(output)
+}
+
+func (s *Server) serverError(w http.ResponseWriter, msg string) {
+	s.logf("PHP Parse error:  %s", msg)
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	io.WriteString(w, msg)
+}
+
+// cgiParams builds the CGI environment PHP's built-in server exposes in
+// $_SERVER.
+func (s *Server) cgiParams(r *http.Request, target resolved) map[string]string {
+	now := time.Now()
+
+	params := map[string]string{
+		"DOCUMENT_ROOT":      s.config.DocRoot,
+		"SERVER_SOFTWARE":    "Hey " + version.FullVersion() + " Development Server",
+		"SERVER_PROTOCOL":    r.Proto,
+		"REQUEST_METHOD":     r.Method,
+		"REQUEST_URI":        r.URL.RequestURI(),
+		"QUERY_STRING":       r.URL.RawQuery,
+		"SCRIPT_FILENAME":    target.file,
+		"SCRIPT_NAME":        target.scriptName,
+		"PHP_SELF":           target.scriptName + target.pathInfo,
+		"REQUEST_TIME":       strconv.FormatInt(now.Unix(), 10),
+		"REQUEST_TIME_FLOAT": strconv.FormatFloat(float64(now.UnixMicro())/1e6, 'f', 6, 64),
+	}
+	if target.pathInfo != "" {
+		params["PATH_INFO"] = target.pathInfo
+	}
+
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		params["REMOTE_ADDR"] = host
+		params["REMOTE_PORT"] = port
+	}
+	serverName, serverPort := r.Host, ""
+	if host, port, err := net.SplitHostPort(r.Host); err == nil {
+		serverName, serverPort = host, port
+	} else if _, port, err := net.SplitHostPort(s.config.Addr); err == nil {
+		serverPort = port
+	}
+	params["SERVER_NAME"] = serverName
+	params["SERVER_PORT"] = serverPort
+
+	for name, vals := range r.Header {
+		key := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		value := strings.Join(vals, ", ")
+		switch key {
+		case "CONTENT_TYPE", "CONTENT_LENGTH":
+			params[key] = value
+		default:
+			params["HTTP_"+key] = value
+		}
+	}
+	if r.Host != "" {
+		params["HTTP_HOST"] = r.Host
+	}
+	if r.ContentLength > 0 {
+		params["CONTENT_LENGTH"] = strconv.FormatInt(r.ContentLength, 10)
+	}
+
+	return params
+}
+
+// logf writes an access log line prefixed with the time, as PHP's server does
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.config.AccessLog == nil {
+		return
+	}
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	fmt.Fprintf(s.config.AccessLog, "[%s] %s\n", time.Now().Format("Mon Jan _2 15:04:05 2006"), fmt.Sprintf(format, args...))
+}
+
+// statusRecorder remembers the status code written for the access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func htmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
@@ -0,0 +1,213 @@
+package devserver
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/compiler"
+	"github.com/wudi/hey/pkg/fpm/opcache"
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vmfactory"
+)
+
+func newTestServer(t *testing.T, files map[string]string, router string) (*httptest.Server, *bytes.Buffer) {
+	t.Helper()
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime.Bootstrap())
+	require.NoError(t, runtime.InitializeVMIntegration())
+
+	docRoot := t.TempDir()
+	for name, content := range files {
+		file := filepath.Join(docRoot, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0755))
+		require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	}
+	if router != "" {
+		router = filepath.Join(docRoot, router)
+	}
+
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return compiler.NewCompiler()
+	})
+	// The same cache as `hey -S`, so requests share compiled scripts
+	factory.SetOpcache(opcache.NewOpcacheManager(&opcache.OpcacheConfig{
+		Enabled:            true,
+		ValidateTimestamps: true,
+	}))
+	var log bytes.Buffer
+	server, err := NewServer(Config{DocRoot: docRoot, Router: router, AccessLog: &log}, factory)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return ts, &log
+}
+
+func get(t *testing.T, rawURL string) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.Get(rawURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestServeScriptsAndStaticFiles(t *testing.T) {
+	ts, log := newTestServer(t, map[string]string{
+		"index.php":       `<?php echo "index ", $_SERVER['SCRIPT_NAME'], " ", $_SERVER['PATH_INFO'] ?? "-", " ", $_GET['q'] ?? "";`,
+		"style.css":       "body {}",
+		"blog/index.html": "<h1>blog</h1>",
+		"status.php": `<?php
+header("X-Custom: yes");
+http_response_code(201);
+echo $_SERVER['REQUEST_METHOD'], " ", $_POST['name'], " ", $_COOKIE['session'];`,
+		"redirect.php": `<?php header("Location: /elsewhere");`,
+		"broken.php":   `<?php echo "unterminated`,
+	}, "")
+
+	resp, body := get(t, ts.URL+"/?q=1")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/html; charset=UTF-8", resp.Header.Get("Content-Type"))
+	require.Equal(t, "index /index.php - 1", body)
+
+	_, body = get(t, ts.URL+"/posts/42")
+	require.Equal(t, "index /index.php /posts/42 ", body)
+
+	resp, body = get(t, ts.URL+"/style.css")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, resp.Header.Get("Content-Type"), "text/css")
+	require.Equal(t, "body {}", body)
+
+	_, body = get(t, ts.URL+"/blog/")
+	require.Equal(t, "<h1>blog</h1>", body)
+
+	resp, _ = get(t, ts.URL+"/missing.js")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/status.php", strings.NewReader(url.Values{"name": {"hey"}}.Encode()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	posted, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "yes", resp.Header.Get("X-Custom"))
+	require.Equal(t, "POST hey abc", string(posted))
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err = client.Get(ts.URL + "/redirect.php")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	require.Equal(t, "/elsewhere", resp.Header.Get("Location"))
+
+	resp, _ = get(t, ts.URL+"/broken.php")
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	require.Contains(t, log.String(), "[200]: GET /?q=1")
+	require.Contains(t, log.String(), "[201]: POST /status.php")
+	require.Contains(t, log.String(), "[404]: GET /missing.js")
+}
+
+func TestRouterScript(t *testing.T) {
+	ts, _ := newTestServer(t, map[string]string{
+		"router.php": `<?php
+if ($_SERVER['REQUEST_URI'] === '/static.txt') {
+    return false;
+}
+echo "routed ", $_SERVER['REQUEST_URI'];`,
+		"static.txt": "plain file",
+	}, "router.php")
+
+	_, body := get(t, ts.URL+"/anything?x=1")
+	require.Equal(t, "routed /anything?x=1", body)
+
+	resp, body := get(t, ts.URL+"/static.txt")
+	require.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	require.Equal(t, "plain file", body)
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	ts, _ := newTestServer(t, map[string]string{
+		"echo.php": `<?php $id = $_GET['id']; for ($i = 0; $i < 100; $i++) {} echo $id;`,
+	}, "")
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 16)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := http.Get(ts.URL + "/echo.php?id=" + string(rune('a'+i)))
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(body)
+		}(i)
+	}
+	wg.Wait()
+	for i, body := range bodies {
+		require.Equal(t, string(rune('a'+i)), body)
+	}
+}
+
+func TestConcurrentRequestsAreIsolated(t *testing.T) {
+	ts, _ := newTestServer(t, map[string]string{
+		"reg.php": `<?php
+class Counter { public static $n = 0; public $items = []; }
+spl_autoload_register(function ($class) { echo "leaked "; });
+Counter::$n++;
+$c = new Counter();
+$c->items[] = $_GET['id'];
+go(function () use ($c) { return count($c->items); });
+echo Counter::$n, ' ', count(spl_autoload_functions()), ' ', $c->items[0];`,
+		"chk.php": `<?php echo count(spl_autoload_functions()), ' ', class_exists('Missing') ? 'yes' : 'no';`,
+	}, "")
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 100)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			script := "/reg.php?id=" + strconv.Itoa(i)
+			if i%2 == 1 {
+				script = "/chk.php"
+			}
+			resp, err := http.Get(ts.URL + script)
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(body)
+		}(i)
+	}
+	wg.Wait()
+	for i, body := range bodies {
+		if i%2 == 1 {
+			require.Equal(t, "0 no", body)
+		} else {
+			require.Equal(t, "1 1 "+strconv.Itoa(i), body)
+		}
+	}
+}
@@ -1,14 +1,32 @@
 package handler
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 
+	"github.com/wudi/hey/runtime"
 	"github.com/wudi/hey/values"
 	"github.com/wudi/hey/vm"
 )
 
+// Upload error codes reported in $_FILES[...]['error']
+const (
+	uploadErrOK        = 0
+	uploadErrIniSize   = 1
+	uploadErrFormSize  = 2
+	uploadErrPartial   = 3
+	uploadErrNoFile    = 4
+	uploadErrNoTmpDir  = 6
+	uploadErrCantWrite = 7
+)
+
 func SetupCGIVariables(vmCtx *vm.ExecutionContext, params map[string]string, stdin []byte) {
 	server := values.NewArray()
 	for k, v := range params {
@@ -30,12 +48,19 @@ func SetupCGIVariables(vmCtx *vm.ExecutionContext, params map[string]string, std
 		vmCtx.GlobalVars.Store("$_GET", values.NewArray())
 	}
 
+	post, files := values.NewArray(), values.NewArray()
 	if method, ok := params["REQUEST_METHOD"]; ok && (method == "POST" || method == "PUT" || method == "PATCH") {
 		contentType := params["CONTENT_TYPE"]
-		vmCtx.GlobalVars.Store("$_POST", parsePostData(stdin, contentType))
-	} else {
-		vmCtx.GlobalVars.Store("$_POST", values.NewArray())
+		// Bodies over post_max_size are discarded, leaving $_POST and
+		// $_FILES empty as PHP does
+		maxSize, _ := runtime.GetIniValue("post_max_size")
+		if limit := runtime.ParseIniQuantity(maxSize); limit <= 0 || int64(len(stdin)) <= limit {
+			post, files = parsePostData(vmCtx, stdin, contentType)
+		}
+	} else if len(stdin) > 0 {
+		vmCtx.HTTPContext.SetRequestBody(stdin)
 	}
+	vmCtx.GlobalVars.Store("$_POST", post)
 
 	if cookie, ok := params["HTTP_COOKIE"]; ok && cookie != "" {
 		vmCtx.GlobalVars.Store("$_COOKIE", parseCookies(cookie))
@@ -47,7 +72,7 @@ func SetupCGIVariables(vmCtx *vm.ExecutionContext, params map[string]string, std
 	if get, ok := vmCtx.GlobalVars.Load("$_GET"); ok {
 		if getArr, ok := get.(*values.Value); ok && getArr.IsArray() {
 			arr := getArr.Data.(*values.Array)
-			for k, v := range arr.Elements {
+			for k, v := range arr.All() {
 				requestArr.ArraySet(convertToValue(k), v)
 			}
 		}
@@ -55,65 +80,313 @@ func SetupCGIVariables(vmCtx *vm.ExecutionContext, params map[string]string, std
 	if post, ok := vmCtx.GlobalVars.Load("$_POST"); ok {
 		if postArr, ok := post.(*values.Value); ok && postArr.IsArray() {
 			arr := postArr.Data.(*values.Array)
-			for k, v := range arr.Elements {
+			for k, v := range arr.All() {
 				requestArr.ArraySet(convertToValue(k), v)
 			}
 		}
 	}
 	vmCtx.GlobalVars.Store("$_REQUEST", requestArr)
 
-	vmCtx.GlobalVars.Store("$_FILES", values.NewArray())
+	vmCtx.GlobalVars.Store("$_FILES", files)
 
 	vmCtx.GlobalVars.Store("$_ENV", values.NewArray())
 }
 
+// CleanupRequest deletes the uploaded files the script did not move away
+func CleanupRequest(vmCtx *vm.ExecutionContext) {
+	if vmCtx.HTTPContext == nil {
+		return
+	}
+	for _, path := range vmCtx.HTTPContext.GetUploadedFiles() {
+		os.Remove(path)
+		vmCtx.HTTPContext.RemoveUploadedFile(path)
+	}
+}
+
 func parseQueryString(qs string) *values.Value {
 	arr := values.NewArray()
-	parsed, err := url.ParseQuery(qs)
-	if err != nil {
-		return arr
+	parseURLEncoded(arr, qs)
+	return arr
+}
+
+// parseURLEncoded registers the pairs of an application/x-www-form-urlencoded
+// string in arr, in order, so later values replace earlier ones
+func parseURLEncoded(arr *values.Value, data string) {
+	for _, pair := range strings.Split(data, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		registerVariable(arr, urlDecode(name), values.NewString(urlDecode(value)))
 	}
+}
 
-	for k, vals := range parsed {
-		if len(vals) == 1 {
-			arr.ArraySet(values.NewString(k), values.NewString(vals[0]))
-		} else {
-			subArr := values.NewArray()
-			for _, v := range vals {
-				subArr.ArraySet(nil, values.NewString(v))
-			}
-			arr.ArraySet(values.NewString(k), subArr)
+// urlDecode decodes like PHP's urldecode, keeping malformed escapes as-is
+func urlDecode(s string) string {
+	if decoded, err := url.QueryUnescape(s); err == nil {
+		return decoded
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '+':
+			sb.WriteByte(' ')
+		case s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b, _ := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			sb.WriteByte(byte(b))
+			i += 2
+		default:
+			sb.WriteByte(s[i])
 		}
 	}
+	return sb.String()
+}
 
-	return arr
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
 }
 
-func parsePostData(data []byte, contentType string) *values.Value {
-	arr := values.NewArray()
+// registerVariable stores value in track under a request variable name,
+// expanding a[b][] style names into nested arrays the way PHP does: spaces
+// and dots in the base name become underscores, an empty index appends, and
+// anything after the last closing bracket is ignored.
+func registerVariable(track *values.Value, name string, value *values.Value) {
+	name = strings.TrimLeft(name, " ")
+
+	base, rest := name, ""
+	if idx := strings.IndexByte(name, '['); idx >= 0 {
+		base, rest = name[:idx], name[idx:]
+	}
+	base = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '.' {
+			return '_'
+		}
+		return r
+	}, base)
+	if base == "" {
+		return
+	}
 
-	if !strings.Contains(contentType, "application/x-www-form-urlencoded") {
-		return arr
+	// A nil key appends to the array
+	keys := []*values.Value{values.NewString(base)}
+	for first := true; strings.HasPrefix(rest, "["); first = false {
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			if first {
+				// An unterminated bracket is part of the name
+				keys[0] = values.NewString(base + "_" + rest[1:])
+			}
+			break
+		}
+		if index := rest[1:end]; index == "" {
+			keys = append(keys, nil)
+		} else {
+			keys = append(keys, values.NewString(index))
+		}
+		rest = rest[end+1:]
 	}
 
-	parsed, err := url.ParseQuery(string(data))
+	current := track
+	for _, key := range keys[:len(keys)-1] {
+		var next *values.Value
+		if key != nil {
+			if existing := current.ArrayGet(key); existing.IsArray() {
+				next = existing
+			}
+		}
+		if next == nil {
+			next = values.NewArray()
+			current.ArraySet(key, next)
+		}
+		current = next
+	}
+	current.ArraySet(keys[len(keys)-1], value)
+}
+
+// parsePostData fills $_POST and $_FILES from a request body. Bodies that
+// are not form data are only available through php://input, as are
+// urlencoded ones; multipart bodies are not kept.
+func parsePostData(vmCtx *vm.ExecutionContext, data []byte, contentType string) (*values.Value, *values.Value) {
+	post, files := values.NewArray(), values.NewArray()
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		return arr
+		vmCtx.HTTPContext.SetRequestBody(data)
+		return post, files
 	}
 
-	for k, vals := range parsed {
-		if len(vals) == 1 {
-			arr.ArraySet(values.NewString(k), values.NewString(vals[0]))
-		} else {
-			subArr := values.NewArray()
-			for _, v := range vals {
-				subArr.ArraySet(nil, values.NewString(v))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		vmCtx.HTTPContext.SetRequestBody(data)
+		parseURLEncoded(post, string(data))
+	case "multipart/form-data":
+		if boundary := params["boundary"]; boundary != "" {
+			parseMultipart(vmCtx, data, boundary, post, files)
+		}
+	default:
+		vmCtx.HTTPContext.SetRequestBody(data)
+	}
+
+	return post, files
+}
+
+// uploadLimits are the ini settings that apply to file uploads
+type uploadLimits struct {
+	enabled    bool
+	maxSize    int64
+	maxUploads int
+	tmpDir     string
+}
+
+func currentUploadLimits() uploadLimits {
+	limits := uploadLimits{enabled: true, maxUploads: 20, tmpDir: os.TempDir()}
+	if v, ok := runtime.GetIniValue("file_uploads"); ok {
+		limits.enabled = v != "" && v != "0" && !strings.EqualFold(v, "off")
+	}
+	if v, ok := runtime.GetIniValue("upload_max_filesize"); ok {
+		limits.maxSize = runtime.ParseIniQuantity(v)
+	}
+	if v, ok := runtime.GetIniValue("max_file_uploads"); ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			limits.maxUploads = n
+		}
+	}
+	if v, ok := runtime.GetIniValue("upload_tmp_dir"); ok && v != "" {
+		limits.tmpDir = v
+	}
+	return limits
+}
+
+// parseMultipart reads a multipart/form-data body into post and files,
+// saving uploads to temporary files that are deleted when the request
+// ends unless moved with move_uploaded_file
+func parseMultipart(vmCtx *vm.ExecutionContext, data []byte, boundary string, post, files *values.Value) {
+	limits := currentUploadLimits()
+	reader := multipart.NewReader(bytes.NewReader(data), boundary)
+
+	// MAX_FILE_SIZE form fields limit the uploads that follow them
+	var formMaxSize int64
+	uploads := 0
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return
+		}
+
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+
+		_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		filename, isFile := params["filename"]
+		if !isFile {
+			value, _ := io.ReadAll(part)
+			if name == "MAX_FILE_SIZE" {
+				formMaxSize, _ = strconv.ParseInt(strings.TrimSpace(string(value)), 10, 64)
+			}
+			registerVariable(post, name, values.NewString(string(value)))
+			part.Close()
+			continue
+		}
+
+		if !limits.enabled {
+			part.Close()
+			continue
+		}
+		if filename != "" {
+			if uploads >= limits.maxUploads {
+				part.Close()
+				continue
 			}
-			arr.ArraySet(values.NewString(k), subArr)
+			uploads++
+		}
+
+		upload := receiveUpload(vmCtx, part, filename, limits, formMaxSize)
+		part.Close()
+
+		base, suffix := name, ""
+		if idx := strings.IndexByte(name, '['); idx >= 0 {
+			base, suffix = name[:idx], name[idx:]
+		}
+		for _, field := range []struct {
+			key   string
+			value *values.Value
+		}{
+			{"name", values.NewString(upload.name)},
+			{"type", values.NewString(upload.contentType)},
+			{"tmp_name", values.NewString(upload.tmpName)},
+			{"error", values.NewInt(int64(upload.errorCode))},
+			{"size", values.NewInt(upload.size)},
+		} {
+			registerVariable(files, fmt.Sprintf("%s[%s]%s", base, field.key, suffix), field.value)
 		}
 	}
+}
 
-	return arr
+// uploadedFile describes one entry of $_FILES
+type uploadedFile struct {
+	name        string
+	contentType string
+	tmpName     string
+	errorCode   int
+	size        int64
+}
+
+func receiveUpload(vmCtx *vm.ExecutionContext, part *multipart.Part, filename string, limits uploadLimits, formMaxSize int64) uploadedFile {
+	// Browsers may send a full client path; only its last element is kept
+	if idx := strings.LastIndexAny(filename, `/\`); idx >= 0 {
+		filename = filename[idx+1:]
+	}
+	upload := uploadedFile{name: filename, contentType: part.Header.Get("Content-Type")}
+	if filename == "" {
+		upload.contentType = ""
+		upload.errorCode = uploadErrNoFile
+		return upload
+	}
+
+	if info, err := os.Stat(limits.tmpDir); err != nil || !info.IsDir() {
+		upload.errorCode = uploadErrNoTmpDir
+		return upload
+	}
+	tmp, err := os.CreateTemp(limits.tmpDir, "php")
+	if err != nil {
+		upload.errorCode = uploadErrCantWrite
+		return upload
+	}
+
+	limit := limits.maxSize
+	if formMaxSize > 0 && (limit <= 0 || formMaxSize < limit) {
+		limit = formMaxSize
+	}
+	var src io.Reader = part
+	if limit > 0 {
+		src = io.LimitReader(part, limit+1)
+	}
+	size, copyErr := io.Copy(tmp, src)
+	closeErr := tmp.Close()
+
+	switch {
+	case copyErr != nil:
+		upload.errorCode = uploadErrPartial
+	case closeErr != nil:
+		upload.errorCode = uploadErrCantWrite
+	case limits.maxSize > 0 && size > limits.maxSize:
+		upload.errorCode = uploadErrIniSize
+	case formMaxSize > 0 && size > formMaxSize:
+		upload.errorCode = uploadErrFormSize
+	}
+	if upload.errorCode != uploadErrOK {
+		os.Remove(tmp.Name())
+		return upload
+	}
+
+	vmCtx.HTTPContext.AddUploadedFile(tmp.Name())
+	upload.tmpName = tmp.Name()
+	upload.size = size
+	return upload
 }
 
 func parseCookies(cookieHeader string) *values.Value {
@@ -146,4 +419,4 @@ func convertToValue(key interface{}) *values.Value {
 	default:
 		return values.NewString(fmt.Sprintf("%v", k))
 	}
-}
\ No newline at end of file
+}
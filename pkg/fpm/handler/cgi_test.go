@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/compiler"
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/values"
+	"github.com/wudi/hey/vm"
+	"github.com/wudi/hey/vmfactory"
+)
+
+// dump renders a value compactly with sorted keys, e.g. {a:[0:x]}
+func dump(v *values.Value) string {
+	if !v.IsArray() {
+		return v.ToString()
+	}
+	arr := v.Data.(*values.Array)
+	keys := make([]string, 0, arr.Len())
+	byKey := make(map[string]*values.Value)
+	for k, elem := range arr.All() {
+		key := fmt.Sprint(k)
+		keys = append(keys, key)
+		byKey[key] = elem
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + ":" + dump(byKey[key])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func global(t *testing.T, vmCtx *vm.ExecutionContext, name string) *values.Value {
+	t.Helper()
+	v, ok := vmCtx.GlobalVars.Load(name)
+	require.True(t, ok, name)
+	return v.(*values.Value)
+}
+
+// setIni changes an ini setting for the duration of a test
+func setIni(t *testing.T, name, value string) {
+	t.Helper()
+	old, ok := runtime.GetIniValue(name)
+	require.True(t, ok, name)
+	runtime.SetIniValue(name, value)
+	t.Cleanup(func() { runtime.SetIniValue(name, old) })
+}
+
+func TestRegisterVariable(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"a=1&b=2", "{a:1,b:2}"},
+		{"a=1&a=2", "{a:2}"},
+		{"a[]=1&a[]=2", "{a:{0:1,1:2}}"},
+		{"a[b][c]=1&a[b][d]=2&a[e]=3", "{a:{b:{c:1,d:2},e:3}}"},
+		{"a[b][]=1&a[b][]=2", "{a:{b:{0:1,1:2}}}"},
+		{"a=x&a[b]=y", "{a:{b:y}}"},
+		{"first.name=x&last%20name=y", "{first_name:x,last_name:y}"},
+		{"a.b[c.d]=1", "{a_b:{c.d:1}}"},
+		{"a[b=1", "{a_b:1}"},
+		{"a[b]c=1&d[e][f=2", "{a:{b:1},d:{e:2}}"},
+		{"[x]=1&=2&%20a=3", "{a:3}"},
+		{"q=a+b%2Bc&bad=%zz%41", "{bad:%zzA,q:a b+c}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			require.Equal(t, tt.expected, dump(parseQueryString(tt.query)))
+		})
+	}
+}
+
+type formFile struct {
+	field, filename, contentType, content string
+}
+
+func multipartBody(t *testing.T, fields [][2]string, files []formFile) ([]byte, string) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, field := range fields {
+		require.NoError(t, w.WriteField(field[0], field[1]))
+	}
+	for _, file := range files {
+		header := make(map[string][]string)
+		header["Content-Disposition"] = []string{fmt.Sprintf(`form-data; name="%s"; filename="%s"`, file.field, file.filename)}
+		if file.contentType != "" {
+			header["Content-Type"] = []string{file.contentType}
+		}
+		part, err := w.CreatePart(header)
+		require.NoError(t, err)
+		_, err = part.Write([]byte(file.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return body.Bytes(), w.FormDataContentType()
+}
+
+func postParams(contentType string, body []byte) map[string]string {
+	return map[string]string{
+		"REQUEST_METHOD": "POST",
+		"CONTENT_TYPE":   contentType,
+		"CONTENT_LENGTH": fmt.Sprint(len(body)),
+	}
+}
+
+func TestMultipartFormData(t *testing.T) {
+	setIni(t, "upload_max_filesize", "10")
+	setIni(t, "upload_tmp_dir", t.TempDir())
+
+	body, contentType := multipartBody(t,
+		[][2]string{{"title", "Holiday"}, {"tags[]", "sea"}, {"tags[]", "sun"}, {"user[name]", "Ann"}},
+		[]formFile{
+			{"avatar", `C:\photos\me.png`, "image/png", "PNGDATA"},
+			{"docs[]", "a.txt", "text/plain", "aaa"},
+			{"docs[]", "", "application/octet-stream", ""},
+			{"big", "big.bin", "application/octet-stream", "this is over ten bytes"},
+		})
+
+	vmCtx := vm.NewExecutionContext()
+	SetupCGIVariables(vmCtx, postParams(contentType, body), body)
+
+	require.Equal(t, "{tags:{0:sea,1:sun},title:Holiday,user:{name:Ann}}", dump(global(t, vmCtx, "$_POST")))
+
+	files := global(t, vmCtx, "$_FILES")
+	avatar := files.ArrayGet(values.NewString("avatar"))
+	tmpName := avatar.ArrayGet(values.NewString("tmp_name")).ToString()
+	require.Equal(t, fmt.Sprintf("{error:0,name:me.png,size:7,tmp_name:%s,type:image/png}", tmpName), dump(avatar))
+	content, err := os.ReadFile(tmpName)
+	require.NoError(t, err)
+	require.Equal(t, "PNGDATA", string(content))
+	require.True(t, vmCtx.HTTPContext.IsUploadedFile(tmpName))
+
+	docs := files.ArrayGet(values.NewString("docs"))
+	docTmp := docs.ArrayGet(values.NewString("tmp_name")).ArrayGet(values.NewInt(0)).ToString()
+	require.Equal(t, fmt.Sprintf("{error:{0:0,1:4},name:{0:a.txt,1:},size:{0:3,1:0},tmp_name:{0:%s,1:},type:{0:text/plain,1:}}", docTmp), dump(docs))
+
+	require.Equal(t, "{error:1,name:big.bin,size:0,tmp_name:,type:application/octet-stream}", dump(files.ArrayGet(values.NewString("big"))))
+
+	// Multipart bodies are not available through php://input
+	require.Empty(t, vmCtx.HTTPContext.GetRequestBody())
+
+	CleanupRequest(vmCtx)
+	require.NoFileExists(t, tmpName)
+	require.NoFileExists(t, docTmp)
+	require.Empty(t, vmCtx.HTTPContext.GetUploadedFiles())
+}
+
+func TestMultipartLimits(t *testing.T) {
+	setIni(t, "upload_tmp_dir", t.TempDir())
+	setIni(t, "max_file_uploads", "1")
+
+	body, contentType := multipartBody(t,
+		[][2]string{{"MAX_FILE_SIZE", "4"}},
+		[]formFile{
+			{"first", "1.txt", "", "12345"},
+			{"second", "2.txt", "", "1"},
+		})
+	vmCtx := vm.NewExecutionContext()
+	SetupCGIVariables(vmCtx, postParams(contentType, body), body)
+	require.Equal(t, "{first:{error:2,name:1.txt,size:0,tmp_name:,type:}}", dump(global(t, vmCtx, "$_FILES")))
+
+	setIni(t, "file_uploads", "0")
+	body, contentType = multipartBody(t, [][2]string{{"a", "b"}}, []formFile{{"f", "f.txt", "", "x"}})
+	vmCtx = vm.NewExecutionContext()
+	SetupCGIVariables(vmCtx, postParams(contentType, body), body)
+	require.Equal(t, "{a:b}", dump(global(t, vmCtx, "$_POST")))
+	require.Equal(t, "{}", dump(global(t, vmCtx, "$_FILES")))
+
+	setIni(t, "post_max_size", "16")
+	body = []byte("name=a+very+long+value+indeed")
+	vmCtx = vm.NewExecutionContext()
+	SetupCGIVariables(vmCtx, postParams("application/x-www-form-urlencoded", body), body)
+	require.Equal(t, "{}", dump(global(t, vmCtx, "$_POST")))
+}
+
+func runRequest(t *testing.T, script string, params map[string]string, body []byte) string {
+	t.Helper()
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime.Bootstrap())
+	require.NoError(t, runtime.InitializeVMIntegration())
+
+	file := filepath.Join(t.TempDir(), "index.php")
+	require.NoError(t, os.WriteFile(file, []byte(script), 0644))
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return compiler.NewCompiler()
+	})
+	compiled, err := factory.CompileFile(file)
+	require.NoError(t, err)
+
+	vmCtx := vm.NewExecutionContext()
+	var out bytes.Buffer
+	vmCtx.OutputWriter = &out
+	SetupRequest(vmCtx, params, body)
+	defer CleanupRequest(vmCtx)
+
+	err = factory.CreateVM().Execute(vmCtx, compiled.Instructions, compiled.Constants,
+		compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+	require.NoError(t, err)
+	return out.String()
+}
+
+func TestMoveUploadedFile(t *testing.T) {
+	setIni(t, "upload_tmp_dir", t.TempDir())
+	dest := filepath.Join(t.TempDir(), "saved.txt")
+
+	body, contentType := multipartBody(t, nil, []formFile{{"doc", "notes.txt", "text/plain", "uploaded!"}})
+	out := runRequest(t, `<?php
+$tmp = $_FILES['doc']['tmp_name'];
+var_dump(is_uploaded_file($tmp), is_uploaded_file(__FILE__));
+var_dump(move_uploaded_file(__FILE__, $_POST['dest'] ?? '/tmp/nowhere'));
+var_dump(move_uploaded_file($tmp, '`+dest+`'));
+var_dump(file_exists($tmp), is_uploaded_file($tmp));
+`, postParams(contentType, body), body)
+	require.Equal(t, "bool(true)\nbool(false)\nbool(false)\nbool(true)\nbool(false)\nbool(false)\n", out)
+
+	content, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, "uploaded!", string(content))
+}
+
+func TestPHPInput(t *testing.T) {
+	body := []byte(`{"id": 42}`)
+	params := map[string]string{
+		"REQUEST_METHOD": "PUT",
+		"CONTENT_TYPE":   "application/json",
+		"CONTENT_LENGTH": fmt.Sprint(len(body)),
+	}
+	out := runRequest(t, `<?php
+echo file_get_contents('php://input'), "\n";
+$h = fopen('php://input', 'r');
+echo fread($h, 5), "|", fread($h, 100), "\n";
+fclose($h);
+echo count($_POST), "\n";
+`, params, body)
+	require.Equal(t, "{\"id\": 42}\n{\"id\"|: 42}\n0\n", out)
+
+	form := []byte("a[]=1&a[]=2")
+	out = runRequest(t, `<?php
+echo file_get_contents('php://input'), " ", implode(",", $_POST['a']);
+`, postParams("application/x-www-form-urlencoded", form), form)
+	require.Equal(t, "a[]=1&a[]=2 1,2", out)
+}
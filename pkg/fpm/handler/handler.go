@@ -3,14 +3,14 @@ package handler
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 
-	"github.com/wudi/hey/compiler"
-	"github.com/wudi/hey/compiler/lexer"
-	"github.com/wudi/hey/compiler/parser"
+	"github.com/wudi/hey/pkg/dbgp"
 	"github.com/wudi/hey/pkg/fastcgi"
+	"github.com/wudi/hey/pkg/profile"
 	"github.com/wudi/hey/runtime"
 	"github.com/wudi/hey/vm"
 	"github.com/wudi/hey/vmfactory"
@@ -36,27 +36,17 @@ func (h *RequestHandler) HandleRequest(ctx context.Context, proto *fastcgi.Proto
 		return h.sendError(proto, req.ID, fmt.Sprintf("File not found: %s", scriptFile))
 	}
 
-	code, err := os.ReadFile(scriptFile)
+	compiled, err := h.vmFactory.CompileFile(scriptFile)
 	if err != nil {
-		return h.sendError(proto, req.ID, fmt.Sprintf("Failed to read file: %v", err))
-	}
-
-	l := lexer.New(string(code))
-	p := parser.New(l)
-	prog := p.ParseProgram()
-
-	if len(p.Errors()) != 0 {
-		var errBuf bytes.Buffer
-		for _, msg := range p.Errors() {
-			errBuf.WriteString(msg)
-			errBuf.WriteString("\n")
+		var parseErr *vmfactory.ParseError
+		if errors.As(err, &parseErr) {
+			var errBuf bytes.Buffer
+			for _, msg := range parseErr.Errors {
+				errBuf.WriteString(msg)
+				errBuf.WriteString("\n")
+			}
+			return h.sendError(proto, req.ID, errBuf.String())
 		}
-		return h.sendError(proto, req.ID, errBuf.String())
-	}
-
-	comp := compiler.NewCompiler()
-	comp.SetCurrentFile(scriptFile)
-	if err := comp.Compile(prog); err != nil {
 		return h.sendError(proto, req.ID, fmt.Sprintf("Compilation error: %v", err))
 	}
 
@@ -65,23 +55,41 @@ func (h *RequestHandler) HandleRequest(ctx context.Context, proto *fastcgi.Proto
 	var outBuf bytes.Buffer
 	vmCtx.OutputWriter = &outBuf
 
-	SetupCGIVariables(vmCtx, req.Params, req.Stdin)
-
-	extractRequestHeaders(vmCtx, req.Params)
-
-	variables := runtime.GlobalVMIntegration.GetAllVariables()
-	for name, value := range variables {
-		vmCtx.GlobalVars.Store(name, value)
-	}
+	SetupRequest(vmCtx, req.Params, req.Stdin)
+	defer CleanupRequest(vmCtx)
 
 	vmachine := h.vmFactory.CreateVM()
 
-	err = vmachine.Execute(vmCtx, comp.GetBytecode(), comp.GetConstants(),
-		comp.Functions(), comp.Classes(), comp.Interfaces(), comp.Traits())
+	var stderrBuf bytes.Buffer
+	var profiler *vm.Profiler
+	if profile.Enabled() {
+		profiler = vm.NewProfiler()
+		vmachine.SetProfiler(profiler)
+	}
+	var session *dbgp.Session
+	if config := dbgp.LoadConfig(); config.ShouldStart(dbgp.RequestTriggered(vmCtx)) {
+		if session, err = dbgp.Start(config, h.vmFactory, vmachine, scriptFile); err != nil {
+			stderrBuf.WriteString(fmt.Sprintf("Xdebug: %v\n", err))
+		}
+	}
 
-	vmachine.CallAllDestructors(vmCtx)
+	err = vmachine.Execute(vmCtx, compiled.Instructions, compiled.Constants,
+		compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+	if session != nil {
+		session.Finish()
+	}
+	if errors.Is(err, vm.ErrDebuggerTerminated) {
+		err = nil
+	} else {
+		vmachine.CallAllDestructors(vmCtx)
+	}
+	if profiler != nil {
+		output := profile.OutputFile(scriptFile, req.Params["REQUEST_URI"])
+		if err := profile.WriteFile(output, profiler.Stop(), scriptFile); err != nil {
+			stderrBuf.WriteString(fmt.Sprintf("Profiler: %v\n", err))
+		}
+	}
 
-	var stderrBuf bytes.Buffer
 	if err != nil {
 		stderrBuf.WriteString(fmt.Sprintf("Runtime error: %v\n", err))
 	}
@@ -100,6 +108,21 @@ func (h *RequestHandler) HandleRequest(ctx context.Context, proto *fastcgi.Proto
 	return proto.SendResponse(req.ID, response.Bytes(), stderrBuf.Bytes(), exitCode)
 }
 
+// SetupRequest prepares a fresh execution context for a web request described
+// by CGI params, filling the superglobals and the request headers.
+func SetupRequest(vmCtx *vm.ExecutionContext, params map[string]string, stdin []byte) {
+	// Runtime globals go in first so the request's superglobals replace
+	// their empty defaults
+	variables := runtime.GlobalVMIntegration.GetAllVariables()
+	for name, value := range variables {
+		vmCtx.GlobalVars.Store(name, value)
+	}
+
+	SetupCGIVariables(vmCtx, params, stdin)
+
+	extractRequestHeaders(vmCtx, params)
+}
+
 func extractRequestHeaders(vmCtx *vm.ExecutionContext, params map[string]string) {
 	headers := make(map[string]string)
 
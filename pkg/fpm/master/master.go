@@ -11,6 +11,7 @@ import (
 
 	"github.com/wudi/hey/compiler"
 	"github.com/wudi/hey/pkg/fastcgi"
+	"github.com/wudi/hey/pkg/fpm/opcache"
 	"github.com/wudi/hey/pkg/fpm/pool"
 	"github.com/wudi/hey/runtime"
 	"github.com/wudi/hey/vmfactory"
@@ -22,6 +23,7 @@ type MasterConfig struct {
 	ErrorLog  string
 	LogLevel  string
 	PoolConfig *pool.PoolConfig
+	Opcache    *opcache.OpcacheConfig
 }
 
 type Master struct {
@@ -69,6 +71,11 @@ func (m *Master) Start() error {
 	vmFactory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
 		return compiler.NewCompiler()
 	})
+	if m.config.Opcache != nil && m.config.Opcache.Enabled {
+		opcache.InitGlobalOpcache(m.config.Opcache)
+		vmFactory.SetOpcache(opcache.GlobalOpcache)
+		log.Printf("Opcache enabled (max %d scripts)", m.config.Opcache.MaxEntries)
+	}
 
 	m.pool = pool.NewWorkerPool(m.config.PoolConfig, vmFactory)
 	if err := m.pool.Start(); err != nil {
@@ -0,0 +1,786 @@
+package opcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/wudi/hey/opcodes"
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// FormatVersion is the version of the binary layout written by
+// EncodeScript. Bump it whenever the layout, the opcode numbering or the
+// meaning of an operand changes.
+const FormatVersion = 6
+
+var scriptMagic = []byte("HEYOPC\x00")
+
+var (
+	// ErrInvalidFormat is returned when data is not an encoded script.
+	ErrInvalidFormat = errors.New("opcache: invalid compiled script data")
+	// ErrVersionMismatch is returned for scripts encoded by a different
+	// format version or interpreter build.
+	ErrVersionMismatch = errors.New("opcache: compiled script version mismatch")
+	// ErrUnsupportedValue is returned when a script holds a value, such as
+	// an object or a Go builtin, that has no serialized form.
+	ErrUnsupportedValue = errors.New("opcache: unsupported value in compiled script")
+)
+
+// ScriptHeader identifies what an encoded script was compiled from and by.
+type ScriptHeader struct {
+	FormatVersion      uint64
+	InterpreterVersion string
+	FileHash           string
+	CompileOptions     string
+}
+
+// EncodeScript serializes the compiled script, tagging it with the
+// interpreter version and the script's FileHash and CompileOptions.
+//
+// The layout is the magic bytes, the format version, then a stream of
+// varints, IEEE 754 floats and strings. Each distinct string is written once
+// and referred to by index afterwards. Map entries are written in sorted key
+// order so the same script always encodes to the same bytes.
+func EncodeScript(script *CompiledScript, interpreterVersion string) ([]byte, error) {
+	w := &scriptWriter{strings: make(map[string]uint64)}
+	w.buf = append(w.buf, scriptMagic...)
+	w.uvarint(FormatVersion)
+	w.str(interpreterVersion)
+	w.str(script.FileHash)
+	w.str(script.CompileOptions)
+
+	w.instructions(script.Instructions)
+	w.values(script.Constants)
+	w.functions(script.Functions)
+	w.uvarint(uint64(len(script.Classes)))
+	for _, name := range sortedKeys(script.Classes) {
+		w.str(name)
+		w.class(script.Classes[name])
+	}
+	w.uvarint(uint64(len(script.Interfaces)))
+	for _, name := range sortedKeys(script.Interfaces) {
+		w.str(name)
+		w.iface(script.Interfaces[name])
+	}
+	w.uvarint(uint64(len(script.Traits)))
+	for _, name := range sortedKeys(script.Traits) {
+		w.str(name)
+		w.trait(script.Traits[name])
+	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.buf, nil
+}
+
+// DecodeScriptHeader reads the header of an encoded script without
+// decoding the rest.
+func DecodeScriptHeader(data []byte) (*ScriptHeader, error) {
+	r, header, err := readScriptHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return header, nil
+}
+
+// DecodeScript deserializes a script written by EncodeScript. It fails with
+// ErrVersionMismatch unless the data was produced by the same format
+// version and interpreterVersion.
+func DecodeScript(data []byte, interpreterVersion string) (*CompiledScript, error) {
+	r, header, err := readScriptHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if header.FormatVersion != FormatVersion || header.InterpreterVersion != interpreterVersion {
+		return nil, ErrVersionMismatch
+	}
+
+	script := &CompiledScript{
+		Bytecode:       data,
+		FileHash:       header.FileHash,
+		CompileOptions: header.CompileOptions,
+		Instructions:   r.instructions(),
+		Constants:      r.values(),
+		Functions:      r.functions(),
+		Classes:        make(map[string]*registry.Class),
+		Interfaces:     make(map[string]*registry.Interface),
+		Traits:         make(map[string]*registry.Trait),
+	}
+	for n := r.count(); n > 0 && r.err == nil; n-- {
+		name := r.str()
+		script.Classes[name] = r.class()
+	}
+	for n := r.count(); n > 0 && r.err == nil; n-- {
+		name := r.str()
+		script.Interfaces[name] = r.iface()
+	}
+	for n := r.count(); n > 0 && r.err == nil; n-- {
+		name := r.str()
+		script.Traits[name] = r.trait()
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.pos != len(r.data) {
+		return nil, ErrInvalidFormat
+	}
+	return script, nil
+}
+
+func readScriptHeader(data []byte) (*scriptReader, *ScriptHeader, error) {
+	if len(data) < len(scriptMagic) || string(data[:len(scriptMagic)]) != string(scriptMagic) {
+		return nil, nil, ErrInvalidFormat
+	}
+	r := &scriptReader{data: data, pos: len(scriptMagic)}
+	header := &ScriptHeader{FormatVersion: r.uvarint()}
+	if header.FormatVersion != FormatVersion {
+		// The rest of the header may be laid out differently
+		return r, header, ErrVersionMismatch
+	}
+	header.InterpreterVersion = r.str()
+	header.FileHash = r.str()
+	header.CompileOptions = r.str()
+	return r, header, r.err
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Value tags used in the encoded constant pool
+const (
+	tagNull byte = iota
+	tagFalse
+	tagTrue
+	tagInt
+	tagFloat
+	tagString
+	tagArray
+	tagNil      // a nil *values.Value
+	tagEnumCase // an enum case object, the value of an enum case constant
+)
+
+type scriptWriter struct {
+	buf     []byte
+	strings map[string]uint64
+	err     error
+}
+
+func (w *scriptWriter) byte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *scriptWriter) uvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *scriptWriter) varint(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *scriptWriter) bool(b bool) {
+	if b {
+		w.byte(1)
+	} else {
+		w.byte(0)
+	}
+}
+
+// str writes a string the first time it is seen and a back reference to
+// it afterwards: 0 followed by the bytes, or the string's index plus one.
+func (w *scriptWriter) str(s string) {
+	if idx, ok := w.strings[s]; ok {
+		w.uvarint(idx + 1)
+		return
+	}
+	w.strings[s] = uint64(len(w.strings))
+	w.uvarint(0)
+	w.uvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *scriptWriter) strs(list []string) {
+	w.uvarint(uint64(len(list)))
+	for _, s := range list {
+		w.str(s)
+	}
+}
+
+func (w *scriptWriter) fail(format string, args ...interface{}) {
+	if w.err == nil {
+		w.err = fmt.Errorf("%w: "+format, append([]interface{}{ErrUnsupportedValue}, args...)...)
+	}
+}
+
+func (w *scriptWriter) instructions(list []*opcodes.Instruction) {
+	w.uvarint(uint64(len(list)))
+	for _, inst := range list {
+		w.byte(byte(inst.Opcode))
+		w.byte(inst.OpType1)
+		w.byte(inst.OpType2)
+		w.byte(inst.Reserved)
+		w.uvarint(uint64(inst.Op1))
+		w.uvarint(uint64(inst.Op2))
+		w.uvarint(uint64(inst.Result))
+		w.str(inst.Filename)
+		w.varint(int64(inst.Line))
+	}
+}
+
+func (w *scriptWriter) value(v *values.Value) {
+	if v == nil {
+		w.byte(tagNil)
+		return
+	}
+	switch v.Type {
+	case values.TypeNull:
+		w.byte(tagNull)
+	case values.TypeBool:
+		if v.ToBool() {
+			w.byte(tagTrue)
+		} else {
+			w.byte(tagFalse)
+		}
+	case values.TypeInt:
+		w.byte(tagInt)
+		w.varint(v.ToInt())
+	case values.TypeFloat:
+		w.byte(tagFloat)
+		w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(v.ToFloat()))
+	case values.TypeString:
+		w.byte(tagString)
+		w.str(v.ToString())
+	case values.TypeArray:
+		arr := v.Data.(*values.Array)
+		w.byte(tagArray)
+		w.uvarint(uint64(arr.Len()))
+		for key, elem := range arr.All() {
+			switch k := key.(type) {
+			case int64:
+				w.byte(tagInt)
+				w.varint(k)
+			case string:
+				w.byte(tagString)
+				w.str(k)
+			default:
+				w.fail("array key of type %T", key)
+			}
+			w.value(elem)
+		}
+	case values.TypeObject:
+		obj := v.Data.(*values.Object)
+		if !obj.EnumCase {
+			w.fail("object of class %s", obj.ClassName)
+			return
+		}
+		w.byte(tagEnumCase)
+		w.str(obj.ClassName)
+		w.value(obj.Properties["name"])
+		w.value(obj.Properties["value"])
+	default:
+		w.fail("value of type %v", v.Type)
+	}
+}
+
+func (w *scriptWriter) values(list []*values.Value) {
+	w.uvarint(uint64(len(list)))
+	for _, v := range list {
+		w.value(v)
+	}
+}
+
+func (w *scriptWriter) attributes(list []*registry.Attribute) {
+	w.uvarint(uint64(len(list)))
+	for _, attr := range list {
+		w.str(attr.Name)
+		w.values(attr.Arguments)
+		w.strs(attr.ArgumentNames)
+	}
+}
+
+func (w *scriptWriter) parameters(list []*registry.Parameter) {
+	w.uvarint(uint64(len(list)))
+	for _, param := range list {
+		w.str(param.Name)
+		w.str(param.Type)
+		w.bool(param.IsReference)
+		w.bool(param.HasDefault)
+		w.value(param.DefaultValue)
+		w.attributes(param.Attributes)
+		w.bool(param.IsPromoted)
+	}
+}
+
+func (w *scriptWriter) function(fn *registry.Function) {
+	if fn.IsBuiltin || fn.Builtin != nil || fn.Handler != nil {
+		w.fail("builtin function %s", fn.Name)
+		return
+	}
+	w.str(fn.Name)
+	w.parameters(fn.Parameters)
+	w.str(fn.ReturnType)
+	w.instructions(fn.Instructions)
+	w.values(fn.Constants)
+	w.bool(fn.IsVariadic)
+	w.bool(fn.IsGenerator)
+	w.bool(fn.IsAnonymous)
+	w.bool(fn.IsAbstract)
+	w.bool(fn.IsStatic)
+	w.bool(fn.IsFinal)
+	w.str(fn.Visibility)
+	w.bool(fn.ReturnsByReference)
+	w.bool(fn.StrictTypes)
+	w.str(fn.DocComment)
+	w.varint(int64(fn.MinArgs))
+	w.varint(int64(fn.MaxArgs))
+	w.attributes(fn.Attributes)
+	w.bool(fn.VariableSlots != nil)
+	w.uvarint(uint64(len(fn.VariableSlots)))
+	for _, name := range sortedKeys(fn.VariableSlots) {
+		w.str(name)
+		w.uvarint(uint64(fn.VariableSlots[name]))
+	}
+	w.uvarint(uint64(fn.MaxLocalSlot))
+}
+
+func (w *scriptWriter) functions(m map[string]*registry.Function) {
+	w.uvarint(uint64(len(m)))
+	for _, name := range sortedKeys(m) {
+		w.str(name)
+		w.function(m[name])
+	}
+}
+
+func (w *scriptWriter) properties(m map[string]*registry.Property) {
+	w.uvarint(uint64(len(m)))
+	for _, name := range sortedKeys(m) {
+		prop := m[name]
+		w.str(name)
+		w.str(prop.Name)
+		w.str(prop.Visibility)
+		w.bool(prop.IsStatic)
+		w.bool(prop.IsReadonly)
+		w.str(prop.Type)
+		w.value(prop.DefaultValue)
+		w.str(prop.DocComment)
+		w.attributes(prop.Attributes)
+	}
+}
+
+func (w *scriptWriter) class(class *registry.Class) {
+	w.str(class.Name)
+	w.str(class.Parent)
+	w.strs(class.Interfaces)
+	w.strs(class.Traits)
+	w.properties(class.Properties)
+	w.functions(class.Methods)
+	w.uvarint(uint64(len(class.Constants)))
+	for _, name := range sortedKeys(class.Constants) {
+		constant := class.Constants[name]
+		w.str(name)
+		w.str(constant.Name)
+		w.value(constant.Value)
+		w.str(constant.Visibility)
+		w.bool(constant.IsFinal)
+		w.str(constant.Type)
+		w.bool(constant.IsAbstract)
+	}
+	w.bool(class.IsAbstract)
+	w.bool(class.IsFinal)
+	w.attributes(class.Attributes)
+	w.str(class.DocComment)
+	w.bool(class.IsEnum)
+	w.str(class.EnumBackingType)
+	w.strs(class.EnumCases)
+	w.strs(class.MethodOrder)
+	w.strs(class.PropertyOrder)
+	w.strs(class.ConstantOrder)
+}
+
+func (w *scriptWriter) iface(iface *registry.Interface) {
+	w.str(iface.Name)
+	w.uvarint(uint64(len(iface.Methods)))
+	for _, name := range sortedKeys(iface.Methods) {
+		method := iface.Methods[name]
+		w.str(name)
+		w.str(method.Name)
+		w.str(method.Visibility)
+		w.parameters(method.Parameters)
+		w.str(method.ReturnType)
+	}
+	w.strs(iface.Extends)
+}
+
+func (w *scriptWriter) trait(trait *registry.Trait) {
+	w.str(trait.Name)
+	w.properties(trait.Properties)
+	w.functions(trait.Methods)
+	w.strs(trait.MethodOrder)
+	w.strs(trait.PropertyOrder)
+}
+
+type scriptReader struct {
+	data    []byte
+	pos     int
+	strings []string
+	err     error
+}
+
+func (r *scriptReader) fail() {
+	if r.err == nil {
+		r.err = ErrInvalidFormat
+	}
+}
+
+func (r *scriptReader) byte() byte {
+	if r.err != nil || r.pos >= len(r.data) {
+		r.fail()
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *scriptReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *scriptReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *scriptReader) uint32() uint32 {
+	v := r.uvarint()
+	if v > math.MaxUint32 {
+		r.fail()
+	}
+	return uint32(v)
+}
+
+// count reads a collection length, rejecting lengths that cannot fit in
+// the remaining data so corrupt input cannot force huge allocations.
+func (r *scriptReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.data)-r.pos) {
+		r.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (r *scriptReader) bool() bool {
+	return r.byte() != 0
+}
+
+func (r *scriptReader) str() string {
+	ref := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if ref > 0 {
+		if ref > uint64(len(r.strings)) {
+			r.fail()
+			return ""
+		}
+		return r.strings[ref-1]
+	}
+	n := r.count()
+	if r.err != nil {
+		return ""
+	}
+	s := string(r.data[r.pos : r.pos+n])
+	r.pos += n
+	r.strings = append(r.strings, s)
+	return s
+}
+
+func (r *scriptReader) strs() []string {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+	list := make([]string, n)
+	for i := range list {
+		list[i] = r.str()
+	}
+	return list
+}
+
+func (r *scriptReader) instructions() []*opcodes.Instruction {
+	n := r.count()
+	list := make([]*opcodes.Instruction, n)
+	for i := range list {
+		list[i] = &opcodes.Instruction{
+			Opcode:   opcodes.Opcode(r.byte()),
+			OpType1:  r.byte(),
+			OpType2:  r.byte(),
+			Reserved: r.byte(),
+			Op1:      r.uint32(),
+			Op2:      r.uint32(),
+			Result:   r.uint32(),
+			Filename: r.str(),
+			Line:     int(r.varint()),
+		}
+	}
+	return list
+}
+
+func (r *scriptReader) value() *values.Value {
+	switch tag := r.byte(); tag {
+	case tagNil:
+		return nil
+	case tagNull:
+		return values.NewNull()
+	case tagFalse:
+		return values.NewBool(false)
+	case tagTrue:
+		return values.NewBool(true)
+	case tagInt:
+		return values.NewInt(r.varint())
+	case tagFloat:
+		if r.err != nil || r.pos+8 > len(r.data) {
+			r.fail()
+			return nil
+		}
+		bits := binary.LittleEndian.Uint64(r.data[r.pos:])
+		r.pos += 8
+		return values.NewFloat(math.Float64frombits(bits))
+	case tagString:
+		return values.NewString(r.str())
+	case tagArray:
+		v := values.NewArray()
+		arr := v.Data.(*values.Array)
+		for n := r.count(); n > 0 && r.err == nil; n-- {
+			var key interface{}
+			switch r.byte() {
+			case tagInt:
+				key = r.varint()
+			case tagString:
+				key = r.str()
+			default:
+				r.fail()
+				return nil
+			}
+			arr.Set(key, r.value())
+		}
+		return v
+	case tagEnumCase:
+		obj := &values.Object{
+			ClassName:  r.str(),
+			Properties: make(map[string]*values.Value),
+			Methods:    make(map[string]interface{}),
+			EnumCase:   true,
+		}
+		obj.Properties["name"] = r.value()
+		if value := r.value(); value != nil {
+			obj.Properties["value"] = value
+		}
+		return &values.Value{Type: values.TypeObject, Data: obj}
+	default:
+		r.fail()
+		return nil
+	}
+}
+
+func (r *scriptReader) values() []*values.Value {
+	n := r.count()
+	list := make([]*values.Value, n)
+	for i := range list {
+		list[i] = r.value()
+	}
+	return list
+}
+
+func (r *scriptReader) attributes() []*registry.Attribute {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+	list := make([]*registry.Attribute, n)
+	for i := range list {
+		list[i] = &registry.Attribute{
+			Name:          r.str(),
+			Arguments:     r.values(),
+			ArgumentNames: r.strs(),
+		}
+	}
+	return list
+}
+
+func (r *scriptReader) parameters() []*registry.Parameter {
+	n := r.count()
+	list := make([]*registry.Parameter, n)
+	for i := range list {
+		list[i] = &registry.Parameter{
+			Name:         r.str(),
+			Type:         r.str(),
+			IsReference:  r.bool(),
+			HasDefault:   r.bool(),
+			DefaultValue: r.value(),
+			Attributes:   r.attributes(),
+			IsPromoted:   r.bool(),
+		}
+	}
+	return list
+}
+
+func (r *scriptReader) function() *registry.Function {
+	fn := &registry.Function{
+		Name:               r.str(),
+		Parameters:         r.parameters(),
+		ReturnType:         r.str(),
+		Instructions:       r.instructions(),
+		Constants:          r.values(),
+		IsVariadic:         r.bool(),
+		IsGenerator:        r.bool(),
+		IsAnonymous:        r.bool(),
+		IsAbstract:         r.bool(),
+		IsStatic:           r.bool(),
+		IsFinal:            r.bool(),
+		Visibility:         r.str(),
+		ReturnsByReference: r.bool(),
+		StrictTypes:        r.bool(),
+		DocComment:         r.str(),
+		MinArgs:            int(r.varint()),
+		MaxArgs:            int(r.varint()),
+		Attributes:         r.attributes(),
+	}
+	hasSlots := r.bool()
+	n := r.count()
+	if hasSlots {
+		fn.VariableSlots = make(map[string]uint32, n)
+	}
+	for ; n > 0 && r.err == nil; n-- {
+		name := r.str()
+		slot := r.uint32()
+		if fn.VariableSlots != nil {
+			fn.VariableSlots[name] = slot
+		}
+	}
+	fn.MaxLocalSlot = r.uint32()
+	return fn
+}
+
+func (r *scriptReader) functions() map[string]*registry.Function {
+	n := r.count()
+	m := make(map[string]*registry.Function, n)
+	for ; n > 0 && r.err == nil; n-- {
+		name := r.str()
+		m[name] = r.function()
+	}
+	return m
+}
+
+func (r *scriptReader) properties() map[string]*registry.Property {
+	n := r.count()
+	m := make(map[string]*registry.Property, n)
+	for ; n > 0 && r.err == nil; n-- {
+		name := r.str()
+		m[name] = &registry.Property{
+			Name:         r.str(),
+			Visibility:   r.str(),
+			IsStatic:     r.bool(),
+			IsReadonly:   r.bool(),
+			Type:         r.str(),
+			DefaultValue: r.value(),
+			DocComment:   r.str(),
+			Attributes:   r.attributes(),
+		}
+	}
+	return m
+}
+
+func (r *scriptReader) class() *registry.Class {
+	class := &registry.Class{
+		Name:       r.str(),
+		Parent:     r.str(),
+		Interfaces: r.strs(),
+		Traits:     r.strs(),
+		Properties: r.properties(),
+		Methods:    r.functions(),
+		Constants:  make(map[string]*registry.ClassConstant),
+	}
+	for n := r.count(); n > 0 && r.err == nil; n-- {
+		name := r.str()
+		class.Constants[name] = &registry.ClassConstant{
+			Name:       r.str(),
+			Value:      r.value(),
+			Visibility: r.str(),
+			IsFinal:    r.bool(),
+			Type:       r.str(),
+			IsAbstract: r.bool(),
+		}
+	}
+	class.IsAbstract = r.bool()
+	class.IsFinal = r.bool()
+	class.Attributes = r.attributes()
+	class.DocComment = r.str()
+	class.IsEnum = r.bool()
+	class.EnumBackingType = r.str()
+	class.EnumCases = r.strs()
+	class.MethodOrder = r.strs()
+	class.PropertyOrder = r.strs()
+	class.ConstantOrder = r.strs()
+	return class
+}
+
+func (r *scriptReader) iface() *registry.Interface {
+	iface := &registry.Interface{
+		Name:    r.str(),
+		Methods: make(map[string]*registry.InterfaceMethod),
+	}
+	for n := r.count(); n > 0 && r.err == nil; n-- {
+		name := r.str()
+		iface.Methods[name] = &registry.InterfaceMethod{
+			Name:       r.str(),
+			Visibility: r.str(),
+			Parameters: r.parameters(),
+			ReturnType: r.str(),
+		}
+	}
+	iface.Extends = r.strs()
+	return iface
+}
+
+func (r *scriptReader) trait() *registry.Trait {
+	return &registry.Trait{
+		Name:          r.str(),
+		Properties:    r.properties(),
+		Methods:       r.functions(),
+		MethodOrder:   r.strs(),
+		PropertyOrder: r.strs(),
+	}
+}
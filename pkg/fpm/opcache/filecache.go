@@ -0,0 +1,165 @@
+package opcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/wudi/hey/version"
+)
+
+// Cache loads compiled scripts, compiling them only when no usable cached
+// copy exists. Both the in-memory OpcacheManager and the on-disk FileCache
+// implement it.
+type Cache interface {
+	Load(file string, compile func(source []byte) (*CompiledScript, error)) (*CompiledScript, error)
+}
+
+// fileCacheExt is the extension of cache entries written by FileCache
+const fileCacheExt = ".heyc"
+
+// FileCache persists compiled scripts in a directory so they can be reused
+// across processes. Entries are keyed by the script's absolute path and the
+// compile options, and are discarded when the source hash or the
+// interpreter version changes.
+type FileCache struct {
+	dir                string
+	interpreterVersion string
+	compileOptions     string
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewFileCache creates a file cache that stores entries in dir, creating the
+// directory if needed.
+func NewFileCache(dir string) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("opcache: failed to create cache directory: %w", err)
+	}
+	return &FileCache{
+		dir:                dir,
+		interpreterVersion: InterpreterVersion(),
+	}, nil
+}
+
+// SetCompileOptions names the compiler settings scripts are compiled with,
+// such as "optimize". Entries compiled with other settings are kept apart
+// and never loaded.
+func (fc *FileCache) SetCompileOptions(options string) {
+	fc.compileOptions = options
+}
+
+// Load returns the cached compilation of file if its entry was written by
+// this interpreter with the same compile options for the file's current
+// contents. Otherwise it compiles
+// the file and stores the result. Failing to write an entry is not an
+// error; the script simply is not cached.
+func (fc *FileCache) Load(file string, compile func(source []byte) (*CompiledScript, error)) (*CompiledScript, error) {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	hash := hashBytes(source)
+
+	entry := fc.entryPath(file)
+	if data, err := os.ReadFile(entry); err == nil {
+		script, err := DecodeScript(data, fc.interpreterVersion)
+		if err == nil && script.FileHash == hash && script.CompileOptions == fc.compileOptions {
+			fc.hits.Add(1)
+			script.Timestamp = info.ModTime()
+			script.Size = info.Size()
+			return script, nil
+		}
+	}
+	fc.misses.Add(1)
+
+	script, err := compile(source)
+	if err != nil {
+		return nil, err
+	}
+	script.FileHash = hash
+	script.CompileOptions = fc.compileOptions
+	script.Timestamp = info.ModTime()
+	script.Size = info.Size()
+
+	// Scripts with parse errors are recompiled every time so the errors
+	// are reported again
+	if len(script.ParseErrors) == 0 {
+		if data, err := EncodeScript(script, fc.interpreterVersion); err == nil {
+			script.Bytecode = data
+			fc.write(entry, data)
+		}
+	}
+	return script, nil
+}
+
+// Invalidate removes the cache entry for file.
+func (fc *FileCache) Invalidate(file string) {
+	os.Remove(fc.entryPath(file))
+}
+
+// Stats returns the cache hit and miss counts.
+func (fc *FileCache) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"dir":    fc.dir,
+		"hits":   fc.hits.Load(),
+		"misses": fc.misses.Load(),
+	}
+}
+
+func (fc *FileCache) entryPath(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	sum := sha256.Sum256([]byte(file + "\x00" + fc.compileOptions))
+	return filepath.Join(fc.dir, hex.EncodeToString(sum[:])+fileCacheExt)
+}
+
+// write stores an entry atomically so concurrent runs never read a
+// partially written file.
+func (fc *FileCache) write(entry string, data []byte) {
+	tmp, err := os.CreateTemp(fc.dir, "tmp-*"+fileCacheExt)
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), entry)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+var (
+	interpreterVersionOnce sync.Once
+	interpreterVersion     string
+)
+
+// InterpreterVersion identifies the running interpreter build. Besides the
+// release version it includes the size and modification time of the
+// executable, so rebuilding the interpreter invalidates cached scripts even
+// when the version number is unchanged.
+func InterpreterVersion() string {
+	interpreterVersionOnce.Do(func() {
+		interpreterVersion = version.FullVersion() + " " + version.Build()
+		if exe, err := os.Executable(); err == nil {
+			if info, err := os.Stat(exe); err == nil {
+				interpreterVersion += fmt.Sprintf(" %d-%d", info.Size(), info.ModTime().UnixNano())
+			}
+		}
+	})
+	return interpreterVersion
+}
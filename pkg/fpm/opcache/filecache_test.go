@@ -0,0 +1,262 @@
+package opcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/opcodes"
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+func sampleScript() *CompiledScript {
+	list := values.NewArray()
+	list.ArraySet(values.NewInt(0), values.NewFloat(1.5))
+	list.ArraySet(values.NewString("key"), values.NewBool(true))
+
+	method := &registry.Function{
+		Name:         "area",
+		Parameters:   []*registry.Parameter{{Name: "scale", Type: "float", HasDefault: true, DefaultValue: values.NewFloat(1)}},
+		ReturnType:   "float",
+		Instructions: []*opcodes.Instruction{{Opcode: opcodes.OP_RETURN, OpType1: 0x01, Op1: 0, Filename: "lib.php", Line: 3}},
+		Constants:    []*values.Value{values.NewInt(-7)},
+		Visibility:   "public",
+		MinArgs:      0,
+		MaxArgs:      1,
+		VariableSlots: map[string]uint32{
+			"this":  0,
+			"scale": 1,
+		},
+		MaxLocalSlot: 2,
+	}
+	constructor := &registry.Function{
+		Name:         "__construct",
+		Parameters:   []*registry.Parameter{{Name: "r", Type: "float", IsPromoted: true}},
+		Instructions: []*opcodes.Instruction{},
+		Constants:    []*values.Value{},
+		Visibility:   "public",
+	}
+
+	return &CompiledScript{
+		FileHash:       "abc",
+		CompileOptions: "optimize",
+		Instructions: []*opcodes.Instruction{
+			{Opcode: opcodes.OP_ECHO, OpType1: 0x01, Op1: 1, Filename: "main.php", Line: 2},
+			{Opcode: opcodes.OP_RETURN, Filename: "main.php", Line: 3},
+		},
+		Constants: []*values.Value{values.NewNull(), values.NewString("hello"), list},
+		Functions: map[string]*registry.Function{
+			"helper": {
+				Name:         "helper",
+				Parameters:   []*registry.Parameter{},
+				Instructions: []*opcodes.Instruction{},
+				Constants:    []*values.Value{},
+				IsVariadic:   true,
+				Visibility:   "public",
+			},
+		},
+		Classes: map[string]*registry.Class{
+			"circle": {
+				Name:       "Circle",
+				Interfaces: []string{"Shape"},
+				Properties: map[string]*registry.Property{
+					"r": {Name: "r", Visibility: "private", Type: "float", DefaultValue: values.NewFloat(2)},
+				},
+				Methods: map[string]*registry.Function{"__construct": constructor, "area": method},
+				Constants: map[string]*registry.ClassConstant{
+					"PI": {Name: "PI", Value: values.NewFloat(3.14), Visibility: "public"},
+				},
+				IsFinal:       true,
+				Attributes:    []*registry.Attribute{{Name: "Tag", Arguments: []*values.Value{values.NewString("x")}, ArgumentNames: []string{"v"}}},
+				MethodOrder:   []string{"area", "__construct"},
+				PropertyOrder: []string{"r"},
+				ConstantOrder: []string{"PI"},
+			},
+		},
+		Interfaces: map[string]*registry.Interface{
+			"shape": {
+				Name: "Shape",
+				Methods: map[string]*registry.InterfaceMethod{
+					"area": {Name: "area", Visibility: "public", Parameters: []*registry.Parameter{}, ReturnType: "float"},
+				},
+			},
+		},
+		Traits: map[string]*registry.Trait{
+			"named": {
+				Name: "Named",
+				Properties: map[string]*registry.Property{
+					"name": {Name: "name", Visibility: "public", Type: "string"},
+					"id":   {Name: "id", Visibility: "public", Type: "int"},
+				},
+				Methods:       map[string]*registry.Function{},
+				PropertyOrder: []string{"name", "id"},
+			},
+		},
+	}
+}
+
+func TestEncodeDecodeScript(t *testing.T) {
+	script := sampleScript()
+	data, err := EncodeScript(script, "test")
+	require.NoError(t, err)
+
+	again, err := EncodeScript(script, "test")
+	require.NoError(t, err)
+	require.Equal(t, data, again, "encoding must be deterministic")
+
+	decoded, err := DecodeScript(data, "test")
+	require.NoError(t, err)
+	require.Equal(t, data, decoded.Bytecode)
+	decoded.Bytecode = nil
+	require.Equal(t, script, decoded)
+
+	header, err := DecodeScriptHeader(data)
+	require.NoError(t, err)
+	require.Equal(t, &ScriptHeader{FormatVersion: FormatVersion, InterpreterVersion: "test", FileHash: "abc", CompileOptions: "optimize"}, header)
+}
+
+func TestDecodeScriptRejectsBadData(t *testing.T) {
+	data, err := EncodeScript(sampleScript(), "test")
+	require.NoError(t, err)
+
+	_, err = DecodeScript(data, "other")
+	require.ErrorIs(t, err, ErrVersionMismatch)
+
+	_, err = DecodeScript([]byte("<?php echo 1;"), "test")
+	require.ErrorIs(t, err, ErrInvalidFormat)
+
+	for _, n := range []int{len(scriptMagic) + 3, len(data) / 2, len(data) - 1} {
+		_, err = DecodeScript(data[:n], "test")
+		require.ErrorIs(t, err, ErrInvalidFormat, "truncated to %d bytes", n)
+	}
+
+	_, err = DecodeScript(append(data, 0), "test")
+	require.ErrorIs(t, err, ErrInvalidFormat)
+}
+
+func TestEncodeScriptRejectsUnsupportedValues(t *testing.T) {
+	script := sampleScript()
+	script.Constants = append(script.Constants, values.NewObject("stdClass"))
+	_, err := EncodeScript(script, "test")
+	require.ErrorIs(t, err, ErrUnsupportedValue)
+
+	script = sampleScript()
+	script.Functions["strlen"] = &registry.Function{Name: "strlen", IsBuiltin: true}
+	_, err = EncodeScript(script, "test")
+	require.ErrorIs(t, err, ErrUnsupportedValue)
+}
+
+func TestFileCache(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "index.php")
+	require.NoError(t, os.WriteFile(file, []byte("<?php echo 1;"), 0644))
+
+	cache, err := NewFileCache(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+	calls := 0
+	compile := func(source []byte) (*CompiledScript, error) {
+		calls++
+		return &CompiledScript{Constants: []*values.Value{values.NewString(string(source))}}, nil
+	}
+
+	_, err = cache.Load(file, compile)
+	require.NoError(t, err)
+
+	// A second cache over the same directory stands in for a new process
+	reopened, err := NewFileCache(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+	cached, err := reopened.Load(file, compile)
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+	require.Equal(t, "<?php echo 1;", cached.Constants[0].ToString())
+	require.NotEmpty(t, cached.Bytecode)
+
+	require.NoError(t, os.WriteFile(file, []byte("<?php echo 2;"), 0644))
+	changed, err := reopened.Load(file, compile)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+	require.Equal(t, "<?php echo 2;", changed.Constants[0].ToString())
+
+	// Entries from another interpreter build are recompiled
+	reopened.interpreterVersion = "other"
+	_, err = reopened.Load(file, compile)
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	// Corrupt entries are recompiled and overwritten
+	require.NoError(t, os.WriteFile(reopened.entryPath(file), []byte("garbage"), 0644))
+	_, err = reopened.Load(file, compile)
+	require.NoError(t, err)
+	require.Equal(t, 4, calls)
+	_, err = reopened.Load(file, compile)
+	require.NoError(t, err)
+	require.Equal(t, 4, calls)
+
+	stats := reopened.Stats()
+	require.Equal(t, uint64(2), stats["hits"])
+	require.Equal(t, uint64(3), stats["misses"])
+}
+
+func TestFileCacheCompileOptions(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "index.php")
+	require.NoError(t, os.WriteFile(file, []byte("<?php echo 1;"), 0644))
+
+	cache, err := NewFileCache(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+	calls := 0
+	compile := func(source []byte) (*CompiledScript, error) {
+		calls++
+		return &CompiledScript{Constants: []*values.Value{values.NewInt(int64(calls))}}, nil
+	}
+
+	plain, err := cache.Load(file, compile)
+	require.NoError(t, err)
+	require.Empty(t, plain.CompileOptions)
+
+	// Optimized compilations get an entry of their own
+	cache.SetCompileOptions("optimize")
+	optimized, err := cache.Load(file, compile)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+	require.Equal(t, "optimize", optimized.CompileOptions)
+	optimizedEntry := cache.entryPath(file)
+
+	cache.SetCompileOptions("")
+	require.NotEqual(t, optimizedEntry, cache.entryPath(file))
+	cached, err := cache.Load(file, compile)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+	require.Equal(t, int64(1), cached.Constants[0].ToInt())
+
+	// An entry whose header names other options is not loaded
+	data, err := os.ReadFile(optimizedEntry)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(cache.entryPath(file), data, 0644))
+	recompiled, err := cache.Load(file, compile)
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	require.Equal(t, int64(3), recompiled.Constants[0].ToInt())
+}
+
+func TestFileCacheSkipsParseErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "broken.php")
+	require.NoError(t, os.WriteFile(file, []byte("<?php echo"), 0644))
+
+	cache, err := NewFileCache(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+	calls := 0
+	for i := 0; i < 2; i++ {
+		script, err := cache.Load(file, countingCompiler(&calls))
+		require.NoError(t, err)
+		require.NotEmpty(t, script.ParseErrors)
+	}
+	require.Equal(t, 2, calls)
+
+	entries, err := os.ReadDir(filepath.Join(dir, "cache"))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
@@ -5,20 +5,32 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/wudi/hey/opcodes"
 	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
 )
 
+// CompiledScript is the compiler output for one file. Cached scripts are
+// shared by concurrent requests and must be treated as read-only.
 type CompiledScript struct {
-	Bytecode   []byte
-	Constants  []*registry.Constant
-	Functions  map[string]*registry.Function
-	Classes    map[string]*registry.Class
-	Interfaces map[string]*registry.Interface
-	Traits     map[string]*registry.Trait
-	Timestamp  time.Time
-	FileHash   string
+	Bytecode     []byte
+	Instructions []*opcodes.Instruction
+	Constants    []*values.Value
+	Functions    map[string]*registry.Function
+	Classes      map[string]*registry.Class
+	Interfaces   map[string]*registry.Interface
+	Traits       map[string]*registry.Trait
+	// ParseErrors holds the parser errors reported while compiling the file
+	ParseErrors []string
+	Timestamp   time.Time
+	Size        int64
+	FileHash    string
+	// CompileOptions names the compiler settings, such as optimization,
+	// the script was compiled with
+	CompileOptions string
 }
 
 type OpcacheManager struct {
@@ -27,6 +39,8 @@ type OpcacheManager struct {
 	enabled    bool
 	maxEntries int
 	validateTimestamps bool
+	hits       atomic.Uint64
+	misses     atomic.Uint64
 }
 
 type OpcacheConfig struct {
@@ -50,33 +64,85 @@ func (o *OpcacheManager) Get(file string) (*CompiledScript, bool) {
 	}
 
 	o.mu.RLock()
-	defer o.mu.RUnlock()
-
 	cached, ok := o.cache[file]
+	o.mu.RUnlock()
 	if !ok {
+		o.misses.Add(1)
 		return nil, false
 	}
 
-	if o.validateTimestamps {
-		stat, err := os.Stat(file)
-		if err != nil {
-			delete(o.cache, file)
-			return nil, false
-		}
+	if o.validateTimestamps && !o.revalidate(file, cached) {
+		o.Invalidate(file)
+		o.misses.Add(1)
+		return nil, false
+	}
 
-		if stat.ModTime().After(cached.Timestamp) {
-			delete(o.cache, file)
-			return nil, false
-		}
+	o.hits.Add(1)
+	return cached, true
+}
 
-		hash, err := computeFileHash(file)
-		if err != nil || hash != cached.FileHash {
-			delete(o.cache, file)
-			return nil, false
-		}
+// revalidate reports whether cached still matches the file on disk. An
+// unchanged mtime and size is trusted; otherwise the content hash decides,
+// so touching a file does not force a recompile.
+func (o *OpcacheManager) revalidate(file string, cached *CompiledScript) bool {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return false
+	}
+	o.mu.RLock()
+	unchanged := stat.ModTime().Equal(cached.Timestamp) && stat.Size() == cached.Size
+	o.mu.RUnlock()
+	if unchanged {
+		return true
 	}
 
-	return cached, true
+	hash, err := computeFileHash(file)
+	if err != nil || hash != cached.FileHash {
+		return false
+	}
+
+	o.mu.Lock()
+	cached.Timestamp = stat.ModTime()
+	cached.Size = stat.Size()
+	o.mu.Unlock()
+	return true
+}
+
+// Load returns the compiled form of file, calling compile with the file's
+// source when it is not cached or has changed since it was cached.
+func (o *OpcacheManager) Load(file string, compile func(source []byte) (*CompiledScript, error)) (*CompiledScript, error) {
+	if cached, ok := o.Get(file); ok {
+		return cached, nil
+	}
+
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := compile(source)
+	if err != nil {
+		return nil, err
+	}
+	if !o.enabled {
+		return compiled, nil
+	}
+
+	compiled.Timestamp = stat.ModTime()
+	compiled.Size = int64(len(source))
+	compiled.FileHash = hashBytes(source)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, exists := o.cache[file]; !exists && o.maxEntries > 0 && len(o.cache) >= o.maxEntries {
+		o.evictOldest()
+	}
+	o.cache[file] = compiled
+	return compiled, nil
 }
 
 func (o *OpcacheManager) Set(file string, compiled *CompiledScript) {
@@ -102,6 +168,7 @@ func (o *OpcacheManager) Set(file string, compiled *CompiledScript) {
 	}
 
 	compiled.Timestamp = stat.ModTime()
+	compiled.Size = stat.Size()
 	compiled.FileHash = hash
 
 	o.cache[file] = compiled
@@ -130,6 +197,8 @@ func (o *OpcacheManager) Stats() map[string]interface{} {
 		"cached_scripts":      len(o.cache),
 		"max_cached_scripts":  o.maxEntries,
 		"validate_timestamps": o.validateTimestamps,
+		"hits":                o.hits.Load(),
+		"misses":              o.misses.Load(),
 	}
 }
 
@@ -155,8 +224,12 @@ func computeFileHash(file string) (string, error) {
 		return "", err
 	}
 
+	return hashBytes(data), nil
+}
+
+func hashBytes(data []byte) string {
 	hash := sha256.Sum256(data)
-	return fmt.Sprintf("%x", hash), nil
+	return fmt.Sprintf("%x", hash)
 }
 
 var GlobalOpcache *OpcacheManager
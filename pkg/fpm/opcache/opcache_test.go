@@ -0,0 +1,94 @@
+package opcache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func countingCompiler(calls *int) func(source []byte) (*CompiledScript, error) {
+	return func(source []byte) (*CompiledScript, error) {
+		*calls++
+		return &CompiledScript{ParseErrors: []string{string(source)}}, nil
+	}
+}
+
+func TestLoadCachesUntilFileChanges(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "index.php")
+	require.NoError(t, os.WriteFile(file, []byte("<?php echo 1;"), 0644))
+
+	cache := NewOpcacheManager(&OpcacheConfig{Enabled: true, MaxEntries: 10, ValidateTimestamps: true})
+	calls := 0
+	compile := countingCompiler(&calls)
+
+	first, err := cache.Load(file, compile)
+	require.NoError(t, err)
+	second, err := cache.Load(file, compile)
+	require.NoError(t, err)
+	require.Same(t, first, second)
+	require.Equal(t, 1, calls)
+
+	// Touching the file without changing it keeps the entry
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(file, later, later))
+	third, err := cache.Load(file, compile)
+	require.NoError(t, err)
+	require.Same(t, first, third)
+	require.Equal(t, 1, calls)
+
+	require.NoError(t, os.WriteFile(file, []byte("<?php echo 2;"), 0644))
+	changed, err := cache.Load(file, compile)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+	require.Equal(t, []string{"<?php echo 2;"}, changed.ParseErrors)
+
+	stats := cache.Stats()
+	require.Equal(t, uint64(2), stats["hits"])
+	require.Equal(t, uint64(2), stats["misses"])
+}
+
+func TestLoadWithoutTimestampValidation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "index.php")
+	require.NoError(t, os.WriteFile(file, []byte("<?php echo 1;"), 0644))
+
+	cache := NewOpcacheManager(&OpcacheConfig{Enabled: true, MaxEntries: 10})
+	calls := 0
+	_, err := cache.Load(file, countingCompiler(&calls))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, []byte("<?php echo 2;"), 0644))
+	cached, err := cache.Load(file, countingCompiler(&calls))
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+	require.Equal(t, []string{"<?php echo 1;"}, cached.ParseErrors)
+
+	cache.Invalidate(file)
+	_, err = cache.Load(file, countingCompiler(&calls))
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+}
+
+func TestLoadDisabledOrFailing(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "index.php")
+	require.NoError(t, os.WriteFile(file, []byte("<?php"), 0644))
+
+	disabled := NewOpcacheManager(&OpcacheConfig{Enabled: false, MaxEntries: 10})
+	calls := 0
+	for i := 0; i < 2; i++ {
+		_, err := disabled.Load(file, countingCompiler(&calls))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, calls)
+
+	cache := NewOpcacheManager(&OpcacheConfig{Enabled: true, MaxEntries: 10})
+	boom := errors.New("boom")
+	_, err := cache.Load(file, func([]byte) (*CompiledScript, error) { return nil, boom })
+	require.ErrorIs(t, err, boom)
+	require.Equal(t, 0, cache.Stats()["cached_scripts"])
+
+	_, err = cache.Load(filepath.Join(t.TempDir(), "missing.php"), countingCompiler(&calls))
+	require.Error(t, err)
+}
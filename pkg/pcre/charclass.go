@@ -0,0 +1,453 @@
+package pcre
+
+import (
+	"strings"
+	"unicode"
+)
+
+// classItem is a class such as \d or [:alpha:] within a character set
+type classItem struct {
+	in     func(rune) bool
+	negate bool
+}
+
+// charSet is a set of characters, with a bitmap for the first 256
+type charSet struct {
+	low    [4]uint64
+	negate bool
+	fold   bool
+	utf    bool
+	ranges []rune
+	items  []classItem
+}
+
+func (p *parser) newSet() *charSet {
+	return &charSet{fold: p.flags&Caseless != 0, utf: p.utf()}
+}
+
+func (s *charSet) addRange(lo, hi rune) {
+	s.ranges = append(s.ranges, lo, hi)
+}
+
+func (s *charSet) addClass(item classItem) {
+	s.items = append(s.items, item)
+}
+
+func (s *charSet) inRanges(r rune) bool {
+	for i := 0; i < len(s.ranges); i += 2 {
+		if r >= s.ranges[i] && r <= s.ranges[i+1] {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether r is in the set, working it out from the ranges
+// and classes. Caseless matching applies to the ranges, as classes such as
+// \d are the same in either case.
+func (s *charSet) contains(r rune) bool {
+	in := s.inRanges(r)
+	if !in && s.fold {
+		if s.utf {
+			for f := unicode.SimpleFold(r); f != r && !in; f = unicode.SimpleFold(f) {
+				in = s.inRanges(f)
+			}
+		} else if r < 0x80 && (r|0x20) >= 'a' && (r|0x20) <= 'z' {
+			in = s.inRanges(r ^ 0x20)
+		}
+	}
+	for i := 0; !in && i < len(s.items); i++ {
+		in = s.items[i].in(r) != s.items[i].negate
+	}
+	return in != s.negate
+}
+
+// finish fills in the bitmap once the set is complete
+func (s *charSet) finish() {
+	for r := rune(0); r < 256; r++ {
+		if s.contains(r) {
+			s.low[r>>6] |= 1 << (r & 63)
+		}
+	}
+}
+
+func (s *charSet) matches(r rune) bool {
+	if r < 256 {
+		return s.low[r>>6]&(1<<(r&63)) != 0
+	}
+	return s.contains(r)
+}
+
+func isASCIIDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isASCIIWord(r rune) bool {
+	return r < 0x80 && (isAlnum(byte(r)) || r == '_')
+}
+
+func isASCIISpace(r rune) bool {
+	return r == ' ' || r >= '\t' && r <= '\r'
+}
+
+func isUnicodeWord(r rune) bool {
+	return r == '_' || unicode.In(r, unicode.L, unicode.N, unicode.Mn, unicode.Pc)
+}
+
+func isUnicodeSpace(r rune) bool {
+	return isASCIISpace(r) || unicode.In(r, unicode.Z) || r == 0x85 || r == 0x180E
+}
+
+func isHSpace(r rune) bool {
+	switch r {
+	case '\t', ' ', 0xA0, 0x1680, 0x180E, 0x202F, 0x205F, 0x3000:
+		return true
+	}
+	return r >= 0x2000 && r <= 0x200A
+}
+
+func isVSpace(r rune) bool {
+	return r >= '\n' && r <= '\r' || r == 0x85 || r == 0x2028 || r == 0x2029
+}
+
+// isWordChar is the test \b and \B use
+func isWordChar(r rune, ucp bool) bool {
+	if ucp {
+		return isUnicodeWord(r)
+	}
+	return isASCIIWord(r)
+}
+
+// escapeClass returns the class for \d, \w, \s, \h or \v, or their negations
+// in upper case. In UTF mode \d, \w and \s use Unicode properties, as PHP's
+// u modifier also sets PCRE2_UCP.
+func escapeClass(c byte, ucp bool) classItem {
+	var in func(rune) bool
+	switch c | 0x20 {
+	case 'd':
+		in = isASCIIDigit
+		if ucp {
+			in = func(r rune) bool { return unicode.Is(unicode.Nd, r) }
+		}
+	case 'w':
+		in = isASCIIWord
+		if ucp {
+			in = isUnicodeWord
+		}
+	case 's':
+		in = isASCIISpace
+		if ucp {
+			in = isUnicodeSpace
+		}
+	case 'h':
+		in = isHSpace
+	case 'v':
+		in = isVSpace
+	}
+	return classItem{in: in, negate: c < 'a'}
+}
+
+// posixClass returns the class for a [:name:] class name
+func posixClass(name string, ucp bool) (func(rune) bool, bool) {
+	switch name {
+	case "alpha":
+		if ucp {
+			return unicode.IsLetter, true
+		}
+		return func(r rune) bool { return r < 0x80 && unicode.IsLetter(r) }, true
+	case "alnum":
+		if ucp {
+			return func(r rune) bool { return unicode.In(r, unicode.L, unicode.N) }, true
+		}
+		return func(r rune) bool { return r < 0x80 && isAlnum(byte(r)) }, true
+	case "ascii":
+		return func(r rune) bool { return r < 0x80 }, true
+	case "blank":
+		if ucp {
+			return isHSpace, true
+		}
+		return func(r rune) bool { return r == ' ' || r == '\t' }, true
+	case "cntrl":
+		return func(r rune) bool { return unicode.Is(unicode.Cc, r) && (ucp || r < 0x80) }, true
+	case "digit":
+		if ucp {
+			return func(r rune) bool { return unicode.Is(unicode.Nd, r) }, true
+		}
+		return isASCIIDigit, true
+	case "graph":
+		if ucp {
+			return func(r rune) bool { return unicode.IsGraphic(r) && !unicode.In(r, unicode.Z) }, true
+		}
+		return func(r rune) bool { return r > ' ' && r < 0x7F }, true
+	case "lower":
+		if ucp {
+			return unicode.IsLower, true
+		}
+		return func(r rune) bool { return r >= 'a' && r <= 'z' }, true
+	case "print":
+		if ucp {
+			return func(r rune) bool { return unicode.IsGraphic(r) && !unicode.In(r, unicode.Zl, unicode.Zp) }, true
+		}
+		return func(r rune) bool { return r >= ' ' && r < 0x7F }, true
+	case "punct":
+		if ucp {
+			return func(r rune) bool {
+				return unicode.IsPunct(r) || r < 0x80 && unicode.IsSymbol(r)
+			}, true
+		}
+		return func(r rune) bool { return r > ' ' && r < 0x7F && !isAlnum(byte(r)) }, true
+	case "space":
+		if ucp {
+			return isUnicodeSpace, true
+		}
+		return isASCIISpace, true
+	case "upper":
+		if ucp {
+			return unicode.IsUpper, true
+		}
+		return func(r rune) bool { return r >= 'A' && r <= 'Z' }, true
+	case "word":
+		if ucp {
+			return isUnicodeWord, true
+		}
+		return isASCIIWord, true
+	case "xdigit":
+		return func(r rune) bool { return r < 0x80 && isHex(byte(r)) }, true
+	}
+	return nil, false
+}
+
+func isUnassigned(r rune) bool {
+	for _, table := range unicode.Categories {
+		if unicode.Is(table, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// propertyClass returns the class for a \p name: a general category, a
+// script, or one of PCRE's special properties
+func propertyClass(name string) (func(rune) bool, bool) {
+	switch name {
+	case "Any":
+		return func(rune) bool { return true }, true
+	case "L&", "LC":
+		return func(r rune) bool { return unicode.In(r, unicode.Lu, unicode.Ll, unicode.Lt) }, true
+	case "Xan":
+		return func(r rune) bool { return unicode.In(r, unicode.L, unicode.N) }, true
+	case "Xps", "Xsp":
+		return isUnicodeSpace, true
+	case "Xwd":
+		return func(r rune) bool { return r == '_' || unicode.In(r, unicode.L, unicode.N) }, true
+	case "Cn":
+		return isUnassigned, true
+	case "C":
+		return func(r rune) bool { return unicode.Is(unicode.C, r) || isUnassigned(r) }, true
+	}
+	if table, ok := unicode.Categories[name]; ok {
+		return func(r rune) bool { return unicode.Is(table, r) }, true
+	}
+	if table, ok := unicode.Scripts[name]; ok {
+		return func(r rune) bool { return unicode.Is(table, r) }, true
+	}
+	// Script names are matched loosely
+	loose := strings.NewReplacer("_", "", " ", "", "-", "").Replace(strings.ToLower(name))
+	for script, table := range unicode.Scripts {
+		if strings.ToLower(strings.ReplaceAll(script, "_", "")) == loose {
+			return func(r rune) bool { return unicode.Is(table, r) }, true
+		}
+	}
+	return nil, false
+}
+
+// parseProperty parses \p{name}, \pL and their \P negations, positioned at
+// the p
+func (p *parser) parseProperty() (classItem, error) {
+	start := p.pos - 1
+	negate := p.src[p.pos] == 'P'
+	p.pos++
+	if !p.more() {
+		return classItem{}, p.errorAt(start, "malformed \\P or \\p sequence")
+	}
+	var name string
+	if p.src[p.pos] == '{' {
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return classItem{}, p.errorAt(start, "malformed \\P or \\p sequence")
+		}
+		name = p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		if strings.HasPrefix(name, "^") {
+			negate = !negate
+			name = name[1:]
+		}
+	} else {
+		name = p.src[p.pos : p.pos+1]
+		p.pos++
+	}
+	in, ok := propertyClass(name)
+	if !ok {
+		return classItem{}, p.errorAt(p.pos, "unknown property after \\P or \\p")
+	}
+	return classItem{in: in, negate: negate}, nil
+}
+
+// parseClass parses a [...] character class
+func (p *parser) parseClass() (*node, error) {
+	p.pos++
+	set := p.newSet()
+	if p.peek("^") {
+		set.negate = true
+		p.pos++
+	}
+	first := true
+	quoting := false
+	for {
+		if !p.more() {
+			return nil, p.errorAt(len(p.src), "missing terminating ] for character class")
+		}
+		if quoting {
+			if p.peek("\\E") {
+				quoting = false
+				p.pos += 2
+				continue
+			}
+		} else {
+			if p.peek("]") && !first {
+				p.pos++
+				break
+			}
+			if p.peek("\\Q") {
+				quoting = true
+				p.pos += 2
+				continue
+			}
+			if p.peek("\\E") {
+				p.pos += 2
+				continue
+			}
+		}
+		first = false
+
+		lo, item, err := p.classAtom(quoting)
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			set.addClass(*item)
+			continue
+		}
+		if p.peek("-") && p.pos+1 < len(p.src) && p.src[p.pos+1] != ']' {
+			save := p.pos
+			p.pos++
+			if p.peek("\\Q") {
+				quoting = true
+				p.pos += 2
+			}
+			hi, item, err := p.classAtom(quoting)
+			if err != nil {
+				return nil, err
+			}
+			if item != nil {
+				if p.src[save+1] == '[' {
+					// A POSIX class after the hyphen, which is then a literal
+					set.addRange(lo, lo)
+					set.addRange('-', '-')
+					set.addClass(*item)
+					continue
+				}
+				return nil, p.errorAt(save+1, "invalid range in character class")
+			}
+			if hi < lo {
+				return nil, p.errorAt(save+1, "range out of order in character class")
+			}
+			set.addRange(lo, hi)
+			continue
+		}
+		set.addRange(lo, lo)
+	}
+	set.finish()
+	return &node{op: opChar, set: set}, nil
+}
+
+// classAtom parses a character or a class such as \d or [:alpha:] within a
+// character class
+func (p *parser) classAtom(quoting bool) (rune, *classItem, error) {
+	if quoting {
+		r, w := p.next()
+		p.pos += w
+		return r, nil, nil
+	}
+	switch {
+	case p.peek("[:") || p.peek("[.") || p.peek("[="):
+		delim := p.src[p.pos+1]
+		end := strings.Index(p.src[p.pos+2:], string(delim)+"]")
+		if end >= 0 && isPosixName(p.src[p.pos+2:p.pos+2+end]) {
+			if delim != ':' {
+				return 0, nil, p.errorf("POSIX collating elements are not supported")
+			}
+			name := p.src[p.pos+2 : p.pos+2+end]
+			negate := strings.HasPrefix(name, "^")
+			in, ok := posixClass(strings.TrimPrefix(name, "^"), p.utf())
+			if !ok {
+				return 0, nil, p.errorf("unknown POSIX class name")
+			}
+			p.pos += end + 4
+			return 0, &classItem{in: in, negate: negate}, nil
+		}
+	case p.peek("\\"):
+		escape := p.pos
+		p.pos++
+		if !p.more() {
+			return 0, nil, p.errorAt(escape, "\\ at end of pattern")
+		}
+		switch c := p.src[p.pos]; c {
+		case 'd', 'D', 'w', 'W', 's', 'S', 'h', 'H', 'v', 'V':
+			p.pos++
+			item := escapeClass(c, p.utf())
+			return 0, &item, nil
+		case 'p', 'P':
+			item, err := p.parseProperty()
+			if err != nil {
+				return 0, nil, err
+			}
+			return 0, &item, nil
+		case 'b':
+			p.pos++
+			return '\b', nil, nil
+		case 'N', 'B', 'R', 'X', 'g', 'k', 'K', 'A', 'z', 'Z', 'G', 'C':
+			return 0, nil, p.errorAt(p.pos, "escape sequence is invalid in character class")
+		case '1', '2', '3', '4', '5', '6', '7':
+			// Octal, as there are no backreferences in a class
+			value := 0
+			for i := 0; i < 3 && p.more() && p.src[p.pos] >= '0' && p.src[p.pos] <= '7'; i++ {
+				value = value*8 + int(p.src[p.pos]-'0')
+				p.pos++
+			}
+			r, err := p.checkCodePoint(rune(value), escape)
+			return r, nil, err
+		case '8', '9':
+			p.pos++
+			return rune(c), nil, nil
+		}
+		r, err := p.parseCharEscape(escape)
+		return r, nil, err
+	}
+	r, w := p.next()
+	p.pos += w
+	return r, nil, nil
+}
+
+func isPosixName(name string) bool {
+	name = strings.TrimPrefix(name, "^")
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < 'a' || name[i] > 'z' {
+			return false
+		}
+	}
+	return true
+}
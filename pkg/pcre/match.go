@@ -0,0 +1,644 @@
+package pcre
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// verb is a backtracking control verb that was backtracked into, which
+// stops the search as it unwinds
+type verb uint8
+
+const (
+	verbNone verb = iota
+	verbCommit
+	verbPrune
+	verbSkip
+	verbThen
+)
+
+// maxDepth bounds the nesting of a match whatever its depth limit, to keep
+// clear of the goroutine stack limit
+const maxDepth = 250000
+
+// call is a subroutine call in progress
+type call struct {
+	group int
+	pos   int
+}
+
+// matcher holds the state of one match. Patterns are matched by recursive
+// descent with continuations: each node calls k with the position after
+// it, and returning false backtracks into the node for its next choice.
+type matcher struct {
+	re      *Regexp
+	subject string
+	utf     bool
+	caps    []int
+	start   int
+	keep    int
+
+	steps      int
+	limit      int
+	depth      int
+	depthLimit int
+	err        error
+
+	verb   verb
+	skipTo int
+	// accept is where (*ACCEPT) ends the match, changed by assertions and
+	// subroutine calls
+	accept func(int) bool
+	calls  []call
+}
+
+func (m *matcher) charWidth(pos int) int {
+	if m.utf {
+		_, w := utf8.DecodeRuneInString(m.subject[pos:])
+		return w
+	}
+	return 1
+}
+
+// decode returns the character at pos and its width, or a width of 0 at the
+// end of the subject
+func (m *matcher) decode(pos int) (rune, int) {
+	if pos >= len(m.subject) {
+		return 0, 0
+	}
+	if m.utf {
+		return utf8.DecodeRuneInString(m.subject[pos:])
+	}
+	return rune(m.subject[pos]), 1
+}
+
+// decodeLast returns the character before pos and its width
+func (m *matcher) decodeLast(pos int) (rune, int) {
+	if pos <= 0 {
+		return 0, 0
+	}
+	if m.utf {
+		return utf8.DecodeLastRuneInString(m.subject[:pos])
+	}
+	return rune(m.subject[pos-1]), 1
+}
+
+func (m *matcher) equalFold(a, b rune) bool {
+	if a == b {
+		return true
+	}
+	if !m.utf {
+		return a < 0x80 && b < 0x80 && a^0x20 == b && (a|0x20) >= 'a' && (a|0x20) <= 'z'
+	}
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFold matches the characters of text caselessly at pos, returning the
+// position after them or -1
+func (m *matcher) matchFold(text []rune, pos int) int {
+	for _, r := range text {
+		c, w := m.decode(pos)
+		if w == 0 || !m.equalFold(r, c) {
+			return -1
+		}
+		pos += w
+	}
+	return pos
+}
+
+func (m *matcher) saveCaps() []int {
+	return append([]int(nil), m.caps...)
+}
+
+// stopped reports whether the search is over: an error occurred or a verb
+// is unwinding it
+func (m *matcher) stopped() bool {
+	return m.err != nil || m.verb != verbNone
+}
+
+// enter counts a level of nesting, failing the match past the depth limit
+func (m *matcher) enter() bool {
+	m.depth++
+	if m.depth > maxDepth || m.depthLimit > 0 && m.depth > m.depthLimit {
+		m.err = ErrDepthLimit
+		return false
+	}
+	return true
+}
+
+func (m *matcher) match(n *node, pos int, k func(int) bool) bool {
+	if m.stopped() {
+		return false
+	}
+	m.steps++
+	if m.limit > 0 && m.steps > m.limit {
+		m.err = ErrMatchLimit
+		return false
+	}
+
+	switch n.op {
+	case opEmpty:
+		return k(pos)
+	case opLiteral:
+		if !n.fold {
+			if strings.HasPrefix(m.subject[pos:], n.lit) {
+				return k(pos + len(n.lit))
+			}
+			return false
+		}
+		if end := m.matchFold(n.runes, pos); end >= 0 {
+			return k(end)
+		}
+		return false
+	case opChar:
+		if r, w := m.decode(pos); w > 0 && n.set.matches(r) {
+			return k(pos + w)
+		}
+		return false
+	case opByte:
+		return pos < len(m.subject) && k(pos+1)
+	case opConcat:
+		return m.sequence(n.subs, pos, k)
+	case opAlt:
+		for _, sub := range n.subs {
+			if m.match(sub, pos, k) {
+				return true
+			}
+			if m.verb == verbThen {
+				m.verb = verbNone
+				continue
+			}
+			if m.stopped() {
+				return false
+			}
+		}
+		return false
+	case opRepeat:
+		return m.repeat(n, pos, k)
+	case opCapture:
+		g := 2 * n.group
+		return m.match(n.subs[0], pos, func(p int) bool {
+			start, end := m.caps[g], m.caps[g+1]
+			m.caps[g], m.caps[g+1] = pos, p
+			if k(p) {
+				return true
+			}
+			m.caps[g], m.caps[g+1] = start, end
+			return false
+		})
+	case opAtomic:
+		return m.atomic(n.subs[0], pos, k)
+	case opLookahead, opLookbehind:
+		return m.assert(n, pos, k)
+	case opBackref:
+		return m.backref(n, pos, k)
+	case opRecurse:
+		return m.recurse(n, pos, k)
+	case opCond:
+		return m.cond(n, pos, k)
+	case opStartSubject:
+		return pos == 0 && k(pos)
+	case opStartLine:
+		return (pos == 0 || m.subject[pos-1] == '\n' && pos < len(m.subject)) && k(pos)
+	case opEndSubject:
+		return pos == len(m.subject) && k(pos)
+	case opEndSubjectNewline:
+		return (pos == len(m.subject) || pos == len(m.subject)-1 && m.subject[pos] == '\n') && k(pos)
+	case opEndLine:
+		return (pos == len(m.subject) || m.subject[pos] == '\n') && k(pos)
+	case opStartOffset:
+		return pos == m.start && k(pos)
+	case opWordBoundary, opNotWordBoundary:
+		before, w := m.decodeLast(pos)
+		after, v := m.decode(pos)
+		boundary := (w > 0 && isWordChar(before, n.ucp)) != (v > 0 && isWordChar(after, n.ucp))
+		return boundary == (n.op == opWordBoundary) && k(pos)
+	case opKeep:
+		keep := m.keep
+		m.keep = pos
+		if k(pos) {
+			return true
+		}
+		m.keep = keep
+		return false
+	case opNewline:
+		if strings.HasPrefix(m.subject[pos:], "\r\n") {
+			return k(pos + 2)
+		}
+		if r, w := m.decode(pos); w > 0 && (isVSpace(r) && (m.utf || r < 0x100)) {
+			return k(pos + w)
+		}
+		return false
+	case opGrapheme:
+		return m.grapheme(pos, k)
+	case opFail:
+		return false
+	case opAccept:
+		return m.accept(pos)
+	case opCommit:
+		return m.backtrackVerb(verbCommit, pos, k)
+	case opPrune:
+		return m.backtrackVerb(verbPrune, pos, k)
+	case opSkip:
+		return m.backtrackVerb(verbSkip, pos, k)
+	case opThen:
+		return m.backtrackVerb(verbThen, pos, k)
+	}
+	return false
+}
+
+func (m *matcher) sequence(subs []*node, pos int, k func(int) bool) bool {
+	if len(subs) == 1 {
+		return m.match(subs[0], pos, k)
+	}
+	return m.match(subs[0], pos, func(p int) bool {
+		return m.sequence(subs[1:], p, k)
+	})
+}
+
+// backtrackVerb matches a verb, which takes effect when the rest of the
+// pattern fails and backtracks into it
+func (m *matcher) backtrackVerb(v verb, pos int, k func(int) bool) bool {
+	if k(pos) {
+		return true
+	}
+	if !m.stopped() {
+		m.verb = v
+		m.skipTo = pos
+	}
+	return false
+}
+
+// atomic matches sub once, without backtracking into it
+func (m *matcher) atomic(sub *node, pos int, k func(int) bool) bool {
+	caps, keep := m.saveCaps(), m.keep
+	end := -1
+	if !m.match(sub, pos, func(p int) bool {
+		end = p
+		return true
+	}) {
+		return false
+	}
+	if k(end) {
+		return true
+	}
+	copy(m.caps, caps)
+	m.keep = keep
+	return false
+}
+
+func (m *matcher) assert(n *node, pos int, k func(int) bool) bool {
+	caps, keep, accept := m.saveCaps(), m.keep, m.accept
+	matched := false
+	if n.op == opLookahead {
+		m.accept = func(int) bool { return true }
+		matched = m.match(n.subs[0], pos, m.accept)
+	} else {
+		for length := n.min; length <= n.max && !matched; length++ {
+			from := pos
+			for i := 0; i < length && from >= 0; i++ {
+				if _, w := m.decodeLast(from); w > 0 {
+					from -= w
+				} else {
+					from = -1
+				}
+			}
+			if from < 0 {
+				break
+			}
+			m.accept = func(p int) bool { return p == pos }
+			matched = m.match(n.subs[0], from, m.accept)
+		}
+	}
+	m.accept, m.keep = accept, keep
+	if m.err != nil {
+		return false
+	}
+	// Verbs inside an assertion only end the assertion
+	if m.verb != verbNone {
+		m.verb = verbNone
+		matched = false
+	}
+
+	if matched == n.negate {
+		copy(m.caps, caps)
+		return false
+	}
+	if n.negate {
+		return k(pos)
+	}
+	if k(pos) {
+		return true
+	}
+	copy(m.caps, caps)
+	return false
+}
+
+func (m *matcher) backref(n *node, pos int, k func(int) bool) bool {
+	g := -1
+	for _, group := range n.groups {
+		if m.caps[2*group+1] >= 0 {
+			g = group
+			break
+		}
+	}
+	if g < 0 {
+		return false
+	}
+	text := m.subject[m.caps[2*g]:m.caps[2*g+1]]
+	if !n.fold {
+		return strings.HasPrefix(m.subject[pos:], text) && k(pos+len(text))
+	}
+	var runes []rune
+	if m.utf {
+		runes = []rune(text)
+	} else {
+		runes = make([]rune, len(text))
+		for i := 0; i < len(text); i++ {
+			runes[i] = rune(text[i])
+		}
+	}
+	end := m.matchFold(runes, pos)
+	return end >= 0 && k(end)
+}
+
+// recurse calls a group, or the whole pattern, as a subroutine. Groups
+// captured inside the call revert once it returns.
+func (m *matcher) recurse(n *node, pos int, k func(int) bool) bool {
+	for _, c := range m.calls {
+		if c.group == n.group && c.pos == pos {
+			// Calling again without moving on would loop forever
+			return false
+		}
+	}
+	if !m.enter() {
+		return false
+	}
+	defer func() { m.depth-- }()
+
+	body := m.re.root
+	if n.group > 0 {
+		body = m.re.groups[n.group].subs[0]
+	}
+	caps, accept := m.saveCaps(), m.accept
+	m.calls = append(m.calls, call{group: n.group, pos: pos})
+	var ret func(int) bool
+	ret = func(p int) bool {
+		inner := m.saveCaps()
+		top := m.calls[len(m.calls)-1]
+		copy(m.caps, caps)
+		m.calls = m.calls[:len(m.calls)-1]
+		m.accept = accept
+		if k(p) {
+			return true
+		}
+		m.accept = ret
+		m.calls = append(m.calls, top)
+		copy(m.caps, inner)
+		return false
+	}
+	m.accept = ret
+	matched := m.match(body, pos, ret)
+	if !matched {
+		m.calls = m.calls[:len(m.calls)-1]
+		m.accept = accept
+	}
+	return matched
+}
+
+func (m *matcher) cond(n *node, pos int, k func(int) bool) bool {
+	yes := false
+	switch n.cond {
+	case condGroup:
+		for _, g := range n.groups {
+			if m.caps[2*g+1] >= 0 {
+				yes = true
+			}
+		}
+	case condRecursion:
+		if len(m.calls) > 0 {
+			yes = n.group < 0 || m.calls[len(m.calls)-1].group == n.group
+		}
+	case condDefine:
+		return k(pos)
+	case condAssert:
+		yes = m.match(n.condNode, pos, func(int) bool { return true })
+		if m.err != nil {
+			return false
+		}
+	}
+	if yes {
+		return m.match(n.subs[0], pos, k)
+	}
+	if len(n.subs) == 2 {
+		return m.match(n.subs[1], pos, k)
+	}
+	return k(pos)
+}
+
+// grapheme matches \X: a character and the combining marks after it, or a
+// CRLF pair
+func (m *matcher) grapheme(pos int, k func(int) bool) bool {
+	if strings.HasPrefix(m.subject[pos:], "\r\n") {
+		return k(pos + 2)
+	}
+	_, w := m.decode(pos)
+	if w == 0 {
+		return false
+	}
+	pos += w
+	for m.utf {
+		r, w := m.decode(pos)
+		if w == 0 || !unicode.In(r, unicode.M) && r != 0x200D {
+			break
+		}
+		pos += w
+	}
+	return k(pos)
+}
+
+// single matches a repeat of one character at pos, returning its width or
+// -1
+func (m *matcher) single(n *node, pos int) int {
+	switch n.op {
+	case opChar:
+		if r, w := m.decode(pos); w > 0 && n.set.matches(r) {
+			return w
+		}
+	case opByte:
+		if pos < len(m.subject) {
+			return 1
+		}
+	case opLiteral:
+		if !n.fold {
+			if strings.HasPrefix(m.subject[pos:], n.lit) {
+				return len(n.lit)
+			}
+		} else if end := m.matchFold(n.runes, pos); end >= 0 {
+			return end - pos
+		}
+	}
+	return -1
+}
+
+func isSingle(n *node) bool {
+	return n.op == opChar || n.op == opByte || n.op == opLiteral && len(n.runes) == 1
+}
+
+func (m *matcher) repeat(n *node, pos int, k func(int) bool) bool {
+	sub := n.subs[0]
+	if isSingle(sub) {
+		if n.greedy || n.possessive {
+			return m.repeatSingleGreedy(n, sub, pos, k)
+		}
+		return m.repeatSingleLazy(n, sub, pos, k)
+	}
+	if n.possessive {
+		caps := m.saveCaps()
+		end := -1
+		if !m.greedy(n, pos, 0, func(p int) bool {
+			end = p
+			return true
+		}) {
+			return false
+		}
+		if k(end) {
+			return true
+		}
+		copy(m.caps, caps)
+		return false
+	}
+	if n.greedy {
+		return m.greedy(n, pos, 0, k)
+	}
+	return m.lazy(n, pos, 0, k)
+}
+
+func (m *matcher) repeatSingleGreedy(n, sub *node, pos int, k func(int) bool) bool {
+	p, count := pos, 0
+	var widths []int
+	for n.max < 0 || count < n.max {
+		w := m.single(sub, p)
+		if w < 0 {
+			break
+		}
+		if sub.op == opByte {
+			widths = append(widths, w)
+		}
+		p += w
+		count++
+	}
+	if count < n.min {
+		return false
+	}
+	if n.possessive {
+		return k(p)
+	}
+	for {
+		if k(p) {
+			return true
+		}
+		if m.stopped() || count == n.min {
+			return false
+		}
+		m.steps++
+		if m.limit > 0 && m.steps > m.limit {
+			m.err = ErrMatchLimit
+			return false
+		}
+		count--
+		if widths != nil {
+			p -= widths[count]
+		} else if sub.op == opLiteral && !sub.fold {
+			p -= len(sub.lit)
+		} else {
+			_, w := m.decodeLast(p)
+			p -= w
+		}
+	}
+}
+
+func (m *matcher) repeatSingleLazy(n, sub *node, pos int, k func(int) bool) bool {
+	p := pos
+	for count := 0; count < n.min; count++ {
+		w := m.single(sub, p)
+		if w < 0 {
+			return false
+		}
+		p += w
+	}
+	for count := n.min; ; count++ {
+		if k(p) {
+			return true
+		}
+		if m.stopped() || n.max >= 0 && count >= n.max {
+			return false
+		}
+		w := m.single(sub, p)
+		if w < 0 {
+			return false
+		}
+		m.steps++
+		if m.limit > 0 && m.steps > m.limit {
+			m.err = ErrMatchLimit
+			return false
+		}
+		p += w
+	}
+}
+
+// greedy matches as many iterations of a repeat as it can before trying
+// the rest of the pattern. An iteration that matches nothing ends the loop.
+func (m *matcher) greedy(n *node, pos, count int, k func(int) bool) bool {
+	if n.max < 0 || count < n.max {
+		if !m.enter() {
+			return false
+		}
+		matched := m.match(n.subs[0], pos, func(p int) bool {
+			if p == pos && count+1 >= n.min {
+				return k(p)
+			}
+			return m.greedy(n, p, count+1, k)
+		})
+		m.depth--
+		if matched {
+			return true
+		}
+		if m.stopped() {
+			return false
+		}
+	}
+	return count >= n.min && k(pos)
+}
+
+// lazy tries the rest of the pattern before each further iteration
+func (m *matcher) lazy(n *node, pos, count int, k func(int) bool) bool {
+	if count >= n.min {
+		if k(pos) {
+			return true
+		}
+		if m.stopped() {
+			return false
+		}
+	}
+	if n.max >= 0 && count >= n.max {
+		return false
+	}
+	if !m.enter() {
+		return false
+	}
+	matched := m.match(n.subs[0], pos, func(p int) bool {
+		if p == pos && count >= n.min {
+			return false
+		}
+		return m.lazy(n, p, count+1, k)
+	})
+	m.depth--
+	return matched
+}
@@ -0,0 +1,1479 @@
+package pcre
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type opcode uint8
+
+const (
+	opEmpty opcode = iota
+	opLiteral
+	opChar
+	opByte
+	opConcat
+	opAlt
+	opRepeat
+	opCapture
+	opAtomic
+	opLookahead
+	opLookbehind
+	opBackref
+	opRecurse
+	opCond
+	opStartSubject
+	opStartLine
+	opEndSubject
+	opEndSubjectNewline
+	opEndLine
+	opStartOffset
+	opWordBoundary
+	opNotWordBoundary
+	opKeep
+	opNewline
+	opGrapheme
+	opFail
+	opAccept
+	opCommit
+	opPrune
+	opSkip
+	opThen
+)
+
+type condKind uint8
+
+const (
+	condGroup condKind = iota
+	condRecursion
+	condDefine
+	condAssert
+)
+
+// node is a part of a compiled pattern
+type node struct {
+	op   opcode
+	subs []*node
+
+	// lit holds the bytes of a literal, and runes its characters for
+	// caseless matching
+	lit   string
+	runes []rune
+	fold  bool
+	set   *charSet
+	ucp   bool
+
+	// min and max bound a repeat, with -1 for no maximum, or the length
+	// of a lookbehind in characters
+	min, max   int
+	greedy     bool
+	possessive bool
+	negate     bool
+
+	// group is the group captured, referenced or called, and groups the
+	// groups a backreference or condition by name can refer to
+	group  int
+	groups []int
+	name   string
+	digits string
+
+	cond     condKind
+	condNode *node
+
+	offset int
+}
+
+// maxRepeat is the largest count allowed in a {} quantifier
+const maxRepeat = 65535
+
+// maxLookbehind is the longest lookbehind allowed, in characters
+const maxLookbehind = 255
+
+type parser struct {
+	src    string
+	pos    int
+	flags  Flags
+	flags0 Flags
+
+	ncap      int
+	names     []string
+	groups    []*node
+	nameIndex map[string][]int
+	// pending holds the references to resolve once every group is known
+	pending []*node
+
+	matchLimit int
+}
+
+func newParser(src string, flags Flags) *parser {
+	return &parser{
+		src:       src,
+		flags:     flags,
+		names:     []string{""},
+		groups:    []*node{nil},
+		nameIndex: make(map[string][]int),
+	}
+}
+
+func (p *parser) errorAt(offset int, format string, args ...interface{}) error {
+	return &Error{Msg: fmt.Sprintf(format, args...), Offset: offset}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return p.errorAt(p.pos, format, args...)
+}
+
+func (p *parser) utf() bool {
+	return p.flags&UTF != 0
+}
+
+func (p *parser) more() bool {
+	return p.pos < len(p.src)
+}
+
+func (p *parser) peek(s string) bool {
+	return strings.HasPrefix(p.src[p.pos:], s)
+}
+
+// next decodes the character at the current position without consuming it
+func (p *parser) next() (rune, int) {
+	if p.utf() {
+		return utf8.DecodeRuneInString(p.src[p.pos:])
+	}
+	return rune(p.src[p.pos]), 1
+}
+
+func (p *parser) parse() (*node, error) {
+	p.startOptions()
+	if p.utf() && !utf8.ValidString(p.src) {
+		offset := 0
+		for offset < len(p.src) {
+			r, w := utf8.DecodeRuneInString(p.src[offset:])
+			if r == utf8.RuneError && w == 1 {
+				break
+			}
+			offset += w
+		}
+		return nil, p.errorAt(offset, "UTF-8 error: invalid UTF-8 string")
+	}
+	p.flags0 = p.flags
+
+	root, err := p.parseAlt()
+	if err != nil {
+		return nil, err
+	}
+	if p.more() {
+		return nil, p.errorf("unmatched closing parenthesis")
+	}
+	if err := p.resolve(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// startOptions consumes the (*NAME) settings a pattern can start with. Only
+// (*UTF) and (*LIMIT_MATCH=n) change anything, as the others select newline
+// conventions and optimizations.
+func (p *parser) startOptions() {
+	for p.peek("(*") {
+		end := strings.IndexByte(p.src[p.pos:], ')')
+		if end < 0 {
+			return
+		}
+		name := p.src[p.pos+2 : p.pos+end]
+		value := ""
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		switch name {
+		case "UTF", "UTF8":
+			p.flags |= UTF
+		case "UCP", "NO_AUTO_POSSESS", "NO_START_OPT", "NO_DOTSTAR_ANCHOR", "NO_JIT",
+			"NOTEMPTY", "NOTEMPTY_ATSTART", "CR", "LF", "CRLF", "ANYCRLF", "ANY", "NUL",
+			"BSR_ANYCRLF", "BSR_UNICODE":
+		case "LIMIT_MATCH", "LIMIT_DEPTH", "LIMIT_HEAP", "LIMIT_RECURSION":
+			if name == "LIMIT_MATCH" {
+				p.matchLimit, _ = strconv.Atoi(value)
+			}
+		default:
+			return
+		}
+		p.pos += end + 1
+	}
+}
+
+func (p *parser) parseAlt() (*node, error) {
+	var branches []*node
+	for {
+		branch, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, branch)
+		if !p.peek("|") {
+			break
+		}
+		p.pos++
+	}
+	if len(branches) == 1 {
+		return branches[0], nil
+	}
+	return &node{op: opAlt, subs: branches}, nil
+}
+
+func (p *parser) parseConcat() (*node, error) {
+	var items []*node
+	for {
+		p.skipExtended()
+		if !p.more() || p.src[p.pos] == '|' || p.src[p.pos] == ')' {
+			break
+		}
+		atom, err := p.parseAtom()
+		if err != nil {
+			return nil, err
+		}
+		if atom == nil {
+			continue
+		}
+		atom, err = p.parseQuantifier(atom)
+		if err != nil {
+			return nil, err
+		}
+		// Runs of literal characters become one literal
+		if last := len(items) - 1; last >= 0 && atom.op == opLiteral &&
+			items[last].op == opLiteral && items[last].fold == atom.fold {
+			items[last] = &node{op: opLiteral, lit: items[last].lit + atom.lit,
+				runes: append(append([]rune(nil), items[last].runes...), atom.runes...), fold: atom.fold}
+			continue
+		}
+		items = append(items, atom)
+	}
+	switch len(items) {
+	case 0:
+		return &node{op: opEmpty}, nil
+	case 1:
+		return items[0], nil
+	}
+	return &node{op: opConcat, subs: items}, nil
+}
+
+// skipExtended skips whitespace and comments in extended mode
+func (p *parser) skipExtended() {
+	if p.flags&Extended == 0 {
+		return
+	}
+	for p.more() {
+		r, w := p.next()
+		switch {
+		case r == '#':
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+				return
+			}
+			p.pos += end + 1
+		case isPatternSpace(r, p.utf()):
+			p.pos += w
+		default:
+			return
+		}
+	}
+}
+
+func isPatternSpace(r rune, utf bool) bool {
+	switch r {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	case 0x85, 0x200E, 0x200F, 0x2028, 0x2029:
+		return utf
+	}
+	return false
+}
+
+func (p *parser) literal(r rune) *node {
+	var lit string
+	if p.utf() {
+		lit = string(r)
+	} else {
+		lit = string([]byte{byte(r)})
+	}
+	return &node{op: opLiteral, lit: lit, runes: []rune{r}, fold: p.flags&Caseless != 0}
+}
+
+func (p *parser) parseAtom() (*node, error) {
+	start := p.pos
+	r, w := p.next()
+	switch r {
+	case '(':
+		return p.parseGroup()
+	case '[':
+		return p.parseClass()
+	case '.':
+		p.pos++
+		set := p.newSet()
+		set.negate = true
+		if p.flags&DotAll == 0 {
+			set.addRange('\n', '\n')
+		}
+		set.finish()
+		return &node{op: opChar, set: set}, nil
+	case '^':
+		p.pos++
+		if p.flags&Multiline != 0 {
+			return &node{op: opStartLine}, nil
+		}
+		return &node{op: opStartSubject}, nil
+	case '$':
+		p.pos++
+		switch {
+		case p.flags&Multiline != 0:
+			return &node{op: opEndLine}, nil
+		case p.flags&DollarEndOnly != 0:
+			return &node{op: opEndSubject}, nil
+		}
+		return &node{op: opEndSubjectNewline}, nil
+	case '\\':
+		return p.parseEscape()
+	case '*', '+', '?':
+		return nil, p.errorAt(start, "quantifier does not follow a repeatable item")
+	case '{':
+		if _, _, n := p.braces(); n > 0 {
+			return nil, p.errorAt(start, "quantifier does not follow a repeatable item")
+		}
+	}
+	p.pos += w
+	return p.literal(r), nil
+}
+
+// braces parses a {n}, {n,}, {n,m} or {,m} quantifier, returning its
+// length, or 0 when the brace is a literal
+func (p *parser) braces() (min, max, n int) {
+	s := p.src[p.pos:]
+	end := strings.IndexByte(s, '}')
+	if end < 0 {
+		return 0, 0, 0
+	}
+	body := s[1:end]
+	lo, hi, comma := body, "", false
+	if i := strings.IndexByte(body, ','); i >= 0 {
+		lo, hi, comma = body[:i], body[i+1:], true
+	}
+	if !isDigits(lo) && !(comma && lo == "" && isDigits(hi)) {
+		return 0, 0, 0
+	}
+	if comma && hi != "" && !isDigits(hi) {
+		return 0, 0, 0
+	}
+	min = atoiLimit(lo)
+	switch {
+	case !comma:
+		max = min
+	case hi == "":
+		max = -1
+	default:
+		max = atoiLimit(hi)
+	}
+	return min, max, end + 1
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// atoiLimit converts digits, returning a value over maxRepeat for numbers
+// too big to convert
+func atoiLimit(s string) int {
+	if len(s) > 6 {
+		return maxRepeat + 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func (p *parser) parseQuantifier(atom *node) (*node, error) {
+	p.skipExtended()
+	if !p.more() {
+		return atom, nil
+	}
+	start := p.pos
+	var min, max int
+	switch p.src[p.pos] {
+	case '*':
+		min, max = 0, -1
+		p.pos++
+	case '+':
+		min, max = 1, -1
+		p.pos++
+	case '?':
+		min, max = 0, 1
+		p.pos++
+	case '{':
+		var n int
+		if min, max, n = p.braces(); n == 0 {
+			return atom, nil
+		}
+		if min > maxRepeat || max > maxRepeat {
+			return nil, p.errorAt(start+n-1, "number too big in {} quantifier")
+		}
+		if max >= 0 && max < min {
+			return nil, p.errorAt(start+n-1, "numbers out of order in {} quantifier")
+		}
+		p.pos += n
+	default:
+		return atom, nil
+	}
+	if !repeatable(atom) {
+		return nil, p.errorAt(start, "quantifier does not follow a repeatable item")
+	}
+	greedy := p.flags&Ungreedy == 0
+	possessive := false
+	if p.peek("?") {
+		greedy = !greedy
+		p.pos++
+	} else if p.peek("+") {
+		possessive = true
+		p.pos++
+	}
+
+	// A quantifier after \Q...\E applies to the last character only
+	var head *node
+	if atom.op == opLiteral && len(atom.runes) > 1 {
+		last := atom.runes[len(atom.runes)-1]
+		lastLit := atom.lit[len(atom.lit)-utf8.RuneLen(last):]
+		if !p.utf() {
+			lastLit = atom.lit[len(atom.lit)-1:]
+		}
+		head = &node{op: opLiteral, lit: atom.lit[:len(atom.lit)-len(lastLit)],
+			runes: atom.runes[:len(atom.runes)-1], fold: atom.fold}
+		atom = &node{op: opLiteral, lit: lastLit, runes: []rune{last}, fold: atom.fold}
+	}
+	repeat := &node{op: opRepeat, subs: []*node{atom}, min: min, max: max,
+		greedy: greedy, possessive: possessive}
+	if head != nil {
+		return &node{op: opConcat, subs: []*node{head, repeat}}, nil
+	}
+	return repeat, nil
+}
+
+func repeatable(n *node) bool {
+	switch n.op {
+	case opStartSubject, opStartLine, opEndSubject, opEndSubjectNewline, opEndLine,
+		opStartOffset, opWordBoundary, opNotWordBoundary, opKeep,
+		opFail, opAccept, opCommit, opPrune, opSkip, opThen:
+		return false
+	}
+	return true
+}
+
+// parseEscape parses a backslash sequence outside of a character class
+func (p *parser) parseEscape() (*node, error) {
+	start := p.pos
+	p.pos++
+	if !p.more() {
+		return nil, p.errorAt(start, "\\ at end of pattern")
+	}
+	c := p.src[p.pos]
+	switch c {
+	case 'd', 'D', 'w', 'W', 's', 'S', 'h', 'H', 'v', 'V', 'N':
+		p.pos++
+		if c == 'N' && p.peek("{") {
+			return nil, p.errorf("\\N{U+dddd} is supported only in Unicode (UTF) mode")
+		}
+		set := p.newSet()
+		if c == 'N' {
+			set.negate = true
+			set.addRange('\n', '\n')
+		} else {
+			set.addClass(escapeClass(c, p.utf()))
+		}
+		set.finish()
+		return &node{op: opChar, set: set}, nil
+	case 'p', 'P':
+		item, err := p.parseProperty()
+		if err != nil {
+			return nil, err
+		}
+		set := p.newSet()
+		set.addClass(item)
+		set.finish()
+		return &node{op: opChar, set: set}, nil
+	case 'b':
+		p.pos++
+		return &node{op: opWordBoundary, ucp: p.utf()}, nil
+	case 'B':
+		p.pos++
+		return &node{op: opNotWordBoundary, ucp: p.utf()}, nil
+	case 'A':
+		p.pos++
+		return &node{op: opStartSubject}, nil
+	case 'z':
+		p.pos++
+		return &node{op: opEndSubject}, nil
+	case 'Z':
+		p.pos++
+		return &node{op: opEndSubjectNewline}, nil
+	case 'G':
+		p.pos++
+		return &node{op: opStartOffset}, nil
+	case 'K':
+		p.pos++
+		return &node{op: opKeep}, nil
+	case 'R':
+		p.pos++
+		return &node{op: opNewline}, nil
+	case 'X':
+		p.pos++
+		return &node{op: opGrapheme}, nil
+	case 'C':
+		p.pos++
+		return &node{op: opByte}, nil
+	case 'Q':
+		p.pos++
+		end := strings.Index(p.src[p.pos:], "\\E")
+		text := p.src[p.pos:]
+		if end >= 0 {
+			text = text[:end]
+			p.pos += end + 2
+		} else {
+			p.pos = len(p.src)
+		}
+		if text == "" {
+			return nil, nil
+		}
+		lit := &node{op: opLiteral, lit: text, fold: p.flags&Caseless != 0}
+		if p.utf() {
+			lit.runes = []rune(text)
+		} else {
+			for i := 0; i < len(text); i++ {
+				lit.runes = append(lit.runes, rune(text[i]))
+			}
+		}
+		return lit, nil
+	case 'E':
+		p.pos++
+		return nil, nil
+	case 'g':
+		return p.parseGEscape(start)
+	case 'k':
+		p.pos++
+		if !p.more() {
+			return nil, p.errorAt(start, "\\k is not followed by a braced, angle-bracketed, or quoted name")
+		}
+		var close byte
+		switch p.src[p.pos] {
+		case '<':
+			close = '>'
+		case '\'':
+			close = '\''
+		case '{':
+			close = '}'
+		default:
+			return nil, p.errorAt(start, "\\k is not followed by a braced, angle-bracketed, or quoted name")
+		}
+		p.pos++
+		name, err := p.parseName(close)
+		if err != nil {
+			return nil, err
+		}
+		return p.backrefByName(name, start), nil
+	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		end := p.pos
+		for end < len(p.src) && p.src[end] >= '0' && p.src[end] <= '9' {
+			end++
+		}
+		digits := p.src[p.pos:end]
+		p.pos = end
+		n := &node{op: opBackref, fold: p.flags&Caseless != 0, digits: digits, offset: start}
+		p.pending = append(p.pending, n)
+		return n, nil
+	}
+	r, err := p.parseCharEscape(start)
+	if err != nil {
+		return nil, err
+	}
+	return p.literal(r), nil
+}
+
+// parseCharEscape parses an escape standing for a single character, with
+// the position after the backslash
+func (p *parser) parseCharEscape(start int) (rune, error) {
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case 'a':
+		return 0x07, nil
+	case 'e':
+		return 0x1B, nil
+	case 'f':
+		return '\f', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case '0':
+		// Up to two more octal digits
+		value := 0
+		for i := 0; i < 2 && p.more() && p.src[p.pos] >= '0' && p.src[p.pos] <= '7'; i++ {
+			value = value*8 + int(p.src[p.pos]-'0')
+			p.pos++
+		}
+		return rune(value), nil
+	case 'o':
+		if !p.peek("{") {
+			return 0, p.errorAt(start, "missing opening brace after \\o")
+		}
+		end := strings.IndexByte(p.src[p.pos:], '}')
+		if end < 0 {
+			return 0, p.errorAt(start, "missing closing brace after \\o{")
+		}
+		value, err := strconv.ParseUint(p.src[p.pos+1:p.pos+end], 8, 32)
+		if err != nil {
+			return 0, p.errorAt(start, "non-octal character in \\o{} (closing brace missing?)")
+		}
+		p.pos += end + 1
+		return p.checkCodePoint(rune(value), start)
+	case 'x':
+		if p.peek("{") {
+			end := strings.IndexByte(p.src[p.pos:], '}')
+			if end < 0 {
+				return 0, p.errorAt(start, "missing closing brace after \\x{")
+			}
+			value, err := strconv.ParseUint(p.src[p.pos+1:p.pos+end], 16, 32)
+			if err != nil {
+				return 0, p.errorAt(start, "non-hex character in \\x{} (closing brace missing?)")
+			}
+			p.pos += end + 1
+			return p.checkCodePoint(rune(value), start)
+		}
+		// Up to two hex digits
+		value := 0
+		for i := 0; i < 2 && p.more() && isHex(p.src[p.pos]); i++ {
+			value = value*16 + hexValue(p.src[p.pos])
+			p.pos++
+		}
+		return rune(value), nil
+	case 'c':
+		if !p.more() {
+			return 0, p.errorAt(start, "\\c at end of pattern")
+		}
+		ctrl := p.src[p.pos]
+		if ctrl < 32 || ctrl > 126 {
+			return 0, p.errorAt(start, "\\c must be followed by a printable ASCII character")
+		}
+		p.pos++
+		if ctrl >= 'a' && ctrl <= 'z' {
+			ctrl -= 32
+		}
+		return rune(ctrl ^ 0x40), nil
+	}
+	if c < utf8.RuneSelf && (isAlnum(c)) {
+		return 0, p.errorAt(start+1, "unrecognized character follows \\")
+	}
+	// Any other character stands for itself
+	p.pos--
+	r, w := p.next()
+	p.pos += w
+	return r, nil
+}
+
+func (p *parser) checkCodePoint(r rune, start int) (rune, error) {
+	if (!p.utf() && r > 0xFF) || r > utf8.MaxRune {
+		return 0, p.errorAt(start, "character code point value in \\x{} or \\o{} is too large")
+	}
+	if p.utf() && r >= 0xD800 && r <= 0xDFFF {
+		return 0, p.errorAt(start, "disallowed Unicode code point (>= 0xd800 && <= 0xdfff)")
+	}
+	return r, nil
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= 'a':
+		return int(c-'a') + 10
+	case c >= 'A':
+		return int(c-'A') + 10
+	}
+	return int(c - '0')
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNameChar(c byte) bool {
+	return isAlnum(c) || c == '_'
+}
+
+// parseName reads a group name up to close
+func (p *parser) parseName(close byte) (string, error) {
+	start := p.pos
+	for p.more() && isNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	name := p.src[start:p.pos]
+	switch {
+	case name == "":
+		if p.more() && p.src[p.pos] == close {
+			return "", p.errorf("subpattern name expected")
+		}
+		return "", p.errorf("syntax error in subpattern name (missing terminator?)")
+	case name[0] >= '0' && name[0] <= '9':
+		return "", p.errorAt(start, "subpattern name must start with a non-digit")
+	case len(name) > 32:
+		return "", p.errorAt(start, "subpattern name is too long (maximum 32 code units)")
+	}
+	if !p.more() || p.src[p.pos] != close {
+		return "", p.errorf("syntax error in subpattern name (missing terminator?)")
+	}
+	p.pos++
+	return name, nil
+}
+
+// parseGEscape parses \g backreferences and \g<...> subroutine calls
+func (p *parser) parseGEscape(start int) (*node, error) {
+	p.pos++
+	if !p.more() {
+		return nil, p.errorAt(start, "a numbered reference must not be zero")
+	}
+	switch p.src[p.pos] {
+	case '<', '\'':
+		close := byte('>')
+		if p.src[p.pos] == '\'' {
+			close = '\''
+		}
+		p.pos++
+		if n, ok, err := p.parseGroupNumber(close); ok || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			return &node{op: opRecurse, group: n, offset: start}, p.checkPending(n, start)
+		}
+		name, err := p.parseName(close)
+		if err != nil {
+			return nil, err
+		}
+		return p.recurseByName(name, start), nil
+	case '{':
+		p.pos++
+		if n, ok, err := p.parseGroupNumber('}'); ok || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			return p.backref(n, start), nil
+		}
+		name, err := p.parseName('}')
+		if err != nil {
+			return nil, err
+		}
+		return p.backrefByName(name, start), nil
+	}
+	n, ok, err := p.parseGroupNumber(0)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, p.errorAt(start, "a numbered reference must not be zero")
+	}
+	return p.backref(n, start), nil
+}
+
+// parseGroupNumber parses an absolute or relative group number followed by
+// close, or by anything when close is 0. It reports false, consuming
+// nothing, when there is no number.
+func (p *parser) parseGroupNumber(close byte) (int, bool, error) {
+	start := p.pos
+	sign := byte(0)
+	if p.more() && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+		sign = p.src[p.pos]
+		p.pos++
+	}
+	end := p.pos
+	for end < len(p.src) && p.src[end] >= '0' && p.src[end] <= '9' {
+		end++
+	}
+	if end == p.pos {
+		p.pos = start
+		return 0, false, nil
+	}
+	n := atoiLimit(p.src[p.pos:end])
+	p.pos = end
+	if close != 0 {
+		if !p.more() || p.src[p.pos] != close {
+			return 0, false, p.errorf("syntax error in subpattern name (missing terminator?)")
+		}
+		p.pos++
+	}
+	switch sign {
+	case '-':
+		if n == 0 || n > p.ncap {
+			return 0, false, p.errorAt(start, "reference to non-existent subpattern")
+		}
+		n = p.ncap - n + 1
+	case '+':
+		if n == 0 {
+			return 0, false, p.errorAt(start, "a relative value of zero is not allowed")
+		}
+		n += p.ncap
+	}
+	return n, true, nil
+}
+
+// checkPending queues a check that group n exists once parsing is done
+func (p *parser) checkPending(n, offset int) error {
+	p.pending = append(p.pending, &node{op: opRecurse, group: n, offset: offset})
+	return nil
+}
+
+func (p *parser) backref(n, offset int) *node {
+	ref := &node{op: opBackref, groups: []int{n}, fold: p.flags&Caseless != 0, offset: offset}
+	p.pending = append(p.pending, ref)
+	return ref
+}
+
+func (p *parser) backrefByName(name string, offset int) *node {
+	ref := &node{op: opBackref, name: name, fold: p.flags&Caseless != 0, offset: offset}
+	p.pending = append(p.pending, ref)
+	return ref
+}
+
+func (p *parser) recurseByName(name string, offset int) *node {
+	call := &node{op: opRecurse, name: name, offset: offset}
+	p.pending = append(p.pending, call)
+	return call
+}
+
+// groupBody parses the alternatives of a group up to its closing
+// parenthesis. Options set inside the group end with it.
+func (p *parser) groupBody(open int) (*node, error) {
+	saved := p.flags
+	body, err := p.parseAlt()
+	p.flags = saved
+	if err != nil {
+		return nil, err
+	}
+	if !p.more() {
+		return nil, p.errorAt(len(p.src), "missing closing parenthesis")
+	}
+	p.pos++
+	if body.op == opLiteral && len(body.runes) > 1 {
+		// Kept apart from the literals around the group, so that a
+		// quantifier after it applies to all of it
+		body = &node{op: opConcat, subs: []*node{body}}
+	}
+	return body, nil
+}
+
+func (p *parser) newGroup(name string, offset int) (*node, error) {
+	p.ncap++
+	index := p.ncap
+	group := &node{op: opCapture, group: index}
+	if index >= len(p.groups) {
+		p.groups = append(p.groups, group)
+		p.names = append(p.names, name)
+	} else if name != "" {
+		// A group number reused by a branch reset
+		p.names[index] = name
+	}
+	if name != "" {
+		existing := p.nameIndex[name]
+		for _, i := range existing {
+			if i == index {
+				return group, nil
+			}
+		}
+		if len(existing) > 0 && p.flags&DupNames == 0 {
+			return nil, p.errorAt(offset, "two named subpatterns have the same name (PCRE2_DUPNAMES not set)")
+		}
+		p.nameIndex[name] = append(existing, index)
+	}
+	return group, nil
+}
+
+func (p *parser) capture(name string, open int) (*node, error) {
+	group, err := p.newGroup(name, open)
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.groupBody(open)
+	if err != nil {
+		return nil, err
+	}
+	group.subs = []*node{body}
+	return group, nil
+}
+
+func (p *parser) wrap(op opcode, open int) (*node, error) {
+	body, err := p.groupBody(open)
+	if err != nil {
+		return nil, err
+	}
+	return &node{op: op, subs: []*node{body}}, nil
+}
+
+func (p *parser) parseGroup() (*node, error) {
+	open := p.pos
+	p.pos++
+	if p.peek("*") {
+		return p.parseVerb(open)
+	}
+	if !p.peek("?") {
+		if p.flags&NoAutoCapture != 0 {
+			return p.groupBody(open)
+		}
+		return p.capture("", open)
+	}
+	p.pos++
+	if !p.more() {
+		return nil, p.errorAt(len(p.src), "missing closing parenthesis")
+	}
+	switch c := p.src[p.pos]; c {
+	case '#':
+		end := strings.IndexByte(p.src[p.pos:], ')')
+		if end < 0 {
+			return nil, p.errorAt(len(p.src), "missing ) after (?# comment")
+		}
+		p.pos += end + 1
+		return nil, nil
+	case ':':
+		p.pos++
+		return p.groupBody(open)
+	case '|':
+		p.pos++
+		return p.branchReset(open)
+	case '>':
+		p.pos++
+		return p.wrap(opAtomic, open)
+	case '=', '!':
+		p.pos++
+		look, err := p.wrap(opLookahead, open)
+		if err != nil {
+			return nil, err
+		}
+		look.negate = c == '!'
+		return look, nil
+	case '<':
+		p.pos++
+		if p.peek("=") || p.peek("!") {
+			negate := p.peek("!")
+			p.pos++
+			return p.lookbehind(open, negate)
+		}
+		name, err := p.parseName('>')
+		if err != nil {
+			return nil, err
+		}
+		return p.capture(name, open)
+	case '\'':
+		p.pos++
+		name, err := p.parseName('\'')
+		if err != nil {
+			return nil, err
+		}
+		return p.capture(name, open)
+	case 'P':
+		p.pos++
+		switch {
+		case p.peek("<"):
+			p.pos++
+			name, err := p.parseName('>')
+			if err != nil {
+				return nil, err
+			}
+			return p.capture(name, open)
+		case p.peek("="):
+			p.pos++
+			name, err := p.parseName(')')
+			if err != nil {
+				return nil, err
+			}
+			return p.backrefByName(name, open), nil
+		case p.peek(">"):
+			p.pos++
+			name, err := p.parseName(')')
+			if err != nil {
+				return nil, err
+			}
+			return p.recurseByName(name, open), nil
+		}
+		return nil, p.errorf("unrecognized character after (?P")
+	case '&':
+		p.pos++
+		name, err := p.parseName(')')
+		if err != nil {
+			return nil, err
+		}
+		return p.recurseByName(name, open), nil
+	case 'R':
+		if p.peek("R)") {
+			p.pos += 2
+			return &node{op: opRecurse, group: 0}, nil
+		}
+	case '(':
+		p.pos++
+		return p.parseCond(open)
+	case 'C':
+		// Callouts have no callback to call, and match nothing
+		end := strings.IndexByte(p.src[p.pos:], ')')
+		if end < 0 {
+			return nil, p.errorAt(len(p.src), "missing closing parenthesis")
+		}
+		p.pos += end + 1
+		return nil, nil
+	}
+	if c := p.src[p.pos]; c >= '0' && c <= '9' || c == '+' || c == '-' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9' {
+		n, ok, err := p.parseGroupNumber(')')
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, p.errorf("digit expected after (?+ or (?-")
+		}
+		if err := p.checkPending(n, open); err != nil {
+			return nil, err
+		}
+		return &node{op: opRecurse, group: n, offset: open}, nil
+	}
+	return p.parseOptions(open)
+}
+
+// parseOptions parses (?flags) and (?flags:...)
+func (p *parser) parseOptions(open int) (*node, error) {
+	flags := p.flags
+	on := true
+	for p.more() {
+		c := p.src[p.pos]
+		p.pos++
+		var flag Flags
+		switch c {
+		case 'i':
+			flag = Caseless
+		case 'm':
+			flag = Multiline
+		case 's':
+			flag = DotAll
+		case 'x':
+			flag = Extended
+		case 'n':
+			flag = NoAutoCapture
+		case 'U':
+			flag = Ungreedy
+		case 'J':
+			flag = DupNames
+		case '-':
+			if !on {
+				return nil, p.errorAt(p.pos-1, "unrecognized character after (? or (?-")
+			}
+			on = false
+			continue
+		case '^':
+			if !on {
+				return nil, p.errorAt(p.pos-1, "unrecognized character after (? or (?-")
+			}
+			flags &^= Caseless | Multiline | DotAll | Extended | NoAutoCapture | Ungreedy
+			continue
+		case ')':
+			p.flags = flags
+			return nil, nil
+		case ':':
+			saved := p.flags
+			p.flags = flags
+			body, err := p.groupBody(open)
+			p.flags = saved
+			return body, err
+		default:
+			return nil, p.errorAt(p.pos-1, "unrecognized character after (? or (?-")
+		}
+		if on {
+			flags |= flag
+		} else {
+			flags &^= flag
+		}
+	}
+	return nil, p.errorAt(len(p.src), "missing closing parenthesis")
+}
+
+// branchReset parses (?|...), whose alternatives number their groups from
+// the same number
+func (p *parser) branchReset(open int) (*node, error) {
+	saved := p.flags
+	base, highest := p.ncap, p.ncap
+	var branches []*node
+	for {
+		p.ncap = base
+		branch, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, branch)
+		highest = max(highest, p.ncap)
+		if !p.peek("|") {
+			break
+		}
+		p.pos++
+	}
+	p.ncap = highest
+	p.flags = saved
+	if !p.more() {
+		return nil, p.errorAt(len(p.src), "missing closing parenthesis")
+	}
+	p.pos++
+	if len(branches) == 1 {
+		return branches[0], nil
+	}
+	return &node{op: opAlt, subs: branches}, nil
+}
+
+func (p *parser) lookbehind(open int, negate bool) (*node, error) {
+	look, err := p.wrap(opLookbehind, open)
+	if err != nil {
+		return nil, err
+	}
+	look.negate = negate
+	min, max, ok := width(look.subs[0])
+	if !ok {
+		return nil, p.errorAt(open, "lookbehind assertion is not fixed length")
+	}
+	if max < 0 || max > maxLookbehind {
+		return nil, p.errorAt(open, "length of lookbehind assertion is not limited")
+	}
+	look.min, look.max = min, max
+	return look, nil
+}
+
+// width returns the shortest and longest lengths in characters n can
+// match, with -1 for no limit. It reports false for backreferences and
+// subroutine calls, whose lengths are not known.
+func width(n *node) (int, int, bool) {
+	switch n.op {
+	case opLiteral:
+		return len(n.runes), len(n.runes), true
+	case opChar, opByte:
+		return 1, 1, true
+	case opNewline:
+		return 1, 2, true
+	case opGrapheme:
+		return 1, -1, true
+	case opBackref, opRecurse:
+		return 0, 0, false
+	case opConcat:
+		lo, hi := 0, 0
+		for _, sub := range n.subs {
+			l, h, ok := width(sub)
+			if !ok {
+				return 0, 0, false
+			}
+			lo += l
+			if hi >= 0 {
+				if h < 0 {
+					hi = -1
+				} else {
+					hi += h
+				}
+			}
+		}
+		return lo, hi, true
+	case opAlt, opCond:
+		lo, hi := -1, 0
+		for _, sub := range n.subs {
+			l, h, ok := width(sub)
+			if !ok {
+				return 0, 0, false
+			}
+			if lo < 0 || l < lo {
+				lo = l
+			}
+			if hi >= 0 && (h < 0 || h > hi) {
+				hi = h
+			}
+		}
+		if n.op == opCond && len(n.subs) < 2 {
+			lo = 0
+		}
+		return max(lo, 0), hi, true
+	case opRepeat:
+		l, h, ok := width(n.subs[0])
+		if !ok {
+			return 0, 0, false
+		}
+		hi := -1
+		if n.max >= 0 && h >= 0 {
+			hi = h * n.max
+		}
+		if h == 0 {
+			hi = 0
+		}
+		return l * n.min, hi, true
+	case opCapture, opAtomic:
+		return width(n.subs[0])
+	}
+	return 0, 0, true
+}
+
+// parseCond parses a conditional group after its "(?("
+func (p *parser) parseCond(open int) (*node, error) {
+	cond := &node{op: opCond, offset: open}
+	switch {
+	case p.peek("?=") || p.peek("?!") || p.peek("?<=") || p.peek("?<!"):
+		assertOpen := p.pos - 1
+		p.pos++
+		var assertion *node
+		var err error
+		switch {
+		case p.peek("="), p.peek("!"):
+			negate := p.peek("!")
+			p.pos++
+			assertion, err = p.wrap(opLookahead, assertOpen)
+			if assertion != nil {
+				assertion.negate = negate
+			}
+		default:
+			negate := p.peek("<!")
+			p.pos += 2
+			assertion, err = p.lookbehind(assertOpen, negate)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cond.cond, cond.condNode = condAssert, assertion
+	case p.peek("R"):
+		p.pos++
+		cond.cond, cond.group = condRecursion, -1
+		switch {
+		case p.peek(")"):
+			p.pos++
+		case p.peek("&"):
+			p.pos++
+			name, err := p.parseName(')')
+			if err != nil {
+				return nil, err
+			}
+			cond.name = name
+			p.pending = append(p.pending, cond)
+		default:
+			n, ok, err := p.parseGroupNumber(')')
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				// A group named R...
+				p.pos--
+				name, err := p.parseName(')')
+				if err != nil {
+					return nil, err
+				}
+				cond.cond, cond.name = condGroup, name
+				p.pending = append(p.pending, cond)
+				break
+			}
+			cond.group = n
+		}
+	case p.peek("DEFINE)"):
+		p.pos += len("DEFINE)")
+		cond.cond = condDefine
+	case p.peek("<") || p.peek("'"):
+		close := byte('>')
+		if p.peek("'") {
+			close = '\''
+		}
+		p.pos++
+		name, err := p.parseName(close)
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, p.errorf("syntax error in subpattern name (missing terminator?)")
+		}
+		p.pos++
+		cond.cond, cond.name = condGroup, name
+		p.pending = append(p.pending, cond)
+	default:
+		n, ok, err := p.parseGroupNumber(')')
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if n == 0 {
+				return nil, p.errorAt(open, "a numbered reference must not be zero")
+			}
+			cond.cond, cond.groups = condGroup, []int{n}
+			p.pending = append(p.pending, &node{op: opRecurse, group: n, offset: open})
+			break
+		}
+		if !p.more() || !isNameChar(p.src[p.pos]) {
+			return nil, p.errorf("assertion expected after (?( or (?(?C)")
+		}
+		name, err := p.parseName(')')
+		if err != nil {
+			return nil, err
+		}
+		cond.cond, cond.name = condGroup, name
+		p.pending = append(p.pending, cond)
+	}
+
+	body, err := p.groupBody(open)
+	if err != nil {
+		return nil, err
+	}
+	cond.subs = []*node{body}
+	if body.op == opAlt {
+		cond.subs = body.subs
+	}
+	if len(cond.subs) > 2 || cond.cond == condDefine && len(cond.subs) > 1 {
+		if cond.cond == condDefine {
+			return nil, p.errorAt(open, "DEFINE subpattern contains more than one branch")
+		}
+		return nil, p.errorAt(open, "conditional subpattern contains more than two branches")
+	}
+	return cond, nil
+}
+
+func (p *parser) parseVerb(open int) (*node, error) {
+	end := strings.IndexByte(p.src[p.pos:], ')')
+	if end < 0 {
+		return nil, p.errorAt(len(p.src), "missing closing parenthesis")
+	}
+	verb := p.src[p.pos+1 : p.pos+end]
+	p.pos += end + 1
+	name, arg := verb, ""
+	if i := strings.IndexByte(verb, ':'); i >= 0 {
+		name, arg = verb[:i], verb[i+1:]
+	}
+	switch name {
+	case "FAIL", "F":
+		return &node{op: opFail}, nil
+	case "ACCEPT":
+		return &node{op: opAccept}, nil
+	case "COMMIT":
+		return &node{op: opCommit}, nil
+	case "PRUNE":
+		return &node{op: opPrune}, nil
+	case "SKIP":
+		// Skipping to a mark is not supported, and like a missing mark
+		// does nothing
+		if arg != "" {
+			return &node{op: opEmpty}, nil
+		}
+		return &node{op: opSkip}, nil
+	case "THEN":
+		return &node{op: opThen}, nil
+	case "MARK", "":
+		if arg == "" {
+			return nil, p.errorAt(open, "(*MARK) must have an argument")
+		}
+		return &node{op: opEmpty}, nil
+	}
+	return nil, p.errorAt(open, "(*VERB) not recognized or malformed")
+}
+
+// resolve checks the references made while parsing against the groups of
+// the whole pattern
+func (p *parser) resolve() error {
+	ncap := len(p.groups) - 1
+	for _, ref := range p.pending {
+		if ref.name != "" {
+			groups, ok := p.nameIndex[ref.name]
+			if !ok {
+				return p.errorAt(ref.offset, "reference to non-existent subpattern")
+			}
+			switch ref.op {
+			case opRecurse:
+				ref.group = groups[0]
+			case opCond:
+				if ref.cond == condRecursion {
+					ref.group = groups[0]
+				} else {
+					ref.groups = groups
+				}
+			default:
+				ref.groups = groups
+			}
+			continue
+		}
+		if ref.digits != "" {
+			n := atoiLimit(ref.digits)
+			if n <= ncap {
+				ref.groups = []int{n}
+				continue
+			}
+			if n < 10 {
+				return p.errorAt(ref.offset, "reference to non-existent subpattern")
+			}
+			p.octalReference(ref)
+			continue
+		}
+		if ref.op == opRecurse && ref.group > ncap {
+			return p.errorAt(ref.offset, "reference to non-existent subpattern")
+		}
+		for _, g := range ref.groups {
+			if g > ncap {
+				return p.errorAt(ref.offset, "reference to non-existent subpattern")
+			}
+		}
+	}
+	return nil
+}
+
+// octalReference turns \ddd that names no group into the character with
+// that octal code, followed by the digits left over
+func (p *parser) octalReference(ref *node) {
+	digits := ref.digits
+	value, n := 0, 0
+	for n < 3 && n < len(digits) && digits[n] <= '7' {
+		value = value*8 + int(digits[n]-'0')
+		n++
+	}
+	chars := []rune{}
+	if n > 0 {
+		chars = append(chars, rune(value))
+	}
+	for _, c := range digits[n:] {
+		chars = append(chars, c)
+	}
+	lit := ""
+	for _, r := range chars {
+		if p.utf() {
+			lit += string(r)
+		} else {
+			lit += string([]byte{byte(r)})
+		}
+	}
+	*ref = node{op: opLiteral, lit: lit, runes: chars, fold: ref.fold}
+}
+
+// analyzeStart finds a literal all matches start with, and whether they can
+// only start at the start offset
+func analyzeStart(n *node) (string, bool) {
+	for {
+		switch n.op {
+		case opConcat:
+			n = n.subs[0]
+		case opCapture, opAtomic:
+			n = n.subs[0]
+		case opLiteral:
+			if n.fold {
+				return "", false
+			}
+			return n.lit, false
+		case opStartSubject, opStartOffset:
+			return "", true
+		default:
+			return "", false
+		}
+	}
+}
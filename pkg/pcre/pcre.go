@@ -0,0 +1,218 @@
+// Package pcre is a backtracking regular expression engine with the syntax
+// and matching semantics of PCRE2, the library behind PHP's preg_*
+// functions. Unlike Go's regexp package it supports backreferences,
+// lookaround, atomic groups, possessive quantifiers, recursion, \K,
+// conditional groups and the backtracking control verbs.
+//
+// Offsets are in bytes. In UTF mode the pattern and the subject are UTF-8
+// and characters are code points; otherwise every byte is a character. The
+// newline convention is LF, as in PHP's bundled PCRE2.
+package pcre
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Flags are the options a pattern is compiled with, which PHP sets with
+// the modifiers after the closing delimiter
+type Flags uint32
+
+const (
+	// Caseless matches letters of either case (i)
+	Caseless Flags = 1 << iota
+	// Multiline makes ^ and $ match at newlines within the subject (m)
+	Multiline
+	// DotAll makes . match newlines too (s)
+	DotAll
+	// Extended ignores whitespace and # comments in the pattern (x)
+	Extended
+	// Anchored only matches at the start offset (A)
+	Anchored
+	// DollarEndOnly stops $ matching before a newline at the end (D)
+	DollarEndOnly
+	// Ungreedy inverts the greediness of quantifiers (U)
+	Ungreedy
+	// UTF treats the pattern and subject as UTF-8, with Unicode properties
+	// for \d, \w, \s, \b and the POSIX classes (u)
+	UTF
+	// NoAutoCapture makes plain parentheses non-capturing, leaving only
+	// named groups to capture (n)
+	NoAutoCapture
+	// DupNames allows groups to share a name (J)
+	DupNames
+)
+
+var (
+	// ErrMatchLimit is returned when a match backtracks more times than
+	// its limit allows
+	ErrMatchLimit = errors.New("match limit exceeded")
+	// ErrDepthLimit is returned when a match nests deeper than its limit
+	// allows
+	ErrDepthLimit = errors.New("depth limit exceeded")
+)
+
+// Error is a pattern that failed to compile
+type Error struct {
+	Msg    string
+	Offset int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Msg, e.Offset)
+}
+
+// Regexp is a compiled pattern. It is safe for concurrent use.
+type Regexp struct {
+	root  *node
+	flags Flags
+	// names holds the name of each group, indexed by group number
+	names []string
+	// groups holds the capture node of each group, for subroutine calls
+	groups []*node
+	// prefix is a literal every match starts with, used to skip ahead to
+	// where a match can start
+	prefix string
+	// anchorStart is set when matches can only start at the start offset
+	anchorStart bool
+	// matchLimit is the limit set by (*LIMIT_MATCH=n), or 0
+	matchLimit int
+}
+
+// MatchOptions control a single match
+type MatchOptions struct {
+	// Anchored only matches at the start offset
+	Anchored bool
+	// NotEmptyAtStart rejects an empty match at the start offset, which
+	// callers set when looking for the next match after an empty one
+	NotEmptyAtStart bool
+	// MatchLimit bounds the backtracking done by the match; 0 means no
+	// limit
+	MatchLimit int
+	// DepthLimit bounds the nesting of the backtracking; 0 means no limit
+	DepthLimit int
+}
+
+// Compile parses pattern, without delimiters, into a Regexp.
+func Compile(pattern string, flags Flags) (*Regexp, error) {
+	p := newParser(pattern, flags)
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	re := &Regexp{
+		root:       root,
+		flags:      p.flags0,
+		names:      p.names,
+		groups:     p.groups,
+		matchLimit: p.matchLimit,
+	}
+	re.prefix, re.anchorStart = analyzeStart(root)
+	return re, nil
+}
+
+// MustCompile is like Compile but panics if the pattern does not compile.
+func MustCompile(pattern string, flags Flags) *Regexp {
+	re, err := Compile(pattern, flags)
+	if err != nil {
+		panic("pcre: Compile(" + pattern + "): " + err.Error())
+	}
+	return re
+}
+
+// NumGroups returns the number of capture groups.
+func (re *Regexp) NumGroups() int {
+	return len(re.names) - 1
+}
+
+// GroupNames returns the name of each group indexed by group number, with
+// "" for group 0 and groups without a name.
+func (re *Regexp) GroupNames() []string {
+	return re.names
+}
+
+// UTF reports whether the pattern was compiled in UTF mode.
+func (re *Regexp) UTF() bool {
+	return re.flags&UTF != 0
+}
+
+// Match looks for the first match in subject at or after start. It returns
+// the offsets of the match and of each group as pairs, with -1 for groups
+// that did not take part, or nil when nothing matches. In UTF mode subject
+// must be valid UTF-8 and start a character boundary.
+func (re *Regexp) Match(subject string, start int, opts MatchOptions) ([]int, error) {
+	m := &matcher{
+		re:         re,
+		subject:    subject,
+		utf:        re.flags&UTF != 0,
+		caps:       make([]int, 2*len(re.names)),
+		start:      start,
+		limit:      opts.MatchLimit,
+		depthLimit: opts.DepthLimit,
+	}
+	if re.matchLimit > 0 && (m.limit == 0 || re.matchLimit < m.limit) {
+		m.limit = re.matchLimit
+	}
+	anchored := opts.Anchored || re.flags&Anchored != 0 || re.anchorStart
+
+	for pos := start; pos <= len(subject); {
+		if re.prefix != "" && !anchored {
+			i := strings.Index(subject[pos:], re.prefix)
+			if i < 0 {
+				break
+			}
+			pos += i
+		}
+		for i := range m.caps {
+			m.caps[i] = -1
+		}
+		m.keep, m.verb = -1, verbNone
+		begin := pos
+		end := -1
+		m.accept = func(p int) bool {
+			first := begin
+			if m.keep >= 0 {
+				first = m.keep
+			}
+			if opts.NotEmptyAtStart && first == start && p == start {
+				return false
+			}
+			end = p
+			return true
+		}
+		matched := m.match(re.root, pos, m.accept)
+		if m.err != nil {
+			return nil, m.err
+		}
+		if matched {
+			m.caps[0], m.caps[1] = begin, end
+			if m.keep >= 0 {
+				m.caps[0] = m.keep
+			}
+			return m.caps, nil
+		}
+		if anchored || m.verb == verbCommit {
+			break
+		}
+		if m.verb == verbSkip && m.skipTo > pos {
+			pos = m.skipTo
+			continue
+		}
+		if pos == len(subject) {
+			break
+		}
+		pos += m.charWidth(pos)
+	}
+	return nil, nil
+}
+
+// MatchString reports whether subject contains a match, ignoring limits.
+func (re *Regexp) MatchString(subject string) bool {
+	if re.UTF() && !utf8.ValidString(subject) {
+		return false
+	}
+	match, _ := re.Match(subject, 0, MatchOptions{})
+	return match != nil
+}
@@ -0,0 +1,192 @@
+package pcre
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// groups runs a match and returns each group's text, with "<unset>" for
+// groups that did not take part, or nil when nothing matches
+func groups(t *testing.T, pattern string, flags Flags, subject string) []string {
+	t.Helper()
+	re, err := Compile(pattern, flags)
+	require.NoError(t, err, "pattern %q", pattern)
+	match, err := re.Match(subject, 0, MatchOptions{})
+	require.NoError(t, err, "pattern %q", pattern)
+	if match == nil {
+		return nil
+	}
+	var result []string
+	for i := 0; i < len(match); i += 2 {
+		if match[i] < 0 {
+			result = append(result, "<unset>")
+		} else {
+			result = append(result, subject[match[i]:match[i+1]])
+		}
+	}
+	return result
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		flags    Flags
+		subject  string
+		expected []string
+	}{
+		{`a+b`, 0, "xaaab", []string{"aaab"}},
+		{`(\w+)@(\w+)\.com`, 0, "mail bob@example.com", []string{"bob@example.com", "bob", "example"}},
+		{`colou?r`, 0, "color", []string{"color"}},
+		{`HELLO`, Caseless, "say hello", []string{"hello"}},
+		{`a.c`, 0, "a\nc", nil},
+		{`a.c`, DotAll, "a\nc", []string{"a\nc"}},
+		{`^b`, 0, "a\nb", nil},
+		{`^b$`, Multiline, "a\nb\nc", []string{"b"}},
+		{`c$`, 0, "abc\n", []string{"c"}},
+		{`c$`, DollarEndOnly, "abc\n", nil},
+		{`a{2,3}`, 0, "aaaa", []string{"aaa"}},
+		{`a{2,3}?`, 0, "aaaa", []string{"aa"}},
+		{`a{,2}b`, 0, "aaab", []string{"aab"}},
+		{`x{`, 0, "x{", []string{"x{"}},
+		{`a+`, Ungreedy, "aaa", []string{"a"}},
+		{`a b # comment`, Extended, "ab", []string{"ab"}},
+		{`[a-c]+`, 0, "xxabcd", []string{"abc"}},
+		{`[^a-c]+`, 0, "abcxyz", []string{"xyz"}},
+		{`[]a]+`, 0, "]a]", []string{"]a]"}},
+		{`[\d-]+`, 0, "1-2", []string{"1-2"}},
+		{`[[:alpha:]]+`, 0, "12abc", []string{"abc"}},
+		{`[[:^digit:]]+`, 0, "12abc", []string{"abc"}},
+		{`[A-Z]+`, Caseless, "abc", []string{"abc"}},
+		{`\bfoo\b`, 0, "a foobar foo", []string{"foo"}},
+		{`\Bbar`, 0, "bar foobar", []string{"bar"}},
+		{`(a)|(b)`, 0, "b", []string{"b", "<unset>", "b"}},
+		{`(?:ab)+`, 0, "ababa", []string{"abab"}},
+		{`(?<year>\d{4})-(?<month>\d\d)`, 0, "on 2024-05", []string{"2024-05", "2024", "05"}},
+		{`(?i)abc(?-i)D`, 0, "ABCD", []string{"ABCD"}},
+		{`(?i:a)b`, 0, "Ab AB", []string{"Ab"}},
+		{`\Qa.b\E+`, 0, "a.bbb", []string{"a.bbb"}},
+		{`\x41\101\cA?`, 0, "AA", []string{"AA"}},
+		{`\x{e9}`, UTF, "café", []string{"é"}},
+		{`^.$`, UTF, "é", []string{"é"}},
+		{`^.$`, 0, "é", nil},
+		{`\w+`, UTF, "héllo", []string{"héllo"}},
+		{`\w+`, 0, "héllo", []string{"h"}},
+		{`\p{Lu}+`, UTF, "abcDÉF", []string{"DÉF"}},
+		{`\p{Greek}+`, UTF, "abc αβγ", []string{"αβγ"}},
+		{`É`, UTF | Caseless, "é", []string{"é"}},
+		{`\d+(?=px)`, 0, "10em 20px", []string{"20"}},
+		{`\d+(?!px|\d)`, 0, "20px 30em", []string{"30"}},
+		{`(?<=\$)\d+`, 0, "cost: $42", []string{"42"}},
+		{`(?<!\$)\b\d+`, 0, "$42 17", []string{"17"}},
+		{`(?<=ab|c)x`, 0, "cx", []string{"x"}},
+		{`(\w)\1`, 0, "abccd", []string{"cc", "c"}},
+		{`(?<q>['"]).*?\k<q>`, 0, `say "hi" 'x'`, []string{`"hi"`, `"`}},
+		{`(a)\g{-1}`, 0, "aa", []string{"aa", "a"}},
+		{`(?i)(a)\1`, 0, "aA", []string{"aA", "a"}},
+		{`(?P<x>a)(?P=x)`, 0, "aa", []string{"aa", "a"}},
+		{`(?>a+)b`, 0, "aaab", []string{"aaab"}},
+		{`(?>a+)a`, 0, "aaaa", nil},
+		{`a++a`, 0, "aaaa", nil},
+		{`a*+b`, 0, "aab", []string{"aab"}},
+		{`(?:a|ab)++c`, 0, "abc", nil},
+		{`foo\Kbar`, 0, "foobar", []string{"bar"}},
+		{`\((?:[^()]|(?R))*\)`, 0, "x(a(b)c)y", []string{"(a(b)c)"}},
+		{`^(\d+|\((?1)([+*-])(?1)\))$`, 0, "(1+(2*3))", []string{"(1+(2*3))", "(1+(2*3))", "+"}},
+		{`(?<p>a|b(?&p)c)`, 0, "bbacc", []string{"bbacc", "bbacc"}},
+		{`(a)?(?(1)b|c)`, 0, "ab", []string{"ab", "a"}},
+		{`(a)?(?(1)b|c)`, 0, "c", []string{"c", "<unset>"}},
+		{`(?(?=\d)\d+|[a-z]+)`, 0, "abc", []string{"abc"}},
+		{`(?(DEFINE)(?<d>\d+))(?&d)\.(?&d)`, 0, "v1.23", []string{"1.23", "<unset>"}},
+		{`(?|(a)|(b))c`, 0, "bc", []string{"bc", "b"}},
+		{`a(*FAIL)|b`, 0, "ab", []string{"b"}},
+		{`a+(*COMMIT)b`, 0, "aaac aab", nil},
+		{`a(*ACCEPT)b`, 0, "ac", []string{"a"}},
+		{`\R`, 0, "a\r\nb", []string{"\r\n"}},
+		{`\h+`, 0, "a \t b", []string{" \t "}},
+		{`a\z`, 0, "a\n", nil},
+		{`a\Z`, 0, "a\n", []string{"a"}},
+		{`(a)|b`, 0, "b", []string{"b", "<unset>"}},
+		{`(?n)(a)(?<x>b)`, 0, "ab", []string{"ab", "b"}},
+		{`(*UTF)^.$`, 0, "é", []string{"é"}},
+		{`(a|)*b`, 0, "b", []string{"b", ""}},
+		{`\X`, UTF, "éx", []string{"é"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, groups(t, tt.pattern, tt.flags, tt.subject), "pattern %q on %q", tt.pattern, tt.subject)
+	}
+}
+
+func TestMatchOffsets(t *testing.T) {
+	re := MustCompile(`\Gab`, 0)
+	match, err := re.Match("abab", 2, MatchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4}, match)
+
+	match, err = re.Match("xab", 0, MatchOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, match)
+
+	// An empty match at the start offset can be refused, as preg_match_all
+	// does after an empty match
+	re = MustCompile(`a*`, 0)
+	match, err = re.Match("baa", 0, MatchOptions{NotEmptyAtStart: true, Anchored: true})
+	require.NoError(t, err)
+	assert.Nil(t, match)
+	match, err = re.Match("baa", 1, MatchOptions{NotEmptyAtStart: true})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 3}, match)
+}
+
+func TestGroupNames(t *testing.T) {
+	re := MustCompile(`(?<a>x)(y)(?<b>z)`, 0)
+	assert.Equal(t, 3, re.NumGroups())
+	assert.Equal(t, []string{"", "a", "", "b"}, re.GroupNames())
+
+	_, err := Compile(`(?<a>x)(?<a>y)`, 0)
+	assert.Error(t, err)
+	re = MustCompile(`(?:(?<a>x)|(?<a>y))\k<a>`, DupNames)
+	assert.True(t, re.MatchString("yy"))
+	assert.False(t, re.MatchString("yx"))
+}
+
+func TestMatchLimit(t *testing.T) {
+	re := MustCompile(`(?:\D+|<\d+>)*[!?]`, 0)
+	_, err := re.Match("foobar foobar foobar", 0, MatchOptions{MatchLimit: 100000})
+	assert.ErrorIs(t, err, ErrMatchLimit)
+
+	match, err := re.Match("foobar foobar foobar", 0, MatchOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, match)
+
+	re = MustCompile(`(?:ab)*c`, 0)
+	_, err = re.Match(strings.Repeat("ab", 1000), 0, MatchOptions{DepthLimit: 100})
+	assert.ErrorIs(t, err, ErrDepthLimit)
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		message string
+	}{
+		{`(abc`, "missing closing parenthesis at offset 4"},
+		{`abc)`, "unmatched closing parenthesis at offset 3"},
+		{`[abc`, "missing terminating ] for character class at offset 4"},
+		{`*a`, "quantifier does not follow a repeatable item at offset 0"},
+		{`a{3,2}`, "numbers out of order in {} quantifier at offset 5"},
+		{`(?<=a+)b`, "length of lookbehind assertion is not limited at offset 0"},
+		{`\2(a)`, "reference to non-existent subpattern at offset 0"},
+		{`[z-a]`, "range out of order in character class at offset 3"},
+		{`\i`, "unrecognized character follows \\ at offset 1"},
+		{`(?<n>a)(?&m)`, "reference to non-existent subpattern at offset 7"},
+		{`\p{Nope}`, "unknown property after \\P or \\p at offset 8"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.pattern, 0)
+		if assert.Error(t, err, "pattern %q", tt.pattern) {
+			assert.Equal(t, tt.message, err.Error(), "pattern %q", tt.pattern)
+		}
+	}
+}
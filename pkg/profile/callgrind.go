@@ -0,0 +1,59 @@
+package profile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/wudi/hey/version"
+	"github.com/wudi/hey/vm"
+)
+
+// WriteCallgrind writes profile in the callgrind format of KCachegrind,
+// QCachegrind and Webgrind, as Xdebug's profiler does. Costs are the wall
+// time in nanoseconds and the allocated bytes. cmd names the profiled
+// script.
+func WriteCallgrind(w io.Writer, profile *vm.Profile, cmd string) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "version: 1\ncreator: hey %s\ncmd: %s\npart: 1\npositions: line\n\n", version.Version(), cmd)
+	fmt.Fprintf(out, "events: Time_(ns) Memory_(bytes)\n\n")
+
+	// Files and functions are named once, then referred to by number
+	files := make(map[string]int)
+	file := func(name string) string {
+		if id, ok := files[name]; ok {
+			return fmt.Sprintf("(%d)", id)
+		}
+		files[name] = len(files) + 1
+		return fmt.Sprintf("(%d) %s", len(files), name)
+	}
+	named := make(map[int]bool)
+	function := func(id int) string {
+		if named[id] {
+			return fmt.Sprintf("(%d)", id+1)
+		}
+		named[id] = true
+		return fmt.Sprintf("(%d) %s", id+1, profile.Functions[id].Name)
+	}
+
+	calls := make(map[int][]*vm.ProfileCall)
+	for _, call := range profile.Calls {
+		calls[call.Caller] = append(calls[call.Caller], call)
+	}
+	var totalTime, totalMemory int64
+	for id, fn := range profile.Functions {
+		fmt.Fprintf(out, "fl=%s\nfn=%s\n", file(fn.File), function(id))
+		fmt.Fprintf(out, "%d %d %d\n", fn.Line, fn.SelfTime.Nanoseconds(), fn.SelfMemory)
+		for _, call := range calls[id] {
+			callee := profile.Functions[call.Callee]
+			fmt.Fprintf(out, "cfl=%s\ncfn=%s\n", file(callee.File), function(call.Callee))
+			fmt.Fprintf(out, "calls=%d %d\n", call.Calls, callee.Line)
+			fmt.Fprintf(out, "%d %d %d\n", call.Line, call.Time.Nanoseconds(), call.Memory)
+		}
+		fmt.Fprintln(out)
+		totalTime += fn.SelfTime.Nanoseconds()
+		totalMemory += fn.SelfMemory
+	}
+	fmt.Fprintf(out, "summary: %d %d\n", totalTime, totalMemory)
+	return out.Flush()
+}
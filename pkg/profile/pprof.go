@@ -0,0 +1,156 @@
+package profile
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+
+	"github.com/wudi/hey/vm"
+)
+
+// Field numbers of the messages of pprof's profile.proto
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+// WritePprof writes profile in the gzipped protocol buffer format of
+// `go tool pprof`. Each sample holds the calls, wall time and allocated
+// bytes of a call stack's innermost function.
+func WritePprof(w io.Writer, profile *vm.Profile) error {
+	var table stringTable
+	table.index("")
+
+	var b protoBuffer
+	for _, sampleType := range [][2]string{{"calls", "count"}, {"wall", "nanoseconds"}, {"alloc_space", "bytes"}} {
+		b.message(profileSampleType, valueType(&table, sampleType[0], sampleType[1]))
+	}
+	for _, sample := range profile.Samples {
+		var m protoBuffer
+		locations := make([]uint64, len(sample.Stack))
+		for i, function := range sample.Stack {
+			locations[i] = uint64(function) + 1
+		}
+		m.packed(sampleLocationID, locations)
+		m.packed(sampleValue, []uint64{uint64(sample.Calls), uint64(sample.Time.Nanoseconds()), uint64(sample.Memory)})
+		b.message(profileSample, m)
+	}
+	// Each function has a single location, at the line it starts on
+	for i, function := range profile.Functions {
+		var line protoBuffer
+		line.varint(lineFunctionID, uint64(i)+1)
+		line.varint(lineLine, uint64(function.Line))
+		var location protoBuffer
+		location.varint(locationID, uint64(i)+1)
+		location.message(locationLine, line)
+		b.message(profileLocation, location)
+	}
+	for i, function := range profile.Functions {
+		var m protoBuffer
+		m.varint(functionID, uint64(i)+1)
+		m.varint(functionName, table.index(function.Name))
+		m.varint(functionSystemName, table.index(function.Name))
+		m.varint(functionFilename, table.index(function.File))
+		m.varint(functionStartLine, uint64(function.Line))
+		b.message(profileFunction, m)
+	}
+	b.varint(profileTimeNanos, uint64(profile.Started.UnixNano()))
+	b.varint(profileDurationNanos, uint64(profile.Duration.Nanoseconds()))
+	b.message(profilePeriodType, valueType(&table, "wall", "nanoseconds"))
+	b.varint(profilePeriod, 1)
+	b.varint(profileDefaultSampleType, table.index("wall"))
+	for _, s := range table.values {
+		b.bytes(profileStringTable, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func valueType(table *stringTable, typ, unit string) protoBuffer {
+	var m protoBuffer
+	m.varint(valueTypeType, table.index(typ))
+	m.varint(valueTypeUnit, table.index(unit))
+	return m
+}
+
+// stringTable numbers the strings of a profile, which messages refer to by
+// index
+type stringTable struct {
+	values []string
+	ids    map[string]uint64
+}
+
+func (t *stringTable) index(s string) uint64 {
+	if t.ids == nil {
+		t.ids = make(map[string]uint64)
+	}
+	if id, ok := t.ids[s]; ok {
+		return id
+	}
+	id := uint64(len(t.values))
+	t.values = append(t.values, s)
+	t.ids[s] = id
+	return id
+}
+
+// protoBuffer encodes the fields of a protocol buffer message
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) key(field int, wireType int) {
+	b.data = binary.AppendUvarint(b.data, uint64(field)<<3|uint64(wireType))
+}
+
+func (b *protoBuffer) varint(field int, value uint64) {
+	b.key(field, 0)
+	b.data = binary.AppendUvarint(b.data, value)
+}
+
+func (b *protoBuffer) bytes(field int, value []byte) {
+	b.key(field, 2)
+	b.data = binary.AppendUvarint(b.data, uint64(len(value)))
+	b.data = append(b.data, value...)
+}
+
+func (b *protoBuffer) message(field int, m protoBuffer) {
+	b.bytes(field, m.data)
+}
+
+func (b *protoBuffer) packed(field int, values []uint64) {
+	var m protoBuffer
+	for _, value := range values {
+		m.data = binary.AppendUvarint(m.data, value)
+	}
+	b.bytes(field, m.data)
+}
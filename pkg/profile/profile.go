@@ -0,0 +1,100 @@
+// Package profile writes the profiles collected by vm.Profiler, either for
+// `go tool pprof` or for callgrind viewers such as KCachegrind and
+// Webgrind, and names the files requests are profiled to when profiling
+// is enabled with xdebug.mode=profile.
+package profile
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vm"
+)
+
+// WriteFile writes profile to path: in pprof format when the file name
+// ends in .pprof or .pb.gz, and in callgrind format otherwise.
+func WriteFile(path string, profile *vm.Profile, cmd string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if IsPprof(path) {
+		err = WritePprof(f, profile)
+	} else {
+		err = WriteCallgrind(f, profile, cmd)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// IsPprof reports whether WriteFile writes path in pprof format
+func IsPprof(path string) bool {
+	return strings.HasSuffix(path, ".pprof") || strings.HasSuffix(path, ".pb.gz")
+}
+
+// Enabled reports whether xdebug.mode, or the XDEBUG_MODE environment
+// variable that overrides it, turns profiling on
+func Enabled() bool {
+	mode, _ := runtime.GetIniValue("xdebug.mode")
+	if env, ok := os.LookupEnv("XDEBUG_MODE"); ok {
+		mode = env
+	}
+	for _, m := range strings.Split(mode, ",") {
+		if strings.TrimSpace(m) == "profile" {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputFile returns the file to profile a script to, named after
+// xdebug.profiler_output_name in xdebug.output_dir. As in Xdebug, the name
+// can include %p for the process ID, %t and %u for the time in seconds and
+// microseconds, %r for a random number, %s for the script's name and %R for
+// the request URI.
+func OutputFile(script, requestURI string) string {
+	dir, _ := runtime.GetIniValue("xdebug.output_dir")
+	name, _ := runtime.GetIniValue("xdebug.profiler_output_name")
+	if name == "" {
+		name = "cachegrind.out.%p"
+	}
+	sanitize := strings.NewReplacer("/", "_", "\\", "_", ".", "_", "?", "_", "=", "_", "&", "_", " ", "_")
+
+	now := time.Now()
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' || i+1 == len(name) {
+			b.WriteByte(name[i])
+			continue
+		}
+		i++
+		switch name[i] {
+		case 'p':
+			b.WriteString(strconv.Itoa(os.Getpid()))
+		case 't':
+			b.WriteString(strconv.FormatInt(now.Unix(), 10))
+		case 'u':
+			fmt.Fprintf(&b, "%d_%06d", now.Unix(), now.Nanosecond()/1000)
+		case 'r':
+			fmt.Fprintf(&b, "%08x", rand.Uint32())
+		case 's':
+			b.WriteString(sanitize.Replace(script))
+		case 'R':
+			b.WriteString(sanitize.Replace(requestURI))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(name[i])
+		}
+	}
+	return filepath.Join(dir, b.String())
+}
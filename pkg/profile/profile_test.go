@@ -0,0 +1,183 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wudi/hey/compiler"
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/vm"
+	"github.com/wudi/hey/vmfactory"
+)
+
+const testScript = `<?php
+function fib($n) {
+    return $n < 2 ? $n : fib($n - 1) + fib($n - 2);
+}
+class Greeter {
+    public function greet($name) {
+        return str_repeat("hello $name ", 10);
+    }
+}
+$twice = function ($x) { return $x * 2; };
+echo fib(5), "\n";
+echo strlen((new Greeter)->greet("world")), "\n";
+echo $twice(21), "\n";
+`
+
+// profileScript runs testScript with a profiler and returns its profile
+func profileScript(t *testing.T) (*vm.Profile, string) {
+	t.Helper()
+	if registry.GlobalRegistry == nil {
+		registry.Initialize()
+	}
+	require.NoError(t, runtime.Bootstrap())
+	require.NoError(t, runtime.InitializeVMIntegration())
+
+	script := filepath.Join(t.TempDir(), "profiled.php")
+	require.NoError(t, os.WriteFile(script, []byte(testScript), 0644))
+	factory := vmfactory.NewVMFactory(func() vmfactory.Compiler {
+		return compiler.NewCompiler()
+	})
+	compiled, err := factory.CompileFile(script)
+	require.NoError(t, err)
+
+	var output bytes.Buffer
+	ctx := vm.NewExecutionContext()
+	ctx.OutputWriter = &output
+	vmachine := factory.CreateVM()
+	profiler := vm.NewProfiler()
+	vmachine.SetProfiler(profiler)
+	require.NoError(t, vmachine.Execute(ctx, compiled.Instructions, compiled.Constants,
+		compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits))
+	require.Equal(t, "5\n120\n42\n", output.String())
+	return profiler.Stop(), script
+}
+
+func function(t *testing.T, profile *vm.Profile, name string) (int, *vm.ProfileFunction) {
+	t.Helper()
+	for id, fn := range profile.Functions {
+		if fn.Name == name {
+			return id, fn
+		}
+	}
+	t.Fatalf("%s was not profiled", name)
+	return 0, nil
+}
+
+func TestProfiler(t *testing.T) {
+	profile, script := profileScript(t)
+
+	mainID, main := function(t, profile, "{main}")
+	fibID, fib := function(t, profile, "fib")
+	_, greet := function(t, profile, "Greeter->greet")
+	_, closure := function(t, profile, "{closure:"+script+":10}")
+	require.Equal(t, 1, main.Calls)
+	require.Equal(t, 15, fib.Calls)
+	require.Equal(t, 1, greet.Calls)
+	require.Equal(t, 1, closure.Calls)
+	require.Equal(t, []interface{}{script, 3}, []interface{}{fib.File, fib.Line})
+
+	// {main} includes everything but runs little itself
+	require.LessOrEqual(t, main.SelfTime, main.Time)
+	require.GreaterOrEqual(t, main.Time, fib.Time+greet.Time)
+	require.LessOrEqual(t, main.Time, profile.Duration)
+
+	var fromMain, recursive *vm.ProfileCall
+	for _, call := range profile.Calls {
+		switch {
+		case call.Caller == mainID && call.Callee == fibID:
+			fromMain = call
+		case call.Caller == fibID && call.Callee == fibID:
+			recursive = call
+		}
+	}
+	require.Equal(t, []int{1, 11}, []int{fromMain.Calls, fromMain.Line})
+	require.Equal(t, []int{14, 3}, []int{recursive.Calls, recursive.Line})
+
+	// The deepest calls of fib(5) are five calls into fib below {main}
+	deepest := 0
+	for _, sample := range profile.Samples {
+		require.Equal(t, mainID, sample.Stack[len(sample.Stack)-1])
+		deepest = max(deepest, len(sample.Stack))
+	}
+	require.Equal(t, 6, deepest)
+}
+
+func TestWriteCallgrind(t *testing.T) {
+	profile, script := profileScript(t)
+	var out bytes.Buffer
+	require.NoError(t, WriteCallgrind(&out, profile, script))
+
+	text := out.String()
+	require.True(t, strings.HasPrefix(text, "version: 1\n"))
+	require.Contains(t, text, "cmd: "+script+"\n")
+	require.Contains(t, text, "events: Time_(ns) Memory_(bytes)\n")
+	require.Contains(t, text, "fl=(1) "+script+"\nfn=(1) {main}\n")
+	require.Contains(t, text, "cfn=(2) fib\ncalls=1 3\n11 ")
+	require.Contains(t, text, "cfn=(2)\ncalls=14 3\n3 ")
+	require.Regexp(t, `\nsummary: \d+ \d+\n$`, text)
+}
+
+func TestWritePprof(t *testing.T) {
+	profile, script := profileScript(t)
+	var out bytes.Buffer
+	require.NoError(t, WritePprof(&out, profile))
+
+	reader, err := gzip.NewReader(&out)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	// Collect the string table and count the samples
+	var strs []string
+	samples := 0
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+		field, wireType := key>>3, key&7
+		if wireType == 0 {
+			_, n = binary.Uvarint(data)
+			data = data[n:]
+			continue
+		}
+		require.EqualValues(t, 2, wireType)
+		size, n := binary.Uvarint(data)
+		value := data[n : n+int(size)]
+		data = data[n+int(size):]
+		switch field {
+		case 2:
+			samples++
+		case 6:
+			strs = append(strs, string(value))
+		}
+	}
+	require.Equal(t, len(profile.Samples), samples)
+	require.Equal(t, "", strs[0])
+	for _, name := range []string{"calls", "wall", "nanoseconds", "alloc_space", "bytes", "{main}", "fib", "Greeter->greet", script} {
+		require.Contains(t, strs, name)
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+	dir := t.TempDir()
+	require.True(t, runtime.SetIniValue("xdebug.output_dir", dir))
+	require.True(t, runtime.SetIniValue("xdebug.profiler_output_name", "cachegrind.%s.%R.%%"))
+	t.Cleanup(func() {
+		runtime.SetIniValue("xdebug.output_dir", "/tmp")
+		runtime.SetIniValue("xdebug.profiler_output_name", "cachegrind.out.%p")
+	})
+
+	require.Equal(t, filepath.Join(dir, "cachegrind._var_www_index_php._users_id_3.%"),
+		OutputFile("/var/www/index.php", "/users?id=3"))
+	require.True(t, IsPprof("out.pprof"))
+	require.False(t, IsPprof("cachegrind.out.1"))
+}
@@ -3,6 +3,7 @@ package registry
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 
@@ -26,6 +27,9 @@ type HTTPContext interface {
 	AreHeadersSent() (bool, string)
 	SetRequestHeaders(headers map[string]string)
 	GetRequestHeaders() map[string]string
+	GetRequestBody() []byte
+	IsUploadedFile(path string) bool
+	RemoveUploadedFile(path string)
 	FormatHeadersForFastCGI() string
 }
 
@@ -76,6 +80,15 @@ type BuiltinCallContext interface {
 	// ThrowException throws a PHP exception object that can be caught by try-catch blocks.
 	// Returns ErrExceptionThrown sentinel error to indicate exception was thrown.
 	ThrowException(exception *values.Value) error
+	// AutoloadClass invokes the registered autoloaders for a class that is not
+	// yet defined and reports whether it is defined afterwards.
+	AutoloadClass(name string) bool
+	// CallMethod executes a class method with $this bound to this (nil for a
+	// static call) in the scope of className and returns its result.
+	CallMethod(method *Function, this *values.Value, className string, args []*values.Value) (*values.Value, error)
+	// InstantiateClass creates an object of className with its declared
+	// property defaults without running the constructor.
+	InstantiateClass(className string) (*values.Value, error)
 }
 
 // ExecutionContextInterface provides minimal interface for timeout management
@@ -115,7 +128,12 @@ type Function struct {
 	IsAnonymous       bool
 	IsBuiltin         bool
 	IsAbstract        bool
+	IsStatic          bool
+	IsFinal           bool
+	Visibility        string // public, protected or private for class methods
 	ReturnsByReference bool
+	StrictTypes       bool // compiled in a file with declare(strict_types=1)
+	DocComment        string
 	Builtin           BuiltinImplementation
 	Handler      func(interface{}, []*values.Value) (*values.Value, error)
 	MinArgs      int
@@ -144,12 +162,17 @@ type Parameter struct {
 	HasDefault   bool
 	DefaultValue *values.Value
 	Attributes   []*Attribute
+	// IsPromoted is set for constructor parameters that declare a property
+	IsPromoted bool
 }
 
 // Attribute represents a compiled PHP attribute.
 type Attribute struct {
 	Name      string
 	Arguments []*values.Value
+	// ArgumentNames holds the name of each named argument, aligned with
+	// Arguments; positional arguments have an empty name.
+	ArgumentNames []string
 }
 
 // Class models a compiled PHP class definition used by the compiler and VM.
@@ -164,6 +187,18 @@ type Class struct {
 	IsAbstract bool
 	IsFinal    bool
 	Attributes []*Attribute
+	DocComment string
+	// MethodOrder, PropertyOrder and ConstantOrder list the member names in
+	// declaration order, which the maps above do not keep.
+	MethodOrder   []string
+	PropertyOrder []string
+	ConstantOrder []string
+	// IsEnum marks enum declarations; EnumCases lists the case names in
+	// declaration order and EnumBackingType is "int", "string" or empty for
+	// pure enums.
+	IsEnum          bool
+	EnumBackingType string
+	EnumCases       []string
 }
 
 // Property represents a class property.
@@ -193,6 +228,8 @@ type Interface struct {
 	Name    string
 	Methods map[string]*InterfaceMethod
 	Extends []string
+	// Constants holds the constants of builtin interfaces
+	Constants map[string]*ConstantDescriptor
 }
 
 // InterfaceMethod represents a method requirement within an interface.
@@ -208,6 +245,28 @@ type Trait struct {
 	Name       string
 	Properties map[string]*Property
 	Methods    map[string]*Function
+	// MethodOrder and PropertyOrder list the member names in declaration order
+	MethodOrder   []string
+	PropertyOrder []string
+}
+
+// OrderedNames returns the names of members in declaration order. Members
+// missing from order, such as those of builtin classes, follow sorted by name.
+func OrderedNames[T any](order []string, members map[string]T) []string {
+	names := make([]string, 0, len(members))
+	for _, name := range order {
+		if _, ok := members[name]; ok && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	var rest []string
+	for name := range members {
+		if !slices.Contains(names, name) {
+			rest = append(rest, name)
+		}
+	}
+	slices.Sort(rest)
+	return append(names, rest...)
 }
 
 // Constant represents a global constant.
@@ -285,6 +344,10 @@ type ClassDescriptor struct {
 	Properties map[string]*PropertyDescriptor
 	Methods    map[string]*MethodDescriptor
 	Constants  map[string]*ConstantDescriptor
+	// Enum metadata, see Class
+	IsEnum          bool
+	EnumBackingType string
+	EnumCases       []string
 }
 
 // Registry is a threadsafe container for all globally registered symbols.
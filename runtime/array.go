@@ -63,30 +63,21 @@ func isNumeric(s string) bool {
 	return err == nil
 }
 
-// deepCopyArray creates a deep copy of an array value
+// deepCopyArray copies an array value. The copy shares its storage with
+// arr until either side is written to.
 func deepCopyArray(arr *values.Value) *values.Value {
 	if arr == nil || !arr.IsArray() {
 		return values.NewArray()
 	}
+	return values.NewArrayValue(arr.Data.(*values.Array).Copy())
+}
 
-	result := values.NewArray()
-	resultArr := result.Data.(*values.Array)
-	sourceArr := arr.Data.(*values.Array)
-
-	for key, value := range sourceArr.Elements {
-		if value != nil && value.IsArray() {
-			// Recursively copy nested arrays
-			resultArr.Elements[key] = deepCopyArray(value)
-		} else {
-			// Copy primitive values
-			resultArr.Elements[key] = value
-		}
+// replaceWithList replaces the entries of arr with vals, keyed 0, 1, 2...
+func replaceWithList(arr *values.Array, vals []*values.Value) {
+	arr.Clear()
+	for _, val := range vals {
+		arr.Append(val)
 	}
-
-	resultArr.NextIndex = sourceArr.NextIndex
-	resultArr.IsIndexed = sourceArr.IsIndexed
-
-	return result
 }
 
 // replaceRecursive performs recursive array replacement
@@ -98,23 +89,18 @@ func replaceRecursive(base, replacement *values.Value) *values.Value {
 	baseArr := base.Data.(*values.Array)
 	replaceArr := replacement.Data.(*values.Array)
 
-	for key, value := range replaceArr.Elements {
-		existingValue, exists := baseArr.Elements[key]
+	for key, value := range replaceArr.All() {
+		existingValue, exists := baseArr.Lookup(key)
 
 		if exists && existingValue != nil && existingValue.IsArray() && value != nil && value.IsArray() {
 			// Both values are arrays, merge recursively
-			baseArr.Elements[key] = replaceRecursive(existingValue, value)
+			baseArr.Set(key, replaceRecursive(deepCopyArray(existingValue), value))
 		} else {
 			// Replace the value
-			baseArr.Elements[key] = value
+			baseArr.Set(key, value)
 		}
 	}
 
-	// Update NextIndex if needed
-	if replaceArr.NextIndex > baseArr.NextIndex {
-		baseArr.NextIndex = replaceArr.NextIndex
-	}
-
 	return base
 }
 
@@ -124,6 +110,13 @@ func callbackInvoker(ctx registry.BuiltinCallContext, callback *values.Value, ar
 		return nil, fmt.Errorf("callback is null")
 	}
 
+	// The VM runs named functions like closures, with the same argument checks
+	if callback.Type == values.TypeString {
+		if closures, ok := ctx.(ClosureRuntime); ok {
+			return closures.CallClosure(callback, args)
+		}
+	}
+
 	// Handle string callback (function name)
 	if callback.Type == values.TypeString {
 		funcName := callback.ToString()
@@ -144,6 +137,10 @@ func callbackInvoker(ctx registry.BuiltinCallContext, callback *values.Value, ar
 
 	// Handle closure/callable objects
 	if callback.IsCallable() {
+		// The VM runs closures with their bound $this and captured variables
+		if closures, ok := ctx.(ClosureRuntime); ok {
+			return closures.CallClosure(callback, args)
+		}
 		closure := callback.ClosureGet()
 		if closure != nil && closure.Function != nil {
 			if userFunc, ok := closure.Function.(*registry.Function); ok && userFunc != nil && !userFunc.IsBuiltin {
@@ -233,25 +230,11 @@ func GetArrayFunctions() []*registry.Function {
 					return values.NewArray(), nil
 				}
 				arr := args[0].Data.(*values.Array)
-				result := values.NewArray()
-				idx := int64(0)
-				for key := range arr.Elements {
-					var keyVal *values.Value
-					switch k := key.(type) {
-					case string:
-						keyVal = values.NewString(k)
-					case int:
-						keyVal = values.NewInt(int64(k))
-					case int64:
-						keyVal = values.NewInt(k)
-					default:
-						keyVal = values.NewString(fmt.Sprintf("%v", k))
-					}
-					result.Data.(*values.Array).Elements[idx] = keyVal
-					idx++
+				result := values.NewArrayOf(arr.Len())
+				for key := range arr.All() {
+					result.Append(values.KeyValue(key))
 				}
-				result.Data.(*values.Array).NextIndex = idx
-				return result, nil
+				return values.NewArrayValue(result), nil
 			},
 		},
 		{
@@ -266,14 +249,7 @@ func GetArrayFunctions() []*registry.Function {
 					return values.NewArray(), nil
 				}
 				arr := args[0].Data.(*values.Array)
-				result := values.NewArray()
-				idx := int64(0)
-				for _, v := range arr.Elements {
-					result.Data.(*values.Array).Elements[idx] = v
-					idx++
-				}
-				result.Data.(*values.Array).NextIndex = idx
-				return result, nil
+				return values.NewList(arr.Values()...), nil
 			},
 		},
 		{
@@ -294,11 +270,10 @@ func GetArrayFunctions() []*registry.Function {
 				arr := args[0].Data.(*values.Array)
 				// Add all values to the end of the array
 				for i := 1; i < len(args); i++ {
-					nextIndex := len(arr.Elements)
-					arr.Elements[int64(nextIndex)] = args[i]
+					arr.Append(args[i])
 				}
 
-				return values.NewInt(int64(len(arr.Elements))), nil
+				return values.NewInt(int64(arr.Len())), nil
 			},
 		},
 		{
@@ -325,7 +300,7 @@ func GetArrayFunctions() []*registry.Function {
 				}
 
 				// Search for the needle in the array values
-				for _, value := range arr.Elements {
+				for _, value := range arr.All() {
 					if value == nil {
 						continue
 					}
@@ -398,15 +373,15 @@ func GetArrayFunctions() []*registry.Function {
 				currentChunkArr := currentChunk.Data.(*values.Array)
 				chunkIdx := int64(0)
 				itemCount := 0
-				for key, val := range arr.Elements {
+				for key, val := range arr.All() {
 					if preserveKeys {
-						currentChunkArr.Elements[key] = val
+						currentChunkArr.Set(key, val)
 					} else {
-						currentChunkArr.Elements[int64(itemCount)] = val
+						currentChunkArr.Set(int64(itemCount), val)
 					}
 					itemCount++
 					if itemCount >= chunkSize {
-						resultArr.Elements[chunkIdx] = currentChunk
+						resultArr.Set(chunkIdx, currentChunk)
 						chunkIdx++
 						currentChunk = values.NewArray()
 						currentChunkArr = currentChunk.Data.(*values.Array)
@@ -414,13 +389,8 @@ func GetArrayFunctions() []*registry.Function {
 					}
 				}
 				if itemCount > 0 {
-					if !preserveKeys {
-						currentChunkArr.NextIndex = int64(itemCount)
-					}
-					resultArr.Elements[chunkIdx] = currentChunk
-					chunkIdx++
+					resultArr.Set(chunkIdx, currentChunk)
 				}
-				resultArr.NextIndex = chunkIdx
 				return result, nil
 			},
 		},
@@ -446,20 +416,15 @@ func GetArrayFunctions() []*registry.Function {
 				result := values.NewArray()
 				resultArr := result.Data.(*values.Array)
 				keysList := make([]*values.Value, 0, args[0].ArrayCount())
-				for _, k := range keysArr.Elements {
+				for _, k := range keysArr.All() {
 					keysList = append(keysList, k)
 				}
 				valsList := make([]*values.Value, 0, args[1].ArrayCount())
-				for _, v := range valsArr.Elements {
+				for _, v := range valsArr.All() {
 					valsList = append(valsList, v)
 				}
 				for i := 0; i < len(keysList) && i < len(valsList); i++ {
-					keyVal := keysList[i]
-					if keyVal.IsInt() {
-						resultArr.Elements[keyVal.ToInt()] = valsList[i]
-					} else {
-						resultArr.Elements[keyVal.ToString()] = valsList[i]
-					}
+					resultArr.Set(values.ArrayKey(keysList[i]), valsList[i])
 				}
 				return result, nil
 			},
@@ -478,15 +443,15 @@ func GetArrayFunctions() []*registry.Function {
 				arr := args[0].Data.(*values.Array)
 				result := values.NewArray()
 				resultArr := result.Data.(*values.Array)
-				for _, val := range arr.Elements {
+				for _, val := range arr.All() {
 					if val == nil {
 						continue
 					}
 					key := val.ToString()
-					if existing, ok := resultArr.Elements[key]; ok && existing != nil {
-						resultArr.Elements[key] = values.NewInt(existing.ToInt() + 1)
+					if existing, ok := resultArr.Lookup(key); ok && existing != nil {
+						resultArr.Set(key, values.NewInt(existing.ToInt() + 1))
 					} else {
-						resultArr.Elements[key] = values.NewInt(1)
+						resultArr.Set(key, values.NewInt(1))
 					}
 				}
 				return result, nil
@@ -511,7 +476,7 @@ func GetArrayFunctions() []*registry.Function {
 				for i := 1; i < len(args); i++ {
 					if args[i] != nil && args[i].IsArray() {
 						arr := args[i].Data.(*values.Array)
-						for _, v := range arr.Elements {
+						for _, v := range arr.All() {
 							if v != nil {
 								otherValues[v.ToString()] = true
 							}
@@ -520,9 +485,9 @@ func GetArrayFunctions() []*registry.Function {
 				}
 				result := values.NewArray()
 				resultArr := result.Data.(*values.Array)
-				for key, val := range arr1.Elements {
+				for key, val := range arr1.All() {
 					if val != nil && !otherValues[val.ToString()] {
-						resultArr.Elements[key] = val
+						resultArr.Set(key, val)
 					}
 				}
 				return result, nil
@@ -542,25 +507,12 @@ func GetArrayFunctions() []*registry.Function {
 				arr := args[0].Data.(*values.Array)
 				result := values.NewArray()
 				resultArr := result.Data.(*values.Array)
-				for key, val := range arr.Elements {
+				for key, val := range arr.All() {
 					if val == nil {
 						continue
 					}
-					var keyStr string
-					switch k := key.(type) {
-					case string:
-						keyStr = k
-					case int:
-						keyStr = fmt.Sprintf("%d", k)
-					case int64:
-						keyStr = fmt.Sprintf("%d", k)
-					default:
-						keyStr = fmt.Sprintf("%v", key)
-					}
-					if val.IsInt() {
-						resultArr.Elements[val.ToInt()] = values.NewString(keyStr)
-					} else {
-						resultArr.Elements[val.ToString()] = values.NewString(keyStr)
+					if val.IsInt() || val.IsString() {
+						resultArr.Set(values.ArrayKey(val), values.KeyValue(key))
 					}
 				}
 				return result, nil
@@ -583,7 +535,7 @@ func GetArrayFunctions() []*registry.Function {
 				arr1 := args[0].Data.(*values.Array)
 				result := values.NewArray()
 				resultArr := result.Data.(*values.Array)
-				for key, val := range arr1.Elements {
+				for key, val := range arr1.All() {
 					if val == nil {
 						continue
 					}
@@ -594,7 +546,7 @@ func GetArrayFunctions() []*registry.Function {
 						}
 						arr := args[i].Data.(*values.Array)
 						hasValue := false
-						for _, v := range arr.Elements {
+						for _, v := range arr.All() {
 							if v != nil && v.ToString() == val.ToString() {
 								hasValue = true
 								break
@@ -606,7 +558,7 @@ func GetArrayFunctions() []*registry.Function {
 						}
 					}
 					if found {
-						resultArr.Elements[key] = val
+						resultArr.Set(key, val)
 					}
 				}
 				return result, nil
@@ -628,60 +580,16 @@ func GetArrayFunctions() []*registry.Function {
 				if len(args) > 1 && args[1] != nil {
 					preserveKeys = args[1].ToBool()
 				}
-				elements := make([]struct {
-					key interface{}
-					val *values.Value
-				}, 0, args[0].ArrayCount())
-				for k, v := range arr.Elements {
-					elements = append(elements, struct {
-						key interface{}
-						val *values.Value
-					}{k, v})
-				}
-				// Sort elements by key to ensure consistent ordering
-				sort.Slice(elements, func(i, j int) bool {
-					ki, kiOk := elements[i].key.(int64)
-					kj, kjOk := elements[j].key.(int64)
-					if kiOk && kjOk {
-						return ki < kj
-					}
-					if kiOk && !kjOk {
-						return true
-					}
-					if !kiOk && kjOk {
-						return false
-					}
-					return fmt.Sprintf("%v", elements[i].key) < fmt.Sprintf("%v", elements[j].key)
-				})
-				for i, j := 0, len(elements)-1; i < j; i, j = i+1, j-1 {
-					elements[i], elements[j] = elements[j], elements[i]
-				}
-				result := values.NewArray()
-				resultArr := result.Data.(*values.Array)
-
-				// Check if array has string keys (associative array)
-				hasStringKeys := false
-				for _, elem := range elements {
-					if _, isInt := elem.key.(int64); !isInt {
-						hasStringKeys = true
-						break
-					}
-				}
-
-				// PHP always preserves string keys regardless of preserve_keys parameter
-				if preserveKeys || hasStringKeys {
-					for _, elem := range elements {
-						resultArr.Elements[elem.key] = elem.val
-					}
-				} else {
-					idx := int64(0)
-					for _, elem := range elements {
-						resultArr.Elements[idx] = elem.val
-						idx++
+				result := values.NewArrayOf(arr.Len())
+				for key, val := range arr.Backward() {
+					// String keys are kept even when preserve_keys is false
+					if _, isInt := key.(int64); isInt && !preserveKeys {
+						result.Append(val)
+					} else {
+						result.Set(key, val)
 					}
-					resultArr.NextIndex = idx
 				}
-				return result, nil
+				return values.NewArrayValue(result), nil
 			},
 		},
 		{
@@ -698,7 +606,7 @@ func GetArrayFunctions() []*registry.Function {
 				arr := args[0].Data.(*values.Array)
 				sum := float64(0)
 				hasFloat := false
-				for _, val := range arr.Elements {
+				for _, val := range arr.All() {
 					if val == nil {
 						continue
 					}
@@ -744,9 +652,9 @@ func GetArrayFunctions() []*registry.Function {
 
 				// If no callback provided, use default filtering (truthy values)
 				if len(args) < 2 || args[1] == nil {
-					for key, val := range arr.Elements {
+					for key, val := range arr.All() {
 						if val != nil && val.ToBool() {
-							resultArr.Elements[key] = val
+							resultArr.Set(key, val)
 						}
 					}
 					return result, nil
@@ -754,7 +662,7 @@ func GetArrayFunctions() []*registry.Function {
 
 				// Use unified callback invoker for both builtin and user-defined callbacks
 				callback := args[1]
-				for key, val := range arr.Elements {
+				for key, val := range arr.All() {
 					if val != nil {
 						// Call the callback function with the value
 						callArgs := []*values.Value{val}
@@ -764,7 +672,7 @@ func GetArrayFunctions() []*registry.Function {
 						}
 						// Include in result if callback returns truthy value
 						if result_val != nil && result_val.ToBool() {
-							resultArr.Elements[key] = val
+							resultArr.Set(key, val)
 						}
 					}
 				}
@@ -789,7 +697,7 @@ func GetArrayFunctions() []*registry.Function {
 				}
 				result := values.NewArray()
 				resultArr := result.Data.(*values.Array)
-				for key, val := range arr.Elements {
+				for key, val := range arr.All() {
 					newKey := key
 					if strKey, ok := key.(string); ok {
 						if caseMode == 0 {
@@ -798,9 +706,8 @@ func GetArrayFunctions() []*registry.Function {
 							newKey = strings.ToUpper(strKey)
 						}
 					}
-					resultArr.Elements[newKey] = val
+					resultArr.Set(newKey, val)
 				}
-				resultArr.NextIndex = arr.NextIndex
 				return result, nil
 			},
 		},
@@ -820,22 +727,11 @@ func GetArrayFunctions() []*registry.Function {
 
 				arr := args[0].Data.(*values.Array)
 
-				// Extract values maintaining original order for stable sort
-				type valueWithIndex struct {
-					value *values.Value
-					index int
-				}
-
-				var sortedValues []valueWithIndex
-				idx := 0
-				for _, value := range arr.Elements {
-					sortedValues = append(sortedValues, valueWithIndex{value, idx})
-					idx++
-				}
+				sortedValues := arr.Values()
 
 				// Use Go's efficient sort with proper PHP comparison logic
-				sort.Slice(sortedValues, func(i, j int) bool {
-					vi, vj := sortedValues[i].value, sortedValues[j].value
+				sort.SliceStable(sortedValues, func(i, j int) bool {
+					vi, vj := sortedValues[i], sortedValues[j]
 
 					// Handle different type comparisons like PHP
 					if vi.IsInt() && vj.IsInt() {
@@ -851,12 +747,7 @@ func GetArrayFunctions() []*registry.Function {
 				})
 
 				// Rebuild array with new sorted order and numeric indices
-				newElements := make(map[interface{}]*values.Value)
-				for i, item := range sortedValues {
-					newElements[int64(i)] = item.value
-				}
-				arr.Elements = newElements
-				arr.NextIndex = int64(len(sortedValues))
+				replaceWithList(arr, sortedValues)
 
 				return values.NewBool(true), nil
 			},
@@ -901,20 +792,18 @@ func GetArrayFunctions() []*registry.Function {
 
 				// Handle null callback - create array of arrays
 				if callback == nil || callback.IsNull() {
+					lists := make([][]*values.Value, len(arrays))
+					for i, arr := range arrays {
+						lists[i] = arr.Data.(*values.Array).Values()
+					}
 					for i := int64(0); i < minLength; i++ {
 						row := values.NewArray()
 						rowArr := row.Data.(*values.Array)
-
-						for _, arr := range arrays {
-							arrData := arr.Data.(*values.Array)
-							if val, ok := arrData.Elements[i]; ok && val != nil {
-								rowArr.Elements[int64(len(rowArr.Elements))] = val
-							}
+						for _, list := range lists {
+							rowArr.Append(list[i])
 						}
-						rowArr.NextIndex = int64(len(arrays))
-						resultArr.Elements[i] = row
+						resultArr.Append(row)
 					}
-					resultArr.NextIndex = minLength
 					return result, nil
 				}
 
@@ -922,31 +811,9 @@ func GetArrayFunctions() []*registry.Function {
 				if len(arrays) == 1 {
 					firstArr := arrays[0].Data.(*values.Array)
 
-					// Create ordered list of keys for consistent iteration
-					keys := make([]interface{}, 0, len(firstArr.Elements))
-					for key := range firstArr.Elements {
-						keys = append(keys, key)
-					}
-
-					// Sort keys to maintain PHP array order
-					sort.Slice(keys, func(i, j int) bool {
-						ki, iIsInt := keys[i].(int64)
-						kj, jIsInt := keys[j].(int64)
-						if iIsInt && jIsInt {
-							return ki < kj
-						}
-						if iIsInt && !jIsInt {
-							return true
-						}
-						if !iIsInt && jIsInt {
-							return false
-						}
-						return fmt.Sprintf("%v", keys[i]) < fmt.Sprintf("%v", keys[j])
-					})
-
 					// Process each element in order
-					for _, key := range keys {
-						if val, ok := firstArr.Elements[key]; ok && val != nil {
+					for key, val := range firstArr.All() {
+						if val != nil {
 							callArgs := []*values.Value{val}
 
 							// Use unified callback invoker
@@ -955,47 +822,32 @@ func GetArrayFunctions() []*registry.Function {
 								return nil, err
 							}
 							if result_val != nil {
-								resultArr.Elements[key] = result_val
+								resultArr.Set(key, result_val)
 							}
 						}
 					}
 				} else {
-					// Handle multiple arrays case (complex multi-array mapping)
-					firstArr := arrays[0].Data.(*values.Array)
-					for key, _ := range firstArr.Elements {
-						// Get values from all arrays at this key
-						var callArgs []*values.Value
-						for _, arr := range arrays {
-							arrData := arr.Data.(*values.Array)
-							if val, ok := arrData.Elements[key]; ok && val != nil {
-								callArgs = append(callArgs, val)
-							} else {
-								callArgs = append(callArgs, values.NewNull())
-							}
+					// Handle multiple arrays case: the values are passed by
+					// position and the result is a list
+					lists := make([][]*values.Value, len(arrays))
+					for i, arr := range arrays {
+						lists[i] = arr.Data.(*values.Array).Values()
+					}
+					for i := int64(0); i < minLength; i++ {
+						callArgs := make([]*values.Value, len(lists))
+						for j, list := range lists {
+							callArgs[j] = list[i]
 						}
 
 						// Use unified callback invoker
-						if len(callArgs) > 0 {
-							result_val, err := callbackInvoker(ctx, callback, callArgs)
-							if err != nil {
-								return nil, err
-							}
-							if result_val != nil {
-								resultArr.Elements[key] = result_val
-							}
+						result_val, err := callbackInvoker(ctx, callback, callArgs)
+						if err != nil {
+							return nil, err
 						}
-					}
-				}
-
-				// Ensure result array NextIndex is properly set
-				if len(resultArr.Elements) > 0 {
-					maxKey := int64(0)
-					for key := range resultArr.Elements {
-						if intKey, ok := key.(int64); ok && intKey >= maxKey {
-							maxKey = intKey + 1
+						if result_val != nil {
+							resultArr.Append(result_val)
 						}
 					}
-					resultArr.NextIndex = maxKey
 				}
 
 				return result, nil
@@ -1034,27 +886,7 @@ func GetArrayFunctions() []*registry.Function {
 					preserveKeys = args[3].ToBool()
 				}
 
-				// Convert map to ordered slice
-				type keyValue struct {
-					key interface{}
-					val *values.Value
-				}
-
-				var elements []keyValue
-				// For arrays, we need to maintain insertion order
-				// Use NextIndex to determine the maximum numeric index
-				for i := int64(0); i < arr.NextIndex; i++ {
-					if val, ok := arr.Elements[i]; ok {
-						elements = append(elements, keyValue{i, val})
-					}
-				}
-				// Add string keys
-				for key, val := range arr.Elements {
-					if _, isInt := key.(int64); !isInt {
-						elements = append(elements, keyValue{key, val})
-					}
-				}
-
+				keys, elements := arr.Keys(), arr.Values()
 				arrLen := len(elements)
 
 				// Handle negative offset
@@ -1093,22 +925,16 @@ func GetArrayFunctions() []*registry.Function {
 				result := values.NewArray()
 				resultArr := result.Data.(*values.Array)
 
-				// Extract slice
-				newIndex := int64(0)
+				// Extract slice. String keys are kept even when preserve_keys
+				// is false.
 				for i := offset; i < end; i++ {
-					elem := elements[i]
-					if preserveKeys {
-						resultArr.Elements[elem.key] = elem.val
+					if _, isInt := keys[i].(int64); isInt && !preserveKeys {
+						resultArr.Append(elements[i])
 					} else {
-						resultArr.Elements[newIndex] = elem.val
-						newIndex++
+						resultArr.Set(keys[i], elements[i])
 					}
 				}
 
-				if !preserveKeys {
-					resultArr.NextIndex = newIndex
-				}
-
 				return result, nil
 			},
 		},
@@ -1137,77 +963,38 @@ func GetArrayFunctions() []*registry.Function {
 					strict = args[2].ToBool()
 				}
 
-				// Search through array elements
-				// First search numeric indices in order
-				for i := int64(0); i < arr.NextIndex; i++ {
-					if val, ok := arr.Elements[i]; ok {
-						if strict {
-							// Strict comparison: same type and value
-							if needle.Type == val.Type {
-								switch needle.Type {
-								case values.TypeInt:
-									if needle.ToInt() == val.ToInt() {
-										return values.NewInt(i), nil
-									}
-								case values.TypeFloat:
-									if needle.ToFloat() == val.ToFloat() {
-										return values.NewInt(i), nil
-									}
-								case values.TypeString:
-									if needle.ToString() == val.ToString() {
-										return values.NewInt(i), nil
-									}
-								case values.TypeBool:
-									if needle.ToBool() == val.ToBool() {
-										return values.NewInt(i), nil
-									}
-								case values.TypeNull:
-									// Both are null
-									return values.NewInt(i), nil
+				// Search through array elements in order
+				for key, val := range arr.All() {
+					if strict {
+						// Strict comparison: same type and value
+						if needle.Type == val.Type {
+							switch needle.Type {
+							case values.TypeInt:
+								if needle.ToInt() == val.ToInt() {
+									return values.KeyValue(key), nil
 								}
-							}
-						} else {
-							// Loose comparison: convert to string and compare
-							if needle.ToString() == val.ToString() {
-								return values.NewInt(i), nil
-							}
-						}
-					}
-				}
-
-				// Then search string keys
-				for key, val := range arr.Elements {
-					if _, isInt := key.(int64); !isInt {
-						if strict {
-							// Strict comparison
-							if needle.Type == val.Type {
-								switch needle.Type {
-								case values.TypeInt:
-									if needle.ToInt() == val.ToInt() {
-										return values.NewString(fmt.Sprintf("%v", key)), nil
-									}
-								case values.TypeFloat:
-									if needle.ToFloat() == val.ToFloat() {
-										return values.NewString(fmt.Sprintf("%v", key)), nil
-									}
-								case values.TypeString:
-									if needle.ToString() == val.ToString() {
-										return values.NewString(fmt.Sprintf("%v", key)), nil
-									}
-								case values.TypeBool:
-									if needle.ToBool() == val.ToBool() {
-										return values.NewString(fmt.Sprintf("%v", key)), nil
-									}
-								case values.TypeNull:
-									return values.NewString(fmt.Sprintf("%v", key)), nil
+							case values.TypeFloat:
+								if needle.ToFloat() == val.ToFloat() {
+									return values.KeyValue(key), nil
 								}
-							}
-						} else {
-							// Loose comparison
-							if needle.ToString() == val.ToString() {
-								return values.NewString(fmt.Sprintf("%v", key)), nil
+							case values.TypeString:
+								if needle.ToString() == val.ToString() {
+									return values.KeyValue(key), nil
+								}
+							case values.TypeBool:
+								if needle.ToBool() == val.ToBool() {
+									return values.KeyValue(key), nil
+								}
+							case values.TypeNull:
+								// Both are null
+								return values.KeyValue(key), nil
 							}
 						}
+					} else {
+						// Loose comparison: convert to string and compare
+						if needle.ToString() == val.ToString() {
+							return values.KeyValue(key), nil
+						}
 					}
 				}
 
@@ -1231,42 +1018,11 @@ func GetArrayFunctions() []*registry.Function {
 
 				arr := args[0].Data.(*values.Array)
 
-				// Find the last element
-				var lastKey interface{} = nil
-				var lastVal *values.Value = nil
-
-				// First check numeric indices from highest to lowest
-				for i := arr.NextIndex - 1; i >= 0; i-- {
-					if val, ok := arr.Elements[i]; ok {
-						lastKey = i
-						lastVal = val
-						break
-					}
-				}
-
-				// If no numeric index found, check string keys
-				if lastKey == nil {
-					for key, val := range arr.Elements {
-						if _, isInt := key.(int64); !isInt {
-							lastKey = key
-							lastVal = val
-							// Note: order of string keys is not guaranteed, but PHP behavior is similar
-						}
-					}
-				}
-
-				if lastKey == nil {
+				_, lastVal, ok := arr.Pop()
+				if !ok {
 					return values.NewNull(), nil
 				}
 
-				// Remove the element
-				delete(arr.Elements, lastKey)
-
-				// Update NextIndex if it was a numeric key
-				if intKey, ok := lastKey.(int64); ok && intKey == arr.NextIndex-1 {
-					arr.NextIndex--
-				}
-
 				return lastVal, nil
 			},
 		},
@@ -1286,59 +1042,12 @@ func GetArrayFunctions() []*registry.Function {
 
 				arr := args[0].Data.(*values.Array)
 
-				// Find the first element
-				var firstKey interface{} = nil
-				var firstVal *values.Value = nil
-
-				// First check numeric indices from lowest to highest
-				for i := int64(0); i < arr.NextIndex; i++ {
-					if val, ok := arr.Elements[i]; ok {
-						firstKey = i
-						firstVal = val
-						break
-					}
-				}
-
-				// If no numeric index found, check string keys
-				if firstKey == nil {
-					for key, val := range arr.Elements {
-						if _, isInt := key.(int64); !isInt {
-							firstKey = key
-							firstVal = val
-							break // Take first string key found
-						}
-					}
-				}
-
-				if firstKey == nil {
+				// Removing the first element renumbers the integer keys
+				_, firstVal, ok := arr.Shift()
+				if !ok {
 					return values.NewNull(), nil
 				}
 
-				// Remove the element
-				delete(arr.Elements, firstKey)
-
-				// For numeric keys, we need to shift all other keys down
-				if intKey, ok := firstKey.(int64); ok {
-					// Create new elements map with shifted keys
-					newElements := make(map[interface{}]*values.Value)
-					for key, val := range arr.Elements {
-						if keyInt, isInt := key.(int64); isInt && keyInt > intKey {
-							// Shift numeric keys down by 1
-							newElements[keyInt-1] = val
-						} else if !isInt {
-							// Keep string keys as-is
-							newElements[key] = val
-						} else {
-							// Keep lower numeric keys
-							newElements[key] = val
-						}
-					}
-					arr.Elements = newElements
-					if arr.NextIndex > 0 {
-						arr.NextIndex--
-					}
-				}
-
 				return firstVal, nil
 			},
 		},
@@ -1360,33 +1069,23 @@ func GetArrayFunctions() []*registry.Function {
 				arr := args[0].Data.(*values.Array)
 				values_to_add := args[1:]
 
-				// Create new elements map
-				newElements := make(map[interface{}]*values.Value)
-
-				// Add new values to the beginning with indices 0, 1, 2...
-				for i, val := range values_to_add {
-					newElements[int64(i)] = val
+				// Add new values to the beginning and renumber the existing
+				// numeric keys after them
+				keys, elements := arr.Keys(), arr.Values()
+				arr.Clear()
+				for _, val := range values_to_add {
+					arr.Append(val)
 				}
-
-				numAdded := int64(len(values_to_add))
-
-				// Shift existing numeric keys up by numAdded
-				for key, val := range arr.Elements {
-					if keyInt, isInt := key.(int64); isInt {
-						// Shift numeric keys up
-						newElements[keyInt+numAdded] = val
+				for i, key := range keys {
+					if _, isInt := key.(int64); isInt {
+						arr.Append(elements[i])
 					} else {
-						// Keep string keys as-is
-						newElements[key] = val
+						arr.Set(key, elements[i])
 					}
 				}
 
-				// Update the array
-				arr.Elements = newElements
-				arr.NextIndex += numAdded
-
 				// Return the new length
-				return values.NewInt(int64(len(arr.Elements))), nil
+				return values.NewInt(int64(arr.Len())), nil
 			},
 		},
 		{
@@ -1409,7 +1108,7 @@ func GetArrayFunctions() []*registry.Function {
 				size := int(args[1].ToInt())
 				padValue := args[2]
 
-				currentLength := len(arr.Elements)
+				currentLength := arr.Len()
 				absSize := size
 				if absSize < 0 {
 					absSize = -absSize
@@ -1417,53 +1116,29 @@ func GetArrayFunctions() []*registry.Function {
 
 				// If size is less than or equal to current length, return copy
 				if absSize <= currentLength {
-					result := values.NewArray()
-					resultArr := result.Data.(*values.Array)
-					for key, val := range arr.Elements {
-						resultArr.Elements[key] = val
-					}
-					resultArr.NextIndex = arr.NextIndex
-					return result, nil
+					return deepCopyArray(args[0]), nil
 				}
 
+				// Numeric keys are renumbered and string keys kept
 				result := values.NewArray()
 				resultArr := result.Data.(*values.Array)
 				padCount := absSize - currentLength
-
-				if size > 0 {
-					// Pad to the right: original elements first, then padding
-					// Copy existing elements
-					for key, val := range arr.Elements {
-						resultArr.Elements[key] = val
-					}
-
-					// Add padding at the end with numeric indices
-					nextIndex := arr.NextIndex
+				if size < 0 {
 					for i := 0; i < padCount; i++ {
-						resultArr.Elements[nextIndex] = padValue
-						nextIndex++
+						resultArr.Append(padValue)
 					}
-					resultArr.NextIndex = nextIndex
-
-				} else {
-					// Pad to the left: padding first, then original elements
-					// Add padding at the beginning
-					for i := int64(0); i < int64(padCount); i++ {
-						resultArr.Elements[i] = padValue
+				}
+				for key, val := range arr.All() {
+					if _, isInt := key.(int64); isInt {
+						resultArr.Append(val)
+					} else {
+						resultArr.Set(key, val)
 					}
-
-					// Shift and copy existing elements
-					for key, val := range arr.Elements {
-						if keyInt, isInt := key.(int64); isInt {
-							// Shift numeric keys
-							resultArr.Elements[keyInt+int64(padCount)] = val
-						} else {
-							// Keep string keys as-is
-							resultArr.Elements[key] = val
-						}
+				}
+				if size > 0 {
+					for i := 0; i < padCount; i++ {
+						resultArr.Append(padValue)
 					}
-
-					resultArr.NextIndex = int64(padCount) + arr.NextIndex
 				}
 
 				return result, nil
@@ -1498,15 +1173,7 @@ func GetArrayFunctions() []*registry.Function {
 
 				for i := 0; i < count; i++ {
 					key := startIndex + int64(i)
-					resultArr.Elements[key] = value
-				}
-
-				// Set NextIndex appropriately
-				if startIndex >= 0 {
-					maxIndex := startIndex + int64(count)
-					if maxIndex > resultArr.NextIndex {
-						resultArr.NextIndex = maxIndex
-					}
+					resultArr.Set(key, value)
 				}
 
 				return result, nil
@@ -1534,19 +1201,12 @@ func GetArrayFunctions() []*registry.Function {
 				resultArr := result.Data.(*values.Array)
 
 				// Iterate through all keys and use them as keys in result
-				for _, keyVal := range keysArr.Elements {
+				for _, keyVal := range keysArr.All() {
 					if keyVal == nil {
 						continue
 					}
 
-					var resultKey interface{}
-					if keyVal.IsInt() {
-						resultKey = keyVal.ToInt()
-					} else {
-						resultKey = keyVal.ToString()
-					}
-
-					resultArr.Elements[resultKey] = value
+					resultArr.Set(values.ArrayKey(keyVal), value)
 				}
 
 				return result, nil
@@ -1595,17 +1255,16 @@ func GetArrayFunctions() []*registry.Function {
 						if startChar <= endChar {
 							// Ascending
 							for char := startChar; char <= endChar; char++ {
-								resultArr.Elements[idx] = values.NewString(string(rune(char)))
+								resultArr.Set(idx, values.NewString(string(rune(char))))
 								idx++
 							}
 						} else {
 							// Descending
 							for char := startChar; char >= endChar; char-- {
-								resultArr.Elements[idx] = values.NewString(string(rune(char)))
+								resultArr.Set(idx, values.NewString(string(rune(char))))
 								idx++
 							}
 						}
-						resultArr.NextIndex = idx
 						return result, nil
 					}
 				}
@@ -1627,9 +1286,9 @@ func GetArrayFunctions() []*registry.Function {
 					for current <= endNum {
 						// Use appropriate type for result
 						if start.IsInt() && end.IsInt() && step.IsInt() {
-							resultArr.Elements[idx] = values.NewInt(int64(current))
+							resultArr.Set(idx, values.NewInt(int64(current)))
 						} else {
-							resultArr.Elements[idx] = values.NewFloat(current)
+							resultArr.Set(idx, values.NewFloat(current))
 						}
 						idx++
 						current += stepNum
@@ -1647,9 +1306,9 @@ func GetArrayFunctions() []*registry.Function {
 					for current >= endNum {
 						// Use appropriate type for result
 						if start.IsInt() && end.IsInt() && step.IsInt() {
-							resultArr.Elements[idx] = values.NewInt(int64(current))
+							resultArr.Set(idx, values.NewInt(int64(current)))
 						} else {
-							resultArr.Elements[idx] = values.NewFloat(current)
+							resultArr.Set(idx, values.NewFloat(current))
 						}
 						idx++
 						current += stepNum
@@ -1661,7 +1320,6 @@ func GetArrayFunctions() []*registry.Function {
 					}
 				}
 
-				resultArr.NextIndex = idx
 				return result, nil
 			},
 		},
@@ -1695,41 +1353,10 @@ func GetArrayFunctions() []*registry.Function {
 				// Handle replacement parameter
 				var replacement []*values.Value
 				if len(args) > 3 && args[3] != nil && args[3].IsArray() {
-					replacementArr := args[3].Data.(*values.Array)
-					for i := int64(0); i < replacementArr.NextIndex; i++ {
-						if val, ok := replacementArr.Elements[i]; ok {
-							replacement = append(replacement, val)
-						}
-					}
-					// Also handle string keys in replacement
-					for key, val := range replacementArr.Elements {
-						if _, isInt := key.(int64); !isInt {
-							replacement = append(replacement, val)
-						}
-					}
-				}
-
-				// Convert array to ordered slice for easier manipulation
-				type keyValue struct {
-					key interface{}
-					val *values.Value
-				}
-
-				var elements []keyValue
-				// First add numeric keys in order
-				for i := int64(0); i < arr.NextIndex; i++ {
-					if val, ok := arr.Elements[i]; ok {
-						elements = append(elements, keyValue{i, val})
-					}
-				}
-				// Then add string keys (they'll be preserved in position)
-				stringKeys := make([]keyValue, 0)
-				for key, val := range arr.Elements {
-					if _, isInt := key.(int64); !isInt {
-						stringKeys = append(stringKeys, keyValue{key, val})
-					}
+					replacement = args[3].Data.(*values.Array).Values()
 				}
 
+				keys, elements := arr.Keys(), arr.Values()
 				arrLen := len(elements)
 
 				// Handle negative offset
@@ -1761,47 +1388,40 @@ func GetArrayFunctions() []*registry.Function {
 					}
 				}
 
-				// Create removed elements array
+				// Create removed elements array, which keeps string keys
 				removed := values.NewArray()
 				removedArr := removed.Data.(*values.Array)
-				removedIdx := int64(0)
-				for i := 0; i < actualLength; i++ {
-					if offset+i < len(elements) {
-						elem := elements[offset+i]
-						// For removed array, use sequential numeric keys starting from 0
-						removedArr.Elements[removedIdx] = elem.val
-						removedIdx++
+				for i := offset; i < offset+actualLength; i++ {
+					if _, isInt := keys[i].(int64); isInt {
+						removedArr.Append(elements[i])
+					} else {
+						removedArr.Set(keys[i], elements[i])
 					}
 				}
-				removedArr.NextIndex = removedIdx
 
-				// Build new elements slice
-				newElements := make([]keyValue, 0)
-				// Add elements before offset
-				for i := 0; i < offset && i < len(elements); i++ {
-					newElements = append(newElements, elements[i])
-				}
-				// Add replacement elements
-				for _, val := range replacement {
-					newElements = append(newElements, keyValue{int64(len(newElements)), val})
-				}
-				// Add elements after removed section
-				for i := offset + actualLength; i < len(elements); i++ {
-					newElements = append(newElements, keyValue{int64(len(newElements)), elements[i].val})
-				}
-
-				// Rebuild the original array
-				arr.Elements = make(map[interface{}]*values.Value)
-				for _, elem := range newElements {
-					arr.Elements[elem.key] = elem.val
+				// Rebuild the original array with the replacement in place of
+				// the removed elements, renumbering the numeric keys
+				arr.Clear()
+				for i := range keys {
+					if i == offset {
+						for _, val := range replacement {
+							arr.Append(val)
+						}
+					}
+					if i >= offset && i < offset+actualLength {
+						continue
+					}
+					if _, isInt := keys[i].(int64); isInt {
+						arr.Append(elements[i])
+					} else {
+						arr.Set(keys[i], elements[i])
+					}
 				}
-				// Re-add string keys
-				for _, elem := range stringKeys {
-					// Only keep string keys that weren't in the removed range
-					// For simplicity, keep all string keys (PHP behavior is complex here)
-					arr.Elements[elem.key] = elem.val
+				if offset == len(keys) {
+					for _, val := range replacement {
+						arr.Append(val)
+					}
 				}
-				arr.NextIndex = int64(len(newElements))
 
 				return removed, nil
 			},
@@ -1836,32 +1456,10 @@ func GetArrayFunctions() []*registry.Function {
 				// Iterate over the input array in order
 				arrayData := array.Data.(*values.Array)
 
-				// Create ordered list of keys
-				keys := make([]interface{}, 0, len(arrayData.Elements))
-				for k := range arrayData.Elements {
-					keys = append(keys, k)
-				}
-
-				// Sort keys to maintain order (numeric keys first, then string keys)
-				sort.Slice(keys, func(i, j int) bool {
-					ki, iIsInt := keys[i].(int64)
-					kj, jIsInt := keys[j].(int64)
-
-					if iIsInt && jIsInt {
-						return ki < kj
-					}
-					if iIsInt && !jIsInt {
-						return true // int keys come first
-					}
-					if !iIsInt && jIsInt {
-						return false // string keys come after
-					}
-					// both string keys
-					return fmt.Sprintf("%v", keys[i]) < fmt.Sprintf("%v", keys[j])
-				})
+				keys := arrayData.Keys()
 
 				for _, key := range keys {
-					element := arrayData.Elements[key]
+					element := arrayData.Get(key)
 					if element.Type != values.TypeArray {
 						continue // Skip non-array elements
 					}
@@ -1875,9 +1473,9 @@ func GetArrayFunctions() []*registry.Function {
 						// If column_key is null, return the whole element
 						colValue = element
 					} else if columnKey.Type == values.TypeString {
-						colValue = elementArr.Elements[columnKey.Data.(string)]
+						colValue = elementArr.Get(columnKey.Data.(string))
 					} else if columnKey.Type == values.TypeInt {
-						colValue = elementArr.Elements[columnKey.Data.(int64)]
+						colValue = elementArr.Get(columnKey.Data.(int64))
 					}
 
 					// If column doesn't exist, skip this element
@@ -1889,9 +1487,9 @@ func GetArrayFunctions() []*registry.Function {
 					if indexKey != nil && !indexKey.IsNull() {
 						var indexVal *values.Value
 						if indexKey.Type == values.TypeString {
-							indexVal = elementArr.Elements[indexKey.Data.(string)]
+							indexVal = elementArr.Get(indexKey.Data.(string))
 						} else if indexKey.Type == values.TypeInt {
-							indexVal = elementArr.Elements[indexKey.Data.(int64)]
+							indexVal = elementArr.Get(indexKey.Data.(int64))
 						}
 
 						if indexVal != nil {
@@ -1912,10 +1510,7 @@ func GetArrayFunctions() []*registry.Function {
 						resultIndex++
 					}
 
-					resultArr.Elements[keyValue] = colValue
-					if numKey, ok := keyValue.(int64); ok && numKey >= resultArr.NextIndex {
-						resultArr.NextIndex = numKey + 1
-					}
+					resultArr.Set(keyValue, colValue)
 				}
 
 				return result, nil
@@ -1953,39 +1548,17 @@ func GetArrayFunctions() []*registry.Function {
 				arrayData := array.Data.(*values.Array)
 				resultIndex := int64(0)
 
-				// Create ordered list of keys
-				keys := make([]interface{}, 0, len(arrayData.Elements))
-				for k := range arrayData.Elements {
-					keys = append(keys, k)
-				}
-
-				// Sort keys to maintain order (numeric keys first, then string keys)
-				sort.Slice(keys, func(i, j int) bool {
-					ki, iIsInt := keys[i].(int64)
-					kj, jIsInt := keys[j].(int64)
-
-					if iIsInt && jIsInt {
-						return ki < kj
-					}
-					if iIsInt && !jIsInt {
-						return true // int keys come first
-					}
-					if !iIsInt && jIsInt {
-						return false // string keys come after
-					}
-					// both string keys
-					return fmt.Sprintf("%v", keys[i]) < fmt.Sprintf("%v", keys[j])
-				})
+				keys := arrayData.Keys()
 
 				for _, key := range keys {
-					value := arrayData.Elements[key]
+					value := arrayData.Get(key)
 
 					// If no search value, include all keys
 					if searchValue == nil {
 						if numKey, ok := key.(int64); ok {
-							resultArr.Elements[resultIndex] = values.NewInt(numKey)
+							resultArr.Set(resultIndex, values.NewInt(numKey))
 						} else {
-							resultArr.Elements[resultIndex] = values.NewString(key.(string))
+							resultArr.Set(resultIndex, values.NewString(key.(string)))
 						}
 						resultIndex++
 					} else {
@@ -2014,16 +1587,15 @@ func GetArrayFunctions() []*registry.Function {
 
 						if matches {
 							if numKey, ok := key.(int64); ok {
-								resultArr.Elements[resultIndex] = values.NewInt(numKey)
+								resultArr.Set(resultIndex, values.NewInt(numKey))
 							} else {
-								resultArr.Elements[resultIndex] = values.NewString(key.(string))
+								resultArr.Set(resultIndex, values.NewString(key.(string)))
 							}
 							resultIndex++
 						}
 					}
 				}
 
-				resultArr.NextIndex = resultIndex
 				return result, nil
 			},
 		},
@@ -2048,38 +1620,15 @@ func GetArrayFunctions() []*registry.Function {
 				arrayData := array.Data.(*values.Array)
 				resultIndex := int64(0)
 
-				// Create ordered list of keys
-				keys := make([]interface{}, 0, len(arrayData.Elements))
-				for k := range arrayData.Elements {
-					keys = append(keys, k)
-				}
-
-				// Sort keys to maintain order (numeric keys first, then string keys)
-				sort.Slice(keys, func(i, j int) bool {
-					ki, iIsInt := keys[i].(int64)
-					kj, jIsInt := keys[j].(int64)
-
-					if iIsInt && jIsInt {
-						return ki < kj
-					}
-					if iIsInt && !jIsInt {
-						return true // int keys come first
-					}
-					if !iIsInt && jIsInt {
-						return false // string keys come after
-					}
-					// both string keys
-					return fmt.Sprintf("%v", keys[i]) < fmt.Sprintf("%v", keys[j])
-				})
+				keys := arrayData.Keys()
 
 				// Add all values with new sequential keys
 				for _, key := range keys {
-					value := arrayData.Elements[key]
-					resultArr.Elements[resultIndex] = value
+					value := arrayData.Get(key)
+					resultArr.Set(resultIndex, value)
 					resultIndex++
 				}
 
-				resultArr.NextIndex = resultIndex
 				return result, nil
 			},
 		},
@@ -2106,46 +1655,23 @@ func GetArrayFunctions() []*registry.Function {
 
 					arrayData := array.Data.(*values.Array)
 
-					// Create ordered list of keys
-					keys := make([]interface{}, 0, len(arrayData.Elements))
-					for k := range arrayData.Elements {
-						keys = append(keys, k)
-					}
-
-					// Sort keys to maintain order (numeric keys first, then string keys)
-					sort.Slice(keys, func(i, j int) bool {
-						ki, iIsInt := keys[i].(int64)
-						kj, jIsInt := keys[j].(int64)
-
-						if iIsInt && jIsInt {
-							return ki < kj
-						}
-						if iIsInt && !jIsInt {
-							return true // int keys come first
-						}
-						if !iIsInt && jIsInt {
-							return false // string keys come after
-						}
-						// both string keys
-						return fmt.Sprintf("%v", keys[i]) < fmt.Sprintf("%v", keys[j])
-					})
+					keys := arrayData.Keys()
 
 					// Merge values according to PHP rules
 					for _, key := range keys {
-						value := arrayData.Elements[key]
+						value := arrayData.Get(key)
 
 						if _, isNumKey := key.(int64); isNumKey {
 							// Numeric keys: always reindex
-							resultArr.Elements[numericIndex] = value
+							resultArr.Set(numericIndex, value)
 							numericIndex++
 						} else {
 							// String keys: preserve key, overwrite if exists
-							resultArr.Elements[key] = value
+							resultArr.Set(key, value)
 						}
 					}
 				}
 
-				resultArr.NextIndex = numericIndex
 				return result, nil
 			},
 		},
@@ -2174,33 +1700,11 @@ func GetArrayFunctions() []*registry.Function {
 				// Track seen values
 				seen := make(map[string]bool)
 
-				// Create ordered list of keys
-				keys := make([]interface{}, 0, len(arrayData.Elements))
-				for k := range arrayData.Elements {
-					keys = append(keys, k)
-				}
-
-				// Sort keys to maintain order (numeric keys first, then string keys)
-				sort.Slice(keys, func(i, j int) bool {
-					ki, iIsInt := keys[i].(int64)
-					kj, jIsInt := keys[j].(int64)
-
-					if iIsInt && jIsInt {
-						return ki < kj
-					}
-					if iIsInt && !jIsInt {
-						return true // int keys come first
-					}
-					if !iIsInt && jIsInt {
-						return false // string keys come after
-					}
-					// both string keys
-					return fmt.Sprintf("%v", keys[i]) < fmt.Sprintf("%v", keys[j])
-				})
+				keys := arrayData.Keys()
 
 				// Process values in order, keeping only first occurrence
 				for _, key := range keys {
-					value := arrayData.Elements[key]
+					value := arrayData.Get(key)
 
 					// Create a string representation for comparison
 					var valueKey string
@@ -2224,12 +1728,7 @@ func GetArrayFunctions() []*registry.Function {
 					// Only add if we haven't seen this value before
 					if !seen[valueKey] {
 						seen[valueKey] = true
-						resultArr.Elements[key] = value
-
-						// Update NextIndex for numeric keys
-						if numKey, ok := key.(int64); ok && numKey >= resultArr.NextIndex {
-							resultArr.NextIndex = numKey + 1
-						}
+						resultArr.Set(key, value)
 					}
 				}
 
@@ -2262,7 +1761,7 @@ func GetArrayFunctions() []*registry.Function {
 					searchKey = key.ToString()
 				}
 
-				_, exists := arr.Elements[searchKey]
+				_, exists := arr.Lookup(searchKey)
 				return values.NewBool(exists), nil
 			},
 		},
@@ -2302,55 +1801,11 @@ func GetArrayFunctions() []*registry.Function {
 					return values.NewNull(), nil
 				}
 
-				arr := args[0].Data.(*values.Array)
-				if len(arr.Elements) == 0 {
-					return values.NewNull(), nil
-				}
-
-				// Find the first key in insertion order
-				// We need to iterate through keys in their original order
-				var firstKey interface{}
-
-				// Get all keys and find minimum based on insertion order
-				// Since Go maps don't preserve insertion order, we'll use a heuristic
-				// Check if this is a purely numeric array first
-				allNumeric := true
-				for key := range arr.Elements {
-					if _, isInt := key.(int64); !isInt {
-						allNumeric = false
-						break
-					}
-				}
-
-				if allNumeric {
-					// For numeric arrays, find minimum key
-					minKey := int64(9223372036854775807) // MaxInt64
-					found := false
-					for key := range arr.Elements {
-						if intKey, ok := key.(int64); ok && intKey < minKey {
-							minKey = intKey
-							found = true
-						}
-					}
-					if found {
-						firstKey = minKey
-					}
-				} else {
-					// For mixed arrays, just take first key found
-					for key := range arr.Elements {
-						firstKey = key
-						break
-					}
-				}
-
-				if firstKey == nil {
+				firstKey, _, ok := args[0].Data.(*values.Array).First()
+				if !ok {
 					return values.NewNull(), nil
 				}
-
-				if intKey, ok := firstKey.(int64); ok {
-					return values.NewInt(intKey), nil
-				}
-				return values.NewString(fmt.Sprintf("%v", firstKey)), nil
+				return values.KeyValue(firstKey), nil
 			},
 		},
 		{
@@ -2367,51 +1822,11 @@ func GetArrayFunctions() []*registry.Function {
 					return values.NewNull(), nil
 				}
 
-				arr := args[0].Data.(*values.Array)
-				if len(arr.Elements) == 0 {
-					return values.NewNull(), nil
-				}
-
-				// Find the last key in insertion order
-				var lastKey interface{}
-
-				// Check if this is a purely numeric array first
-				allNumeric := true
-				for key := range arr.Elements {
-					if _, isInt := key.(int64); !isInt {
-						allNumeric = false
-						break
-					}
-				}
-
-				if allNumeric {
-					// For numeric arrays, find maximum key
-					maxKey := int64(-9223372036854775808) // MinInt64
-					found := false
-					for key := range arr.Elements {
-						if intKey, ok := key.(int64); ok && intKey > maxKey {
-							maxKey = intKey
-							found = true
-						}
-					}
-					if found {
-						lastKey = maxKey
-					}
-				} else {
-					// For mixed arrays, just take last key found (Go map iteration order)
-					for key := range arr.Elements {
-						lastKey = key // Keep overwriting to get "last"
-					}
-				}
-
-				if lastKey == nil {
+				lastKey, _, ok := args[0].Data.(*values.Array).Last()
+				if !ok {
 					return values.NewNull(), nil
 				}
-
-				if intKey, ok := lastKey.(int64); ok {
-					return values.NewInt(intKey), nil
-				}
-				return values.NewString(fmt.Sprintf("%v", lastKey)), nil
+				return values.KeyValue(lastKey), nil
 			},
 		},
 		{
@@ -2439,43 +1854,14 @@ func GetArrayFunctions() []*registry.Function {
 
 				// Use unified callback invoker for both builtin and user-defined callbacks
 
-				// Iterate through array elements in order
-				keys := make([]interface{}, 0, len(arr.Elements))
-				for key := range arr.Elements {
-					keys = append(keys, key)
-				}
-
-				// Sort keys to maintain order (numeric first, then string)
-				sort.Slice(keys, func(i, j int) bool {
-					ki, iIsInt := keys[i].(int64)
-					kj, jIsInt := keys[j].(int64)
-
-					if iIsInt && jIsInt {
-						return ki < kj
-					}
-					if iIsInt && !jIsInt {
-						return true // int keys come first
-					}
-					if !iIsInt && jIsInt {
-						return false // string keys come after
-					}
-					// both string keys
-					return fmt.Sprintf("%v", keys[i]) < fmt.Sprintf("%v", keys[j])
-				})
-
-				// Call function for each element
-				for _, key := range keys {
-					value := arr.Elements[key]
-					if value == nil {
+				// Call function for each element in order. The callback may
+				// change the values in place.
+				for _, key := range arr.Keys() {
+					value, ok := arr.GetForWrite(key)
+					if !ok || value == nil {
 						continue
 					}
-
-					var keyVal *values.Value
-					if intKey, ok := key.(int64); ok {
-						keyVal = values.NewInt(intKey)
-					} else {
-						keyVal = values.NewString(fmt.Sprintf("%v", key))
-					}
+					keyVal := values.KeyValue(key)
 
 					// Call with (value, key, userdata)
 					callArgs := []*values.Value{value, keyVal}
@@ -2541,32 +1927,8 @@ func GetArrayFunctions() []*registry.Function {
 
 				// Use unified callback invoker for both builtin and user-defined callbacks
 
-				// Get elements in order
-				keys := make([]interface{}, 0, len(arr.Elements))
-				for key := range arr.Elements {
-					keys = append(keys, key)
-				}
-
-				// Sort keys to maintain order
-				sort.Slice(keys, func(i, j int) bool {
-					ki, iIsInt := keys[i].(int64)
-					kj, jIsInt := keys[j].(int64)
-
-					if iIsInt && jIsInt {
-						return ki < kj
-					}
-					if iIsInt && !jIsInt {
-						return true
-					}
-					if !iIsInt && jIsInt {
-						return false
-					}
-					return fmt.Sprintf("%v", keys[i]) < fmt.Sprintf("%v", keys[j])
-				})
-
-				// Reduce array elements
-				for _, key := range keys {
-					value := arr.Elements[key]
+				// Reduce array elements in order
+				for _, value := range arr.All() {
 					if value == nil {
 						continue
 					}
@@ -2601,7 +1963,7 @@ func GetArrayFunctions() []*registry.Function {
 				hasFloat := false
 				hasElements := false
 
-				for _, val := range arr.Elements {
+				for _, val := range arr.All() {
 					if val == nil {
 						continue
 					}
@@ -2653,7 +2015,7 @@ func GetArrayFunctions() []*registry.Function {
 				}
 
 				arr := args[0].Data.(*values.Array)
-				if len(arr.Elements) == 0 {
+				if arr.Len() == 0 {
 					return values.NewNull(), nil
 				}
 
@@ -2667,10 +2029,7 @@ func GetArrayFunctions() []*registry.Function {
 				}
 
 				// Get all keys
-				keys := make([]interface{}, 0, len(arr.Elements))
-				for key := range arr.Elements {
-					keys = append(keys, key)
-				}
+				keys := arr.Keys()
 
 				if int64(len(keys)) < num {
 					return values.NewNull(), fmt.Errorf("array_rand(): Second argument has to be between 1 and the number of elements in the array")
@@ -2679,11 +2038,7 @@ func GetArrayFunctions() []*registry.Function {
 				// If only one key requested, return it directly
 				if num == 1 {
 					randomIndex := len(keys) / 2 // Simple deterministic "random" for testing
-					key := keys[randomIndex]
-					if intKey, ok := key.(int64); ok {
-						return values.NewInt(intKey), nil
-					}
-					return values.NewString(fmt.Sprintf("%v", key)), nil
+					return values.KeyValue(keys[randomIndex]), nil
 				}
 
 				// Return array of random keys
@@ -2693,13 +2048,8 @@ func GetArrayFunctions() []*registry.Function {
 				// Simple deterministic selection for testing - in real implementation would use math/rand
 				for i := int64(0); i < num && i < int64(len(keys)); i++ {
 					key := keys[i]
-					if intKey, ok := key.(int64); ok {
-						resultArr.Elements[i] = values.NewInt(intKey)
-					} else {
-						resultArr.Elements[i] = values.NewString(fmt.Sprintf("%v", key))
-					}
+					resultArr.Append(values.KeyValue(key))
 				}
-				resultArr.NextIndex = num
 
 				return result, nil
 			},
@@ -2721,10 +2071,7 @@ func GetArrayFunctions() []*registry.Function {
 				arr := args[0].Data.(*values.Array)
 
 				// Get all values
-				values_list := make([]*values.Value, 0, len(arr.Elements))
-				for _, value := range arr.Elements {
-					values_list = append(values_list, value)
-				}
+				values_list := arr.Values()
 
 				// Simple deterministic shuffle for testing
 				// In real implementation, would use math/rand.Shuffle()
@@ -2735,11 +2082,7 @@ func GetArrayFunctions() []*registry.Function {
 				}
 
 				// Replace array contents with shuffled values using numeric keys
-				arr.Elements = make(map[interface{}]*values.Value)
-				for i, value := range shuffled {
-					arr.Elements[int64(i)] = value
-				}
-				arr.NextIndex = int64(len(shuffled))
+				replaceWithList(arr, shuffled)
 
 				return values.NewBool(true), nil
 			},
@@ -2761,22 +2104,11 @@ func GetArrayFunctions() []*registry.Function {
 
 				arr := args[0].Data.(*values.Array)
 
-				// Extract values maintaining original order for stable sort
-				type valueWithIndex struct {
-					value *values.Value
-					index int
-				}
-
-				var sortedValues []valueWithIndex
-				idx := 0
-				for _, value := range arr.Elements {
-					sortedValues = append(sortedValues, valueWithIndex{value, idx})
-					idx++
-				}
+				sortedValues := arr.Values()
 
 				// Sort in descending order (reverse of sort())
-				sort.Slice(sortedValues, func(i, j int) bool {
-					vi, vj := sortedValues[i].value, sortedValues[j].value
+				sort.SliceStable(sortedValues, func(i, j int) bool {
+					vi, vj := sortedValues[i], sortedValues[j]
 
 					// Handle different type comparisons like PHP - reverse order for rsort
 					if vi.IsInt() && vj.IsInt() {
@@ -2792,12 +2124,7 @@ func GetArrayFunctions() []*registry.Function {
 				})
 
 				// Rebuild array with new sorted order and numeric indices
-				newElements := make(map[interface{}]*values.Value)
-				for i, item := range sortedValues {
-					newElements[int64(i)] = item.value
-				}
-				arr.Elements = newElements
-				arr.NextIndex = int64(len(sortedValues))
+				replaceWithList(arr, sortedValues)
 
 				return values.NewBool(true), nil
 			},
@@ -2819,21 +2146,8 @@ func GetArrayFunctions() []*registry.Function {
 
 				arr := args[0].Data.(*values.Array)
 
-				// Extract key-value pairs
-				type keyValuePair struct {
-					key   interface{}
-					value *values.Value
-				}
-
-				var pairs []keyValuePair
-				for key, value := range arr.Elements {
-					pairs = append(pairs, keyValuePair{key, value})
-				}
-
 				// Sort by values while maintaining key association
-				sort.Slice(pairs, func(i, j int) bool {
-					vi, vj := pairs[i].value, pairs[j].value
-
+				arr.Sort(func(_ interface{}, vi *values.Value, _ interface{}, vj *values.Value) bool {
 					// Handle different type comparisons like PHP
 					if vi.IsInt() && vj.IsInt() {
 						return vi.ToInt() < vj.ToInt()
@@ -2847,13 +2161,6 @@ func GetArrayFunctions() []*registry.Function {
 					}
 				})
 
-				// Rebuild array maintaining original keys
-				newElements := make(map[interface{}]*values.Value)
-				for _, pair := range pairs {
-					newElements[pair.key] = pair.value
-				}
-				arr.Elements = newElements
-
 				return values.NewBool(true), nil
 			},
 		},
@@ -2874,21 +2181,8 @@ func GetArrayFunctions() []*registry.Function {
 
 				arr := args[0].Data.(*values.Array)
 
-				// Extract key-value pairs
-				type keyValuePair struct {
-					key   interface{}
-					value *values.Value
-				}
-
-				var pairs []keyValuePair
-				for key, value := range arr.Elements {
-					pairs = append(pairs, keyValuePair{key, value})
-				}
-
 				// Sort by values in descending order while maintaining key association
-				sort.Slice(pairs, func(i, j int) bool {
-					vi, vj := pairs[i].value, pairs[j].value
-
+				arr.Sort(func(_ interface{}, vi *values.Value, _ interface{}, vj *values.Value) bool {
 					// Handle different type comparisons like PHP - reverse order
 					if vi.IsInt() && vj.IsInt() {
 						return vi.ToInt() > vj.ToInt()
@@ -2902,13 +2196,6 @@ func GetArrayFunctions() []*registry.Function {
 					}
 				})
 
-				// Rebuild array maintaining original keys
-				newElements := make(map[interface{}]*values.Value)
-				for _, pair := range pairs {
-					newElements[pair.key] = pair.value
-				}
-				arr.Elements = newElements
-
 				return values.NewBool(true), nil
 			},
 		},
@@ -2929,21 +2216,8 @@ func GetArrayFunctions() []*registry.Function {
 
 				arr := args[0].Data.(*values.Array)
 
-				// Extract key-value pairs
-				type keyValuePair struct {
-					key   interface{}
-					value *values.Value
-				}
-
-				var pairs []keyValuePair
-				for key, value := range arr.Elements {
-					pairs = append(pairs, keyValuePair{key, value})
-				}
-
 				// Sort by keys
-				sort.Slice(pairs, func(i, j int) bool {
-					ki, kj := pairs[i].key, pairs[j].key
-
+				arr.Sort(func(ki interface{}, _ *values.Value, kj interface{}, _ *values.Value) bool {
 					// Handle different key type comparisons
 					kiInt, kiIsInt := ki.(int64)
 					kjInt, kjIsInt := kj.(int64)
@@ -2958,16 +2232,9 @@ func GetArrayFunctions() []*registry.Function {
 						return false // string keys come after
 					}
 					// both string keys
-					return fmt.Sprintf("%v", ki) < fmt.Sprintf("%v", kj)
+					return ki.(string) < kj.(string)
 				})
 
-				// Rebuild array with sorted keys
-				newElements := make(map[interface{}]*values.Value)
-				for _, pair := range pairs {
-					newElements[pair.key] = pair.value
-				}
-				arr.Elements = newElements
-
 				return values.NewBool(true), nil
 			},
 		},
@@ -2988,21 +2255,8 @@ func GetArrayFunctions() []*registry.Function {
 
 				arr := args[0].Data.(*values.Array)
 
-				// Extract key-value pairs
-				type keyValuePair struct {
-					key   interface{}
-					value *values.Value
-				}
-
-				var pairs []keyValuePair
-				for key, value := range arr.Elements {
-					pairs = append(pairs, keyValuePair{key, value})
-				}
-
 				// Sort by keys in descending order
-				sort.Slice(pairs, func(i, j int) bool {
-					ki, kj := pairs[i].key, pairs[j].key
-
+				arr.Sort(func(ki interface{}, _ *values.Value, kj interface{}, _ *values.Value) bool {
 					// Handle different key type comparisons - reverse order
 					kiInt, kiIsInt := ki.(int64)
 					kjInt, kjIsInt := kj.(int64)
@@ -3017,16 +2271,9 @@ func GetArrayFunctions() []*registry.Function {
 						return true // Int keys come after in reverse
 					}
 					// both string keys - reverse
-					return fmt.Sprintf("%v", ki) > fmt.Sprintf("%v", kj)
+					return ki.(string) > kj.(string)
 				})
 
-				// Rebuild array with sorted keys
-				newElements := make(map[interface{}]*values.Value)
-				for _, pair := range pairs {
-					newElements[pair.key] = pair.value
-				}
-				arr.Elements = newElements
-
 				return values.NewBool(true), nil
 			},
 		},
@@ -3049,13 +2296,10 @@ func GetArrayFunctions() []*registry.Function {
 				callback := args[1]
 
 				// Extract values into slice for sorting
-				var values_list []*values.Value
-				for _, val := range arr.Elements {
-					values_list = append(values_list, val)
-				}
+				values_list := arr.Values()
 
 				// Sort using callback comparator (supports both builtin and user-defined)
-				sort.Slice(values_list, func(i, j int) bool {
+				sort.SliceStable(values_list, func(i, j int) bool {
 					result, err := callbackInvoker(ctx, callback, []*values.Value{values_list[i], values_list[j]})
 					if err != nil {
 						return false // Default ordering on error
@@ -3064,11 +2308,7 @@ func GetArrayFunctions() []*registry.Function {
 				})
 
 				// Rebuild array with new order (usort reindexes keys)
-				arr.Elements = make(map[interface{}]*values.Value)
-				for i, val := range values_list {
-					arr.Elements[int64(i)] = val
-				}
-				arr.NextIndex = int64(len(values_list))
+				replaceWithList(arr, values_list)
 
 				return values.NewBool(true), nil
 			},
@@ -3091,32 +2331,15 @@ func GetArrayFunctions() []*registry.Function {
 				arr := args[0].Data.(*values.Array)
 				callback := args[1]
 
-				// Extract key-value pairs for sorting
-				type KeyValue struct {
-					Key   interface{}
-					Value *values.Value
-				}
-
-				pairs := make([]KeyValue, 0, len(arr.Elements))
-				for key, value := range arr.Elements {
-					pairs = append(pairs, KeyValue{Key: key, Value: value})
-				}
-
-				// Sort by values using callback comparator (supports both builtin and user-defined)
-				sort.Slice(pairs, func(i, j int) bool {
-					result, err := callbackInvoker(ctx, callback, []*values.Value{pairs[i].Value, pairs[j].Value})
+				// Sort by values using callback comparator, keeping the keys
+				arr.Sort(func(_ interface{}, vi *values.Value, _ interface{}, vj *values.Value) bool {
+					result, err := callbackInvoker(ctx, callback, []*values.Value{vi, vj})
 					if err != nil {
 						return false // Default ordering on error
 					}
 					return result.ToInt() < 0
 				})
 
-				// Rebuild array maintaining key association (uasort preserves keys)
-				arr.Elements = make(map[interface{}]*values.Value)
-				for _, pair := range pairs {
-					arr.Elements[pair.Key] = pair.Value
-				}
-
 				return values.NewBool(true), nil
 			},
 		},
@@ -3138,36 +2361,15 @@ func GetArrayFunctions() []*registry.Function {
 				arr := args[0].Data.(*values.Array)
 				callback := args[1]
 
-				// Extract key-value pairs for sorting
-				type KeyValue struct {
-					Key   interface{}
-					Value *values.Value
-				}
-
-				pairs := make([]KeyValue, 0, len(arr.Elements))
-				for key, value := range arr.Elements {
-					pairs = append(pairs, KeyValue{Key: key, Value: value})
-				}
-
-				// Sort by keys using callback comparator (supports both builtin and user-defined)
-				sort.Slice(pairs, func(i, j int) bool {
-					// Convert keys to values for callback
-					keyA := values.NewString(fmt.Sprintf("%v", pairs[i].Key))
-					keyB := values.NewString(fmt.Sprintf("%v", pairs[j].Key))
-
-					result, err := callbackInvoker(ctx, callback, []*values.Value{keyA, keyB})
+				// Sort by keys using callback comparator, keeping the values
+				arr.Sort(func(ki interface{}, _ *values.Value, kj interface{}, _ *values.Value) bool {
+					result, err := callbackInvoker(ctx, callback, []*values.Value{values.KeyValue(ki), values.KeyValue(kj)})
 					if err != nil {
 						return false // Default ordering on error
 					}
 					return result.ToInt() < 0
 				})
 
-				// Rebuild array maintaining key-value association (uksort preserves values)
-				arr.Elements = make(map[interface{}]*values.Value)
-				for _, pair := range pairs {
-					arr.Elements[pair.Key] = pair.Value
-				}
-
 				return values.NewBool(true), nil
 			},
 		},
@@ -3193,7 +2395,7 @@ func GetArrayFunctions() []*registry.Function {
 				for i := 1; i < len(args); i++ {
 					if args[i] != nil && args[i].IsArray() {
 						arr := args[i].Data.(*values.Array)
-						for key, val := range arr.Elements {
+						for key, val := range arr.All() {
 							if val != nil {
 								keyStr := fmt.Sprintf("%v", key)
 								otherKeyValues[keyStr] = val.ToString()
@@ -3206,14 +2408,14 @@ func GetArrayFunctions() []*registry.Function {
 				resultArr := result.Data.(*values.Array)
 
 				// Find key-value pairs that don't exist in other arrays
-				for key, val := range arr1.Elements {
+				for key, val := range arr1.All() {
 					if val != nil {
 						keyStr := fmt.Sprintf("%v", key)
 						expectedValue, exists := otherKeyValues[keyStr]
 
 						// Include if key doesn't exist or value is different
 						if !exists || expectedValue != val.ToString() {
-							resultArr.Elements[key] = val
+							resultArr.Set(key, val)
 						}
 					}
 				}
@@ -3243,7 +2445,7 @@ func GetArrayFunctions() []*registry.Function {
 				for i := 1; i < len(args); i++ {
 					if args[i] != nil && args[i].IsArray() {
 						arr := args[i].Data.(*values.Array)
-						for key := range arr.Elements {
+						for key := range arr.All() {
 							keyStr := fmt.Sprintf("%v", key)
 							otherKeys[keyStr] = true
 						}
@@ -3254,10 +2456,10 @@ func GetArrayFunctions() []*registry.Function {
 				resultArr := result.Data.(*values.Array)
 
 				// Find key-value pairs where key doesn't exist in other arrays
-				for key, val := range arr1.Elements {
+				for key, val := range arr1.All() {
 					keyStr := fmt.Sprintf("%v", key)
 					if !otherKeys[keyStr] {
-						resultArr.Elements[key] = val
+						resultArr.Set(key, val)
 					}
 				}
 
@@ -3284,7 +2486,7 @@ func GetArrayFunctions() []*registry.Function {
 				resultArr := result.Data.(*values.Array)
 
 				// For each element in first array, check if it exists in ALL other arrays
-				for key, val := range arr1.Elements {
+				for key, val := range arr1.All() {
 					if val == nil {
 						continue
 					}
@@ -3299,7 +2501,7 @@ func GetArrayFunctions() []*registry.Function {
 						}
 
 						otherArr := args[i].Data.(*values.Array)
-						otherVal, exists := otherArr.Elements[key]
+						otherVal, exists := otherArr.Lookup(key)
 
 						if !exists || otherVal == nil || otherVal.ToString() != val.ToString() {
 							foundInAll = false
@@ -3308,7 +2510,7 @@ func GetArrayFunctions() []*registry.Function {
 					}
 
 					if foundInAll {
-						resultArr.Elements[key] = val
+						resultArr.Set(key, val)
 					}
 				}
 
@@ -3335,7 +2537,7 @@ func GetArrayFunctions() []*registry.Function {
 				resultArr := result.Data.(*values.Array)
 
 				// For each element in first array, check if key exists in ALL other arrays
-				for key, val := range arr1.Elements {
+				for key, val := range arr1.All() {
 					if val == nil {
 						continue
 					}
@@ -3350,14 +2552,14 @@ func GetArrayFunctions() []*registry.Function {
 						}
 
 						otherArr := args[i].Data.(*values.Array)
-						if _, exists := otherArr.Elements[key]; !exists {
+						if _, exists := otherArr.Lookup(key); !exists {
 							foundInAll = false
 							break
 						}
 					}
 
 					if foundInAll {
-						resultArr.Elements[key] = val
+						resultArr.Set(key, val)
 					}
 				}
 
@@ -3379,17 +2581,10 @@ func GetArrayFunctions() []*registry.Function {
 					return values.NewArray(), nil
 				}
 
-				// Start with first array
-				result := values.NewArray()
+				// Start with a copy of the first array
+				result := deepCopyArray(args[0])
 				resultArr := result.Data.(*values.Array)
 
-				// Copy first array
-				arr1 := args[0].Data.(*values.Array)
-				for key, val := range arr1.Elements {
-					resultArr.Elements[key] = val
-				}
-				resultArr.NextIndex = arr1.NextIndex
-
 				// Replace/add values from subsequent arrays
 				for i := 1; i < len(args); i++ {
 					if args[i] == nil || !args[i].IsArray() {
@@ -3397,11 +2592,8 @@ func GetArrayFunctions() []*registry.Function {
 					}
 
 					replaceArr := args[i].Data.(*values.Array)
-					for key, val := range replaceArr.Elements {
-						resultArr.Elements[key] = val
-					}
-					if replaceArr.NextIndex > resultArr.NextIndex {
-						resultArr.NextIndex = replaceArr.NextIndex
+					for key, val := range replaceArr.All() {
+						resultArr.Set(key, val)
 					}
 				}
 
@@ -3423,19 +2615,12 @@ func GetArrayFunctions() []*registry.Function {
 				}
 
 				arr := args[0].Data.(*values.Array)
-				if len(arr.Elements) == 0 {
+				if arr.Len() == 0 {
 					return values.NewBool(false), nil
 				}
 
 				// Find first element (simplified implementation)
-				for i := int64(0); i < arr.NextIndex; i++ {
-					if val, exists := arr.Elements[i]; exists {
-						return val, nil
-					}
-				}
-
-				// Check string keys
-				for _, val := range arr.Elements {
+				if _, val, ok := arr.First(); ok {
 					return val, nil
 				}
 
@@ -3458,18 +2643,12 @@ func GetArrayFunctions() []*registry.Function {
 				}
 
 				arr := args[0].Data.(*values.Array)
-				if len(arr.Elements) == 0 {
+				if arr.Len() == 0 {
 					return values.NewBool(false), nil
 				}
 
 				// Return first element
-				for i := int64(0); i < arr.NextIndex; i++ {
-					if val, exists := arr.Elements[i]; exists {
-						return val, nil
-					}
-				}
-
-				for _, val := range arr.Elements {
+				if _, val, ok := arr.First(); ok {
 					return val, nil
 				}
 
@@ -3491,27 +2670,13 @@ func GetArrayFunctions() []*registry.Function {
 				}
 
 				arr := args[0].Data.(*values.Array)
-				if len(arr.Elements) == 0 {
+				if arr.Len() == 0 {
 					return values.NewBool(false), nil
 				}
 
-				// Find last element - check highest numeric key first
-				for i := arr.NextIndex - 1; i >= 0; i-- {
-					if val, exists := arr.Elements[i]; exists {
-						return val, nil
-					}
-				}
-
-				// Check string keys (last one found)
-				var lastVal *values.Value
-				for key, val := range arr.Elements {
-					if _, isInt := key.(int64); !isInt {
-						lastVal = val
-					}
-				}
-
-				if lastVal != nil {
-					return lastVal, nil
+				// Return last element
+				if _, val, ok := arr.Last(); ok {
+					return val, nil
 				}
 
 				return values.NewBool(false), nil
@@ -3562,22 +2727,13 @@ func GetArrayFunctions() []*registry.Function {
 				}
 
 				arr := args[0].Data.(*values.Array)
-				if len(arr.Elements) == 0 {
+				if arr.Len() == 0 {
 					return values.NewNull(), nil
 				}
 
 				// Return first key (simple implementation)
-				for i := int64(0); i < arr.NextIndex; i++ {
-					if _, exists := arr.Elements[i]; exists {
-						return values.NewInt(i), nil
-					}
-				}
-
-				// Check string keys
-				for key := range arr.Elements {
-					if _, isInt := key.(int64); !isInt {
-						return values.NewString(fmt.Sprintf("%v", key)), nil
-					}
+				if key, _, ok := arr.First(); ok {
+					return values.KeyValue(key), nil
 				}
 
 				return values.NewNull(), nil
@@ -3669,28 +2825,8 @@ func GetArrayFunctions() []*registry.Function {
 
 				arr := args[0].Data.(*values.Array)
 
-				// Empty array is a list
-				if len(arr.Elements) == 0 {
-					return values.NewBool(true), nil
-				}
-
-				// Check if keys are consecutive integers starting from 0
-				expectedKey := int64(0)
-				for expectedKey < arr.NextIndex {
-					if _, exists := arr.Elements[expectedKey]; !exists {
-						return values.NewBool(false), nil
-					}
-					expectedKey++
-				}
-
-				// Check that no non-numeric keys exist
-				for key := range arr.Elements {
-					if _, isInt := key.(int64); !isInt {
-						return values.NewBool(false), nil
-					}
-				}
-
-				return values.NewBool(true), nil
+				// Keys must be consecutive integers starting from 0, in order
+				return values.NewBool(arr.IsList()), nil
 			},
 		},
 		{
@@ -3798,33 +2934,14 @@ func GetArrayFunctions() []*registry.Function {
 
 				arr := args[0].Data.(*values.Array)
 
-				// Extract key-value pairs
-				type keyValuePair struct {
-					key   interface{}
-					value *values.Value
-				}
-
-				var pairs []keyValuePair
-				for key, value := range arr.Elements {
-					pairs = append(pairs, keyValuePair{key, value})
-				}
-
-				// Sort using case-insensitive natural order
-				sort.Slice(pairs, func(i, j int) bool {
-					vi, vj := pairs[i].value, pairs[j].value
+				// Sort using case-insensitive natural order, keeping the keys
+				arr.Sort(func(_ interface{}, vi *values.Value, _ interface{}, vj *values.Value) bool {
 					// Convert to lowercase strings for natural comparison
 					si := strings.ToLower(vi.ToString())
 					sj := strings.ToLower(vj.ToString())
 					return naturalCompare(si, sj) < 0
 				})
 
-				// Rebuild array maintaining original keys
-				newElements := make(map[interface{}]*values.Value)
-				for _, pair := range pairs {
-					newElements[pair.key] = pair.value
-				}
-				arr.Elements = newElements
-
 				return values.NewBool(true), nil
 			},
 		},
@@ -3844,30 +2961,11 @@ func GetArrayFunctions() []*registry.Function {
 
 				arr := args[0].Data.(*values.Array)
 
-				// Extract key-value pairs
-				type keyValuePair struct {
-					key   interface{}
-					value *values.Value
-				}
-
-				var pairs []keyValuePair
-				for key, value := range arr.Elements {
-					pairs = append(pairs, keyValuePair{key, value})
-				}
-
-				// Sort using natural order
-				sort.Slice(pairs, func(i, j int) bool {
-					vi, vj := pairs[i].value, pairs[j].value
+				// Sort using natural order, keeping the keys
+				arr.Sort(func(_ interface{}, vi *values.Value, _ interface{}, vj *values.Value) bool {
 					return naturalCompare(vi.ToString(), vj.ToString()) < 0
 				})
 
-				// Rebuild array maintaining original keys
-				newElements := make(map[interface{}]*values.Value)
-				for _, pair := range pairs {
-					newElements[pair.key] = pair.value
-				}
-				arr.Elements = newElements
-
 				return values.NewBool(true), nil
 			},
 		},
@@ -3890,22 +2988,11 @@ func GetArrayFunctions() []*registry.Function {
 				// multi-dimensional sorting with multiple sort orders
 				arr := args[0].Data.(*values.Array)
 
-				// Extract values maintaining original order for stable sort
-				type valueWithIndex struct {
-					value *values.Value
-					index int
-				}
-
-				var sortedValues []valueWithIndex
-				idx := 0
-				for _, value := range arr.Elements {
-					sortedValues = append(sortedValues, valueWithIndex{value, idx})
-					idx++
-				}
+				sortedValues := arr.Values()
 
 				// Simple sort by first column values
-				sort.Slice(sortedValues, func(i, j int) bool {
-					vi, vj := sortedValues[i].value, sortedValues[j].value
+				sort.SliceStable(sortedValues, func(i, j int) bool {
+					vi, vj := sortedValues[i], sortedValues[j]
 
 					if vi.IsInt() && vj.IsInt() {
 						return vi.ToInt() < vj.ToInt()
@@ -3919,12 +3006,7 @@ func GetArrayFunctions() []*registry.Function {
 				})
 
 				// Rebuild array with sorted order and numeric indices
-				newElements := make(map[interface{}]*values.Value)
-				for i, item := range sortedValues {
-					newElements[int64(i)] = item.value
-				}
-				arr.Elements = newElements
-				arr.NextIndex = int64(len(sortedValues))
+				replaceWithList(arr, sortedValues)
 
 				return values.NewBool(true), nil
 			},
@@ -3946,28 +3028,21 @@ func GetArrayFunctions() []*registry.Function {
 				}
 
 				arr := args[0].Data.(*values.Array)
-				if len(arr.Elements) == 0 {
+				if arr.Len() == 0 {
 					return values.NewBool(false), nil
 				}
 
 				// Find first element (simple implementation)
-				for key, value := range arr.Elements {
+				for key, value := range arr.All() {
 					result := values.NewArray()
 					resultArr := result.Data.(*values.Array)
 
 					// Add numeric indices
-					resultArr.Elements[int64(1)] = value
-					resultArr.Elements["value"] = value
-
-					if intKey, ok := key.(int64); ok {
-						resultArr.Elements[int64(0)] = values.NewInt(intKey)
-						resultArr.Elements["key"] = values.NewInt(intKey)
-					} else {
-						resultArr.Elements[int64(0)] = values.NewString(fmt.Sprintf("%v", key))
-						resultArr.Elements["key"] = values.NewString(fmt.Sprintf("%v", key))
-					}
+					resultArr.Set(int64(1), value)
+					resultArr.Set("value", value)
 
-					resultArr.NextIndex = 2
+					resultArr.Set(int64(0), values.KeyValue(key))
+					resultArr.Set("key", values.KeyValue(key))
 					return result, nil
 				}
 
@@ -4000,7 +3075,7 @@ func GetArrayFunctions() []*registry.Function {
 
 					// Get global variable by name
 					if value, exists := ctx.GetGlobal(varName); exists && value != nil {
-						resultArr.Elements[varName] = value
+						resultArr.Set(varName, value)
 					}
 				}
 
@@ -4036,7 +3111,7 @@ func GetArrayFunctions() []*registry.Function {
 
 				extractedCount := int64(0)
 
-				for key, value := range arr.Elements {
+				for key, value := range arr.All() {
 					if value == nil {
 						continue
 					}
@@ -4107,12 +3182,12 @@ func GetArrayFunctions() []*registry.Function {
 				callback := args[1]
 
 				// Empty array returns true (all elements satisfy condition vacuously)
-				if len(arr.Elements) == 0 {
+				if arr.Len() == 0 {
 					return values.NewBool(true), nil
 				}
 
 				// Test all elements using callback (supports both builtin and user-defined)
-				for _, value := range arr.Elements {
+				for _, value := range arr.All() {
 					result, err := callbackInvoker(ctx, callback, []*values.Value{value})
 					if err != nil {
 						return values.NewBool(false), err
@@ -4143,12 +3218,12 @@ func GetArrayFunctions() []*registry.Function {
 				callback := args[1]
 
 				// Empty array returns false (no elements satisfy condition)
-				if len(arr.Elements) == 0 {
+				if arr.Len() == 0 {
 					return values.NewBool(false), nil
 				}
 
 				// Test any element using callback (supports both builtin and user-defined)
-				for _, value := range arr.Elements {
+				for _, value := range arr.All() {
 					result, err := callbackInvoker(ctx, callback, []*values.Value{value})
 					if err != nil {
 						return values.NewBool(false), err
@@ -4179,12 +3254,12 @@ func GetArrayFunctions() []*registry.Function {
 				callback := args[1]
 
 				// Empty array returns null
-				if len(arr.Elements) == 0 {
+				if arr.Len() == 0 {
 					return values.NewNull(), nil
 				}
 
 				// Find first element that matches using callback (supports both builtin and user-defined)
-				for _, value := range arr.Elements {
+				for _, value := range arr.All() {
 					result, err := callbackInvoker(ctx, callback, []*values.Value{value})
 					if err != nil {
 						return values.NewNull(), err
@@ -4215,12 +3290,12 @@ func GetArrayFunctions() []*registry.Function {
 				callback := args[1]
 
 				// Empty array returns null
-				if len(arr.Elements) == 0 {
+				if arr.Len() == 0 {
 					return values.NewNull(), nil
 				}
 
 				// Find first key where value matches using callback (supports both builtin and user-defined)
-				for key, value := range arr.Elements {
+				for key, value := range arr.All() {
 					result, err := callbackInvoker(ctx, callback, []*values.Value{value})
 					if err != nil {
 						return values.NewNull(), err
@@ -51,6 +51,11 @@ func (m *mockBuiltinContext) CallUserFunction(function *registry.Function, args
 func (m *mockBuiltinContext) ThrowException(exception *values.Value) error {
 	return fmt.Errorf("exception thrown in test mock: %v", exception)
 }
+func (m *mockBuiltinContext) AutoloadClass(name string) bool { return false }
+func (m *mockBuiltinContext) CallMethod(method *registry.Function, this *values.Value, className string, args []*values.Value) (*values.Value, error) {
+	return nil, nil
+}
+func (m *mockBuiltinContext) InstantiateClass(className string) (*values.Value, error) { return nil, nil }
 
 func (m *mockBuiltinContext) SimpleCallUserFunction(function *registry.Function, args []*values.Value) (*values.Value, error) {
 	return nil, fmt.Errorf("user function calls not supported in test mock")
@@ -135,6 +140,18 @@ func (m *mockHTTPContext) GetRequestHeaders() map[string]string {
 	return nil
 }
 
+func (m *mockHTTPContext) GetRequestBody() []byte {
+	return nil
+}
+
+func (m *mockHTTPContext) IsUploadedFile(path string) bool {
+	return false
+}
+
+func (m *mockHTTPContext) RemoveUploadedFile(path string) {
+	// No-op for test mock
+}
+
 func (m *mockHTTPContext) FormatHeadersForFastCGI() string {
 	return ""
 }
@@ -159,17 +176,15 @@ func TestArrayFunctions(t *testing.T) {
 			// Create test arrays: [1,2,3] and [4,5,6]
 			arr1 := values.NewArray()
 			arr1Ptr := arr1.Data.(*values.Array)
-			arr1Ptr.Elements[int64(0)] = values.NewInt(1)
-			arr1Ptr.Elements[int64(1)] = values.NewInt(2)
-			arr1Ptr.Elements[int64(2)] = values.NewInt(3)
-			arr1Ptr.NextIndex = 3
+			arr1Ptr.Set(int64(0), values.NewInt(1))
+			arr1Ptr.Set(int64(1), values.NewInt(2))
+			arr1Ptr.Set(int64(2), values.NewInt(3))
 
 			arr2 := values.NewArray()
 			arr2Ptr := arr2.Data.(*values.Array)
-			arr2Ptr.Elements[int64(0)] = values.NewInt(4)
-			arr2Ptr.Elements[int64(1)] = values.NewInt(5)
-			arr2Ptr.Elements[int64(2)] = values.NewInt(6)
-			arr2Ptr.NextIndex = 3
+			arr2Ptr.Set(int64(0), values.NewInt(4))
+			arr2Ptr.Set(int64(1), values.NewInt(5))
+			arr2Ptr.Set(int64(2), values.NewInt(6))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				values.NewNull(), // null callback
@@ -191,7 +206,7 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			// Check first element: [1,4]
-			firstRow := resultArr.Elements[int64(0)]
+			firstRow := resultArr.Get(int64(0))
 			if !firstRow.IsArray() {
 				t.Fatal("first row should be an array")
 			}
@@ -199,11 +214,11 @@ func TestArrayFunctions(t *testing.T) {
 			if firstRow.ArrayCount() != 2 {
 				t.Errorf("first row should have 2 elements, got %d", firstRow.ArrayCount())
 			}
-			if firstRowArr.Elements[int64(0)].ToInt() != 1 {
-				t.Errorf("expected 1, got %d", firstRowArr.Elements[int64(0)].ToInt())
+			if firstRowArr.Get(int64(0)).ToInt() != 1 {
+				t.Errorf("expected 1, got %d", firstRowArr.Get(int64(0)).ToInt())
 			}
-			if firstRowArr.Elements[int64(1)].ToInt() != 4 {
-				t.Errorf("expected 4, got %d", firstRowArr.Elements[int64(1)].ToInt())
+			if firstRowArr.Get(int64(1)).ToInt() != 4 {
+				t.Errorf("expected 4, got %d", firstRowArr.Get(int64(1)).ToInt())
 			}
 		})
 
@@ -211,9 +226,8 @@ func TestArrayFunctions(t *testing.T) {
 			// Create test array: ["hello", "world"]
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewString("hello")
-			arrPtr.Elements[int64(1)] = values.NewString("world")
-			arrPtr.NextIndex = 2
+			arrPtr.Set(int64(0), values.NewString("hello"))
+			arrPtr.Set(int64(1), values.NewString("world"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				values.NewString("strtoupper"), // builtin function name
@@ -233,11 +247,11 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(0)].ToString() != "HELLO" {
-				t.Errorf("expected 'HELLO', got '%s'", resultArr.Elements[int64(0)].ToString())
+			if resultArr.Get(int64(0)).ToString() != "HELLO" {
+				t.Errorf("expected 'HELLO', got '%s'", resultArr.Get(int64(0)).ToString())
 			}
-			if resultArr.Elements[int64(1)].ToString() != "WORLD" {
-				t.Errorf("expected 'WORLD', got '%s'", resultArr.Elements[int64(1)].ToString())
+			if resultArr.Get(int64(1)).ToString() != "WORLD" {
+				t.Errorf("expected 'WORLD', got '%s'", resultArr.Get(int64(1)).ToString())
 			}
 		})
 
@@ -245,9 +259,9 @@ func TestArrayFunctions(t *testing.T) {
 			// Create test array: ["a" => 1, "b" => 2, "c" => 3]
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements["a"] = values.NewInt(1)
-			arrPtr.Elements["b"] = values.NewInt(2)
-			arrPtr.Elements["c"] = values.NewInt(3)
+			arrPtr.Set("a", values.NewInt(1))
+			arrPtr.Set("b", values.NewInt(2))
+			arrPtr.Set("c", values.NewInt(3))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				values.NewString("strlen"), // get string length
@@ -263,14 +277,14 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements["a"].ToInt() != 1 {
-				t.Errorf("expected 1, got %d", resultArr.Elements["a"].ToInt())
+			if resultArr.Get("a").ToInt() != 1 {
+				t.Errorf("expected 1, got %d", resultArr.Get("a").ToInt())
 			}
-			if resultArr.Elements["b"].ToInt() != 1 {
-				t.Errorf("expected 1, got %d", resultArr.Elements["b"].ToInt())
+			if resultArr.Get("b").ToInt() != 1 {
+				t.Errorf("expected 1, got %d", resultArr.Get("b").ToInt())
 			}
-			if resultArr.Elements["c"].ToInt() != 1 {
-				t.Errorf("expected 1, got %d", resultArr.Elements["c"].ToInt())
+			if resultArr.Get("c").ToInt() != 1 {
+				t.Errorf("expected 1, got %d", resultArr.Get("c").ToInt())
 			}
 		})
 
@@ -298,7 +312,7 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("invalid function name returns error", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewString("test")
+			arrPtr.Set(int64(0), values.NewString("test"))
 
 			_, err := fn.Builtin(ctx, []*values.Value{
 				values.NewString("nonexistent_function"),
@@ -340,9 +354,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 5; i++ {
-				arrPtr.Elements[i] = values.NewInt(i + 1)
+				arrPtr.Set(i, values.NewInt(i + 1))
 			}
-			arrPtr.NextIndex = 5
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -362,14 +375,14 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(0)].ToInt() != 3 {
-				t.Errorf("expected 3, got %d", resultArr.Elements[int64(0)].ToInt())
+			if resultArr.Get(int64(0)).ToInt() != 3 {
+				t.Errorf("expected 3, got %d", resultArr.Get(int64(0)).ToInt())
 			}
-			if resultArr.Elements[int64(1)].ToInt() != 4 {
-				t.Errorf("expected 4, got %d", resultArr.Elements[int64(1)].ToInt())
+			if resultArr.Get(int64(1)).ToInt() != 4 {
+				t.Errorf("expected 4, got %d", resultArr.Get(int64(1)).ToInt())
 			}
-			if resultArr.Elements[int64(2)].ToInt() != 5 {
-				t.Errorf("expected 5, got %d", resultArr.Elements[int64(2)].ToInt())
+			if resultArr.Get(int64(2)).ToInt() != 5 {
+				t.Errorf("expected 5, got %d", resultArr.Get(int64(2)).ToInt())
 			}
 		})
 
@@ -378,9 +391,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 5; i++ {
-				arrPtr.Elements[i] = values.NewInt(i + 1)
+				arrPtr.Set(i, values.NewInt(i + 1))
 			}
-			arrPtr.NextIndex = 5
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -397,14 +409,14 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(0)].ToInt() != 2 {
-				t.Errorf("expected 2, got %d", resultArr.Elements[int64(0)].ToInt())
+			if resultArr.Get(int64(0)).ToInt() != 2 {
+				t.Errorf("expected 2, got %d", resultArr.Get(int64(0)).ToInt())
 			}
-			if resultArr.Elements[int64(1)].ToInt() != 3 {
-				t.Errorf("expected 3, got %d", resultArr.Elements[int64(1)].ToInt())
+			if resultArr.Get(int64(1)).ToInt() != 3 {
+				t.Errorf("expected 3, got %d", resultArr.Get(int64(1)).ToInt())
 			}
-			if resultArr.Elements[int64(2)].ToInt() != 4 {
-				t.Errorf("expected 4, got %d", resultArr.Elements[int64(2)].ToInt())
+			if resultArr.Get(int64(2)).ToInt() != 4 {
+				t.Errorf("expected 4, got %d", resultArr.Get(int64(2)).ToInt())
 			}
 		})
 
@@ -413,9 +425,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 5; i++ {
-				arrPtr.Elements[i] = values.NewInt(i + 1)
+				arrPtr.Set(i, values.NewInt(i + 1))
 			}
-			arrPtr.NextIndex = 5
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -431,11 +442,11 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(0)].ToInt() != 4 {
-				t.Errorf("expected 4, got %d", resultArr.Elements[int64(0)].ToInt())
+			if resultArr.Get(int64(0)).ToInt() != 4 {
+				t.Errorf("expected 4, got %d", resultArr.Get(int64(0)).ToInt())
 			}
-			if resultArr.Elements[int64(1)].ToInt() != 5 {
-				t.Errorf("expected 5, got %d", resultArr.Elements[int64(1)].ToInt())
+			if resultArr.Get(int64(1)).ToInt() != 5 {
+				t.Errorf("expected 5, got %d", resultArr.Get(int64(1)).ToInt())
 			}
 		})
 
@@ -443,10 +454,10 @@ func TestArrayFunctions(t *testing.T) {
 			// Create test array: ["a" => 1, "b" => 2, "c" => 3, "d" => 4]
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements["a"] = values.NewInt(1)
-			arrPtr.Elements["b"] = values.NewInt(2)
-			arrPtr.Elements["c"] = values.NewInt(3)
-			arrPtr.Elements["d"] = values.NewInt(4)
+			arrPtr.Set("a", values.NewInt(1))
+			arrPtr.Set("b", values.NewInt(2))
+			arrPtr.Set("c", values.NewInt(3))
+			arrPtr.Set("d", values.NewInt(4))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -466,17 +477,16 @@ func TestArrayFunctions(t *testing.T) {
 			resultArr := result.Data.(*values.Array)
 			// Note: the order depends on iteration order of string keys
 			// We'll just check that we have the right number of elements
-			if len(resultArr.Elements) != 2 {
-				t.Errorf("expected 2 elements in result map, got %d", len(resultArr.Elements))
+			if resultArr.Len() != 2 {
+				t.Errorf("expected 2 elements in result map, got %d", resultArr.Len())
 			}
 		})
 
 		t.Run("zero length returns empty", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewInt(1)
-			arrPtr.Elements[int64(1)] = values.NewInt(2)
-			arrPtr.NextIndex = 2
+			arrPtr.Set(int64(0), values.NewInt(1))
+			arrPtr.Set(int64(1), values.NewInt(2))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -505,9 +515,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 5; i++ {
-				arrPtr.Elements[i] = values.NewInt(i + 1)
+				arrPtr.Set(i, values.NewInt(i + 1))
 			}
-			arrPtr.NextIndex = 5
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				values.NewInt(3), // search for 3
@@ -530,9 +539,8 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("search not found", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewInt(1)
-			arrPtr.Elements[int64(1)] = values.NewInt(2)
-			arrPtr.NextIndex = 2
+			arrPtr.Set(int64(0), values.NewInt(1))
+			arrPtr.Set(int64(1), values.NewInt(2))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				values.NewInt(10), // search for 10 (not found)
@@ -555,9 +563,9 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("associative array search", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements["a"] = values.NewString("apple")
-			arrPtr.Elements["b"] = values.NewString("banana")
-			arrPtr.Elements["c"] = values.NewString("cherry")
+			arrPtr.Set("a", values.NewString("apple"))
+			arrPtr.Set("b", values.NewString("banana"))
+			arrPtr.Set("c", values.NewString("cherry"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				values.NewString("banana"), // search for banana
@@ -580,9 +588,8 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("strict vs loose comparison", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewString("1")
-			arrPtr.Elements[int64(1)] = values.NewInt(2)
-			arrPtr.NextIndex = 2
+			arrPtr.Set(int64(0), values.NewString("1"))
+			arrPtr.Set(int64(1), values.NewInt(2))
 
 			// Loose comparison (default)
 			result, err := fn.Builtin(ctx, []*values.Value{
@@ -626,9 +633,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 5; i++ {
-				arrPtr.Elements[i] = values.NewInt(i + 1)
+				arrPtr.Set(i, values.NewInt(i + 1))
 			}
-			arrPtr.NextIndex = 5
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -668,9 +674,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 5; i++ {
-				arrPtr.Elements[i] = values.NewInt(i + 1)
+				arrPtr.Set(i, values.NewInt(i + 1))
 			}
-			arrPtr.NextIndex = 5
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -686,8 +691,8 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			// Check that elements were shifted
-			if arrPtr.Elements[int64(0)].ToInt() != 2 {
-				t.Errorf("expected first element to be 2, got %d", arrPtr.Elements[int64(0)].ToInt())
+			if arrPtr.Get(int64(0)).ToInt() != 2 {
+				t.Errorf("expected first element to be 2, got %d", arrPtr.Get(int64(0)).ToInt())
 			}
 		})
 
@@ -714,10 +719,9 @@ func TestArrayFunctions(t *testing.T) {
 			// Create test array: [2,3,4]
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewInt(2)
-			arrPtr.Elements[int64(1)] = values.NewInt(3)
-			arrPtr.Elements[int64(2)] = values.NewInt(4)
-			arrPtr.NextIndex = 3
+			arrPtr.Set(int64(0), values.NewInt(2))
+			arrPtr.Set(int64(1), values.NewInt(3))
+			arrPtr.Set(int64(2), values.NewInt(4))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -737,20 +741,19 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			// Check that values were shifted
-			if arrPtr.Elements[int64(0)].ToInt() != 1 {
-				t.Errorf("expected first element to be 1, got %d", arrPtr.Elements[int64(0)].ToInt())
+			if arrPtr.Get(int64(0)).ToInt() != 1 {
+				t.Errorf("expected first element to be 1, got %d", arrPtr.Get(int64(0)).ToInt())
 			}
-			if arrPtr.Elements[int64(1)].ToInt() != 2 {
-				t.Errorf("expected second element to be 2, got %d", arrPtr.Elements[int64(1)].ToInt())
+			if arrPtr.Get(int64(1)).ToInt() != 2 {
+				t.Errorf("expected second element to be 2, got %d", arrPtr.Get(int64(1)).ToInt())
 			}
 		})
 
 		t.Run("unshift multiple values", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewInt(4)
-			arrPtr.Elements[int64(1)] = values.NewInt(5)
-			arrPtr.NextIndex = 2
+			arrPtr.Set(int64(0), values.NewInt(4))
+			arrPtr.Set(int64(1), values.NewInt(5))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -770,8 +773,8 @@ func TestArrayFunctions(t *testing.T) {
 			// Check order: [1,2,3,4,5]
 			expected := []int64{1, 2, 3, 4, 5}
 			for i, exp := range expected {
-				if arrPtr.Elements[int64(i)].ToInt() != exp {
-					t.Errorf("expected element %d to be %d, got %d", i, exp, arrPtr.Elements[int64(i)].ToInt())
+				if arrPtr.Get(int64(i)).ToInt() != exp {
+					t.Errorf("expected element %d to be %d, got %d", i, exp, arrPtr.Get(int64(i)).ToInt())
 				}
 			}
 		})
@@ -793,11 +796,11 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			arrPtr := arr.Data.(*values.Array)
-			if arrPtr.Elements[int64(0)].ToInt() != 1 {
-				t.Errorf("expected first element to be 1, got %d", arrPtr.Elements[int64(0)].ToInt())
+			if arrPtr.Get(int64(0)).ToInt() != 1 {
+				t.Errorf("expected first element to be 1, got %d", arrPtr.Get(int64(0)).ToInt())
 			}
-			if arrPtr.Elements[int64(1)].ToInt() != 2 {
-				t.Errorf("expected second element to be 2, got %d", arrPtr.Elements[int64(1)].ToInt())
+			if arrPtr.Get(int64(1)).ToInt() != 2 {
+				t.Errorf("expected second element to be 2, got %d", arrPtr.Get(int64(1)).ToInt())
 			}
 		})
 	})
@@ -812,10 +815,9 @@ func TestArrayFunctions(t *testing.T) {
 			// Create test array: [1,2,3]
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewInt(1)
-			arrPtr.Elements[int64(1)] = values.NewInt(2)
-			arrPtr.Elements[int64(2)] = values.NewInt(3)
-			arrPtr.NextIndex = 3
+			arrPtr.Set(int64(0), values.NewInt(1))
+			arrPtr.Set(int64(1), values.NewInt(2))
+			arrPtr.Set(int64(2), values.NewInt(3))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -833,27 +835,26 @@ func TestArrayFunctions(t *testing.T) {
 
 			resultArr := result.Data.(*values.Array)
 			// Check original elements
-			if resultArr.Elements[int64(0)].ToInt() != 1 {
-				t.Errorf("expected element 0 to be 1, got %d", resultArr.Elements[int64(0)].ToInt())
+			if resultArr.Get(int64(0)).ToInt() != 1 {
+				t.Errorf("expected element 0 to be 1, got %d", resultArr.Get(int64(0)).ToInt())
 			}
-			if resultArr.Elements[int64(1)].ToInt() != 2 {
-				t.Errorf("expected element 1 to be 2, got %d", resultArr.Elements[int64(1)].ToInt())
+			if resultArr.Get(int64(1)).ToInt() != 2 {
+				t.Errorf("expected element 1 to be 2, got %d", resultArr.Get(int64(1)).ToInt())
 			}
 			// Check padded elements
-			if resultArr.Elements[int64(3)].ToInt() != 0 {
-				t.Errorf("expected element 3 to be 0, got %d", resultArr.Elements[int64(3)].ToInt())
+			if resultArr.Get(int64(3)).ToInt() != 0 {
+				t.Errorf("expected element 3 to be 0, got %d", resultArr.Get(int64(3)).ToInt())
 			}
-			if resultArr.Elements[int64(4)].ToInt() != 0 {
-				t.Errorf("expected element 4 to be 0, got %d", resultArr.Elements[int64(4)].ToInt())
+			if resultArr.Get(int64(4)).ToInt() != 0 {
+				t.Errorf("expected element 4 to be 0, got %d", resultArr.Get(int64(4)).ToInt())
 			}
 		})
 
 		t.Run("pad to the left", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewInt(1)
-			arrPtr.Elements[int64(1)] = values.NewInt(2)
-			arrPtr.NextIndex = 2
+			arrPtr.Set(int64(0), values.NewInt(1))
+			arrPtr.Set(int64(1), values.NewInt(2))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -871,27 +872,26 @@ func TestArrayFunctions(t *testing.T) {
 
 			resultArr := result.Data.(*values.Array)
 			// Check padded elements at beginning
-			if resultArr.Elements[int64(0)].ToString() != "pad" {
-				t.Errorf("expected element 0 to be 'pad', got '%s'", resultArr.Elements[int64(0)].ToString())
+			if resultArr.Get(int64(0)).ToString() != "pad" {
+				t.Errorf("expected element 0 to be 'pad', got '%s'", resultArr.Get(int64(0)).ToString())
 			}
-			if resultArr.Elements[int64(1)].ToString() != "pad" {
-				t.Errorf("expected element 1 to be 'pad', got '%s'", resultArr.Elements[int64(1)].ToString())
+			if resultArr.Get(int64(1)).ToString() != "pad" {
+				t.Errorf("expected element 1 to be 'pad', got '%s'", resultArr.Get(int64(1)).ToString())
 			}
 			// Check original elements shifted
-			if resultArr.Elements[int64(2)].ToInt() != 1 {
-				t.Errorf("expected element 2 to be 1, got %d", resultArr.Elements[int64(2)].ToInt())
+			if resultArr.Get(int64(2)).ToInt() != 1 {
+				t.Errorf("expected element 2 to be 1, got %d", resultArr.Get(int64(2)).ToInt())
 			}
-			if resultArr.Elements[int64(3)].ToInt() != 2 {
-				t.Errorf("expected element 3 to be 2, got %d", resultArr.Elements[int64(3)].ToInt())
+			if resultArr.Get(int64(3)).ToInt() != 2 {
+				t.Errorf("expected element 3 to be 2, got %d", resultArr.Get(int64(3)).ToInt())
 			}
 		})
 
 		t.Run("no padding needed", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewInt(1)
-			arrPtr.Elements[int64(1)] = values.NewInt(2)
-			arrPtr.NextIndex = 2
+			arrPtr.Set(int64(0), values.NewInt(1))
+			arrPtr.Set(int64(1), values.NewInt(2))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -909,11 +909,11 @@ func TestArrayFunctions(t *testing.T) {
 
 			// Should be a copy with same values
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(0)].ToInt() != 1 {
-				t.Errorf("expected element 0 to be 1, got %d", resultArr.Elements[int64(0)].ToInt())
+			if resultArr.Get(int64(0)).ToInt() != 1 {
+				t.Errorf("expected element 0 to be 1, got %d", resultArr.Get(int64(0)).ToInt())
 			}
-			if resultArr.Elements[int64(1)].ToInt() != 2 {
-				t.Errorf("expected element 1 to be 2, got %d", resultArr.Elements[int64(1)].ToInt())
+			if resultArr.Get(int64(1)).ToInt() != 2 {
+				t.Errorf("expected element 1 to be 2, got %d", resultArr.Get(int64(1)).ToInt())
 			}
 		})
 
@@ -936,8 +936,8 @@ func TestArrayFunctions(t *testing.T) {
 
 			resultArr := result.Data.(*values.Array)
 			for i := int64(0); i < 3; i++ {
-				if resultArr.Elements[i].ToString() != "fill" {
-					t.Errorf("expected element %d to be 'fill', got '%s'", i, resultArr.Elements[i].ToString())
+				if resultArr.Get(i).ToString() != "fill" {
+					t.Errorf("expected element %d to be 'fill', got '%s'", i, resultArr.Get(i).ToString())
 				}
 			}
 		})
@@ -966,8 +966,8 @@ func TestArrayFunctions(t *testing.T) {
 
 			resultArr := result.Data.(*values.Array)
 			for i := int64(0); i < 3; i++ {
-				if resultArr.Elements[i].ToString() != "hi" {
-					t.Errorf("expected element %d to be 'hi', got '%s'", i, resultArr.Elements[i].ToString())
+				if resultArr.Get(i).ToString() != "hi" {
+					t.Errorf("expected element %d to be 'hi', got '%s'", i, resultArr.Get(i).ToString())
 				}
 			}
 		})
@@ -988,11 +988,11 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(5)].ToInt() != 42 {
-				t.Errorf("expected element 5 to be 42, got %d", resultArr.Elements[int64(5)].ToInt())
+			if resultArr.Get(int64(5)).ToInt() != 42 {
+				t.Errorf("expected element 5 to be 42, got %d", resultArr.Get(int64(5)).ToInt())
 			}
-			if resultArr.Elements[int64(6)].ToInt() != 42 {
-				t.Errorf("expected element 6 to be 42, got %d", resultArr.Elements[int64(6)].ToInt())
+			if resultArr.Get(int64(6)).ToInt() != 42 {
+				t.Errorf("expected element 6 to be 42, got %d", resultArr.Get(int64(6)).ToInt())
 			}
 		})
 
@@ -1023,10 +1023,9 @@ func TestArrayFunctions(t *testing.T) {
 			// Create keys array: ['a', 'b', 'c']
 			keys := values.NewArray()
 			keysPtr := keys.Data.(*values.Array)
-			keysPtr.Elements[int64(0)] = values.NewString("a")
-			keysPtr.Elements[int64(1)] = values.NewString("b")
-			keysPtr.Elements[int64(2)] = values.NewString("c")
-			keysPtr.NextIndex = 3
+			keysPtr.Set(int64(0), values.NewString("a"))
+			keysPtr.Set(int64(1), values.NewString("b"))
+			keysPtr.Set(int64(2), values.NewString("c"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				keys,
@@ -1042,14 +1041,14 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements["a"].ToString() != "value" {
-				t.Errorf("expected key 'a' to have 'value', got '%s'", resultArr.Elements["a"].ToString())
+			if resultArr.Get("a").ToString() != "value" {
+				t.Errorf("expected key 'a' to have 'value', got '%s'", resultArr.Get("a").ToString())
 			}
-			if resultArr.Elements["b"].ToString() != "value" {
-				t.Errorf("expected key 'b' to have 'value', got '%s'", resultArr.Elements["b"].ToString())
+			if resultArr.Get("b").ToString() != "value" {
+				t.Errorf("expected key 'b' to have 'value', got '%s'", resultArr.Get("b").ToString())
 			}
-			if resultArr.Elements["c"].ToString() != "value" {
-				t.Errorf("expected key 'c' to have 'value', got '%s'", resultArr.Elements["c"].ToString())
+			if resultArr.Get("c").ToString() != "value" {
+				t.Errorf("expected key 'c' to have 'value', got '%s'", resultArr.Get("c").ToString())
 			}
 		})
 
@@ -1057,10 +1056,9 @@ func TestArrayFunctions(t *testing.T) {
 			// Create keys array: [1, 2, 3]
 			keys := values.NewArray()
 			keysPtr := keys.Data.(*values.Array)
-			keysPtr.Elements[int64(0)] = values.NewInt(1)
-			keysPtr.Elements[int64(1)] = values.NewInt(2)
-			keysPtr.Elements[int64(2)] = values.NewInt(3)
-			keysPtr.NextIndex = 3
+			keysPtr.Set(int64(0), values.NewInt(1))
+			keysPtr.Set(int64(1), values.NewInt(2))
+			keysPtr.Set(int64(2), values.NewInt(3))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				keys,
@@ -1072,14 +1070,14 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(1)].ToInt() != 0 {
-				t.Errorf("expected key 1 to have 0, got %d", resultArr.Elements[int64(1)].ToInt())
+			if resultArr.Get(int64(1)).ToInt() != 0 {
+				t.Errorf("expected key 1 to have 0, got %d", resultArr.Get(int64(1)).ToInt())
 			}
-			if resultArr.Elements[int64(2)].ToInt() != 0 {
-				t.Errorf("expected key 2 to have 0, got %d", resultArr.Elements[int64(2)].ToInt())
+			if resultArr.Get(int64(2)).ToInt() != 0 {
+				t.Errorf("expected key 2 to have 0, got %d", resultArr.Get(int64(2)).ToInt())
 			}
-			if resultArr.Elements[int64(3)].ToInt() != 0 {
-				t.Errorf("expected key 3 to have 0, got %d", resultArr.Elements[int64(3)].ToInt())
+			if resultArr.Get(int64(3)).ToInt() != 0 {
+				t.Errorf("expected key 3 to have 0, got %d", resultArr.Get(int64(3)).ToInt())
 			}
 		})
 
@@ -1123,8 +1121,8 @@ func TestArrayFunctions(t *testing.T) {
 			resultArr := result.Data.(*values.Array)
 			expected := []int64{1, 2, 3, 4, 5}
 			for i, exp := range expected {
-				if resultArr.Elements[int64(i)].ToInt() != exp {
-					t.Errorf("expected element %d to be %d, got %d", i, exp, resultArr.Elements[int64(i)].ToInt())
+				if resultArr.Get(int64(i)).ToInt() != exp {
+					t.Errorf("expected element %d to be %d, got %d", i, exp, resultArr.Get(int64(i)).ToInt())
 				}
 			}
 		})
@@ -1147,8 +1145,8 @@ func TestArrayFunctions(t *testing.T) {
 			resultArr := result.Data.(*values.Array)
 			expected := []int64{0, 2, 4, 6, 8, 10}
 			for i, exp := range expected {
-				if resultArr.Elements[int64(i)].ToInt() != exp {
-					t.Errorf("expected element %d to be %d, got %d", i, exp, resultArr.Elements[int64(i)].ToInt())
+				if resultArr.Get(int64(i)).ToInt() != exp {
+					t.Errorf("expected element %d to be %d, got %d", i, exp, resultArr.Get(int64(i)).ToInt())
 				}
 			}
 		})
@@ -1170,8 +1168,8 @@ func TestArrayFunctions(t *testing.T) {
 			resultArr := result.Data.(*values.Array)
 			expected := []int64{5, 4, 3, 2, 1}
 			for i, exp := range expected {
-				if resultArr.Elements[int64(i)].ToInt() != exp {
-					t.Errorf("expected element %d to be %d, got %d", i, exp, resultArr.Elements[int64(i)].ToInt())
+				if resultArr.Get(int64(i)).ToInt() != exp {
+					t.Errorf("expected element %d to be %d, got %d", i, exp, resultArr.Get(int64(i)).ToInt())
 				}
 			}
 		})
@@ -1193,8 +1191,8 @@ func TestArrayFunctions(t *testing.T) {
 			resultArr := result.Data.(*values.Array)
 			expected := []string{"a", "b", "c", "d", "e"}
 			for i, exp := range expected {
-				if resultArr.Elements[int64(i)].ToString() != exp {
-					t.Errorf("expected element %d to be '%s', got '%s'", i, exp, resultArr.Elements[int64(i)].ToString())
+				if resultArr.Get(int64(i)).ToString() != exp {
+					t.Errorf("expected element %d to be '%s', got '%s'", i, exp, resultArr.Get(int64(i)).ToString())
 				}
 			}
 		})
@@ -1214,8 +1212,8 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(0)].ToInt() != 5 {
-				t.Errorf("expected element 0 to be 5, got %d", resultArr.Elements[int64(0)].ToInt())
+			if resultArr.Get(int64(0)).ToInt() != 5 {
+				t.Errorf("expected element 0 to be 5, got %d", resultArr.Get(int64(0)).ToInt())
 			}
 		})
 
@@ -1243,9 +1241,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 5; i++ {
-				arrPtr.Elements[i] = values.NewInt(i + 1)
+				arrPtr.Set(i, values.NewInt(i + 1))
 			}
-			arrPtr.NextIndex = 5
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -1263,11 +1260,11 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(0)].ToInt() != 3 {
-				t.Errorf("expected removed[0] to be 3, got %d", resultArr.Elements[int64(0)].ToInt())
+			if resultArr.Get(int64(0)).ToInt() != 3 {
+				t.Errorf("expected removed[0] to be 3, got %d", resultArr.Get(int64(0)).ToInt())
 			}
-			if resultArr.Elements[int64(1)].ToInt() != 4 {
-				t.Errorf("expected removed[1] to be 4, got %d", resultArr.Elements[int64(1)].ToInt())
+			if resultArr.Get(int64(1)).ToInt() != 4 {
+				t.Errorf("expected removed[1] to be 4, got %d", resultArr.Get(int64(1)).ToInt())
 			}
 
 			// Check modified original array
@@ -1275,11 +1272,11 @@ func TestArrayFunctions(t *testing.T) {
 				t.Errorf("expected array length 3 after splice, got %d", arr.ArrayCount())
 			}
 
-			if arrPtr.Elements[int64(0)].ToInt() != 1 {
-				t.Errorf("expected element 0 to be 1, got %d", arrPtr.Elements[int64(0)].ToInt())
+			if arrPtr.Get(int64(0)).ToInt() != 1 {
+				t.Errorf("expected element 0 to be 1, got %d", arrPtr.Get(int64(0)).ToInt())
 			}
-			if arrPtr.Elements[int64(2)].ToInt() != 5 {
-				t.Errorf("expected element 2 to be 5, got %d", arrPtr.Elements[int64(2)].ToInt())
+			if arrPtr.Get(int64(2)).ToInt() != 5 {
+				t.Errorf("expected element 2 to be 5, got %d", arrPtr.Get(int64(2)).ToInt())
 			}
 		})
 
@@ -1287,16 +1284,14 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 5; i++ {
-				arrPtr.Elements[i] = values.NewInt(i + 1)
+				arrPtr.Set(i, values.NewInt(i + 1))
 			}
-			arrPtr.NextIndex = 5
 
 			// Create replacement array: ['a', 'b']
 			replacement := values.NewArray()
 			replacementPtr := replacement.Data.(*values.Array)
-			replacementPtr.Elements[int64(0)] = values.NewString("a")
-			replacementPtr.Elements[int64(1)] = values.NewString("b")
-			replacementPtr.NextIndex = 2
+			replacementPtr.Set(int64(0), values.NewString("a"))
+			replacementPtr.Set(int64(1), values.NewString("b"))
 
 			removed, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -1315,26 +1310,24 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			// Check that array now has replacements
-			if arrPtr.Elements[int64(1)].ToString() != "a" {
-				t.Errorf("expected element 1 to be 'a', got '%s'", arrPtr.Elements[int64(1)].ToString())
+			if arrPtr.Get(int64(1)).ToString() != "a" {
+				t.Errorf("expected element 1 to be 'a', got '%s'", arrPtr.Get(int64(1)).ToString())
 			}
-			if arrPtr.Elements[int64(2)].ToString() != "b" {
-				t.Errorf("expected element 2 to be 'b', got '%s'", arrPtr.Elements[int64(2)].ToString())
+			if arrPtr.Get(int64(2)).ToString() != "b" {
+				t.Errorf("expected element 2 to be 'b', got '%s'", arrPtr.Get(int64(2)).ToString())
 			}
 		})
 
 		t.Run("insert without removal", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewInt(1)
-			arrPtr.Elements[int64(1)] = values.NewInt(2)
-			arrPtr.NextIndex = 2
+			arrPtr.Set(int64(0), values.NewInt(1))
+			arrPtr.Set(int64(1), values.NewInt(2))
 
 			// Create replacement array: ['x']
 			replacement := values.NewArray()
 			replacementPtr := replacement.Data.(*values.Array)
-			replacementPtr.Elements[int64(0)] = values.NewString("x")
-			replacementPtr.NextIndex = 1
+			replacementPtr.Set(int64(0), values.NewString("x"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -1362,9 +1355,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 5; i++ {
-				arrPtr.Elements[i] = values.NewInt(i + 1)
+				arrPtr.Set(i, values.NewInt(i + 1))
 			}
-			arrPtr.NextIndex = 5
 
 			removed, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -1402,18 +1394,17 @@ func TestArrayFunctions(t *testing.T) {
 			// First record
 			record1 := values.NewArray()
 			record1Ptr := record1.Data.(*values.Array)
-			record1Ptr.Elements["id"] = values.NewInt(1)
-			record1Ptr.Elements["name"] = values.NewString("John")
+			record1Ptr.Set("id", values.NewInt(1))
+			record1Ptr.Set("name", values.NewString("John"))
 
 			// Second record
 			record2 := values.NewArray()
 			record2Ptr := record2.Data.(*values.Array)
-			record2Ptr.Elements["id"] = values.NewInt(2)
-			record2Ptr.Elements["name"] = values.NewString("Jane")
+			record2Ptr.Set("id", values.NewInt(2))
+			record2Ptr.Set("name", values.NewString("Jane"))
 
-			dataPtr.Elements[int64(0)] = record1
-			dataPtr.Elements[int64(1)] = record2
-			dataPtr.NextIndex = 2
+			dataPtr.Set(int64(0), record1)
+			dataPtr.Set(int64(1), record2)
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				data,
@@ -1446,17 +1437,16 @@ func TestArrayFunctions(t *testing.T) {
 
 			record1 := values.NewArray()
 			record1Ptr := record1.Data.(*values.Array)
-			record1Ptr.Elements["id"] = values.NewInt(10)
-			record1Ptr.Elements["name"] = values.NewString("Alice")
+			record1Ptr.Set("id", values.NewInt(10))
+			record1Ptr.Set("name", values.NewString("Alice"))
 
 			record2 := values.NewArray()
 			record2Ptr := record2.Data.(*values.Array)
-			record2Ptr.Elements["id"] = values.NewInt(20)
-			record2Ptr.Elements["name"] = values.NewString("Bob")
+			record2Ptr.Set("id", values.NewInt(20))
+			record2Ptr.Set("name", values.NewString("Bob"))
 
-			dataPtr.Elements[int64(0)] = record1
-			dataPtr.Elements[int64(1)] = record2
-			dataPtr.NextIndex = 2
+			dataPtr.Set(int64(0), record1)
+			dataPtr.Set(int64(1), record2)
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				data,
@@ -1470,12 +1460,12 @@ func TestArrayFunctions(t *testing.T) {
 
 			// Should be indexed by id values
 			resultArr := result.Data.(*values.Array)
-			alice := resultArr.Elements[int64(10)]
+			alice := resultArr.Get(int64(10))
 			if alice == nil || alice.Data.(string) != "Alice" {
 				t.Errorf("expected 'Alice' at key 10, got %v", alice)
 			}
 
-			bob := resultArr.Elements[int64(20)]
+			bob := resultArr.Get(int64(20))
 			if bob == nil || bob.Data.(string) != "Bob" {
 				t.Errorf("expected 'Bob' at key 20, got %v", bob)
 			}
@@ -1487,11 +1477,10 @@ func TestArrayFunctions(t *testing.T) {
 
 			record1 := values.NewArray()
 			record1Ptr := record1.Data.(*values.Array)
-			record1Ptr.Elements["id"] = values.NewInt(1)
-			record1Ptr.Elements["name"] = values.NewString("Test")
+			record1Ptr.Set("id", values.NewInt(1))
+			record1Ptr.Set("name", values.NewString("Test"))
 
-			dataPtr.Elements[int64(0)] = record1
-			dataPtr.NextIndex = 1
+			dataPtr.Set(int64(0), record1)
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				data,
@@ -1505,7 +1494,7 @@ func TestArrayFunctions(t *testing.T) {
 
 			// Should return whole record indexed by id
 			resultArr := result.Data.(*values.Array)
-			wholeRecord := resultArr.Elements[int64(1)]
+			wholeRecord := resultArr.Get(int64(1))
 			if wholeRecord == nil || wholeRecord.Type != values.TypeArray {
 				t.Errorf("expected array at key 1, got %v", wholeRecord)
 			}
@@ -1518,17 +1507,16 @@ func TestArrayFunctions(t *testing.T) {
 
 			record1 := values.NewArray()
 			record1Ptr := record1.Data.(*values.Array)
-			record1Ptr.Elements[int64(0)] = values.NewInt(1)
-			record1Ptr.Elements[int64(1)] = values.NewString("John")
+			record1Ptr.Set(int64(0), values.NewInt(1))
+			record1Ptr.Set(int64(1), values.NewString("John"))
 
 			record2 := values.NewArray()
 			record2Ptr := record2.Data.(*values.Array)
-			record2Ptr.Elements[int64(0)] = values.NewInt(2)
-			record2Ptr.Elements[int64(1)] = values.NewString("Jane")
+			record2Ptr.Set(int64(0), values.NewInt(2))
+			record2Ptr.Set(int64(1), values.NewString("Jane"))
 
-			dataPtr.Elements[int64(0)] = record1
-			dataPtr.Elements[int64(1)] = record2
-			dataPtr.NextIndex = 2
+			dataPtr.Set(int64(0), record1)
+			dataPtr.Set(int64(1), record2)
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				data,
@@ -1555,10 +1543,9 @@ func TestArrayFunctions(t *testing.T) {
 
 			record1 := values.NewArray()
 			record1Ptr := record1.Data.(*values.Array)
-			record1Ptr.Elements["id"] = values.NewInt(1)
+			record1Ptr.Set("id", values.NewInt(1))
 
-			dataPtr.Elements[int64(0)] = record1
-			dataPtr.NextIndex = 1
+			dataPtr.Set(int64(0), record1)
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				data,
@@ -1603,9 +1590,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 5; i++ {
-				arrPtr.Elements[i] = values.NewInt(i + 1)
+				arrPtr.Set(i, values.NewInt(i + 1))
 			}
-			arrPtr.NextIndex = 5
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -1630,9 +1616,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 3; i++ {
-				arrPtr.Elements[i] = values.NewInt(i + 1)
+				arrPtr.Set(i, values.NewInt(i + 1))
 			}
-			arrPtr.NextIndex = 3
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -1644,23 +1629,23 @@ func TestArrayFunctions(t *testing.T) {
 
 			// Should preserve original keys: [2=>3, 1=>2, 0=>1]
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(2)].Data.(int64) != 3 {
-				t.Errorf("expected 3 at key 2, got %v", resultArr.Elements[int64(2)])
+			if resultArr.Get(int64(2)).Data.(int64) != 3 {
+				t.Errorf("expected 3 at key 2, got %v", resultArr.Get(int64(2)))
 			}
-			if resultArr.Elements[int64(1)].Data.(int64) != 2 {
-				t.Errorf("expected 2 at key 1, got %v", resultArr.Elements[int64(1)])
+			if resultArr.Get(int64(1)).Data.(int64) != 2 {
+				t.Errorf("expected 2 at key 1, got %v", resultArr.Get(int64(1)))
 			}
-			if resultArr.Elements[int64(0)].Data.(int64) != 1 {
-				t.Errorf("expected 1 at key 0, got %v", resultArr.Elements[int64(0)])
+			if resultArr.Get(int64(0)).Data.(int64) != 1 {
+				t.Errorf("expected 1 at key 0, got %v", resultArr.Get(int64(0)))
 			}
 		})
 
 		t.Run("associative array", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements["a"] = values.NewString("first")
-			arrPtr.Elements["b"] = values.NewString("second")
-			arrPtr.Elements["c"] = values.NewString("third")
+			arrPtr.Set("a", values.NewString("first"))
+			arrPtr.Set("b", values.NewString("second"))
+			arrPtr.Set("c", values.NewString("third"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -1669,7 +1654,7 @@ func TestArrayFunctions(t *testing.T) {
 
 			// String keys are always preserved
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements["c"].Data.(string) != "third" {
+			if resultArr.Get("c").Data.(string) != "third" {
 				t.Errorf("expected 'third' for key 'c'")
 			}
 		})
@@ -1697,9 +1682,9 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("basic keys extraction", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements["a"] = values.NewString("first")
-			arrPtr.Elements["b"] = values.NewString("second")
-			arrPtr.Elements["c"] = values.NewString("third")
+			arrPtr.Set("a", values.NewString("first"))
+			arrPtr.Set("b", values.NewString("second"))
+			arrPtr.Set("c", values.NewString("third"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -1721,9 +1706,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 3; i++ {
-				arrPtr.Elements[i] = values.NewInt((i + 1) * 10)
+				arrPtr.Set(i, values.NewInt((i + 1) * 10))
 			}
-			arrPtr.NextIndex = 3
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -1744,9 +1728,9 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("search for specific value", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements["a"] = values.NewInt(1)
-			arrPtr.Elements["b"] = values.NewInt(2)
-			arrPtr.Elements["c"] = values.NewInt(3)
+			arrPtr.Set("a", values.NewInt(1))
+			arrPtr.Set("b", values.NewInt(2))
+			arrPtr.Set("c", values.NewInt(3))
 
 			result, err := fn.Builtin(ctx, []*values.Value{
 				arr,
@@ -1770,8 +1754,8 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("strict search", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewInt(1)
-			arrPtr.Elements[int64(1)] = values.NewString("1")
+			arrPtr.Set(int64(0), values.NewInt(1))
+			arrPtr.Set(int64(1), values.NewString("1"))
 
 			// Strict search for int(1)
 			result, err := fn.Builtin(ctx, []*values.Value{
@@ -1797,8 +1781,8 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("loose search", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewInt(1)
-			arrPtr.Elements[int64(1)] = values.NewString("1")
+			arrPtr.Set(int64(0), values.NewInt(1))
+			arrPtr.Set(int64(1), values.NewString("1"))
 
 			// Loose search for string("1")
 			result, err := fn.Builtin(ctx, []*values.Value{
@@ -1845,9 +1829,9 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("associative array", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements["a"] = values.NewInt(1)
-			arrPtr.Elements["b"] = values.NewInt(2)
-			arrPtr.Elements["c"] = values.NewInt(3)
+			arrPtr.Set("a", values.NewInt(1))
+			arrPtr.Set("b", values.NewInt(2))
+			arrPtr.Set("c", values.NewInt(3))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -1873,9 +1857,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
 			for i := int64(0); i < 3; i++ {
-				arrPtr.Elements[i] = values.NewInt((i + 1) * 10)
+				arrPtr.Set(i, values.NewInt((i + 1) * 10))
 			}
-			arrPtr.NextIndex = 3
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -1900,10 +1883,10 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("mixed keys array", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewString("a")
-			arrPtr.Elements["x"] = values.NewString("b")
-			arrPtr.Elements[int64(1)] = values.NewString("c")
-			arrPtr.Elements["y"] = values.NewString("d")
+			arrPtr.Set(int64(0), values.NewString("a"))
+			arrPtr.Set("x", values.NewString("b"))
+			arrPtr.Set(int64(1), values.NewString("c"))
+			arrPtr.Set("y", values.NewString("d"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -1920,10 +1903,10 @@ func TestArrayFunctions(t *testing.T) {
 			val2 := result.ArrayGet(values.NewInt(2))
 			val3 := result.ArrayGet(values.NewInt(3))
 
-			// Numeric keys come first (0, 1), then string keys (x, y)
-			if val0.Data.(string) != "a" || val1.Data.(string) != "c" ||
-			   val2.Data.(string) != "b" || val3.Data.(string) != "d" {
-				t.Errorf("expected values [a,c,b,d], got [%s,%s,%s,%s]",
+			// Values keep the insertion order of their keys
+			if val0.Data.(string) != "a" || val1.Data.(string) != "b" ||
+			   val2.Data.(string) != "c" || val3.Data.(string) != "d" {
+				t.Errorf("expected values [a,b,c,d], got [%s,%s,%s,%s]",
 					val0.Data.(string), val1.Data.(string), val2.Data.(string), val3.Data.(string))
 			}
 		})
@@ -1931,9 +1914,9 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("sparse array", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewString("first")
-			arrPtr.Elements[int64(5)] = values.NewString("second")
-			arrPtr.Elements[int64(10)] = values.NewString("third")
+			arrPtr.Set(int64(0), values.NewString("first"))
+			arrPtr.Set(int64(5), values.NewString("second"))
+			arrPtr.Set(int64(10), values.NewString("third"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -1958,9 +1941,9 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("array with null values", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements["a"] = values.NewNull()
-			arrPtr.Elements["b"] = values.NewString("test")
-			arrPtr.Elements["c"] = values.NewNull()
+			arrPtr.Set("a", values.NewNull())
+			arrPtr.Set("b", values.NewString("test"))
+			arrPtr.Set("c", values.NewNull())
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -2005,16 +1988,14 @@ func TestArrayFunctions(t *testing.T) {
 			arr1 := values.NewArray()
 			arr1Ptr := arr1.Data.(*values.Array)
 			for i := int64(0); i < 3; i++ {
-				arr1Ptr.Elements[i] = values.NewInt(i + 1)
+				arr1Ptr.Set(i, values.NewInt(i + 1))
 			}
-			arr1Ptr.NextIndex = 3
 
 			arr2 := values.NewArray()
 			arr2Ptr := arr2.Data.(*values.Array)
 			for i := int64(0); i < 3; i++ {
-				arr2Ptr.Elements[i] = values.NewInt(i + 4)
+				arr2Ptr.Set(i, values.NewInt(i + 4))
 			}
-			arr2Ptr.NextIndex = 3
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr1, arr2})
 			if err != nil {
@@ -2038,13 +2019,13 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("merge associative arrays", func(t *testing.T) {
 			arr1 := values.NewArray()
 			arr1Ptr := arr1.Data.(*values.Array)
-			arr1Ptr.Elements["a"] = values.NewInt(1)
-			arr1Ptr.Elements["b"] = values.NewInt(2)
+			arr1Ptr.Set("a", values.NewInt(1))
+			arr1Ptr.Set("b", values.NewInt(2))
 
 			arr2 := values.NewArray()
 			arr2Ptr := arr2.Data.(*values.Array)
-			arr2Ptr.Elements["c"] = values.NewInt(3)
-			arr2Ptr.Elements["d"] = values.NewInt(4)
+			arr2Ptr.Set("c", values.NewInt(3))
+			arr2Ptr.Set("d", values.NewInt(4))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr1, arr2})
 			if err != nil {
@@ -2057,10 +2038,10 @@ func TestArrayFunctions(t *testing.T) {
 
 			// Should preserve string keys
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements["a"].Data.(int64) != 1 ||
-			   resultArr.Elements["b"].Data.(int64) != 2 ||
-			   resultArr.Elements["c"].Data.(int64) != 3 ||
-			   resultArr.Elements["d"].Data.(int64) != 4 {
+			if resultArr.Get("a").Data.(int64) != 1 ||
+			   resultArr.Get("b").Data.(int64) != 2 ||
+			   resultArr.Get("c").Data.(int64) != 3 ||
+			   resultArr.Get("d").Data.(int64) != 4 {
 				t.Errorf("associative keys not preserved correctly")
 			}
 		})
@@ -2068,13 +2049,13 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("overlapping string keys", func(t *testing.T) {
 			arr1 := values.NewArray()
 			arr1Ptr := arr1.Data.(*values.Array)
-			arr1Ptr.Elements["a"] = values.NewInt(1)
-			arr1Ptr.Elements["b"] = values.NewInt(2)
+			arr1Ptr.Set("a", values.NewInt(1))
+			arr1Ptr.Set("b", values.NewInt(2))
 
 			arr2 := values.NewArray()
 			arr2Ptr := arr2.Data.(*values.Array)
-			arr2Ptr.Elements["b"] = values.NewInt(20) // Should overwrite
-			arr2Ptr.Elements["c"] = values.NewInt(3)
+			arr2Ptr.Set("b", values.NewInt(20)) // Should overwrite
+			arr2Ptr.Set("c", values.NewInt(3))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr1, arr2})
 			if err != nil {
@@ -2087,21 +2068,21 @@ func TestArrayFunctions(t *testing.T) {
 
 			// b should be overwritten to 20
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements["b"].Data.(int64) != 20 {
-				t.Errorf("expected b to be overwritten to 20, got %d", resultArr.Elements["b"].Data.(int64))
+			if resultArr.Get("b").Data.(int64) != 20 {
+				t.Errorf("expected b to be overwritten to 20, got %d", resultArr.Get("b").Data.(int64))
 			}
 		})
 
 		t.Run("numeric keys get reindexed", func(t *testing.T) {
 			arr1 := values.NewArray()
 			arr1Ptr := arr1.Data.(*values.Array)
-			arr1Ptr.Elements[int64(10)] = values.NewString("a")
-			arr1Ptr.Elements[int64(20)] = values.NewString("b")
+			arr1Ptr.Set(int64(10), values.NewString("a"))
+			arr1Ptr.Set(int64(20), values.NewString("b"))
 
 			arr2 := values.NewArray()
 			arr2Ptr := arr2.Data.(*values.Array)
-			arr2Ptr.Elements[int64(30)] = values.NewString("c")
-			arr2Ptr.Elements[int64(40)] = values.NewString("d")
+			arr2Ptr.Set(int64(30), values.NewString("c"))
+			arr2Ptr.Set(int64(40), values.NewString("d"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr1, arr2})
 			if err != nil {
@@ -2125,18 +2106,18 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("multiple arrays", func(t *testing.T) {
 			arr1 := values.NewArray()
 			arr1Ptr := arr1.Data.(*values.Array)
-			arr1Ptr.Elements[int64(0)] = values.NewInt(1)
-			arr1Ptr.Elements[int64(1)] = values.NewInt(2)
+			arr1Ptr.Set(int64(0), values.NewInt(1))
+			arr1Ptr.Set(int64(1), values.NewInt(2))
 
 			arr2 := values.NewArray()
 			arr2Ptr := arr2.Data.(*values.Array)
-			arr2Ptr.Elements[int64(0)] = values.NewInt(3)
-			arr2Ptr.Elements[int64(1)] = values.NewInt(4)
+			arr2Ptr.Set(int64(0), values.NewInt(3))
+			arr2Ptr.Set(int64(1), values.NewInt(4))
 
 			arr3 := values.NewArray()
 			arr3Ptr := arr3.Data.(*values.Array)
-			arr3Ptr.Elements[int64(0)] = values.NewInt(5)
-			arr3Ptr.Elements[int64(1)] = values.NewInt(6)
+			arr3Ptr.Set(int64(0), values.NewInt(5))
+			arr3Ptr.Set(int64(1), values.NewInt(6))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr1, arr2, arr3})
 			if err != nil {
@@ -2161,8 +2142,8 @@ func TestArrayFunctions(t *testing.T) {
 			arr1 := values.NewArray() // empty
 			arr2 := values.NewArray()
 			arr2Ptr := arr2.Data.(*values.Array)
-			arr2Ptr.Elements[int64(0)] = values.NewInt(1)
-			arr2Ptr.Elements[int64(1)] = values.NewInt(2)
+			arr2Ptr.Set(int64(0), values.NewInt(1))
+			arr2Ptr.Set(int64(1), values.NewInt(2))
 
 			arr3 := values.NewArray() // empty
 
@@ -2201,9 +2182,8 @@ func TestArrayFunctions(t *testing.T) {
 			// [1,2,2,3,1,4,3]
 			values_list := []int64{1, 2, 2, 3, 1, 4, 3}
 			for i, val := range values_list {
-				arrPtr.Elements[int64(i)] = values.NewInt(val)
+				arrPtr.Set(int64(i), values.NewInt(val))
 			}
-			arrPtr.NextIndex = int64(len(values_list))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -2216,10 +2196,10 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(0)].Data.(int64) != 1 ||
-			   resultArr.Elements[int64(1)].Data.(int64) != 2 ||
-			   resultArr.Elements[int64(3)].Data.(int64) != 3 ||
-			   resultArr.Elements[int64(5)].Data.(int64) != 4 {
+			if resultArr.Get(int64(0)).Data.(int64) != 1 ||
+			   resultArr.Get(int64(1)).Data.(int64) != 2 ||
+			   resultArr.Get(int64(3)).Data.(int64) != 3 ||
+			   resultArr.Get(int64(5)).Data.(int64) != 4 {
 				t.Errorf("unique values not correct or keys not preserved")
 			}
 		})
@@ -2231,9 +2211,8 @@ func TestArrayFunctions(t *testing.T) {
 			// ['a','b','b','c','a']
 			strings_list := []string{"a", "b", "b", "c", "a"}
 			for i, val := range strings_list {
-				arrPtr.Elements[int64(i)] = values.NewString(val)
+				arrPtr.Set(int64(i), values.NewString(val))
 			}
-			arrPtr.NextIndex = int64(len(strings_list))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -2246,9 +2225,9 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements[int64(0)].Data.(string) != "a" ||
-			   resultArr.Elements[int64(1)].Data.(string) != "b" ||
-			   resultArr.Elements[int64(3)].Data.(string) != "c" {
+			if resultArr.Get(int64(0)).Data.(string) != "a" ||
+			   resultArr.Get(int64(1)).Data.(string) != "b" ||
+			   resultArr.Get(int64(3)).Data.(string) != "c" {
 				t.Errorf("unique string values not correct or keys not preserved")
 			}
 		})
@@ -2256,10 +2235,10 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("associative array with duplicate values", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements["x"] = values.NewString("a")
-			arrPtr.Elements["y"] = values.NewString("b")
-			arrPtr.Elements["z"] = values.NewString("b") // duplicate
-			arrPtr.Elements["w"] = values.NewString("c")
+			arrPtr.Set("x", values.NewString("a"))
+			arrPtr.Set("y", values.NewString("b"))
+			arrPtr.Set("z", values.NewString("b")) // duplicate
+			arrPtr.Set("w", values.NewString("c"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -2272,14 +2251,14 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if resultArr.Elements["x"].Data.(string) != "a" ||
-			   resultArr.Elements["y"].Data.(string) != "b" ||
-			   resultArr.Elements["w"].Data.(string) != "c" {
+			if resultArr.Get("x").Data.(string) != "a" ||
+			   resultArr.Get("y").Data.(string) != "b" ||
+			   resultArr.Get("w").Data.(string) != "c" {
 				t.Errorf("unique associative values not correct")
 			}
 
 			// z key should be removed (duplicate value)
-			if resultArr.Elements["z"] != nil {
+			if resultArr.Get("z") != nil {
 				t.Errorf("duplicate key 'z' should have been removed")
 			}
 		})
@@ -2287,10 +2266,10 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("with null values", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewNull()
-			arrPtr.Elements[int64(1)] = values.NewString("test")
-			arrPtr.Elements[int64(2)] = values.NewNull() // duplicate
-			arrPtr.Elements[int64(3)] = values.NewString("other")
+			arrPtr.Set(int64(0), values.NewNull())
+			arrPtr.Set(int64(1), values.NewString("test"))
+			arrPtr.Set(int64(2), values.NewNull()) // duplicate
+			arrPtr.Set(int64(3), values.NewString("other"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
@@ -2303,14 +2282,14 @@ func TestArrayFunctions(t *testing.T) {
 			}
 
 			resultArr := result.Data.(*values.Array)
-			if !resultArr.Elements[int64(0)].IsNull() ||
-			   resultArr.Elements[int64(1)].Data.(string) != "test" ||
-			   resultArr.Elements[int64(3)].Data.(string) != "other" {
+			if !resultArr.Get(int64(0)).IsNull() ||
+			   resultArr.Get(int64(1)).Data.(string) != "test" ||
+			   resultArr.Get(int64(3)).Data.(string) != "other" {
 				t.Errorf("unique null values not handled correctly")
 			}
 
 			// Index 2 should be removed (duplicate null)
-			if resultArr.Elements[int64(2)] != nil {
+			if resultArr.Get(int64(2)) != nil {
 				t.Errorf("duplicate null at index 2 should have been removed")
 			}
 		})
@@ -2331,7 +2310,7 @@ func TestArrayFunctions(t *testing.T) {
 		t.Run("single element", func(t *testing.T) {
 			arr := values.NewArray()
 			arrPtr := arr.Data.(*values.Array)
-			arrPtr.Elements[int64(0)] = values.NewString("test")
+			arrPtr.Set(int64(0), values.NewString("test"))
 
 			result, err := fn.Builtin(ctx, []*values.Value{arr})
 			if err != nil {
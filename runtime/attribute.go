@@ -0,0 +1,304 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// Attribute target and flag bits, matching PHP's Attribute constants.
+const (
+	attributeTargetClass         = 1
+	attributeTargetFunction      = 2
+	attributeTargetMethod        = 4
+	attributeTargetProperty      = 8
+	attributeTargetClassConstant = 16
+	attributeTargetParameter     = 32
+	attributeTargetAll           = 63
+	attributeIsRepeatable        = 64
+)
+
+// reflectionAttributeIsInstanceOf is the getAttributes() flag that matches
+// attributes by class hierarchy instead of by exact name.
+const reflectionAttributeIsInstanceOf = 2
+
+var attributeTargetNames = []struct {
+	bit  int64
+	name string
+}{
+	{attributeTargetClass, "class"},
+	{attributeTargetFunction, "function"},
+	{attributeTargetMethod, "method"},
+	{attributeTargetProperty, "property"},
+	{attributeTargetClassConstant, "class constant"},
+	{attributeTargetParameter, "parameter"},
+}
+
+// GetAttributeClasses returns the Attribute and ReflectionAttribute class descriptors
+func GetAttributeClasses() []*registry.ClassDescriptor {
+	return []*registry.ClassDescriptor{
+		getAttributeClass(),
+		getReflectionAttributeClass(),
+	}
+}
+
+func getAttributeClass() *registry.ClassDescriptor {
+	constructor := &registry.Function{
+		Name:       "__construct",
+		IsBuiltin:  true,
+		Visibility: "public",
+		Parameters: []*registry.Parameter{
+			{Name: "flags", Type: "int", HasDefault: true, DefaultValue: values.NewInt(attributeTargetAll)},
+		},
+		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			if len(args) == 0 || args[0] == nil || !args[0].IsObject() {
+				return nil, fmt.Errorf("Attribute::__construct() called on non-object")
+			}
+			flags := values.NewInt(attributeTargetAll)
+			if len(args) > 1 && args[1] != nil {
+				flags = values.NewInt(args[1].ToInt())
+			}
+			args[0].Data.(*values.Object).Properties["flags"] = flags
+			return values.NewNull(), nil
+		},
+	}
+
+	desc := &registry.ClassDescriptor{
+		Name:    "Attribute",
+		IsFinal: true,
+		Properties: map[string]*registry.PropertyDescriptor{
+			"flags": {Name: "flags", Visibility: "public", Type: "int"},
+		},
+		Methods: map[string]*registry.MethodDescriptor{
+			"__construct": {
+				Name:       "__construct",
+				Visibility: "public",
+				Parameters: []*registry.ParameterDescriptor{
+					{Name: "flags", Type: "int", HasDefault: true, DefaultValue: values.NewInt(attributeTargetAll)},
+				},
+				Implementation: NewBuiltinMethodImpl(constructor),
+			},
+		},
+		Constants: make(map[string]*registry.ConstantDescriptor),
+	}
+	for name, value := range map[string]int64{
+		"TARGET_CLASS":          attributeTargetClass,
+		"TARGET_FUNCTION":       attributeTargetFunction,
+		"TARGET_METHOD":         attributeTargetMethod,
+		"TARGET_PROPERTY":       attributeTargetProperty,
+		"TARGET_CLASS_CONSTANT": attributeTargetClassConstant,
+		"TARGET_PARAMETER":      attributeTargetParameter,
+		"TARGET_ALL":            attributeTargetAll,
+		"IS_REPEATABLE":         attributeIsRepeatable,
+	} {
+		desc.Constants[name] = &registry.ConstantDescriptor{Name: name, Visibility: "public", Value: values.NewInt(value), IsFinal: true}
+	}
+	return desc
+}
+
+func getReflectionAttributeClass() *registry.ClassDescriptor {
+	desc := reflectionClassDescriptor("ReflectionAttribute", "", false, map[string]reflectionMethodFunc{
+		"getName": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewString(data.attribute.Name), nil
+		},
+		"getArguments": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			result := values.NewArray()
+			for i, arg := range data.attribute.Arguments {
+				var key *values.Value
+				if name := attributeArgumentName(data.attribute, i); name != "" {
+					key = values.NewString(name)
+				}
+				result.ArraySet(key, copyValue(arg))
+			}
+			return result, nil
+		},
+		"getTarget": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewInt(data.target), nil
+		},
+		"isRepeated": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.repeated), nil
+		},
+		"newInstance": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return newAttributeInstance(ctx, data)
+		},
+	})
+	desc.Constants["IS_INSTANCEOF"] = &registry.ConstantDescriptor{Name: "IS_INSTANCEOF", Visibility: "public", Value: values.NewInt(reflectionAttributeIsInstanceOf), IsFinal: true}
+	return desc
+}
+
+func attributeArgumentName(attr *registry.Attribute, i int) string {
+	if i < len(attr.ArgumentNames) {
+		return attr.ArgumentNames[i]
+	}
+	return ""
+}
+
+// reflectionGetAttributes implements getAttributes() for the reflector
+// whose declaration carries attrs.
+func reflectionGetAttributes(ctx registry.BuiltinCallContext, attrs []*registry.Attribute, target int64, args []*values.Value) (*values.Value, error) {
+	name := ""
+	if filter := reflectionArg(args, 0); !filter.IsNull() {
+		name = strings.TrimPrefix(filter.ToString(), "\\")
+	}
+	flags := reflectionArg(args, 1).ToInt()
+	if flags&^reflectionAttributeIsInstanceOf != 0 {
+		return throwReflectionError(ctx, "ValueError", "getAttributes(): Argument #2 ($flags) must be a valid attribute filter flag")
+	}
+	if name != "" && flags&reflectionAttributeIsInstanceOf != 0 {
+		if _, _, ok := reflectionLookupClass(ctx, name); !ok {
+			return throwReflectionError(ctx, "Error", "Class \"%s\" not found", name)
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, attr := range attrs {
+		counts[strings.ToLower(attr.Name)]++
+	}
+
+	var result []*values.Value
+	for _, attr := range attrs {
+		if name != "" {
+			if flags&reflectionAttributeIsInstanceOf != 0 {
+				if !reflectionIsA(ctx, attr.Name, name) {
+					continue
+				}
+			} else if !strings.EqualFold(attr.Name, name) {
+				continue
+			}
+		}
+		data := &reflectionData{
+			attribute: attr,
+			target:    target,
+			repeated:  counts[strings.ToLower(attr.Name)] > 1,
+		}
+		result = append(result, attachReflectionData(values.NewObject("ReflectionAttribute"), data))
+	}
+	return reflectionList(result), nil
+}
+
+// attributeFlags returns the Attribute flags declared on an attribute class,
+// or false if the class is not marked with #[Attribute].
+func attributeFlags(cls *registry.Class) (int64, bool) {
+	if strings.EqualFold(cls.Name, "Attribute") {
+		return attributeTargetClass, true
+	}
+	for _, attr := range cls.Attributes {
+		if !strings.EqualFold(attr.Name, "Attribute") {
+			continue
+		}
+		if len(attr.Arguments) > 0 && attr.Arguments[0] != nil {
+			return attr.Arguments[0].ToInt(), true
+		}
+		return attributeTargetAll, true
+	}
+	return 0, false
+}
+
+func attributeTargetList(flags int64) string {
+	var names []string
+	for _, target := range attributeTargetNames {
+		if flags&target.bit != 0 {
+			names = append(names, target.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// newAttributeInstance validates the attribute against its class's
+// #[Attribute] declaration and instantiates it with the stored arguments.
+func newAttributeInstance(ctx registry.BuiltinCallContext, data *reflectionData) (*values.Value, error) {
+	attr := data.attribute
+	cls, kind, ok := reflectionLookupClass(ctx, attr.Name)
+	if !ok || kind != "class" {
+		return throwReflectionError(ctx, "Error", "Attribute class \"%s\" not found", attr.Name)
+	}
+	flags, ok := attributeFlags(cls)
+	if !ok {
+		return throwReflectionError(ctx, "Error", "Attempting to use non-attribute class \"%s\" as attribute", cls.Name)
+	}
+	if flags&data.target == 0 {
+		return throwReflectionError(ctx, "Error", "Attribute \"%s\" cannot target %s (allowed targets: %s)", cls.Name, attributeTargetList(data.target), attributeTargetList(flags))
+	}
+	if data.repeated && flags&attributeIsRepeatable == 0 {
+		return throwReflectionError(ctx, "Error", "Attribute \"%s\" must not be repeated", cls.Name)
+	}
+
+	classData := &reflectionData{class: cls, kind: kind}
+	args, err := attributeConstructorArgs(ctx, classData, attr)
+	if err != nil {
+		return nil, err
+	}
+	return reflectionNewInstance(ctx, classData, args, true)
+}
+
+// attributeConstructorArgs orders the attribute arguments for the attribute
+// class's constructor, placing named arguments by parameter name.
+func attributeConstructorArgs(ctx registry.BuiltinCallContext, data *reflectionData, attr *registry.Attribute) ([]*values.Value, error) {
+	var args []*values.Value
+	named := false
+	for i, arg := range attr.Arguments {
+		if attributeArgumentName(attr, i) != "" {
+			named = true
+			break
+		}
+		args = append(args, copyValue(arg))
+	}
+	if !named {
+		return args, nil
+	}
+
+	ctor, _ := reflectionFindMethod(ctx, data.class, "__construct")
+	if ctor == nil {
+		_, err := throwReflectionError(ctx, "Error", "Attribute class %s does not have a constructor, cannot pass arguments", data.class.Name)
+		return nil, err
+	}
+	slots := make([]*values.Value, len(ctor.Parameters))
+	copy(slots, args)
+	for i, arg := range attr.Arguments {
+		name := attributeArgumentName(attr, i)
+		if name == "" {
+			continue
+		}
+		position := -1
+		for j, param := range ctor.Parameters {
+			if reflectionParameterName(param) == name {
+				position = j
+				break
+			}
+		}
+		if position < 0 {
+			_, err := throwReflectionError(ctx, "Error", "Unknown named parameter $%s", name)
+			return nil, err
+		}
+		if slots[position] != nil {
+			_, err := throwReflectionError(ctx, "Error", "Named parameter $%s overwrites previous argument", name)
+			return nil, err
+		}
+		slots[position] = copyValue(arg)
+	}
+
+	// Trailing omitted parameters are left to the constructor's defaults
+	last := len(slots)
+	for last > 0 && slots[last-1] == nil {
+		last--
+	}
+	args = slots[:last]
+	for i, arg := range args {
+		if arg != nil {
+			continue
+		}
+		param := ctor.Parameters[i]
+		if !param.HasDefault {
+			_, err := throwReflectionError(ctx, "ArgumentCountError", "%s::__construct(): Argument #%d ($%s) not passed", data.class.Name, i+1, reflectionParameterName(param))
+			return nil, err
+		}
+		if param.DefaultValue == nil {
+			args[i] = values.NewNull()
+		} else {
+			args[i] = copyValue(param.DefaultValue)
+		}
+	}
+	return args, nil
+}
@@ -31,11 +31,13 @@ func GetAllBuiltinFunctions() []*registry.Function {
 	functions = append(functions, GetRegexCacheFunctions()...)
 	functions = append(functions, GetTypeFunctions()...)
 	functions = append(functions, GetEncodingFunctions()...)
+	functions = append(functions, GetPackFunctions()...)
 	functions = append(functions, GetFilesystemFunctions()...)
 	functions = append(functions, GetSystemFunctions()...)
 	functions = append(functions, GetTimeFunctions()...)
 	functions = append(functions, GetDateTimeFunctions()...)
 	functions = append(functions, GetDateTimeObjectFunctions()...)
+	functions = append(functions, GetDateTimeClassFunctions()...)
 	functions = append(functions, GetMathFunctions()...)
 	functions = append(functions, GetOutputFunctions()...)
 	functions = append(functions, GetReflectionFunctions()...)
@@ -51,6 +53,7 @@ func GetAllBuiltinFunctions() []*registry.Function {
 	functions = append(functions, GetMySQLiFunctions()...)
 	functions = append(functions, GetMySQLiAdvancedFunctions()...)
 	functions = append(functions, GetMySQLiStmtFunctions()...)
+	functions = append(functions, GetCoverageFunctions()...)
 
 	return functions
 }
@@ -91,6 +94,24 @@ func GetAllBuiltinClasses() []*registry.ClassDescriptor {
 	// Add PDO classes
 	classes = append(classes, GetPDOClassDescriptors()...)
 
+	// Add Reflection classes
+	classes = append(classes, GetReflectionClasses()...)
+
+	// Add Attribute classes
+	classes = append(classes, GetAttributeClasses()...)
+
+	// Add Fiber classes
+	classes = append(classes, GetFiberClasses()...)
+
+	// Add the Closure class
+	classes = append(classes, GetClosureClasses()...)
+
+	// Add WeakReference and WeakMap
+	classes = append(classes, GetWeakReferenceClasses()...)
+
+	// Add the date and time classes
+	classes = append(classes, GetDateTimeClasses()...)
+
 	return classes
 }
 
@@ -104,6 +125,12 @@ func GetAllBuiltinInterfaces() []*registry.Interface {
 	// Add interfaces from SPL module
 	interfaces = append(interfaces, spl.GetSplInterfaces()...)
 
+	// Add UnitEnum and BackedEnum
+	interfaces = append(interfaces, GetEnumInterfaces()...)
+
+	// Add DateTimeInterface
+	interfaces = append(interfaces, GetDateTimeInterfaces()...)
+
 	return interfaces
 }
 
@@ -528,6 +555,10 @@ func GetAllBuiltinConstants() []*registry.ConstantDescriptor {
 		})
 	}
 
+	constants = append(constants, GetCoverageConstants()...)
+	constants = append(constants, GetRegexConstants()...)
+	constants = append(constants, GetDateTimeConstants()...)
+
 	return constants
 }
 
@@ -0,0 +1,202 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// ClosureRuntime is implemented by the VM's builtin call context, which can
+// run closures and resolve any kind of callable.
+type ClosureRuntime interface {
+	// CallClosure invokes closure with args and returns its result.
+	CallClosure(closure *values.Value, args []*values.Value) (*values.Value, error)
+	// ClosureFromCallable returns a closure calling callable, throwing a
+	// TypeError when it is not callable.
+	ClosureFromCallable(callable *values.Value) (*values.Value, error)
+}
+
+// GetClosureClasses returns the Closure class descriptor
+func GetClosureClasses() []*registry.ClassDescriptor {
+	return []*registry.ClassDescriptor{getClosureClass()}
+}
+
+type closureMethodFunc func(ctx registry.BuiltinCallContext, closures ClosureRuntime, this *values.Value, args []*values.Value) (*values.Value, error)
+
+func getClosureClass() *registry.ClassDescriptor {
+	methods := map[string]closureMethodFunc{
+		"bindTo": func(ctx registry.BuiltinCallContext, _ ClosureRuntime, this *values.Value, args []*values.Value) (*values.Value, error) {
+			return bindClosure(ctx, "Closure::bindTo", this, closureArg(args, 0), closureScopeArg(args, 1))
+		},
+		"call": func(ctx registry.BuiltinCallContext, closures ClosureRuntime, this *values.Value, args []*values.Value) (*values.Value, error) {
+			newThis := closureArg(args, 0)
+			if !newThis.IsObject() {
+				return throwClosureTypeError(ctx, "Closure::call(): Argument #1 ($newThis) must be of type object, %s given", newThis.TypeName())
+			}
+			bound, err := bindClosure(ctx, "Closure::call", this, newThis, newThis)
+			if err != nil || bound.IsNull() {
+				return bound, err
+			}
+			return closures.CallClosure(bound, args[1:])
+		},
+		"__invoke": func(_ registry.BuiltinCallContext, closures ClosureRuntime, this *values.Value, args []*values.Value) (*values.Value, error) {
+			return closures.CallClosure(this, args)
+		},
+	}
+	staticMethods := map[string]closureMethodFunc{
+		"bind": func(ctx registry.BuiltinCallContext, _ ClosureRuntime, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			closure := closureArg(args, 0)
+			if !closure.IsCallable() {
+				return throwClosureTypeError(ctx, "Closure::bind(): Argument #1 ($closure) must be of type Closure, %s given", closure.TypeName())
+			}
+			return bindClosure(ctx, "Closure::bind", closure, closureArg(args, 1), closureScopeArg(args, 2))
+		},
+		"fromCallable": func(_ registry.BuiltinCallContext, closures ClosureRuntime, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			return closures.ClosureFromCallable(closureArg(args, 0))
+		},
+	}
+
+	desc := &registry.ClassDescriptor{
+		Name:       "Closure",
+		IsFinal:    true,
+		Properties: make(map[string]*registry.PropertyDescriptor),
+		Methods:    make(map[string]*registry.MethodDescriptor),
+		Constants:  make(map[string]*registry.ConstantDescriptor),
+	}
+	for name, impl := range methods {
+		desc.Methods[name] = closureMethod(name, impl, false)
+	}
+	for name, impl := range staticMethods {
+		desc.Methods[name] = closureMethod(name, impl, true)
+	}
+	return desc
+}
+
+func closureMethod(name string, impl closureMethodFunc, static bool) *registry.MethodDescriptor {
+	fn := &registry.Function{
+		Name:       name,
+		IsBuiltin:  true,
+		IsStatic:   static,
+		IsVariadic: true,
+		Visibility: "public",
+		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			closures, ok := ctx.(ClosureRuntime)
+			if !ok {
+				return nil, fmt.Errorf("Closure::%s() is not supported in this context", name)
+			}
+			if static {
+				return impl(ctx, closures, nil, args)
+			}
+			if len(args) == 0 || args[0] == nil || !args[0].IsCallable() {
+				return nil, fmt.Errorf("Closure::%s() called on non-closure", name)
+			}
+			return impl(ctx, closures, args[0], args[1:])
+		},
+	}
+	return &registry.MethodDescriptor{
+		Name:           name,
+		Visibility:     "public",
+		IsStatic:       static,
+		IsVariadic:     true,
+		Parameters:     []*registry.ParameterDescriptor{},
+		Implementation: NewBuiltinMethodImpl(fn),
+	}
+}
+
+// closureArg returns argument i, or null when it was not passed
+func closureArg(args []*values.Value, i int) *values.Value {
+	if i >= len(args) || args[i] == nil {
+		return values.NewNull()
+	}
+	return args[i]
+}
+
+// closureScopeArg returns the $newScope argument at i, which defaults to
+// "static", keeping the closure's current scope
+func closureScopeArg(args []*values.Value, i int) *values.Value {
+	if i >= len(args) || args[i] == nil {
+		return values.NewString("static")
+	}
+	return args[i]
+}
+
+// bindClosure returns a copy of closure with $this bound to newThis, or
+// unbound when it is null. scope is an object or class name giving the new
+// class scope, "static" to keep the current one or null for none. Bindings
+// PHP refuses with a warning yield null.
+func bindClosure(ctx registry.BuiltinCallContext, method string, closure, newThis, scope *values.Value) (*values.Value, error) {
+	source := closure.ClosureGet()
+	if source == nil {
+		return values.NewNull(), nil
+	}
+	if !newThis.IsNull() && !newThis.IsObject() {
+		return throwClosureTypeError(ctx, "%s(): Argument #1 ($newThis) must be of type ?object, %s given", method, newThis.TypeName())
+	}
+
+	newScope := source.Scope
+	switch {
+	case scope.IsObject():
+		newScope = scope.Data.(*values.Object).ClassName
+	case scope.IsNull():
+		newScope = ""
+	case scope.ToString() != "static":
+		name := strings.TrimPrefix(scope.ToString(), "\\")
+		if class, ok := ctx.LookupUserClass(name); ok {
+			newScope = class.Name
+		} else if class, _ := ctx.SymbolRegistry().GetClass(name); class != nil {
+			newScope = class.Name
+		} else {
+			// Warning: Class "name" not found
+			return values.NewNull(), nil
+		}
+	}
+
+	bound := &values.Closure{
+		Function:  source.Function,
+		BoundVars: make(map[string]*values.Value, len(source.BoundVars)),
+		Name:      source.Name,
+		This:      source.This,
+		Scope:     newScope,
+	}
+	for name, value := range source.BoundVars {
+		bound.BoundVars[name] = value
+	}
+
+	switch kind, _ := source.Function.(string); kind {
+	case "bound_method":
+		// Closures of methods keep their scope and can only move to
+		// another object; Warning: Cannot unbind $this of method
+		if newThis.IsNull() {
+			return values.NewNull(), nil
+		}
+		bound.BoundVars["object"] = newThis
+		return values.NewCallable(bound), nil
+	case "static_method":
+		// Warning: Cannot bind an instance to a static closure
+		if !newThis.IsNull() {
+			return values.NewNull(), nil
+		}
+		return values.NewCallable(bound), nil
+	}
+
+	if fn, ok := source.Function.(*registry.Function); ok && fn.IsStatic && !newThis.IsNull() {
+		// Warning: Cannot bind an instance to a static closure
+		return values.NewNull(), nil
+	}
+	bound.This = nil
+	if newThis.IsObject() {
+		bound.This = newThis
+	}
+	return values.NewCallable(bound), nil
+}
+
+func throwClosureTypeError(ctx registry.BuiltinCallContext, format string, args ...interface{}) (*values.Value, error) {
+	message := fmt.Sprintf(format, args...)
+	exception := CreateException(ctx, "TypeError", message)
+	if exception == nil {
+		return nil, fmt.Errorf("TypeError: %s", message)
+	}
+	return nil, ctx.ThrowException(exception)
+}
@@ -30,8 +30,10 @@ func copyValue(val *values.Value) *values.Value {
 		return values.NewFloat(val.ToFloat())
 	case values.TypeString:
 		return values.NewString(val.ToString())
+	case values.TypeArray:
+		return values.NewArrayValue(val.Data.(*values.Array).Copy())
 	default:
-		// For complex types (objects, arrays, etc.), return the same reference
+		// For complex types (objects, etc.), return the same reference
 		// This is a simplified copy - full deep copy would be more complex
 		return val
 	}
@@ -42,12 +44,11 @@ type GoroutineExecutor interface {
 	ExecuteFunction(fn *registry.Function, boundVars map[string]*values.Value) (*values.Value, error)
 }
 
-// Global executor that will be set by the VM
-var globalGoroutineExecutor GoroutineExecutor
-
-// SetGoroutineExecutor sets the global executor for goroutine bytecode execution
-func SetGoroutineExecutor(executor GoroutineExecutor) {
-	globalGoroutineExecutor = executor
+// goroutineExecutorOwner is implemented by call contexts whose VM can run
+// functions in goroutines. Each VM brings its own executor, so concurrent
+// requests never share one.
+type goroutineExecutorOwner interface {
+	GoroutineExecutor() GoroutineExecutor
 }
 
 // Global goroutine manager and context tracking
@@ -117,7 +118,7 @@ type GoroutineManager struct {
 }
 
 // ExecuteGoroutine runs a goroutine with basic execution
-func (gm *GoroutineManager) ExecuteGoroutine(gor *values.Value) {
+func (gm *GoroutineManager) ExecuteGoroutine(gor *values.Value, executor GoroutineExecutor) {
 	goroutineData := gor.Data.(*values.Goroutine)
 
 	gm.mu.Lock()
@@ -167,7 +168,7 @@ func (gm *GoroutineManager) ExecuteGoroutine(gor *values.Value) {
 				}
 			} else if fn.Instructions != nil {
 				// User-defined function with bytecode - need VM execution
-				err := gm.executeUserFunction(fn, goroutineData)
+				err := gm.executeUserFunction(fn, goroutineData, executor)
 				if err != nil {
 					goroutineData.Status = "error"
 					goroutineData.Error = err
@@ -187,8 +188,8 @@ func (gm *GoroutineManager) ExecuteGoroutine(gor *values.Value) {
 }
 
 // executeUserFunction executes a user-defined PHP function in a goroutine context
-func (gm *GoroutineManager) executeUserFunction(fn *registry.Function, goroutineData *values.Goroutine) error {
-	if globalGoroutineExecutor == nil {
+func (gm *GoroutineManager) executeUserFunction(fn *registry.Function, goroutineData *values.Goroutine, executor GoroutineExecutor) error {
+	if executor == nil {
 		return fmt.Errorf("no goroutine executor registered - VM integration not available")
 	}
 
@@ -212,8 +213,8 @@ func (gm *GoroutineManager) executeUserFunction(fn *registry.Function, goroutine
 		}
 	}
 
-	// Execute the function using the global executor
-	result, err := globalGoroutineExecutor.ExecuteFunction(fn, boundVars)
+	// Execute the function using the executor of the calling VM
+	result, err := executor.ExecuteFunction(fn, boundVars)
 	if err != nil {
 		return err
 	}
@@ -275,8 +276,13 @@ func GetConcurrencyFunctions() []*registry.Function {
 				// Create goroutine value with the isolated closure copy
 				gor := values.NewGoroutine(closureCopy, useVars)
 
+				var executor GoroutineExecutor
+				if owner, ok := ctx.(goroutineExecutorOwner); ok {
+					executor = owner.GoroutineExecutor()
+				}
+
 				// Execute immediately in real Go goroutine
-				goroutineManager.ExecuteGoroutine(gor)
+				goroutineManager.ExecuteGoroutine(gor, executor)
 
 				return gor, nil
 			},
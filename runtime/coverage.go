@@ -0,0 +1,192 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// CoverageRuntime is implemented by the VM's builtin call context, which
+// records the lines scripts run.
+type CoverageRuntime interface {
+	// StartCoverage starts or resumes recording, also recording branches
+	// when asked to on first start.
+	StartCoverage(branches bool)
+	// StopCoverage pauses recording.
+	StopCoverage()
+	// CoverageStarted reports whether lines are being recorded.
+	CoverageStarted() bool
+	// ClearCoverage resets the recorded counts, and forgets the files seen
+	// so far when files is set.
+	ClearCoverage(files bool)
+	// CollectCoverage returns the times each executable line of each file
+	// ran, zero for lines that did not.
+	CollectCoverage() map[string]map[int]int
+}
+
+// Collection modes of pcov\collect()
+const (
+	pcovAll       = 0
+	pcovInclusive = 1
+	pcovExclusive = 2
+)
+
+// Options of xdebug_start_code_coverage()
+const (
+	xdebugCoverageUnused      = 1
+	xdebugCoverageDeadCode    = 2
+	xdebugCoverageBranchCheck = 4
+)
+
+// GetCoverageConstants returns the constants of the pcov and Xdebug code
+// coverage functions
+func GetCoverageConstants() []*registry.ConstantDescriptor {
+	return []*registry.ConstantDescriptor{
+		{Name: "pcov\\all", Value: values.NewInt(pcovAll)},
+		{Name: "pcov\\inclusive", Value: values.NewInt(pcovInclusive)},
+		{Name: "pcov\\exclusive", Value: values.NewInt(pcovExclusive)},
+		{Name: "pcov\\version", Value: values.NewString("1.0.11")},
+		{Name: "XDEBUG_CC_UNUSED", Value: values.NewInt(xdebugCoverageUnused)},
+		{Name: "XDEBUG_CC_DEAD_CODE", Value: values.NewInt(xdebugCoverageDeadCode)},
+		{Name: "XDEBUG_CC_BRANCH_CHECK", Value: values.NewInt(xdebugCoverageBranchCheck)},
+	}
+}
+
+// GetCoverageFunctions returns the code coverage functions of the pcov
+// extension, which php-code-coverage drives, and their Xdebug equivalents
+func GetCoverageFunctions() []*registry.Function {
+	return []*registry.Function{
+		coverageFunction("pcov\\start", 0, 0, func(coverage CoverageRuntime, _ []*values.Value) *values.Value {
+			coverage.StartCoverage(false)
+			return values.NewNull()
+		}),
+		coverageFunction("pcov\\stop", 0, 0, func(coverage CoverageRuntime, _ []*values.Value) *values.Value {
+			coverage.StopCoverage()
+			return values.NewNull()
+		}),
+		coverageFunction("pcov\\clear", 0, 1, func(coverage CoverageRuntime, args []*values.Value) *values.Value {
+			coverage.ClearCoverage(len(args) > 0 && args[0].ToBool())
+			return values.NewNull()
+		}),
+		coverageFunction("pcov\\waiting", 0, 0, func(coverage CoverageRuntime, _ []*values.Value) *values.Value {
+			files := values.NewArray()
+			for i, file := range sortedCoverageFiles(coverage.CollectCoverage()) {
+				files.ArraySet(values.NewInt(int64(i)), values.NewString(file))
+			}
+			return files
+		}),
+		// pcov\collect(int $type = pcov\all, array $filter = []) returns, for
+		// the files selected by $type and $filter, 1 for each line run and
+		// -1 for each line not run
+		coverageFunction("pcov\\collect", 0, 2, func(coverage CoverageRuntime, args []*values.Value) *values.Value {
+			mode := int64(pcovAll)
+			if len(args) > 0 {
+				mode = args[0].ToInt()
+			}
+			filter := make(map[string]bool)
+			if len(args) > 1 && args[1].IsArray() {
+				for _, file := range args[1].Data.(*values.Array).All() {
+					filter[file.ToString()] = true
+				}
+			}
+			collected := coverage.CollectCoverage()
+			for file := range collected {
+				if (mode == pcovInclusive && !filter[file]) || (mode == pcovExclusive && filter[file]) {
+					delete(collected, file)
+				}
+			}
+			return coverageArray(collected, true)
+		}),
+		coverageFunction("pcov\\memory", 0, 0, func(coverage CoverageRuntime, _ []*values.Value) *values.Value {
+			size := 0
+			for file, lines := range coverage.CollectCoverage() {
+				size += len(file) + 16*len(lines)
+			}
+			return values.NewInt(int64(size))
+		}),
+
+		coverageFunction("xdebug_start_code_coverage", 0, 1, func(coverage CoverageRuntime, args []*values.Value) *values.Value {
+			options := int64(0)
+			if len(args) > 0 {
+				options = args[0].ToInt()
+			}
+			xdebugCoverageUnusedLines = options&xdebugCoverageUnused != 0
+			coverage.StartCoverage(options&xdebugCoverageBranchCheck != 0)
+			return values.NewBool(true)
+		}),
+		coverageFunction("xdebug_stop_code_coverage", 0, 1, func(coverage CoverageRuntime, args []*values.Value) *values.Value {
+			coverage.StopCoverage()
+			if len(args) == 0 || args[0].ToBool() {
+				coverage.ClearCoverage(true)
+			}
+			return values.NewBool(true)
+		}),
+		coverageFunction("xdebug_code_coverage_started", 0, 0, func(coverage CoverageRuntime, _ []*values.Value) *values.Value {
+			return values.NewBool(coverage.CoverageStarted())
+		}),
+		// xdebug_get_code_coverage() returns 1 for each line run and, with
+		// XDEBUG_CC_UNUSED, -1 for each line not run
+		coverageFunction("xdebug_get_code_coverage", 0, 0, func(coverage CoverageRuntime, _ []*values.Value) *values.Value {
+			return coverageArray(coverage.CollectCoverage(), xdebugCoverageUnusedLines)
+		}),
+	}
+}
+
+// xdebugCoverageUnusedLines is set when xdebug_start_code_coverage() was
+// passed XDEBUG_CC_UNUSED
+var xdebugCoverageUnusedLines bool
+
+func coverageFunction(name string, minArgs, maxArgs int, impl func(coverage CoverageRuntime, args []*values.Value) *values.Value) *registry.Function {
+	return &registry.Function{
+		Name:       name,
+		Parameters: []*registry.Parameter{},
+		ReturnType: "mixed",
+		MinArgs:    minArgs,
+		MaxArgs:    maxArgs,
+		IsBuiltin:  true,
+		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			coverage, ok := ctx.(CoverageRuntime)
+			if !ok {
+				return nil, fmt.Errorf("%s() is not supported in this context", name)
+			}
+			return impl(coverage, args), nil
+		},
+	}
+}
+
+// coverageArray renders collected coverage as PHP arrays of files mapping
+// lines to 1 when they ran, and to -1 when they did not and unused is set
+func coverageArray(collected map[string]map[int]int, unused bool) *values.Value {
+	result := values.NewArray()
+	for _, file := range sortedCoverageFiles(collected) {
+		lines := make([]int, 0, len(collected[file]))
+		for line := range collected[file] {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		fileLines := values.NewArray()
+		for _, line := range lines {
+			switch {
+			case collected[file][line] > 0:
+				fileLines.ArraySet(values.NewInt(int64(line)), values.NewInt(1))
+			case unused:
+				fileLines.ArraySet(values.NewInt(int64(line)), values.NewInt(-1))
+			}
+		}
+		if fileLines.ArrayCount() > 0 {
+			result.ArraySet(values.NewString(file), fileLines)
+		}
+	}
+	return result
+}
+
+func sortedCoverageFiles(collected map[string]map[int]int) []string {
+	files := make([]string, 0, len(collected))
+	for file := range collected {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
@@ -5,7 +5,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-	"regexp"
 	"sync"
 
 	"github.com/wudi/hey/registry"
@@ -318,13 +317,11 @@ func GetDateTimeFunctions() []*registry.Function {
 			Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
 				timeStr := args[0].ToString()
 
-				var baseTime time.Time
+				baseTime := time.Now()
 				if len(args) > 1 && !args[1].IsNull() {
-					timestamp := args[1].ToInt()
-					baseTime = time.Unix(timestamp, 0)
-				} else {
-					baseTime = time.Now()
+					baseTime = time.Unix(args[1].ToInt(), 0)
 				}
+				baseTime = baseTime.In(defaultDateZone().loc)
 
 				result, err := parseTimeString(timeStr, baseTime)
 				if err != nil {
@@ -344,43 +341,7 @@ func GetDateTimeFunctions() []*registry.Function {
 			MaxArgs:    1,
 			IsBuiltin:  true,
 			Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
-				dateStr := args[0].ToString()
-
-				result := values.NewArray()
-
-				// Initialize all fields
-				result.ArraySet(values.NewString("year"), values.NewBool(false))
-				result.ArraySet(values.NewString("month"), values.NewBool(false))
-				result.ArraySet(values.NewString("day"), values.NewBool(false))
-				result.ArraySet(values.NewString("hour"), values.NewBool(false))
-				result.ArraySet(values.NewString("minute"), values.NewBool(false))
-				result.ArraySet(values.NewString("second"), values.NewBool(false))
-				result.ArraySet(values.NewString("fraction"), values.NewFloat(0))
-				result.ArraySet(values.NewString("warning_count"), values.NewInt(0))
-				result.ArraySet(values.NewString("warnings"), values.NewArray())
-				result.ArraySet(values.NewString("error_count"), values.NewInt(0))
-				result.ArraySet(values.NewString("errors"), values.NewArray())
-				result.ArraySet(values.NewString("is_localtime"), values.NewBool(false))
-
-				// Try to parse the date
-				parsedTime, err := parseTimeStringForParsing(dateStr)
-				if err != nil {
-					// Set error information
-					errors := values.NewArray()
-					errors.ArraySet(values.NewInt(0), values.NewString("The timezone could not be found in the database"))
-					result.ArraySet(values.NewString("error_count"), values.NewInt(1))
-					result.ArraySet(values.NewString("errors"), errors)
-				} else {
-					// Set parsed values
-					result.ArraySet(values.NewString("year"), values.NewInt(int64(parsedTime.Year())))
-					result.ArraySet(values.NewString("month"), values.NewInt(int64(parsedTime.Month())))
-					result.ArraySet(values.NewString("day"), values.NewInt(int64(parsedTime.Day())))
-					result.ArraySet(values.NewString("hour"), values.NewInt(int64(parsedTime.Hour())))
-					result.ArraySet(values.NewString("minute"), values.NewInt(int64(parsedTime.Minute())))
-					result.ArraySet(values.NewString("second"), values.NewInt(int64(parsedTime.Second())))
-				}
-
-				return result, nil
+				return dateParseResult(parseDateText(args[0].ToString())), nil
 			},
 		},
 		{
@@ -874,10 +835,24 @@ func formatDateTime(format string, t time.Time) (string, error) {
 		char := format[i]
 
 		switch char {
-		case 'Y': // 4-digit year
-			result += strconv.Itoa(t.Year())
+		case 'Y': // Year with at least 4 digits, negative before year 1
+			if t.Year() < 0 {
+				result += fmt.Sprintf("-%04d", -t.Year())
+			} else {
+				result += fmt.Sprintf("%04d", t.Year())
+			}
 		case 'y': // 2-digit year
 			result += fmt.Sprintf("%02d", t.Year()%100)
+		case 'o': // ISO-8601 week-numbering year
+			year, _ := t.ISOWeek()
+			result += strconv.Itoa(year)
+		case 'L': // Leap year (1 if leap year, 0 otherwise)
+			year := t.Year()
+			if (year%4 == 0 && year%100 != 0) || year%400 == 0 {
+				result += "1"
+			} else {
+				result += "0"
+			}
 		case 'm': // Month with leading zeros (01-12)
 			result += fmt.Sprintf("%02d", int(t.Month()))
 		case 'n': // Month without leading zeros (1-12)
@@ -886,38 +861,45 @@ func formatDateTime(format string, t time.Time) (string, error) {
 			result += t.Month().String()
 		case 'M': // Short month name
 			result += t.Month().String()[:3]
+		case 't': // Number of days in month
+			// Get last day of month
+			lastDay := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+			result += strconv.Itoa(lastDay)
 		case 'd': // Day with leading zeros (01-31)
 			result += fmt.Sprintf("%02d", t.Day())
 		case 'j': // Day without leading zeros (1-31)
 			result += strconv.Itoa(t.Day())
+		case 'S': // English ordinal suffix of the day (st, nd, rd, th)
+			result += ordinalSuffix(t.Day())
+		case 'z': // Day of year (0-365)
+			result += strconv.Itoa(t.YearDay() - 1)
 		case 'l': // Full day name
 			result += t.Weekday().String()
 		case 'D': // Short day name
 			result += t.Weekday().String()[:3]
+		case 'w': // Day of week (0=Sunday, 6=Saturday)
+			result += strconv.Itoa(int(t.Weekday()))
+		case 'N': // ISO-8601 day of week (1=Monday, 7=Sunday)
+			result += strconv.Itoa((int(t.Weekday())+6)%7 + 1)
+		case 'W': // Week number (ISO-8601)
+			_, week := t.ISOWeek()
+			result += fmt.Sprintf("%02d", week)
 		case 'H': // Hour in 24h format with leading zeros (00-23)
 			result += fmt.Sprintf("%02d", t.Hour())
 		case 'G': // Hour in 24h format without leading zeros (0-23)
 			result += strconv.Itoa(t.Hour())
 		case 'h': // Hour in 12h format with leading zeros (01-12)
-			hour := t.Hour()
-			if hour == 0 {
-				hour = 12
-			} else if hour > 12 {
-				hour -= 12
-			}
-			result += fmt.Sprintf("%02d", hour)
+			result += fmt.Sprintf("%02d", (t.Hour()+11)%12+1)
 		case 'g': // Hour in 12h format without leading zeros (1-12)
-			hour := t.Hour()
-			if hour == 0 {
-				hour = 12
-			} else if hour > 12 {
-				hour -= 12
-			}
-			result += strconv.Itoa(hour)
+			result += strconv.Itoa((t.Hour()+11)%12 + 1)
 		case 'i': // Minutes with leading zeros (00-59)
 			result += fmt.Sprintf("%02d", t.Minute())
 		case 's': // Seconds with leading zeros (00-59)
 			result += fmt.Sprintf("%02d", t.Second())
+		case 'u': // Microseconds
+			result += fmt.Sprintf("%06d", t.Nanosecond()/1000)
+		case 'v': // Milliseconds
+			result += fmt.Sprintf("%03d", t.Nanosecond()/1000000)
 		case 'A': // AM/PM uppercase
 			if t.Hour() < 12 {
 				result += "AM"
@@ -930,23 +912,16 @@ func formatDateTime(format string, t time.Time) (string, error) {
 			} else {
 				result += "pm"
 			}
+		case 'B': // Swatch Internet time (000-999)
+			utc := t.UTC()
+			seconds := (utc.Hour()*3600 + utc.Minute()*60 + utc.Second() + 3600) % 86400
+			result += fmt.Sprintf("%03d", seconds*10/864)
 		case 'U': // Unix timestamp
 			result += strconv.FormatInt(t.Unix(), 10)
-		case 'w': // Day of week (0=Sunday, 6=Saturday)
-			result += strconv.Itoa(int(t.Weekday()))
-		case 'z': // Day of year (0-365)
-			result += strconv.Itoa(t.YearDay() - 1)
-		case 'W': // Week number (ISO-8601)
-			year, week := t.ISOWeek()
-			_ = year
-			result += fmt.Sprintf("%02d", week)
-		case 't': // Number of days in month
-			// Get last day of month
-			lastDay := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
-			result += strconv.Itoa(lastDay)
-		case 'L': // Leap year (1 if leap year, 0 otherwise)
-			year := t.Year()
-			if (year%4 == 0 && year%100 != 0) || year%400 == 0 {
+		case 'e': // Timezone identifier
+			result += t.Location().String()
+		case 'I': // Daylight saving time (1 if in effect, 0 otherwise)
+			if t.IsDST() {
 				result += "1"
 			} else {
 				result += "0"
@@ -954,9 +929,27 @@ func formatDateTime(format string, t time.Time) (string, error) {
 		case 'T': // Timezone abbreviation
 			result += t.Format("MST")
 		case 'O': // Difference to GMT in hours (+0200)
-			result += t.Format("-0700")
+			_, offset := t.Zone()
+			result += formatZoneOffset(offset, false)
 		case 'P': // Difference to GMT with colon (+02:00)
-			result += t.Format("-07:00")
+			_, offset := t.Zone()
+			result += formatZoneOffset(offset, true)
+		case 'p': // Like P, but Z for UTC
+			if _, offset := t.Zone(); offset == 0 {
+				result += "Z"
+			} else {
+				result += formatZoneOffset(offset, true)
+			}
+		case 'Z': // Timezone offset in seconds
+			_, offset := t.Zone()
+			result += strconv.Itoa(offset)
+		case 'c', 'r': // ISO 8601 and RFC 2822 dates
+			layout := `Y-m-d\TH:i:sP`
+			if char == 'r' {
+				layout = "D, d M Y H:i:s O"
+			}
+			formatted, _ := formatDateTime(layout, t)
+			result += formatted
 		case '\\': // Escape next character
 			if i+1 < len(format) {
 				i++
@@ -972,127 +965,30 @@ func formatDateTime(format string, t time.Time) (string, error) {
 	return result, nil
 }
 
-// parseTimeString parses various time string formats
-func parseTimeString(timeStr string, baseTime time.Time) (time.Time, error) {
-	timeStr = strings.TrimSpace(timeStr)
-
-	// Handle special keywords
-	switch strings.ToLower(timeStr) {
-	case "now":
-		return time.Now(), nil
-	case "today":
-		return time.Date(baseTime.Year(), baseTime.Month(), baseTime.Day(), 0, 0, 0, 0, baseTime.Location()), nil
-	case "tomorrow":
-		return baseTime.AddDate(0, 0, 1), nil
-	case "yesterday":
-		return baseTime.AddDate(0, 0, -1), nil
-	}
-
-	// Handle relative time strings
-	if strings.HasPrefix(timeStr, "+") || strings.HasPrefix(timeStr, "-") {
-		return parseRelativeTime(timeStr, baseTime)
-	}
-
-	// Handle "next" keywords
-	if strings.HasPrefix(strings.ToLower(timeStr), "next ") {
-		return parseNext(timeStr, baseTime)
-	}
-
-	// Handle ISO format dates
-	formats := []string{
-		"2006-01-02 15:04:05",
-		"2006-01-02T15:04:05Z",
-		"2006-01-02T15:04:05-07:00",
-		"2006-01-02",
-		"15:04:05",
-		"01/02/2006",
-		"01/02/2006 15:04:05",
-	}
-
-	for _, format := range formats {
-		if t, err := time.Parse(format, timeStr); err == nil {
-			return t, nil
-		}
-	}
-
-	return time.Time{}, fmt.Errorf("unable to parse time string: %s", timeStr)
-}
-
-// parseRelativeTime handles "+1 day", "-2 hours", etc.
-func parseRelativeTime(timeStr string, baseTime time.Time) (time.Time, error) {
-	// Simple regex for relative time
-	re := regexp.MustCompile(`([+-]?\d+)\s+(second|minute|hour|day|week|month|year)s?`)
-	matches := re.FindStringSubmatch(timeStr)
-
-	if len(matches) != 3 {
-		return time.Time{}, fmt.Errorf("invalid relative time format")
-	}
-
-	amount, err := strconv.Atoi(matches[1])
-	if err != nil {
-		return time.Time{}, err
+// ordinalSuffix returns the English ordinal suffix of day
+func ordinalSuffix(day int) string {
+	if day%100 >= 11 && day%100 <= 13 {
+		return "th"
 	}
-
-	unit := matches[2]
-
-	switch unit {
-	case "second":
-		return baseTime.Add(time.Duration(amount) * time.Second), nil
-	case "minute":
-		return baseTime.Add(time.Duration(amount) * time.Minute), nil
-	case "hour":
-		return baseTime.Add(time.Duration(amount) * time.Hour), nil
-	case "day":
-		return baseTime.AddDate(0, 0, amount), nil
-	case "week":
-		return baseTime.AddDate(0, 0, amount*7), nil
-	case "month":
-		return baseTime.AddDate(0, amount, 0), nil
-	case "year":
-		return baseTime.AddDate(amount, 0, 0), nil
+	switch day % 10 {
+	case 1:
+		return "st"
+	case 2:
+		return "nd"
+	case 3:
+		return "rd"
 	}
-
-	return time.Time{}, fmt.Errorf("unknown time unit: %s", unit)
+	return "th"
 }
 
-// parseNext handles "next Monday", "next week", etc.
-func parseNext(timeStr string, baseTime time.Time) (time.Time, error) {
-	parts := strings.Fields(strings.ToLower(timeStr))
-	if len(parts) < 2 || parts[0] != "next" {
-		return time.Time{}, fmt.Errorf("invalid next format")
-	}
-
-	target := parts[1]
-
-	weekdays := map[string]time.Weekday{
-		"sunday":    time.Sunday,
-		"monday":    time.Monday,
-		"tuesday":   time.Tuesday,
-		"wednesday": time.Wednesday,
-		"thursday":  time.Thursday,
-		"friday":    time.Friday,
-		"saturday":  time.Saturday,
-	}
-
-	if weekday, exists := weekdays[target]; exists {
-		// Find next occurrence of this weekday
-		days := int(weekday - baseTime.Weekday())
-		if days <= 0 {
-			days += 7
-		}
-		return baseTime.AddDate(0, 0, days), nil
-	}
-
-	switch target {
-	case "week":
-		return baseTime.AddDate(0, 0, 7), nil
-	case "month":
-		return baseTime.AddDate(0, 1, 0), nil
-	case "year":
-		return baseTime.AddDate(1, 0, 0), nil
+// parseTimeString parses a date string relative to baseTime, as
+// strtotime() does
+func parseTimeString(timeStr string, baseTime time.Time) (time.Time, error) {
+	t, _, errs := parseDateString(timeStr, baseTime)
+	if errs.failed() {
+		return time.Time{}, fmt.Errorf("unable to parse time string: %s", timeStr)
 	}
-
-	return time.Time{}, fmt.Errorf("unknown next target: %s", target)
+	return t, nil
 }
 
 // parseStrptimeString parses a date string according to strftime format
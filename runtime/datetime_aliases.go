@@ -0,0 +1,152 @@
+package runtime
+
+import (
+	"sync"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+var (
+	dateClassMethodsOnce sync.Once
+	dateClassMethods     map[string]*registry.Function
+)
+
+// dateClassMethod returns the builtin implementing a method of one of the
+// date classes, keyed as "Class::method"
+func dateClassMethod(className, method string) *registry.Function {
+	dateClassMethodsOnce.Do(func() {
+		dateClassMethods = make(map[string]*registry.Function)
+		for _, class := range GetDateTimeClasses() {
+			for name, m := range class.Methods {
+				if impl, ok := m.Implementation.(*BuiltinMethodImpl); ok {
+					dateClassMethods[class.Name+"::"+name] = impl.GetFunction()
+				}
+			}
+		}
+	})
+	return dateClassMethods[className+"::"+method]
+}
+
+// GetDateTimeClassFunctions returns the procedural aliases of the date
+// class methods, such as date_create() and date_format()
+func GetDateTimeClassFunctions() []*registry.Function {
+	functions := []*registry.Function{
+		dateCreateFunction("date_create", "DateTime"),
+		dateCreateFunction("date_create_immutable", "DateTimeImmutable"),
+		dateStaticAlias("date_create_from_format", "DateTime", "createFromFormat", 2, 3),
+		dateStaticAlias("date_create_immutable_from_format", "DateTimeImmutable", "createFromFormat", 2, 3),
+		dateMethodAlias("date_format", "DateTimeInterface", "format", 2, 2),
+		dateMethodAlias("date_modify", "DateTime", "modify", 2, 2),
+		dateMethodAlias("date_add", "DateTime", "add", 2, 2),
+		dateMethodAlias("date_sub", "DateTime", "sub", 2, 2),
+		dateMethodAlias("date_diff", "DateTimeInterface", "diff", 2, 3),
+		dateMethodAlias("date_timezone_get", "DateTimeInterface", "getTimezone", 1, 1),
+		dateMethodAlias("date_timezone_set", "DateTime", "setTimezone", 2, 2),
+		dateMethodAlias("date_offset_get", "DateTimeInterface", "getOffset", 1, 1),
+		dateMethodAlias("date_time_set", "DateTime", "setTime", 3, 5),
+		dateMethodAlias("date_date_set", "DateTime", "setDate", 4, 4),
+		dateMethodAlias("date_isodate_set", "DateTime", "setISODate", 3, 4),
+		dateMethodAlias("date_timestamp_get", "DateTimeInterface", "getTimestamp", 1, 1),
+		dateMethodAlias("date_timestamp_set", "DateTime", "setTimestamp", 2, 2),
+		dateStaticAlias("date_get_last_errors", "DateTime", "getLastErrors", 0, 0),
+		dateMethodAlias("timezone_name_get", "DateTimeZone", "getName", 1, 1),
+		dateMethodAlias("timezone_offset_get", "DateTimeZone", "getOffset", 2, 2),
+		dateMethodAlias("timezone_transitions_get", "DateTimeZone", "getTransitions", 1, 3),
+		dateMethodAlias("timezone_location_get", "DateTimeZone", "getLocation", 1, 1),
+		dateStaticAlias("timezone_identifiers_list", "DateTimeZone", "listIdentifiers", 0, 2),
+		dateStaticAlias("timezone_abbreviations_list", "DateTimeZone", "listAbbreviations", 0, 0),
+		dateStaticAlias("date_interval_create_from_date_string", "DateInterval", "createFromDateString", 1, 1),
+		dateMethodAlias("date_interval_format", "DateInterval", "format", 2, 2),
+	}
+
+	// timezone_open() returns false for an unknown timezone instead of
+	// throwing like the DateTimeZone constructor
+	functions = append(functions, &registry.Function{
+		Name:       "timezone_open",
+		Parameters: []*registry.Parameter{{Name: "timezone", Type: "string"}},
+		ReturnType: "DateTimeZone|false",
+		MinArgs:    1,
+		MaxArgs:    1,
+		IsBuiltin:  true,
+		Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			zone, ok := loadDateZone(dateArg(args, 0).ToString())
+			if !ok {
+				return values.NewBool(false), nil
+			}
+			return newDateTimeZoneObject(zone), nil
+		},
+	})
+	return functions
+}
+
+// dateCreateFunction builds date_create() or date_create_immutable(),
+// which return false for a string that does not parse
+func dateCreateFunction(name, className string) *registry.Function {
+	return &registry.Function{
+		Name: name,
+		Parameters: []*registry.Parameter{
+			{Name: "datetime", Type: "string", HasDefault: true, DefaultValue: values.NewString("now")},
+			{Name: "timezone", Type: "?DateTimeZone", HasDefault: true, DefaultValue: values.NewNull()},
+		},
+		ReturnType: className + "|false",
+		MinArgs:    0,
+		MaxArgs:    2,
+		IsBuiltin:  true,
+		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			t, zone, parseErr, err := createDate(ctx, name, args)
+			if err != nil {
+				return nil, err
+			}
+			if parseErr != nil {
+				return values.NewBool(false), nil
+			}
+			return newDateObject(className, t, zone), nil
+		},
+	}
+}
+
+// dateMethodAlias builds a function that calls a method on its first
+// argument, which must be an instance of className
+func dateMethodAlias(name, className, method string, minArgs, maxArgs int) *registry.Function {
+	return &registry.Function{
+		Name:       name,
+		Parameters: []*registry.Parameter{{Name: "object", Type: className}},
+		ReturnType: "mixed",
+		MinArgs:    minArgs,
+		MaxArgs:    maxArgs,
+		IsBuiltin:  true,
+		IsVariadic: true,
+		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			object := dateArg(args, 0)
+			if !isDateInstance(ctx, object, className) {
+				return throwDateTypeError(ctx, name, 1, "object", className, object)
+			}
+			target := className
+			if className == "DateTimeInterface" {
+				target = "DateTime"
+				if isDateInstance(ctx, object, "DateTimeImmutable") {
+					target = "DateTimeImmutable"
+				}
+			}
+			callArgs := append([]*values.Value{object}, args[1:]...)
+			return dateClassMethod(target, method).Builtin(ctx, callArgs)
+		},
+	}
+}
+
+// dateStaticAlias builds a function that calls a static method
+func dateStaticAlias(name, className, method string, minArgs, maxArgs int) *registry.Function {
+	return &registry.Function{
+		Name:       name,
+		Parameters: []*registry.Parameter{},
+		ReturnType: "mixed",
+		MinArgs:    minArgs,
+		MaxArgs:    maxArgs,
+		IsBuiltin:  true,
+		IsVariadic: true,
+		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			return dateClassMethod(className, method).Builtin(ctx, args)
+		},
+	}
+}
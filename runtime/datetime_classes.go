@@ -0,0 +1,808 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// DateTime, DateTimeImmutable, DateTimeZone, DateInterval and DatePeriod
+// keep their state in the properties PHP shows for them: the date,
+// timezone_type and timezone of a date, the fields of an interval and the
+// start, end, interval and options of a period. Methods read the state
+// back from the properties, so clone, serialize and var_dump need nothing
+// special.
+
+// DateTimeZone group constants, for listIdentifiers()
+const (
+	dateTimeZoneUTC        = 1024
+	dateTimeZoneAll        = 2047
+	dateTimeZoneAllWithBC  = 4095
+	dateTimeZonePerCountry = 4096
+)
+
+// dateFormatConstants are the formats of the DateTimeInterface constants,
+// which are also the DATE_* constants
+var dateFormatConstants = []struct {
+	name   string
+	format string
+}{
+	{"ATOM", `Y-m-d\TH:i:sP`},
+	{"COOKIE", "l, d-M-Y H:i:s T"},
+	{"ISO8601", `Y-m-d\TH:i:sO`},
+	{"RFC822", "D, d M y H:i:s O"},
+	{"RFC850", "l, d-M-y H:i:s T"},
+	{"RFC1036", "D, d M y H:i:s O"},
+	{"RFC1123", "D, d M Y H:i:s O"},
+	{"RFC7231", `D, d M Y H:i:s \G\M\T`},
+	{"RFC2822", "D, d M Y H:i:s O"},
+	{"RFC3339", `Y-m-d\TH:i:sP`},
+	{"RFC3339_EXTENDED", `Y-m-d\TH:i:s.vP`},
+	{"RSS", "D, d M Y H:i:s O"},
+	{"W3C", `Y-m-d\TH:i:sP`},
+}
+
+// GetDateTimeConstants returns the DATE_* format constants
+func GetDateTimeConstants() []*registry.ConstantDescriptor {
+	constants := make([]*registry.ConstantDescriptor, 0, len(dateFormatConstants))
+	for _, c := range dateFormatConstants {
+		constants = append(constants, &registry.ConstantDescriptor{
+			Name:  "DATE_" + c.name,
+			Value: values.NewString(c.format),
+		})
+	}
+	return constants
+}
+
+// GetDateTimeInterfaces returns the DateTimeInterface interface
+func GetDateTimeInterfaces() []*registry.Interface {
+	methods := map[string]*registry.InterfaceMethod{}
+	for _, name := range []string{"format", "getTimezone", "getOffset", "getTimestamp", "diff", "__wakeup"} {
+		methods[name] = &registry.InterfaceMethod{Name: name, Visibility: "public", Parameters: []*registry.Parameter{}}
+	}
+	constants := make(map[string]*registry.ConstantDescriptor, len(dateFormatConstants))
+	for _, c := range dateFormatConstants {
+		constants[c.name] = &registry.ConstantDescriptor{Name: c.name, Value: values.NewString(c.format), Visibility: "public"}
+	}
+	return []*registry.Interface{{
+		Name:      "DateTimeInterface",
+		Methods:   methods,
+		Extends:   []string{},
+		Constants: constants,
+	}}
+}
+
+// GetDateTimeClasses returns the DateTime, DateTimeImmutable, DateTimeZone,
+// DateInterval and DatePeriod class descriptors
+func GetDateTimeClasses() []*registry.ClassDescriptor {
+	return []*registry.ClassDescriptor{
+		getDateTimeClass("DateTime", false),
+		getDateTimeClass("DateTimeImmutable", true),
+		getDateTimeZoneClass(),
+		getDateIntervalClass(),
+		getDatePeriodClass(),
+	}
+}
+
+type dateMethodFunc func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error)
+
+// dateChange computes the new time and timezone of a date object for a
+// method such as modify() or setTimezone(). It returns false in ok for a
+// method that fails without throwing.
+type dateChange func(ctx registry.BuiltinCallContext, t time.Time, zone *dateZone, args []*values.Value) (result time.Time, newZone *dateZone, ok bool, err error)
+
+func getDateTimeClass(className string, immutable bool) *registry.ClassDescriptor {
+	// change turns a dateChange into a method, which updates the object for
+	// DateTime and returns an updated copy for DateTimeImmutable
+	change := func(fn dateChange) dateMethodFunc {
+		return func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			t, zone, err := dateStateOf(ctx, this, className)
+			if err != nil {
+				return nil, err
+			}
+			t, zone, ok, err := fn(ctx, t, zone, args)
+			if err != nil || !ok {
+				return values.NewBool(false), err
+			}
+			target := this
+			if immutable {
+				target = copyObject(this)
+			}
+			setDateState(target.Data.(*values.Object), t, zone)
+			return target, nil
+		}
+	}
+	// fromInterface creates a date of this class from another date
+	fromInterface := func(method, expected string) dateMethodFunc {
+		return func(ctx registry.BuiltinCallContext, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			source := dateArg(args, 0)
+			if !isDateInstance(ctx, source, expected) {
+				return throwDateTypeError(ctx, className+"::"+method, 1, "object", expected, source)
+			}
+			t, zone, err := dateStateOf(ctx, source, expected)
+			if err != nil {
+				return nil, err
+			}
+			return newDateObject(className, t, zone), nil
+		}
+	}
+
+	methods := map[string]dateMethodFunc{
+		"__construct": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			t, zone, parseErr, err := createDate(ctx, className, args)
+			if err != nil {
+				return nil, err
+			}
+			if parseErr != nil {
+				return throwDateError(ctx, "Exception", "%s::__construct(): Failed to parse time string (%s) at position %d (%s): %s",
+					className, dateArg(args, 0).ToString(), parseErr.pos, parseErrorChar(dateArg(args, 0).ToString(), parseErr.pos), parseErr.msg)
+			}
+			setDateState(this.Data.(*values.Object), t, zone)
+			return values.NewNull(), nil
+		},
+		"__wakeup": func(ctx registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			if _, _, err := dateStateOf(ctx, this, className); err != nil {
+				return nil, err
+			}
+			return values.NewNull(), nil
+		},
+		"__serialize": func(ctx registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return objectPropertiesArray(this), nil
+		},
+		"__unserialize": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			t, zone, ok := dateStateFromArray(dateArg(args, 0))
+			if !ok {
+				return throwDateError(ctx, "Error", "Invalid serialization data for %s object", className)
+			}
+			setDateState(this.Data.(*values.Object), t, zone)
+			return values.NewNull(), nil
+		},
+		"format": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			t, _, err := dateStateOf(ctx, this, className)
+			if err != nil {
+				return nil, err
+			}
+			formatted, err := formatDateTime(dateArg(args, 0).ToString(), t)
+			if err != nil {
+				return nil, err
+			}
+			return values.NewString(formatted), nil
+		},
+		"modify": change(func(_ registry.BuiltinCallContext, t time.Time, zone *dateZone, args []*values.Value) (time.Time, *dateZone, bool, error) {
+			result, zone, errs := modifyDateString(dateArg(args, 0).ToString(), t, zone)
+			setDateLastErrorList(errs)
+			return result, zone, !errs.failed(), nil
+		}),
+		"add": change(func(ctx registry.BuiltinCallContext, t time.Time, zone *dateZone, args []*values.Value) (time.Time, *dateZone, bool, error) {
+			result, err := addDateInterval(ctx, className+"::add", t, dateArg(args, 0), 1)
+			return result, zone, err == nil, err
+		}),
+		"sub": change(func(ctx registry.BuiltinCallContext, t time.Time, zone *dateZone, args []*values.Value) (time.Time, *dateZone, bool, error) {
+			result, err := addDateInterval(ctx, className+"::sub", t, dateArg(args, 0), -1)
+			return result, zone, err == nil, err
+		}),
+		"getTimezone": func(ctx registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			_, zone, err := dateStateOf(ctx, this, className)
+			if err != nil {
+				return nil, err
+			}
+			return newDateTimeZoneObject(zone), nil
+		},
+		"setTimezone": change(func(ctx registry.BuiltinCallContext, t time.Time, _ *dateZone, args []*values.Value) (time.Time, *dateZone, bool, error) {
+			zone, err := dateTimeZoneArg(ctx, className+"::setTimezone", args, 0)
+			return t, zone, err == nil, err
+		}),
+		"getOffset": func(ctx registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			t, _, err := dateStateOf(ctx, this, className)
+			if err != nil {
+				return nil, err
+			}
+			_, offset := t.Zone()
+			return values.NewInt(int64(offset)), nil
+		},
+		"setTime": change(func(_ registry.BuiltinCallContext, t time.Time, zone *dateZone, args []*values.Value) (time.Time, *dateZone, bool, error) {
+			hour, minute := int(dateArg(args, 0).ToInt()), int(dateArg(args, 1).ToInt())
+			second, micro := int(dateArg(args, 2).ToInt()), int(dateArg(args, 3).ToInt())
+			result := time.Date(t.Year(), t.Month(), t.Day(), hour, minute, second, micro*1000, t.Location())
+			return result, zone, true, nil
+		}),
+		"setDate": change(func(_ registry.BuiltinCallContext, t time.Time, zone *dateZone, args []*values.Value) (time.Time, *dateZone, bool, error) {
+			year, month, day := int(dateArg(args, 0).ToInt()), time.Month(dateArg(args, 1).ToInt()), int(dateArg(args, 2).ToInt())
+			result := time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+			return result, zone, true, nil
+		}),
+		"setISODate": change(func(_ registry.BuiltinCallContext, t time.Time, zone *dateZone, args []*values.Value) (time.Time, *dateZone, bool, error) {
+			dayOfWeek := 1
+			if len(args) > 2 {
+				dayOfWeek = int(dateArg(args, 2).ToInt())
+			}
+			return isoWeekDate(t, int(dateArg(args, 0).ToInt()), int(dateArg(args, 1).ToInt()), dayOfWeek), zone, true, nil
+		}),
+		"setTimestamp": change(func(_ registry.BuiltinCallContext, t time.Time, zone *dateZone, args []*values.Value) (time.Time, *dateZone, bool, error) {
+			return time.Unix(dateArg(args, 0).ToInt(), 0).In(t.Location()), zone, true, nil
+		}),
+		"getTimestamp": func(ctx registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			t, _, err := dateStateOf(ctx, this, className)
+			if err != nil {
+				return nil, err
+			}
+			return values.NewInt(t.Unix()), nil
+		},
+		"diff": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			t, zone, err := dateStateOf(ctx, this, className)
+			if err != nil {
+				return nil, err
+			}
+			target := dateArg(args, 0)
+			if !isDateInstance(ctx, target, "DateTimeInterface") {
+				return throwDateTypeError(ctx, className+"::diff", 1, "targetObject", "DateTimeInterface", target)
+			}
+			other, otherZone, err := dateStateOf(ctx, target, "DateTimeInterface")
+			if err != nil {
+				return nil, err
+			}
+			interval := diffDates(t, zone, other, otherZone)
+			if dateArg(args, 1).ToBool() {
+				interval.Invert = false
+			}
+			return newDateIntervalObject(interval), nil
+		},
+	}
+
+	staticMethods := map[string]dateMethodFunc{
+		"__set_state": func(ctx registry.BuiltinCallContext, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			t, zone, ok := dateStateFromArray(dateArg(args, 0))
+			if !ok {
+				return throwDateError(ctx, "Error", "Invalid serialization data for %s object", className)
+			}
+			return newDateObject(className, t, zone), nil
+		},
+		"createFromFormat": func(ctx registry.BuiltinCallContext, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			zone := defaultDateZone()
+			if !dateArg(args, 2).IsNull() {
+				var err error
+				if zone, err = dateTimeZoneArg(ctx, className+"::createFromFormat", args, 2); err != nil {
+					return nil, err
+				}
+			}
+			t, parsedZone, errs := parseDateFormat(dateArg(args, 0).ToString(), dateArg(args, 1).ToString(), time.Now().In(zone.loc))
+			setDateLastErrorList(errs)
+			if errs.failed() {
+				return values.NewBool(false), nil
+			}
+			if parsedZone != nil {
+				zone = parsedZone
+			}
+			return newDateObject(className, t, zone), nil
+		},
+		"createFromInterface": fromInterface("createFromInterface", "DateTimeInterface"),
+		"getLastErrors": func(_ registry.BuiltinCallContext, _ *values.Value, _ []*values.Value) (*values.Value, error) {
+			return dateLastErrorsValue(), nil
+		},
+	}
+	if immutable {
+		staticMethods["createFromMutable"] = fromInterface("createFromMutable", "DateTime")
+	} else {
+		staticMethods["createFromImmutable"] = fromInterface("createFromImmutable", "DateTimeImmutable")
+	}
+
+	constants := make(map[string]*registry.ConstantDescriptor, len(dateFormatConstants))
+	for _, c := range dateFormatConstants {
+		constants[c.name] = &registry.ConstantDescriptor{Name: c.name, Value: values.NewString(c.format), Visibility: "public"}
+	}
+	return dateClass(className, []string{"DateTimeInterface"}, methods, staticMethods, constants)
+}
+
+func getDateTimeZoneClass() *registry.ClassDescriptor {
+	// zoneOf reads the timezone of a DateTimeZone object
+	zoneOf := func(ctx registry.BuiltinCallContext, this *values.Value) (*dateZone, error) {
+		zone, ok := dateZoneFromProperties(this.Data.(*values.Object).Properties)
+		if !ok {
+			_, err := throwDateError(ctx, "Error", "The DateTimeZone object has not been correctly initialized by its constructor")
+			return nil, err
+		}
+		return zone, nil
+	}
+
+	methods := map[string]dateMethodFunc{
+		"__construct": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			name := dateArg(args, 0).ToString()
+			zone, ok := loadDateZone(name)
+			if !ok {
+				return throwDateError(ctx, "Exception", "DateTimeZone::__construct(): Unknown or bad timezone (%s)", name)
+			}
+			setDateZoneState(this.Data.(*values.Object), zone)
+			return values.NewNull(), nil
+		},
+		"__wakeup": func(ctx registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			_, err := zoneOf(ctx, this)
+			return values.NewNull(), err
+		},
+		"__serialize": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return objectPropertiesArray(this), nil
+		},
+		"__unserialize": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			zone, ok := dateZoneFromArray(dateArg(args, 0))
+			if !ok {
+				return throwDateError(ctx, "Error", "Invalid serialization data for DateTimeZone object")
+			}
+			setDateZoneState(this.Data.(*values.Object), zone)
+			return values.NewNull(), nil
+		},
+		"getName": func(ctx registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			zone, err := zoneOf(ctx, this)
+			if err != nil {
+				return nil, err
+			}
+			return values.NewString(zone.name), nil
+		},
+		"getOffset": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			zone, err := zoneOf(ctx, this)
+			if err != nil {
+				return nil, err
+			}
+			target := dateArg(args, 0)
+			if !isDateInstance(ctx, target, "DateTimeInterface") {
+				return throwDateTypeError(ctx, "DateTimeZone::getOffset", 1, "datetime", "DateTimeInterface", target)
+			}
+			t, _, err := dateStateOf(ctx, target, "DateTimeInterface")
+			if err != nil {
+				return nil, err
+			}
+			_, offset := t.In(zone.loc).Zone()
+			return values.NewInt(int64(offset)), nil
+		},
+		"getTransitions": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			zone, err := zoneOf(ctx, this)
+			if err != nil {
+				return nil, err
+			}
+			if zone.kind != zoneTypeID {
+				return values.NewBool(false), nil
+			}
+			begin, end := int64(-1<<63), int64(1<<63-1)
+			if len(args) > 0 {
+				begin = dateArg(args, 0).ToInt()
+			}
+			if len(args) > 1 {
+				end = dateArg(args, 1).ToInt()
+			}
+			result := values.NewArray()
+			for _, transition := range zoneTransitions(zone.loc, begin, end) {
+				year, month, day, hour, minute, second := civilFromUnix(transition.ts)
+				entry := values.NewArray()
+				entry.ArraySet(values.NewString("ts"), values.NewInt(transition.ts))
+				entry.ArraySet(values.NewString("time"), values.NewString(fmt.Sprintf("%s-%02d-%02dT%02d:%02d:%02d+0000",
+					formatDateYear(year), month, day, hour, minute, second)))
+				entry.ArraySet(values.NewString("offset"), values.NewInt(int64(transition.offset)))
+				entry.ArraySet(values.NewString("isdst"), values.NewBool(transition.dst))
+				entry.ArraySet(values.NewString("abbr"), values.NewString(transition.abbr))
+				result.ArraySet(nil, entry)
+			}
+			return result, nil
+		},
+		"getLocation": func(ctx registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			zone, err := zoneOf(ctx, this)
+			if err != nil {
+				return nil, err
+			}
+			if zone.kind != zoneTypeID {
+				return values.NewBool(false), nil
+			}
+			location := &timezoneLocation{country: "??"}
+			if found := findTimezoneLocation(zone.name); found != nil {
+				location = found
+			}
+			result := values.NewArray()
+			result.ArraySet(values.NewString("country_code"), values.NewString(location.country))
+			result.ArraySet(values.NewString("latitude"), values.NewFloat(location.latitude))
+			result.ArraySet(values.NewString("longitude"), values.NewFloat(location.longitude))
+			result.ArraySet(values.NewString("comments"), values.NewString(location.comments))
+			return result, nil
+		},
+	}
+
+	staticMethods := map[string]dateMethodFunc{
+		"__set_state": func(ctx registry.BuiltinCallContext, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			zone, ok := dateZoneFromArray(dateArg(args, 0))
+			if !ok {
+				return throwDateError(ctx, "Error", "Timezone initialization failed")
+			}
+			return newDateTimeZoneObject(zone), nil
+		},
+		"listAbbreviations": func(_ registry.BuiltinCallContext, _ *values.Value, _ []*values.Value) (*values.Value, error) {
+			return timezoneAbbreviationsList(), nil
+		},
+		"listIdentifiers": func(ctx registry.BuiltinCallContext, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			return timezoneIdentifiersList(ctx, "DateTimeZone::listIdentifiers", args)
+		},
+	}
+
+	constants := map[string]*registry.ConstantDescriptor{}
+	groups := []string{"AFRICA", "AMERICA", "ANTARCTICA", "ARCTIC", "ASIA", "ATLANTIC", "AUSTRALIA", "EUROPE", "INDIAN", "PACIFIC", "UTC"}
+	for bit, name := range groups {
+		constants[name] = &registry.ConstantDescriptor{Name: name, Value: values.NewInt(1 << bit), Visibility: "public"}
+	}
+	constants["ALL"] = &registry.ConstantDescriptor{Name: "ALL", Value: values.NewInt(dateTimeZoneAll), Visibility: "public"}
+	constants["ALL_WITH_BC"] = &registry.ConstantDescriptor{Name: "ALL_WITH_BC", Value: values.NewInt(dateTimeZoneAllWithBC), Visibility: "public"}
+	constants["PER_COUNTRY"] = &registry.ConstantDescriptor{Name: "PER_COUNTRY", Value: values.NewInt(dateTimeZonePerCountry), Visibility: "public"}
+	return dateClass("DateTimeZone", nil, methods, staticMethods, constants)
+}
+
+// createDate works out the time and timezone of a new date from the
+// constructor arguments. A string that does not parse is returned as a
+// parse error rather than thrown, for date_create().
+func createDate(ctx registry.BuiltinCallContext, className string, args []*values.Value) (time.Time, *dateZone, *dateParseError, error) {
+	zone := defaultDateZone()
+	if !dateArg(args, 1).IsNull() {
+		var err error
+		if zone, err = dateTimeZoneArg(ctx, className+"::__construct", args, 1); err != nil {
+			return time.Time{}, nil, nil, err
+		}
+	}
+	input := "now"
+	if !dateArg(args, 0).IsNull() && dateArg(args, 0).ToString() != "" {
+		input = dateArg(args, 0).ToString()
+	}
+	t, parsedZone, errs := parseDateString(input, time.Now().In(zone.loc))
+	setDateLastErrorList(errs)
+	if errs.failed() {
+		return t, zone, errs.errors[0], nil
+	}
+	if parsedZone != nil {
+		zone = parsedZone
+	}
+	return t, zone, nil, nil
+}
+
+// dateStateOf reads the time and timezone of a DateTime or
+// DateTimeImmutable object, throwing for an object whose constructor did
+// not run
+func dateStateOf(ctx registry.BuiltinCallContext, this *values.Value, className string) (time.Time, *dateZone, error) {
+	if this != nil && this.IsObject() {
+		if t, zone, ok := dateStateFromProperties(this.Data.(*values.Object).Properties); ok {
+			return t, zone, nil
+		}
+	}
+	if className == "DateTimeInterface" && this != nil && this.IsObject() {
+		className = this.Data.(*values.Object).ClassName
+	}
+	_, err := throwDateError(ctx, "Error", "The %s object has not been correctly initialized by its constructor", className)
+	return time.Time{}, nil, err
+}
+
+// CompareDateObjects compares two DateTimeInterface objects by the instant
+// they represent, whatever their timezones. ok is false unless both values
+// are initialised date objects.
+func CompareDateObjects(ctx registry.BuiltinCallContext, a, b *values.Value) (result int, ok bool) {
+	if !isDateInstance(ctx, a, "DateTimeInterface") || !isDateInstance(ctx, b, "DateTimeInterface") {
+		return 0, false
+	}
+	ta, _, okA := dateStateFromProperties(a.Data.(*values.Object).Properties)
+	tb, _, okB := dateStateFromProperties(b.Data.(*values.Object).Properties)
+	if !okA || !okB {
+		return 0, false
+	}
+	return ta.Compare(tb), true
+}
+
+func dateStateFromProperties(props map[string]*values.Value) (time.Time, *dateZone, bool) {
+	date, ok := props["date"]
+	if !ok || date == nil {
+		return time.Time{}, nil, false
+	}
+	zone, ok := dateZoneFromProperties(props)
+	if !ok {
+		return time.Time{}, nil, false
+	}
+	t, ok := parseDateProperty(date.ToString(), zone.loc)
+	return t, zone, ok
+}
+
+// dateStateFromArray reads a date from the array given to __set_state()
+// or __unserialize()
+func dateStateFromArray(arr *values.Value) (time.Time, *dateZone, bool) {
+	if !arr.IsArray() {
+		return time.Time{}, nil, false
+	}
+	props := make(map[string]*values.Value)
+	for key, val := range arr.Data.(*values.Array).All() {
+		if name, ok := key.(string); ok {
+			props[name] = val
+		}
+	}
+	return dateStateFromProperties(props)
+}
+
+// parseDateProperty reads the date property, formatted as Y-m-d H:i:s.u
+func parseDateProperty(s string, loc *time.Location) (time.Time, bool) {
+	sign := 1
+	if strings.HasPrefix(s, "-") {
+		sign, s = -1, s[1:]
+	}
+	var year, month, day, hour, minute, second, micro int
+	if _, err := fmt.Sscanf(s, "%d-%d-%d %d:%d:%d.%d", &year, &month, &day, &hour, &minute, &second, &micro); err != nil {
+		micro = 0
+		if _, err := fmt.Sscanf(s, "%d-%d-%d %d:%d:%d", &year, &month, &day, &hour, &minute, &second); err != nil {
+			return time.Time{}, false
+		}
+	}
+	return time.Date(sign*year, time.Month(month), day, hour, minute, second, micro*1000, loc), true
+}
+
+// setDateState stores the time and timezone of a date object in its
+// properties
+func setDateState(obj *values.Object, t time.Time, zone *dateZone) {
+	t = t.In(zone.loc)
+	date, _ := formatDateTime("Y-m-d H:i:s.u", t)
+	obj.Properties["date"] = values.NewString(date)
+	setDateZoneState(obj, zone)
+}
+
+func setDateZoneState(obj *values.Object, zone *dateZone) {
+	obj.Properties["timezone_type"] = values.NewInt(int64(zone.kind))
+	obj.Properties["timezone"] = values.NewString(zone.name)
+}
+
+func dateZoneFromProperties(props map[string]*values.Value) (*dateZone, bool) {
+	kind, ok := props["timezone_type"]
+	if !ok || kind == nil {
+		return nil, false
+	}
+	name, ok := props["timezone"]
+	if !ok || name == nil {
+		return nil, false
+	}
+	switch kind.ToInt() {
+	case zoneTypeOffset:
+		if offset, ok := parseZoneOffset(name.ToString()); ok {
+			return offsetDateZone(offset), true
+		}
+	case zoneTypeAbbr:
+		if abbr := lookupZoneAbbreviation(name.ToString()); abbr != nil {
+			return abbreviationDateZone(abbr), true
+		}
+	case zoneTypeID:
+		if zone, ok := loadDateZone(name.ToString()); ok && zone.kind == zoneTypeID {
+			return zone, true
+		}
+	}
+	return nil, false
+}
+
+func dateZoneFromArray(arr *values.Value) (*dateZone, bool) {
+	if !arr.IsArray() {
+		return nil, false
+	}
+	props := make(map[string]*values.Value)
+	for key, val := range arr.Data.(*values.Array).All() {
+		if name, ok := key.(string); ok {
+			props[name] = val
+		}
+	}
+	return dateZoneFromProperties(props)
+}
+
+// newDateObject returns a new object of className holding a date
+func newDateObject(className string, t time.Time, zone *dateZone) *values.Value {
+	obj := values.NewObject(className)
+	setDateState(obj.Data.(*values.Object), t, zone)
+	return obj
+}
+
+func newDateTimeZoneObject(zone *dateZone) *values.Value {
+	obj := values.NewObject("DateTimeZone")
+	setDateZoneState(obj.Data.(*values.Object), zone)
+	return obj
+}
+
+// copyObject returns a shallow copy of an object, as clone makes it
+func copyObject(v *values.Value) *values.Value {
+	src := v.Data.(*values.Object)
+	dup := values.NewObject(src.ClassName)
+	for name, prop := range src.Properties {
+		dup.Data.(*values.Object).Properties[name] = prop
+	}
+	return dup
+}
+
+// objectPropertiesArray returns the properties of an object as an array,
+// for __serialize()
+func objectPropertiesArray(v *values.Value) *values.Value {
+	result := values.NewArray()
+	obj := v.Data.(*values.Object)
+	for _, name := range sortedPropertyNames(obj) {
+		result.ArraySet(values.NewString(name), obj.Properties[name])
+	}
+	return result
+}
+
+func sortedPropertyNames(obj *values.Object) []string {
+	names := make([]string, 0, len(obj.Properties))
+	for name := range obj.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isoWeekDate returns the day of an ISO-8601 week, keeping the time of day
+// of t. Out of range weeks and days carry over to the next ones.
+func isoWeekDate(t time.Time, year, week, dayOfWeek int) time.Time {
+	jan4 := time.Date(year, time.January, 4, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	monday := jan4.AddDate(0, 0, -((int(jan4.Weekday()) + 6) % 7))
+	return monday.AddDate(0, 0, (week-1)*7+dayOfWeek-1)
+}
+
+// formatDateYear formats a year with at least 4 digits, as the Y format
+// character does
+func formatDateYear(year int64) string {
+	if year < 0 {
+		return fmt.Sprintf("-%04d", -year)
+	}
+	return fmt.Sprintf("%04d", year)
+}
+
+// dateTimeZoneArg reads a DateTimeZone argument, throwing a TypeError for
+// anything else
+func dateTimeZoneArg(ctx registry.BuiltinCallContext, function string, args []*values.Value, i int) (*dateZone, error) {
+	arg := dateArg(args, i)
+	if !isDateInstance(ctx, arg, "DateTimeZone") {
+		_, err := throwDateTypeError(ctx, function, i+1, "timezone", "DateTimeZone", arg)
+		return nil, err
+	}
+	zone, ok := dateZoneFromProperties(arg.Data.(*values.Object).Properties)
+	if !ok {
+		_, err := throwDateError(ctx, "Error", "The DateTimeZone object has not been correctly initialized by its constructor")
+		return nil, err
+	}
+	return zone, nil
+}
+
+// timezoneIdentifiersList implements DateTimeZone::listIdentifiers() and
+// timezone_identifiers_list()
+func timezoneIdentifiersList(ctx registry.BuiltinCallContext, function string, args []*values.Value) (*values.Value, error) {
+	group := int64(dateTimeZoneAll)
+	if len(args) > 0 && !dateArg(args, 0).IsNull() {
+		group = dateArg(args, 0).ToInt()
+	}
+	country := ""
+	if len(args) > 1 && !dateArg(args, 1).IsNull() {
+		country = dateArg(args, 1).ToString()
+	}
+	if group == dateTimeZonePerCountry && len(country) != 2 {
+		return throwDateError(ctx, "ValueError", "%s(): Argument #2 ($countryCode) must be a two-letter ISO 3166-1 compatible country code when argument #1 ($timezoneGroup) is DateTimeZone::PER_COUNTRY", function)
+	}
+	if group < 0 || (group > dateTimeZoneAllWithBC && group != dateTimeZonePerCountry) {
+		return throwDateError(ctx, "ValueError", "%s(): Argument #1 ($timezoneGroup) must be one of the DateTimeZone group constants", function)
+	}
+	result := values.NewArray()
+	for _, id := range timezoneIdentifiers(group, strings.ToUpper(country)) {
+		result.ArraySet(nil, values.NewString(id))
+	}
+	return result, nil
+}
+
+// timezoneAbbreviationsList implements DateTimeZone::listAbbreviations()
+func timezoneAbbreviationsList() *values.Value {
+	result := values.NewArray()
+	for _, abbr := range timezoneAbbreviations {
+		entry := values.NewArray()
+		entry.ArraySet(values.NewString("dst"), values.NewBool(abbr.dst))
+		entry.ArraySet(values.NewString("offset"), values.NewInt(int64(abbr.offset)))
+		entry.ArraySet(values.NewString("timezone_id"), values.NewString(abbr.id))
+		list := result.ArrayGet(values.NewString(abbr.abbr))
+		if list == nil || list.IsNull() {
+			list = values.NewArray()
+			result.ArraySet(values.NewString(abbr.abbr), list)
+		}
+		list.ArraySet(nil, entry)
+	}
+	return result
+}
+
+func dateClass(name string, interfaces []string, methods, staticMethods map[string]dateMethodFunc, constants map[string]*registry.ConstantDescriptor) *registry.ClassDescriptor {
+	if constants == nil {
+		constants = make(map[string]*registry.ConstantDescriptor)
+	}
+	desc := &registry.ClassDescriptor{
+		Name:       name,
+		Interfaces: interfaces,
+		Properties: make(map[string]*registry.PropertyDescriptor),
+		Methods:    make(map[string]*registry.MethodDescriptor),
+		Constants:  constants,
+	}
+	for methodName, impl := range methods {
+		desc.Methods[methodName] = dateMethod(name, methodName, impl, false)
+	}
+	for methodName, impl := range staticMethods {
+		desc.Methods[methodName] = dateMethod(name, methodName, impl, true)
+	}
+	return desc
+}
+
+func dateMethod(className, name string, impl dateMethodFunc, static bool) *registry.MethodDescriptor {
+	fn := &registry.Function{
+		Name:       name,
+		IsBuiltin:  true,
+		IsStatic:   static,
+		IsVariadic: true,
+		Visibility: "public",
+		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			if static {
+				return impl(ctx, nil, args)
+			}
+			if len(args) == 0 || args[0] == nil || !args[0].IsObject() {
+				return nil, fmt.Errorf("%s::%s() called on non-object", className, name)
+			}
+			return impl(ctx, args[0], args[1:])
+		},
+	}
+	return &registry.MethodDescriptor{
+		Name:           name,
+		Visibility:     "public",
+		IsStatic:       static,
+		IsVariadic:     true,
+		Parameters:     []*registry.ParameterDescriptor{},
+		Implementation: NewBuiltinMethodImpl(fn),
+	}
+}
+
+// isDateInstance reports whether v is an object of className, a subclass
+// or an implementation of it
+func isDateInstance(ctx registry.BuiltinCallContext, v *values.Value, className string) bool {
+	if v == nil || !v.IsObject() {
+		return false
+	}
+	objClass := v.Data.(*values.Object).ClassName
+	if strings.EqualFold(objClass, className) {
+		return true
+	}
+	if className == "DateTimeInterface" && (strings.EqualFold(objClass, "DateTime") || strings.EqualFold(objClass, "DateTimeImmutable")) {
+		return true
+	}
+	return ctx != nil && reflectionIsA(ctx, objClass, className)
+}
+
+func dateArg(args []*values.Value, i int) *values.Value {
+	if i >= len(args) || args[i] == nil {
+		return values.NewNull()
+	}
+	if args[i].IsReference() {
+		return args[i].Deref()
+	}
+	return args[i]
+}
+
+// parseErrorChar returns the character of input at pos for an error
+// message, or an empty string past its end
+func parseErrorChar(input string, pos int) string {
+	if pos < len(input) {
+		return input[pos : pos+1]
+	}
+	return ""
+}
+
+func throwDateTypeError(ctx registry.BuiltinCallContext, function string, position int, param, expected string, given *values.Value) (*values.Value, error) {
+	givenType := given.TypeName()
+	if given.IsObject() {
+		givenType = given.Data.(*values.Object).ClassName
+	}
+	return throwDateError(ctx, "TypeError", "%s(): Argument #%d ($%s) must be of type %s, %s given", function, position, param, expected, givenType)
+}
+
+func throwDateError(ctx registry.BuiltinCallContext, className, format string, args ...interface{}) (*values.Value, error) {
+	message := fmt.Sprintf(format, args...)
+	if ctx == nil {
+		return nil, fmt.Errorf("%s: %s", className, message)
+	}
+	exception := CreateException(ctx, className, message)
+	if exception == nil {
+		return nil, fmt.Errorf("%s: %s", className, message)
+	}
+	return nil, ctx.ThrowException(exception)
+}
@@ -0,0 +1,465 @@
+package runtime
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wudi/hey/values"
+)
+
+// dateParseError is a problem found while parsing a date string, at the
+// byte offset where it was found
+type dateParseError struct {
+	pos int
+	msg string
+}
+
+func (e *dateParseError) Error() string {
+	return e.msg
+}
+
+// dateErrors are the warnings and errors of parsing a date string, as
+// date_parse() and DateTime::getLastErrors() report them
+type dateErrors struct {
+	warnings []*dateParseError
+	errors   []*dateParseError
+}
+
+func (e *dateErrors) warn(pos int, msg string) {
+	e.warnings = append(e.warnings, &dateParseError{pos: pos, msg: msg})
+}
+
+func (e *dateErrors) fail(pos int, msg string) {
+	e.errors = append(e.errors, &dateParseError{pos: pos, msg: msg})
+}
+
+func (e *dateErrors) failed() bool {
+	return e != nil && len(e.errors) > 0
+}
+
+func (e *dateErrors) empty() bool {
+	return e == nil || len(e.warnings)+len(e.errors) == 0
+}
+
+// addTo sets the warning_count, warnings, error_count and errors entries of
+// result. Messages are keyed by position, so a later one at the same
+// position replaces an earlier one but still counts.
+func (e *dateErrors) addTo(result *values.Value) {
+	list := func(problems []*dateParseError) *values.Value {
+		arr := values.NewArray()
+		for _, problem := range problems {
+			arr.ArraySet(values.NewInt(int64(problem.pos)), values.NewString(problem.msg))
+		}
+		return arr
+	}
+	var warnings, errors []*dateParseError
+	if e != nil {
+		warnings, errors = e.warnings, e.errors
+	}
+	result.ArraySet(values.NewString("warning_count"), values.NewInt(int64(len(warnings))))
+	result.ArraySet(values.NewString("warnings"), list(warnings))
+	result.ArraySet(values.NewString("error_count"), values.NewInt(int64(len(errors))))
+	result.ArraySet(values.NewString("errors"), list(errors))
+}
+
+var (
+	dateLastErrorsMu sync.Mutex
+	// dateLastErrors holds the problems of the last date string parsed by
+	// a DateTime constructor, modify() or createFromFormat()
+	dateLastErrors *dateErrors
+)
+
+// setDateLastErrorList records the outcome of parsing a date string
+func setDateLastErrorList(errs *dateErrors) {
+	dateLastErrorsMu.Lock()
+	defer dateLastErrorsMu.Unlock()
+	dateLastErrors = errs
+}
+
+// dateLastErrorsValue returns what DateTime::getLastErrors() reports: false
+// when the last parse had no warnings or errors
+func dateLastErrorsValue() *values.Value {
+	dateLastErrorsMu.Lock()
+	defer dateLastErrorsMu.Unlock()
+	if dateLastErrors.empty() {
+		return values.NewBool(false)
+	}
+	result := values.NewArray()
+	dateLastErrors.addTo(result)
+	return result
+}
+
+// unsetField marks the parts of a date a string did not give
+const unsetField = -1 << 31
+
+// dateFields are the parts of a date read from a string
+type dateFields struct {
+	year, month, day     int
+	hour, minute, second int
+	micro                int
+	dayOfYear            int
+	meridian             int // 0 when not given, otherwise 1 for am and 2 for pm
+	timestamp            int64
+	hasTimestamp         bool
+	zone                 *dateZone
+	// resetUnparsed is set by |, which resets the fields left unset
+	resetUnparsed bool
+}
+
+// parseDateFormat reads input according to a DateTime::createFromFormat()
+// format. Fields missing from the format are taken from now, unless the
+// format resets them with ! or |.
+func parseDateFormat(format, input string, now time.Time) (time.Time, *dateZone, *dateErrors) {
+	errs := &dateErrors{}
+	f := dateFields{year: unsetField, month: unsetField, day: unsetField, hour: unsetField,
+		minute: unsetField, second: unsetField, micro: unsetField, dayOfYear: unsetField}
+	pos := 0
+
+	// number reads up to max digits, requiring at least min
+	number := func(min, max int) (int, bool) {
+		start := pos
+		for pos < len(input) && pos-start < max && input[pos] >= '0' && input[pos] <= '9' {
+			pos++
+		}
+		if pos-start < min {
+			pos = start
+			return 0, false
+		}
+		n, _ := strconv.Atoi(input[start:pos])
+		return n, true
+	}
+	// word reads a run of letters
+	word := func() string {
+		start := pos
+		for pos < len(input) && isASCIILetter(input[pos]) {
+			pos++
+		}
+		return input[start:pos]
+	}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if pos >= len(input) && !strings.ContainsRune("!|+*", rune(c)) {
+			errs.fail(pos, "Not enough data available to satisfy format")
+			break
+		}
+		start := pos
+		switch c {
+		case 'd', 'j':
+			if n, ok := number(1, 2); ok {
+				f.day = n
+			} else {
+				errs.fail(start, "A two digit day could not be found")
+			}
+		case 'S':
+			if suffix := strings.ToLower(word()); suffix != "st" && suffix != "nd" && suffix != "rd" && suffix != "th" {
+				errs.fail(start, "The ordinal suffix could not be found")
+			}
+		case 'z':
+			if n, ok := number(1, 3); ok {
+				f.dayOfYear = n
+			} else {
+				errs.fail(start, "A three digit day-of-year could not be found")
+			}
+		case 'D', 'l':
+			if _, ok := lookupDayName(word()); !ok {
+				errs.fail(start, "A textual day could not be found")
+			}
+		case 'm', 'n':
+			if n, ok := number(1, 2); ok {
+				f.month = n
+			} else {
+				errs.fail(start, "A two digit month could not be found")
+			}
+		case 'M', 'F':
+			if month, ok := lookupMonthName(word()); ok {
+				f.month = month
+			} else {
+				errs.fail(start, "A textual month could not be found")
+			}
+		case 'y':
+			if n, ok := number(2, 2); ok {
+				f.year = expandTwoDigitYear(n)
+			} else {
+				errs.fail(start, "A two digit year could not be found")
+			}
+		case 'Y':
+			negative := false
+			if input[pos] == '-' {
+				negative = true
+				pos++
+			}
+			if n, ok := number(1, 4); ok {
+				f.year = n
+				if negative {
+					f.year = -n
+				}
+			} else {
+				pos = start
+				errs.fail(start, "A four digit year could not be found")
+			}
+		case 'a', 'A':
+			switch meridian := strings.ToLower(readMeridian(input, &pos)); meridian {
+			case "am":
+				f.meridian = 1
+			case "pm":
+				f.meridian = 2
+			default:
+				errs.fail(start, "A meridian could not be found")
+			}
+		case 'g', 'h', 'G', 'H':
+			if n, ok := number(1, 2); ok {
+				f.hour = n
+			} else {
+				errs.fail(start, "A two digit hour could not be found")
+			}
+		case 'i':
+			if n, ok := number(2, 2); ok {
+				f.minute = n
+			} else {
+				errs.fail(start, "A two digit minute could not be found")
+			}
+		case 's':
+			if n, ok := number(2, 2); ok {
+				f.second = n
+			} else {
+				errs.fail(start, "A two digit second could not be found")
+			}
+		case 'v':
+			if n, ok := number(3, 3); ok {
+				f.micro = n * 1000
+			} else {
+				errs.fail(start, "A three digit millisecond could not be found")
+			}
+		case 'u':
+			if n, ok := number(1, 6); ok {
+				for digits := pos - start; digits < 6; digits++ {
+					n *= 10
+				}
+				f.micro = n
+			} else {
+				errs.fail(start, "A six digit microsecond could not be found")
+			}
+		case 'U':
+			negative := input[pos] == '-'
+			if negative || input[pos] == '+' {
+				pos++
+			}
+			if n, ok := number(1, 19); ok {
+				f.timestamp, f.hasTimestamp = int64(n), true
+				if negative {
+					f.timestamp = -f.timestamp
+				}
+			} else {
+				pos = start
+				errs.fail(start, "A unix timestamp could not be found")
+			}
+		case 'e', 'T', 'O', 'P', 'p':
+			if zone := readZone(input, &pos); zone != nil {
+				f.zone = zone
+			} else {
+				errs.fail(start, "The timezone could not be found in the database")
+			}
+		case ' ':
+			for pos < len(input) && (input[pos] == ' ' || input[pos] == '\t') {
+				pos++
+			}
+		case '#':
+			if strings.IndexByte(";:/.,-()", input[pos]) >= 0 {
+				pos++
+			} else {
+				errs.fail(start, "The separation symbol ([;:/.,-]) could not be found")
+			}
+		case ';', ':', '/', '.', ',', '-', '(', ')':
+			if input[pos] == c {
+				pos++
+			} else {
+				errs.fail(start, "The separation symbol could not be found")
+			}
+		case '?':
+			pos++
+		case '*':
+			for pos < len(input) && strings.IndexByte(" ,;:/.-()", input[pos]) < 0 && (input[pos] < '0' || input[pos] > '9') {
+				pos++
+			}
+		case '!':
+			f = resetDateFields(f)
+		case '|':
+			f.resetUnparsed = true
+		case '+':
+			if pos < len(input) {
+				errs.warn(pos, "Trailing data")
+			}
+			pos = len(input)
+		case '\\':
+			i++
+			if i < len(format) && input[pos] == format[i] {
+				pos++
+			} else {
+				errs.fail(start, "The escaped character could not be found")
+			}
+		default:
+			if input[pos] == c {
+				pos++
+			} else {
+				errs.fail(start, "The format separator does not match")
+			}
+		}
+		if errs.failed() {
+			break
+		}
+	}
+	if !errs.failed() && pos < len(input) {
+		errs.fail(pos, "Trailing data")
+	}
+	if errs.failed() {
+		return time.Time{}, nil, errs
+	}
+	t, zone := f.resolve(now)
+	return t, zone, errs
+}
+
+// resetDateFields sets the fields not read yet to the Unix epoch, as ! and
+// | do
+func resetDateFields(f dateFields) dateFields {
+	set := func(field *int, value int) {
+		if *field == unsetField {
+			*field = value
+		}
+	}
+	set(&f.year, 1970)
+	set(&f.month, 1)
+	set(&f.day, 1)
+	set(&f.hour, 0)
+	set(&f.minute, 0)
+	set(&f.second, 0)
+	set(&f.micro, 0)
+	return f
+}
+
+// resolve turns the fields into a time. The date fields not given come from
+// now; when any time field is given the others default to zero, otherwise
+// the time also comes from now.
+func (f dateFields) resolve(now time.Time) (time.Time, *dateZone) {
+	if f.resetUnparsed {
+		f = resetDateFields(f)
+	}
+	loc := now.Location()
+	if f.zone != nil {
+		loc = f.zone.loc
+		now = now.In(loc)
+	}
+	if f.hasTimestamp {
+		zone := f.zone
+		if zone == nil {
+			zone = offsetDateZone(0)
+		}
+		t := time.Unix(f.timestamp, 0).In(zone.loc)
+		if f.micro != unsetField {
+			t = t.Add(time.Duration(f.micro) * time.Microsecond)
+		}
+		return t, zone
+	}
+	if f.hour == unsetField && f.minute == unsetField && f.second == unsetField && f.micro == unsetField {
+		f.hour, f.minute, f.second, f.micro = now.Hour(), now.Minute(), now.Second(), now.Nanosecond()/1000
+	}
+	for _, field := range []struct {
+		value    *int
+		fallback int
+	}{
+		{&f.year, now.Year()}, {&f.month, int(now.Month())}, {&f.day, now.Day()},
+		{&f.hour, 0}, {&f.minute, 0}, {&f.second, 0}, {&f.micro, 0},
+	} {
+		if *field.value == unsetField {
+			*field.value = field.fallback
+		}
+	}
+	switch f.meridian {
+	case 1:
+		f.hour %= 12
+	case 2:
+		f.hour = f.hour%12 + 12
+	}
+	if f.dayOfYear != unsetField {
+		f.month, f.day = 1, 1+f.dayOfYear
+	}
+	t := time.Date(f.year, time.Month(f.month), f.day, f.hour, f.minute, f.second, f.micro*1000, loc)
+	return t, f.zone
+}
+
+// readZone reads a timezone identifier, abbreviation or offset at *pos
+func readZone(input string, pos *int) *dateZone {
+	start := *pos
+	end := start
+	if end < len(input) && (input[end] == '+' || input[end] == '-') {
+		end++
+		for end < len(input) && (input[end] >= '0' && input[end] <= '9' || input[end] == ':') {
+			end++
+		}
+	} else {
+		for end < len(input) && (isASCIILetter(input[end]) || input[end] == '/' || input[end] == '_' ||
+			(end > start && (input[end] == '-' || input[end] == '+' || input[end] >= '0' && input[end] <= '9'))) {
+			end++
+		}
+	}
+	zone, ok := loadDateZone(input[start:end])
+	if !ok {
+		return nil
+	}
+	*pos = end
+	return zone
+}
+
+// readMeridian reads am, pm, a.m. or p.m. at *pos
+func readMeridian(input string, pos *int) string {
+	rest := strings.ToLower(input[*pos:])
+	for _, form := range []string{"a.m.", "p.m.", "am", "pm"} {
+		if strings.HasPrefix(rest, form) {
+			*pos += len(form)
+			return strings.ReplaceAll(form, ".", "")
+		}
+	}
+	return ""
+}
+
+// expandTwoDigitYear maps a two-digit year to 1970-2069
+func expandTwoDigitYear(year int) int {
+	if year < 70 {
+		return 2000 + year
+	}
+	return 1900 + year
+}
+
+var monthNames = []string{"january", "february", "march", "april", "may", "june", "july", "august", "september", "october", "november", "december"}
+
+var dayNames = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// lookupMonthName returns the month of a full or three-letter English
+// month name
+func lookupMonthName(name string) (int, bool) {
+	name = strings.ToLower(name)
+	for i, month := range monthNames {
+		if name == month || (len(name) == 3 && strings.HasPrefix(month, name)) || (name == "sept" && i == 8) {
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// lookupDayName returns the weekday of a full or three-letter English day
+// name
+func lookupDayName(name string) (time.Weekday, bool) {
+	name = strings.ToLower(name)
+	for i, day := range dayNames {
+		if name == day || (len(name) == 3 && strings.HasPrefix(day, name)) {
+			return time.Weekday(i), true
+		}
+	}
+	return 0, false
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
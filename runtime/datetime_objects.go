@@ -312,6 +312,10 @@ func GetDateTimeObjectFunctions() []*registry.Function {
 					Seconds: int(intervalObj.ArrayGet(values.NewString("s")).ToInt()),
 					Invert:  intervalObj.ArrayGet(values.NewString("invert")).ToBool(),
 				}
+				interval.TotalDays = -1
+				if days := intervalObj.ArrayGet(values.NewString("days")); days != nil && !days.IsNull() && days.Type != values.TypeBool {
+					interval.TotalDays = int(days.ToInt())
+				}
 
 				formatted := formatInterval(format, interval)
 				return values.NewString(formatted), nil
@@ -425,13 +429,15 @@ func GetDateTimeObjectFunctions() []*registry.Function {
 
 // DateIntervalData represents interval data
 type DateIntervalData struct {
-	Years   int
-	Months  int
-	Days    int
-	Hours   int
-	Minutes int
-	Seconds int
-	Invert  bool // Whether the interval is negative
+	Years        int
+	Months       int
+	Days         int
+	Hours        int
+	Minutes      int
+	Seconds      int
+	Microseconds int
+	Invert       bool // Whether the interval is negative
+	TotalDays    int  // Total number of days, or -1 when unknown
 }
 
 // parseISOInterval parses ISO 8601 duration format (P1Y2M3DT4H5M6S)
@@ -441,7 +447,25 @@ func parseISOInterval(spec string) (*DateIntervalData, error) {
 	}
 
 	spec = spec[1:] // Remove 'P'
-	interval := &DateIntervalData{}
+	interval := &DateIntervalData{TotalDays: -1}
+
+	// Alternative form: PYYYY-MM-DDTHH:MM:SS
+	if len(spec) == 19 && spec[4] == '-' && spec[10] == 'T' {
+		var err error
+		fields := []*int{&interval.Years, &interval.Months, &interval.Days, &interval.Hours, &interval.Minutes, &interval.Seconds}
+		for i, part := range strings.FieldsFunc(spec, func(r rune) bool { return r == '-' || r == 'T' || r == ':' }) {
+			if i >= len(fields) {
+				return nil, fmt.Errorf("invalid interval format")
+			}
+			if *fields[i], err = strconv.Atoi(part); err != nil {
+				return nil, fmt.Errorf("invalid interval format")
+			}
+		}
+		return interval, nil
+	}
+	if spec == "" || strings.HasSuffix(spec, "T") {
+		return nil, fmt.Errorf("invalid interval format")
+	}
 
 	// Split at 'T' if present
 	timePart := ""
@@ -499,8 +523,10 @@ func parseIntervalDatePart(part string, interval *DateIntervalData) error {
 			interval.Years = num
 		case 'M':
 			interval.Months = num
+		case 'W':
+			interval.Days += num * 7
 		case 'D':
-			interval.Days = num
+			interval.Days += num
 		default:
 			return fmt.Errorf("invalid date unit: %c", unit)
 		}
@@ -553,41 +579,66 @@ func parseIntervalTimePart(part string, interval *DateIntervalData) error {
 
 // formatInterval formats a DateInterval according to format string
 func formatInterval(format string, interval *DateIntervalData) string {
-	result := ""
+	var result strings.Builder
 
 	for i := 0; i < len(format); i++ {
-		if format[i] == '%' && i+1 < len(format) {
-			i++ // Skip %
-			switch format[i] {
-			case 'y', 'Y':
-				result += strconv.Itoa(interval.Years)
-			case 'm':
-				result += strconv.Itoa(interval.Months)
-			case 'd':
-				result += strconv.Itoa(interval.Days)
-			case 'h', 'H':
-				result += strconv.Itoa(interval.Hours)
-			case 'i', 'I':
-				result += strconv.Itoa(interval.Minutes)
-			case 's', 'S':
-				result += strconv.Itoa(interval.Seconds)
-			case 'a':
-				// Total days - simplified calculation
-				totalDays := interval.Days + (interval.Years * 365) + (interval.Months * 30)
-				result += strconv.Itoa(totalDays)
-			case 'R':
-				if interval.Invert {
-					result += "-"
-				} else {
-					result += "+"
-				}
-			default:
-				result += "%" + string(format[i])
+		if format[i] != '%' || i+1 >= len(format) {
+			result.WriteByte(format[i])
+			continue
+		}
+		i++ // Skip %
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&result, "%02d", interval.Years)
+		case 'y':
+			result.WriteString(strconv.Itoa(interval.Years))
+		case 'M':
+			fmt.Fprintf(&result, "%02d", interval.Months)
+		case 'm':
+			result.WriteString(strconv.Itoa(interval.Months))
+		case 'D':
+			fmt.Fprintf(&result, "%02d", interval.Days)
+		case 'd':
+			result.WriteString(strconv.Itoa(interval.Days))
+		case 'H':
+			fmt.Fprintf(&result, "%02d", interval.Hours)
+		case 'h':
+			result.WriteString(strconv.Itoa(interval.Hours))
+		case 'I':
+			fmt.Fprintf(&result, "%02d", interval.Minutes)
+		case 'i':
+			result.WriteString(strconv.Itoa(interval.Minutes))
+		case 'S':
+			fmt.Fprintf(&result, "%02d", interval.Seconds)
+		case 's':
+			result.WriteString(strconv.Itoa(interval.Seconds))
+		case 'F':
+			fmt.Fprintf(&result, "%06d", interval.Microseconds)
+		case 'f':
+			result.WriteString(strconv.Itoa(interval.Microseconds))
+		case 'a':
+			if interval.TotalDays < 0 {
+				result.WriteString("(unknown)")
+			} else {
+				result.WriteString(strconv.Itoa(interval.TotalDays))
 			}
-		} else {
-			result += string(format[i])
+		case 'R':
+			if interval.Invert {
+				result.WriteByte('-')
+			} else {
+				result.WriteByte('+')
+			}
+		case 'r':
+			if interval.Invert {
+				result.WriteByte('-')
+			}
+		case '%':
+			result.WriteByte('%')
+		default:
+			result.WriteByte('%')
+			result.WriteByte(format[i])
 		}
 	}
 
-	return result
-}
\ No newline at end of file
+	return result.String()
+}
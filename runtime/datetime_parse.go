@@ -0,0 +1,1334 @@
+package runtime
+
+import (
+	"strings"
+	"time"
+
+	"github.com/wudi/hey/values"
+)
+
+// The date string parser reads the formats PHP documents for strtotime(),
+// the DateTime constructor and modify(): absolute dates and times, relative
+// units, ordinals and weekdays, and timezone names and offsets. It works in
+// two steps like PHP's timelib does: parsing fills a parsedDate with the
+// fields and relative parts the string gives, and resolve or modify then
+// apply them to a base time.
+
+// Special relative adjustments
+const (
+	dateSpecialWeekdays = iota + 1
+	dateSpecialDayOfWeekInMonth
+	dateSpecialLastDayOfWeekInMonth
+)
+
+// "first day of" and "last day of"
+const (
+	dateFirstDayOfMonth = 1
+	dateLastDayOfMonth  = 2
+)
+
+// dateRelative is the relative part of a date string
+type dateRelative struct {
+	y, m, d, h, i, s, us int
+
+	haveWeekday bool
+	weekday     int
+	// weekdayBehavior is 0 to move to the next matching weekday, 1 to
+	// also accept the current day and 2 to stay in the current week
+	weekdayBehavior int
+
+	special       int
+	specialAmount int
+	firstLast     int
+}
+
+// parsedDate is what a date string gives. Fields it does not give are
+// unsetField.
+type parsedDate struct {
+	y, m, d, h, i, s, us int
+	zone                 *dateZone
+
+	haveDate     bool
+	haveTime     bool
+	haveZone     int
+	haveRelative bool
+	rel          dateRelative
+
+	errs dateErrors
+}
+
+// dateUnit is a unit of a relative date, such as "days" or "fri"
+type dateUnit struct {
+	field      byte // y, m, d, h, i, s or u for the fields, w for a weekday, W for weekdays
+	multiplier int
+}
+
+var dateUnits = map[string]dateUnit{
+	"ms": {'u', 1000}, "msec": {'u', 1000}, "msecs": {'u', 1000}, "millisecond": {'u', 1000}, "milliseconds": {'u', 1000},
+	"usec": {'u', 1}, "usecs": {'u', 1}, "microsecond": {'u', 1}, "microseconds": {'u', 1},
+	"sec": {'s', 1}, "secs": {'s', 1}, "second": {'s', 1}, "seconds": {'s', 1},
+	"min": {'i', 1}, "mins": {'i', 1}, "minute": {'i', 1}, "minutes": {'i', 1},
+	"hour": {'h', 1}, "hours": {'h', 1},
+	"day": {'d', 1}, "days": {'d', 1},
+	"week": {'d', 7}, "weeks": {'d', 7},
+	"fortnight": {'d', 14}, "fortnights": {'d', 14}, "forthnight": {'d', 14}, "forthnights": {'d', 14},
+	"month": {'m', 1}, "months": {'m', 1},
+	"year": {'y', 1}, "years": {'y', 1},
+	"weekday": {'W', 1}, "weekdays": {'W', 1},
+}
+
+// dateRelativeText maps the words of relative text such as "next month" and
+// "third friday of" to their amounts
+var dateRelativeText = map[string]int{
+	"last": -1, "previous": -1, "this": 0, "first": 1, "next": 1,
+	"second": 2, "third": 3, "fourth": 4, "fifth": 5, "sixth": 6,
+	"seventh": 7, "eight": 8, "eighth": 8, "ninth": 9, "tenth": 10,
+	"eleventh": 11, "twelfth": 12,
+}
+
+// lookupDateUnit returns the unit named by word, which may also be a
+// weekday
+func lookupDateUnit(word string) (dateUnit, bool) {
+	if unit, ok := dateUnits[word]; ok {
+		return unit, true
+	}
+	if day, ok := lookupWeekdayWord(word); ok {
+		return dateUnit{field: 'w', multiplier: int(day)}, true
+	}
+	return dateUnit{}, false
+}
+
+// lookupWeekdayWord returns the weekday of a day name, its three-letter
+// abbreviation or one of tues, wednes, thur and thurs
+func lookupWeekdayWord(word string) (time.Weekday, bool) {
+	switch word {
+	case "tues":
+		return time.Tuesday, true
+	case "wednes":
+		return time.Wednesday, true
+	case "thur", "thurs":
+		return time.Thursday, true
+	}
+	return lookupDayName(word)
+}
+
+// dateScanner walks a date string. low is the string with ASCII letters
+// lowercased, so positions match the input.
+type dateScanner struct {
+	str  string
+	low  string
+	pos  int
+	date *parsedDate
+}
+
+// parseDateText parses a date string. Problems are recorded in the errs
+// of the result.
+func parseDateText(input string) *parsedDate {
+	p := &parsedDate{y: unsetField, m: unsetField, d: unsetField, h: unsetField, i: unsetField, s: unsetField, us: unsetField}
+	str := strings.TrimSpace(input)
+	if str == "" {
+		p.errs.fail(0, "Empty string")
+		return p
+	}
+	sc := &dateScanner{str: str, low: asciiLower(str), date: p}
+	for sc.pos < len(sc.low) {
+		sc.scan()
+	}
+
+	end := len(str) + 1
+	if p.haveDate && p.m != unsetField && p.d != unsetField {
+		year := p.y
+		if year == unsetField {
+			year = 2000
+		}
+		if p.m < 1 || p.m > 12 || p.d < 1 || p.d > daysInMonth(year, time.Month(p.m)) {
+			p.errs.warn(end, "The parsed date was invalid")
+		}
+	}
+	if p.haveTime && (p.h > 23 || p.i > 59 || p.s > 59) {
+		p.errs.warn(end, "The parsed time was invalid")
+	}
+	return p
+}
+
+func (sc *dateScanner) scan() {
+	c := sc.low[sc.pos]
+	switch {
+	case c == ' ' || c == '\t' || c == '\n' || c == ',' || c == '.':
+		sc.pos++
+	case c == '@':
+		sc.timestamp()
+	case isDigit(c):
+		sc.number()
+	case c == '+' || c == '-':
+		sc.signed()
+	case c == '(' && isASCIILetter(sc.peek(sc.pos+1)):
+		sc.pos++
+		sc.word()
+		if sc.peek(sc.pos) == ')' {
+			sc.pos++
+		}
+	case isASCIILetter(c):
+		sc.word()
+	default:
+		sc.date.errs.fail(sc.pos, "Unexpected character")
+		sc.pos++
+	}
+}
+
+// timestamp reads @ followed by a Unix timestamp, which may have a
+// fraction
+func (sc *dateScanner) timestamp() {
+	p := sc.date
+	start := sc.pos
+	pos := start + 1
+	sign := 1
+	if c := sc.peek(pos); c == '-' || c == '+' {
+		if c == '-' {
+			sign = -1
+		}
+		pos++
+	}
+	seconds, n := sc.digits(pos)
+	if n == 0 {
+		p.errs.fail(start, "Unexpected character")
+		sc.pos++
+		return
+	}
+	pos += n
+	micro := 0
+	if sc.peek(pos) == '.' && isDigit(sc.peek(pos+1)) {
+		micro, pos = sc.fraction(pos + 1)
+	}
+	sc.pos = pos
+
+	p.haveRelative = true
+	p.haveDate, p.haveTime = false, false
+	if !sc.haveZone(start) {
+		return
+	}
+	p.zone = offsetDateZone(0)
+	p.y, p.m, p.d, p.h, p.i, p.s, p.us = 1970, 1, 1, 0, 0, 0, 0
+	p.rel.s += sign * seconds
+	p.rel.us += sign * micro
+}
+
+// number reads a token starting with a digit: a date, a time, or an amount
+// of a relative unit
+func (sc *dateScanner) number() {
+	p := sc.date
+	start := sc.pos
+	value, n := sc.digits(start)
+	end := start + n
+	next := sc.peek(end)
+
+	switch {
+	case n == 4 && (next == '-' || next == '/') && isDigit(sc.peek(end+1)):
+		sc.isoDate(start)
+		return
+	case n == 4 && (next == 'w' || next == '-' && sc.peek(end+1) == 'w'):
+		sc.isoWeekDate(start)
+		return
+	case n == 8:
+		sc.compactDate(start)
+		return
+	case n <= 2 && next == ':' && isDigit(sc.peek(end+1)):
+		sc.clock(start)
+		return
+	case n <= 2 && next == '.' && isDigit(sc.peek(end+1)):
+		sc.dotted(start)
+		return
+	case n <= 2 && next == '/' && isDigit(sc.peek(end+1)):
+		sc.americanDate(start)
+		return
+	case n <= 2 && next == '-' && isDigit(sc.peek(end+1)):
+		sc.dashedDate(start)
+		return
+	}
+
+	after := sc.skipBlanks(end)
+	word := sc.wordAt(after)
+	if unit, ok := lookupDateUnit(word); ok {
+		sc.pos = after + len(word)
+		sc.setRelative(value, 0, unit, true)
+		return
+	}
+	if n <= 2 {
+		if meridian, length := sc.meridian(after); meridian != 0 {
+			sc.pos = after + length
+			if sc.haveTime(start) {
+				p.h = applyMeridian(value, meridian)
+			}
+			return
+		}
+		if sc.textualDate(start) {
+			return
+		}
+	}
+	switch n {
+	case 4:
+		// Four digits alone are a time without a colon, or a year when they
+		// cannot be one
+		sc.pos = end
+		if value/100 < 24 && value%100 < 60 {
+			if sc.haveTime(start) {
+				p.h, p.i = value/100, value%100
+			}
+		} else if sc.haveDate(start) {
+			p.y = value
+		}
+	case 6:
+		sc.pos = end
+		if sc.haveTime(start) {
+			p.h, p.i, p.s = value/10000, value/100%100, value%100
+		}
+	default:
+		p.errs.fail(start, "Unexpected character")
+		sc.pos = end
+	}
+}
+
+// isoDate reads year-month-day or year/month/day, or year-month alone,
+// and a time following a T
+func (sc *dateScanner) isoDate(start int) {
+	p := sc.date
+	year, _ := sc.digits(start)
+	sep := sc.peek(start + 4)
+	pos := start + 5
+	month, n := sc.digits(pos)
+	if n > 2 {
+		p.errs.fail(start, "Unexpected character")
+		sc.pos = pos + n
+		return
+	}
+	pos += n
+	day := 1
+	if sc.peek(pos) == sep && isDigit(sc.peek(pos+1)) {
+		var m int
+		day, m = sc.digits(pos + 1)
+		if m > 2 {
+			p.errs.fail(start, "Unexpected character")
+			sc.pos = pos + 1 + m
+			return
+		}
+		pos += 1 + m
+	}
+	sc.pos = pos
+	if month > 12 || day > 31 {
+		p.errs.fail(start, "Unexpected character")
+		return
+	}
+	if sc.haveDate(start) {
+		p.y, p.m, p.d = year, month, day
+	}
+	sc.skipTimeDesignator()
+}
+
+// isoWeekDate reads an ISO week date such as 2025W03, 2025W031 or
+// 2025-W03-1
+func (sc *dateScanner) isoWeekDate(start int) {
+	p := sc.date
+	year, _ := sc.digits(start)
+	pos := start + 4
+	if sc.peek(pos) == '-' {
+		pos++
+	}
+	pos++ // W
+	week, n := sc.digits(pos)
+	if n != 2 && n != 3 {
+		p.errs.fail(start, "Unexpected character")
+		sc.pos = pos
+		return
+	}
+	day := 1
+	if n == 3 {
+		week, day = week/10, week%10
+	} else if sc.peek(pos+2) == '-' && isDigit(sc.peek(pos+3)) {
+		day = int(sc.low[pos+3] - '0')
+		n++
+		pos++
+	}
+	sc.pos = pos + n
+	if week < 1 || week > 53 || day > 7 {
+		p.errs.fail(start, "Unexpected character")
+		return
+	}
+	if sc.haveDate(start) {
+		p.haveRelative = true
+		p.y, p.m, p.d = year, 1, 1
+		p.rel.d += dayNumberFromISOWeek(year, week, day)
+	}
+}
+
+// compactDate reads YYYYMMDD, and a time following a T
+func (sc *dateScanner) compactDate(start int) {
+	p := sc.date
+	value, _ := sc.digits(start)
+	sc.pos = start + 8
+	month, day := value/100%100, value%100
+	if month > 12 || day > 31 {
+		p.errs.fail(start, "Unexpected character")
+		return
+	}
+	if sc.haveDate(start) {
+		p.y, p.m, p.d = value/10000, month, day
+	}
+	sc.skipTimeDesignator()
+}
+
+// skipTimeDesignator skips the T between the date and time of ISO 8601
+func (sc *dateScanner) skipTimeDesignator() {
+	if sc.peek(sc.pos) == 't' && isDigit(sc.peek(sc.pos+1)) {
+		sc.pos++
+	}
+}
+
+// clock reads hour:minute, with optional seconds, fraction and am or pm.
+// A dot may separate the parts too.
+func (sc *dateScanner) clock(start int) {
+	p := sc.date
+	hour, n := sc.digits(start)
+	pos := start + n + 1
+	minute, n := sc.digits(pos)
+	pos += n
+	second, micro := 0, 0
+	if c := sc.peek(pos); (c == ':' || c == '.') && isDigit(sc.peek(pos+1)) {
+		second, n = sc.digits(pos + 1)
+		pos += 1 + n
+		if c := sc.peek(pos); (c == '.' || c == ',') && isDigit(sc.peek(pos+1)) {
+			micro, pos = sc.fraction(pos + 1)
+		}
+	}
+	meridian := 0
+	if hour >= 1 && hour <= 12 {
+		var length int
+		after := sc.skipBlanks(pos)
+		if meridian, length = sc.meridian(after); meridian != 0 {
+			pos = after + length
+		}
+	}
+	sc.pos = pos
+	if hour > 24 || minute > 59 || second > 60 {
+		p.errs.fail(start, "Unexpected character")
+		return
+	}
+	if !sc.haveTime(start) {
+		return
+	}
+	if meridian != 0 {
+		hour = applyMeridian(hour, meridian)
+	}
+	p.h, p.i, p.s, p.us = hour, minute, second, micro
+}
+
+// dotted reads day.month.year, or hour.minute when there are only two
+// parts
+func (sc *dateScanner) dotted(start int) {
+	first, n := sc.digits(start)
+	pos := start + n + 1
+	second, m := sc.digits(pos)
+	pos += m
+	if sc.peek(pos) != '.' || !isDigit(sc.peek(pos+1)) || m > 2 {
+		sc.clock(start)
+		return
+	}
+	third, k := sc.digits(pos + 1)
+	if k != 2 && k != 4 {
+		sc.clock(start)
+		return
+	}
+	sc.pos = pos + 1 + k
+	if k == 2 {
+		third = expandTwoDigitYear(third)
+	}
+	sc.setDate(start, third, second, first)
+}
+
+// americanDate reads month/day and month/day/year
+func (sc *dateScanner) americanDate(start int) {
+	month, n := sc.digits(start)
+	pos := start + n + 1
+	day, m := sc.digits(pos)
+	pos += m
+	year := unsetField
+	if sc.peek(pos) == '/' && isDigit(sc.peek(pos+1)) {
+		var k int
+		year, k = sc.digits(pos + 1)
+		if k <= 2 {
+			year = expandTwoDigitYear(year)
+		}
+		pos += 1 + k
+	}
+	sc.pos = pos
+	sc.setDate(start, year, month, day)
+}
+
+// dashedDate reads day-month-year with a four-digit year, or
+// year-month-day with a two-digit one
+func (sc *dateScanner) dashedDate(start int) {
+	first, n := sc.digits(start)
+	pos := start + n + 1
+	second, m := sc.digits(pos)
+	pos += m
+	if sc.peek(pos) != '-' || !isDigit(sc.peek(pos+1)) || m > 2 {
+		sc.date.errs.fail(start, "Unexpected character")
+		sc.pos = pos
+		return
+	}
+	third, k := sc.digits(pos + 1)
+	sc.pos = pos + 1 + k
+	switch k {
+	case 4:
+		sc.setDate(start, third, second, first)
+	case 2:
+		sc.setDate(start, expandTwoDigitYear(first), second, third)
+	default:
+		sc.date.errs.fail(start, "Unexpected character")
+	}
+}
+
+// textualDate reads a day followed by a month name, such as "15 January
+// 2025", "1st jan" or "15-Jan-24". It reports false when no month name
+// follows the day.
+func (sc *dateScanner) textualDate(start int) bool {
+	day, n := sc.digits(start)
+	pos := start + n
+	if suffix := sc.wordAt(pos); suffix == "st" || suffix == "nd" || suffix == "rd" || suffix == "th" {
+		pos += 2
+	}
+	pos = sc.skipDateSeparators(pos)
+	word := sc.wordAt(pos)
+	month, ok := lookupMonthName(word)
+	if !ok {
+		return false
+	}
+	pos += len(word)
+	year := unsetField
+	if yearPos := sc.skipDateSeparators(pos); isDigit(sc.peek(yearPos)) {
+		if value, k, ok := sc.yearAt(yearPos); ok {
+			year = value
+			if k <= 2 {
+				year = expandTwoDigitYear(year)
+			}
+			pos = yearPos + k
+		}
+	}
+	sc.pos = pos
+	sc.setDate(start, year, month, day)
+	return true
+}
+
+// monthDate reads a date starting with a month name: "january", "jan 15",
+// "January 15th, 2025" or "january 2025"
+func (sc *dateScanner) monthDate(start, end, month int) {
+	p := sc.date
+	sc.pos = end
+	year, day := unsetField, unsetField
+	pos := sc.skipDateSeparators(end)
+	if value, n, ok := sc.yearAt(pos); ok && n == 4 {
+		year, day = value, 1
+		sc.pos = pos + n
+	} else if value, n := sc.digits(pos); n > 0 && n <= 2 && sc.peek(pos+n) != ':' && sc.peek(pos+n) != '.' {
+		day = value
+		pos += n
+		if suffix := sc.wordAt(pos); suffix == "st" || suffix == "nd" || suffix == "rd" || suffix == "th" {
+			pos += 2
+		}
+		sc.pos = pos
+		if value, k, ok := sc.yearAt(sc.skipDateSeparators(pos)); ok && k == 4 {
+			year = value
+			sc.pos = sc.skipDateSeparators(pos) + k
+		}
+	}
+	if !sc.haveDate(start) {
+		return
+	}
+	p.m = month
+	if day != unsetField {
+		p.d = day
+	}
+	if year != unsetField {
+		p.y = year
+	}
+}
+
+// yearAt reads digits at pos that are not the start of a time or a relative
+// amount
+func (sc *dateScanner) yearAt(pos int) (int, int, bool) {
+	value, n := sc.digits(pos)
+	if n == 0 || n > 4 || n == 3 {
+		return 0, 0, false
+	}
+	if c := sc.peek(pos + n); c == ':' || isASCIILetter(c) {
+		return 0, 0, false
+	}
+	if _, ok := lookupDateUnit(sc.wordAt(sc.skipBlanks(pos + n))); ok {
+		return 0, 0, false
+	}
+	if _, length := sc.meridian(sc.skipBlanks(pos + n)); length > 0 {
+		return 0, 0, false
+	}
+	return value, n, true
+}
+
+func (sc *dateScanner) setDate(start, year, month, day int) {
+	p := sc.date
+	if month > 12 || day > 31 {
+		p.errs.fail(start, "Unexpected character")
+		return
+	}
+	if !sc.haveDate(start) {
+		return
+	}
+	p.m, p.d = month, day
+	if year != unsetField {
+		p.y = year
+	}
+}
+
+// signed reads a token starting with + or -: a relative amount such as
+// "+1 week", or a UTC offset such as -05:00
+func (sc *dateScanner) signed() {
+	p := sc.date
+	start := sc.pos
+	pos := start
+	sign := 1
+	for pos < len(sc.low) && (sc.low[pos] == '+' || sc.low[pos] == '-') {
+		if sc.low[pos] == '-' {
+			sign = -sign
+		}
+		pos++
+	}
+	signs := pos - start
+	pos = sc.skipBlanks(pos)
+	value, n := sc.digits(pos)
+	if n == 0 {
+		p.errs.fail(start, "Unexpected character")
+		sc.pos = start + 1
+		return
+	}
+	end := pos + n
+	after := sc.skipBlanks(end)
+	word := sc.wordAt(after)
+	if unit, ok := lookupDateUnit(word); ok {
+		sc.pos = after + len(word)
+		sc.setRelative(sign*value, 0, unit, true)
+		return
+	}
+	if signs != 1 || pos != start+1 {
+		p.errs.fail(start, "Unexpected character")
+		sc.pos = end
+		return
+	}
+	sc.offset(start)
+}
+
+// offset reads a UTC offset such as +5, +0530 or -05:00 at start
+func (sc *dateScanner) offset(start int) {
+	p := sc.date
+	_, n := sc.digits(start + 1)
+	end := start + 1 + n
+	if n <= 2 && sc.peek(end) == ':' && isDigit(sc.peek(end+1)) {
+		_, m := sc.digits(end + 1)
+		end += 1 + m
+	}
+	sc.pos = end
+	offset, ok := parseZoneOffset(sc.str[start:end])
+	if !ok {
+		p.errs.fail(start, "Unexpected character")
+		return
+	}
+	if sc.haveZone(start) {
+		p.zone = offsetDateZone(offset)
+	}
+}
+
+// word reads a token starting with a letter: a keyword, relative text, a
+// month or day name, or a timezone
+func (sc *dateScanner) word() {
+	p := sc.date
+	start := sc.pos
+	word := sc.wordAt(start)
+	end := start + len(word)
+
+	if c := sc.peek(end); (c == '/' || c == '_') && isASCIILetter(sc.peek(end+1)) {
+		sc.zoneIdentifier(start)
+		return
+	}
+
+	switch word {
+	case "now":
+		sc.pos = end
+		return
+	case "today", "midnight":
+		sc.pos = end
+		p.unhaveTime()
+		return
+	case "noon":
+		sc.pos = end
+		p.unhaveTime()
+		if sc.haveTime(start) {
+			p.h = 12
+		}
+		return
+	case "tomorrow", "yesterday":
+		sc.pos = end
+		p.haveRelative = true
+		p.unhaveTime()
+		if word == "tomorrow" {
+			p.rel.d++
+		} else {
+			p.rel.d--
+		}
+		return
+	case "ago":
+		sc.pos = end
+		p.rel.y, p.rel.m, p.rel.d = -p.rel.y, -p.rel.m, -p.rel.d
+		p.rel.h, p.rel.i, p.rel.s, p.rel.us = -p.rel.h, -p.rel.i, -p.rel.s, -p.rel.us
+		if p.rel.special == dateSpecialWeekdays {
+			p.rel.specialAmount = -p.rel.specialAmount
+		}
+		return
+	case "t":
+		if isDigit(sc.peek(end)) {
+			sc.pos = end
+			return
+		}
+	case "back", "front":
+		if of := sc.skipBlanks(end); sc.wordAt(of) == "of" {
+			if hour := sc.skipBlanks(of + 2); isDigit(sc.peek(hour)) {
+				sc.backFrontOf(word, start, hour)
+				return
+			}
+		}
+	}
+
+	if amount, ok := dateRelativeText[word]; ok {
+		behavior := 0
+		if word == "this" {
+			behavior = 1
+		}
+		next := sc.skipBlanks(end)
+		unitWord := sc.wordAt(next)
+		if (word == "first" || word == "last") && unitWord == "day" {
+			sc.firstLastDayOf(word, next+len(unitWord))
+			return
+		}
+		if unit, ok := lookupDateUnit(unitWord); ok {
+			sc.pos = next + len(unitWord)
+			switch {
+			case unit.field == 'w' && sc.wordAt(sc.skipBlanks(sc.pos)) == "of":
+				sc.pos = sc.skipBlanks(sc.pos) + 2
+				sc.weekdayOf(amount, behavior, unit)
+			case unitWord == "week" && (word == "next" || word == "last" || word == "previous" || word == "this"):
+				sc.setRelative(amount, behavior, unit, false)
+				p.rel.weekdayBehavior = 2
+				if !p.rel.haveWeekday {
+					p.rel.haveWeekday = true
+					p.rel.weekday = 1
+				}
+			default:
+				sc.setRelative(amount, behavior, unit, false)
+			}
+			return
+		}
+	}
+
+	if month, ok := lookupMonthName(word); ok {
+		sc.monthDate(start, end, month)
+		return
+	}
+	if day, ok := lookupWeekdayWord(word); ok {
+		sc.pos = end
+		p.haveRelative = true
+		p.rel.haveWeekday = true
+		p.unhaveTime()
+		p.rel.weekday = int(day)
+		if p.rel.weekdayBehavior != 2 {
+			p.rel.weekdayBehavior = 1
+		}
+		return
+	}
+	if (word == "gmt" || word == "utc") && (sc.peek(end) == '+' || sc.peek(end) == '-') && isDigit(sc.peek(end+1)) {
+		sc.offset(end)
+		return
+	}
+
+	// Anything else is taken for a timezone abbreviation of up to six
+	// letters
+	if len(word) > 6 {
+		end = start + 6
+	}
+	sc.pos = end
+	if !sc.haveZone(start) {
+		return
+	}
+	zone, ok := loadDateZone(sc.str[start:end])
+	if !ok {
+		p.errs.fail(start, "The timezone could not be found in the database")
+		return
+	}
+	p.zone = zone
+}
+
+// zoneIdentifier reads a timezone identifier such as Europe/Paris
+func (sc *dateScanner) zoneIdentifier(start int) {
+	p := sc.date
+	end := start
+	for end < len(sc.low) {
+		c := sc.low[end]
+		if !isASCIILetter(c) && !isDigit(c) && c != '/' && c != '_' && c != '-' && c != '+' {
+			break
+		}
+		end++
+	}
+	sc.pos = end
+	if !sc.haveZone(start) {
+		return
+	}
+	zone, ok := loadDateZone(sc.str[start:end])
+	if !ok {
+		p.errs.fail(start, "The timezone could not be found in the database")
+		return
+	}
+	p.zone = zone
+}
+
+// firstLastDayOf reads the rest of "first day of" or "last day of"
+func (sc *dateScanner) firstLastDayOf(word string, pos int) {
+	p := sc.date
+	if after := sc.skipBlanks(pos); sc.wordAt(after) == "of" {
+		pos = after + 2
+	}
+	sc.pos = pos
+	p.haveRelative = true
+	if word == "first" {
+		p.rel.firstLast = dateFirstDayOfMonth
+	} else {
+		p.rel.firstLast = dateLastDayOfMonth
+	}
+}
+
+// backFrontOf reads the hour of "back of 7pm", a quarter past it, or of
+// "front of 7pm", a quarter to it
+func (sc *dateScanner) backFrontOf(word string, start, pos int) {
+	p := sc.date
+	hour, n := sc.digits(pos)
+	pos += n
+	if n > 2 || hour > 24 {
+		sc.pos = pos
+		p.errs.fail(start, "Unexpected character")
+		return
+	}
+	minute := 15
+	if word == "front" {
+		hour, minute = hour-1, 45
+	}
+	after := sc.skipBlanks(pos)
+	meridian, length := sc.meridian(after)
+	if meridian != 0 {
+		pos = after + length
+	}
+	sc.pos = pos
+	p.unhaveTime()
+	if !sc.haveTime(start) {
+		return
+	}
+	if meridian != 0 {
+		hour = applyMeridian(hour, meridian)
+	}
+	p.h, p.i = hour, minute
+}
+
+// weekdayOf sets up "first monday of", "last friday of" and the like
+func (sc *dateScanner) weekdayOf(amount, behavior int, unit dateUnit) {
+	p := sc.date
+	p.haveRelative = true
+	if amount > 0 {
+		p.rel.special = dateSpecialDayOfWeekInMonth
+		sc.setRelative(amount, 1, unit, false)
+	} else {
+		p.rel.special = dateSpecialLastDayOfWeekInMonth
+		sc.setRelative(amount, behavior, unit, false)
+	}
+}
+
+// setRelative adds amount of unit to the relative part. Relative text
+// such as "next monday" resets the time, amounts such as "+1 monday" keep
+// it.
+func (sc *dateScanner) setRelative(amount, behavior int, unit dateUnit, keepTime bool) {
+	p := sc.date
+	p.haveRelative = true
+	switch unit.field {
+	case 'y':
+		p.rel.y += amount * unit.multiplier
+	case 'm':
+		p.rel.m += amount * unit.multiplier
+	case 'd':
+		p.rel.d += amount * unit.multiplier
+	case 'h':
+		p.rel.h += amount * unit.multiplier
+	case 'i':
+		p.rel.i += amount * unit.multiplier
+	case 's':
+		p.rel.s += amount * unit.multiplier
+	case 'u':
+		p.rel.us += amount * unit.multiplier
+	case 'w':
+		if !keepTime {
+			p.unhaveTime()
+		}
+		p.rel.haveWeekday = true
+		if amount > 0 {
+			p.rel.d += (amount - 1) * 7
+		} else {
+			p.rel.d += amount * 7
+		}
+		p.rel.weekday = unit.multiplier
+		p.rel.weekdayBehavior = behavior
+	case 'W':
+		if !keepTime {
+			p.unhaveTime()
+		}
+		p.rel.special = dateSpecialWeekdays
+		p.rel.specialAmount = amount
+	}
+}
+
+func (sc *dateScanner) haveDate(start int) bool {
+	if sc.date.haveDate {
+		sc.date.errs.fail(start, "Double date specification")
+		return false
+	}
+	sc.date.haveDate = true
+	return true
+}
+
+func (sc *dateScanner) haveTime(start int) bool {
+	p := sc.date
+	if p.haveTime {
+		p.errs.fail(start, "Double time specification")
+		return false
+	}
+	p.haveTime = true
+	p.h, p.i, p.s, p.us = 0, 0, 0, 0
+	return true
+}
+
+// haveZone records a timezone. A second one is a warning and any further
+// one an error.
+func (sc *dateScanner) haveZone(start int) bool {
+	p := sc.date
+	p.haveZone++
+	switch p.haveZone {
+	case 1:
+		return true
+	case 2:
+		p.errs.warn(start, "Double timezone specification")
+	default:
+		p.errs.fail(start, "Double timezone specification")
+	}
+	return false
+}
+
+func (p *parsedDate) unhaveTime() {
+	p.haveTime = false
+	p.h, p.i, p.s, p.us = 0, 0, 0, 0
+}
+
+// digits reads the number at pos, returning its value and length
+func (sc *dateScanner) digits(pos int) (int, int) {
+	value, n := 0, 0
+	for pos+n < len(sc.low) && isDigit(sc.low[pos+n]) {
+		if n < 18 {
+			value = value*10 + int(sc.low[pos+n]-'0')
+		}
+		n++
+	}
+	return value, n
+}
+
+// fraction reads the digits of a fraction of a second at pos as
+// microseconds, returning them and the position after the digits
+func (sc *dateScanner) fraction(pos int) (int, int) {
+	micro := 0
+	for i := 0; pos < len(sc.low) && isDigit(sc.low[pos]); i, pos = i+1, pos+1 {
+		if i < 6 {
+			micro = micro*10 + int(sc.low[pos]-'0')
+		}
+		if i < 6 && (pos+1 >= len(sc.low) || !isDigit(sc.low[pos+1])) {
+			for j := i + 1; j < 6; j++ {
+				micro *= 10
+			}
+		}
+	}
+	return micro, pos
+}
+
+// meridian reads am, pm, a.m. or p.m. at pos, which must end the string or
+// be followed by a space. It returns 1 for am and 2 for pm, and the length
+// read.
+func (sc *dateScanner) meridian(pos int) (int, int) {
+	c := sc.peek(pos)
+	if c != 'a' && c != 'p' {
+		return 0, 0
+	}
+	length := 1
+	if sc.peek(pos+length) == '.' {
+		length++
+	}
+	if sc.peek(pos+length) != 'm' {
+		return 0, 0
+	}
+	length++
+	if sc.peek(pos+length) == '.' {
+		length++
+	}
+	if next := sc.peek(pos + length); next != 0 && next != ' ' && next != '\t' && next != ',' {
+		return 0, 0
+	}
+	if c == 'a' {
+		return 1, length
+	}
+	return 2, length
+}
+
+func (sc *dateScanner) peek(pos int) byte {
+	if pos < len(sc.low) {
+		return sc.low[pos]
+	}
+	return 0
+}
+
+// wordAt returns the letters at pos
+func (sc *dateScanner) wordAt(pos int) string {
+	end := pos
+	for end < len(sc.low) && isASCIILetter(sc.low[end]) {
+		end++
+	}
+	return sc.low[pos:end]
+}
+
+func (sc *dateScanner) skipBlanks(pos int) int {
+	for pos < len(sc.low) && (sc.low[pos] == ' ' || sc.low[pos] == '\t') {
+		pos++
+	}
+	return pos
+}
+
+// skipDateSeparators skips the spaces, dots, dashes and commas between the
+// parts of a textual date
+func (sc *dateScanner) skipDateSeparators(pos int) int {
+	for pos < len(sc.low) && strings.IndexByte(" \t.,-", sc.low[pos]) >= 0 {
+		pos++
+	}
+	return pos
+}
+
+func applyMeridian(hour, meridian int) int {
+	hour %= 12
+	if meridian == 2 {
+		hour += 12
+	}
+	return hour
+}
+
+// dayNumberFromISOWeek returns the day of ISO week week of year, counted
+// from January 1st
+func dayNumberFromISOWeek(year, week, day int) int {
+	dow := int(time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC).Weekday())
+	first := -dow
+	if dow > 4 {
+		first = 7 - dow
+	}
+	return first + (week-1)*7 + day
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// dateCivil is a wall clock date and time whose fields may be out of range
+// until normalized
+type dateCivil struct {
+	y, m, d, h, i, s, us int
+}
+
+func (c *dateCivil) normalize() {
+	t := time.Date(c.y, time.Month(c.m), c.d, c.h, c.i, c.s, c.us*1000, time.UTC)
+	c.y, c.d = t.Year(), t.Day()
+	c.m = int(t.Month())
+	c.h, c.i, c.s, c.us = t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1000
+}
+
+func (c *dateCivil) weekday() int {
+	return int(time.Date(c.y, time.Month(c.m), c.d, 0, 0, 0, 0, time.UTC).Weekday())
+}
+
+// resolve applies the parsed date to now, the way strtotime() and the
+// DateTime constructor do: the fields the string does not give come from
+// now, except that a date without a time is at midnight. The result is in
+// the timezone the string names, or else in now's.
+func (p *parsedDate) resolve(now time.Time) (time.Time, *dateZone) {
+	loc := now.Location()
+	if p.zone != nil {
+		loc = p.zone.loc
+	}
+	c := dateCivil{p.y, p.m, p.d, p.h, p.i, p.s, p.us}
+	if p.haveDate && !p.haveTime {
+		c.h, c.i, c.s, c.us = 0, 0, 0, 0
+	}
+	if c.us == unsetField {
+		c.us = 0
+		if c.y == unsetField && c.m == unsetField && c.d == unsetField && c.h == unsetField && c.i == unsetField && c.s == unsetField {
+			c.us = now.Nanosecond() / 1000
+		}
+	}
+	for _, field := range []struct {
+		value    *int
+		fallback int
+	}{
+		{&c.y, now.Year()}, {&c.m, int(now.Month())}, {&c.d, now.Day()},
+		{&c.h, now.Hour()}, {&c.i, now.Minute()}, {&c.s, now.Second()},
+	} {
+		if *field.value == unsetField {
+			*field.value = field.fallback
+		}
+	}
+	return p.adjust(c, loc), p.zone
+}
+
+// modify applies the parsed date to t the way DateTime::modify() does: the
+// fields the string gives replace those of t, and a timezone in the string
+// is ignored unless it comes with an @ timestamp
+func (p *parsedDate) modify(t time.Time, zone *dateZone) (time.Time, *dateZone) {
+	c := dateCivil{t.Year(), int(t.Month()), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond() / 1000}
+	for _, field := range []struct {
+		value  *int
+		parsed int
+	}{{&c.y, p.y}, {&c.m, p.m}, {&c.d, p.d}, {&c.us, p.us}} {
+		if field.parsed != unsetField {
+			*field.value = field.parsed
+		}
+	}
+	if p.h != unsetField {
+		c.h, c.i, c.s = p.h, 0, 0
+		if p.i != unsetField {
+			c.i = p.i
+			if p.s != unsetField {
+				c.s = p.s
+			}
+		}
+	}
+	loc := t.Location()
+	if p.y == 1970 && p.m == 1 && p.d == 1 && p.h == 0 && p.i == 0 && p.s == 0 && p.us == 0 &&
+		p.zone != nil && p.zone.kind == zoneTypeOffset && p.zone.name == "+00:00" {
+		zone = p.zone
+		loc = zone.loc
+	}
+	return p.adjust(c, loc), zone
+}
+
+// adjust applies the relative part to c and returns the time it names in
+// loc
+func (p *parsedDate) adjust(c dateCivil, loc *time.Location) time.Time {
+	rel := p.rel
+	switch rel.special {
+	case dateSpecialDayOfWeekInMonth:
+		c.d = 1
+		c.m += rel.m
+		rel.m = 0
+	case dateSpecialLastDayOfWeekInMonth:
+		c.d = 1
+		c.m += rel.m + 1
+		rel.m = 0
+	}
+	c.normalize()
+
+	if rel.haveWeekday {
+		c.d += weekdayDifference(c.weekday(), rel)
+	}
+	c.normalize()
+
+	c.y += rel.y
+	c.m += rel.m
+	c.d += rel.d
+	c.h += rel.h
+	c.i += rel.i
+	c.s += rel.s
+	c.us += rel.us
+	switch rel.firstLast {
+	case dateFirstDayOfMonth:
+		c.d = 1
+	case dateLastDayOfMonth:
+		c.d = 0
+		c.m++
+	}
+	c.normalize()
+
+	if rel.special == dateSpecialWeekdays {
+		c.d += weekdaysDifference(c.weekday(), rel.specialAmount)
+		c.normalize()
+	}
+	return time.Date(c.y, time.Month(c.m), c.d, c.h, c.i, c.s, c.us*1000, loc)
+}
+
+// weekdayDifference returns the days from a day of week dow to the
+// weekday the relative part asks for
+func weekdayDifference(dow int, rel dateRelative) int {
+	weekday := rel.weekday
+	if rel.weekdayBehavior == 2 {
+		// Weeks run from Monday to Sunday
+		if dow == 0 && weekday != 0 {
+			weekday -= 7
+		}
+		if weekday == 0 && dow != 0 {
+			weekday = 7
+		}
+		return weekday - dow
+	}
+	difference := weekday - dow
+	if (rel.d < 0 && difference < 0) || (rel.d >= 0 && difference <= -rel.weekdayBehavior) {
+		difference += 7
+	}
+	return difference
+}
+
+// weekdaysDifference returns the days to move from a day of week dow to
+// skip count weekdays, passing over weekends
+func weekdaysDifference(dow, count int) int {
+	days := count / 5 * 7
+	rem := count % 5
+	if count > 0 {
+		switch {
+		case rem == 0:
+			// Head back to Friday when stopping on a weekend
+			if dow == 0 {
+				days -= 2
+			} else if dow == 6 {
+				days--
+			}
+		case dow == 6:
+			days++
+		case dow+rem > 5:
+			days += 2
+		}
+	} else {
+		switch {
+		case rem == 0:
+			if dow == 6 {
+				days += 2
+			} else if dow == 0 {
+				days++
+			}
+		case dow == 0:
+			days--
+		case dow+rem < 1:
+			days -= 2
+		}
+	}
+	return days + rem
+}
+
+// parseDateString parses a date string relative to now, the way strtotime()
+// and the DateTime constructor read it. The zone is set when the string
+// names one; otherwise the result is in now's timezone.
+func parseDateString(input string, now time.Time) (time.Time, *dateZone, *dateErrors) {
+	parsed := parseDateText(input)
+	if parsed.errs.failed() {
+		return time.Time{}, nil, &parsed.errs
+	}
+	t, zone := parsed.resolve(now)
+	return t, zone, &parsed.errs
+}
+
+// modifyDateString applies a date string to t and its zone the way
+// DateTime::modify() does
+func modifyDateString(input string, t time.Time, zone *dateZone) (time.Time, *dateZone, *dateErrors) {
+	parsed := parseDateText(input)
+	if parsed.errs.failed() {
+		return t, zone, &parsed.errs
+	}
+	t, zone = parsed.modify(t, zone)
+	return t, zone, &parsed.errs
+}
+
+// dateParseResult builds the array date_parse() returns
+func dateParseResult(p *parsedDate) *values.Value {
+	result := values.NewArray()
+	field := func(name string, value int) {
+		if value == unsetField {
+			result.ArraySet(values.NewString(name), values.NewBool(false))
+		} else {
+			result.ArraySet(values.NewString(name), values.NewInt(int64(value)))
+		}
+	}
+	field("year", p.y)
+	field("month", p.m)
+	field("day", p.d)
+	field("hour", p.h)
+	field("minute", p.i)
+	field("second", p.s)
+	if p.us == unsetField {
+		result.ArraySet(values.NewString("fraction"), values.NewBool(false))
+	} else {
+		result.ArraySet(values.NewString("fraction"), values.NewFloat(float64(p.us)/1e6))
+	}
+	p.errs.addTo(result)
+	result.ArraySet(values.NewString("is_localtime"), values.NewBool(p.haveZone > 0))
+
+	if p.haveZone > 0 && p.zone == nil {
+		result.ArraySet(values.NewString("zone_type"), values.NewInt(0))
+	} else if p.haveZone > 0 {
+		result.ArraySet(values.NewString("zone_type"), values.NewInt(int64(p.zone.kind)))
+		_, offset := time.Unix(0, 0).In(p.zone.loc).Zone()
+		switch p.zone.kind {
+		case zoneTypeOffset:
+			result.ArraySet(values.NewString("zone"), values.NewInt(int64(offset)))
+			result.ArraySet(values.NewString("is_dst"), values.NewBool(false))
+		case zoneTypeAbbr:
+			if p.zone.dst {
+				offset -= 3600
+			}
+			result.ArraySet(values.NewString("zone"), values.NewInt(int64(offset)))
+			result.ArraySet(values.NewString("is_dst"), values.NewBool(p.zone.dst))
+			result.ArraySet(values.NewString("tz_abbr"), values.NewString(p.zone.name))
+		case zoneTypeID:
+			result.ArraySet(values.NewString("tz_id"), values.NewString(p.zone.name))
+		}
+	}
+
+	if p.haveRelative {
+		rel := values.NewArray()
+		for _, f := range []struct {
+			name  string
+			value int
+		}{{"year", p.rel.y}, {"month", p.rel.m}, {"day", p.rel.d}, {"hour", p.rel.h}, {"minute", p.rel.i}, {"second", p.rel.s}} {
+			rel.ArraySet(values.NewString(f.name), values.NewInt(int64(f.value)))
+		}
+		if p.rel.haveWeekday {
+			rel.ArraySet(values.NewString("weekday"), values.NewInt(int64(p.rel.weekday)))
+		}
+		if p.rel.special == dateSpecialWeekdays {
+			rel.ArraySet(values.NewString("weekdays"), values.NewInt(int64(p.rel.specialAmount)))
+		}
+		switch p.rel.firstLast {
+		case dateFirstDayOfMonth:
+			rel.ArraySet(values.NewString("first_day_of_month"), values.NewBool(true))
+		case dateLastDayOfMonth:
+			rel.ArraySet(values.NewString("last_day_of_month"), values.NewBool(true))
+		}
+		result.ArraySet(values.NewString("relative"), rel)
+	}
+	return result
+}
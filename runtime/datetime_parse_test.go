@@ -0,0 +1,97 @@
+package runtime
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDateStringFormats tests the relative and absolute formats of
+// strtotime() against a fixed base time
+func TestDateStringFormats(t *testing.T) {
+	// A Monday
+	base := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"last day of next month", "2024-02-29 10:30:00"},
+		{"first monday of january 2025", "2025-01-06 00:00:00"},
+		{"+1 week 2 days", "2024-01-24 10:30:00"},
+		{"tomorrow noon", "2024-01-16 12:00:00"},
+		{"noon tomorrow", "2024-01-16 00:00:00"},
+		{"@1700000000", "2023-11-14 22:13:20"},
+		{"2025W031", "2025-01-13 00:00:00"},
+		{"2025-W03-1", "2025-01-13 00:00:00"},
+		{"next monday", "2024-01-22 00:00:00"},
+		{"monday", "2024-01-15 00:00:00"},
+		{"sunday this week", "2024-01-21 00:00:00"},
+		{"last friday of this month", "2024-01-26 00:00:00"},
+		{"second tuesday of march 2024", "2024-03-12 00:00:00"},
+		{"+3 weekdays", "2024-01-18 10:30:00"},
+		{"-2 weekdays", "2024-01-11 10:30:00"},
+		{"3 days ago", "2024-01-12 10:30:00"},
+		{"+1 month -1 day", "2024-02-14 10:30:00"},
+		{"yesterday 14:00", "2024-01-14 14:00:00"},
+		{"7:30pm", "2024-01-15 19:30:00"},
+		{"back of 7pm", "2024-01-15 19:15:00"},
+		{"front of 7pm", "2024-01-15 18:45:00"},
+		{"tomorrow back of 19", "2024-01-16 19:15:00"},
+		{"back of 12am", "2024-01-15 00:15:00"},
+		{"Jan 15, 2024 3pm", "2024-01-15 15:00:00"},
+		{"15 January 2025", "2025-01-15 00:00:00"},
+		{"01/02/2024", "2024-01-02 00:00:00"},
+		{"15.01.2024", "2024-01-15 00:00:00"},
+		{"20240115T101010", "2024-01-15 10:10:10"},
+		{"2024-02-30", "2024-03-01 00:00:00"},
+		{"2024-01-15T08:00:00+02:00", "2024-01-15 06:00:00"},
+		{"2024-01-15 08:00 EST", "2024-01-15 13:00:00"},
+		{"2024-01-15 08:00 Europe/Paris", "2024-01-15 07:00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, _, errs := parseDateString(tt.input, base)
+			if errs.failed() {
+				t.Fatalf("unexpected errors: %v", errs.errors[0].msg)
+			}
+			if got := result.UTC().Format("2006-01-02 15:04:05"); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestDateStringErrors tests the warnings and errors date_parse() reports
+func TestDateStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		warnings map[int]string
+		errors   map[int]string
+	}{
+		{"", nil, map[int]string{0: "Empty string"}},
+		{"asdfasdf", map[int]string{6: "Double timezone specification"}, map[int]string{0: "The timezone could not be found in the database"}},
+		{"2024-02-30", map[int]string{11: "The parsed date was invalid"}, nil},
+		{"2024-01-01 2024-01-02", nil, map[int]string{11: "Double date specification"}},
+		{"10:00 11:00", nil, map[int]string{6: "Double time specification"}},
+		{"10:00 #", nil, map[int]string{6: "Unexpected character"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			parsed := parseDateText(tt.input)
+			check := func(kind string, got []*dateParseError, expected map[int]string) {
+				if len(got) != len(expected) {
+					t.Fatalf("expected %d %s, got %d", len(expected), kind, len(got))
+				}
+				for _, e := range got {
+					if expected[e.pos] != e.msg {
+						t.Errorf("unexpected %s at %d: %s", kind, e.pos, e.msg)
+					}
+				}
+			}
+			check("warnings", parsed.errs.warnings, tt.warnings)
+			check("errors", parsed.errs.errors, tt.errors)
+		})
+	}
+}
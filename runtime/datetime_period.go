@@ -0,0 +1,512 @@
+package runtime
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// DatePeriod options
+const (
+	datePeriodExcludeStartDate = 1
+	datePeriodIncludeEndDate   = 2
+)
+
+func getDateIntervalClass() *registry.ClassDescriptor {
+	methods := map[string]dateMethodFunc{
+		"__construct": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			spec := dateArg(args, 0).ToString()
+			interval, err := parseISOInterval(spec)
+			if err != nil {
+				return throwDateError(ctx, "Exception", "DateInterval::__construct(): Unknown or bad format (%s)", spec)
+			}
+			setDateIntervalState(this.Data.(*values.Object), interval)
+			return values.NewNull(), nil
+		},
+		"__wakeup": func(_ registry.BuiltinCallContext, _ *values.Value, _ []*values.Value) (*values.Value, error) {
+			return values.NewNull(), nil
+		},
+		"__serialize": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return objectPropertiesArray(this), nil
+		},
+		"__unserialize": func(_ registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			setObjectPropertiesFromArray(this, dateArg(args, 0))
+			return values.NewNull(), nil
+		},
+		"format": func(_ registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			interval := dateIntervalState(this.Data.(*values.Object))
+			return values.NewString(formatInterval(dateArg(args, 0).ToString(), interval)), nil
+		},
+	}
+
+	staticMethods := map[string]dateMethodFunc{
+		"__set_state": func(_ registry.BuiltinCallContext, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			obj := values.NewObject("DateInterval")
+			setDateIntervalState(obj.Data.(*values.Object), &DateIntervalData{TotalDays: -1})
+			setObjectPropertiesFromArray(obj, dateArg(args, 0))
+			return obj, nil
+		},
+		"createFromDateString": func(_ registry.BuiltinCallContext, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			return dateIntervalFromString(dateArg(args, 0).ToString()), nil
+		},
+	}
+	return dateClass("DateInterval", nil, methods, staticMethods, nil)
+}
+
+func getDatePeriodClass() *registry.ClassDescriptor {
+	methods := map[string]dateMethodFunc{
+		"__construct": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			return initDatePeriod(ctx, this, args)
+		},
+		"__wakeup": func(_ registry.BuiltinCallContext, _ *values.Value, _ []*values.Value) (*values.Value, error) {
+			return values.NewNull(), nil
+		},
+		"__serialize": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return objectPropertiesArray(this), nil
+		},
+		"__unserialize": func(_ registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			setObjectPropertiesFromArray(this, dateArg(args, 0))
+			return values.NewNull(), nil
+		},
+		"getStartDate": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return copyPeriodDate(this, "start"), nil
+		},
+		"getEndDate": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return copyPeriodDate(this, "end"), nil
+		},
+		"getDateInterval": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return copyPeriodDate(this, "interval"), nil
+		},
+		"getRecurrences": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			props := this.Data.(*values.Object).Properties
+			recurrences := props["recurrences"].ToInt() - boolInt(props["include_start_date"]) - boolInt(props["include_end_date"])
+			if recurrences == 0 {
+				return values.NewNull(), nil
+			}
+			return values.NewInt(recurrences), nil
+		},
+		"getIterator": func(ctx registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			it, err := newDatePeriodIterator(ctx, this)
+			if err != nil {
+				return nil, err
+			}
+			return newInternalIterator(it), nil
+		},
+	}
+
+	staticMethods := map[string]dateMethodFunc{
+		"__set_state": func(_ registry.BuiltinCallContext, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			obj := values.NewObject("DatePeriod")
+			setObjectPropertiesFromArray(obj, dateArg(args, 0))
+			return obj, nil
+		},
+	}
+
+	constants := map[string]*registry.ConstantDescriptor{
+		"EXCLUDE_START_DATE": {Name: "EXCLUDE_START_DATE", Value: values.NewInt(datePeriodExcludeStartDate), Visibility: "public"},
+		"INCLUDE_END_DATE":   {Name: "INCLUDE_END_DATE", Value: values.NewInt(datePeriodIncludeEndDate), Visibility: "public"},
+	}
+	return dateClass("DatePeriod", []string{"IteratorAggregate", "Traversable"}, methods, staticMethods, constants)
+}
+
+// initDatePeriod implements the three forms of the DatePeriod constructor:
+// a start, an interval and a number of recurrences; a start, an interval
+// and an end; or an ISO 8601 repeating interval such as R4/2012-07-01T00:00:00Z/P7D
+func initDatePeriod(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+	var start, interval, end *values.Value
+	var recurrences, options int64
+
+	if first := dateArg(args, 0); !first.IsObject() {
+		iso := first.ToString()
+		var ok bool
+		start, interval, end, recurrences, ok = parseISOPeriod(iso)
+		if !ok {
+			return throwDateError(ctx, "Exception", "DatePeriod::__construct(): Unknown or bad format (%s)", iso)
+		}
+		options = dateArg(args, 1).ToInt()
+	} else {
+		if !isDateInstance(ctx, first, "DateTimeInterface") {
+			return throwDateTypeError(ctx, "DatePeriod::__construct", 1, "start", "DateTimeInterface", first)
+		}
+		interval = dateArg(args, 1)
+		if !isDateInstance(ctx, interval, "DateInterval") {
+			return throwDateTypeError(ctx, "DatePeriod::__construct", 2, "interval", "DateInterval", interval)
+		}
+		start, interval = copyObject(first), copyObject(interval)
+		if third := dateArg(args, 2); third.IsObject() {
+			if !isDateInstance(ctx, third, "DateTimeInterface") {
+				return throwDateTypeError(ctx, "DatePeriod::__construct", 3, "end", "DateTimeInterface", third)
+			}
+			end = copyObject(third)
+		} else {
+			recurrences = third.ToInt()
+		}
+		options = dateArg(args, 3).ToInt()
+	}
+	if end == nil && recurrences < 1 {
+		return throwDateError(ctx, "Exception", "DatePeriod::__construct(): Recurrence count must be greater than 0")
+	}
+
+	includeStart := options&datePeriodExcludeStartDate == 0
+	includeEnd := options&datePeriodIncludeEndDate != 0
+	props := this.Data.(*values.Object).Properties
+	props["start"] = start
+	props["current"] = values.NewNull()
+	props["end"] = values.NewNull()
+	if end != nil {
+		props["end"] = end
+	}
+	props["interval"] = interval
+	props["recurrences"] = values.NewInt(recurrences + int64(boolToInt(includeStart)) + int64(boolToInt(includeEnd)))
+	props["include_start_date"] = values.NewBool(includeStart)
+	props["include_end_date"] = values.NewBool(includeEnd)
+	return values.NewNull(), nil
+}
+
+// parseISOPeriod parses an ISO 8601 repeating interval: Rn, a start date in
+// UTC, an interval and optionally an end date, separated by slashes
+func parseISOPeriod(iso string) (start, interval, end *values.Value, recurrences int64, ok bool) {
+	parts := strings.Split(iso, "/")
+	if len(parts) < 3 || len(parts) > 4 || !strings.HasPrefix(parts[0], "R") {
+		return nil, nil, nil, 0, false
+	}
+	var n int64
+	for _, c := range parts[0][1:] {
+		if c < '0' || c > '9' {
+			return nil, nil, nil, 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	zone := abbreviationDateZone(lookupZoneAbbreviation("z"))
+	parseDate := func(s string) *values.Value {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil
+		}
+		return newDateObject("DateTime", t, zone)
+	}
+	if start = parseDate(parts[1]); start == nil {
+		return nil, nil, nil, 0, false
+	}
+	data, err := parseISOInterval(parts[2])
+	if err != nil {
+		return nil, nil, nil, 0, false
+	}
+	interval = newDateIntervalObject(data)
+	if len(parts) == 4 {
+		if end = parseDate(parts[3]); end == nil {
+			return nil, nil, nil, 0, false
+		}
+	}
+	return start, interval, end, n, true
+}
+
+// datePeriodIterator walks the dates of a DatePeriod
+type datePeriodIterator struct {
+	start       time.Time
+	zone        *dateZone
+	end         *time.Time
+	className   string
+	template    *values.Value
+	interval    *values.Value
+	recurrences int64
+	includeEnd  bool
+	skipStart   bool
+	ctx         registry.BuiltinCallContext
+
+	position int64
+	date     time.Time
+}
+
+func newDatePeriodIterator(ctx registry.BuiltinCallContext, period *values.Value) (*datePeriodIterator, error) {
+	props := period.Data.(*values.Object).Properties
+	startObj := props["start"]
+	if startObj == nil || !startObj.IsObject() {
+		_, err := throwDateError(ctx, "Error", "The DatePeriod object has not been correctly initialized by its constructor")
+		return nil, err
+	}
+	start, zone, err := dateStateOf(ctx, startObj, "DateTimeInterface")
+	if err != nil {
+		return nil, err
+	}
+	it := &datePeriodIterator{
+		start:       start,
+		zone:        zone,
+		className:   startObj.Data.(*values.Object).ClassName,
+		template:    startObj,
+		interval:    props["interval"],
+		recurrences: props["recurrences"].ToInt(),
+		includeEnd:  props["include_end_date"].ToBool(),
+		skipStart:   !props["include_start_date"].ToBool(),
+		ctx:         ctx,
+	}
+	if endObj := props["end"]; endObj != nil && endObj.IsObject() {
+		end, _, err := dateStateOf(ctx, endObj, "DateTimeInterface")
+		if err != nil {
+			return nil, err
+		}
+		it.end = &end
+	}
+	it.rewind()
+	return it, nil
+}
+
+func (it *datePeriodIterator) rewind() {
+	it.position = 0
+	it.date = it.start
+	if it.skipStart {
+		it.advance()
+	}
+}
+
+func (it *datePeriodIterator) advance() {
+	if next, err := addDateInterval(it.ctx, "DatePeriod::getIterator", it.date, it.interval, 1); err == nil {
+		it.date = next
+	}
+}
+
+func (it *datePeriodIterator) valid() bool {
+	if it.end != nil {
+		if it.includeEnd {
+			return !it.date.After(*it.end)
+		}
+		return it.date.Before(*it.end)
+	}
+	return it.position < it.recurrences
+}
+
+func (it *datePeriodIterator) current() *values.Value {
+	if !it.valid() {
+		return values.NewNull()
+	}
+	date := copyObject(it.template)
+	setDateState(date.Data.(*values.Object), it.date, it.zone)
+	return date
+}
+
+func (it *datePeriodIterator) key() *values.Value {
+	if !it.valid() {
+		return values.NewNull()
+	}
+	return values.NewInt(it.position)
+}
+
+func (it *datePeriodIterator) next() {
+	it.position++
+	it.advance()
+}
+
+// copyPeriodDate returns a copy of the start, end or interval of a period,
+// or null when it has none
+func copyPeriodDate(period *values.Value, name string) *values.Value {
+	value := period.Data.(*values.Object).Properties[name]
+	if value == nil || !value.IsObject() {
+		return values.NewNull()
+	}
+	return copyObject(value)
+}
+
+// dateIntervalState reads the fields of a DateInterval object
+func dateIntervalState(obj *values.Object) *DateIntervalData {
+	field := func(name string) int {
+		if v := obj.Properties[name]; v != nil {
+			return int(v.ToInt())
+		}
+		return 0
+	}
+	interval := &DateIntervalData{
+		Years:     field("y"),
+		Months:    field("m"),
+		Days:      field("d"),
+		Hours:     field("h"),
+		Minutes:   field("i"),
+		Seconds:   field("s"),
+		Invert:    field("invert") != 0,
+		TotalDays: -1,
+	}
+	if f := obj.Properties["f"]; f != nil {
+		interval.Microseconds = int(math.Round(f.ToFloat() * 1e6))
+	}
+	if days := obj.Properties["days"]; days != nil && days.Type != values.TypeBool {
+		interval.TotalDays = int(days.ToInt())
+	}
+	return interval
+}
+
+// setDateIntervalState stores the fields of an interval in a DateInterval
+// object's properties
+func setDateIntervalState(obj *values.Object, interval *DateIntervalData) {
+	obj.Properties["y"] = values.NewInt(int64(interval.Years))
+	obj.Properties["m"] = values.NewInt(int64(interval.Months))
+	obj.Properties["d"] = values.NewInt(int64(interval.Days))
+	obj.Properties["h"] = values.NewInt(int64(interval.Hours))
+	obj.Properties["i"] = values.NewInt(int64(interval.Minutes))
+	obj.Properties["s"] = values.NewInt(int64(interval.Seconds))
+	obj.Properties["f"] = values.NewFloat(float64(interval.Microseconds) / 1e6)
+	obj.Properties["invert"] = values.NewInt(int64(boolToInt(interval.Invert)))
+	if interval.TotalDays < 0 {
+		obj.Properties["days"] = values.NewBool(false)
+	} else {
+		obj.Properties["days"] = values.NewInt(int64(interval.TotalDays))
+	}
+	obj.Properties["from_string"] = values.NewBool(false)
+}
+
+func newDateIntervalObject(interval *DateIntervalData) *values.Value {
+	obj := values.NewObject("DateInterval")
+	setDateIntervalState(obj.Data.(*values.Object), interval)
+	return obj
+}
+
+// dateIntervalFromString implements DateInterval::createFromDateString().
+// Plain units such as "3 days" become the fields of the interval; for
+// weekdays and phrases such as "last day of next month" the string is kept
+// and applied as a relative date when the interval is added to a date.
+func dateIntervalFromString(s string) *values.Value {
+	parsed := parseDateText(s)
+	if parsed.errs.failed() {
+		return values.NewBool(false)
+	}
+	if rel := parsed.rel; !rel.haveWeekday && rel.special == 0 && rel.firstLast == 0 {
+		return newDateIntervalObject(&DateIntervalData{
+			Years: rel.y, Months: rel.m, Days: rel.d,
+			Hours: rel.h, Minutes: rel.i, Seconds: rel.s, Microseconds: rel.us,
+			TotalDays: -1,
+		})
+	}
+	obj := newDateIntervalObject(&DateIntervalData{TotalDays: -1})
+	props := obj.Data.(*values.Object).Properties
+	props["from_string"] = values.NewBool(true)
+	props["date_string"] = values.NewString(s)
+	return obj
+}
+
+// addDateInterval adds a DateInterval to t, or subtracts it when sign is
+// -1. Years, months and days move the date on the wall clock; hours,
+// minutes and seconds are elapsed time.
+func addDateInterval(ctx registry.BuiltinCallContext, function string, t time.Time, intervalValue *values.Value, sign int) (time.Time, error) {
+	if !isDateInstance(ctx, intervalValue, "DateInterval") {
+		_, err := throwDateTypeError(ctx, function, 1, "interval", "DateInterval", intervalValue)
+		return t, err
+	}
+	obj := intervalValue.Data.(*values.Object)
+	if fromString := obj.Properties["from_string"]; fromString != nil && fromString.ToBool() {
+		if sign < 0 {
+			// PHP only subtracts intervals made of plain units; the others
+			// leave the date unchanged
+			return t, nil
+		}
+		result, _, _ := modifyDateString(obj.Properties["date_string"].ToString(), t, nil)
+		return result, nil
+	}
+	interval := dateIntervalState(obj)
+	if interval.Invert {
+		sign = -sign
+	}
+	t = t.AddDate(sign*interval.Years, sign*interval.Months, sign*interval.Days)
+	elapsed := time.Duration(interval.Hours)*time.Hour + time.Duration(interval.Minutes)*time.Minute +
+		time.Duration(interval.Seconds)*time.Second + time.Duration(interval.Microseconds)*time.Microsecond
+	return t.Add(time.Duration(sign) * elapsed), nil
+}
+
+// diffDates returns the interval from one to two. Dates in the same
+// timezone are compared on their wall clock, others in UTC.
+func diffDates(one time.Time, oneZone *dateZone, two time.Time, twoZone *dateZone) *DateIntervalData {
+	interval := &DateIntervalData{}
+	if two.Before(one) {
+		one, two = two, one
+		interval.Invert = true
+	}
+	if oneZone.kind == twoZone.kind && oneZone.name == twoZone.name {
+		two = two.In(one.Location())
+	} else {
+		one, two = one.UTC(), two.UTC()
+	}
+
+	y1, m1, d1 := one.Date()
+	y2, m2, d2 := two.Date()
+	interval.Years = y2 - y1
+	interval.Months = int(m2 - m1)
+	interval.Days = d2 - d1
+	interval.Hours = two.Hour() - one.Hour()
+	interval.Minutes = two.Minute() - one.Minute()
+	interval.Seconds = two.Second() - one.Second()
+	interval.Microseconds = two.Nanosecond()/1000 - one.Nanosecond()/1000
+
+	if interval.Microseconds < 0 {
+		interval.Microseconds += 1000000
+		interval.Seconds--
+	}
+	if interval.Seconds < 0 {
+		interval.Seconds += 60
+		interval.Minutes--
+	}
+	if interval.Minutes < 0 {
+		interval.Minutes += 60
+		interval.Hours--
+	}
+	if interval.Hours < 0 {
+		interval.Hours += 24
+		interval.Days--
+	}
+	// Borrowed days come from the months of the earlier date
+	for year, month := y1, m1; interval.Days < 0; month++ {
+		interval.Days += daysInMonth(year, month)
+		interval.Months--
+	}
+	if interval.Months < 0 {
+		interval.Months += 12
+		interval.Years--
+	}
+
+	dayNumber := func(t time.Time) int {
+		return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+	}
+	interval.TotalDays = dayNumber(two) - dayNumber(one)
+	if timeOfDay(two) < timeOfDay(one) {
+		interval.TotalDays--
+	}
+	return interval
+}
+
+// daysInMonth returns the number of days of a month, normalizing months
+// past December into the following years
+func daysInMonth(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+}
+
+// setObjectPropertiesFromArray copies the string keys of arr into the
+// properties of an object, for __set_state() and __unserialize()
+func setObjectPropertiesFromArray(obj, arr *values.Value) {
+	if !arr.IsArray() {
+		return
+	}
+	props := obj.Data.(*values.Object).Properties
+	for key, val := range arr.Data.(*values.Array).All() {
+		if name, ok := key.(string); ok {
+			props[name] = val
+		}
+	}
+}
+
+func boolInt(v *values.Value) int64 {
+	if v != nil && v.ToBool() {
+		return 1
+	}
+	return 0
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
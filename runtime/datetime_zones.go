@@ -0,0 +1,773 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	// The timezone database is compiled in, so timezones work on systems
+	// without zoneinfo files
+	_ "time/tzdata"
+)
+
+// Timezone types, as reported by the timezone_type property of DateTime
+// and DateTimeZone objects
+const (
+	zoneTypeOffset = 1 // a UTC offset such as +02:00
+	zoneTypeAbbr   = 2 // an abbreviation such as EST
+	zoneTypeID     = 3 // an identifier such as Europe/Paris
+)
+
+// dateZone is the timezone of a DateTime or DateTimeZone object
+type dateZone struct {
+	kind int
+	// name is the offset, abbreviation or identifier, as PHP shows it
+	name string
+	loc  *time.Location
+	// dst is set for abbreviations of daylight saving time
+	dst bool
+}
+
+type timezoneLocation struct {
+	name      string
+	country   string
+	latitude  float64
+	longitude float64
+	comments  string
+}
+
+type timezoneAbbreviation struct {
+	abbr   string
+	dst    bool
+	offset int
+	id     string
+}
+
+var (
+	timezoneIDsOnce sync.Once
+	// timezoneIDs maps lowercased identifiers to their canonical spelling
+	timezoneIDs map[string]string
+)
+
+// loadDateZone resolves a timezone the way DateTimeZone's constructor does:
+// a UTC offset, an abbreviation or an identifier, matched case-insensitively
+func loadDateZone(name string) (*dateZone, bool) {
+	if name == "" {
+		return nil, false
+	}
+	if name[0] == '+' || name[0] == '-' {
+		offset, ok := parseZoneOffset(name)
+		if !ok {
+			return nil, false
+		}
+		return offsetDateZone(offset), true
+	}
+	if strings.EqualFold(name, "UTC") {
+		return &dateZone{kind: zoneTypeID, name: "UTC", loc: time.UTC}, true
+	}
+	if abbr := lookupZoneAbbreviation(name); abbr != nil {
+		return abbreviationDateZone(abbr), true
+	}
+	timezoneIDsOnce.Do(func() {
+		timezoneIDs = make(map[string]string, len(timezoneLocations))
+		for _, location := range timezoneLocations {
+			timezoneIDs[strings.ToLower(location.name)] = location.name
+		}
+	})
+	id, ok := timezoneIDs[strings.ToLower(name)]
+	if !ok {
+		// Backward-compatible names such as US/Eastern are not in the
+		// table but are still in the database
+		id = name
+	}
+	loc, err := time.LoadLocation(id)
+	if err != nil || id == "Local" {
+		return nil, false
+	}
+	return &dateZone{kind: zoneTypeID, name: id, loc: loc}, true
+}
+
+// offsetDateZone returns the timezone of a fixed UTC offset in seconds
+func offsetDateZone(offset int) *dateZone {
+	name := formatZoneOffset(offset, true)
+	return &dateZone{kind: zoneTypeOffset, name: name, loc: time.FixedZone(name, offset)}
+}
+
+func abbreviationDateZone(abbr *timezoneAbbreviation) *dateZone {
+	name := strings.ToUpper(abbr.abbr)
+	return &dateZone{kind: zoneTypeAbbr, name: name, loc: time.FixedZone(name, abbr.offset), dst: abbr.dst}
+}
+
+// lookupZoneAbbreviation returns the first entry for abbr, or nil
+func lookupZoneAbbreviation(abbr string) *timezoneAbbreviation {
+	abbr = strings.ToLower(abbr)
+	for i := range timezoneAbbreviations {
+		if timezoneAbbreviations[i].abbr == abbr {
+			return &timezoneAbbreviations[i]
+		}
+	}
+	return nil
+}
+
+// defaultDateZone returns the timezone set by date_default_timezone_set()
+func defaultDateZone() *dateZone {
+	defaultTimezoneMutex.RLock()
+	name := defaultTimezone
+	defaultTimezoneMutex.RUnlock()
+	if zone, ok := loadDateZone(name); ok {
+		return zone
+	}
+	return &dateZone{kind: zoneTypeID, name: "UTC", loc: time.UTC}
+}
+
+// parseZoneOffset parses a UTC offset such as +5, +05, +0530 or +05:30
+// into seconds
+func parseZoneOffset(s string) (int, bool) {
+	if len(s) < 2 || (s[0] != '+' && s[0] != '-') {
+		return 0, false
+	}
+	sign := 1
+	if s[0] == '-' {
+		sign = -1
+	}
+	digits := s[1:]
+	var hours, minutes, seconds int
+	var err error
+	if i := strings.IndexByte(digits, ':'); i >= 0 {
+		parts := strings.Split(digits, ":")
+		if len(parts) > 3 {
+			return 0, false
+		}
+		fields := []*int{&hours, &minutes, &seconds}
+		for j, part := range parts {
+			if len(part) == 0 || len(part) > 2 {
+				return 0, false
+			}
+			if *fields[j], err = strconv.Atoi(part); err != nil {
+				return 0, false
+			}
+		}
+	} else {
+		for _, c := range digits {
+			if c < '0' || c > '9' {
+				return 0, false
+			}
+		}
+		switch len(digits) {
+		case 1, 2:
+			hours, _ = strconv.Atoi(digits)
+		case 3, 4:
+			hours, _ = strconv.Atoi(digits[:len(digits)-2])
+			minutes, _ = strconv.Atoi(digits[len(digits)-2:])
+		case 6:
+			hours, _ = strconv.Atoi(digits[:2])
+			minutes, _ = strconv.Atoi(digits[2:4])
+			seconds, _ = strconv.Atoi(digits[4:])
+		default:
+			return 0, false
+		}
+	}
+	if hours > 99 || minutes > 59 || seconds > 59 {
+		return 0, false
+	}
+	return sign * (hours*3600 + minutes*60 + seconds), true
+}
+
+// formatZoneOffset formats an offset in seconds as +0200, or as +02:00 when
+// colon is set. Seconds are only shown when there are some.
+func formatZoneOffset(offset int, colon bool) string {
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	separator := ""
+	if colon {
+		separator = ":"
+	}
+	s := fmt.Sprintf("%c%02d%s%02d", sign, offset/3600, separator, offset/60%60)
+	if offset%60 != 0 {
+		s += fmt.Sprintf("%s%02d", separator, offset%60)
+	}
+	return s
+}
+
+// timezoneIdentifiers returns the identifiers in the groups of the
+// DateTimeZone constants set in group, or those of a country
+func timezoneIdentifiers(group int64, country string) []string {
+	prefixes := []string{"Africa/", "America/", "Antarctica/", "Arctic/", "Asia/", "Atlantic/", "Australia/", "Europe/", "Indian/", "Pacific/"}
+	var ids []string
+	for _, location := range timezoneLocations {
+		if group == dateTimeZonePerCountry {
+			if location.country == country {
+				ids = append(ids, location.name)
+			}
+			continue
+		}
+		for bit, prefix := range prefixes {
+			if group&(1<<bit) != 0 && strings.HasPrefix(location.name, prefix) {
+				ids = append(ids, location.name)
+				break
+			}
+		}
+	}
+	if group != dateTimeZonePerCountry && group&dateTimeZoneUTC != 0 {
+		ids = append(ids, "UTC")
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// findTimezoneLocation returns the zone.tab entry of an identifier
+func findTimezoneLocation(id string) *timezoneLocation {
+	i := sort.Search(len(timezoneLocations), func(i int) bool { return timezoneLocations[i].name >= id })
+	if i < len(timezoneLocations) && timezoneLocations[i].name == id {
+		return &timezoneLocations[i]
+	}
+	return nil
+}
+
+// zoneTransition is a change of offset or abbreviation in a timezone
+type zoneTransition struct {
+	ts     int64
+	offset int
+	dst    bool
+	abbr   string
+}
+
+// zoneTransitions returns the state of loc at begin followed by its
+// transitions up to end. Transitions after 2037 are only listed when end
+// asks for them explicitly.
+func zoneTransitions(loc *time.Location, begin, end int64) []zoneTransition {
+	// Go cannot represent the whole int64 range of timestamps, so the
+	// lookups are clamped to a range covering every transition
+	const earliest, latest = -1 << 40, 1 << 40
+	lookup := begin
+	if lookup < earliest {
+		lookup = earliest
+	}
+	if end > latest {
+		end = time.Date(2037, time.December, 31, 23, 59, 59, 0, time.UTC).Unix()
+	}
+	t := time.Unix(lookup, 0).In(loc)
+	abbr, offset := t.Zone()
+	transitions := []zoneTransition{{ts: begin, offset: offset, dst: t.IsDST(), abbr: abbr}}
+	for {
+		_, next := t.ZoneBounds()
+		if next.IsZero() || next.Unix() >= end {
+			break
+		}
+		t = next.In(loc)
+		abbr, offset = t.Zone()
+		transitions = append(transitions, zoneTransition{ts: t.Unix(), offset: offset, dst: t.IsDST(), abbr: abbr})
+	}
+	return transitions
+}
+
+// civilFromUnix converts a timestamp to a UTC date and time, for the whole
+// int64 range
+func civilFromUnix(ts int64) (year int64, month, day, hour, minute, second int) {
+	days := ts / 86400
+	secs := ts % 86400
+	if secs < 0 {
+		secs += 86400
+		days--
+	}
+	// Howard Hinnant's civil_from_days
+	z := days + 719468
+	era := z / 146097
+	if z < 0 && z%146097 != 0 {
+		era--
+	}
+	doe := z - era*146097
+	yoe := (doe - doe/1460 + doe/36524 - doe/146096) / 365
+	doy := doe - (365*yoe + yoe/4 - yoe/100)
+	mp := (5*doy + 2) / 153
+	day = int(doy - (153*mp+2)/5 + 1)
+	if mp < 10 {
+		month = int(mp + 3)
+	} else {
+		month = int(mp - 9)
+	}
+	year = yoe + era*400
+	if month <= 2 {
+		year++
+	}
+	return year, month, day, int(secs / 3600), int(secs / 60 % 60), int(secs % 60)
+}
+
+// timezoneLocations lists the canonical timezone identifiers, in order, with
+// their country and location, following the IANA time zone database's
+// zone.tab. They are the identifiers DateTimeZone::listIdentifiers()
+// returns, apart from UTC.
+var timezoneLocations = []timezoneLocation{
+	{"Africa/Abidjan", "CI", 5.31666, -4.03333, ""},
+	{"Africa/Accra", "GH", 5.55, -0.21666, ""},
+	{"Africa/Addis_Ababa", "ET", 9.03333, 38.7, ""},
+	{"Africa/Algiers", "DZ", 36.78333, 3.05, ""},
+	{"Africa/Asmara", "ER", 15.33333, 38.88333, ""},
+	{"Africa/Bamako", "ML", 12.65, -8.0, ""},
+	{"Africa/Bangui", "CF", 4.36666, 18.58333, ""},
+	{"Africa/Banjul", "GM", 13.46666, -16.64999, ""},
+	{"Africa/Bissau", "GW", 11.85, -15.58333, ""},
+	{"Africa/Blantyre", "MW", -15.78333, 35.0, ""},
+	{"Africa/Brazzaville", "CG", -4.26666, 15.28333, ""},
+	{"Africa/Bujumbura", "BI", -3.38333, 29.36666, ""},
+	{"Africa/Cairo", "EG", 30.05, 31.25, ""},
+	{"Africa/Casablanca", "MA", 33.65, -7.58333, ""},
+	{"Africa/Ceuta", "ES", 35.88333, -5.31666, "Ceuta, Melilla"},
+	{"Africa/Conakry", "GN", 9.51666, -13.71666, ""},
+	{"Africa/Dakar", "SN", 14.66666, -17.43333, ""},
+	{"Africa/Dar_es_Salaam", "TZ", -6.8, 39.28333, ""},
+	{"Africa/Djibouti", "DJ", 11.6, 43.15, ""},
+	{"Africa/Douala", "CM", 4.05, 9.69999, ""},
+	{"Africa/El_Aaiun", "EH", 27.15, -13.2, ""},
+	{"Africa/Freetown", "SL", 8.5, -13.25, ""},
+	{"Africa/Gaborone", "BW", -24.65, 25.91666, ""},
+	{"Africa/Harare", "ZW", -17.83333, 31.05, ""},
+	{"Africa/Johannesburg", "ZA", -26.25, 28.0, ""},
+	{"Africa/Juba", "SS", 4.84999, 31.61666, ""},
+	{"Africa/Kampala", "UG", 0.31666, 32.41666, ""},
+	{"Africa/Khartoum", "SD", 15.6, 32.53333, ""},
+	{"Africa/Kigali", "RW", -1.95, 30.06666, ""},
+	{"Africa/Kinshasa", "CD", -4.3, 15.3, "Dem. Rep. of Congo (west)"},
+	{"Africa/Lagos", "NG", 6.45, 3.4, ""},
+	{"Africa/Libreville", "GA", 0.38333, 9.44999, ""},
+	{"Africa/Lome", "TG", 6.13333, 1.21666, ""},
+	{"Africa/Luanda", "AO", -8.8, 13.23333, ""},
+	{"Africa/Lubumbashi", "CD", -11.66666, 27.46666, "Dem. Rep. of Congo (east)"},
+	{"Africa/Lusaka", "ZM", -15.41666, 28.28333, ""},
+	{"Africa/Malabo", "GQ", 3.75, 8.78333, ""},
+	{"Africa/Maputo", "MZ", -25.96666, 32.58333, ""},
+	{"Africa/Maseru", "LS", -29.46666, 27.5, ""},
+	{"Africa/Mbabane", "SZ", -26.3, 31.1, ""},
+	{"Africa/Mogadishu", "SO", 2.06666, 45.36666, ""},
+	{"Africa/Monrovia", "LR", 6.3, -10.78333, ""},
+	{"Africa/Nairobi", "KE", -1.28333, 36.81666, ""},
+	{"Africa/Ndjamena", "TD", 12.11666, 15.05, ""},
+	{"Africa/Niamey", "NE", 13.51666, 2.11666, ""},
+	{"Africa/Nouakchott", "MR", 18.1, -15.95, ""},
+	{"Africa/Ouagadougou", "BF", 12.36666, -1.51666, ""},
+	{"Africa/Porto-Novo", "BJ", 6.48333, 2.61666, ""},
+	{"Africa/Sao_Tome", "ST", 0.33333, 6.73333, ""},
+	{"Africa/Tripoli", "LY", 32.9, 13.18333, ""},
+	{"Africa/Tunis", "TN", 36.79999, 10.18333, ""},
+	{"Africa/Windhoek", "NA", -22.56666, 17.1, ""},
+	{"America/Adak", "US", 51.88, -176.65805, "Alaska - western Aleutians"},
+	{"America/Anchorage", "US", 61.21805, -149.90027, "Alaska (most areas)"},
+	{"America/Anguilla", "AI", 18.2, -63.06666, ""},
+	{"America/Antigua", "AG", 17.05, -61.8, ""},
+	{"America/Araguaina", "BR", -7.2, -48.2, "Tocantins"},
+	{"America/Argentina/Buenos_Aires", "AR", -34.6, -58.45, "Buenos Aires (BA, CF)"},
+	{"America/Argentina/Catamarca", "AR", -28.46666, -65.78333, "Catamarca (CT), Chubut (CH)"},
+	{"America/Argentina/Cordoba", "AR", -31.4, -64.18333, "Argentina (most areas: CB, CC, CN, ER, FM, MN, SE, SF)"},
+	{"America/Argentina/Jujuy", "AR", -24.18333, -65.3, "Jujuy (JY)"},
+	{"America/Argentina/La_Rioja", "AR", -29.43333, -66.84999, "La Rioja (LR)"},
+	{"America/Argentina/Mendoza", "AR", -32.88333, -68.81666, "Mendoza (MZ)"},
+	{"America/Argentina/Rio_Gallegos", "AR", -51.63333, -69.21666, "Santa Cruz (SC)"},
+	{"America/Argentina/Salta", "AR", -24.78333, -65.41666, "Salta (SA, LP, NQ, RN)"},
+	{"America/Argentina/San_Juan", "AR", -31.53333, -68.51666, "San Juan (SJ)"},
+	{"America/Argentina/San_Luis", "AR", -33.31666, -66.34999, "San Luis (SL)"},
+	{"America/Argentina/Tucuman", "AR", -26.81666, -65.21666, "Tucuman (TM)"},
+	{"America/Argentina/Ushuaia", "AR", -54.8, -68.3, "Tierra del Fuego (TF)"},
+	{"America/Aruba", "AW", 12.5, -69.96666, ""},
+	{"America/Asuncion", "PY", -25.26666, -57.66666, ""},
+	{"America/Atikokan", "CA", 48.75861, -91.62166, "EST - ON (Atikokan), NU (Coral H)"},
+	{"America/Bahia", "BR", -12.98333, -38.51666, "Bahia"},
+	{"America/Bahia_Banderas", "MX", 20.8, -105.25, "Bahia de Banderas"},
+	{"America/Barbados", "BB", 13.1, -59.61666, ""},
+	{"America/Belem", "BR", -1.45, -48.48333, "Para (east), Amapa"},
+	{"America/Belize", "BZ", 17.5, -88.2, ""},
+	{"America/Blanc-Sablon", "CA", 51.41666, -57.11666, "AST - QC (Lower North Shore)"},
+	{"America/Boa_Vista", "BR", 2.81666, -60.66666, "Roraima"},
+	{"America/Bogota", "CO", 4.59999, -74.08333, ""},
+	{"America/Boise", "US", 43.61361, -116.2025, "Mountain - ID (south), OR (east)"},
+	{"America/Cambridge_Bay", "CA", 69.11388, -105.05277, "Mountain - NU (west)"},
+	{"America/Campo_Grande", "BR", -20.45, -54.61666, "Mato Grosso do Sul"},
+	{"America/Cancun", "MX", 21.08333, -86.76666, "Quintana Roo"},
+	{"America/Caracas", "VE", 10.5, -66.93333, ""},
+	{"America/Cayenne", "GF", 4.93333, -52.33333, ""},
+	{"America/Cayman", "KY", 19.3, -81.38333, ""},
+	{"America/Chicago", "US", 41.85, -87.65, "Central (most areas)"},
+	{"America/Chihuahua", "MX", 28.63333, -106.08333, "Chihuahua (most areas)"},
+	{"America/Ciudad_Juarez", "MX", 31.73333, -106.48333, "Chihuahua (US border - west)"},
+	{"America/Costa_Rica", "CR", 9.93333, -84.08333, ""},
+	{"America/Coyhaique", "CL", -45.56666, -72.06666, "Aysen Region"},
+	{"America/Creston", "CA", 49.1, -116.51666, "MST - BC (Creston)"},
+	{"America/Cuiaba", "BR", -15.58333, -56.08333, "Mato Grosso"},
+	{"America/Curacao", "CW", 12.18333, -69.0, ""},
+	{"America/Danmarkshavn", "GL", 76.76666, -18.66666, "National Park (east coast)"},
+	{"America/Dawson", "CA", 64.06666, -139.41666, "MST - Yukon (west)"},
+	{"America/Dawson_Creek", "CA", 55.76666, -120.23333, "MST - BC (Dawson Cr, Ft St John)"},
+	{"America/Denver", "US", 39.73916, -104.98416, "Mountain (most areas)"},
+	{"America/Detroit", "US", 42.33138, -83.04583, "Eastern - MI (most areas)"},
+	{"America/Dominica", "DM", 15.3, -61.4, ""},
+	{"America/Edmonton", "CA", 53.55, -113.46666, "Mountain - AB, BC(E), NT(E), SK(W)"},
+	{"America/Eirunepe", "BR", -6.66666, -69.86666, "Amazonas (west)"},
+	{"America/El_Salvador", "SV", 13.7, -89.2, ""},
+	{"America/Fort_Nelson", "CA", 58.8, -122.7, "MST - BC (Ft Nelson)"},
+	{"America/Fortaleza", "BR", -3.71666, -38.5, "Brazil (northeast: MA, PI, CE, RN, PB)"},
+	{"America/Glace_Bay", "CA", 46.2, -59.95, "Atlantic - NS (Cape Breton)"},
+	{"America/Goose_Bay", "CA", 53.33333, -60.41666, "Atlantic - Labrador (most areas)"},
+	{"America/Grand_Turk", "TC", 21.46666, -71.13333, ""},
+	{"America/Grenada", "GD", 12.05, -61.75, ""},
+	{"America/Guadeloupe", "GP", 16.23333, -61.53333, ""},
+	{"America/Guatemala", "GT", 14.63333, -90.51666, ""},
+	{"America/Guayaquil", "EC", -2.16666, -79.83333, "Ecuador (mainland)"},
+	{"America/Guyana", "GY", 6.8, -58.16666, ""},
+	{"America/Halifax", "CA", 44.65, -63.6, "Atlantic - NS (most areas), PE"},
+	{"America/Havana", "CU", 23.13333, -82.36666, ""},
+	{"America/Hermosillo", "MX", 29.06666, -110.96666, "Sonora"},
+	{"America/Indiana/Indianapolis", "US", 39.76833, -86.15805, "Eastern - IN (most areas)"},
+	{"America/Indiana/Knox", "US", 41.29583, -86.625, "Central - IN (Starke)"},
+	{"America/Indiana/Marengo", "US", 38.37555, -86.34472, "Eastern - IN (Crawford)"},
+	{"America/Indiana/Petersburg", "US", 38.49194, -87.27861, "Eastern - IN (Pike)"},
+	{"America/Indiana/Tell_City", "US", 37.95305, -86.76138, "Central - IN (Perry)"},
+	{"America/Indiana/Vevay", "US", 38.74777, -85.06722, "Eastern - IN (Switzerland)"},
+	{"America/Indiana/Vincennes", "US", 38.67722, -87.52861, "Eastern - IN (Da, Du, K, Mn)"},
+	{"America/Indiana/Winamac", "US", 41.05138, -86.60305, "Eastern - IN (Pulaski)"},
+	{"America/Inuvik", "CA", 68.34972, -133.71666, "Mountain - NT (west)"},
+	{"America/Iqaluit", "CA", 63.73333, -68.46666, "Eastern - NU (most areas)"},
+	{"America/Jamaica", "JM", 17.96805, -76.79333, ""},
+	{"America/Juneau", "US", 58.30194, -134.41972, "Alaska - Juneau area"},
+	{"America/Kentucky/Louisville", "US", 38.25416, -85.75944, "Eastern - KY (Louisville area)"},
+	{"America/Kentucky/Monticello", "US", 36.82972, -84.84916, "Eastern - KY (Wayne)"},
+	{"America/Kralendijk", "BQ", 12.15083, -68.27666, ""},
+	{"America/La_Paz", "BO", -16.5, -68.15, ""},
+	{"America/Lima", "PE", -12.05, -77.05, ""},
+	{"America/Los_Angeles", "US", 34.05222, -118.24277, "Pacific"},
+	{"America/Lower_Princes", "SX", 18.05138, -63.04722, ""},
+	{"America/Maceio", "BR", -9.66666, -35.71666, "Alagoas, Sergipe"},
+	{"America/Managua", "NI", 12.15, -86.28333, ""},
+	{"America/Manaus", "BR", -3.13333, -60.01666, "Amazonas (east)"},
+	{"America/Marigot", "MF", 18.06666, -63.08333, ""},
+	{"America/Martinique", "MQ", 14.6, -61.08333, ""},
+	{"America/Matamoros", "MX", 25.83333, -97.5, "Coahuila, Nuevo Leon, Tamaulipas (US border)"},
+	{"America/Mazatlan", "MX", 23.21666, -106.41666, "Baja California Sur, Nayarit (most areas), Sinaloa"},
+	{"America/Menominee", "US", 45.10777, -87.61416, "Central - MI (Wisconsin border)"},
+	{"America/Merida", "MX", 20.96666, -89.61666, "Campeche, Yucatan"},
+	{"America/Metlakatla", "US", 55.12694, -131.57638, "Alaska - Annette Island"},
+	{"America/Mexico_City", "MX", 19.39999, -99.15, "Central Mexico"},
+	{"America/Miquelon", "PM", 47.05, -56.33333, ""},
+	{"America/Moncton", "CA", 46.1, -64.78333, "Atlantic - New Brunswick"},
+	{"America/Monterrey", "MX", 25.66666, -100.31666, "Durango; Coahuila, Nuevo Leon, Tamaulipas (most areas)"},
+	{"America/Montevideo", "UY", -34.90916, -56.2125, ""},
+	{"America/Montserrat", "MS", 16.71666, -62.21666, ""},
+	{"America/Nassau", "BS", 25.08333, -77.34999, ""},
+	{"America/New_York", "US", 40.71416, -74.00638, "Eastern (most areas)"},
+	{"America/Nome", "US", 64.50111, -165.40638, "Alaska (west)"},
+	{"America/Noronha", "BR", -3.85, -32.41666, "Atlantic islands"},
+	{"America/North_Dakota/Beulah", "US", 47.26416, -101.77777, "Central - ND (Mercer)"},
+	{"America/North_Dakota/Center", "US", 47.11638, -101.29916, "Central - ND (Oliver)"},
+	{"America/North_Dakota/New_Salem", "US", 46.845, -101.41083, "Central - ND (Morton rural)"},
+	{"America/Nuuk", "GL", 64.18333, -51.73333, "most of Greenland"},
+	{"America/Ojinaga", "MX", 29.56666, -104.41666, "Chihuahua (US border - east)"},
+	{"America/Panama", "PA", 8.96666, -79.53333, ""},
+	{"America/Paramaribo", "SR", 5.83333, -55.16666, ""},
+	{"America/Phoenix", "US", 33.44833, -112.07333, "MST - AZ (except Navajo)"},
+	{"America/Port-au-Prince", "HT", 18.53333, -72.33333, ""},
+	{"America/Port_of_Spain", "TT", 10.65, -61.51666, ""},
+	{"America/Porto_Velho", "BR", -8.76666, -63.9, "Rondonia"},
+	{"America/Puerto_Rico", "PR", 18.46833, -66.10611, ""},
+	{"America/Punta_Arenas", "CL", -53.15, -70.91666, "Magallanes Region"},
+	{"America/Rankin_Inlet", "CA", 62.81666, -92.08305, "Central - NU (central)"},
+	{"America/Recife", "BR", -8.05, -34.9, "Pernambuco"},
+	{"America/Regina", "CA", 50.4, -104.65, "CST - SK (most areas)"},
+	{"America/Resolute", "CA", 74.69555, -94.82916, "Central - NU (Resolute)"},
+	{"America/Rio_Branco", "BR", -9.96666, -67.8, "Acre"},
+	{"America/Santarem", "BR", -2.43333, -54.86666, "Para (west)"},
+	{"America/Santiago", "CL", -33.45, -70.66666, "most of Chile"},
+	{"America/Santo_Domingo", "DO", 18.46666, -69.9, ""},
+	{"America/Sao_Paulo", "BR", -23.53333, -46.61666, "Brazil (southeast: GO, DF, MG, ES, RJ, SP, PR, SC, RS)"},
+	{"America/Scoresbysund", "GL", 70.48333, -21.96666, "Scoresbysund/Ittoqqortoormiit"},
+	{"America/Sitka", "US", 57.17638, -135.30194, "Alaska - Sitka area"},
+	{"America/St_Barthelemy", "BL", 17.88333, -62.85, ""},
+	{"America/St_Johns", "CA", 47.56666, -52.71666, "Newfoundland, Labrador (SE)"},
+	{"America/St_Kitts", "KN", 17.3, -62.71666, ""},
+	{"America/St_Lucia", "LC", 14.01666, -61.0, ""},
+	{"America/St_Thomas", "VI", 18.35, -64.93333, ""},
+	{"America/St_Vincent", "VC", 13.15, -61.23333, ""},
+	{"America/Swift_Current", "CA", 50.28333, -107.83333, "CST - SK (midwest)"},
+	{"America/Tegucigalpa", "HN", 14.1, -87.21666, ""},
+	{"America/Thule", "GL", 76.56666, -68.78333, "Thule/Pituffik"},
+	{"America/Tijuana", "MX", 32.53333, -117.01666, "Baja California"},
+	{"America/Toronto", "CA", 43.65, -79.38333, "Eastern - ON & QC (most areas)"},
+	{"America/Tortola", "VG", 18.45, -64.61666, ""},
+	{"America/Vancouver", "CA", 49.26666, -123.11666, "Pacific - BC (most areas)"},
+	{"America/Whitehorse", "CA", 60.71666, -135.05, "MST - Yukon (east)"},
+	{"America/Winnipeg", "CA", 49.88333, -97.15, "Central - ON (west), Manitoba"},
+	{"America/Yakutat", "US", 59.54694, -139.72722, "Alaska - Yakutat"},
+	{"Antarctica/Casey", "AQ", -66.28333, 110.51666, "Casey"},
+	{"Antarctica/Davis", "AQ", -68.58333, 77.96666, "Davis"},
+	{"Antarctica/DumontDUrville", "AQ", -66.66666, 140.01666, "Dumont-d'Urville"},
+	{"Antarctica/Macquarie", "AU", -54.5, 158.94999, "Macquarie Island"},
+	{"Antarctica/Mawson", "AQ", -67.59999, 62.88333, "Mawson"},
+	{"Antarctica/McMurdo", "AQ", -77.83333, 166.6, "New Zealand time - McMurdo, South Pole"},
+	{"Antarctica/Palmer", "AQ", -64.8, -64.09999, "Palmer"},
+	{"Antarctica/Rothera", "AQ", -67.56666, -68.13333, "Rothera"},
+	{"Antarctica/Syowa", "AQ", -69.00611, 39.59, "Syowa"},
+	{"Antarctica/Troll", "AQ", -72.01138, 2.53499, "Troll"},
+	{"Antarctica/Vostok", "AQ", -78.4, 106.9, "Vostok"},
+	{"Arctic/Longyearbyen", "SJ", 78.0, 16.0, ""},
+	{"Asia/Aden", "YE", 12.75, 45.2, ""},
+	{"Asia/Almaty", "KZ", 43.25, 76.95, "most of Kazakhstan"},
+	{"Asia/Amman", "JO", 31.95, 35.93333, ""},
+	{"Asia/Anadyr", "RU", 64.75, 177.48333, "MSK+09 - Bering Sea"},
+	{"Asia/Aqtau", "KZ", 44.51666, 50.26666, "Mangghystau/Mankistau"},
+	{"Asia/Aqtobe", "KZ", 50.28333, 57.16666, "Aqtobe/Aktobe"},
+	{"Asia/Ashgabat", "TM", 37.95, 58.38333, ""},
+	{"Asia/Atyrau", "KZ", 47.11666, 51.93333, "Atyrau/Atirau/Gur'yev"},
+	{"Asia/Baghdad", "IQ", 33.35, 44.41666, ""},
+	{"Asia/Bahrain", "BH", 26.38333, 50.58333, ""},
+	{"Asia/Baku", "AZ", 40.38333, 49.85, ""},
+	{"Asia/Bangkok", "TH", 13.75, 100.51666, ""},
+	{"Asia/Barnaul", "RU", 53.36666, 83.75, "MSK+04 - Altai"},
+	{"Asia/Beirut", "LB", 33.88333, 35.5, ""},
+	{"Asia/Bishkek", "KG", 42.9, 74.59999, ""},
+	{"Asia/Brunei", "BN", 4.93333, 114.91666, ""},
+	{"Asia/Chita", "RU", 52.05, 113.46666, "MSK+06 - Zabaykalsky"},
+	{"Asia/Colombo", "LK", 6.93333, 79.84999, ""},
+	{"Asia/Damascus", "SY", 33.5, 36.29999, ""},
+	{"Asia/Dhaka", "BD", 23.71666, 90.41666, ""},
+	{"Asia/Dili", "TL", -8.55, 125.58333, ""},
+	{"Asia/Dubai", "AE", 25.3, 55.3, ""},
+	{"Asia/Dushanbe", "TJ", 38.58333, 68.8, ""},
+	{"Asia/Famagusta", "CY", 35.11666, 33.95, "Northern Cyprus"},
+	{"Asia/Gaza", "PS", 31.5, 34.46666, "Gaza Strip"},
+	{"Asia/Hebron", "PS", 31.53333, 35.095, "West Bank"},
+	{"Asia/Ho_Chi_Minh", "VN", 10.75, 106.66666, ""},
+	{"Asia/Hong_Kong", "HK", 22.28333, 114.15, ""},
+	{"Asia/Hovd", "MN", 48.01666, 91.65, "Bayan-Olgii, Hovd, Uvs"},
+	{"Asia/Irkutsk", "RU", 52.26666, 104.33333, "MSK+05 - Irkutsk, Buryatia"},
+	{"Asia/Jakarta", "ID", -6.16666, 106.8, "Java, Sumatra"},
+	{"Asia/Jayapura", "ID", -2.53333, 140.69999, "New Guinea (West Papua / Irian Jaya), Malukus/Moluccas"},
+	{"Asia/Jerusalem", "IL", 31.78055, 35.22388, ""},
+	{"Asia/Kabul", "AF", 34.51666, 69.2, ""},
+	{"Asia/Kamchatka", "RU", 53.01666, 158.65, "MSK+09 - Kamchatka"},
+	{"Asia/Karachi", "PK", 24.86666, 67.05, ""},
+	{"Asia/Kathmandu", "NP", 27.71666, 85.31666, ""},
+	{"Asia/Khandyga", "RU", 62.65638, 135.55388, "MSK+06 - Tomponsky, Ust-Maysky"},
+	{"Asia/Kolkata", "IN", 22.53333, 88.36666, ""},
+	{"Asia/Krasnoyarsk", "RU", 56.01666, 92.83333, "MSK+04 - Krasnoyarsk area"},
+	{"Asia/Kuala_Lumpur", "MY", 3.16666, 101.7, "Malaysia (peninsula)"},
+	{"Asia/Kuching", "MY", 1.55, 110.33333, "Sabah, Sarawak"},
+	{"Asia/Kuwait", "KW", 29.33333, 47.98333, ""},
+	{"Asia/Macau", "MO", 22.19722, 113.54166, ""},
+	{"Asia/Magadan", "RU", 59.56666, 150.8, "MSK+08 - Magadan"},
+	{"Asia/Makassar", "ID", -5.11666, 119.4, "Borneo (east, south), Sulawesi/Celebes, Bali, Nusa Tengarra, Timor (west)"},
+	{"Asia/Manila", "PH", 14.58666, 120.96777, ""},
+	{"Asia/Muscat", "OM", 23.6, 58.58333, ""},
+	{"Asia/Nicosia", "CY", 35.16666, 33.36666, "most of Cyprus"},
+	{"Asia/Novokuznetsk", "RU", 53.75, 87.11666, "MSK+04 - Kemerovo"},
+	{"Asia/Novosibirsk", "RU", 55.03333, 82.91666, "MSK+04 - Novosibirsk"},
+	{"Asia/Omsk", "RU", 55.0, 73.4, "MSK+03 - Omsk"},
+	{"Asia/Oral", "KZ", 51.21666, 51.35, "West Kazakhstan"},
+	{"Asia/Phnom_Penh", "KH", 11.55, 104.91666, ""},
+	{"Asia/Pontianak", "ID", -0.03333, 109.33333, "Borneo (west, central)"},
+	{"Asia/Pyongyang", "KP", 39.01666, 125.75, ""},
+	{"Asia/Qatar", "QA", 25.28333, 51.53333, ""},
+	{"Asia/Qostanay", "KZ", 53.2, 63.61666, "Qostanay/Kostanay/Kustanay"},
+	{"Asia/Qyzylorda", "KZ", 44.8, 65.46666, "Qyzylorda/Kyzylorda/Kzyl-Orda"},
+	{"Asia/Riyadh", "SA", 24.63333, 46.71666, ""},
+	{"Asia/Sakhalin", "RU", 46.96666, 142.69999, "MSK+08 - Sakhalin Island"},
+	{"Asia/Samarkand", "UZ", 39.66666, 66.8, "Uzbekistan (west)"},
+	{"Asia/Seoul", "KR", 37.54999, 126.96666, ""},
+	{"Asia/Shanghai", "CN", 31.23333, 121.46666, "Beijing Time"},
+	{"Asia/Singapore", "SG", 1.28333, 103.85, ""},
+	{"Asia/Srednekolymsk", "RU", 67.46666, 153.71666, "MSK+08 - Sakha (E), N Kuril Is"},
+	{"Asia/Taipei", "TW", 25.05, 121.5, ""},
+	{"Asia/Tashkent", "UZ", 41.33333, 69.3, "Uzbekistan (east)"},
+	{"Asia/Tbilisi", "GE", 41.71666, 44.81666, ""},
+	{"Asia/Tehran", "IR", 35.66666, 51.43333, ""},
+	{"Asia/Thimphu", "BT", 27.46666, 89.65, ""},
+	{"Asia/Tokyo", "JP", 35.65444, 139.74472, ""},
+	{"Asia/Tomsk", "RU", 56.5, 84.96666, "MSK+04 - Tomsk"},
+	{"Asia/Ulaanbaatar", "MN", 47.91666, 106.88333, "most of Mongolia"},
+	{"Asia/Urumqi", "CN", 43.8, 87.58333, "Xinjiang Time"},
+	{"Asia/Ust-Nera", "RU", 64.56027, 143.22666, "MSK+07 - Oymyakonsky"},
+	{"Asia/Vientiane", "LA", 17.96666, 102.6, ""},
+	{"Asia/Vladivostok", "RU", 43.16666, 131.93333, "MSK+07 - Amur River"},
+	{"Asia/Yakutsk", "RU", 62.0, 129.66666, "MSK+06 - Lena River"},
+	{"Asia/Yangon", "MM", 16.78333, 96.16666, ""},
+	{"Asia/Yekaterinburg", "RU", 56.85, 60.6, "MSK+02 - Urals"},
+	{"Asia/Yerevan", "AM", 40.18333, 44.5, ""},
+	{"Atlantic/Azores", "PT", 37.73333, -25.66666, "Azores"},
+	{"Atlantic/Bermuda", "BM", 32.28333, -64.76666, ""},
+	{"Atlantic/Canary", "ES", 28.1, -15.4, "Canary Islands"},
+	{"Atlantic/Cape_Verde", "CV", 14.91666, -23.51666, ""},
+	{"Atlantic/Faroe", "FO", 62.01666, -6.76666, ""},
+	{"Atlantic/Madeira", "PT", 32.63333, -16.89999, "Madeira Islands"},
+	{"Atlantic/Reykjavik", "IS", 64.15, -21.85, ""},
+	{"Atlantic/South_Georgia", "GS", -54.26666, -36.53333, ""},
+	{"Atlantic/St_Helena", "SH", -15.91666, -5.7, ""},
+	{"Atlantic/Stanley", "FK", -51.7, -57.85, ""},
+	{"Australia/Adelaide", "AU", -34.91666, 138.58333, "South Australia"},
+	{"Australia/Brisbane", "AU", -27.46666, 153.03333, "Queensland (most areas)"},
+	{"Australia/Broken_Hill", "AU", -31.95, 141.44999, "New South Wales (Yancowinna)"},
+	{"Australia/Darwin", "AU", -12.46666, 130.83333, "Northern Territory"},
+	{"Australia/Eucla", "AU", -31.71666, 128.86666, "Western Australia (Eucla)"},
+	{"Australia/Hobart", "AU", -42.88333, 147.31666, "Tasmania"},
+	{"Australia/Lindeman", "AU", -20.26666, 149.0, "Queensland (Whitsunday Islands)"},
+	{"Australia/Lord_Howe", "AU", -31.55, 159.08333, "Lord Howe Island"},
+	{"Australia/Melbourne", "AU", -37.81666, 144.96666, "Victoria"},
+	{"Australia/Perth", "AU", -31.95, 115.85, "Western Australia (most areas)"},
+	{"Australia/Sydney", "AU", -33.86666, 151.21666, "New South Wales (most areas)"},
+	{"Europe/Amsterdam", "NL", 52.36666, 4.9, ""},
+	{"Europe/Andorra", "AD", 42.5, 1.51666, ""},
+	{"Europe/Astrakhan", "RU", 46.35, 48.05, "MSK+01 - Astrakhan"},
+	{"Europe/Athens", "GR", 37.96666, 23.71666, ""},
+	{"Europe/Belgrade", "RS", 44.83333, 20.5, ""},
+	{"Europe/Berlin", "DE", 52.5, 13.36666, "most of Germany"},
+	{"Europe/Bratislava", "SK", 48.15, 17.11666, ""},
+	{"Europe/Brussels", "BE", 50.83333, 4.33333, ""},
+	{"Europe/Bucharest", "RO", 44.43333, 26.1, ""},
+	{"Europe/Budapest", "HU", 47.5, 19.08333, ""},
+	{"Europe/Busingen", "DE", 47.7, 8.68333, "Busingen"},
+	{"Europe/Chisinau", "MD", 47.0, 28.83333, ""},
+	{"Europe/Copenhagen", "DK", 55.66666, 12.58333, ""},
+	{"Europe/Dublin", "IE", 53.33333, -6.25, ""},
+	{"Europe/Gibraltar", "GI", 36.13333, -5.35, ""},
+	{"Europe/Guernsey", "GG", 49.45472, -2.53611, ""},
+	{"Europe/Helsinki", "FI", 60.16666, 24.96666, ""},
+	{"Europe/Isle_of_Man", "IM", 54.15, -4.46666, ""},
+	{"Europe/Istanbul", "TR", 41.01666, 28.96666, ""},
+	{"Europe/Jersey", "JE", 49.18361, -2.10666, ""},
+	{"Europe/Kaliningrad", "RU", 54.71666, 20.5, "MSK-01 - Kaliningrad"},
+	{"Europe/Kirov", "RU", 58.6, 49.65, "MSK+00 - Kirov"},
+	{"Europe/Kyiv", "UA", 50.43333, 30.51666, "most of Ukraine"},
+	{"Europe/Lisbon", "PT", 38.71666, -9.13333, "Portugal (mainland)"},
+	{"Europe/Ljubljana", "SI", 46.05, 14.51666, ""},
+	{"Europe/London", "GB", 51.50833, -0.12527, ""},
+	{"Europe/Luxembourg", "LU", 49.6, 6.15, ""},
+	{"Europe/Madrid", "ES", 40.4, -3.68333, "Spain (mainland)"},
+	{"Europe/Malta", "MT", 35.9, 14.51666, ""},
+	{"Europe/Mariehamn", "AX", 60.1, 19.95, ""},
+	{"Europe/Minsk", "BY", 53.9, 27.56666, ""},
+	{"Europe/Monaco", "MC", 43.7, 7.38333, ""},
+	{"Europe/Moscow", "RU", 55.75583, 37.61777, "MSK+00 - Moscow area"},
+	{"Europe/Oslo", "NO", 59.91666, 10.75, ""},
+	{"Europe/Paris", "FR", 48.86666, 2.33333, ""},
+	{"Europe/Podgorica", "ME", 42.43333, 19.26666, ""},
+	{"Europe/Prague", "CZ", 50.08333, 14.43333, ""},
+	{"Europe/Riga", "LV", 56.95, 24.1, ""},
+	{"Europe/Rome", "IT", 41.9, 12.48333, ""},
+	{"Europe/Samara", "RU", 53.2, 50.15, "MSK+01 - Samara, Udmurtia"},
+	{"Europe/San_Marino", "SM", 43.91666, 12.46666, ""},
+	{"Europe/Sarajevo", "BA", 43.86666, 18.41666, ""},
+	{"Europe/Saratov", "RU", 51.56666, 46.03333, "MSK+01 - Saratov"},
+	{"Europe/Simferopol", "UA", 44.95, 34.1, "Crimea"},
+	{"Europe/Skopje", "MK", 41.98333, 21.43333, ""},
+	{"Europe/Sofia", "BG", 42.68333, 23.31666, ""},
+	{"Europe/Stockholm", "SE", 59.33333, 18.05, ""},
+	{"Europe/Tallinn", "EE", 59.41666, 24.75, ""},
+	{"Europe/Tirane", "AL", 41.33333, 19.83333, ""},
+	{"Europe/Ulyanovsk", "RU", 54.33333, 48.4, "MSK+01 - Ulyanovsk"},
+	{"Europe/Vaduz", "LI", 47.15, 9.51666, ""},
+	{"Europe/Vatican", "VA", 41.90222, 12.45305, ""},
+	{"Europe/Vienna", "AT", 48.21666, 16.33333, ""},
+	{"Europe/Vilnius", "LT", 54.68333, 25.31666, ""},
+	{"Europe/Volgograd", "RU", 48.73333, 44.41666, "MSK+00 - Volgograd"},
+	{"Europe/Warsaw", "PL", 52.25, 21.0, ""},
+	{"Europe/Zagreb", "HR", 45.8, 15.96666, ""},
+	{"Europe/Zurich", "CH", 47.38333, 8.53333, ""},
+	{"Indian/Antananarivo", "MG", -18.91666, 47.51666, ""},
+	{"Indian/Chagos", "IO", -7.33333, 72.41666, ""},
+	{"Indian/Christmas", "CX", -10.41666, 105.71666, ""},
+	{"Indian/Cocos", "CC", -12.16666, 96.91666, ""},
+	{"Indian/Comoro", "KM", -11.68333, 43.26666, ""},
+	{"Indian/Kerguelen", "TF", -49.35277, 70.2175, ""},
+	{"Indian/Mahe", "SC", -4.66666, 55.46666, ""},
+	{"Indian/Maldives", "MV", 4.16666, 73.5, ""},
+	{"Indian/Mauritius", "MU", -20.16666, 57.5, ""},
+	{"Indian/Mayotte", "YT", -12.78333, 45.23333, ""},
+	{"Indian/Reunion", "RE", -20.86666, 55.46666, ""},
+	{"Pacific/Apia", "WS", -13.83333, -171.73333, ""},
+	{"Pacific/Auckland", "NZ", -36.86666, 174.76666, "most of New Zealand"},
+	{"Pacific/Bougainville", "PG", -6.21666, 155.56666, "Bougainville"},
+	{"Pacific/Chatham", "NZ", -43.95, -176.55, "Chatham Islands"},
+	{"Pacific/Chuuk", "FM", 7.41666, 151.78333, "Chuuk/Truk, Yap"},
+	{"Pacific/Easter", "CL", -27.15, -109.43333, "Easter Island"},
+	{"Pacific/Efate", "VU", -17.66666, 168.41666, ""},
+	{"Pacific/Fakaofo", "TK", -9.36666, -171.23333, ""},
+	{"Pacific/Fiji", "FJ", -18.13333, 178.41666, ""},
+	{"Pacific/Funafuti", "TV", -8.51666, 179.21666, ""},
+	{"Pacific/Galapagos", "EC", -0.9, -89.6, "Galapagos Islands"},
+	{"Pacific/Gambier", "PF", -23.13333, -134.94999, "Gambier Islands"},
+	{"Pacific/Guadalcanal", "SB", -9.53333, 160.19999, ""},
+	{"Pacific/Guam", "GU", 13.46666, 144.75, ""},
+	{"Pacific/Honolulu", "US", 21.30694, -157.85833, "Hawaii"},
+	{"Pacific/Kanton", "KI", -2.78333, -171.71666, "Phoenix Islands"},
+	{"Pacific/Kiritimati", "KI", 1.86666, -157.33333, "Line Islands"},
+	{"Pacific/Kosrae", "FM", 5.31666, 162.98333, "Kosrae"},
+	{"Pacific/Kwajalein", "MH", 9.08333, 167.33333, "Kwajalein"},
+	{"Pacific/Majuro", "MH", 7.15, 171.2, "most of Marshall Islands"},
+	{"Pacific/Marquesas", "PF", -9.0, -139.5, "Marquesas Islands"},
+	{"Pacific/Midway", "UM", 28.21666, -177.36666, "Midway Islands"},
+	{"Pacific/Nauru", "NR", -0.51666, 166.91666, ""},
+	{"Pacific/Niue", "NU", -19.01666, -169.91666, ""},
+	{"Pacific/Norfolk", "NF", -29.05, 167.96666, ""},
+	{"Pacific/Noumea", "NC", -22.26666, 166.44999, ""},
+	{"Pacific/Pago_Pago", "AS", -14.26666, -170.7, ""},
+	{"Pacific/Palau", "PW", 7.33333, 134.48333, ""},
+	{"Pacific/Pitcairn", "PN", -25.06666, -130.08333, ""},
+	{"Pacific/Pohnpei", "FM", 6.96666, 158.21666, "Pohnpei/Ponape"},
+	{"Pacific/Port_Moresby", "PG", -9.5, 147.16666, "most of Papua New Guinea"},
+	{"Pacific/Rarotonga", "CK", -21.23333, -159.76666, ""},
+	{"Pacific/Saipan", "MP", 15.2, 145.75, ""},
+	{"Pacific/Tahiti", "PF", -17.53333, -149.56666, "Society Islands"},
+	{"Pacific/Tarawa", "KI", 1.41666, 173.0, "Gilbert Islands"},
+	{"Pacific/Tongatapu", "TO", -21.13333, -175.2, ""},
+	{"Pacific/Wake", "UM", 19.28333, 166.61666, "Wake Island"},
+	{"Pacific/Wallis", "WF", -13.3, -176.16666, ""},
+}
+
+// timezoneAbbreviations lists the timezone abbreviations that date strings
+// and DateTimeZone accept, with the identifier each one maps to first. They
+// are what DateTimeZone::listAbbreviations() returns.
+var timezoneAbbreviations = []timezoneAbbreviation{
+	{"acdt", true, 37800, "Australia/Adelaide"},
+	{"acst", false, 34200, "Australia/Adelaide"},
+	{"adt", true, -10800, "America/Halifax"},
+	{"aedt", true, 39600, "Australia/Melbourne"},
+	{"aest", false, 36000, "Australia/Melbourne"},
+	{"akdt", true, -28800, "America/Anchorage"},
+	{"akst", false, -32400, "America/Anchorage"},
+	{"ast", false, -14400, "America/Halifax"},
+	{"awst", false, 28800, "Australia/Perth"},
+	{"bst", true, 3600, "Europe/London"},
+	{"cat", false, 7200, "Africa/Maputo"},
+	{"cdt", true, -18000, "America/Chicago"},
+	{"cest", true, 7200, "Europe/Berlin"},
+	{"cet", false, 3600, "Europe/Berlin"},
+	{"cst", false, -21600, "America/Chicago"},
+	{"eat", false, 10800, "Africa/Nairobi"},
+	{"edt", true, -14400, "America/New_York"},
+	{"eest", true, 10800, "Europe/Helsinki"},
+	{"eet", false, 7200, "Europe/Helsinki"},
+	{"est", false, -18000, "America/New_York"},
+	{"gmt", false, 0, "Europe/London"},
+	{"hdt", true, -32400, "America/Adak"},
+	{"hkt", false, 28800, "Asia/Hong_Kong"},
+	{"hst", false, -36000, "Pacific/Honolulu"},
+	{"idt", true, 10800, "Asia/Jerusalem"},
+	{"ist", false, 7200, "Asia/Jerusalem"},
+	{"jst", false, 32400, "Asia/Tokyo"},
+	{"kst", false, 32400, "Asia/Seoul"},
+	{"mdt", true, -21600, "America/Denver"},
+	{"msk", false, 10800, "Europe/Moscow"},
+	{"mst", false, -25200, "America/Denver"},
+	{"nzdt", true, 46800, "Pacific/Auckland"},
+	{"nzst", false, 43200, "Pacific/Auckland"},
+	{"pdt", true, -25200, "America/Los_Angeles"},
+	{"pkt", false, 18000, "Asia/Karachi"},
+	{"pst", false, -28800, "America/Los_Angeles"},
+	{"sast", false, 7200, "Africa/Johannesburg"},
+	{"utc", false, 0, "UTC"},
+	{"wat", false, 3600, "Africa/Lagos"},
+	{"west", true, 3600, "Europe/Lisbon"},
+	{"wet", false, 0, "Europe/Lisbon"},
+	{"wib", false, 25200, "Asia/Jakarta"},
+	{"z", false, 0, "UTC"},
+}
@@ -1,39 +1,84 @@
 package runtime
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"strings"
 
 	"github.com/wudi/hey/registry"
 	"github.com/wudi/hey/values"
 )
 
-// isSequentialArray checks if a PHP array is sequential (list-like)
-// A sequential array has integer keys starting from 0 with no gaps
-func isSequentialArray(arr *values.Array) bool {
-	if len(arr.Elements) == 0 {
-		return true // Empty arrays are considered sequential
-	}
+// jsonObject is a PHP array converted for json_encode as a JSON object,
+// keeping the order of its keys
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	key   string
+	value interface{}
+}
 
-	// Check if all keys are integers and sequential from 0
-	for i := 0; i < len(arr.Elements); i++ {
-		if _, exists := arr.Elements[int64(i)]; !exists {
-			return false
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, member := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(member.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(member.value)
+		if err != nil {
+			return nil, err
 		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
 	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
 
-	// Check there are no additional non-integer keys
-	for key := range arr.Elements {
-		if _, ok := key.(int64); !ok {
-			return false
+// decodeJSON decodes the next JSON value of dec, with objects as jsonObject
+// so that json_decode keeps the order of their keys
+func decodeJSON(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, jsonMember{key: key.(string), value: value})
 		}
-		intKey := key.(int64)
-		if intKey < 0 || intKey >= int64(len(arr.Elements)) {
-			return false
+		_, err = dec.Token()
+		return object, err
+	case json.Delim('['):
+		list := []interface{}{}
+		for dec.More() {
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
 		}
+		_, err = dec.Token()
+		return list, err
 	}
-
-	return true
+	return token, nil
 }
 
 // phpValueToGoValue converts PHP value to Go value for JSON encoding
@@ -56,25 +101,27 @@ func phpValueToGoValue(val *values.Value) interface{} {
 	case values.TypeArray:
 		arr := val.Data.(*values.Array)
 
-		// Check if this is a sequential array (should be JSON array)
-		if isSequentialArray(arr) {
-			// Convert to slice for JSON array encoding
-			result := make([]interface{}, len(arr.Elements))
-			for i := 0; i < len(arr.Elements); i++ {
-				result[i] = phpValueToGoValue(arr.Elements[int64(i)])
-			}
-			return result
-		} else {
-			// Convert to map for JSON object encoding
-			result := make(map[string]interface{})
-			for key, value := range arr.Elements {
-				keyStr := fmt.Sprintf("%v", key)
-				result[keyStr] = phpValueToGoValue(value)
+		// Lists are JSON arrays, and any other array a JSON object
+		if arr.IsList() {
+			result := make([]interface{}, 0, arr.Len())
+			for _, value := range arr.All() {
+				result = append(result, phpValueToGoValue(value))
 			}
 			return result
 		}
+		result := make(jsonObject, 0, arr.Len())
+		for key, value := range arr.All() {
+			result = append(result, jsonMember{key: fmt.Sprintf("%v", key), value: phpValueToGoValue(value)})
+		}
+		return result
 	case values.TypeObject:
 		obj := val.Data.(*values.Object)
+		if obj.EnumCase {
+			if backing := enumCaseValue(obj); backing != nil {
+				return phpValueToGoValue(backing)
+			}
+			return pureEnumJSON{}
+		}
 		result := make(map[string]interface{})
 		for key, value := range obj.Properties {
 			result[key] = phpValueToGoValue(value)
@@ -102,18 +149,18 @@ func goValueToPhpValue(val interface{}) *values.Value {
 		return values.NewFloat(v)
 	case string:
 		return values.NewString(v)
-	case map[string]interface{}:
+	case jsonObject:
 		arr := values.NewArray()
 		arrData := arr.Data.(*values.Array)
-		for key, value := range v {
-			arrData.Elements[key] = goValueToPhpValue(value)
+		for _, member := range v {
+			arrData.Set(values.NormalizeKey(member.key), goValueToPhpValue(member.value))
 		}
 		return arr
 	case []interface{}:
 		arr := values.NewArray()
 		arrData := arr.Data.(*values.Array)
 		for i, value := range v {
-			arrData.Elements[int64(i)] = goValueToPhpValue(value)
+			arrData.Set(int64(i), goValueToPhpValue(value))
 		}
 		return arr
 	default:
@@ -174,8 +221,13 @@ func GetEncodingFunctions() []*registry.Function {
 					flags = args[3].ToInt()
 				}
 
-				var result interface{}
-				err := json.Unmarshal([]byte(jsonStr), &result)
+				dec := json.NewDecoder(strings.NewReader(jsonStr))
+				result, err := decodeJSON(dec)
+				if err == nil {
+					if _, trailing := dec.Token(); trailing != io.EOF {
+						err = fmt.Errorf("syntax error")
+					}
+				}
 				if err != nil {
 					const JSON_THROW_ON_ERROR = 4194304
 					if (flags & JSON_THROW_ON_ERROR) != 0 {
@@ -0,0 +1,80 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// GetEnumInterfaces returns the interfaces implemented by every enum. The
+// VM provides cases(), from() and tryFrom() for each enum class.
+func GetEnumInterfaces() []*registry.Interface {
+	return []*registry.Interface{
+		{
+			Name: "UnitEnum",
+			Methods: map[string]*registry.InterfaceMethod{
+				"cases": {Name: "cases", Visibility: "public", Parameters: []*registry.Parameter{}, ReturnType: "array"},
+			},
+			Extends: []string{},
+		},
+		{
+			Name: "BackedEnum",
+			Methods: map[string]*registry.InterfaceMethod{
+				"from":    {Name: "from", Visibility: "public", Parameters: []*registry.Parameter{{Name: "value", Type: "int|string"}}, ReturnType: "static"},
+				"tryFrom": {Name: "tryFrom", Visibility: "public", Parameters: []*registry.Parameter{{Name: "value", Type: "int|string"}}, ReturnType: "?static"},
+			},
+			Extends: []string{"UnitEnum"},
+		},
+	}
+}
+
+// errPureEnumJSON is reported by json_encode for enums without a backing
+// value
+var errPureEnumJSON = errors.New("Non-backed enums have no default serialization")
+
+// pureEnumJSON stands in for a pure enum case when converting a value for
+// json_encode and makes the encoding fail
+type pureEnumJSON struct{}
+
+func (pureEnumJSON) MarshalJSON() ([]byte, error) {
+	return nil, errPureEnumJSON
+}
+
+// enumCaseValue returns the backing value of an enum case, or nil for a
+// pure enum case
+func enumCaseValue(obj *values.Object) *values.Value {
+	return obj.Properties["value"]
+}
+
+// serializeEnumCase formats an enum case as E:len:"Class:Case";
+func serializeEnumCase(obj *values.Object) string {
+	ref := obj.ClassName + ":" + obj.Properties["name"].ToString()
+	return fmt.Sprintf("E:%d:\"%s\";", len(ref), ref)
+}
+
+// classConstantLookup is implemented by call contexts that can read class
+// constants, which unserialize needs to restore enum cases
+type classConstantLookup interface {
+	LookupClassConstant(className, name string) (*values.Value, bool)
+}
+
+// unserializeEnumCase restores the case instance named by E:len:"Class:Case";
+// returning false when the case does not exist
+func unserializeEnumCase(ctx registry.BuiltinCallContext, data string) *values.Value {
+	ref, err := unserializeValue("s" + strings.TrimPrefix(data, "E"))
+	if err != nil {
+		return values.NewBool(false)
+	}
+	className, caseName, ok := strings.Cut(ref.ToString(), ":")
+	lookup, canLookup := ctx.(classConstantLookup)
+	if !ok || !canLookup {
+		return values.NewBool(false)
+	}
+	if val, found := lookup.LookupClassConstant(className, caseName); found && val.IsObject() && val.Data.(*values.Object).EnumCase {
+		return val
+	}
+	return values.NewBool(false)
+}
@@ -0,0 +1,144 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// Fiber states as reported by FiberRuntime.FiberStatus
+const (
+	FiberStatusInit       = "init"
+	FiberStatusSuspended  = "suspended"
+	FiberStatusRunning    = "running"
+	FiberStatusTerminated = "terminated"
+)
+
+// FiberRuntime is implemented by the VM's builtin call context. A fiber runs
+// on a call stack of its own, which only the VM can create and switch to.
+type FiberRuntime interface {
+	// NewFiber attaches a callback to a freshly constructed Fiber object.
+	NewFiber(fiber, callback *values.Value) error
+	// StartFiber runs the fiber until it suspends or returns and yields the
+	// value passed to Fiber::suspend(), or null once it has returned.
+	StartFiber(fiber *values.Value, args []*values.Value) (*values.Value, error)
+	// ResumeFiber continues a suspended fiber, making Fiber::suspend()
+	// return value, or throw it when throw is set.
+	ResumeFiber(fiber, value *values.Value, throw bool) (*values.Value, error)
+	// SuspendFiber suspends the running fiber, handing value to its resumer.
+	SuspendFiber(value *values.Value) (*values.Value, error)
+	// FiberReturn returns the value the fiber's callback returned.
+	FiberReturn(fiber *values.Value) (*values.Value, error)
+	// FiberStatus returns one of the FiberStatus constants.
+	FiberStatus(fiber *values.Value) string
+	// CurrentFiber returns the running Fiber object, or null outside fibers.
+	CurrentFiber() *values.Value
+}
+
+// GetFiberClasses returns the Fiber and FiberError class descriptors
+func GetFiberClasses() []*registry.ClassDescriptor {
+	fiberError := createSimpleExceptionClass("FiberError", "Error")
+	fiberError.IsFinal = true
+	return []*registry.ClassDescriptor{getFiberClass(), fiberError}
+}
+
+type fiberMethodFunc func(fibers FiberRuntime, this *values.Value, args []*values.Value) (*values.Value, error)
+
+func getFiberClass() *registry.ClassDescriptor {
+	methods := map[string]fiberMethodFunc{
+		"__construct": func(fibers FiberRuntime, this *values.Value, args []*values.Value) (*values.Value, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("Fiber::__construct() expects exactly 1 argument, 0 given")
+			}
+			return values.NewNull(), fibers.NewFiber(this, args[0])
+		},
+		"start": func(fibers FiberRuntime, this *values.Value, args []*values.Value) (*values.Value, error) {
+			return fibers.StartFiber(this, args)
+		},
+		"resume": func(fibers FiberRuntime, this *values.Value, args []*values.Value) (*values.Value, error) {
+			return fibers.ResumeFiber(this, fiberArg(args), false)
+		},
+		"throw": func(fibers FiberRuntime, this *values.Value, args []*values.Value) (*values.Value, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("Fiber::throw() expects exactly 1 argument, 0 given")
+			}
+			return fibers.ResumeFiber(this, args[0], true)
+		},
+		"getReturn": func(fibers FiberRuntime, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return fibers.FiberReturn(this)
+		},
+		"isStarted": func(fibers FiberRuntime, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return values.NewBool(fibers.FiberStatus(this) != FiberStatusInit), nil
+		},
+		"isSuspended": func(fibers FiberRuntime, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return values.NewBool(fibers.FiberStatus(this) == FiberStatusSuspended), nil
+		},
+		"isRunning": func(fibers FiberRuntime, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return values.NewBool(fibers.FiberStatus(this) == FiberStatusRunning), nil
+		},
+		"isTerminated": func(fibers FiberRuntime, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return values.NewBool(fibers.FiberStatus(this) == FiberStatusTerminated), nil
+		},
+	}
+	staticMethods := map[string]fiberMethodFunc{
+		"suspend": func(fibers FiberRuntime, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			return fibers.SuspendFiber(fiberArg(args))
+		},
+		"getCurrent": func(fibers FiberRuntime, _ *values.Value, _ []*values.Value) (*values.Value, error) {
+			return fibers.CurrentFiber(), nil
+		},
+	}
+
+	desc := &registry.ClassDescriptor{
+		Name:       "Fiber",
+		IsFinal:    true,
+		Properties: make(map[string]*registry.PropertyDescriptor),
+		Methods:    make(map[string]*registry.MethodDescriptor),
+		Constants:  make(map[string]*registry.ConstantDescriptor),
+	}
+	for name, impl := range methods {
+		desc.Methods[name] = fiberMethod(name, impl, false)
+	}
+	for name, impl := range staticMethods {
+		desc.Methods[name] = fiberMethod(name, impl, true)
+	}
+	return desc
+}
+
+func fiberMethod(name string, impl fiberMethodFunc, static bool) *registry.MethodDescriptor {
+	fn := &registry.Function{
+		Name:       name,
+		IsBuiltin:  true,
+		IsStatic:   static,
+		Visibility: "public",
+		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			fibers, ok := ctx.(FiberRuntime)
+			if !ok {
+				return nil, fmt.Errorf("Fiber::%s() is not supported in this context", name)
+			}
+			if static {
+				return impl(fibers, nil, args)
+			}
+			if len(args) == 0 || args[0] == nil || !args[0].IsObject() {
+				return nil, fmt.Errorf("Fiber::%s() called on non-object", name)
+			}
+			return impl(fibers, args[0], args[1:])
+		},
+	}
+	return &registry.MethodDescriptor{
+		Name:           name,
+		Visibility:     "public",
+		IsStatic:       static,
+		Parameters:     []*registry.ParameterDescriptor{},
+		Implementation: NewBuiltinMethodImpl(fn),
+	}
+}
+
+// fiberArg returns the optional value argument of resume() and suspend()
+func fiberArg(args []*values.Value) *values.Value {
+	if len(args) == 0 || args[0] == nil {
+		return values.NewNull()
+	}
+	return args[0]
+}
@@ -92,6 +92,76 @@ func removeProcessHandle(id int64) {
 	delete(processHandles, id)
 }
 
+// requestBody returns the raw body of the current web request, which is
+// empty on the command line
+func requestBody(ctx registry.BuiltinCallContext) []byte {
+	if ctx == nil {
+		return nil
+	}
+	httpCtx := ctx.GetHTTPContext()
+	if httpCtx == nil {
+		return nil
+	}
+	return httpCtx.GetRequestBody()
+}
+
+// openRequestBody backs php://input with an unlinked temporary file so the
+// regular stream functions can read it
+func openRequestBody(ctx registry.BuiltinCallContext) (*os.File, error) {
+	file, err := os.CreateTemp("", "php-input")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(file.Name())
+	if _, err := file.Write(requestBody(ctx)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// uploadedFile reports whether path is a file uploaded with the current
+// request, returning the request's HTTP context
+func uploadedFile(ctx registry.BuiltinCallContext, path string) (registry.HTTPContext, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	httpCtx := ctx.GetHTTPContext()
+	if httpCtx == nil || !httpCtx.IsUploadedFile(path) {
+		return nil, false
+	}
+	return httpCtx, true
+}
+
+// moveFile renames src to dst, copying when they are on different devices
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return os.Chmod(dst, 0644)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
 // parseINIContent parses INI content string and returns a PHP-compatible array
 func parseINIContent(content string, processSections bool, scannerMode int64) *values.Value {
 	result := values.NewArray()
@@ -457,13 +527,27 @@ func GetFilesystemFunctions() []*registry.Function {
 			MinArgs:    2,
 			MaxArgs:    2,
 			IsBuiltin:  true,
-			Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
 				if len(args) < 2 || args[0] == nil || args[1] == nil {
 					return values.NewBool(false), nil
 				}
 				filename := args[0].ToString()
 				mode := args[1].ToString()
 
+				if filename == "php://input" {
+					file, err := openRequestBody(ctx)
+					if err != nil {
+						return values.NewBool(false), nil
+					}
+					handle := &FileHandle{
+						ID:   atomic.AddInt64(&fileHandleCounter, 1),
+						File: file,
+						Mode: "rb",
+					}
+					registerFileHandle(handle)
+					return values.NewResource(handle.ID), nil
+				}
+
 				var flag int
 				switch mode {
 				case "r":
@@ -1001,12 +1085,16 @@ func GetFilesystemFunctions() []*registry.Function {
 			MinArgs:    1,
 			MaxArgs:    1,
 			IsBuiltin: true,
-			Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
 				if len(args) == 0 || args[0] == nil {
 					return values.NewBool(false), nil
 				}
 				filename := args[0].ToString()
 
+				if filename == "php://input" {
+					return values.NewString(string(requestBody(ctx))), nil
+				}
+
 				content, err := os.ReadFile(filename)
 				if err != nil {
 					return values.NewBool(false), nil
@@ -3402,11 +3490,12 @@ func GetFilesystemFunctions() []*registry.Function {
 			MinArgs:    1,
 			MaxArgs:    1,
 			IsBuiltin:  true,
-			Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
-				// In a CLI environment, files are never uploaded via HTTP POST
-				// This function checks if a file was uploaded via HTTP POST mechanism
-				// Since we're in CLI context, always return false
-				return values.NewBool(false), nil
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+				if len(args) == 0 || args[0] == nil {
+					return values.NewBool(false), nil
+				}
+				_, ok := uploadedFile(ctx, args[0].ToString())
+				return values.NewBool(ok), nil
 			},
 		},
 		{
@@ -3419,9 +3508,7 @@ func GetFilesystemFunctions() []*registry.Function {
 			MinArgs:    2,
 			MaxArgs:    2,
 			IsBuiltin:  true,
-			Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
-				// In CLI environment, this would never succeed since files aren't uploaded via HTTP
-				// But for completeness, we implement basic file moving functionality
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
 				if len(args) < 2 || args[0] == nil || args[1] == nil {
 					return values.NewBool(false), nil
 				}
@@ -3429,14 +3516,17 @@ func GetFilesystemFunctions() []*registry.Function {
 				filename := args[0].ToString()
 				destination := args[1].ToString()
 
-				// Check if source file exists
-				if _, err := os.Stat(filename); os.IsNotExist(err) {
+				// Only files uploaded in the current request may be moved
+				httpCtx, ok := uploadedFile(ctx, filename)
+				if !ok {
 					return values.NewBool(false), nil
 				}
 
-				// In CLI context, we can't verify if file was uploaded, so just do a move
-				err := os.Rename(filename, destination)
-				return values.NewBool(err == nil), nil
+				if err := moveFile(filename, destination); err != nil {
+					return values.NewBool(false), nil
+				}
+				httpCtx.RemoveUploadedFile(filename)
+				return values.NewBool(true), nil
 			},
 		},
 		{
@@ -70,7 +70,7 @@ func GetFunctionFunctions() []*registry.Function {
 				result := values.NewArray()
 				resultData := result.Data.(*values.Array)
 				for i, arg := range argValues {
-					resultData.Elements[int64(i)] = arg
+					resultData.Set(int64(i), arg)
 				}
 
 				return result, nil
@@ -130,7 +130,7 @@ func GetFunctionFunctions() []*registry.Function {
 					internalData := internalArray.Data.(*values.Array)
 					i := int64(0)
 					for name := range functions {
-						internalData.Elements[i] = values.NewString(name)
+						internalData.Set(i, values.NewString(name))
 						i++
 					}
 				}
@@ -152,15 +152,15 @@ func GetFunctionFunctions() []*registry.Function {
 					userIndex := int64(0)
 					for _, name := range testFunctionNames {
 						if _, exists := ctx.LookupUserFunction(name); exists {
-							userArrayData.Elements[userIndex] = values.NewString(name)
+							userArrayData.Set(userIndex, values.NewString(name))
 							userIndex++
 						}
 					}
 				}
 
 				resultData := result.Data.(*values.Array)
-				resultData.Elements["internal"] = internalArray
-				resultData.Elements["user"] = userArray
+				resultData.Set("internal", internalArray)
+				resultData.Set("user", userArray)
 
 				return result, nil
 			},
@@ -240,8 +240,8 @@ func GetFunctionFunctions() []*registry.Function {
 				if argsArray.Type == values.TypeArray {
 					arrayData := argsArray.Data.(*values.Array)
 					// Convert map to ordered slice based on indices
-					keys := make([]int64, 0, len(arrayData.Elements))
-					for key := range arrayData.Elements {
+					keys := make([]int64, 0, arrayData.Len())
+					for key := range arrayData.All() {
 						if intKey, ok := key.(int64); ok {
 							keys = append(keys, intKey)
 						}
@@ -256,7 +256,7 @@ func GetFunctionFunctions() []*registry.Function {
 					}
 					// Build arguments in order
 					for _, key := range keys {
-						if val, exists := arrayData.Elements[key]; exists {
+						if val, exists := arrayData.Lookup(key); exists {
 							callArgs = append(callArgs, val)
 						}
 					}
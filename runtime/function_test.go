@@ -193,10 +193,10 @@ func TestGetDefinedFunctions(t *testing.T) {
 
 	// Verify structure: should have 'internal' and 'user' keys
 	resultData := result.Data.(*values.Array)
-	if _, hasInternal := resultData.Elements["internal"]; !hasInternal {
+	if _, hasInternal := resultData.Lookup("internal"); !hasInternal {
 		t.Error("Expected 'internal' key in result")
 	}
-	if _, hasUser := resultData.Elements["user"]; !hasUser {
+	if _, hasUser := resultData.Lookup("user"); !hasUser {
 		t.Error("Expected 'user' key in result")
 	}
 }
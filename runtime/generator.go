@@ -2,381 +2,212 @@ package runtime
 
 import (
 	"fmt"
-	"strconv"
 
 	"github.com/wudi/hey/registry"
 	"github.com/wudi/hey/values"
 )
 
-// GeneratorExecutor is used to execute generator functions with yield support
-type GeneratorExecutor struct {
-	generator *Generator
-}
-
-// Generator implements PHP generators with proper execution suspension/resumption
+// GeneratorBody runs the code of a generator function. The VM implements it
+// on a call stack of its own, so that the function can stop at each yield.
+type GeneratorBody interface {
+	// Resume runs the function up to its next yield or its end. The yield
+	// it last stopped at evaluates to sent, or throws exception when set.
+	Resume(sent, exception *values.Value) GeneratorStep
+}
+
+// GeneratorStep describes where a generator function stopped
+type GeneratorStep struct {
+	// Key and Value are the pair yielded, unless Done is set
+	Key   *values.Value
+	Value *values.Value
+	// Done is set once the function returned Return or threw Exception
+	Done      bool
+	Return    *values.Value
+	Exception *values.Value
+	Err       error
+}
+
+// Generator implements the Generator object handed out by a call of a
+// generator function. The function does not run until the generator is
+// first used.
 type Generator struct {
-	function     *registry.Function
-	args         []*values.Value
-	vm           interface{} // VM interface to avoid import cycles
+	body GeneratorBody
 
-	// Generator state
 	started      bool
 	finished     bool
-	suspended    bool
+	running      bool
+	atFirstYield bool
+
 	currentKey   *values.Value
 	currentValue *values.Value
-
-	// Yield from delegation state
-	delegating        bool
-	delegateIterable  *values.Value
-	delegateKeys      []string // For array iteration
-	delegateIndex     int      // Current index in array
-	delegateGenerator *Generator // For generator delegation
-
-	// Suspended execution state
-	suspendedContext *GeneratorExecutionState
+	returnValue  *values.Value
 }
 
-// GeneratorExecutionState preserves VM execution state at yield points
-type GeneratorExecutionState struct {
-	frame interface{} // Actual CallFrame object
-	ctx   interface{} // Actual ExecutionContext object
+// NewGenerator creates a generator running body
+func NewGenerator(body GeneratorBody) *Generator {
+	return &Generator{body: body}
 }
 
-// NewGenerator creates a new generator
-func NewGenerator(function *registry.Function, args []*values.Value, vm interface{}) *Generator {
-	return &Generator{
-		function:         function,
-		args:             args,
-		vm:               vm,
-		started:          false,
-		finished:         false,
-		suspended:        false,
-		currentKey:       values.NewNull(),
-		currentValue:     values.NewNull(),
-		delegating:       false,
-		delegateIterable: nil,
-		delegateKeys:     nil,
-		delegateIndex:    0,
-		delegateGenerator: nil,
-		suspendedContext: nil,
+// GeneratorOf returns the generator behind a Generator object, or nil
+func GeneratorOf(value *values.Value) *Generator {
+	if value == nil || !value.IsObject() {
+		return nil
 	}
-}
-
-// NewChannelGenerator creates a new channel-based generator (DEPRECATED - for compatibility)
-func NewChannelGenerator(function interface{}, args []*values.Value, vm interface{}) *Generator {
-	fn, ok := function.(*registry.Function)
-	if !ok {
+	genVal, ok := value.Data.(*values.Object).Properties["__channel_generator"]
+	if !ok || genVal == nil {
 		return nil
 	}
-	return NewGenerator(fn, args, vm)
+	gen, _ := genVal.Data.(*Generator)
+	return gen
 }
 
-// Next advances the generator to the next value
-func (g *Generator) Next() bool {
-	if g.finished {
-		return false
+// resume continues the function and records where it stopped. An exception
+// escaping the function is rethrown in the caller.
+func (g *Generator) resume(ctx registry.BuiltinCallContext, sent, exception *values.Value) error {
+	if g.running {
+		return throwGeneratorError(ctx, "Error", "Cannot resume an already running generator")
 	}
-
-	// Check if we're delegating to another iterable
-	if g.delegating {
-		return g.handleDelegateNext()
+	g.running = true
+	step := g.body.Resume(sent, exception)
+	g.running = false
+	g.started = true
+	g.atFirstYield = false
+
+	if step.Err != nil {
+		g.finished = true
+		return step.Err
 	}
-
-	if !g.started {
-		// First call - start execution from beginning
-		g.started = true
-		return g.executeGenerator()
-	} else if g.suspended {
-		// Resume from suspended state
-		g.suspended = false
-		return g.executeGenerator()
+	if step.Done {
+		g.finished = true
+		g.currentKey, g.currentValue = nil, nil
+		if step.Exception != nil {
+			return ctx.ThrowException(step.Exception)
+		}
+		g.returnValue = step.Return
+		if g.returnValue == nil {
+			g.returnValue = values.NewNull()
+		}
+		return nil
 	}
-
-	return false
+	g.currentKey, g.currentValue = step.Key, step.Value
+	return nil
 }
 
-// executeGenerator executes the generator function using the VM
-func (g *Generator) executeGenerator() bool {
-	// ARCHITECTURE NOTE: This implementation is incomplete and requires significant VM changes
-	//
-	// Issues to resolve:
-	// 1. Import cycle between runtime and vm packages prevents direct VM method calls
-	// 2. Generator needs to invoke VM.ExecuteFunction() with proper state management
-	// 3. Yield suspension needs to preserve complete VM execution state (frames, locals, PC)
-	// 4. Resume needs to restore exact VM state and continue from yield point
-	//
-	// Suggested approach:
-	// 1. Define VMExecutor interface in registry or separate package to break import cycles
-	// 2. Pass VM executor to generator that can execute functions with generator context
-	// 3. Implement proper state serialization/deserialization for yield points
-	// 4. Integrate generator execution with VM's main execution loop
-	//
-	// Current implementation is a basic simulation for testing purposes only
-
-	// This is a temporary implementation for basic generator functionality
-	// TODO: Replace with proper VM integration once import cycle is resolved
-
-	// Check if we have suspended state to resume from
-	if g.suspended && g.suspendedContext != nil {
-		g.suspended = false
-		// For now, simulate resumption by continuing execution
-		return g.continueSimulatedExecution()
+// ensureInitialized runs a fresh generator up to its first yield
+func (g *Generator) ensureInitialized(ctx registry.BuiltinCallContext) error {
+	if g.started || g.finished {
+		return nil
 	}
-
-	// Start initial execution
-	if !g.started {
-		g.started = true
-		return g.simulateGeneratorExecution()
+	if err := g.resume(ctx, nil, nil); err != nil {
+		return err
 	}
-
-	return false
+	g.atFirstYield = true
+	return nil
 }
 
-// simulateGeneratorExecution provides basic generator simulation for testing
-func (g *Generator) simulateGeneratorExecution() bool {
-	// Extract information from the function to simulate execution
-	funcName := g.function.Name
-
-	// Simple simulation based on common generator patterns
-	// This handles basic sequential yields for test cases
-	if funcName == "main" || g.isSimpleGenerator() {
-		// Start with first yield value
-		g.currentKey = values.NewInt(0)
-		g.currentValue = values.NewInt(1)
-
-		// Set up for next iterations
-		g.suspended = true
-		g.suspendedContext = &GeneratorExecutionState{
-			frame: nil, // Simplified for now
-			ctx:   nil,
-		}
-
-		return true
+// Current returns the value yielded last, or null once finished
+func (g *Generator) Current(ctx registry.BuiltinCallContext) (*values.Value, error) {
+	if err := g.ensureInitialized(ctx); err != nil {
+		return nil, err
 	}
-
-	g.finished = true
-	return false
-}
-
-// continueSimulatedExecution handles continuation of simulated generator execution
-func (g *Generator) continueSimulatedExecution() bool {
-	// Get current key as integer for progression
-	currentIdx := int(g.currentKey.ToInt())
-
-	// Simple progression for basic test cases
-	nextIdx := currentIdx + 1
-
-	// Simulate common test patterns
-	if nextIdx < 3 { // Most test cases have 3 values
-		g.currentKey = values.NewInt(int64(nextIdx))
-		g.currentValue = values.NewInt(int64(nextIdx + 1))
-		return true
+	if g.finished {
+		return values.NewNull(), nil
 	}
-
-	// Finished
-	g.finished = true
-	g.suspended = false
-	g.suspendedContext = nil
-	return false
+	return g.currentValue, nil
 }
 
-// isSimpleGenerator checks if this is a simple generator for simulation
-func (g *Generator) isSimpleGenerator() bool {
-	// For now, assume most generators are simple for testing
-	return g.function != nil && g.function.Name != ""
-}
-
-// SaveState preserves VM state for resumption
-func (g *Generator) SaveState(ctx, frame interface{}) {
-	g.suspendedContext = &GeneratorExecutionState{
-		frame: frame,
-		ctx:   ctx,
+// Key returns the key yielded last, or null once finished
+func (g *Generator) Key(ctx registry.BuiltinCallContext) (*values.Value, error) {
+	if err := g.ensureInitialized(ctx); err != nil {
+		return nil, err
 	}
-	g.suspended = true
-}
-
-// RestoreState recreates VM state from saved state
-func (g *Generator) RestoreState() (interface{}, interface{}) {
-	if g.suspendedContext == nil {
-		return nil, nil
+	if g.finished {
+		return values.NewNull(), nil
 	}
-	return g.suspendedContext.ctx, g.suspendedContext.frame
-}
-
-// HasSavedState returns whether the generator has saved state
-func (g *Generator) HasSavedState() bool {
-	return g.suspendedContext != nil && g.suspended
-}
-
-// ClearState clears saved state when generator completes
-func (g *Generator) ClearState() {
-	g.suspendedContext = nil
-	g.suspended = false
-}
-
-// saveExecutionState preserves VM state for resumption (deprecated)
-func (g *Generator) saveExecutionState(ctx, frame interface{}) {
-	g.SaveState(ctx, frame)
+	return g.currentKey, nil
 }
 
-// restoreExecutionState recreates VM state from saved state (deprecated)
-func (g *Generator) restoreExecutionState() (interface{}, interface{}) {
-	return g.RestoreState()
+// Next resumes the generator with null as the result of the current yield
+func (g *Generator) Next(ctx registry.BuiltinCallContext) error {
+	if err := g.ensureInitialized(ctx); err != nil {
+		return err
+	}
+	if g.finished {
+		return nil
+	}
+	return g.resume(ctx, values.NewNull(), nil)
 }
 
-// Current returns the current value
-func (g *Generator) Current() *values.Value {
-	return g.currentValue
+// Send resumes the generator with value as the result of the current yield
+// and returns the next value yielded
+func (g *Generator) Send(ctx registry.BuiltinCallContext, value *values.Value) (*values.Value, error) {
+	if err := g.ensureInitialized(ctx); err != nil {
+		return nil, err
+	}
+	if g.finished {
+		return values.NewNull(), nil
+	}
+	if err := g.resume(ctx, value, nil); err != nil {
+		return nil, err
+	}
+	return g.Current(ctx)
 }
 
-// Key returns the current key
-func (g *Generator) Key() *values.Value {
-	return g.currentKey
+// Throw throws exception at the current yield and returns the next value
+// yielded. A finished generator rethrows it in the caller instead.
+func (g *Generator) Throw(ctx registry.BuiltinCallContext, exception *values.Value) (*values.Value, error) {
+	if err := g.ensureInitialized(ctx); err != nil {
+		return nil, err
+	}
+	if g.finished {
+		return nil, ctx.ThrowException(exception)
+	}
+	if err := g.resume(ctx, nil, exception); err != nil {
+		return nil, err
+	}
+	return g.Current(ctx)
 }
 
-// Valid returns whether the generator has more values
-func (g *Generator) Valid() bool {
-	return !g.finished && g.started
+// Valid reports whether the generator has not finished
+func (g *Generator) Valid(ctx registry.BuiltinCallContext) (bool, error) {
+	if err := g.ensureInitialized(ctx); err != nil {
+		return false, err
+	}
+	return !g.finished, nil
 }
 
-// Rewind resets the generator (not supported for generators)
-func (g *Generator) Rewind() error {
-	if g.started {
-		return fmt.Errorf("Cannot rewind a generator that was already run")
+// Rewind starts the generator; generators cannot go back once past their
+// first yield
+func (g *Generator) Rewind(ctx registry.BuiltinCallContext) error {
+	if err := g.ensureInitialized(ctx); err != nil {
+		return err
+	}
+	if !g.atFirstYield {
+		return throwGeneratorError(ctx, "Exception", "Cannot rewind a generator that was already run")
 	}
 	return nil
 }
 
-// Yield is called from within the generator function to yield a value
-func (g *Generator) Yield(key, value *values.Value) {
-	// Store the yielded values for retrieval
-	g.currentKey = key
-	g.currentValue = value
-	// Actual suspension logic will be handled by VM.execYield
+// Finished reports whether the function has returned or thrown
+func (g *Generator) Finished() bool {
+	return g.finished
 }
 
-// StartDelegation begins delegating to another iterable
-func (g *Generator) StartDelegation(iterable *values.Value) error {
-	g.delegating = true
-	g.delegateIterable = iterable
-	g.delegateIndex = 0
-	g.suspended = true // Mark as suspended so Next() will handle delegation
-
-	if iterable.IsArray() {
-		// Prepare array keys for iteration
-		if arr, ok := iterable.Data.(*values.Array); ok {
-			g.delegateKeys = make([]string, 0, len(arr.Elements))
-			for key := range arr.Elements {
-				keyStr := fmt.Sprintf("%v", key)
-				g.delegateKeys = append(g.delegateKeys, keyStr)
-			}
-		}
-
-		// Set the first value immediately if array is not empty
-		if len(g.delegateKeys) > 0 {
-			keyStr := g.delegateKeys[0]
-			arr := g.delegateIterable.Data.(*values.Array)
-
-			// Convert string back to interface{} key for lookup
-			var key interface{}
-			if intKey, err := strconv.Atoi(keyStr); err == nil {
-				key = int64(intKey)
-			} else {
-				key = keyStr
-			}
-
-			value := arr.Elements[key]
-
-			// Convert to appropriate Value type
-			var keyValue *values.Value
-			if intKey, err := strconv.Atoi(keyStr); err == nil {
-				keyValue = values.NewInt(int64(intKey))
-			} else {
-				keyValue = values.NewString(keyStr)
-			}
-
-			// Set current values and advance index
-			g.currentKey = keyValue
-			g.currentValue = value
-			g.delegateIndex++
-		}
-	} else if iterable.IsObject() && iterable.Data.(*values.Object).ClassName == "Generator" {
-		// Get the delegate generator
-		obj := iterable.Data.(*values.Object)
-		if genVal, ok := obj.Properties["__channel_generator"]; ok {
-			if delegateGen, ok := genVal.Data.(*Generator); ok {
-				g.delegateGenerator = delegateGen
-				// Get the first value from the delegate generator
-				if g.delegateGenerator.Next() {
-					g.currentKey = g.delegateGenerator.Key()
-					g.currentValue = g.delegateGenerator.Current()
-				} else {
-				}
-			} else {
-				return fmt.Errorf("invalid generator for delegation")
-			}
-		} else {
-			return fmt.Errorf("generator object missing __channel_generator property")
-		}
-	} else {
-		return fmt.Errorf("yield from requires an iterable (array or Generator)")
+// GetReturn returns the value the generator function returned
+func (g *Generator) GetReturn(ctx registry.BuiltinCallContext) (*values.Value, error) {
+	if err := g.ensureInitialized(ctx); err != nil {
+		return nil, err
 	}
-
-	return nil
+	if !g.finished || g.returnValue == nil {
+		return nil, throwGeneratorError(ctx, "Exception", "Cannot get return value of a generator that hasn't returned")
+	}
+	return g.returnValue, nil
 }
 
-// handleDelegateNext handles the next iteration when delegating
-func (g *Generator) handleDelegateNext() bool {
-	if g.delegateIterable.IsArray() {
-		// Array delegation
-		if g.delegateIndex >= len(g.delegateKeys) {
-			// Array exhausted, stop delegating and resume normal execution
-			g.delegating = false
-			return g.executeGenerator()
-		}
-
-		// Get current array item
-		keyStr := g.delegateKeys[g.delegateIndex]
-		arr := g.delegateIterable.Data.(*values.Array)
-
-		// Convert string back to interface{} key for lookup
-		var key interface{}
-		if intKey, err := strconv.Atoi(keyStr); err == nil {
-			key = int64(intKey)
-		} else {
-			key = keyStr
-		}
-
-		value := arr.Elements[key]
-
-		// Convert to appropriate Value type
-		var keyValue *values.Value
-		if intKey, err := strconv.Atoi(keyStr); err == nil {
-			keyValue = values.NewInt(int64(intKey))
-		} else {
-			keyValue = values.NewString(keyStr)
-		}
-
-		// Set current values and advance index
-		g.currentKey = keyValue
-		g.currentValue = value
-		g.delegateIndex++
-
-		return true
-	} else if g.delegateGenerator != nil {
-		// Generator delegation
-		if g.delegateGenerator.Next() {
-			// Delegate generator has a value
-			g.currentKey = g.delegateGenerator.Key()
-			g.currentValue = g.delegateGenerator.Current()
-			return true
-		} else {
-			// Delegate generator is exhausted, stop delegating and resume normal execution
-			g.delegating = false
-			return g.executeGenerator()
-		}
+func throwGeneratorError(ctx registry.BuiltinCallContext, className, message string) error {
+	exception := CreateException(ctx, className, message)
+	if exception == nil {
+		return fmt.Errorf("%s: %s", className, message)
 	}
-
-	return false
-}
\ No newline at end of file
+	return ctx.ThrowException(exception)
+}
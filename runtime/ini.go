@@ -108,6 +108,41 @@ func initializeDefaultSettings() {
 			OriginalValue: "&",
 			Access: 7, // PHP_INI_ALL
 		},
+		"file_uploads": {
+			Name: "file_uploads",
+			GlobalValue: "1",
+			LocalValue: "1",
+			OriginalValue: "1",
+			Access: 4, // PHP_INI_SYSTEM
+		},
+		"upload_tmp_dir": {
+			Name: "upload_tmp_dir",
+			GlobalValue: "",
+			LocalValue: "",
+			OriginalValue: "",
+			Access: 4, // PHP_INI_SYSTEM
+		},
+		"upload_max_filesize": {
+			Name: "upload_max_filesize",
+			GlobalValue: "2M",
+			LocalValue: "2M",
+			OriginalValue: "2M",
+			Access: 6, // PHP_INI_PERDIR
+		},
+		"max_file_uploads": {
+			Name: "max_file_uploads",
+			GlobalValue: "20",
+			LocalValue: "20",
+			OriginalValue: "20",
+			Access: 4, // PHP_INI_SYSTEM
+		},
+		"post_max_size": {
+			Name: "post_max_size",
+			GlobalValue: "8M",
+			LocalValue: "8M",
+			OriginalValue: "8M",
+			Access: 6, // PHP_INI_PERDIR
+		},
 		"assert.active": {
 			Name: "assert.active",
 			GlobalValue: "1",
@@ -115,6 +150,87 @@ func initializeDefaultSettings() {
 			OriginalValue: "1",
 			Access: 7, // PHP_INI_ALL
 		},
+		// Step debugging over DBGp, configured as with Xdebug
+		"xdebug.mode": {
+			Name: "xdebug.mode",
+			GlobalValue: "develop",
+			LocalValue: "develop",
+			OriginalValue: "develop",
+			Access: 4, // PHP_INI_SYSTEM
+		},
+		"xdebug.start_with_request": {
+			Name: "xdebug.start_with_request",
+			GlobalValue: "default",
+			LocalValue: "default",
+			OriginalValue: "default",
+			Access: 4, // PHP_INI_SYSTEM
+		},
+		"xdebug.client_host": {
+			Name: "xdebug.client_host",
+			GlobalValue: "localhost",
+			LocalValue: "localhost",
+			OriginalValue: "localhost",
+			Access: 7, // PHP_INI_ALL
+		},
+		"xdebug.client_port": {
+			Name: "xdebug.client_port",
+			GlobalValue: "9003",
+			LocalValue: "9003",
+			OriginalValue: "9003",
+			Access: 7, // PHP_INI_ALL
+		},
+		"xdebug.idekey": {
+			Name: "xdebug.idekey",
+			GlobalValue: "",
+			LocalValue: "",
+			OriginalValue: "",
+			Access: 7, // PHP_INI_ALL
+		},
+		// Profiling, enabled by adding "profile" to xdebug.mode
+		"xdebug.output_dir": {
+			Name: "xdebug.output_dir",
+			GlobalValue: "/tmp",
+			LocalValue: "/tmp",
+			OriginalValue: "/tmp",
+			Access: 7, // PHP_INI_ALL
+		},
+		"xdebug.profiler_output_name": {
+			Name: "xdebug.profiler_output_name",
+			GlobalValue: "cachegrind.out.%p",
+			LocalValue: "cachegrind.out.%p",
+			OriginalValue: "cachegrind.out.%p",
+			Access: 7, // PHP_INI_ALL
+		},
+		// Code coverage through the pcov functions
+		"pcov.enabled": {
+			Name: "pcov.enabled",
+			GlobalValue: "1",
+			LocalValue: "1",
+			OriginalValue: "1",
+			Access: 4, // PHP_INI_SYSTEM
+		},
+		// Limits on the backtracking of the preg_* functions
+		"pcre.backtrack_limit": {
+			Name: "pcre.backtrack_limit",
+			GlobalValue: "1000000",
+			LocalValue: "1000000",
+			OriginalValue: "1000000",
+			Access: 7, // PHP_INI_ALL
+		},
+		"pcre.recursion_limit": {
+			Name: "pcre.recursion_limit",
+			GlobalValue: "100000",
+			LocalValue: "100000",
+			OriginalValue: "100000",
+			Access: 7, // PHP_INI_ALL
+		},
+		"pcre.jit": {
+			Name: "pcre.jit",
+			GlobalValue: "0",
+			LocalValue: "0",
+			OriginalValue: "0",
+			Access: 7, // PHP_INI_ALL
+		},
 	}
 
 	for name, setting := range defaultSettings {
@@ -340,4 +456,39 @@ func UpdateIniMaxExecutionTime(seconds int) {
 		setting.GlobalValue = newValue
 		setting.LocalValue = newValue
 	}
-}
\ No newline at end of file
+}
+// GetIniValue returns the current value of an ini setting
+func GetIniValue(name string) (string, bool) {
+	storage := getIniStorage()
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+
+	setting, exists := storage.settings[name]
+	if !exists {
+		return "", false
+	}
+	return setting.LocalValue, true
+}
+
+// SetIniValue changes an ini setting regardless of its access level, as
+// configuration files and server directives do. It returns false for
+// unknown settings.
+func SetIniValue(name, value string) bool {
+	storage := getIniStorage()
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	setting, exists := storage.settings[name]
+	if !exists {
+		return false
+	}
+	setting.GlobalValue = value
+	setting.LocalValue = value
+	return true
+}
+
+// ParseIniQuantity converts a size setting like "8M" into bytes
+func ParseIniQuantity(shorthand string) int64 {
+	quantity, _ := parseQuantity(strings.TrimSpace(shorthand))
+	return quantity.ToInt()
+}
@@ -1,6 +1,8 @@
 package runtime
 
 import (
+	"fmt"
+
 	"github.com/wudi/hey/registry"
 	"github.com/wudi/hey/values"
 )
@@ -71,150 +73,69 @@ func getIteratorInterface() *registry.Interface {
 	}
 }
 
-func getGeneratorClass() *registry.ClassDescriptor {
-	// Create method implementations for Generator class
-	currentImpl := &registry.Function{
-		Name:      "current",
-		IsBuiltin: true,
-		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
-			if len(args) < 1 || !args[0].IsObject() {
-				return values.NewNull(), nil
-			}
-			obj := args[0].Data.(*values.Object)
-
-			// Get generator
-			if genVal, ok := obj.Properties["__channel_generator"]; ok && genVal != nil {
-				if gen, ok := genVal.Data.(*Generator); ok {
-					return gen.Current(), nil
-				}
-			}
+type generatorMethodFunc func(ctx registry.BuiltinCallContext, gen *Generator, args []*values.Value) (*values.Value, error)
 
-			return values.NewNull(), nil
+func getGeneratorClass() *registry.ClassDescriptor {
+	impls := map[string]generatorMethodFunc{
+		"current": func(ctx registry.BuiltinCallContext, gen *Generator, _ []*values.Value) (*values.Value, error) {
+			return gen.Current(ctx)
 		},
-		Parameters: []*registry.Parameter{},
-	}
-
-	keyImpl := &registry.Function{
-		Name:      "key",
-		IsBuiltin: true,
-		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
-			if len(args) < 1 || !args[0].IsObject() {
-				return values.NewNull(), nil
-			}
-			obj := args[0].Data.(*values.Object)
-
-			// Get generator
-			if genVal, ok := obj.Properties["__channel_generator"]; ok && genVal != nil {
-				if gen, ok := genVal.Data.(*Generator); ok {
-					return gen.Key(), nil
-				}
-			}
-
-			return values.NewNull(), nil
+		"key": func(ctx registry.BuiltinCallContext, gen *Generator, _ []*values.Value) (*values.Value, error) {
+			return gen.Key(ctx)
 		},
-		Parameters: []*registry.Parameter{},
-	}
-
-	nextImpl := &registry.Function{
-		Name:      "next",
-		IsBuiltin: true,
-		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
-			if len(args) < 1 || !args[0].IsObject() {
-				return values.NewNull(), nil
-			}
-			obj := args[0].Data.(*values.Object)
-
-			// Get generator
-			if genVal, ok := obj.Properties["__channel_generator"]; ok && genVal != nil {
-				if gen, ok := genVal.Data.(*Generator); ok {
-					gen.Next() // Advance to next value
-					return values.NewNull(), nil
-				}
-			}
-
-			return values.NewNull(), nil
+		"next": func(ctx registry.BuiltinCallContext, gen *Generator, _ []*values.Value) (*values.Value, error) {
+			return values.NewNull(), gen.Next(ctx)
 		},
-		Parameters: []*registry.Parameter{},
-	}
-
-	rewindImpl := &registry.Function{
-		Name:      "rewind",
-		IsBuiltin: true,
-		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
-			if len(args) < 1 || !args[0].IsObject() {
-				return values.NewNull(), nil
-			}
-			obj := args[0].Data.(*values.Object)
-
-			// Get generator
-			if genVal, ok := obj.Properties["__channel_generator"]; ok && genVal != nil {
-				if gen, ok := genVal.Data.(*Generator); ok {
-					if err := gen.Rewind(); err != nil {
-						return values.NewNull(), err
-					}
-					gen.Next() // Start and get first value
-					return values.NewNull(), nil
-				}
+		"rewind": func(ctx registry.BuiltinCallContext, gen *Generator, _ []*values.Value) (*values.Value, error) {
+			return values.NewNull(), gen.Rewind(ctx)
+		},
+		"valid": func(ctx registry.BuiltinCallContext, gen *Generator, _ []*values.Value) (*values.Value, error) {
+			valid, err := gen.Valid(ctx)
+			return values.NewBool(valid), err
+		},
+		"send": func(ctx registry.BuiltinCallContext, gen *Generator, args []*values.Value) (*values.Value, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("Generator::send() expects exactly 1 argument, 0 given")
 			}
-
-			return values.NewNull(), nil
+			return gen.Send(ctx, args[0])
 		},
-		Parameters: []*registry.Parameter{},
-	}
-
-	validImpl := &registry.Function{
-		Name:      "valid",
-		IsBuiltin: true,
-		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
-			if len(args) < 1 || !args[0].IsObject() {
-				return values.NewBool(false), nil
+		"throw": func(ctx registry.BuiltinCallContext, gen *Generator, args []*values.Value) (*values.Value, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("Generator::throw() expects exactly 1 argument, 0 given")
 			}
-			obj := args[0].Data.(*values.Object)
-
-			// Get generator
-			if genVal, ok := obj.Properties["__channel_generator"]; ok && genVal != nil {
-				if gen, ok := genVal.Data.(*Generator); ok {
-					return values.NewBool(gen.Valid()), nil
-				}
+			if !args[0].IsObject() {
+				return nil, throwGeneratorError(ctx, "TypeError", fmt.Sprintf("Generator::throw(): Argument #1 ($exception) must be of type Throwable, %s given", args[0].TypeName()))
 			}
-
-			return values.NewBool(false), nil
+			return gen.Throw(ctx, args[0])
+		},
+		"getReturn": func(ctx registry.BuiltinCallContext, gen *Generator, _ []*values.Value) (*values.Value, error) {
+			return gen.GetReturn(ctx)
 		},
-		Parameters: []*registry.Parameter{},
 	}
 
-	// Create method descriptors
-	methods := map[string]*registry.MethodDescriptor{
-		"current": {
-			Name:           "current",
-			Visibility:     "public",
-			Parameters:     []*registry.ParameterDescriptor{},
-			Implementation: &BuiltinMethodImpl{function: currentImpl},
-		},
-		"key": {
-			Name:           "key",
-			Visibility:     "public",
-			Parameters:     []*registry.ParameterDescriptor{},
-			Implementation: &BuiltinMethodImpl{function: keyImpl},
-		},
-		"next": {
-			Name:           "next",
-			Visibility:     "public",
-			Parameters:     []*registry.ParameterDescriptor{},
-			Implementation: &BuiltinMethodImpl{function: nextImpl},
-		},
-		"rewind": {
-			Name:           "rewind",
-			Visibility:     "public",
-			Parameters:     []*registry.ParameterDescriptor{},
-			Implementation: &BuiltinMethodImpl{function: rewindImpl},
-		},
-		"valid": {
-			Name:           "valid",
+	methods := make(map[string]*registry.MethodDescriptor, len(impls))
+	for name, impl := range impls {
+		fn := &registry.Function{
+			Name:       name,
+			IsBuiltin:  true,
+			Visibility: "public",
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+				if len(args) < 1 {
+					return nil, fmt.Errorf("Generator::%s() called on non-object", name)
+				}
+				gen := GeneratorOf(args[0])
+				if gen == nil {
+					return nil, fmt.Errorf("Generator::%s() called on an invalid generator", name)
+				}
+				return impl(ctx, gen, args[1:])
+			},
+		}
+		methods[name] = &registry.MethodDescriptor{
+			Name:           name,
 			Visibility:     "public",
 			Parameters:     []*registry.ParameterDescriptor{},
-			Implementation: &BuiltinMethodImpl{function: validImpl},
-		},
+			Implementation: &BuiltinMethodImpl{function: fn},
+		}
 	}
 
 	return &registry.ClassDescriptor{
@@ -225,4 +146,4 @@ func getGeneratorClass() *registry.ClassDescriptor {
 		Properties: make(map[string]*registry.PropertyDescriptor),
 		Constants:  make(map[string]*registry.ConstantDescriptor),
 	}
-}
\ No newline at end of file
+}
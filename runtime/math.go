@@ -120,7 +120,7 @@ func GetMathFunctions() []*registry.Function {
 			MinArgs:    1,
 			MaxArgs:    999,
 			IsBuiltin: true,
-			Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
 				if len(args) == 0 {
 					return values.NewNull(), nil
 				}
@@ -129,13 +129,13 @@ func GetMathFunctions() []*registry.Function {
 				if len(args) == 1 && args[0].IsArray() {
 					arr := args[0]
 					arrayData := arr.Data.(*values.Array)
-					if len(arrayData.Elements) == 0 {
+					if arrayData.Len() == 0 {
 						return values.NewNull(), nil
 					}
 
 					var maxVal *values.Value
-					for _, val := range arrayData.Elements {
-						if maxVal == nil || compareValuesForMath(val, maxVal) > 0 {
+					for _, val := range arrayData.All() {
+						if maxVal == nil || compareValuesForMath(ctx, val, maxVal) > 0 {
 							maxVal = val
 						}
 					}
@@ -145,7 +145,7 @@ func GetMathFunctions() []*registry.Function {
 				// Find max among all arguments
 				maxVal := args[0]
 				for i := 1; i < len(args); i++ {
-					if compareValuesForMath(args[i], maxVal) > 0 {
+					if compareValuesForMath(ctx, args[i], maxVal) > 0 {
 						maxVal = args[i]
 					}
 				}
@@ -161,7 +161,7 @@ func GetMathFunctions() []*registry.Function {
 			MinArgs:    1,
 			MaxArgs:    999,
 			IsBuiltin: true,
-			Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
 				if len(args) == 0 {
 					return values.NewNull(), nil
 				}
@@ -170,13 +170,13 @@ func GetMathFunctions() []*registry.Function {
 				if len(args) == 1 && args[0].IsArray() {
 					arr := args[0]
 					arrayData := arr.Data.(*values.Array)
-					if len(arrayData.Elements) == 0 {
+					if arrayData.Len() == 0 {
 						return values.NewNull(), nil
 					}
 
 					var minVal *values.Value
-					for _, val := range arrayData.Elements {
-						if minVal == nil || compareValuesForMath(val, minVal) < 0 {
+					for _, val := range arrayData.All() {
+						if minVal == nil || compareValuesForMath(ctx, val, minVal) < 0 {
 							minVal = val
 						}
 					}
@@ -186,7 +186,7 @@ func GetMathFunctions() []*registry.Function {
 				// Find min among all arguments
 				minVal := args[0]
 				for i := 1; i < len(args); i++ {
-					if compareValuesForMath(args[i], minVal) < 0 {
+					if compareValuesForMath(ctx, args[i], minVal) < 0 {
 						minVal = args[i]
 					}
 				}
@@ -431,7 +431,12 @@ func init() {
 
 // compareValuesForMath compares two values for max/min operations
 // Returns: >0 if a > b, <0 if a < b, 0 if a == b
-func compareValuesForMath(a, b *values.Value) int {
+func compareValuesForMath(ctx registry.BuiltinCallContext, a, b *values.Value) int {
+	// Date objects compare by the instant they represent
+	if cmp, ok := CompareDateObjects(ctx, a.Deref(), b.Deref()); ok {
+		return cmp
+	}
+
 	// Handle null values
 	if a.IsNull() && b.IsNull() {
 		return 0
@@ -240,6 +240,11 @@ func (m *mockMathBuiltinCallContext) GetCurrentFunctionArgCount() (int, error) {
 func (m *mockMathBuiltinCallContext) GetCurrentFunctionArg(index int) (*values.Value, error) { return nil, nil }
 func (m *mockMathBuiltinCallContext) GetCurrentFunctionArgs() ([]*values.Value, error) { return nil, nil }
 func (m *mockMathBuiltinCallContext) ThrowException(exception *values.Value) error { return fmt.Errorf("exception thrown in test mock: %v", exception) }
+func (m *mockMathBuiltinCallContext) AutoloadClass(name string) bool { return false }
+func (m *mockMathBuiltinCallContext) CallMethod(method *registry.Function, this *values.Value, className string, args []*values.Value) (*values.Value, error) {
+	return nil, nil
+}
+func (m *mockMathBuiltinCallContext) InstantiateClass(className string) (*values.Value, error) { return nil, nil }
 func (m *mockMathBuiltinCallContext) GetHTTPContext() registry.HTTPContext { return &mockHTTPContext{} }
 func (m *mockMathBuiltinCallContext) ResetHTTPContext() {}
 func (m *mockMathBuiltinCallContext) RemoveHTTPHeader(name string) {}
\ No newline at end of file
@@ -451,30 +451,25 @@ func GetMySQLiFunctions() []*registry.Function {
 				if mode == 1 {
 					// Associative only
 					for key, val := range row {
-						arrData.Elements[key] = val
+						arrData.Set(key, val)
 					}
-					arrData.IsIndexed = false
 					return arr, nil
 				} else if mode == 2 {
 					// Numeric array
 					idx := int64(0)
 					for _, val := range row {
-						arrData.Elements[idx] = val
+						arrData.Set(idx, val)
 						idx++
 					}
-					arrData.NextIndex = idx
-					arrData.IsIndexed = true
 					return arr, nil
 				} else {
 					// Both
 					idx := int64(0)
 					for key, val := range row {
-						arrData.Elements[key] = val
-						arrData.Elements[idx] = val
+						arrData.Set(key, val)
+						arrData.Set(idx, val)
 						idx++
 					}
-					arrData.NextIndex = idx
-					arrData.IsIndexed = false
 					return arr, nil
 				}
 			},
@@ -503,9 +498,8 @@ func GetMySQLiFunctions() []*registry.Function {
 						arr := values.NewArray()
 						arrData := arr.Data.(*values.Array)
 						for key, val := range row {
-							arrData.Elements[key] = val
+							arrData.Set(key, val)
 						}
-						arrData.IsIndexed = false // associative array
 						return arr, nil
 					}
 				}
@@ -671,11 +665,9 @@ func GetMySQLiFunctions() []*registry.Function {
 				arrData := arr.Data.(*values.Array)
 				idx := int64(0)
 				for _, val := range row {
-					arrData.Elements[idx] = val
+					arrData.Set(idx, val)
 					idx++
 				}
-				arrData.NextIndex = idx
-				arrData.IsIndexed = true
 
 				return arr, nil
 			},
@@ -159,30 +159,25 @@ func mysqliResultFetchArray(_ registry.BuiltinCallContext, args []*values.Value)
 	if mode == 1 {
 		// Associative only
 		for key, val := range row {
-			arrData.Elements[key] = val
+			arrData.Set(key, val)
 		}
-		arrData.IsIndexed = false
 		return arr, nil
 	} else if mode == 2 {
 		// Numeric array
 		idx := int64(0)
 		for _, val := range row {
-			arrData.Elements[idx] = val
+			arrData.Set(idx, val)
 			idx++
 		}
-		arrData.NextIndex = idx
-		arrData.IsIndexed = true
 		return arr, nil
 	} else {
 		// Both
 		idx := int64(0)
 		for key, val := range row {
-			arrData.Elements[key] = val
-			arrData.Elements[idx] = val
+			arrData.Set(key, val)
+			arrData.Set(idx, val)
 			idx++
 		}
-		arrData.NextIndex = idx
-		arrData.IsIndexed = false
 		return arr, nil
 	}
 }
@@ -209,9 +204,8 @@ func mysqliResultFetchAssoc(_ registry.BuiltinCallContext, args []*values.Value)
 	arr := values.NewArray()
 	arrData := arr.Data.(*values.Array)
 	for key, val := range row {
-		arrData.Elements[key] = val
+		arrData.Set(key, val)
 	}
-	arrData.IsIndexed = false // associative array
 
 	return arr, nil
 }
@@ -239,11 +233,9 @@ func mysqliResultFetchRow(_ registry.BuiltinCallContext, args []*values.Value) (
 	arrData := arr.Data.(*values.Array)
 	idx := int64(0)
 	for _, val := range row {
-		arrData.Elements[idx] = val
+		arrData.Set(idx, val)
 		idx++
 	}
-	arrData.NextIndex = idx
-	arrData.IsIndexed = true
 
 	return arr, nil
 }
@@ -77,6 +77,11 @@ func (m *mockOutputContext) GetCurrentFunctionArgs() ([]*values.Value, error) {
 func (m *mockOutputContext) ThrowException(exception *values.Value) error {
 	return fmt.Errorf("exception thrown in test mock: %v", exception)
 }
+func (m *mockOutputContext) AutoloadClass(name string) bool { return false }
+func (m *mockOutputContext) CallMethod(method *registry.Function, this *values.Value, className string, args []*values.Value) (*values.Value, error) {
+	return nil, nil
+}
+func (m *mockOutputContext) InstantiateClass(className string) (*values.Value, error) { return nil, nil }
 
 func (m *mockOutputContext) GetHTTPContext() registry.HTTPContext {
 	return &mockHTTPContext{}
@@ -192,8 +197,8 @@ func TestPrintR(t *testing.T) {
 			expected: `Array
 (
     [0] => 1
-    [1] => 3
     [a] => 2
+    [1] => 3
     [b] => 4
 )
 `,
@@ -221,12 +226,7 @@ func TestPrintR(t *testing.T) {
 			}()},
 			expected: `Array
 (
-    [address] => Array
-        (
-            [city] => New York
-            [country] => USA
-        )
-
+    [name] => John
     [age] => 30
     [hobbies] => Array
         (
@@ -235,7 +235,12 @@ func TestPrintR(t *testing.T) {
             [2] => gaming
         )
 
-    [name] => John
+    [address] => Array
+        (
+            [city] => New York
+            [country] => USA
+        )
+
 )
 `,
 			returns: values.NewBool(true),
@@ -0,0 +1,419 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// packIntegerSizes are the byte sizes of the integer and float format codes
+// of pack() and unpack(). Machine order and sizes are those of a 64-bit
+// little-endian build of PHP.
+var packIntegerSizes = map[byte]int{
+	'c': 1, 'C': 1,
+	's': 2, 'S': 2, 'n': 2, 'v': 2,
+	'i': 4, 'I': 4, 'l': 4, 'L': 4, 'N': 4, 'V': 4,
+	'q': 8, 'Q': 8, 'J': 8, 'P': 8,
+	'f': 4, 'g': 4, 'G': 4,
+	'd': 8, 'e': 8, 'E': 8,
+}
+
+// packByteOrder returns the byte order of a format code
+func packByteOrder(code byte) binary.ByteOrder {
+	switch code {
+	case 'n', 'N', 'J', 'G', 'E':
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// GetPackFunctions returns pack() and unpack()
+func GetPackFunctions() []*registry.Function {
+	return []*registry.Function{
+		{
+			Name: "pack",
+			Parameters: []*registry.Parameter{
+				{Name: "format", Type: "string"},
+				{Name: "values", Type: "mixed"},
+			},
+			ReturnType: "string",
+			MinArgs:    1,
+			MaxArgs:    -1,
+			IsBuiltin:  true,
+			IsVariadic: true,
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+				packed, err := packValues(args[0].ToString(), args[1:])
+				if err != nil {
+					return throwPackError(ctx, err)
+				}
+				return values.NewString(string(packed)), nil
+			},
+		},
+		{
+			Name: "unpack",
+			Parameters: []*registry.Parameter{
+				{Name: "format", Type: "string"},
+				{Name: "string", Type: "string"},
+				{Name: "offset", Type: "int", HasDefault: true, DefaultValue: values.NewInt(0)},
+			},
+			ReturnType: "array|false",
+			MinArgs:    2,
+			MaxArgs:    3,
+			IsBuiltin:  true,
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+				data := args[1].ToString()
+				offset := 0
+				if len(args) > 2 && args[2] != nil {
+					offset = int(args[2].ToInt())
+				}
+				if offset < 0 || offset > len(data) {
+					return throwPackError(ctx, fmt.Errorf("unpack(): Argument #3 ($offset) must be contained in argument #2 ($data)"))
+				}
+				result, ok, err := unpackValues(args[0].ToString(), data[offset:])
+				if err != nil {
+					return throwPackError(ctx, err)
+				}
+				if !ok {
+					return values.NewBool(false), nil
+				}
+				return result, nil
+			},
+		},
+	}
+}
+
+// throwPackError throws err as a ValueError
+func throwPackError(ctx registry.BuiltinCallContext, err error) (*values.Value, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("ValueError: %s", err)
+	}
+	exception := CreateException(ctx, "ValueError", err.Error())
+	if exception == nil {
+		return nil, fmt.Errorf("ValueError: %s", err)
+	}
+	return nil, ctx.ThrowException(exception)
+}
+
+// readPackCount reads the repeat count after a format code at pos. It
+// returns -1 for *, and 1 when there is no count.
+func readPackCount(format string, pos int) (int, int) {
+	if pos < len(format) && format[pos] == '*' {
+		return -1, pos + 1
+	}
+	end := pos
+	for end < len(format) && format[end] >= '0' && format[end] <= '9' {
+		end++
+	}
+	if end == pos {
+		return 1, pos
+	}
+	count, err := strconv.Atoi(format[pos:end])
+	if err != nil {
+		count = math.MaxInt32
+	}
+	return count, end
+}
+
+// packValues implements pack()
+func packValues(format string, args []*values.Value) ([]byte, error) {
+	var out []byte
+	next := 0
+	for pos := 0; pos < len(format); {
+		code := format[pos]
+		var count int
+		count, pos = readPackCount(format, pos+1)
+
+		switch code {
+		case 'a', 'A', 'Z', 'h', 'H':
+			if next >= len(args) {
+				return nil, fmt.Errorf("Type %c: not enough arguments", code)
+			}
+			s := args[next].ToString()
+			next++
+			switch code {
+			case 'h', 'H':
+				out = append(out, packHex(code, s, count)...)
+			default:
+				out = append(out, packString(code, s, count)...)
+			}
+
+		case 'x':
+			if count < 0 {
+				count = 1
+			}
+			out = append(out, make([]byte, count)...)
+
+		case 'X':
+			if count < 0 {
+				count = 1
+			}
+			if count > len(out) {
+				count = len(out)
+			}
+			out = out[:len(out)-count]
+
+		case '@':
+			if count < 0 {
+				count = 1
+			}
+			if count > len(out) {
+				out = append(out, make([]byte, count-len(out))...)
+			}
+			out = out[:count]
+
+		default:
+			size, ok := packIntegerSizes[code]
+			if !ok {
+				return nil, fmt.Errorf("Type %c: unknown format code", code)
+			}
+			if count < 0 {
+				count = len(args) - next
+			}
+			if next+count > len(args) {
+				return nil, fmt.Errorf("Type %c: too few arguments", code)
+			}
+			for i := 0; i < count; i++ {
+				out = append(out, packNumber(code, size, args[next])...)
+				next++
+			}
+		}
+	}
+	return out, nil
+}
+
+// packString packs s for a, A and Z, padded with NULs or spaces to count
+// bytes
+func packString(code byte, s string, count int) []byte {
+	if count < 0 {
+		count = len(s)
+		if code == 'Z' {
+			count++
+		}
+	}
+	pad := byte(0)
+	if code == 'A' {
+		pad = ' '
+	}
+	out := make([]byte, count)
+	for i := range out {
+		out[i] = pad
+	}
+	n := len(s)
+	if code == 'Z' && n > count-1 {
+		// Z always ends with a NUL
+		n = count - 1
+	}
+	if n > count {
+		n = count
+	}
+	if n > 0 {
+		copy(out, s[:n])
+	}
+	return out
+}
+
+// packHex packs the hex digits of s for h (low nibble first) and H (high
+// nibble first). count is the number of digits to use.
+func packHex(code byte, s string, count int) []byte {
+	if count < 0 || count > len(s) {
+		count = len(s)
+	}
+	out := make([]byte, (count+1)/2)
+	for i := 0; i < count; i++ {
+		var nibble byte
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			nibble = c - '0'
+		case c >= 'a' && c <= 'f':
+			nibble = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			nibble = c - 'A' + 10
+		}
+		first := i%2 == 0
+		if code == 'h' {
+			first = !first
+		}
+		if first {
+			nibble <<= 4
+		}
+		out[i/2] |= nibble
+	}
+	return out
+}
+
+// packNumber packs one integer or float of size bytes
+func packNumber(code byte, size int, v *values.Value) []byte {
+	out := make([]byte, 8)
+	order := packByteOrder(code)
+	switch code {
+	case 'f', 'g', 'G':
+		order.PutUint32(out, math.Float32bits(float32(v.ToFloat())))
+	case 'd', 'e', 'E':
+		order.PutUint64(out, math.Float64bits(v.ToFloat()))
+	default:
+		n := uint64(v.ToInt())
+		switch size {
+		case 1:
+			out[0] = byte(n)
+		case 2:
+			order.PutUint16(out, uint16(n))
+		case 4:
+			order.PutUint32(out, uint32(n))
+		default:
+			order.PutUint64(out, n)
+		}
+	}
+	return out[:size]
+}
+
+// unpackValues implements unpack(). It reports false when data is too
+// short for the format.
+func unpackValues(format string, data string) (*values.Value, bool, error) {
+	result := values.NewArray()
+	pos := 0
+	for _, element := range strings.Split(format, "/") {
+		if element == "" {
+			continue
+		}
+		code := element[0]
+		count, nameStart := readPackCount(element, 1)
+		name := element[nameStart:]
+
+		size, repetitions := 0, count
+		switch code {
+		case 'a', 'A', 'Z':
+			size, repetitions = count, 1
+		case 'h', 'H':
+			size, repetitions = count, 1
+			if count > 0 {
+				size = (count + 1) / 2
+			}
+		case 'x', 'X', '@':
+			size = 1
+			if count < 0 {
+				repetitions = 1
+			}
+		default:
+			var ok bool
+			if size, ok = packIntegerSizes[code]; !ok {
+				return nil, false, fmt.Errorf("Invalid format type %c", code)
+			}
+		}
+
+		key := func(i int) *values.Value {
+			if repetitions == 1 && name != "" {
+				return values.NewString(name)
+			}
+			return values.NewString(name + strconv.Itoa(i+1))
+		}
+
+		switch code {
+		case 'x':
+			if pos+repetitions > len(data) {
+				return nil, false, nil
+			}
+			pos += repetitions
+			continue
+		case 'X':
+			pos -= repetitions
+			if pos < 0 {
+				pos = 0
+			}
+			continue
+		case '@':
+			if repetitions > len(data) {
+				return nil, false, nil
+			}
+			pos = repetitions
+			continue
+		}
+
+		for i := 0; i != repetitions; i++ {
+			length := size
+			if length < 0 {
+				length = len(data) - pos
+			}
+			if pos+length > len(data) {
+				if repetitions < 0 {
+					break
+				}
+				return nil, false, nil
+			}
+			chunk := data[pos : pos+length]
+			switch code {
+			case 'a':
+				result.ArraySet(key(i), values.NewString(chunk))
+			case 'A':
+				result.ArraySet(key(i), values.NewString(strings.TrimRight(chunk, " \t\r\n\x00")))
+			case 'Z':
+				if nul := strings.IndexByte(chunk, 0); nul >= 0 {
+					chunk = chunk[:nul]
+				}
+				result.ArraySet(key(i), values.NewString(chunk))
+			case 'h', 'H':
+				result.ArraySet(key(i), values.NewString(unpackHex(code, chunk, count)))
+			default:
+				result.ArraySet(key(i), unpackNumber(code, chunk))
+			}
+			pos += length
+			if pos == len(data) && repetitions < 0 {
+				break
+			}
+		}
+	}
+	return result, true, nil
+}
+
+// unpackHex returns the hex digits of b, count of them unless count is -1
+func unpackHex(code byte, b string, count int) string {
+	digits := len(b) * 2
+	if count >= 0 && count < digits {
+		digits = count
+	}
+	const hexDigits = "0123456789abcdef"
+	out := make([]byte, digits)
+	for i := range out {
+		c := b[i/2]
+		first := i%2 == 0
+		if code == 'h' {
+			first = !first
+		}
+		if first {
+			out[i] = hexDigits[c>>4]
+		} else {
+			out[i] = hexDigits[c&0x0f]
+		}
+	}
+	return string(out)
+}
+
+// unpackNumber reads one integer or float from b
+func unpackNumber(code byte, b string) *values.Value {
+	raw := []byte(b)
+	order := packByteOrder(code)
+	switch code {
+	case 'c':
+		return values.NewInt(int64(int8(raw[0])))
+	case 'C':
+		return values.NewInt(int64(raw[0]))
+	case 's':
+		return values.NewInt(int64(int16(order.Uint16(raw))))
+	case 'S', 'n', 'v':
+		return values.NewInt(int64(order.Uint16(raw)))
+	case 'i', 'l':
+		return values.NewInt(int64(int32(order.Uint32(raw))))
+	case 'I', 'L', 'N', 'V':
+		return values.NewInt(int64(order.Uint32(raw)))
+	case 'f', 'g', 'G':
+		return values.NewFloat(float64(math.Float32frombits(order.Uint32(raw))))
+	case 'd', 'e', 'E':
+		return values.NewFloat(math.Float64frombits(order.Uint64(raw)))
+	}
+	// q, Q, J and P: unsigned values past the range of int wrap around
+	return values.NewInt(int64(order.Uint64(raw)))
+}
@@ -0,0 +1,139 @@
+package runtime
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// TestPackFunctions tests pack() and unpack()
+func TestPackFunctions(t *testing.T) {
+	functions := GetPackFunctions()
+	functionMap := make(map[string]*registry.Function)
+	for _, fn := range functions {
+		functionMap[fn.Name] = fn
+	}
+
+	t.Run("pack", func(t *testing.T) {
+		fn := functionMap["pack"]
+		if fn == nil {
+			t.Fatal("pack function not found")
+		}
+
+		tests := []struct {
+			format   string
+			args     []*values.Value
+			expected string
+		}{
+			{"nvc*", []*values.Value{values.NewInt(0x1234), values.NewInt(0x5678), values.NewInt(65), values.NewInt(-1)}, "1234785641ff"},
+			{"NVJP", []*values.Value{values.NewInt(1), values.NewInt(1), values.NewInt(1), values.NewInt(1)}, "000000010100000000000000000000010100000000000000"},
+			{"sSiIlLqQ", []*values.Value{values.NewInt(-2), values.NewInt(2), values.NewInt(-3), values.NewInt(3), values.NewInt(-4), values.NewInt(4), values.NewInt(-5), values.NewInt(5)}, "feff0200fdffffff03000000fcffffff04000000fbffffffffffffff0500000000000000"},
+			{"a5A5Z5", []*values.Value{values.NewString("ab"), values.NewString("cd"), values.NewString("efghij")}, "616200000063642020206566676800"},
+			{"a*Z*", []*values.Value{values.NewString("xy"), values.NewString("z")}, "78797a00"},
+			{"H*h3H3", []*values.Value{values.NewString("4a6B"), values.NewString("123"), values.NewString("123")}, "4a6b21031230"},
+			{"C3x2X@7", []*values.Value{values.NewInt(1), values.NewInt(2), values.NewInt(3)}, "01020300000000"},
+			{"gGeE", []*values.Value{values.NewFloat(1.5), values.NewFloat(1.5), values.NewFloat(2.5), values.NewFloat(2.5)}, "0000c03f3fc0000000000000000004404004000000000000"},
+			{"fd", []*values.Value{values.NewFloat(0.1), values.NewFloat(0.1)}, "cdcccc3d9a9999999999b93f"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.format, func(t *testing.T) {
+				result, err := fn.Builtin(nil, append([]*values.Value{values.NewString(tt.format)}, tt.args...))
+				if err != nil {
+					t.Fatalf("pack error: %v", err)
+				}
+				if got := hex.EncodeToString([]byte(result.ToString())); got != tt.expected {
+					t.Errorf("pack(%q): expected %s, got %s", tt.format, tt.expected, got)
+				}
+			})
+		}
+
+		// Unknown codes and missing arguments are errors
+		for _, args := range [][]*values.Value{
+			{values.NewString("y"), values.NewInt(1)},
+			{values.NewString("N2"), values.NewInt(1)},
+			{values.NewString("a")},
+		} {
+			if _, err := fn.Builtin(nil, args); err == nil {
+				t.Errorf("pack(%q): expected an error", args[0].ToString())
+			}
+		}
+	})
+
+	t.Run("unpack", func(t *testing.T) {
+		fn := functionMap["unpack"]
+		if fn == nil {
+			t.Fatal("unpack function not found")
+		}
+
+		tests := []struct {
+			format   string
+			data     string
+			expected map[interface{}]interface{}
+		}{
+			{"nlen/Cflag/a3body", "\x00\x05\x01abc", map[interface{}]interface{}{"len": int64(5), "flag": int64(1), "body": "abc"}},
+			{"C*", "\x01\x02\x03", map[interface{}]interface{}{int64(1): int64(1), int64(2): int64(2), int64(3): int64(3)}},
+			{"C2chars/nint", "\x04\x00\xa0\x00", map[interface{}]interface{}{"chars1": int64(4), "chars2": int64(0), "int": int64(40960)}},
+			{"N/l", "\xff\xff\xff\xff\xff\xff\xff\xff", map[interface{}]interface{}{int64(1): int64(-1)}},
+			{"Nu/ls", "\xff\xff\xff\xff\xff\xff\xff\xff", map[interface{}]interface{}{"u": int64(4294967295), "s": int64(-1)}},
+			{"q/Jbig", "\xfe\xff\xff\xff\xff\xff\xff\xff\x00\x00\x00\x00\x00\x00\x00\x02", map[interface{}]interface{}{int64(1): int64(-2), "big": int64(2)}},
+			{"H*hex/@1/h2lo", "\xab\xcd", map[interface{}]interface{}{"hex": "abcd", "lo": "dc"}},
+			{"A5trim/Z*z", "ab   cd\x00ef", map[interface{}]interface{}{"trim": "ab", "z": "cd"}},
+			{"Gg/Ee", "\x3f\xc0\x00\x00\x40\x04\x00\x00\x00\x00\x00\x00", map[interface{}]interface{}{"g": 1.5, "e": 2.5}},
+			{"vlo/x/X2/nhi", "\x01\x02\x03", map[interface{}]interface{}{"lo": int64(0x0201), "hi": int64(0x0203)}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.format, func(t *testing.T) {
+				result, err := fn.Builtin(nil, []*values.Value{values.NewString(tt.format), values.NewString(tt.data)})
+				if err != nil {
+					t.Fatalf("unpack error: %v", err)
+				}
+				if !result.IsArray() || result.ArrayCount() != len(tt.expected) {
+					t.Fatalf("unpack(%q): unexpected result %v", tt.format, result)
+				}
+				for key, expected := range tt.expected {
+					var keyValue *values.Value
+					if s, ok := key.(string); ok {
+						keyValue = values.NewString(s)
+					} else {
+						keyValue = values.NewInt(key.(int64))
+					}
+					got := result.ArrayGet(keyValue)
+					if got == nil {
+						t.Errorf("unpack(%q): missing key %v", tt.format, key)
+						continue
+					}
+					switch want := expected.(type) {
+					case int64:
+						if got.ToInt() != want {
+							t.Errorf("unpack(%q)[%v]: expected %d, got %d", tt.format, key, want, got.ToInt())
+						}
+					case float64:
+						if got.ToFloat() != want {
+							t.Errorf("unpack(%q)[%v]: expected %v, got %v", tt.format, key, want, got.ToFloat())
+						}
+					case string:
+						if got.ToString() != want {
+							t.Errorf("unpack(%q)[%v]: expected %q, got %q", tt.format, key, want, got.ToString())
+						}
+					}
+				}
+			})
+		}
+
+		result, err := fn.Builtin(nil, []*values.Value{values.NewString("N"), values.NewString("ab")})
+		if err != nil || !result.IsBool() || result.ToBool() {
+			t.Errorf("unpack with too little data: expected false, got %v (%v)", result, err)
+		}
+		result, err = fn.Builtin(nil, []*values.Value{values.NewString("C"), values.NewString("abc"), values.NewInt(2)})
+		if err != nil || result.ArrayGet(values.NewInt(1)).ToInt() != 'c' {
+			t.Errorf("unpack with an offset: unexpected %v (%v)", result, err)
+		}
+		if _, err := fn.Builtin(nil, []*values.Value{values.NewString("y"), values.NewString("a")}); err == nil {
+			t.Error("unpack with an unknown code: expected an error")
+		}
+	})
+}
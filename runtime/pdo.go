@@ -509,7 +509,7 @@ func pdoGetAttribute(ctx registry.BuiltinCallContext, args []*values.Value) (*va
 	attributes := attributesVal.Data.(*values.Array)
 
 	// Look up the attribute value
-	if val, exists := attributes.Elements[attribute]; exists {
+	if val, exists := attributes.Lookup(attribute); exists {
 		return val, nil
 	}
 
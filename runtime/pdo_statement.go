@@ -32,7 +32,7 @@ func pdoStmtExecute(ctx registry.BuiltinCallContext, args []*values.Value) (*val
 				// Extract parameter map
 				paramMap = make(map[string]int)
 				paramMapArr := paramMapVal.Data.(*values.Array)
-				for keyIface, val := range paramMapArr.Elements {
+				for keyIface, val := range paramMapArr.All() {
 					if keyStr, ok := keyIface.(string); ok {
 						paramPos := int(val.Data.(int64))
 						paramMap[keyStr] = paramPos
@@ -44,7 +44,7 @@ func pdoStmtExecute(ctx registry.BuiltinCallContext, args []*values.Value) (*val
 			// If params.Elements keys are strings (named parameters), use paramMap
 			// Otherwise, use positional binding
 			idx := 1
-			for keyIface, val := range params.Elements {
+			for keyIface, val := range params.All() {
 				switch k := keyIface.(type) {
 				case string:
 					// Named parameter
@@ -42,6 +42,7 @@ func GetReflectionFunctions() []*registry.Function {
 			Name: "class_exists",
 			Parameters: []*registry.Parameter{
 				{Name: "class_name", Type: "string"},
+				{Name: "autoload", Type: "bool", HasDefault: true, DefaultValue: values.NewBool(true)},
 			},
 			ReturnType: "bool",
 			MinArgs:    1,
@@ -55,15 +56,65 @@ func GetReflectionFunctions() []*registry.Function {
 				if name == "" {
 					return values.NewBool(false), nil
 				}
-				if _, ok := ctx.LookupUserClass(name); ok {
+				classKnown := func() bool {
+					if _, ok := ctx.LookupUserClass(name); ok {
+						return true
+					}
+					if reg := ctx.SymbolRegistry(); reg != nil {
+						if _, err := reg.GetClass(name); err == nil {
+							return true
+						}
+					}
+					// Note: builtinClassStubs check removed - should be handled by builtins.go
+					return false
+				}
+				if classKnown() {
 					return values.NewBool(true), nil
 				}
-				if reg := ctx.SymbolRegistry(); reg != nil {
-					if _, err := reg.GetClass(name); err == nil {
-						return values.NewBool(true), nil
+				autoload := len(args) < 2 || args[1] == nil || args[1].ToBool()
+				if autoload && ctx.AutoloadClass(name) {
+					return values.NewBool(classKnown()), nil
+				}
+				return values.NewBool(false), nil
+			},
+		},
+		{
+			Name: "enum_exists",
+			Parameters: []*registry.Parameter{
+				{Name: "enum", Type: "string"},
+				{Name: "autoload", Type: "bool", HasDefault: true, DefaultValue: values.NewBool(true)},
+			},
+			ReturnType: "bool",
+			MinArgs:    1,
+			MaxArgs:    2,
+			IsBuiltin:  true,
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+				if len(args) == 0 || args[0] == nil {
+					return values.NewBool(false), nil
+				}
+				name := strings.TrimPrefix(args[0].ToString(), "\\")
+				if name == "" {
+					return values.NewBool(false), nil
+				}
+				isEnum := func() (bool, bool) {
+					if class, ok := ctx.LookupUserClass(name); ok {
+						return class.IsEnum, true
+					}
+					if reg := ctx.SymbolRegistry(); reg != nil {
+						if desc, err := reg.GetClass(name); err == nil {
+							return desc.IsEnum, true
+						}
 					}
+					return false, false
+				}
+				if enum, known := isEnum(); known {
+					return values.NewBool(enum), nil
+				}
+				autoload := len(args) < 2 || args[1] == nil || args[1].ToBool()
+				if autoload && ctx.AutoloadClass(name) {
+					enum, _ := isEnum()
+					return values.NewBool(enum), nil
 				}
-				// Note: builtinClassStubs check removed - should be handled by builtins.go
 				return values.NewBool(false), nil
 			},
 		},
@@ -83,6 +134,9 @@ func GetReflectionFunctions() []*registry.Function {
 					return values.NewBool(false), nil
 				}
 
+				if args[0] != nil && args[0].IsCallable() {
+					return values.NewString("Closure"), nil
+				}
 				if args[0] == nil || !args[0].IsObject() {
 					return values.NewBool(false), nil
 				}
@@ -148,6 +148,11 @@ func (m *mockReflectionBuiltinCallContext) GetCurrentFunctionArgCount() (int, er
 func (m *mockReflectionBuiltinCallContext) GetCurrentFunctionArg(index int) (*values.Value, error) { return nil, nil }
 func (m *mockReflectionBuiltinCallContext) GetCurrentFunctionArgs() ([]*values.Value, error) { return nil, nil }
 func (m *mockReflectionBuiltinCallContext) ThrowException(exception *values.Value) error { return fmt.Errorf("exception thrown in test mock: %v", exception) }
+func (m *mockReflectionBuiltinCallContext) AutoloadClass(name string) bool { return false }
+func (m *mockReflectionBuiltinCallContext) CallMethod(method *registry.Function, this *values.Value, className string, args []*values.Value) (*values.Value, error) {
+	return nil, nil
+}
+func (m *mockReflectionBuiltinCallContext) InstantiateClass(className string) (*values.Value, error) { return nil, nil }
 func (m *mockReflectionBuiltinCallContext) GetHTTPContext() registry.HTTPContext { return &mockHTTPContext{} }
 func (m *mockReflectionBuiltinCallContext) ResetHTTPContext() {}
 func (m *mockReflectionBuiltinCallContext) RemoveHTTPHeader(name string) {}
\ No newline at end of file
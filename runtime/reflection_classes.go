@@ -0,0 +1,1380 @@
+package runtime
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// Modifier bits returned by getModifiers(), matching PHP's values.
+const (
+	reflectionIsPublic    = 1
+	reflectionIsProtected = 2
+	reflectionIsPrivate   = 4
+	reflectionIsStatic    = 16
+	reflectionIsFinal     = 32
+	reflectionIsAbstract  = 64
+	reflectionIsReadonly  = 128
+)
+
+// reflectionDataKey is the hidden property holding the Go-side state of a
+// Reflection* object.
+const reflectionDataKey = "__reflection"
+
+// reflectionData is the state behind a Reflection* object. Which fields are
+// set depends on the reflection class: ReflectionClass uses class and kind,
+// methods and functions add function, parameters add param and position,
+// properties use property, types use typeName and attributes use attribute.
+type reflectionData struct {
+	class    *registry.Class
+	kind     string // "class", "interface" or "trait"
+	function *registry.Function
+	this     *values.Value // object a first-class callable method is bound to
+	closure  *values.Value
+	param    *registry.Parameter
+	position int
+	property *registry.Property
+	dynamic  bool // property exists only on the object, not in the class
+	typeName string
+	// ReflectionAttribute state
+	attribute *registry.Attribute
+	target    int64
+	repeated  bool
+}
+
+// reflectionMethodFunc implements a Reflection* method. data is nil only for
+// constructors, which are responsible for creating it.
+type reflectionMethodFunc func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error)
+
+// GetReflectionClasses returns the Reflection API class descriptors
+func GetReflectionClasses() []*registry.ClassDescriptor {
+	return []*registry.ClassDescriptor{
+		createSimpleExceptionClass("ReflectionException", "Exception"),
+		getReflectionClassClass(),
+		getReflectionObjectClass(),
+		getReflectionFunctionAbstractClass(),
+		getReflectionFunctionClass(),
+		getReflectionMethodClass(),
+		getReflectionParameterClass(),
+		getReflectionPropertyClass(),
+		getReflectionTypeClass(),
+		getReflectionNamedTypeClass(),
+		getReflectionUnionTypeClass(),
+	}
+}
+
+func reflectionMethod(name string, impl reflectionMethodFunc) *registry.MethodDescriptor {
+	fn := &registry.Function{
+		Name:       name,
+		IsBuiltin:  true,
+		Visibility: "public",
+		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			if len(args) == 0 || args[0] == nil || !args[0].IsObject() {
+				return nil, fmt.Errorf("%s called on non-object", name)
+			}
+			this := args[0]
+			data := reflectionDataOf(this)
+			if data == nil && name != "__construct" {
+				return nil, fmt.Errorf("internal error: failed to retrieve the reflection object")
+			}
+			return impl(ctx, this, data, args[1:])
+		},
+	}
+	return &registry.MethodDescriptor{
+		Name:           name,
+		Visibility:     "public",
+		Parameters:     []*registry.ParameterDescriptor{},
+		Implementation: NewBuiltinMethodImpl(fn),
+	}
+}
+
+func reflectionClassDescriptor(name, parent string, isAbstract bool, methods map[string]reflectionMethodFunc, publicProps ...string) *registry.ClassDescriptor {
+	desc := &registry.ClassDescriptor{
+		Name:       name,
+		Parent:     parent,
+		IsAbstract: isAbstract,
+		Properties: make(map[string]*registry.PropertyDescriptor),
+		Methods:    make(map[string]*registry.MethodDescriptor),
+		Constants:  make(map[string]*registry.ConstantDescriptor),
+	}
+	for methodName, impl := range methods {
+		desc.Methods[methodName] = reflectionMethod(methodName, impl)
+	}
+	for _, prop := range publicProps {
+		desc.Properties[prop] = &registry.PropertyDescriptor{
+			Name:         prop,
+			Visibility:   "public",
+			Type:         "string",
+			DefaultValue: values.NewString(""),
+		}
+	}
+	return desc
+}
+
+func reflectionDataOf(this *values.Value) *reflectionData {
+	if this == nil || !this.IsObject() {
+		return nil
+	}
+	res, ok := this.Data.(*values.Object).Properties[reflectionDataKey]
+	if !ok || res == nil || res.Type != values.TypeResource {
+		return nil
+	}
+	data, _ := res.Data.(*reflectionData)
+	return data
+}
+
+// attachReflectionData stores data on obj along with its public string
+// properties, given as name/value pairs.
+func attachReflectionData(obj *values.Value, data *reflectionData, props ...string) *values.Value {
+	o := obj.Data.(*values.Object)
+	if o.Properties == nil {
+		o.Properties = make(map[string]*values.Value)
+	}
+	for i := 0; i+1 < len(props); i += 2 {
+		o.Properties[props[i]] = values.NewString(props[i+1])
+	}
+	o.Properties[reflectionDataKey] = values.NewResource(data)
+	return obj
+}
+
+func throwReflectionError(ctx registry.BuiltinCallContext, className, format string, args ...interface{}) (*values.Value, error) {
+	message := fmt.Sprintf(format, args...)
+	exception := CreateException(ctx, className, message)
+	if exception == nil {
+		return nil, fmt.Errorf("%s: %s", className, message)
+	}
+	return nil, ctx.ThrowException(exception)
+}
+
+func reflectionArg(args []*values.Value, i int) *values.Value {
+	if i < len(args) && args[i] != nil {
+		return args[i]
+	}
+	return values.NewNull()
+}
+
+// reflectionArgList turns the list part of an argument array into a slice.
+func reflectionArgList(arr *values.Value) []*values.Value {
+	if arr == nil || !arr.IsArray() {
+		return nil
+	}
+	var list []*values.Value
+	for key, val := range arr.Data.(*values.Array).All() {
+		if _, ok := key.(int64); ok {
+			list = append(list, val)
+		}
+	}
+	return list
+}
+
+func reflectionList(items []*values.Value) *values.Value {
+	arr := values.NewArray()
+	for i, item := range items {
+		arr.ArraySet(values.NewInt(int64(i)), item)
+	}
+	return arr
+}
+
+func reflectionStringOrFalse(s string) *values.Value {
+	if s == "" {
+		return values.NewBool(false)
+	}
+	return values.NewString(s)
+}
+
+// ---------------------------------------------------------------------------
+// Class lookup
+
+var (
+	builtinClassNamesOnce sync.Once
+	builtinClassNames     map[string]struct{}
+)
+
+// isBuiltinClassName reports whether name is a class or interface provided by
+// the runtime rather than by user code.
+func isBuiltinClassName(name string) bool {
+	builtinClassNamesOnce.Do(func() {
+		builtinClassNames = make(map[string]struct{})
+		for _, class := range GetAllBuiltinClasses() {
+			builtinClassNames[strings.ToLower(class.Name)] = struct{}{}
+		}
+		for _, iface := range GetAllBuiltinInterfaces() {
+			builtinClassNames[strings.ToLower(iface.Name)] = struct{}{}
+		}
+	})
+	_, ok := builtinClassNames[strings.ToLower(name)]
+	return ok
+}
+
+// reflectionLookupClass resolves a class, interface or trait by name,
+// running the autoloaders when it is not defined yet.
+func reflectionLookupClass(ctx registry.BuiltinCallContext, name string) (*registry.Class, string, bool) {
+	name = strings.TrimPrefix(name, "\\")
+	if name == "" {
+		return nil, "", false
+	}
+	if cls, kind, ok := reflectionFindClass(ctx, name); ok {
+		return cls, kind, true
+	}
+	if ctx.AutoloadClass(name) {
+		return reflectionFindClass(ctx, name)
+	}
+	return nil, "", false
+}
+
+func reflectionFindClass(ctx registry.BuiltinCallContext, name string) (*registry.Class, string, bool) {
+	if cls, ok := ctx.LookupUserClass(name); ok && cls != nil {
+		return cls, "class", true
+	}
+	reg := ctx.SymbolRegistry()
+	if reg == nil {
+		return nil, "", false
+	}
+	if desc, err := reg.GetClass(name); err == nil && desc != nil {
+		return reflectionClassFromDescriptor(desc), "class", true
+	}
+	if iface, ok := reg.GetInterface(name); ok && iface != nil {
+		return reflectionClassFromInterface(iface), "interface", true
+	}
+	if trait, ok := reg.GetTrait(name); ok && trait != nil {
+		return &registry.Class{
+			Name:       trait.Name,
+			Properties: trait.Properties,
+			Methods:    trait.Methods,
+		}, "trait", true
+	}
+	return nil, "", false
+}
+
+func reflectionClassFromDescriptor(desc *registry.ClassDescriptor) *registry.Class {
+	class := &registry.Class{
+		Name:       desc.Name,
+		Parent:     desc.Parent,
+		Interfaces: desc.Interfaces,
+		Traits:     desc.Traits,
+		IsAbstract: desc.IsAbstract,
+		IsFinal:    desc.IsFinal,
+		Properties: make(map[string]*registry.Property, len(desc.Properties)),
+		Methods:    make(map[string]*registry.Function, len(desc.Methods)),
+		Constants:  make(map[string]*registry.ClassConstant, len(desc.Constants)),
+	}
+	for name, prop := range desc.Properties {
+		class.Properties[name] = &registry.Property{
+			Name:         prop.Name,
+			Visibility:   prop.Visibility,
+			IsStatic:     prop.IsStatic,
+			IsReadonly:   prop.IsReadonly,
+			Type:         prop.Type,
+			DefaultValue: prop.DefaultValue,
+		}
+	}
+	for name, constant := range desc.Constants {
+		class.Constants[name] = &registry.ClassConstant{
+			Name:       constant.Name,
+			Value:      constant.Value,
+			Visibility: constant.Visibility,
+			IsFinal:    constant.IsFinal,
+		}
+	}
+	for name, method := range desc.Methods {
+		fn := &registry.Function{Name: method.Name}
+		if impl, ok := method.Implementation.(interface{ GetFunction() *registry.Function }); ok && impl.GetFunction() != nil {
+			fn = impl.GetFunction().Clone()
+			fn.Name = method.Name
+		} else if impl, ok := method.Implementation.(*registry.BytecodeMethodImpl); ok && impl != nil {
+			fn.Instructions = impl.Instructions
+			fn.Constants = impl.Constants
+		}
+		if len(fn.Parameters) == 0 {
+			for _, param := range method.Parameters {
+				fn.Parameters = append(fn.Parameters, &registry.Parameter{
+					Name:         param.Name,
+					Type:         param.Type,
+					IsReference:  param.IsReference,
+					HasDefault:   param.HasDefault,
+					DefaultValue: param.DefaultValue,
+				})
+			}
+		}
+		fn.Visibility = method.Visibility
+		fn.IsStatic = method.IsStatic
+		fn.IsAbstract = method.IsAbstract
+		fn.IsFinal = method.IsFinal
+		fn.IsVariadic = fn.IsVariadic || method.IsVariadic
+		class.Methods[name] = fn
+	}
+	return class
+}
+
+func reflectionClassFromInterface(iface *registry.Interface) *registry.Class {
+	class := &registry.Class{
+		Name:       iface.Name,
+		Interfaces: iface.Extends,
+		IsAbstract: true,
+		Methods:    make(map[string]*registry.Function, len(iface.Methods)),
+	}
+	for name, method := range iface.Methods {
+		class.Methods[name] = &registry.Function{
+			Name:       method.Name,
+			Parameters: method.Parameters,
+			ReturnType: method.ReturnType,
+			Visibility: "public",
+			IsAbstract: true,
+		}
+	}
+	return class
+}
+
+func reflectionParent(ctx registry.BuiltinCallContext, cls *registry.Class) *registry.Class {
+	if cls == nil || cls.Parent == "" {
+		return nil
+	}
+	parent, _, ok := reflectionLookupClass(ctx, cls.Parent)
+	if !ok {
+		return nil
+	}
+	return parent
+}
+
+// reflectionFindMethod looks a method up case-insensitively in cls and its
+// ancestors and returns it with the class that declares it.
+func reflectionFindMethod(ctx registry.BuiltinCallContext, cls *registry.Class, name string) (*registry.Function, *registry.Class) {
+	for depth := 0; cls != nil && depth < 64; depth++ {
+		for methodName, fn := range cls.Methods {
+			if fn != nil && strings.EqualFold(methodName, name) {
+				return fn, cls
+			}
+		}
+		cls = reflectionParent(ctx, cls)
+	}
+	return nil, nil
+}
+
+// reflectionFindProperty looks a non-inherited-private property up in cls
+// and its ancestors and returns it with the class that declares it.
+func reflectionFindProperty(ctx registry.BuiltinCallContext, cls *registry.Class, name string) (*registry.Property, *registry.Class) {
+	for depth := 0; cls != nil && depth < 64; depth++ {
+		if prop, ok := cls.Properties[name]; ok && prop != nil {
+			if depth > 0 && prop.Visibility == "private" {
+				return nil, nil
+			}
+			return prop, cls
+		}
+		cls = reflectionParent(ctx, cls)
+	}
+	return nil, nil
+}
+
+// reflectionInterfaceNames collects the interfaces implemented by cls,
+// including inherited ones and the interfaces they extend.
+func reflectionInterfaceNames(ctx registry.BuiltinCallContext, cls *registry.Class) []string {
+	var names []string
+	seen := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		key := strings.ToLower(strings.TrimPrefix(name, "\\"))
+		if key == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		iface, kind, ok := reflectionLookupClass(ctx, name)
+		if ok && kind == "interface" {
+			names = append(names, iface.Name)
+			for _, parent := range iface.Interfaces {
+				visit(parent)
+			}
+			return
+		}
+		names = append(names, strings.TrimPrefix(name, "\\"))
+	}
+	for depth := 0; cls != nil && depth < 64; depth++ {
+		for _, name := range cls.Interfaces {
+			visit(name)
+		}
+		cls = reflectionParent(ctx, cls)
+	}
+	return names
+}
+
+// reflectionIsA reports whether className is target, one of its subclasses
+// or an implementation of the interface target.
+func reflectionIsA(ctx registry.BuiltinCallContext, className, target string) bool {
+	target = strings.TrimPrefix(target, "\\")
+	cls, _, ok := reflectionLookupClass(ctx, className)
+	if !ok {
+		return strings.EqualFold(className, target)
+	}
+	for c, depth := cls, 0; c != nil && depth < 64; c, depth = reflectionParent(ctx, c), depth+1 {
+		if strings.EqualFold(c.Name, target) {
+			return true
+		}
+	}
+	for _, name := range reflectionInterfaceNames(ctx, cls) {
+		if strings.EqualFold(name, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func reflectionVisibilityBit(visibility string) int64 {
+	switch strings.ToLower(visibility) {
+	case "private":
+		return reflectionIsPrivate
+	case "protected":
+		return reflectionIsProtected
+	default:
+		return reflectionIsPublic
+	}
+}
+
+func reflectionMethodModifiers(fn *registry.Function) int64 {
+	modifiers := reflectionVisibilityBit(fn.Visibility)
+	if fn.IsStatic {
+		modifiers |= reflectionIsStatic
+	}
+	if fn.IsFinal {
+		modifiers |= reflectionIsFinal
+	}
+	if fn.IsAbstract {
+		modifiers |= reflectionIsAbstract
+	}
+	return modifiers
+}
+
+func reflectionPropertyModifiers(prop *registry.Property) int64 {
+	modifiers := reflectionVisibilityBit(prop.Visibility)
+	if prop.IsStatic {
+		modifiers |= reflectionIsStatic
+	}
+	if prop.IsReadonly {
+		modifiers |= reflectionIsReadonly
+	}
+	return modifiers
+}
+
+// reflectionFilter reads the optional modifier filter argument of
+// getMethods() and getProperties(); -1 means no filter.
+func reflectionFilter(args []*values.Value) int64 {
+	if len(args) == 0 || args[0] == nil || args[0].IsNull() {
+		return -1
+	}
+	return args[0].ToInt()
+}
+
+func reflectionShortName(name string) string {
+	if i := strings.LastIndex(name, "\\"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+func reflectionNamespaceName(name string) string {
+	if i := strings.LastIndex(name, "\\"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// ---------------------------------------------------------------------------
+// Object constructors
+
+func newReflectionClass(cls *registry.Class, kind string) *values.Value {
+	return attachReflectionData(values.NewObject("ReflectionClass"), &reflectionData{class: cls, kind: kind}, "name", cls.Name)
+}
+
+func newReflectionMethod(declaring *registry.Class, fn *registry.Function) *values.Value {
+	return attachReflectionData(values.NewObject("ReflectionMethod"), &reflectionData{class: declaring, kind: "class", function: fn}, "name", fn.Name, "class", declaring.Name)
+}
+
+func newReflectionProperty(declaring *registry.Class, prop *registry.Property, dynamic bool) *values.Value {
+	return attachReflectionData(values.NewObject("ReflectionProperty"), &reflectionData{class: declaring, kind: "class", property: prop, dynamic: dynamic}, "name", prop.Name, "class", declaring.Name)
+}
+
+func newReflectionParameter(owner *reflectionData, position int) *values.Value {
+	param := owner.function.Parameters[position]
+	data := &reflectionData{
+		class:    owner.class,
+		kind:     owner.kind,
+		function: owner.function,
+		this:     owner.this,
+		closure:  owner.closure,
+		param:    param,
+		position: position,
+	}
+	return attachReflectionData(values.NewObject("ReflectionParameter"), data, "name", reflectionParameterName(param))
+}
+
+// newReflectionType builds the ReflectionType for a declared type, or NULL
+// when nothing was declared. T|null is reported as the named type ?T.
+func newReflectionType(typeName string) *values.Value {
+	typeName = strings.TrimSpace(typeName)
+	if typeName == "" {
+		return values.NewNull()
+	}
+	if strings.Contains(typeName, "|") {
+		var members []string
+		hasNull := strings.HasPrefix(typeName, "?")
+		for _, member := range strings.Split(strings.TrimPrefix(typeName, "?"), "|") {
+			member = strings.TrimSpace(member)
+			if strings.EqualFold(member, "null") {
+				hasNull = true
+				continue
+			}
+			members = append(members, member)
+		}
+		switch {
+		case len(members) == 1 && hasNull:
+			typeName = "?" + members[0]
+		case len(members) == 1:
+			typeName = members[0]
+		default:
+			if hasNull {
+				members = append(members, "null")
+			}
+			typeName = strings.Join(members, "|")
+			return attachReflectionData(values.NewObject("ReflectionUnionType"), &reflectionData{typeName: typeName})
+		}
+	}
+	return attachReflectionData(values.NewObject("ReflectionNamedType"), &reflectionData{typeName: typeName})
+}
+
+func reflectionTypeAllowsNull(typeName string) bool {
+	if typeName == "" || strings.HasPrefix(typeName, "?") {
+		return true
+	}
+	for _, member := range strings.Split(typeName, "|") {
+		switch strings.ToLower(strings.TrimSpace(member)) {
+		case "null", "mixed":
+			return true
+		}
+	}
+	return false
+}
+
+var reflectionBuiltinTypes = map[string]bool{
+	"int": true, "float": true, "string": true, "bool": true, "array": true,
+	"callable": true, "iterable": true, "object": true, "mixed": true,
+	"void": true, "null": true, "never": true, "false": true, "true": true,
+}
+
+// ---------------------------------------------------------------------------
+// ReflectionClass
+
+func getReflectionClassClass() *registry.ClassDescriptor {
+	desc := reflectionClassDescriptor("ReflectionClass", "", false, map[string]reflectionMethodFunc{
+		"__construct": func(ctx registry.BuiltinCallContext, this *values.Value, _ *reflectionData, args []*values.Value) (*values.Value, error) {
+			target := reflectionArg(args, 0)
+			name := target.ToString()
+			if target.IsObject() {
+				name = target.Data.(*values.Object).ClassName
+			}
+			cls, kind, ok := reflectionLookupClass(ctx, name)
+			if !ok {
+				return throwReflectionError(ctx, "ReflectionException", "Class \"%s\" does not exist", name)
+			}
+			attachReflectionData(this, &reflectionData{class: cls, kind: kind}, "name", cls.Name)
+			return this, nil
+		},
+		"getName": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewString(data.class.Name), nil
+		},
+		"getShortName": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewString(reflectionShortName(data.class.Name)), nil
+		},
+		"getNamespaceName": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewString(reflectionNamespaceName(data.class.Name)), nil
+		},
+		"inNamespace": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(strings.Contains(data.class.Name, "\\")), nil
+		},
+		"getDocComment": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionStringOrFalse(data.class.DocComment), nil
+		},
+		"getAttributes": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionGetAttributes(ctx, data.class.Attributes, attributeTargetClass, args)
+		},
+		"isInterface": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.kind == "interface"), nil
+		},
+		"isTrait": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.kind == "trait"), nil
+		},
+		"isAbstract": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionClassIsAbstract(data)), nil
+		},
+		"isFinal": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.class.IsFinal), nil
+		},
+		"isInstantiable": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			if data.kind != "class" || reflectionClassIsAbstract(data) {
+				return values.NewBool(false), nil
+			}
+			ctor, _ := reflectionFindMethod(ctx, data.class, "__construct")
+			return values.NewBool(ctor == nil || reflectionVisibilityBit(ctor.Visibility) == reflectionIsPublic), nil
+		},
+		"isInternal": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(isBuiltinClassName(data.class.Name)), nil
+		},
+		"isUserDefined": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(!isBuiltinClassName(data.class.Name)), nil
+		},
+		"getParentClass": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			parent := reflectionParent(ctx, data.class)
+			if parent == nil {
+				return values.NewBool(false), nil
+			}
+			return newReflectionClass(parent, "class"), nil
+		},
+		"isSubclassOf": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			target := reflectionClassArgName(reflectionArg(args, 0))
+			if strings.EqualFold(strings.TrimPrefix(target, "\\"), data.class.Name) {
+				return values.NewBool(false), nil
+			}
+			return values.NewBool(reflectionIsA(ctx, data.class.Name, target)), nil
+		},
+		"implementsInterface": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			target := strings.TrimPrefix(reflectionClassArgName(reflectionArg(args, 0)), "\\")
+			if data.kind == "interface" && strings.EqualFold(target, data.class.Name) {
+				return values.NewBool(true), nil
+			}
+			for _, name := range reflectionInterfaceNames(ctx, data.class) {
+				if strings.EqualFold(name, target) {
+					return values.NewBool(true), nil
+				}
+			}
+			return values.NewBool(false), nil
+		},
+		"getInterfaceNames": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			var names []*values.Value
+			for _, name := range reflectionInterfaceNames(ctx, data.class) {
+				names = append(names, values.NewString(name))
+			}
+			return reflectionList(names), nil
+		},
+		"getInterfaces": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			result := values.NewArray()
+			for _, name := range reflectionInterfaceNames(ctx, data.class) {
+				if iface, kind, ok := reflectionLookupClass(ctx, name); ok {
+					result.ArraySet(values.NewString(iface.Name), newReflectionClass(iface, kind))
+				}
+			}
+			return result, nil
+		},
+		"getTraitNames": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			var names []*values.Value
+			for _, name := range data.class.Traits {
+				names = append(names, values.NewString(name))
+			}
+			return reflectionList(names), nil
+		},
+		"isInstance": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			obj := reflectionArg(args, 0)
+			if !obj.IsObject() {
+				return values.NewBool(false), nil
+			}
+			return values.NewBool(reflectionIsA(ctx, obj.Data.(*values.Object).ClassName, data.class.Name)), nil
+		},
+		"hasMethod": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			fn, _ := reflectionFindMethod(ctx, data.class, reflectionArg(args, 0).ToString())
+			return values.NewBool(fn != nil), nil
+		},
+		"getMethod": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			name := reflectionArg(args, 0).ToString()
+			fn, declaring := reflectionFindMethod(ctx, data.class, name)
+			if fn == nil {
+				return throwReflectionError(ctx, "ReflectionException", "Method %s::%s() does not exist", data.class.Name, name)
+			}
+			return newReflectionMethod(declaring, fn), nil
+		},
+		"getMethods": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			filter := reflectionFilter(args)
+			var methods []*values.Value
+			seen := make(map[string]bool)
+			for cls, depth := data.class, 0; cls != nil && depth < 64; cls, depth = reflectionParent(ctx, cls), depth+1 {
+				for _, name := range registry.OrderedNames(cls.MethodOrder, cls.Methods) {
+					fn := cls.Methods[name]
+					key := strings.ToLower(name)
+					if fn == nil || seen[key] {
+						continue
+					}
+					seen[key] = true
+					if filter != -1 && reflectionMethodModifiers(fn)&filter == 0 {
+						continue
+					}
+					methods = append(methods, newReflectionMethod(cls, fn))
+				}
+			}
+			return reflectionList(methods), nil
+		},
+		"getConstructor": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			fn, declaring := reflectionFindMethod(ctx, data.class, "__construct")
+			if fn == nil {
+				return values.NewNull(), nil
+			}
+			return newReflectionMethod(declaring, fn), nil
+		},
+		"hasProperty": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			prop, _ := reflectionFindProperty(ctx, data.class, reflectionArg(args, 0).ToString())
+			return values.NewBool(prop != nil), nil
+		},
+		"getProperty": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			name := reflectionArg(args, 0).ToString()
+			prop, declaring := reflectionFindProperty(ctx, data.class, name)
+			if prop == nil {
+				return throwReflectionError(ctx, "ReflectionException", "Property %s::$%s does not exist", data.class.Name, name)
+			}
+			return newReflectionProperty(declaring, prop, false), nil
+		},
+		"getProperties": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			filter := reflectionFilter(args)
+			var props []*values.Value
+			seen := make(map[string]bool)
+			for cls, depth := data.class, 0; cls != nil && depth < 64; cls, depth = reflectionParent(ctx, cls), depth+1 {
+				for _, name := range registry.OrderedNames(cls.PropertyOrder, cls.Properties) {
+					prop := cls.Properties[name]
+					if prop == nil || seen[name] || (depth > 0 && prop.Visibility == "private") {
+						continue
+					}
+					seen[name] = true
+					if filter != -1 && reflectionPropertyModifiers(prop)&filter == 0 {
+						continue
+					}
+					props = append(props, newReflectionProperty(cls, prop, false))
+				}
+			}
+			return reflectionList(props), nil
+		},
+		"hasConstant": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			_, ok := reflectionFindConstant(ctx, data.class, reflectionArg(args, 0).ToString())
+			return values.NewBool(ok), nil
+		},
+		"getConstant": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			if constant, ok := reflectionFindConstant(ctx, data.class, reflectionArg(args, 0).ToString()); ok {
+				return copyValue(constant.Value), nil
+			}
+			return values.NewBool(false), nil
+		},
+		"getConstants": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			result := values.NewArray()
+			seen := make(map[string]bool)
+			for cls, depth := data.class, 0; cls != nil && depth < 64; cls, depth = reflectionParent(ctx, cls), depth+1 {
+				for _, name := range registry.OrderedNames(cls.ConstantOrder, cls.Constants) {
+					if constant := cls.Constants[name]; constant != nil && !seen[name] {
+						seen[name] = true
+						result.ArraySet(values.NewString(name), copyValue(constant.Value))
+					}
+				}
+			}
+			return result, nil
+		},
+		"newInstance": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionNewInstance(ctx, data, args, true)
+		},
+		"newInstanceArgs": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionNewInstance(ctx, data, reflectionArgList(reflectionArg(args, 0)), true)
+		},
+		"newInstanceWithoutConstructor": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionNewInstance(ctx, data, nil, false)
+		},
+	}, "name")
+	desc.Constants["IS_IMPLICIT_ABSTRACT"] = &registry.ConstantDescriptor{Name: "IS_IMPLICIT_ABSTRACT", Visibility: "public", Value: values.NewInt(16)}
+	desc.Constants["IS_EXPLICIT_ABSTRACT"] = &registry.ConstantDescriptor{Name: "IS_EXPLICIT_ABSTRACT", Visibility: "public", Value: values.NewInt(64)}
+	desc.Constants["IS_FINAL"] = &registry.ConstantDescriptor{Name: "IS_FINAL", Visibility: "public", Value: values.NewInt(reflectionIsFinal)}
+	return desc
+}
+
+// getReflectionObjectClass returns ReflectionObject, which is ReflectionClass
+// constructed from an instance.
+func getReflectionObjectClass() *registry.ClassDescriptor {
+	return reflectionClassDescriptor("ReflectionObject", "ReflectionClass", false, nil)
+}
+
+func reflectionClassArgName(arg *values.Value) string {
+	if arg.IsObject() {
+		if data := reflectionDataOf(arg); data != nil && data.class != nil && data.function == nil && data.property == nil {
+			return data.class.Name
+		}
+		return arg.Data.(*values.Object).ClassName
+	}
+	return arg.ToString()
+}
+
+func reflectionClassIsAbstract(data *reflectionData) bool {
+	if data.class.IsAbstract || data.kind == "interface" {
+		return true
+	}
+	for _, fn := range data.class.Methods {
+		if fn != nil && fn.IsAbstract {
+			return true
+		}
+	}
+	return false
+}
+
+func reflectionFindConstant(ctx registry.BuiltinCallContext, cls *registry.Class, name string) (*registry.ClassConstant, bool) {
+	for depth := 0; cls != nil && depth < 64; depth++ {
+		if constant, ok := cls.Constants[name]; ok && constant != nil {
+			return constant, true
+		}
+		cls = reflectionParent(ctx, cls)
+	}
+	return nil, false
+}
+
+func reflectionNewInstance(ctx registry.BuiltinCallContext, data *reflectionData, args []*values.Value, construct bool) (*values.Value, error) {
+	switch {
+	case data.kind == "interface":
+		return throwReflectionError(ctx, "Error", "Cannot instantiate interface %s", data.class.Name)
+	case data.kind == "trait":
+		return throwReflectionError(ctx, "Error", "Cannot instantiate trait %s", data.class.Name)
+	case reflectionClassIsAbstract(data):
+		return throwReflectionError(ctx, "Error", "Cannot instantiate abstract class %s", data.class.Name)
+	}
+
+	obj, err := ctx.InstantiateClass(data.class.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !construct {
+		return obj, nil
+	}
+
+	ctor, _ := reflectionFindMethod(ctx, data.class, "__construct")
+	if ctor == nil {
+		if len(args) > 0 {
+			return throwReflectionError(ctx, "ReflectionException", "Class %s does not have a constructor, so you cannot pass any constructor arguments", data.class.Name)
+		}
+		return obj, nil
+	}
+	if reflectionVisibilityBit(ctor.Visibility) != reflectionIsPublic {
+		return throwReflectionError(ctx, "ReflectionException", "Access to non-public constructor of class %s", data.class.Name)
+	}
+	if _, err := ctx.CallMethod(ctor, obj, data.class.Name, args); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ---------------------------------------------------------------------------
+// ReflectionFunctionAbstract, ReflectionFunction and ReflectionMethod
+
+func getReflectionFunctionAbstractClass() *registry.ClassDescriptor {
+	return reflectionClassDescriptor("ReflectionFunctionAbstract", "", true, map[string]reflectionMethodFunc{
+		"getName": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewString(reflectionFunctionName(data)), nil
+		},
+		"getShortName": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewString(reflectionShortName(reflectionFunctionName(data))), nil
+		},
+		"getNamespaceName": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewString(reflectionNamespaceName(reflectionFunctionName(data))), nil
+		},
+		"inNamespace": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(strings.Contains(reflectionFunctionName(data), "\\")), nil
+		},
+		"getDocComment": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionStringOrFalse(data.function.DocComment), nil
+		},
+		"getAttributes": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			target := int64(attributeTargetFunction)
+			if data.class != nil && data.closure == nil {
+				target = attributeTargetMethod
+			}
+			return reflectionGetAttributes(ctx, data.function.Attributes, target, args)
+		},
+		"getParameters": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			params := make([]*values.Value, 0, len(data.function.Parameters))
+			for i := range data.function.Parameters {
+				params = append(params, newReflectionParameter(data, i))
+			}
+			return reflectionList(params), nil
+		},
+		"getNumberOfParameters": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewInt(int64(len(data.function.Parameters))), nil
+		},
+		"getNumberOfRequiredParameters": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			required := 0
+			for i := range data.function.Parameters {
+				if !reflectionParameterIsOptional(data.function, i) {
+					required = i + 1
+				}
+			}
+			return values.NewInt(int64(required)), nil
+		},
+		"hasReturnType": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.function.ReturnType != ""), nil
+		},
+		"getReturnType": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return newReflectionType(data.function.ReturnType), nil
+		},
+		"isVariadic": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.function.IsVariadic), nil
+		},
+		"returnsReference": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.function.ReturnsByReference), nil
+		},
+		"isGenerator": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.function.IsGenerator), nil
+		},
+		"isClosure": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.closure != nil), nil
+		},
+		"isInternal": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.function.IsBuiltin), nil
+		},
+		"isUserDefined": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(!data.function.IsBuiltin), nil
+		},
+	}, "name")
+}
+
+func reflectionFunctionName(data *reflectionData) string {
+	if data.closure != nil && data.function.IsAnonymous {
+		return "{closure}"
+	}
+	return data.function.Name
+}
+
+func getReflectionFunctionClass() *registry.ClassDescriptor {
+	return reflectionClassDescriptor("ReflectionFunction", "ReflectionFunctionAbstract", false, map[string]reflectionMethodFunc{
+		"__construct": func(ctx registry.BuiltinCallContext, this *values.Value, _ *reflectionData, args []*values.Value) (*values.Value, error) {
+			target := reflectionArg(args, 0)
+			data := &reflectionData{}
+			if target.IsCallable() {
+				closure := target.ClosureGet()
+				data.closure = target
+				switch fn := closure.Function.(type) {
+				case *registry.Function:
+					data.function = fn
+				case string:
+					// First-class callables created from methods
+					var owner *values.Value
+					switch fn {
+					case "bound_method":
+						data.this = closure.BoundVars["object"]
+						owner = data.this
+					case "static_method":
+						owner = closure.BoundVars["class"]
+					}
+					if owner != nil {
+						if cls, _, ok := reflectionLookupClass(ctx, reflectionClassArgName(owner)); ok {
+							data.function, data.class = reflectionFindMethod(ctx, cls, closure.BoundVars["method"].ToString())
+						}
+					}
+				}
+				if data.function == nil {
+					return throwReflectionError(ctx, "ReflectionException", "Closure could not be reflected")
+				}
+			} else {
+				name := strings.TrimPrefix(target.ToString(), "\\")
+				if fn, ok := ctx.LookupUserFunction(name); ok && fn != nil {
+					data.function = fn
+				} else if reg := ctx.SymbolRegistry(); reg != nil {
+					if fn, ok := reg.GetFunction(name); ok && fn != nil {
+						data.function = fn
+					}
+				}
+				if data.function == nil {
+					return throwReflectionError(ctx, "ReflectionException", "Function %s() does not exist", name)
+				}
+			}
+			attachReflectionData(this, data, "name", reflectionFunctionName(data))
+			return this, nil
+		},
+		"invoke": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionInvokeFunction(ctx, data, args)
+		},
+		"invokeArgs": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionInvokeFunction(ctx, data, reflectionArgList(reflectionArg(args, 0)))
+		},
+		"isAnonymous": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.closure != nil && data.function.IsAnonymous), nil
+		},
+	})
+}
+
+func reflectionInvokeFunction(ctx registry.BuiltinCallContext, data *reflectionData, args []*values.Value) (*values.Value, error) {
+	if data.class != nil {
+		className := data.class.Name
+		if data.this != nil && data.this.IsObject() {
+			className = data.this.Data.(*values.Object).ClassName
+		}
+		return ctx.CallMethod(data.function, data.this, className, args)
+	}
+	return ctx.CallMethod(data.function, nil, "", args)
+}
+
+func getReflectionMethodClass() *registry.ClassDescriptor {
+	desc := reflectionClassDescriptor("ReflectionMethod", "ReflectionFunctionAbstract", false, map[string]reflectionMethodFunc{
+		"__construct": func(ctx registry.BuiltinCallContext, this *values.Value, _ *reflectionData, args []*values.Value) (*values.Value, error) {
+			target := reflectionArg(args, 0)
+			methodName := reflectionArg(args, 1)
+			var className, name string
+			if methodName.IsNull() {
+				var ok bool
+				if className, name, ok = strings.Cut(target.ToString(), "::"); !ok {
+					return throwReflectionError(ctx, "ReflectionException", "ReflectionMethod::__construct(): Argument #1 ($objectOrMethod) must be a valid method name")
+				}
+			} else {
+				className = reflectionClassArgName(target)
+				name = methodName.ToString()
+			}
+			cls, _, ok := reflectionLookupClass(ctx, className)
+			if !ok {
+				return throwReflectionError(ctx, "ReflectionException", "Class \"%s\" does not exist", className)
+			}
+			fn, declaring := reflectionFindMethod(ctx, cls, name)
+			if fn == nil {
+				return throwReflectionError(ctx, "ReflectionException", "Method %s::%s() does not exist", cls.Name, name)
+			}
+			attachReflectionData(this, &reflectionData{class: declaring, kind: "class", function: fn}, "name", fn.Name, "class", declaring.Name)
+			return this, nil
+		},
+		"isPublic": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionVisibilityBit(data.function.Visibility) == reflectionIsPublic), nil
+		},
+		"isProtected": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionVisibilityBit(data.function.Visibility) == reflectionIsProtected), nil
+		},
+		"isPrivate": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionVisibilityBit(data.function.Visibility) == reflectionIsPrivate), nil
+		},
+		"isStatic": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.function.IsStatic), nil
+		},
+		"isAbstract": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.function.IsAbstract), nil
+		},
+		"isFinal": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.function.IsFinal), nil
+		},
+		"isConstructor": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(strings.EqualFold(data.function.Name, "__construct")), nil
+		},
+		"isDestructor": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(strings.EqualFold(data.function.Name, "__destruct")), nil
+		},
+		"getModifiers": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewInt(reflectionMethodModifiers(data.function)), nil
+		},
+		"getDeclaringClass": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return newReflectionClass(data.class, data.kind), nil
+		},
+		"setAccessible": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			// Reflection always has access to non-public members since PHP 8.1
+			return values.NewNull(), nil
+		},
+		"invoke": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			var rest []*values.Value
+			if len(args) > 1 {
+				rest = args[1:]
+			}
+			return reflectionInvokeMethod(ctx, data, reflectionArg(args, 0), rest)
+		},
+		"invokeArgs": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionInvokeMethod(ctx, data, reflectionArg(args, 0), reflectionArgList(reflectionArg(args, 1)))
+		},
+		"getClosure": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			object := reflectionArg(args, 0)
+			method := values.NewString(data.function.Name)
+			if data.function.IsStatic || !object.IsObject() {
+				bound := map[string]*values.Value{"class": values.NewString(data.class.Name), "method": method}
+				return values.NewClosure("static_method", bound, fmt.Sprintf("%s::%s", data.class.Name, data.function.Name)), nil
+			}
+			bound := map[string]*values.Value{"object": object, "method": method}
+			return values.NewClosure("bound_method", bound, fmt.Sprintf("%s->%s", object.Data.(*values.Object).ClassName, data.function.Name)), nil
+		},
+	}, "class")
+	for name, value := range map[string]int64{
+		"IS_STATIC":    reflectionIsStatic,
+		"IS_PUBLIC":    reflectionIsPublic,
+		"IS_PROTECTED": reflectionIsProtected,
+		"IS_PRIVATE":   reflectionIsPrivate,
+		"IS_ABSTRACT":  reflectionIsAbstract,
+		"IS_FINAL":     reflectionIsFinal,
+	} {
+		desc.Constants[name] = &registry.ConstantDescriptor{Name: name, Visibility: "public", Value: values.NewInt(value)}
+	}
+	return desc
+}
+
+func reflectionInvokeMethod(ctx registry.BuiltinCallContext, data *reflectionData, object *values.Value, args []*values.Value) (*values.Value, error) {
+	fn := data.function
+	if fn.IsAbstract {
+		return throwReflectionError(ctx, "ReflectionException", "Trying to invoke abstract method %s::%s()", data.class.Name, fn.Name)
+	}
+	if fn.IsStatic {
+		return ctx.CallMethod(fn, nil, data.class.Name, args)
+	}
+	if !object.IsObject() {
+		return throwReflectionError(ctx, "ReflectionException", "Trying to invoke non static method %s::%s() without an object", data.class.Name, fn.Name)
+	}
+	className := object.Data.(*values.Object).ClassName
+	if !reflectionIsA(ctx, className, data.class.Name) {
+		return throwReflectionError(ctx, "ReflectionException", "Given object is not an instance of the class this method was declared in")
+	}
+	return ctx.CallMethod(fn, object, className, args)
+}
+
+// ---------------------------------------------------------------------------
+// ReflectionParameter
+
+func reflectionParameterIsVariadic(fn *registry.Function, position int) bool {
+	return fn.IsVariadic && position == len(fn.Parameters)-1
+}
+
+// reflectionParameterIsOptional reports whether the parameter at position
+// and every parameter after it can be omitted.
+func reflectionParameterIsOptional(fn *registry.Function, position int) bool {
+	for i := position; i < len(fn.Parameters); i++ {
+		if !fn.Parameters[i].HasDefault && !reflectionParameterIsVariadic(fn, i) {
+			return false
+		}
+	}
+	return true
+}
+
+// reflectionParameterName strips the leading "$" that closure parameters
+// keep in their compiled names.
+func reflectionParameterName(param *registry.Parameter) string {
+	return strings.TrimPrefix(param.Name, "$")
+}
+
+func getReflectionParameterClass() *registry.ClassDescriptor {
+	return reflectionClassDescriptor("ReflectionParameter", "", false, map[string]reflectionMethodFunc{
+		"getName": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewString(reflectionParameterName(data.param)), nil
+		},
+		"getAttributes": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionGetAttributes(ctx, data.param.Attributes, attributeTargetParameter, args)
+		},
+		"getPosition": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewInt(int64(data.position)), nil
+		},
+		"hasType": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.param.Type != ""), nil
+		},
+		"getType": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return newReflectionType(data.param.Type), nil
+		},
+		"allowsNull": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionTypeAllowsNull(data.param.Type)), nil
+		},
+		"isOptional": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionParameterIsOptional(data.function, data.position)), nil
+		},
+		"isDefaultValueAvailable": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.param.HasDefault), nil
+		},
+		"getDefaultValue": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			if !data.param.HasDefault {
+				return throwReflectionError(ctx, "ReflectionException", "Internal error: Failed to retrieve the default value")
+			}
+			if data.param.DefaultValue == nil {
+				return values.NewNull(), nil
+			}
+			return copyValue(data.param.DefaultValue), nil
+		},
+		"isVariadic": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionParameterIsVariadic(data.function, data.position)), nil
+		},
+		"isPassedByReference": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.param.IsReference), nil
+		},
+		"canBePassedByValue": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(!data.param.IsReference), nil
+		},
+		"isPromoted": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.param.IsPromoted), nil
+		},
+		"getDeclaringFunction": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			if data.class != nil && data.closure == nil {
+				return newReflectionMethod(data.class, data.function), nil
+			}
+			fnData := &reflectionData{class: data.class, kind: data.kind, function: data.function, this: data.this, closure: data.closure}
+			return attachReflectionData(values.NewObject("ReflectionFunction"), fnData, "name", reflectionFunctionName(fnData)), nil
+		},
+		"getDeclaringClass": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			if data.class == nil {
+				return values.NewNull(), nil
+			}
+			return newReflectionClass(data.class, data.kind), nil
+		},
+	}, "name")
+}
+
+// ---------------------------------------------------------------------------
+// ReflectionProperty
+
+func getReflectionPropertyClass() *registry.ClassDescriptor {
+	desc := reflectionClassDescriptor("ReflectionProperty", "", false, map[string]reflectionMethodFunc{
+		"__construct": func(ctx registry.BuiltinCallContext, this *values.Value, _ *reflectionData, args []*values.Value) (*values.Value, error) {
+			target := reflectionArg(args, 0)
+			name := reflectionArg(args, 1).ToString()
+			className := reflectionClassArgName(target)
+			cls, _, ok := reflectionLookupClass(ctx, className)
+			if !ok {
+				return throwReflectionError(ctx, "ReflectionException", "Class \"%s\" does not exist", className)
+			}
+			prop, declaring := reflectionFindProperty(ctx, cls, name)
+			dynamic := false
+			if prop == nil && target.IsObject() {
+				if _, exists := target.Data.(*values.Object).Properties[name]; exists {
+					prop = &registry.Property{Name: name, Visibility: "public"}
+					declaring = cls
+					dynamic = true
+				}
+			}
+			if prop == nil {
+				return throwReflectionError(ctx, "ReflectionException", "Property %s::$%s does not exist", cls.Name, name)
+			}
+			attachReflectionData(this, &reflectionData{class: declaring, kind: "class", property: prop, dynamic: dynamic}, "name", prop.Name, "class", declaring.Name)
+			return this, nil
+		},
+		"getName": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewString(data.property.Name), nil
+		},
+		"getAttributes": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionGetAttributes(ctx, data.property.Attributes, attributeTargetProperty, args)
+		},
+		"getValue": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			if data.property.IsStatic {
+				return throwReflectionError(ctx, "ReflectionException", "Cannot read static property %s::$%s through reflection", data.class.Name, data.property.Name)
+			}
+			object := reflectionArg(args, 0)
+			if !object.IsObject() {
+				return throwReflectionError(ctx, "TypeError", "ReflectionProperty::getValue(): Argument #1 ($object) must be provided for instance properties")
+			}
+			if value, ok := object.Data.(*values.Object).Properties[data.property.Name]; ok && value != nil {
+				return value, nil
+			}
+			if data.property.Type != "" {
+				return throwReflectionError(ctx, "Error", "Typed property %s::$%s must not be accessed before initialization", data.class.Name, data.property.Name)
+			}
+			return values.NewNull(), nil
+		},
+		"setValue": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			if data.property.IsStatic {
+				return throwReflectionError(ctx, "ReflectionException", "Cannot write static property %s::$%s through reflection", data.class.Name, data.property.Name)
+			}
+			object := reflectionArg(args, 0)
+			if !object.IsObject() {
+				return throwReflectionError(ctx, "TypeError", "ReflectionProperty::setValue(): Argument #1 ($objectOrValue) must be of type object")
+			}
+			obj := object.Data.(*values.Object)
+			if data.property.IsReadonly {
+				if current, ok := obj.Properties[data.property.Name]; ok && current != nil {
+					return throwReflectionError(ctx, "Error", "Cannot modify readonly property %s::$%s", obj.ClassName, data.property.Name)
+				}
+			}
+			obj.Properties[data.property.Name] = copyValue(reflectionArg(args, 1))
+			return values.NewNull(), nil
+		},
+		"isInitialized": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			object := reflectionArg(args, 0)
+			if !object.IsObject() {
+				return values.NewBool(data.property.IsStatic), nil
+			}
+			value, ok := object.Data.(*values.Object).Properties[data.property.Name]
+			return values.NewBool(ok && value != nil), nil
+		},
+		"isPublic": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionVisibilityBit(data.property.Visibility) == reflectionIsPublic), nil
+		},
+		"isProtected": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionVisibilityBit(data.property.Visibility) == reflectionIsProtected), nil
+		},
+		"isPrivate": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionVisibilityBit(data.property.Visibility) == reflectionIsPrivate), nil
+		},
+		"isStatic": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.property.IsStatic), nil
+		},
+		"isReadOnly": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.property.IsReadonly), nil
+		},
+		"isDefault": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(!data.dynamic), nil
+		},
+		"getModifiers": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewInt(reflectionPropertyModifiers(data.property)), nil
+		},
+		"getDocComment": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return reflectionStringOrFalse(data.property.DocComment), nil
+		},
+		"getDeclaringClass": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return newReflectionClass(data.class, data.kind), nil
+		},
+		"hasType": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(data.property.Type != ""), nil
+		},
+		"getType": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return newReflectionType(data.property.Type), nil
+		},
+		"hasDefaultValue": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			// Untyped properties default to null; typed ones need an explicit default
+			return values.NewBool(!data.dynamic && (data.property.DefaultValue != nil || data.property.Type == "")), nil
+		},
+		"getDefaultValue": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			if data.property.DefaultValue == nil {
+				return values.NewNull(), nil
+			}
+			return copyValue(data.property.DefaultValue), nil
+		},
+		"setAccessible": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			// Reflection always has access to non-public members since PHP 8.1
+			return values.NewNull(), nil
+		},
+	}, "name", "class")
+	for name, value := range map[string]int64{
+		"IS_STATIC":    reflectionIsStatic,
+		"IS_READONLY":  reflectionIsReadonly,
+		"IS_PUBLIC":    reflectionIsPublic,
+		"IS_PROTECTED": reflectionIsProtected,
+		"IS_PRIVATE":   reflectionIsPrivate,
+	} {
+		desc.Constants[name] = &registry.ConstantDescriptor{Name: name, Visibility: "public", Value: values.NewInt(value)}
+	}
+	return desc
+}
+
+// ---------------------------------------------------------------------------
+// ReflectionType, ReflectionNamedType and ReflectionUnionType
+
+func getReflectionTypeClass() *registry.ClassDescriptor {
+	return reflectionClassDescriptor("ReflectionType", "", true, map[string]reflectionMethodFunc{
+		"allowsNull": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionTypeAllowsNull(data.typeName)), nil
+		},
+		"__toString": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewString(data.typeName), nil
+		},
+	})
+}
+
+func getReflectionNamedTypeClass() *registry.ClassDescriptor {
+	return reflectionClassDescriptor("ReflectionNamedType", "ReflectionType", false, map[string]reflectionMethodFunc{
+		"getName": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewString(strings.TrimPrefix(data.typeName, "?")), nil
+		},
+		"isBuiltin": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			return values.NewBool(reflectionBuiltinTypes[strings.ToLower(strings.TrimPrefix(data.typeName, "?"))]), nil
+		},
+	})
+}
+
+func getReflectionUnionTypeClass() *registry.ClassDescriptor {
+	return reflectionClassDescriptor("ReflectionUnionType", "ReflectionType", false, map[string]reflectionMethodFunc{
+		"getTypes": func(ctx registry.BuiltinCallContext, this *values.Value, data *reflectionData, args []*values.Value) (*values.Value, error) {
+			var types []*values.Value
+			for _, member := range strings.Split(data.typeName, "|") {
+				types = append(types, attachReflectionData(values.NewObject("ReflectionNamedType"), &reflectionData{typeName: member}))
+			}
+			return reflectionList(types), nil
+		},
+	})
+}
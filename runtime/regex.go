@@ -2,12 +2,16 @@ package runtime
 
 import (
 	"container/list"
+	"errors"
 	"fmt"
-	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/wudi/hey/pkg/pcre"
 	"github.com/wudi/hey/registry"
 	"github.com/wudi/hey/values"
 )
@@ -38,7 +42,7 @@ const (
 
 // cachedRegex represents a cached compiled regex with metadata
 type cachedRegex struct {
-	regex       *regexp.Regexp
+	regex       *pcre.Regexp
 	compiledAt  time.Time
 	accessCount int64
 	lastAccess  time.Time
@@ -84,7 +88,7 @@ func getRegexCache() *regexCache {
 }
 
 // get retrieves a compiled regex from the cache
-func (c *regexCache) get(pattern string) (*regexp.Regexp, bool) {
+func (c *regexCache) get(pattern string) (*pcre.Regexp, bool) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -115,7 +119,7 @@ func (c *regexCache) get(pattern string) (*regexp.Regexp, bool) {
 }
 
 // put stores a compiled regex in the cache
-func (c *regexCache) put(pattern string, regex *regexp.Regexp) {
+func (c *regexCache) put(pattern string, regex *pcre.Regexp) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -263,56 +267,143 @@ func clearRegexError() {
 	lastErrorMsg = ""
 }
 
-// parsePhpPattern parses PHP regex pattern with delimiters and flags
-func parsePhpPattern(pattern string) (string, string, error) {
-	if len(pattern) < 2 {
-		return "", "", fmt.Errorf("invalid pattern: too short")
-	}
-
-	// Find delimiter (first character)
-	delimiter := pattern[0:1]
+// PCRE flag constants
+const (
+	PREG_PATTERN_ORDER        = 1
+	PREG_SET_ORDER            = 2
+	PREG_OFFSET_CAPTURE       = 256
+	PREG_UNMATCHED_AS_NULL    = 512
+	PREG_SPLIT_NO_EMPTY       = 1
+	PREG_SPLIT_DELIM_CAPTURE  = 2
+	PREG_SPLIT_OFFSET_CAPTURE = 4
+	PREG_GREP_INVERT          = 1
+)
 
-	// Find closing delimiter
-	lastPos := strings.LastIndex(pattern[1:], delimiter)
-	if lastPos == -1 {
-		return "", "", fmt.Errorf("no ending delimiter '%s' found", delimiter)
-	}
+// pcreVersion is the PCRE2 release whose behavior pkg/pcre follows
+const pcreVersion = "10.42 2022-12-11"
+
+// regexErrorMessages are the messages preg_last_error_msg gives for each
+// error code
+var regexErrorMessages = map[int]string{
+	PREG_INTERNAL_ERROR:        "Internal error",
+	PREG_BACKTRACK_LIMIT_ERROR: "Backtrack limit exhausted",
+	PREG_RECURSION_LIMIT_ERROR: "Recursion limit exhausted",
+	PREG_BAD_UTF8_ERROR:        "Malformed UTF-8 characters, possibly incorrectly encoded",
+	PREG_BAD_UTF8_OFFSET_ERROR: "The offset did not correspond to the beginning of a valid UTF-8 code point",
+	PREG_JIT_STACKLIMIT_ERROR:  "JIT stack limit exhausted",
+}
 
-	// Extract pattern and flags
-	actualPattern := pattern[1 : lastPos+1]
-	flags := ""
-	if len(pattern) > lastPos+2 {
-		flags = pattern[lastPos+2:]
+// GetRegexConstants returns the PREG_* and PCRE_* constants
+func GetRegexConstants() []*registry.ConstantDescriptor {
+	return []*registry.ConstantDescriptor{
+		{Name: "PREG_PATTERN_ORDER", Value: values.NewInt(PREG_PATTERN_ORDER)},
+		{Name: "PREG_SET_ORDER", Value: values.NewInt(PREG_SET_ORDER)},
+		{Name: "PREG_OFFSET_CAPTURE", Value: values.NewInt(PREG_OFFSET_CAPTURE)},
+		{Name: "PREG_UNMATCHED_AS_NULL", Value: values.NewInt(PREG_UNMATCHED_AS_NULL)},
+		{Name: "PREG_SPLIT_NO_EMPTY", Value: values.NewInt(PREG_SPLIT_NO_EMPTY)},
+		{Name: "PREG_SPLIT_DELIM_CAPTURE", Value: values.NewInt(PREG_SPLIT_DELIM_CAPTURE)},
+		{Name: "PREG_SPLIT_OFFSET_CAPTURE", Value: values.NewInt(PREG_SPLIT_OFFSET_CAPTURE)},
+		{Name: "PREG_GREP_INVERT", Value: values.NewInt(PREG_GREP_INVERT)},
+		{Name: "PREG_NO_ERROR", Value: values.NewInt(PREG_NO_ERROR)},
+		{Name: "PREG_INTERNAL_ERROR", Value: values.NewInt(PREG_INTERNAL_ERROR)},
+		{Name: "PREG_BACKTRACK_LIMIT_ERROR", Value: values.NewInt(PREG_BACKTRACK_LIMIT_ERROR)},
+		{Name: "PREG_RECURSION_LIMIT_ERROR", Value: values.NewInt(PREG_RECURSION_LIMIT_ERROR)},
+		{Name: "PREG_BAD_UTF8_ERROR", Value: values.NewInt(PREG_BAD_UTF8_ERROR)},
+		{Name: "PREG_BAD_UTF8_OFFSET_ERROR", Value: values.NewInt(PREG_BAD_UTF8_OFFSET_ERROR)},
+		{Name: "PREG_JIT_STACKLIMIT_ERROR", Value: values.NewInt(PREG_JIT_STACKLIMIT_ERROR)},
+		{Name: "PCRE_VERSION", Value: values.NewString(pcreVersion)},
+		{Name: "PCRE_VERSION_MAJOR", Value: values.NewInt(10)},
+		{Name: "PCRE_VERSION_MINOR", Value: values.NewInt(42)},
+		{Name: "PCRE_JIT_SUPPORT", Value: values.NewBool(false)},
 	}
+}
 
-	return actualPattern, flags, nil
+// setRegexErrorCode sets the last regex error with its standard message
+func setRegexErrorCode(errorCode int) {
+	setRegexError(errorCode, regexErrorMessages[errorCode])
 }
 
-// convertPhpFlags converts PHP regex flags to Go regex syntax
-func convertPhpFlags(pattern string, flags string) (string, error) {
-	var goPattern strings.Builder
+// parsePhpPattern splits a PHP pattern into the regex between its
+// delimiters and the flags its modifiers select
+func parsePhpPattern(pattern string) (string, pcre.Flags, error) {
+	pattern = strings.TrimLeft(pattern, " \t\n\r\v\f")
+	if pattern == "" {
+		return "", 0, fmt.Errorf("empty regular expression")
+	}
 
-	// Handle case-insensitive flag
-	if strings.Contains(flags, "i") {
-		goPattern.WriteString("(?i)")
+	delimiter := pattern[0]
+	if delimiter == '\\' || delimiter == 0 || delimiter < utf8.RuneSelf &&
+		(unicode.IsLetter(rune(delimiter)) || unicode.IsDigit(rune(delimiter))) {
+		return "", 0, fmt.Errorf("delimiter must not be alphanumeric, backslash, or NUL")
 	}
 
-	// Handle multiline flag
-	if strings.Contains(flags, "m") {
-		goPattern.WriteString("(?m)")
+	// Bracket delimiters close with their pair and may nest
+	closing := delimiter
+	switch delimiter {
+	case '(':
+		closing = ')'
+	case '[':
+		closing = ']'
+	case '{':
+		closing = '}'
+	case '<':
+		closing = '>'
 	}
 
-	// Handle dot-all flag (. matches newlines)
-	if strings.Contains(flags, "s") {
-		goPattern.WriteString("(?s)")
+	end, depth := -1, 0
+	for i := 1; i < len(pattern) && end < 0; i++ {
+		switch c := pattern[i]; {
+		case c == '\\':
+			i++
+		case c == closing && depth == 0:
+			end = i
+		case c == closing:
+			depth--
+		case c == delimiter && closing != delimiter:
+			depth++
+		}
+	}
+	if end < 0 {
+		if closing != delimiter {
+			return "", 0, fmt.Errorf("no ending matching delimiter '%c' found", closing)
+		}
+		return "", 0, fmt.Errorf("no ending delimiter '%c' found", delimiter)
 	}
 
-	goPattern.WriteString(pattern)
-	return goPattern.String(), nil
+	var flags pcre.Flags
+	for _, modifier := range pattern[end+1:] {
+		switch modifier {
+		case 'i':
+			flags |= pcre.Caseless
+		case 'm':
+			flags |= pcre.Multiline
+		case 's':
+			flags |= pcre.DotAll
+		case 'x':
+			flags |= pcre.Extended
+		case 'A':
+			flags |= pcre.Anchored
+		case 'D':
+			flags |= pcre.DollarEndOnly
+		case 'U':
+			flags |= pcre.Ungreedy
+		case 'u':
+			flags |= pcre.UTF
+		case 'n':
+			flags |= pcre.NoAutoCapture
+		case 'J':
+			flags |= pcre.DupNames
+		case 'S', 'X', ' ', '\n', '\r':
+			// Study and extra checking are always on
+		default:
+			return "", 0, fmt.Errorf("unknown modifier '%c'", modifier)
+		}
+	}
+	return pattern[1:end], flags, nil
 }
 
 // compilePhpRegex compiles a PHP-style regex pattern with caching
-func compilePhpRegex(pattern string) (*regexp.Regexp, error) {
+func compilePhpRegex(pattern string) (*pcre.Regexp, error) {
 	clearRegexError()
 
 	// Try to get from cache first
@@ -322,22 +413,16 @@ func compilePhpRegex(pattern string) (*regexp.Regexp, error) {
 	}
 
 	// Cache miss - compile the pattern
-	actualPattern, flags, err := parsePhpPattern(pattern)
+	body, flags, err := parsePhpPattern(pattern)
 	if err != nil {
-		setRegexError(PREG_INTERNAL_ERROR, err.Error())
+		setRegexErrorCode(PREG_INTERNAL_ERROR)
 		return nil, err
 	}
 
-	goPattern, err := convertPhpFlags(actualPattern, flags)
+	regex, err := pcre.Compile(body, flags)
 	if err != nil {
-		setRegexError(PREG_INTERNAL_ERROR, err.Error())
-		return nil, err
-	}
-
-	regex, err := regexp.Compile(goPattern)
-	if err != nil {
-		setRegexError(PREG_INTERNAL_ERROR, err.Error())
-		return nil, err
+		setRegexErrorCode(PREG_INTERNAL_ERROR)
+		return nil, fmt.Errorf("compilation failed: %w", err)
 	}
 
 	// Store in cache for future use
@@ -346,6 +431,400 @@ func compilePhpRegex(pattern string) (*regexp.Regexp, error) {
 	return regex, nil
 }
 
+// regexLimits returns the match options for the pcre ini limits
+func regexLimits() pcre.MatchOptions {
+	var opts pcre.MatchOptions
+	if limit, ok := GetIniValue("pcre.backtrack_limit"); ok {
+		opts.MatchLimit, _ = strconv.Atoi(limit)
+	}
+	if limit, ok := GetIniValue("pcre.recursion_limit"); ok {
+		opts.DepthLimit, _ = strconv.Atoi(limit)
+	}
+	return opts
+}
+
+// checkRegexSubject checks that a UTF-8 pattern is matched against valid
+// UTF-8 from a character boundary
+func checkRegexSubject(regex *pcre.Regexp, subject string, offset int) bool {
+	if !regex.UTF() {
+		return true
+	}
+	if !utf8.ValidString(subject) {
+		setRegexErrorCode(PREG_BAD_UTF8_ERROR)
+		return false
+	}
+	if offset < len(subject) && !utf8.RuneStart(subject[offset]) {
+		setRegexErrorCode(PREG_BAD_UTF8_OFFSET_ERROR)
+		return false
+	}
+	return true
+}
+
+// execRegex finds the first match at or after offset. It reports false,
+// setting the last regex error, when a limit stops the match.
+func execRegex(regex *pcre.Regexp, subject string, offset int, opts pcre.MatchOptions) ([]int, bool) {
+	match, err := regex.Match(subject, offset, opts)
+	switch {
+	case errors.Is(err, pcre.ErrMatchLimit):
+		setRegexErrorCode(PREG_BACKTRACK_LIMIT_ERROR)
+		return nil, false
+	case errors.Is(err, pcre.ErrDepthLimit):
+		setRegexErrorCode(PREG_RECURSION_LIMIT_ERROR)
+		return nil, false
+	case err != nil:
+		setRegexErrorCode(PREG_INTERNAL_ERROR)
+		return nil, false
+	}
+	return match, true
+}
+
+// eachRegexMatch calls fn with each match from offset until it returns
+// false. After an empty match it looks for a non-empty one at the same
+// position before moving on a character, as Perl's /g does. It reports
+// false when a match fails with an error.
+func eachRegexMatch(regex *pcre.Regexp, subject string, offset int, fn func(match []int) bool) bool {
+	opts := regexLimits()
+	pos, afterEmpty := offset, false
+	for pos <= len(subject) {
+		attempt := opts
+		attempt.NotEmptyAtStart, attempt.Anchored = afterEmpty, afterEmpty
+		match, ok := execRegex(regex, subject, pos, attempt)
+		if !ok {
+			return false
+		}
+		if match == nil {
+			if !afterEmpty || pos >= len(subject) {
+				break
+			}
+			_, width := utf8.DecodeRuneInString(subject[pos:])
+			if !regex.UTF() {
+				width = 1
+			}
+			pos += width
+			afterEmpty = false
+			continue
+		}
+		if !fn(match) {
+			break
+		}
+		pos, afterEmpty = match[1], match[0] == match[1]
+	}
+	return true
+}
+
+// regexOffset returns the start offset given as args[i], counting a
+// negative offset from the end of subject
+func regexOffset(args []*values.Value, i int, subject string) int {
+	if len(args) <= i || args[i] == nil {
+		return 0
+	}
+	offset := int(args[i].ToInt())
+	if offset < 0 {
+		offset = max(len(subject)+offset, 0)
+	}
+	return offset
+}
+
+// regexIntArg returns the optional int argument args[i]
+func regexIntArg(args []*values.Value, i int, def int) int {
+	if len(args) <= i || args[i] == nil || args[i].IsNull() {
+		return def
+	}
+	return int(args[i].ToInt())
+}
+
+// setRegexResult stores a result in a by-reference argument such as
+// $matches or $count
+func setRegexResult(args []*values.Value, i int, result *values.Value) {
+	if len(args) <= i || args[i] == nil {
+		return
+	}
+	if args[i].Type == values.TypeReference {
+		ref := args[i].Data.(*values.Reference)
+		if ref.Target == nil {
+			ref.Target = result
+		} else {
+			*ref.Target = *result
+		}
+		return
+	}
+	*args[i] = *result
+}
+
+// regexGroup returns the value of group i of a match, as a string, or as a
+// [string, offset] pair with PREG_OFFSET_CAPTURE. Groups that did not take
+// part are "" at offset -1, or null with PREG_UNMATCHED_AS_NULL.
+func regexGroup(subject string, match []int, i int, flags int) *values.Value {
+	start, end := match[2*i], match[2*i+1]
+	var text *values.Value
+	switch {
+	case start >= 0:
+		text = values.NewString(subject[start:end])
+	case flags&PREG_UNMATCHED_AS_NULL != 0:
+		text = values.NewNull()
+	default:
+		text = values.NewString("")
+	}
+	if flags&PREG_OFFSET_CAPTURE == 0 {
+		return text
+	}
+	return values.NewList(text, values.NewInt(int64(start)))
+}
+
+// regexMatchArray builds the array preg_match gives for a match. Named
+// groups appear under their name as well, before their number. Groups left
+// unset after the last one that matched are left out, unless unmatched
+// groups are null.
+func regexMatchArray(regex *pcre.Regexp, subject string, match []int, flags int) *values.Value {
+	count := len(match) / 2
+	if flags&PREG_UNMATCHED_AS_NULL == 0 {
+		for count > 1 && match[2*(count-1)] < 0 {
+			count--
+		}
+	}
+	result := values.NewArray()
+	arr := result.Data.(*values.Array)
+	names := regex.GroupNames()
+	for i := 0; i < count; i++ {
+		if names[i] != "" {
+			arr.Set(names[i], regexGroup(subject, match, i, flags))
+		}
+		arr.Set(int64(i), regexGroup(subject, match, i, flags))
+	}
+	return result
+}
+
+// regexPatternOrder builds the array preg_match_all gives with
+// PREG_PATTERN_ORDER: for each group, its value in every match
+func regexPatternOrder(regex *pcre.Regexp, subject string, matches [][]int, flags int) *values.Value {
+	result := values.NewArray()
+	arr := result.Data.(*values.Array)
+	for i, name := range regex.GroupNames() {
+		group := values.NewArray()
+		for _, match := range matches {
+			group.Data.(*values.Array).Append(regexGroup(subject, match, i, flags))
+		}
+		if name != "" {
+			arr.Set(name, values.NewArrayValue(group.Data.(*values.Array).Copy()))
+		}
+		arr.Set(int64(i), group)
+	}
+	return result
+}
+
+// expandReplacement substitutes the \n, $n and ${n} references of a
+// preg_replace replacement. A backslash before \ or $ makes it literal.
+func expandReplacement(replacement, subject string, match []int) string {
+	result := make([]byte, 0, len(replacement))
+	var last byte
+	for i := 0; i < len(replacement); {
+		c := replacement[i]
+		if c == '\\' || c == '$' {
+			if last == '\\' {
+				result[len(result)-1] = c
+				last = 0
+				i++
+				continue
+			}
+			if group, width, ok := replacementReference(replacement[i:]); ok {
+				if group < len(match)/2 && match[2*group] >= 0 {
+					result = append(result, subject[match[2*group]:match[2*group+1]]...)
+				}
+				i += width
+				last = replacement[i-1]
+				continue
+			}
+		}
+		result = append(result, c)
+		last = c
+		i++
+	}
+	return string(result)
+}
+
+// replacementReference parses a group reference of up to two digits at the
+// start of s
+func replacementReference(s string) (int, int, bool) {
+	i, braced := 1, false
+	if s[0] == '$' && i < len(s) && s[i] == '{' {
+		braced = true
+		i++
+	}
+	if i >= len(s) || s[i] < '0' || s[i] > '9' {
+		return 0, 0, false
+	}
+	group := int(s[i] - '0')
+	i++
+	if i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		group = group*10 + int(s[i]-'0')
+		i++
+	}
+	if braced {
+		if i >= len(s) || s[i] != '}' {
+			return 0, 0, false
+		}
+		i++
+	}
+	return group, i, true
+}
+
+// regexReplacement is a pattern and the function giving the replacement for
+// each of its matches
+type regexReplacement struct {
+	regex   *pcre.Regexp
+	replace func(subject string, match []int) (string, error)
+}
+
+// replaceSubject applies each replacement in turn to subject, replacing up
+// to limit matches of each pattern. It reports false when a match fails.
+func replaceSubject(replacements []regexReplacement, subject string, limit int, count *int) (string, bool, error) {
+	for _, rep := range replacements {
+		if !checkRegexSubject(rep.regex, subject, 0) {
+			return "", false, nil
+		}
+		var result strings.Builder
+		last, replaced := 0, 0
+		var replaceErr error
+		ok := eachRegexMatch(rep.regex, subject, 0, func(match []int) bool {
+			if limit >= 0 && replaced >= limit {
+				return false
+			}
+			text, err := rep.replace(subject, match)
+			if err != nil {
+				replaceErr = err
+				return false
+			}
+			result.WriteString(subject[last:match[0]])
+			result.WriteString(text)
+			last = match[1]
+			replaced++
+			return true
+		})
+		if replaceErr != nil {
+			return "", false, replaceErr
+		}
+		if !ok {
+			return "", false, nil
+		}
+		result.WriteString(subject[last:])
+		subject = result.String()
+		*count += replaced
+	}
+	return subject, true, nil
+}
+
+// replaceSubjects applies replacements to a string subject, or to each
+// element of an array subject keeping its keys. With filter, as for
+// preg_filter, subjects where nothing matched are left out.
+func replaceSubjects(replacements []regexReplacement, subject *values.Value, limit int, filter bool) (*values.Value, int, error) {
+	total := 0
+	replaceOne := func(text string) (*values.Value, error) {
+		count := 0
+		result, ok, err := replaceSubject(replacements, text, limit, &count)
+		if err != nil || !ok || filter && count == 0 {
+			return nil, err
+		}
+		total += count
+		return values.NewString(result), nil
+	}
+
+	if !subject.IsArray() {
+		result, err := replaceOne(subject.ToString())
+		if result == nil {
+			result = values.NewNull()
+		}
+		return result, total, err
+	}
+	result := values.NewArray()
+	for key, val := range subject.Data.(*values.Array).All() {
+		replaced, err := replaceOne(val.ToString())
+		if err != nil {
+			return nil, total, err
+		}
+		if replaced != nil {
+			result.Data.(*values.Array).Set(key, replaced)
+		}
+	}
+	return result, total, nil
+}
+
+// regexPatterns compiles a pattern argument, which may be an array of
+// patterns
+func regexPatterns(pattern *values.Value) ([]*pcre.Regexp, bool) {
+	var sources []string
+	if pattern.IsArray() {
+		for _, val := range pattern.Data.(*values.Array).All() {
+			sources = append(sources, val.ToString())
+		}
+	} else {
+		sources = []string{pattern.ToString()}
+	}
+	var regexes []*pcre.Regexp
+	for _, source := range sources {
+		regex, err := compilePhpRegex(source)
+		if err != nil {
+			return nil, false
+		}
+		regexes = append(regexes, regex)
+	}
+	return regexes, true
+}
+
+// pregReplace implements preg_replace and preg_filter
+func pregReplace(args []*values.Value, filter bool) (*values.Value, error) {
+	if len(args) < 3 {
+		return values.NewNull(), nil
+	}
+	regexes, ok := regexPatterns(args[0])
+	if !ok {
+		return values.NewNull(), nil
+	}
+
+	// An array of replacements pairs up with the patterns, with "" for
+	// patterns past its end
+	var replacementList []string
+	if args[1].IsArray() {
+		for _, val := range args[1].Data.(*values.Array).All() {
+			replacementList = append(replacementList, val.ToString())
+		}
+	}
+	replacements := make([]regexReplacement, len(regexes))
+	for i, regex := range regexes {
+		replacement := ""
+		switch {
+		case !args[1].IsArray():
+			replacement = args[1].ToString()
+		case i < len(replacementList):
+			replacement = replacementList[i]
+		}
+		replacements[i] = regexReplacement{regex: regex, replace: func(subject string, match []int) (string, error) {
+			return expandReplacement(replacement, subject, match), nil
+		}}
+	}
+
+	result, count, err := replaceSubjects(replacements, args[2], regexIntArg(args, 3, -1), filter)
+	if err != nil {
+		return nil, err
+	}
+	setRegexResult(args, 4, values.NewInt(int64(count)))
+	return result, nil
+}
+
+// callbackReplacement gives the replacements for a pattern from a
+// callback, which receives the match array
+func callbackReplacement(ctx registry.BuiltinCallContext, regex *pcre.Regexp, callback *values.Value, flags int) regexReplacement {
+	return regexReplacement{regex: regex, replace: func(subject string, match []int) (string, error) {
+		result, err := callbackInvoker(ctx, callback, []*values.Value{regexMatchArray(regex, subject, match, flags)})
+		if err != nil {
+			return "", err
+		}
+		if result == nil {
+			return "", nil
+		}
+		return result.ToString(), nil
+	}}
+}
+
 // GetRegexFunctions returns all regex-related PHP functions
 func GetRegexFunctions() []*registry.Function {
 	return []*registry.Function{
@@ -367,78 +846,33 @@ func GetRegexFunctions() []*registry.Function {
 					return values.NewBool(false), nil
 				}
 
-				pattern := args[0].ToString()
-				subject := args[1].ToString()
-
-				regex, err := compilePhpRegex(pattern)
+				regex, err := compilePhpRegex(args[0].ToString())
 				if err != nil {
 					return values.NewBool(false), nil
 				}
-
-				matches := regex.FindStringSubmatch(subject)
-				if matches == nil {
-					return values.NewInt(0), nil
+				subject := args[1].ToString()
+				flags := regexIntArg(args, 3, 0)
+				offset := regexOffset(args, 4, subject)
+				if offset > len(subject) {
+					setRegexErrorCode(PREG_INTERNAL_ERROR)
+					setRegexResult(args, 2, values.NewArray())
+					return values.NewBool(false), nil
 				}
-
-				// If matches parameter is provided, populate it
-				if len(args) > 2 {
-					var targetValue *values.Value
-
-					// Handle nil or undefined reference parameters
-					if args[2] == nil {
-						// Create new array for undefined reference parameter
-						newArray := values.NewArray()
-						args[2] = newArray
-						targetValue = newArray
-					} else if args[2].Type == values.TypeReference {
-						ref := args[2].Data.(*values.Reference)
-						if ref.Target == nil || ref.Target.Type != values.TypeArray {
-							// Convert the existing null value to an array in-place
-							if ref.Target == nil {
-								ref.Target = values.NewArray()
-							} else {
-								// Transform the existing value to array type
-								ref.Target.Type = values.TypeArray
-								ref.Target.Data = &values.Array{
-									Elements:  make(map[interface{}]*values.Value),
-									NextIndex: 0,
-									IsIndexed: true,
-								}
-							}
-							targetValue = ref.Target
-						} else {
-							targetValue = ref.Target
-						}
-					} else {
-						// Direct value - ensure it's an array
-						if args[2].Type != values.TypeArray {
-							newArray := values.NewArray()
-							args[2] = newArray
-							targetValue = newArray
-						} else {
-							targetValue = args[2]
-						}
-					}
-
-					// Clear existing array and populate with matches
-					arr := targetValue.Data.(*values.Array)
-					// Clear existing elements
-					arr.Elements = make(map[interface{}]*values.Value)
-
-					// Trim trailing empty strings to match PHP behavior
-					// PHP omits unmatched optional capture groups from the end
-					trimmedMatches := matches
-					for len(trimmedMatches) > 1 && trimmedMatches[len(trimmedMatches)-1] == "" {
-						trimmedMatches = trimmedMatches[:len(trimmedMatches)-1]
-					}
-
-					// Populate with trimmed matches
-					for i, match := range trimmedMatches {
-						arr.Elements[int64(i)] = values.NewString(match)
-					}
-					arr.NextIndex = int64(len(trimmedMatches))
+				if !checkRegexSubject(regex, subject, offset) {
+					setRegexResult(args, 2, values.NewArray())
+					return values.NewBool(false), nil
 				}
 
+				match, ok := execRegex(regex, subject, offset, regexLimits())
+				if !ok {
+					setRegexResult(args, 2, values.NewArray())
+					return values.NewBool(false), nil
+				}
+				if match == nil {
+					setRegexResult(args, 2, values.NewArray())
+					return values.NewInt(0), nil
+				}
+				setRegexResult(args, 2, regexMatchArray(regex, subject, match, flags))
 				return values.NewInt(1), nil
 			},
 		},
@@ -460,93 +894,45 @@ func GetRegexFunctions() []*registry.Function {
 					return values.NewBool(false), nil
 				}
 
-				pattern := args[0].ToString()
-				subject := args[1].ToString()
-
-				regex, err := compilePhpRegex(pattern)
+				regex, err := compilePhpRegex(args[0].ToString())
 				if err != nil {
 					return values.NewBool(false), nil
 				}
-
-				allMatches := regex.FindAllStringSubmatch(subject, -1)
-				if allMatches == nil {
-					allMatches = [][]string{} // Set to empty slice instead of nil
+				subject := args[1].ToString()
+				flags := regexIntArg(args, 3, 0)
+				if flags&PREG_PATTERN_ORDER != 0 && flags&PREG_SET_ORDER != 0 {
+					return values.NewBool(false), fmt.Errorf("preg_match_all(): Argument #4 ($flags) must be a PREG_* constant")
+				}
+				offset := regexOffset(args, 4, subject)
+				if offset > len(subject) {
+					setRegexErrorCode(PREG_INTERNAL_ERROR)
+					setRegexResult(args, 2, values.NewArray())
+					return values.NewBool(false), nil
+				}
+				if !checkRegexSubject(regex, subject, offset) {
+					setRegexResult(args, 2, values.NewArray())
+					return values.NewBool(false), nil
 				}
 
-				// If matches parameter is provided, populate it
-				if len(args) > 2 {
-					var targetValue *values.Value
-
-					// Handle nil or undefined reference parameters
-					if args[2] == nil {
-						// Create new array for undefined reference parameter
-						newArray := values.NewArray()
-						args[2] = newArray
-						targetValue = newArray
-					} else if args[2].Type == values.TypeReference {
-						ref := args[2].Data.(*values.Reference)
-						if ref.Target == nil || ref.Target.Type != values.TypeArray {
-							// Convert the existing null value to an array in-place
-							if ref.Target == nil {
-								ref.Target = values.NewArray()
-							} else {
-								// Transform the existing value to array type
-								ref.Target.Type = values.TypeArray
-								ref.Target.Data = &values.Array{
-									Elements:  make(map[interface{}]*values.Value),
-									NextIndex: 0,
-									IsIndexed: true,
-								}
-							}
-							targetValue = ref.Target
-						} else {
-							targetValue = ref.Target
-						}
-					} else {
-						// Direct value - ensure it's an array
-						if args[2].Type != values.TypeArray {
-							newArray := values.NewArray()
-							args[2] = newArray
-							targetValue = newArray
-						} else {
-							targetValue = args[2]
-						}
-					}
-
-					// Clear existing array and populate with matches in PHP format
-					arr := targetValue.Data.(*values.Array)
-					arr.Elements = make(map[interface{}]*values.Value)
-
-					if len(allMatches) > 0 {
-						// Figure out how many capture groups we have
-						numGroups := len(allMatches[0])
-
-						// Create sub-arrays for each capture group
-						for groupIndex := 0; groupIndex < numGroups; groupIndex++ {
-							groupArray := values.NewArray()
-							groupArr := groupArray.Data.(*values.Array)
-
-							// Populate this group's matches across all match sets
-							for matchIndex, match := range allMatches {
-								if groupIndex < len(match) {
-									groupArr.Elements[int64(matchIndex)] = values.NewString(match[groupIndex])
-								}
-							}
-							groupArr.NextIndex = int64(len(allMatches))
+				var matches [][]int
+				if !eachRegexMatch(regex, subject, offset, func(match []int) bool {
+					matches = append(matches, match)
+					return true
+				}) {
+					setRegexResult(args, 2, values.NewArray())
+					return values.NewBool(false), nil
+				}
 
-							// Add this group array to the main matches array
-							arr.Elements[int64(groupIndex)] = groupArray
-						}
-						arr.NextIndex = int64(numGroups)
-					} else {
-						// No matches found - create empty array at index 0
-						emptyArray := values.NewArray()
-						arr.Elements[int64(0)] = emptyArray
-						arr.NextIndex = 1
+				if flags&PREG_SET_ORDER != 0 {
+					result := values.NewArray()
+					for _, match := range matches {
+						result.Data.(*values.Array).Append(regexMatchArray(regex, subject, match, flags))
 					}
+					setRegexResult(args, 2, result)
+				} else {
+					setRegexResult(args, 2, regexPatternOrder(regex, subject, matches, flags))
 				}
-
-				return values.NewInt(int64(len(allMatches))), nil
+				return values.NewInt(int64(len(matches))), nil
 			},
 		},
 		{
@@ -563,27 +949,7 @@ func GetRegexFunctions() []*registry.Function {
 			MaxArgs:    5,
 			IsBuiltin:  true,
 			Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
-				if len(args) < 3 {
-					return values.NewNull(), nil
-				}
-
-				pattern := args[0].ToString()
-				replacement := args[1].ToString()
-				subject := args[2].ToString()
-
-				regex, err := compilePhpRegex(pattern)
-				if err != nil {
-					setRegexError(PREG_INTERNAL_ERROR, fmt.Sprintf("Invalid regex pattern: %s", pattern))
-					return values.NewNull(), nil
-				}
-
-				// Convert PHP-style backreferences to Go-style
-				// Convert $1, $2, etc. to ${1}, ${2}, etc. for Go regex
-				goReplacement := convertPhpBackreferences(replacement)
-
-				result := regex.ReplaceAllString(subject, goReplacement)
-				clearRegexError()
-				return values.NewString(result), nil
+				return pregReplace(args, false)
 			},
 		},
 		{
@@ -603,26 +969,62 @@ func GetRegexFunctions() []*registry.Function {
 					return values.NewBool(false), nil
 				}
 
-				pattern := args[0].ToString()
-				subject := args[1].ToString()
-				limit := int(-1)
-				if len(args) > 2 {
-					limit = int(args[2].ToInt())
-				}
-
-				regex, err := compilePhpRegex(pattern)
+				regex, err := compilePhpRegex(args[0].ToString())
 				if err != nil {
 					return values.NewBool(false), nil
 				}
+				subject := args[1].ToString()
+				if !checkRegexSubject(regex, subject, 0) {
+					return values.NewBool(false), nil
+				}
+				limit := regexIntArg(args, 2, -1)
+				if limit == 0 {
+					limit = -1
+				}
+				flags := regexIntArg(args, 3, 0)
+				noEmpty := flags&PREG_SPLIT_NO_EMPTY != 0
 
-				parts := regex.Split(subject, limit)
 				result := values.NewArray()
 				arr := result.Data.(*values.Array)
-				for i, part := range parts {
-					arr.Elements[int64(i)] = values.NewString(part)
+				addPiece := func(start, end int) {
+					if flags&PREG_SPLIT_OFFSET_CAPTURE != 0 {
+						arr.Append(values.NewList(values.NewString(subject[start:end]), values.NewInt(int64(start))))
+					} else {
+						arr.Append(values.NewString(subject[start:end]))
+					}
 				}
-				arr.NextIndex = int64(len(parts))
 
+				// The limit counts the pieces, and the last piece holds the
+				// rest of the subject
+				last := 0
+				if limit == -1 || limit > 1 {
+					if !eachRegexMatch(regex, subject, 0, func(match []int) bool {
+						if !noEmpty || match[0] != last {
+							addPiece(last, match[0])
+							if limit != -1 {
+								limit--
+							}
+						}
+						if flags&PREG_SPLIT_DELIM_CAPTURE != 0 {
+							groups := len(match) / 2
+							for groups > 1 && match[2*(groups-1)] < 0 {
+								groups--
+							}
+							for i := 1; i < groups; i++ {
+								if !noEmpty || match[2*i] != match[2*i+1] {
+									addPiece(max(match[2*i], 0), max(match[2*i+1], 0))
+								}
+							}
+						}
+						last = match[1]
+						return limit == -1 || limit > 1
+					}) {
+						return values.NewBool(false), nil
+					}
+				}
+				if !noEmpty || last < len(subject) {
+					addPiece(last, len(subject))
+				}
 				return result, nil
 			},
 		},
@@ -642,21 +1044,24 @@ func GetRegexFunctions() []*registry.Function {
 				}
 
 				str := args[0].ToString()
-				delimiter := ""
-				if len(args) > 1 && args[1] != nil {
-					delimiter = args[1].ToString()
+				delimiter := -1
+				if len(args) > 1 && args[1] != nil && !args[1].IsNull() && args[1].ToString() != "" {
+					delimiter = int(args[1].ToString()[0])
 				}
 
-				// Quote regex metacharacters
-				quoted := regexp.QuoteMeta(str)
-
-				// Quote delimiter if provided
-				if delimiter != "" && len(delimiter) > 0 {
-					delim := string(delimiter[0])
-					quoted = strings.ReplaceAll(quoted, delim, "\\"+delim)
+				var quoted strings.Builder
+				for i := 0; i < len(str); i++ {
+					c := str[i]
+					switch {
+					case c == 0:
+						quoted.WriteString("\\000")
+						continue
+					case strings.IndexByte(`.\+*?[^]$(){}=!<>|:-#`, c) >= 0, int(c) == delimiter:
+						quoted.WriteByte('\\')
+					}
+					quoted.WriteByte(c)
 				}
-
-				return values.NewString(quoted), nil
+				return values.NewString(quoted.String()), nil
 			},
 		},
 		{
@@ -675,29 +1080,31 @@ func GetRegexFunctions() []*registry.Function {
 					return values.NewBool(false), nil
 				}
 
-				pattern := args[0].ToString()
 				inputArray := args[1]
-
 				if inputArray.Type != values.TypeArray {
 					return values.NewBool(false), nil
 				}
-
-				regex, err := compilePhpRegex(pattern)
+				regex, err := compilePhpRegex(args[0].ToString())
 				if err != nil {
 					return values.NewBool(false), nil
 				}
+				invert := regexIntArg(args, 2, 0)&PREG_GREP_INVERT != 0
 
 				result := values.NewArray()
 				resultArr := result.Data.(*values.Array)
-				inputArr := inputArray.Data.(*values.Array)
-
-				for key, val := range inputArr.Elements {
-					strVal := val.ToString()
-					if regex.MatchString(strVal) {
-						resultArr.Elements[key] = val
+				for key, val := range inputArray.Data.(*values.Array).All() {
+					subject := val.ToString()
+					if !checkRegexSubject(regex, subject, 0) {
+						return values.NewBool(false), nil
+					}
+					match, ok := execRegex(regex, subject, 0, regexLimits())
+					if !ok {
+						return values.NewBool(false), nil
+					}
+					if (match != nil) != invert {
+						resultArr.Set(key, val)
 					}
 				}
-
 				return result, nil
 			},
 		},
@@ -739,46 +1146,7 @@ func GetRegexFunctions() []*registry.Function {
 			MaxArgs:    5,
 			IsBuiltin:  true,
 			Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
-				if len(args) < 3 {
-					return values.NewNull(), nil
-				}
-
-				pattern := args[0].ToString()
-				replacement := args[1].ToString()
-
-				regex, err := compilePhpRegex(pattern)
-				if err != nil {
-					return values.NewNull(), nil
-				}
-
-				// Handle different subject types
-				subject := args[2]
-				if subject.Type == values.TypeString {
-					// For strings, preg_filter acts like preg_replace
-					subjectStr := subject.ToString()
-					result := regex.ReplaceAllString(subjectStr, replacement)
-					return values.NewString(result), nil
-				} else if subject.Type == values.TypeArray {
-					// For arrays, filter out non-matching elements
-					inputArr := subject.Data.(*values.Array)
-					result := values.NewArray()
-					resultArr := result.Data.(*values.Array)
-
-					for key, val := range inputArr.Elements {
-						strVal := val.ToString()
-						if regex.MatchString(strVal) {
-							// Element matches, apply replacement and include in result
-							replaced := regex.ReplaceAllString(strVal, replacement)
-							resultArr.Elements[key] = values.NewString(replaced)
-						}
-						// Non-matching elements are filtered out (not included in result)
-					}
-
-					return result, nil
-				}
-
-				// Unsupported type
-				return values.NewNull(), nil
+				return pregReplace(args, true)
 			},
 		},
 		{
@@ -789,204 +1157,74 @@ func GetRegexFunctions() []*registry.Function {
 				{Name: "subject", Type: "string|array"},
 				{Name: "limit", Type: "int", HasDefault: true, DefaultValue: values.NewInt(-1)},
 				{Name: "count", Type: "int", IsReference: true, HasDefault: true, DefaultValue: values.NewNull()},
+				{Name: "flags", Type: "int", HasDefault: true, DefaultValue: values.NewInt(0)},
 			},
 			ReturnType: "string|array|null",
 			MinArgs:    3,
-			MaxArgs:    5,
+			MaxArgs:    6,
 			IsBuiltin:  true,
 			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
 				if len(args) < 3 {
 					return values.NewNull(), nil
 				}
 
-				clearRegexError() // Clear any previous errors
-
-				pattern := args[0].ToString()
-				callback := args[1]
-				subject := args[2]
-				limit := int(-1)
-				if len(args) > 3 && !args[3].IsNull() {
-					limit = int(args[3].ToInt())
+				regexes, ok := regexPatterns(args[0])
+				if !ok {
+					return values.NewNull(), nil
+				}
+				flags := regexIntArg(args, 5, 0)
+				replacements := make([]regexReplacement, len(regexes))
+				for i, regex := range regexes {
+					replacements[i] = callbackReplacement(ctx, regex, args[1], flags)
 				}
 
-				// Compile the regex pattern
-				regex, err := compilePhpRegex(pattern)
+				result, count, err := replaceSubjects(replacements, args[2], regexIntArg(args, 3, -1), false)
 				if err != nil {
-					setRegexError(PREG_INTERNAL_ERROR, fmt.Sprintf("Invalid regex pattern: %s", err.Error()))
+					return nil, err
+				}
+				setRegexResult(args, 4, values.NewInt(int64(count)))
+				return result, nil
+			},
+		},
+		{
+			Name: "preg_replace_callback_array",
+			Parameters: []*registry.Parameter{
+				{Name: "pattern", Type: "array"},
+				{Name: "subject", Type: "string|array"},
+				{Name: "limit", Type: "int", HasDefault: true, DefaultValue: values.NewInt(-1)},
+				{Name: "count", Type: "int", IsReference: true, HasDefault: true, DefaultValue: values.NewNull()},
+				{Name: "flags", Type: "int", HasDefault: true, DefaultValue: values.NewInt(0)},
+			},
+			ReturnType: "string|array|null",
+			MinArgs:    2,
+			MaxArgs:    5,
+			IsBuiltin:  true,
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+				if len(args) < 2 || !args[0].IsArray() {
 					return values.NewNull(), nil
 				}
 
-				// Handle string subject
-				if subject.Type == values.TypeString {
-					subjectStr := subject.ToString()
-					replacementCount := 0
-
-					// Find all matches with capture groups
-					allMatches := regex.FindAllStringSubmatch(subjectStr, limit)
-					if len(allMatches) == 0 {
-						return values.NewString(subjectStr), nil // No matches, return original
-					}
-
-					// Process each match and build result string manually
-					result := subjectStr
-					offset := 0
-
-					for _, matchData := range allMatches {
-						if len(matchData) == 0 {
-							continue
-						}
-
-						// Check limit
-						if limit >= 0 && replacementCount >= limit {
-							break
-						}
-						replacementCount++
-
-						// Create matches array (similar to preg_match format)
-						matches := values.NewArray()
-						matchesArr := matches.Data.(*values.Array)
-
-						// Add all capture groups (including full match at index 0)
-						for i, submatch := range matchData {
-							matchesArr.Elements[int64(i)] = values.NewString(submatch)
-						}
-						matchesArr.NextIndex = int64(len(matchData))
-
-						// Call the callback with matches array
-						// For builtin string functions, pass just the matched string instead of the full array
-						var callArgs []*values.Value
-						if callback.Type == values.TypeString {
-							funcName := callback.ToString()
-							// Check if it's a common string builtin that expects a single string
-							stringBuiltins := []string{"strtoupper", "strtolower", "ucfirst", "lcfirst", "trim", "ltrim", "rtrim"}
-							isStringBuiltin := false
-							for _, builtin := range stringBuiltins {
-								if funcName == builtin {
-									isStringBuiltin = true
-									break
-								}
-							}
-							if isStringBuiltin {
-								// Pass just the matched string (matches[0])
-								callArgs = []*values.Value{values.NewString(matchData[0])}
-							} else {
-								// Pass the full matches array for user-defined functions
-								callArgs = []*values.Value{matches}
-							}
-						} else {
-							// For closures and other callbacks, pass the matches array
-							callArgs = []*values.Value{matches}
-						}
-
-						callbackResult, err := callbackInvoker(ctx, callback, callArgs)
-						if err != nil {
-							setRegexError(PREG_INTERNAL_ERROR, fmt.Sprintf("Callback error: %s", err.Error()))
-							continue // Skip this match on error
-						}
-
-						var replacement string
-						if callbackResult != nil {
-							replacement = callbackResult.ToString()
-						} else {
-							replacement = matchData[0] // Use original match if callback returns null
-						}
-
-						// Replace the first occurrence of the full match in the result
-						fullMatch := matchData[0]
-						if idx := strings.Index(result[offset:], fullMatch); idx != -1 {
-							actualIdx := offset + idx
-							result = result[:actualIdx] + replacement + result[actualIdx+len(fullMatch):]
-							offset = actualIdx + len(replacement)
-						}
+				flags := regexIntArg(args, 4, 0)
+				var replacements []regexReplacement
+				for pattern, callback := range args[0].Data.(*values.Array).All() {
+					regex, err := compilePhpRegex(values.KeyValue(pattern).ToString())
+					if err != nil {
+						return values.NewNull(), nil
 					}
-
-					// Set count reference if provided
-					// TODO: Implement proper reference parameter handling
-					_ = replacementCount // Avoid unused variable warning for now
-
-					return values.NewString(result), nil
+					replacements = append(replacements, callbackReplacement(ctx, regex, callback, flags))
 				}
 
-				// Handle array subject
-				if subject.Type == values.TypeArray {
-					inputArr := subject.Data.(*values.Array)
-					result := values.NewArray()
-					resultArr := result.Data.(*values.Array)
-					totalReplacements := 0
-
-					for key, val := range inputArr.Elements {
-						if val == nil {
-							continue
-						}
-
-						valStr := val.ToString()
-						elementReplacements := 0
-
-						// Process each array element
-						elementResult := regex.ReplaceAllStringFunc(valStr, func(match string) string {
-							// Check global limit
-							if limit >= 0 && totalReplacements >= limit {
-								return match
-							}
-							elementReplacements++
-							totalReplacements++
-
-							// Create matches array
-							matches := values.NewArray()
-							matchesArr := matches.Data.(*values.Array)
-							matchesArr.Elements[int64(0)] = values.NewString(match)
-							matchesArr.NextIndex = 1
-
-							// Call the callback
-							callArgs := []*values.Value{matches}
-							result, err := callbackInvoker(ctx, callback, callArgs)
-							if err != nil {
-								setRegexError(PREG_INTERNAL_ERROR, fmt.Sprintf("Callback error: %s", err.Error()))
-								return match
-							}
-
-							if result == nil {
-								return match
-							}
-							return result.ToString()
-						})
-
-						resultArr.Elements[key] = values.NewString(elementResult)
-					}
-
-					// Set count reference if provided
-					// TODO: Implement proper reference parameter handling
-					_ = totalReplacements // Avoid unused variable warning for now
-
-					return result, nil
+				result, count, err := replaceSubjects(replacements, args[1], regexIntArg(args, 2, -1), false)
+				if err != nil {
+					return nil, err
 				}
-
-				// Unsupported type
-				return values.NewNull(), nil
+				setRegexResult(args, 3, values.NewInt(int64(count)))
+				return result, nil
 			},
 		},
 	}
 }
 
-// convertPhpBackreferences converts PHP-style backreferences ($1, $2, etc.) to Go-style (${1}, ${2}, etc.)
-func convertPhpBackreferences(replacement string) string {
-	// Use a simple regex to find and replace $1, $2, etc. with ${1}, ${2}, etc.
-	// This handles the most common PHP backreference syntax
-	result := replacement
-
-	// Convert $1-9 to ${1}-${9} (most common case)
-	for i := 1; i <= 9; i++ {
-		oldPattern := fmt.Sprintf("$%d", i)
-		newPattern := fmt.Sprintf("${%d}", i)
-		result = strings.ReplaceAll(result, oldPattern, newPattern)
-	}
-
-	// Also handle $0 (full match)
-	result = strings.ReplaceAll(result, "$0", "${0}")
-
-	return result
-}
-
 // GetRegexCacheFunctions returns regex cache management PHP functions
 func GetRegexCacheFunctions() []*registry.Function {
 	return []*registry.Function{
@@ -1016,7 +1254,7 @@ func GetRegexCacheFunctions() []*registry.Function {
 					default:
 						val = values.NewString(fmt.Sprintf("%v", v))
 					}
-					resultArr.Elements[key] = val
+					resultArr.Set(key, val)
 				}
 
 				return result, nil
@@ -95,7 +95,7 @@ func TestPregMatch(t *testing.T) {
 			pattern:         "/()/",
 			subject:         "test",
 			expectedResult:  1,
-			expectedMatches: []string{""}, // Only one element for empty match
+			expectedMatches: []string{"", ""}, // The empty group took part in the match
 		},
 		{
 			name:            "Nested capture groups",
@@ -151,12 +151,12 @@ func TestPregMatch(t *testing.T) {
 				}
 
 				arr := matches.Data.(*values.Array)
-				if len(arr.Elements) != len(tt.expectedMatches) {
-					t.Errorf("Expected %d matches, got %d", len(tt.expectedMatches), len(arr.Elements))
+				if arr.Len() != len(tt.expectedMatches) {
+					t.Errorf("Expected %d matches, got %d", len(tt.expectedMatches), arr.Len())
 				}
 
 				for i, expectedMatch := range tt.expectedMatches {
-					if actualMatch, exists := arr.Elements[int64(i)]; exists {
+					if actualMatch, exists := arr.Lookup(int64(i)); exists {
 						if actualMatch.ToString() != expectedMatch {
 							t.Errorf("Match %d: expected %q, got %q", i, expectedMatch, actualMatch.ToString())
 						}
@@ -168,8 +168,8 @@ func TestPregMatch(t *testing.T) {
 				// No matches expected, array should be empty
 				if matches.Type == values.TypeArray {
 					arr := matches.Data.(*values.Array)
-					if len(arr.Elements) != 0 {
-						t.Errorf("Expected empty matches array, got %d elements", len(arr.Elements))
+					if arr.Len() != 0 {
+						t.Errorf("Expected empty matches array, got %d elements", arr.Len())
 					}
 				}
 			}
@@ -416,26 +416,26 @@ func TestPregMatchAll(t *testing.T) {
 			}
 
 			arr := matches.Data.(*values.Array)
-			if len(arr.Elements) != len(tt.expectedMatches) {
-				t.Errorf("Expected %d groups, got %d", len(tt.expectedMatches), len(arr.Elements))
+			if arr.Len() != len(tt.expectedMatches) {
+				t.Errorf("Expected %d groups, got %d", len(tt.expectedMatches), arr.Len())
 			}
 
 			// Check each group
 			for groupIndex, expectedGroup := range tt.expectedMatches {
-				if groupArray, exists := arr.Elements[int64(groupIndex)]; exists {
+				if groupArray, exists := arr.Lookup(int64(groupIndex)); exists {
 					if groupArray.Type != values.TypeArray {
 						t.Errorf("Group %d should be array type", groupIndex)
 						continue
 					}
 
 					groupArr := groupArray.Data.(*values.Array)
-					if len(groupArr.Elements) != len(expectedGroup) {
-						t.Errorf("Group %d: expected %d matches, got %d", groupIndex, len(expectedGroup), len(groupArr.Elements))
+					if groupArr.Len() != len(expectedGroup) {
+						t.Errorf("Group %d: expected %d matches, got %d", groupIndex, len(expectedGroup), groupArr.Len())
 					}
 
 					// Check each match in this group
 					for matchIndex, expectedMatch := range expectedGroup {
-						if actualMatch, exists := groupArr.Elements[int64(matchIndex)]; exists {
+						if actualMatch, exists := groupArr.Lookup(int64(matchIndex)); exists {
 							if actualMatch.ToString() != expectedMatch {
 								t.Errorf("Group %d, Match %d: expected %q, got %q", groupIndex, matchIndex, expectedMatch, actualMatch.ToString())
 							}
@@ -670,4 +670,84 @@ func TestPregReplace(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestPregMatchPcreFeatures(t *testing.T) {
+	err := Bootstrap()
+	if err != nil {
+		t.Fatalf("Failed to bootstrap runtime: %v", err)
+	}
+
+	pregMatchFunc, found := registry.GlobalRegistry.GetFunction("preg_match")
+	if !found || pregMatchFunc == nil {
+		t.Fatal("preg_match function not found in registry")
+	}
+
+	tests := []struct {
+		name           string
+		pattern        string
+		subject        string
+		expectedResult int64
+		expectedMatch  string
+	}{
+		{"Backreference", "/(\\w)\\1/", "hello", 1, "ll"},
+		{"Lookbehind", "/(?<=\\$)\\d+/", "cost $42", 1, "42"},
+		{"Negative lookahead", "/\\d+(?!px|\\d)/", "20px 30em", 1, "30"},
+		{"Atomic group", "/(?>a+)a/", "aaa", 0, ""},
+		{"Possessive quantifier", "/a++b/", "aab", 1, "aab"},
+		{"Recursion", "/\\((?:[^()]|(?R))*\\)/", "x(a(b)c)", 1, "(a(b)c)"},
+		{"Reset match start", "/foo\\Kbar/", "foobar", 1, "bar"},
+		{"Conditional", "/^(<)?\\w+(?(1)>)$/", "<tag>", 1, "<tag>"},
+		{"Extended", "/a b c # letters/x", "abc", 1, "abc"},
+		{"Ungreedy", "/a+/U", "aaa", 1, "a"},
+		{"Anchored", "/b/A", "ab", 0, ""},
+		{"Dollar end only", "/a$/D", "a\n", 0, ""},
+		{"UTF-8", "/^\\w+$/u", "héllo", 1, "héllo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := values.NewArray()
+			args := []*values.Value{values.NewString(tt.pattern), values.NewString(tt.subject), matches}
+
+			result, err := pregMatchFunc.Builtin(nil, args)
+			if err != nil {
+				t.Fatalf("preg_match failed with error: %v", err)
+			}
+			if result.ToInt() != tt.expectedResult {
+				t.Fatalf("Expected result %d, got %v", tt.expectedResult, result)
+			}
+			if tt.expectedResult > 0 {
+				if actual := matches.Data.(*values.Array).Get(int64(0)).ToString(); actual != tt.expectedMatch {
+					t.Errorf("Expected match %q, got %q", tt.expectedMatch, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestPregMatchBacktrackLimit(t *testing.T) {
+	err := Bootstrap()
+	if err != nil {
+		t.Fatalf("Failed to bootstrap runtime: %v", err)
+	}
+
+	pregMatchFunc, found := registry.GlobalRegistry.GetFunction("preg_match")
+	if !found || pregMatchFunc == nil {
+		t.Fatal("preg_match function not found in registry")
+	}
+
+	args := []*values.Value{
+		values.NewString("/(?:\\D+|<\\d+>)*[!?]/"),
+		values.NewString("foobar foobar foobar"),
+	}
+	result, err := pregMatchFunc.Builtin(nil, args)
+	if err != nil {
+		t.Fatalf("preg_match failed with error: %v", err)
+	}
+	if result.Type != values.TypeBool || result.ToBool() {
+		t.Errorf("Expected false when the backtrack limit is hit, got %v", result)
+	}
+	if errorCode, message := getRegexError(); errorCode != PREG_BACKTRACK_LIMIT_ERROR || message != "Backtrack limit exhausted" {
+		t.Errorf("Expected PREG_BACKTRACK_LIMIT_ERROR, got %d %q", errorCode, message)
+	}
+}
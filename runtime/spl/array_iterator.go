@@ -2,7 +2,6 @@ package spl
 
 import (
 	"fmt"
-	"sort"
 
 	"github.com/wudi/hey/registry"
 	"github.com/wudi/hey/values"
@@ -55,37 +54,13 @@ func GetArrayIteratorClass() *registry.ClassDescriptor {
 			obj.Properties["__array"] = array
 			obj.Properties["__position"] = values.NewInt(0)
 
-			// Build keys array for iteration in sorted order
+			// Build keys array for iteration in insertion order
 			keys := values.NewArray()
 			if array.IsArray() {
 				arr := array.Data.(*values.Array)
 
-				// Collect all keys first
-				var intKeys []int64
-				var stringKeys []string
-
-				for k := range arr.Elements {
-					switch v := k.(type) {
-					case int64:
-						intKeys = append(intKeys, v)
-					case string:
-						stringKeys = append(stringKeys, v)
-					}
-				}
-
-				// Sort keys to ensure consistent iteration order
-				sort.Slice(intKeys, func(i, j int) bool { return intKeys[i] < intKeys[j] })
-				sort.Strings(stringKeys)
-
-				// Add sorted keys to keys array
-				index := 0
-				for _, key := range intKeys {
-					keys.ArraySet(values.NewInt(int64(index)), values.NewInt(key))
-					index++
-				}
-				for _, key := range stringKeys {
-					keys.ArraySet(values.NewInt(int64(index)), values.NewString(key))
-					index++
+				for _, key := range arr.Keys() {
+					keys.ArraySet(nil, values.KeyValue(key))
 				}
 			}
 			obj.Properties["__keys"] = keys
@@ -359,7 +334,7 @@ func GetArrayIteratorClass() *registry.ClassDescriptor {
 			if arr.IsArray() {
 				arrData := arr.Data.(*values.Array)
 				index := 0
-				for k := range arrData.Elements {
+				for k := range arrData.All() {
 					keyVal := values.NewNull()
 					switch v := k.(type) {
 					case int64:
@@ -409,7 +384,7 @@ func GetArrayIteratorClass() *registry.ClassDescriptor {
 			if arr.IsArray() {
 				arrData := arr.Data.(*values.Array)
 				index := 0
-				for k := range arrData.Elements {
+				for k := range arrData.All() {
 					keyVal := values.NewNull()
 					switch v := k.(type) {
 					case int64:
@@ -32,6 +32,11 @@ func (m *mockContext) GetCurrentFunctionArgCount() (int, error)
 func (m *mockContext) GetCurrentFunctionArg(index int) (*values.Value, error)   { return nil, nil }
 func (m *mockContext) GetCurrentFunctionArgs() ([]*values.Value, error)         { return nil, nil }
 func (m *mockContext) ThrowException(exception *values.Value) error { return fmt.Errorf("exception thrown in test mock: %v", exception) }
+func (m *mockContext) AutoloadClass(name string) bool { return false }
+func (m *mockContext) CallMethod(method *registry.Function, this *values.Value, className string, args []*values.Value) (*values.Value, error) {
+	return nil, nil
+}
+func (m *mockContext) InstantiateClass(className string) (*values.Value, error) { return nil, nil }
 
 // HTTP methods - missing from original mock
 func (m *mockContext) GetHTTPContext() registry.HTTPContext { return &mockHTTPContext{} }
@@ -50,6 +55,9 @@ func (m *mockHTTPContext) MarkHeadersSent(location string) {}
 func (m *mockHTTPContext) AreHeadersSent() (bool, string) { return false, "" }
 func (m *mockHTTPContext) SetRequestHeaders(headers map[string]string) {}
 func (m *mockHTTPContext) GetRequestHeaders() map[string]string { return nil }
+func (m *mockHTTPContext) GetRequestBody() []byte { return nil }
+func (m *mockHTTPContext) IsUploadedFile(path string) bool { return false }
+func (m *mockHTTPContext) RemoveUploadedFile(path string) {}
 func (m *mockHTTPContext) FormatHeadersForFastCGI() string { return "" }
 
 func TestArrayIterator(t *testing.T) {
@@ -35,7 +35,7 @@ func GetArrayObjectClass() *registry.ClassDescriptor {
 					// Clone the array
 					array = values.NewArray()
 					arr := args[1].Data.(*values.Array)
-					for k, v := range arr.Elements {
+					for k, v := range arr.All() {
 						keyVal := values.NewNull()
 						switch key := k.(type) {
 						case int64:
@@ -284,7 +284,7 @@ func GetArrayObjectClass() *registry.ClassDescriptor {
 			copy := values.NewArray()
 			if arr.IsArray() {
 				arrData := arr.Data.(*values.Array)
-				for k, v := range arrData.Elements {
+				for k, v := range arrData.All() {
 					keyVal := values.NewNull()
 					switch key := k.(type) {
 					case int64:
@@ -329,7 +329,7 @@ func GetArrayObjectClass() *registry.ClassDescriptor {
 				// Clone the array
 				newArray = values.NewArray()
 				arr := args[1].Data.(*values.Array)
-				for k, v := range arr.Elements {
+				for k, v := range arr.All() {
 					keyVal := values.NewNull()
 					switch key := k.(type) {
 					case int64:
@@ -162,9 +162,9 @@ func GetCachingIteratorClass() *registry.ClassDescriptor {
 								cacheArray := cache.Data.(*values.Array)
 								// Store in cache using key as index
 								if keyValue.IsInt() {
-									cacheArray.Elements[keyValue.ToInt()] = currentValue
+									cacheArray.Set(keyValue.ToInt(), currentValue)
 								} else if keyValue.IsString() {
-									cacheArray.Elements[keyValue.ToString()] = currentValue
+									cacheArray.Set(keyValue.ToString(), currentValue)
 								}
 							}
 						}
@@ -312,7 +312,7 @@ func GetCachingIteratorClass() *registry.ClassDescriptor {
 
 					if keyResult.IsInt() {
 						currentKey := keyResult.ToInt()
-						maxKey := int64(len(arrayData.Elements)) - 1
+						maxKey := int64(arrayData.Len()) - 1
 
 						// Has next if current key is less than max key
 						return values.NewBool(currentKey < maxKey), nil
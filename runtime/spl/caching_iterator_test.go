@@ -386,8 +386,8 @@ func TestCachingIterator(t *testing.T) {
 			}
 
 			cacheArray := cacheResult.Data.(*values.Array)
-			if len(cacheArray.Elements) != 3 {
-				t.Fatalf("Expected cache size 3, got %d", len(cacheArray.Elements))
+			if cacheArray.Len() != 3 {
+				t.Fatalf("Expected cache size 3, got %d", cacheArray.Len())
 			}
 		}
 	})
@@ -43,7 +43,7 @@ func TestClassReflectionFunctions(t *testing.T) {
 		// Check that Iterator interface is present
 		arr := result.Data.(*values.Array)
 		found := false
-		for _, v := range arr.Elements {
+		for _, v := range arr.All() {
 			if v.IsString() && v.ToString() == "Iterator" {
 				found = true
 				break
@@ -108,7 +108,7 @@ func TestClassReflectionFunctions(t *testing.T) {
 
 		// Should be empty array
 		arr := result.Data.(*values.Array)
-		if len(arr.Elements) != 0 {
+		if arr.Len() != 0 {
 			t.Fatal("Expected empty array for ArrayIterator parents")
 		}
 	})
@@ -143,7 +143,7 @@ func TestClassReflectionFunctions(t *testing.T) {
 
 		// Should be empty array
 		arr := result.Data.(*values.Array)
-		if len(arr.Elements) != 0 {
+		if arr.Len() != 0 {
 			t.Fatal("Expected empty array for ArrayIterator traits")
 		}
 	})
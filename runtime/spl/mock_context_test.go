@@ -31,6 +31,11 @@ func (m *SharedMockContext) GetCurrentFunctionArgCount() (int, error)
 func (m *SharedMockContext) GetCurrentFunctionArg(index int) (*values.Value, error)   { return nil, nil }
 func (m *SharedMockContext) GetCurrentFunctionArgs() ([]*values.Value, error)         { return nil, nil }
 func (m *SharedMockContext) ThrowException(exception *values.Value) error { return fmt.Errorf("exception thrown in test mock: %v", exception) }
+func (m *SharedMockContext) AutoloadClass(name string) bool { return false }
+func (m *SharedMockContext) CallMethod(method *registry.Function, this *values.Value, className string, args []*values.Value) (*values.Value, error) {
+	return nil, nil
+}
+func (m *SharedMockContext) InstantiateClass(className string) (*values.Value, error) { return nil, nil }
 
 // HTTP methods - missing from original mocks
 func (m *SharedMockContext) GetHTTPContext() registry.HTTPContext { return &SharedMockHTTPContext{} }
@@ -49,6 +54,9 @@ func (m *SharedMockHTTPContext) MarkHeadersSent(location string) {}
 func (m *SharedMockHTTPContext) AreHeadersSent() (bool, string) { return false, "" }
 func (m *SharedMockHTTPContext) SetRequestHeaders(headers map[string]string) {}
 func (m *SharedMockHTTPContext) GetRequestHeaders() map[string]string { return nil }
+func (m *SharedMockHTTPContext) GetRequestBody() []byte { return nil }
+func (m *SharedMockHTTPContext) IsUploadedFile(path string) bool { return false }
+func (m *SharedMockHTTPContext) RemoveUploadedFile(path string) {}
 func (m *SharedMockHTTPContext) FormatHeadersForFastCGI() string { return "" }
 
 // NewMockContext creates a new mock context for testing
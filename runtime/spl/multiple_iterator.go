@@ -74,9 +74,9 @@ func GetMultipleIteratorClass() *registry.ClassDescriptor {
 			infoArray := iteratorInfo.Data.(*values.Array)
 
 			// Add iterator to the end of the array
-			nextIndex := int64(len(iteratorsArray.Elements))
-			iteratorsArray.Elements[nextIndex] = iterator
-			infoArray.Elements[nextIndex] = info
+			nextIndex := int64(iteratorsArray.Len())
+			iteratorsArray.Set(nextIndex, iterator)
+			infoArray.Set(nextIndex, info)
 
 			return values.NewNull(), nil
 		},
@@ -110,28 +110,26 @@ func GetMultipleIteratorClass() *registry.ClassDescriptor {
 			infoArray := iteratorInfo.Data.(*values.Array)
 
 			// Find and remove the iterator
-			newIterators := make(map[interface{}]*values.Value)
-			newInfo := make(map[interface{}]*values.Value)
-			newIndex := int64(0)
+			newIterators := values.NewArray()
+			newInfo := values.NewArray()
 
-			for i := int64(0); i < int64(len(iteratorsArray.Elements)); i++ {
-				if iter, exists := iteratorsArray.Elements[i]; exists {
+			for i := int64(0); i < int64(iteratorsArray.Len()); i++ {
+				if iter, exists := iteratorsArray.Lookup(i); exists {
 					// Compare iterator objects by reference
 					if iter != iterator {
-						newIterators[newIndex] = iter
-						if info, infoExists := infoArray.Elements[i]; infoExists {
-							newInfo[newIndex] = info
+						newIterators.ArraySet(nil, iter)
+						if info, infoExists := infoArray.Lookup(i); infoExists {
+							newInfo.ArraySet(nil, info)
 						} else {
-							newInfo[newIndex] = values.NewNull()
+							newInfo.ArraySet(nil, values.NewNull())
 						}
-						newIndex++
 					}
 				}
 			}
 
 			// Replace arrays with new ones
-			iteratorsArray.Elements = newIterators
-			infoArray.Elements = newInfo
+			obj.Properties["__iterators"] = newIterators
+			obj.Properties["__iterator_info"] = newInfo
 
 			return values.NewNull(), nil
 		},
@@ -163,7 +161,7 @@ func GetMultipleIteratorClass() *registry.ClassDescriptor {
 			iteratorsArray := iterators.Data.(*values.Array)
 
 			// Search for the iterator
-			for _, iter := range iteratorsArray.Elements {
+			for _, iter := range iteratorsArray.All() {
 				if iter == iterator {
 					return values.NewBool(true), nil
 				}
@@ -195,7 +193,7 @@ func GetMultipleIteratorClass() *registry.ClassDescriptor {
 			}
 
 			iteratorsArray := iterators.Data.(*values.Array)
-			return values.NewInt(int64(len(iteratorsArray.Elements))), nil
+			return values.NewInt(int64(iteratorsArray.Len())), nil
 		},
 	}
 
@@ -224,8 +222,8 @@ func GetMultipleIteratorClass() *registry.ClassDescriptor {
 			result := values.NewArray()
 
 			// Get current value from each iterator
-			for i := int64(0); i < int64(len(iteratorsArray.Elements)); i++ {
-				if iter, exists := iteratorsArray.Elements[i]; exists {
+			for i := int64(0); i < int64(iteratorsArray.Len()); i++ {
+				if iter, exists := iteratorsArray.Lookup(i); exists {
 					current, err := callIteratorMethod(ctx, iter, "current", []*values.Value{iter})
 					if err != nil {
 						return values.NewNull(), err
@@ -263,8 +261,8 @@ func GetMultipleIteratorClass() *registry.ClassDescriptor {
 			result := values.NewArray()
 
 			// Get key from each iterator
-			for i := int64(0); i < int64(len(iteratorsArray.Elements)); i++ {
-				if iter, exists := iteratorsArray.Elements[i]; exists {
+			for i := int64(0); i < int64(iteratorsArray.Len()); i++ {
+				if iter, exists := iteratorsArray.Lookup(i); exists {
 					key, err := callIteratorMethod(ctx, iter, "key", []*values.Value{iter})
 					if err != nil {
 						return values.NewNull(), err
@@ -301,8 +299,8 @@ func GetMultipleIteratorClass() *registry.ClassDescriptor {
 			iteratorsArray := iterators.Data.(*values.Array)
 
 			// Call next() on all iterators
-			for i := int64(0); i < int64(len(iteratorsArray.Elements)); i++ {
-				if iter, exists := iteratorsArray.Elements[i]; exists {
+			for i := int64(0); i < int64(iteratorsArray.Len()); i++ {
+				if iter, exists := iteratorsArray.Lookup(i); exists {
 					_, err := callIteratorMethod(ctx, iter, "next", []*values.Value{iter})
 					if err != nil {
 						return values.NewNull(), err
@@ -338,8 +336,8 @@ func GetMultipleIteratorClass() *registry.ClassDescriptor {
 			iteratorsArray := iterators.Data.(*values.Array)
 
 			// Call rewind() on all iterators
-			for i := int64(0); i < int64(len(iteratorsArray.Elements)); i++ {
-				if iter, exists := iteratorsArray.Elements[i]; exists {
+			for i := int64(0); i < int64(iteratorsArray.Len()); i++ {
+				if iter, exists := iteratorsArray.Lookup(i); exists {
 					_, err := callIteratorMethod(ctx, iter, "rewind", []*values.Value{iter})
 					if err != nil {
 						return values.NewNull(), err
@@ -374,14 +372,14 @@ func GetMultipleIteratorClass() *registry.ClassDescriptor {
 
 			iteratorsArray := iterators.Data.(*values.Array)
 
-			if len(iteratorsArray.Elements) == 0 {
+			if iteratorsArray.Len() == 0 {
 				return values.NewBool(false), nil
 			}
 
 			// MultipleIterator is valid only if ALL iterators are valid
 			// (stops at shortest iterator)
-			for i := int64(0); i < int64(len(iteratorsArray.Elements)); i++ {
-				if iter, exists := iteratorsArray.Elements[i]; exists {
+			for i := int64(0); i < int64(iteratorsArray.Len()); i++ {
+				if iter, exists := iteratorsArray.Lookup(i); exists {
 					validResult, err := callIteratorMethod(ctx, iter, "valid", []*values.Value{iter})
 					if err != nil {
 						return values.NewBool(false), err
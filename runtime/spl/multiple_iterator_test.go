@@ -246,13 +246,13 @@ func TestMultipleIterator(t *testing.T) {
 			currentArray := currentResult.Data.(*values.Array)
 
 			// Check first value
-			val0, exists := currentArray.Elements[int64(0)]
+			val0, exists := currentArray.Lookup(int64(0))
 			if !exists || !val0.IsString() || val0.ToString() != expectedValues[i][0] {
 				t.Fatalf("Expected first value '%s' at iteration %d, got %v", expectedValues[i][0], i, val0)
 			}
 
 			// Check second value
-			val1, exists := currentArray.Elements[int64(1)]
+			val1, exists := currentArray.Lookup(int64(1))
 			if !exists || !val1.IsInt() || val1.ToString() != expectedValues[i][1] {
 				t.Fatalf("Expected second value '%s' at iteration %d, got %v", expectedValues[i][1], i, val1)
 			}
@@ -2,7 +2,6 @@ package spl
 
 import (
 	"fmt"
-	"sort"
 
 	"github.com/wudi/hey/registry"
 	"github.com/wudi/hey/values"
@@ -40,37 +39,13 @@ func GetRecursiveArrayIteratorClass() *registry.ClassDescriptor {
 			obj.Properties["__array"] = array
 			obj.Properties["__position"] = values.NewInt(0)
 
-			// Build keys array for iteration in sorted order (same as ArrayIterator)
+			// Build keys array for iteration in insertion order (same as ArrayIterator)
 			keys := values.NewArray()
 			if array.IsArray() {
 				arr := array.Data.(*values.Array)
 
-				// Collect all keys first
-				var intKeys []int64
-				var stringKeys []string
-
-				for k := range arr.Elements {
-					switch v := k.(type) {
-					case int64:
-						intKeys = append(intKeys, v)
-					case string:
-						stringKeys = append(stringKeys, v)
-					}
-				}
-
-				// Sort keys to ensure consistent iteration order
-				sort.Slice(intKeys, func(i, j int) bool { return intKeys[i] < intKeys[j] })
-				sort.Strings(stringKeys)
-
-				// Add sorted keys to keys array
-				index := 0
-				for _, key := range intKeys {
-					keys.ArraySet(values.NewInt(int64(index)), values.NewInt(key))
-					index++
-				}
-				for _, key := range stringKeys {
-					keys.ArraySet(values.NewInt(int64(index)), values.NewString(key))
-					index++
+				for _, key := range arr.Keys() {
+					keys.ArraySet(nil, values.KeyValue(key))
 				}
 			}
 			obj.Properties["__keys"] = keys
@@ -189,32 +164,8 @@ func GetRecursiveArrayIteratorClass() *registry.ClassDescriptor {
 			if currentValue.IsArray() {
 				arr := currentValue.Data.(*values.Array)
 
-				// Collect all keys first
-				var intKeys []int64
-				var stringKeys []string
-
-				for k := range arr.Elements {
-					switch v := k.(type) {
-					case int64:
-						intKeys = append(intKeys, v)
-					case string:
-						stringKeys = append(stringKeys, v)
-					}
-				}
-
-				// Sort keys to ensure consistent iteration order
-				sort.Slice(intKeys, func(i, j int) bool { return intKeys[i] < intKeys[j] })
-				sort.Strings(stringKeys)
-
-				// Add sorted keys to keys array
-				index := 0
-				for _, key := range intKeys {
-					childKeys.ArraySet(values.NewInt(int64(index)), values.NewInt(key))
-					index++
-				}
-				for _, key := range stringKeys {
-					childKeys.ArraySet(values.NewInt(int64(index)), values.NewString(key))
-					index++
+				for _, key := range arr.Keys() {
+					childKeys.ArraySet(nil, values.KeyValue(key))
 				}
 			}
 			childObj.Properties["__keys"] = childKeys
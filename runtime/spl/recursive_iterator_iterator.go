@@ -3,7 +3,6 @@ package spl
 import (
 	"fmt"
 	"os"
-	"sort"
 
 	"github.com/wudi/hey/registry"
 	"github.com/wudi/hey/values"
@@ -694,34 +693,12 @@ func callDirectIteratorGetChildren(iter *values.Value) *values.Value {
 								childObj.Properties["__array"] = currentValue
 								childObj.Properties["__position"] = values.NewInt(0)
 
-								// Build sorted keys for child iterator (same logic as RecursiveArrayIterator constructor)
+								// Build keys for child iterator (same logic as RecursiveArrayIterator constructor)
 								keys := values.NewArray()
 								childArr := currentValue.Data.(*values.Array)
 
-								// Use same key sorting logic as ArrayIterator
-								var intKeys []int64
-								var stringKeys []string
-								for k := range childArr.Elements {
-									switch v := k.(type) {
-									case int64:
-										intKeys = append(intKeys, v)
-									case string:
-										stringKeys = append(stringKeys, v)
-									}
-								}
-
-								// Sort keys to ensure consistent iteration order
-								sort.Slice(intKeys, func(i, j int) bool { return intKeys[i] < intKeys[j] })
-								sort.Strings(stringKeys)
-
-								index := 0
-								for _, key := range intKeys {
-									keys.ArraySet(values.NewInt(int64(index)), values.NewInt(key))
-									index++
-								}
-								for _, key := range stringKeys {
-									keys.ArraySet(values.NewInt(int64(index)), values.NewString(key))
-									index++
+								for _, key := range childArr.Keys() {
+									keys.ArraySet(nil, values.KeyValue(key))
 								}
 
 								childObj.Properties["__keys"] = keys
@@ -268,22 +268,22 @@ func TestRegexIterator(t *testing.T) {
 		}
 
 		matchArray := currentResult.Data.(*values.Array)
-		if len(matchArray.Elements) != 3 {
-			t.Fatalf("Expected 3 captures, got %d", len(matchArray.Elements))
+		if matchArray.Len() != 3 {
+			t.Fatalf("Expected 3 captures, got %d", matchArray.Len())
 		}
 
 		// Check capture groups: [0] = full match, [1] = "regex", [2] = "match"
-		fullMatch := matchArray.Elements[int64(0)]
+		fullMatch := matchArray.Get(int64(0))
 		if fullMatch.ToString() != "regex_match" {
 			t.Fatalf("Expected full match 'regex_match', got '%s'", fullMatch.ToString())
 		}
 
-		group1 := matchArray.Elements[int64(1)]
+		group1 := matchArray.Get(int64(1))
 		if group1.ToString() != "regex" {
 			t.Fatalf("Expected capture group 1 'regex', got '%s'", group1.ToString())
 		}
 
-		group2 := matchArray.Elements[int64(2)]
+		group2 := matchArray.Get(int64(2))
 		if group2.ToString() != "match" {
 			t.Fatalf("Expected capture group 2 'match', got '%s'", group2.ToString())
 		}
@@ -34,6 +34,46 @@ func GetSplAutoloadFunctions() []*registry.Function {
 	}
 }
 
+// AutoloadChain holds the autoloaders registered by one request. Call
+// contexts that own a chain expose it through autoloadChainOwner; the
+// package-level list is only used when they do not.
+type AutoloadChain struct {
+	mu        sync.RWMutex
+	functions []*values.Value
+}
+
+// NewAutoloadChain creates an empty autoload chain
+func NewAutoloadChain() *AutoloadChain {
+	return &AutoloadChain{functions: make([]*values.Value, 0)}
+}
+
+// Functions returns a snapshot of the registered autoloaders in the order
+// they should be called.
+func (c *AutoloadChain) Functions() []*values.Value {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	functions := make([]*values.Value, len(c.functions))
+	copy(functions, c.functions)
+	return functions
+}
+
+// autoloadChainOwner is implemented by call contexts that keep their own
+// autoload chain
+type autoloadChainOwner interface {
+	AutoloadChain() *AutoloadChain
+}
+
+// autoloadState returns the lock and the autoloader list for ctx
+func autoloadState(ctx registry.BuiltinCallContext) (*sync.RWMutex, *[]*values.Value) {
+	if owner, ok := ctx.(autoloadChainOwner); ok {
+		if chain := owner.AutoloadChain(); chain != nil {
+			return &chain.mu, &chain.functions
+		}
+	}
+	return &autoloadMutex, &autoloadFunctions
+}
+
 // spl_autoload() - Default autoload implementation
 func getSplAutoloadFunction() *registry.Function {
 	return &registry.Function{
@@ -87,23 +127,8 @@ func getSplAutoloadCallFunction() *registry.Function {
 				return values.NewNull(), fmt.Errorf("spl_autoload_call() expects exactly 1 parameter, %d given", len(args))
 			}
 
-			className := args[0].ToString()
-
-			autoloadMutex.RLock()
-			functions := make([]*values.Value, len(autoloadFunctions))
-			copy(functions, autoloadFunctions)
-			autoloadMutex.RUnlock()
-
-			// Call each registered autoload function
-			for _, autoloadFunc := range functions {
-				if autoloadFunc.IsCallable() {
-					// Call the autoload function with the class name
-					// In a full implementation, this would use CallUserFunction
-					// For now, we'll simulate the call by using the className
-					_ = className // Use className to avoid unused variable error
-					continue
-				}
-			}
+			// Run the registered autoloaders in order until the class is defined
+			ctx.AutoloadClass(args[0].ToString())
 
 			return values.NewNull(), nil
 		},
@@ -152,12 +177,13 @@ func getSplAutoloadFunctionsFunction() *registry.Function {
 		Name:      "spl_autoload_functions",
 		IsBuiltin: true,
 		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
-			autoloadMutex.RLock()
-			defer autoloadMutex.RUnlock()
+			mu, functions := autoloadState(ctx)
+			mu.RLock()
+			defer mu.RUnlock()
 
 			// Always return an array, even if empty (PHP standard behavior)
 			result := values.NewArray()
-			for i, autoloadFunc := range autoloadFunctions {
+			for i, autoloadFunc := range *functions {
 				result.ArraySet(values.NewInt(int64(i)), autoloadFunc)
 			}
 
@@ -194,19 +220,20 @@ func getSplAutoloadRegisterFunction() *registry.Function {
 			}
 
 			// Validate that the function is callable
-			// For simplicity, we'll accept strings (function names) and closures
-			if !autoloadFunc.IsCallable() && autoloadFunc.Type != values.TypeString {
+			// Accept function names, closures and [object|class, method] arrays
+			if !autoloadFunc.IsCallable() && autoloadFunc.Type != values.TypeString && !isArrayCallable(autoloadFunc) {
 				if throw {
 					return values.NewNull(), fmt.Errorf("spl_autoload_register(): Argument #1 ($callback) must be a valid callback or null")
 				}
 				return values.NewBool(false), nil
 			}
 
-			autoloadMutex.Lock()
-			defer autoloadMutex.Unlock()
+			mu, functions := autoloadState(ctx)
+			mu.Lock()
+			defer mu.Unlock()
 
 			// Check if already registered
-			for _, existing := range autoloadFunctions {
+			for _, existing := range *functions {
 				if equalCallables(existing, autoloadFunc) {
 					return values.NewBool(true), nil // Already registered
 				}
@@ -215,10 +242,10 @@ func getSplAutoloadRegisterFunction() *registry.Function {
 			// Add to list
 			if prepend {
 				// Add to beginning
-				autoloadFunctions = append([]*values.Value{autoloadFunc}, autoloadFunctions...)
+				*functions = append([]*values.Value{autoloadFunc}, *functions...)
 			} else {
 				// Add to end
-				autoloadFunctions = append(autoloadFunctions, autoloadFunc)
+				*functions = append(*functions, autoloadFunc)
 			}
 
 			return values.NewBool(true), nil
@@ -243,14 +270,15 @@ func getSplAutoloadUnregisterFunction() *registry.Function {
 
 			autoloadFunc := args[0]
 
-			autoloadMutex.Lock()
-			defer autoloadMutex.Unlock()
+			mu, functions := autoloadState(ctx)
+			mu.Lock()
+			defer mu.Unlock()
 
 			// Find and remove the function
-			for i, existing := range autoloadFunctions {
+			for i, existing := range *functions {
 				if equalCallables(existing, autoloadFunc) {
 					// Remove from slice
-					autoloadFunctions = append(autoloadFunctions[:i], autoloadFunctions[i+1:]...)
+					*functions = append((*functions)[:i], (*functions)[i+1:]...)
 					return values.NewBool(true), nil
 				}
 			}
@@ -273,10 +301,38 @@ func equalCallables(a, b *values.Value) bool {
 	switch a.Type {
 	case values.TypeString:
 		return a.ToString() == b.ToString()
-	case values.TypeObject:
+	case values.TypeObject, values.TypeCallable:
 		// For closures/objects, compare by memory address (simplified)
 		return a.Data == b.Data
+	case values.TypeArray:
+		if !isArrayCallable(a) || !isArrayCallable(b) {
+			return false
+		}
+		targetA, targetB := a.ArrayGet(values.NewInt(0)), b.ArrayGet(values.NewInt(0))
+		if targetA.IsObject() != targetB.IsObject() {
+			return false
+		}
+		if targetA.IsObject() {
+			if targetA.Data != targetB.Data {
+				return false
+			}
+		} else if !strings.EqualFold(targetA.ToString(), targetB.ToString()) {
+			return false
+		}
+		return strings.EqualFold(a.ArrayGet(values.NewInt(1)).ToString(), b.ArrayGet(values.NewInt(1)).ToString())
 	default:
 		return false
 	}
-}
\ No newline at end of file
+}
+// isArrayCallable reports whether v has the [object|class, method] callable shape
+func isArrayCallable(v *values.Value) bool {
+	if v == nil || !v.IsArray() || v.ArrayCount() != 2 {
+		return false
+	}
+	target := v.ArrayGet(values.NewInt(0))
+	method := v.ArrayGet(values.NewInt(1))
+	if target == nil || method == nil || method.Type != values.TypeString {
+		return false
+	}
+	return target.IsObject() || target.Type == values.TypeString
+}
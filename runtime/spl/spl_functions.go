@@ -175,7 +175,7 @@ func getIteratorToArrayFunction() *registry.Function {
 						// Copy array data
 						arrData := arr.Data.(*values.Array)
 						index := int64(0)
-						for k, v := range arrData.Elements {
+						for k, v := range arrData.All() {
 							if useKeys {
 								// Use original keys
 								switch key := k.(type) {
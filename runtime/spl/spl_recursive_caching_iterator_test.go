@@ -194,7 +194,7 @@ func createRecursiveArrayIterator(data *values.Value) *values.Value {
 	recursiveArrayObj.Properties["__flags"] = values.NewInt(0)
 	recursiveArrayObj.Properties["__position"] = values.NewInt(0)
 
-	// Build keys array for iteration in sorted order (same as ArrayIterator)
+	// Build keys array for iteration in insertion order (same as ArrayIterator)
 	keys := values.NewArray()
 	if data.IsArray() {
 		arr := data.Data.(*values.Array)
@@ -202,7 +202,7 @@ func createRecursiveArrayIterator(data *values.Value) *values.Value {
 		// Collect all keys first
 		var stringKeys []string
 
-		for k := range arr.Elements {
+		for k := range arr.All() {
 			switch v := k.(type) {
 			case string:
 				stringKeys = append(stringKeys, v)
@@ -361,7 +361,7 @@ func GetStringFunctions() []*registry.Function {
 				result := values.NewArray()
 				resultArr := result.Data.(*values.Array)
 				for i, part := range parts {
-					resultArr.Elements[int64(i)] = values.NewString(part)
+					resultArr.Set(int64(i), values.NewString(part))
 				}
 
 				return result, nil
@@ -382,19 +382,7 @@ func GetStringFunctions() []*registry.Function {
 					return values.NewString(""), nil
 				}
 
-				separator := args[0].ToString()
-				arr := args[1].Data.(*values.Array)
-
-				// Convert array elements to strings and join
-				var parts []string
-				for _, value := range arr.Elements {
-					if value != nil {
-						parts = append(parts, value.ToString())
-					}
-				}
-
-				result := strings.Join(parts, separator)
-				return values.NewString(result), nil
+				return values.NewString(implodeArray(args[0].ToString(), args[1].Data.(*values.Array))), nil
 			},
 		},
 		{
@@ -413,19 +401,7 @@ func GetStringFunctions() []*registry.Function {
 					return values.NewString(""), nil
 				}
 
-				separator := args[0].ToString()
-				arr := args[1].Data.(*values.Array)
-
-				// Convert array elements to strings and join
-				var parts []string
-				for _, value := range arr.Elements {
-					if value != nil {
-						parts = append(parts, value.ToString())
-					}
-				}
-
-				result := strings.Join(parts, separator)
-				return values.NewString(result), nil
+				return values.NewString(implodeArray(args[0].ToString(), args[1].Data.(*values.Array))), nil
 			},
 		},
 		{
@@ -491,8 +467,8 @@ func GetStringFunctions() []*registry.Function {
 
 				// Convert array elements to Go interface{} for fmt.Sprintf in index order
 				var goArgs []interface{}
-				for i := int64(0); i < int64(len(arr.Elements)); i++ {
-					if value, exists := arr.Elements[i]; exists {
+				for i := int64(0); i < int64(arr.Len()); i++ {
+					if value, exists := arr.Lookup(i); exists {
 						if value == nil {
 							goArgs = append(goArgs, nil)
 						} else if value.IsInt() {
@@ -531,8 +507,8 @@ func GetStringFunctions() []*registry.Function {
 
 				// Convert array elements to Go interface{} for fmt.Printf in index order
 				var goArgs []interface{}
-				for i := int64(0); i < int64(len(arr.Elements)); i++ {
-					if value, exists := arr.Elements[i]; exists {
+				for i := int64(0); i < int64(arr.Len()); i++ {
+					if value, exists := arr.Lookup(i); exists {
 						if value == nil {
 							goArgs = append(goArgs, nil)
 						} else if value.IsInt() {
@@ -1199,7 +1175,7 @@ func GetStringFunctions() []*registry.Function {
 					}
 
 					chunk := string(strRunes[i:end])
-					resultArr.Elements[index] = values.NewString(chunk)
+					resultArr.Set(index, values.NewString(chunk))
 					index++
 				}
 
@@ -1732,7 +1708,7 @@ func GetStringFunctions() []*registry.Function {
 					result := values.NewArray()
 					arr := result.Data.(*values.Array)
 					for i, word := range words {
-						arr.Elements[int64(i)] = values.NewString(word)
+						arr.Set(int64(i), values.NewString(word))
 					}
 					return result, nil
 				case 2:
@@ -1741,7 +1717,7 @@ func GetStringFunctions() []*registry.Function {
 					arr := result.Data.(*values.Array)
 					for i, word := range words {
 						pos := wordPositions[i]
-						arr.Elements[int64(pos)] = values.NewString(word)
+						arr.Set(int64(pos), values.NewString(word))
 					}
 					return result, nil
 				default:
@@ -3299,23 +3275,20 @@ func parseQueryString(queryStr string) *values.Value {
 		if strings.HasSuffix(key, "[]") {
 			// Simple array: key[] = value
 			arrayKey := key[:len(key)-2]
-			if existing, exists := resultArray.Elements[arrayKey]; exists && existing.Type == values.TypeArray {
+			if existing, exists := resultArray.Lookup(arrayKey); exists && existing.Type == values.TypeArray {
 				// Append to existing array
 				existingArray := existing.Data.(*values.Array)
-				nextIndex := existingArray.NextIndex
-				existingArray.Elements[nextIndex] = values.NewString(value)
-				existingArray.NextIndex++
+				existingArray.Append(values.NewString(value))
 			} else {
 				// Create new array
 				newArray := values.NewArray()
 				newArrayData := newArray.Data.(*values.Array)
-				newArrayData.Elements[int64(0)] = values.NewString(value)
-				newArrayData.NextIndex = 1
-				resultArray.Elements[arrayKey] = newArray
+				newArrayData.Set(int64(0), values.NewString(value))
+				resultArray.Set(arrayKey, newArray)
 			}
 		} else {
 			// Simple key-value pair
-			resultArray.Elements[key] = values.NewString(value)
+			resultArray.Set(key, values.NewString(value))
 		}
 	}
 
@@ -3434,14 +3407,13 @@ func sscanfParse(str, format string) *values.Value {
 			val = values.NewNull()
 		}
 
-		resultArray.Elements[outputIndex] = val
+		resultArray.Set(outputIndex, val)
 		outputIndex++
 		if hasAnyMatch {
 			matchIndex++
 		}
 	}
 
-	resultArray.NextIndex = outputIndex
 	return result
 }
 
@@ -4741,3 +4713,14 @@ func compareComponent(c1, c2 versionComponent) int {
 
 	return 0
 }
+
+// implodeArray joins the string forms of arr's elements in order
+func implodeArray(separator string, arr *values.Array) string {
+	parts := make([]string, 0, arr.Len())
+	for _, value := range arr.All() {
+		if value != nil {
+			parts = append(parts, value.ToString())
+		}
+	}
+	return strings.Join(parts, separator)
+}
@@ -3217,12 +3217,12 @@ func TestStringFunctions(t *testing.T) {
 						return
 					}
 					arrayData := result.Data.(*values.Array)
-					if len(arrayData.Elements) != len(expected) {
-						t.Errorf("Expected %d elements, got %d", len(expected), len(arrayData.Elements))
+					if arrayData.Len() != len(expected) {
+						t.Errorf("Expected %d elements, got %d", len(expected), arrayData.Len())
 						return
 					}
 					for i, expectedStr := range expected {
-						element, exists := arrayData.Elements[int64(i)]
+						element, exists := arrayData.Lookup(int64(i))
 						if !exists {
 							t.Errorf("Expected element at index %d not found", i)
 							continue
@@ -3237,13 +3237,13 @@ func TestStringFunctions(t *testing.T) {
 						return
 					}
 					arrayData := result.Data.(*values.Array)
-					if len(arrayData.Elements) != len(expected) {
-						t.Errorf("Expected %d elements, got %d", len(expected), len(arrayData.Elements))
+					if arrayData.Len() != len(expected) {
+						t.Errorf("Expected %d elements, got %d", len(expected), arrayData.Len())
 						return
 					}
 					// For format 2, check elements at specific positions
 					for key, expectedStr := range expected {
-						element, exists := arrayData.Elements[key]
+						element, exists := arrayData.Lookup(key)
 						if !exists {
 							t.Errorf("Expected key %d not found in result", key)
 							continue
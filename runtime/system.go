@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,6 +17,12 @@ import (
 	"github.com/wudi/hey/values"
 )
 
+// generatorCloser is implemented by call contexts that can tear down the
+// suspended generators the garbage collector has released
+type generatorCloser interface {
+	CloseUnreachableGenerators()
+}
+
 // Process represents an open process handle
 type Process struct {
 	cmd       *exec.Cmd
@@ -115,7 +122,7 @@ func GetSystemFunctions() []*registry.Function {
 					for _, env := range envVars {
 						parts := strings.SplitN(env, "=", 2)
 						if len(parts) == 2 {
-							arr.Elements[parts[0]] = values.NewString(parts[1])
+							arr.Set(parts[0], values.NewString(parts[1]))
 						}
 					}
 					return result, nil
@@ -401,14 +408,12 @@ func GetSystemFunctions() []*registry.Function {
 					if outputArray.IsArray() {
 						arr := outputArray.Data.(*values.Array)
 						// Clear the array
-						arr.Elements = make(map[interface{}]*values.Value)
-						arr.NextIndex = 0
+						arr.Clear()
 
 						// Add output lines
 						for i, line := range outputLines {
-							arr.Elements[int64(i)] = values.NewString(line)
+							arr.Set(int64(i), values.NewString(line))
 						}
-						arr.NextIndex = int64(len(outputLines))
 					}
 				}
 
@@ -574,7 +579,7 @@ func GetSystemFunctions() []*registry.Function {
 				if len(args) > 4 && !args[4].IsNull() && args[4].IsArray() {
 					envArray := args[4].Data.(*values.Array)
 					var envVars []string
-					for key, val := range envArray.Elements {
+					for key, val := range envArray.All() {
 						envVars = append(envVars, fmt.Sprintf("%v=%s", key, val.ToString()))
 					}
 					cmd.Env = envVars
@@ -597,25 +602,24 @@ func GetSystemFunctions() []*registry.Function {
 				pipeArr := pipesArray.Data.(*values.Array)
 
 				// Clear pipes array
-				pipeArr.Elements = make(map[interface{}]*values.Value)
-				pipeArr.NextIndex = 0
+				pipeArr.Clear()
 
 				descArr := descriptorSpec.Data.(*values.Array)
 
 				// Process each descriptor
-				for fdNum, descriptor := range descArr.Elements {
+				for fdNum, descriptor := range descArr.All() {
 					if !descriptor.IsArray() {
 						continue
 					}
 
 					descArray := descriptor.Data.(*values.Array)
-					if len(descArray.Elements) < 2 {
+					if descArray.Len() < 2 {
 						continue
 					}
 
 					// Get descriptor type
 					descType := ""
-					if typeVal, ok := descArray.Elements[int64(0)]; ok {
+					if typeVal, ok := descArray.Lookup(int64(0)); ok {
 						descType = typeVal.ToString()
 					}
 
@@ -628,7 +632,7 @@ func GetSystemFunctions() []*registry.Function {
 							}
 							proc.stdin = stdin
 							// Store pipe handle (simplified - would need proper resource type)
-							pipeArr.Elements[int64(0)] = values.NewResource(stdin)
+							pipeArr.Set(int64(0), values.NewResource(stdin))
 						}
 					case int64(1): // stdout
 						if descType == "pipe" {
@@ -637,10 +641,10 @@ func GetSystemFunctions() []*registry.Function {
 								return values.NewBool(false), nil
 							}
 							proc.stdout = stdout
-							pipeArr.Elements[int64(1)] = values.NewResource(stdout)
+							pipeArr.Set(int64(1), values.NewResource(stdout))
 						} else if descType == "file" {
 							// Handle file output
-							if fileVal, ok := descArray.Elements[int64(1)]; ok {
+							if fileVal, ok := descArray.Lookup(int64(1)); ok {
 								filename := fileVal.ToString()
 								file, err := os.Create(filename)
 								if err != nil {
@@ -657,10 +661,10 @@ func GetSystemFunctions() []*registry.Function {
 								return values.NewBool(false), nil
 							}
 							proc.stderr = stderr
-							pipeArr.Elements[int64(2)] = values.NewResource(stderr)
+							pipeArr.Set(int64(2), values.NewResource(stderr))
 						} else if descType == "file" {
 							// Handle file output
-							if fileVal, ok := descArray.Elements[int64(1)]; ok {
+							if fileVal, ok := descArray.Lookup(int64(1)); ok {
 								filename := fileVal.ToString()
 								file, err := os.Create(filename)
 								if err != nil {
@@ -778,14 +782,14 @@ func GetSystemFunctions() []*registry.Function {
 				arr := status.Data.(*values.Array)
 
 				// Set command
-				arr.Elements["command"] = values.NewString(strings.Join(proc.cmd.Args, " "))
+				arr.Set("command", values.NewString(strings.Join(proc.cmd.Args, " ")))
 
 				// Set PID
 				pid := 0
 				if proc.cmd.Process != nil {
 					pid = proc.cmd.Process.Pid
 				}
-				arr.Elements["pid"] = values.NewInt(int64(pid))
+				arr.Set("pid", values.NewInt(int64(pid)))
 
 				// Check if running
 				running := false
@@ -795,20 +799,20 @@ func GetSystemFunctions() []*registry.Function {
 						running = true
 					}
 				}
-				arr.Elements["running"] = values.NewBool(running)
+				arr.Set("running", values.NewBool(running))
 
 				// Set exit status
 				if proc.cmd.ProcessState != nil {
-					arr.Elements["exitcode"] = values.NewInt(int64(proc.cmd.ProcessState.ExitCode()))
+					arr.Set("exitcode", values.NewInt(int64(proc.cmd.ProcessState.ExitCode())))
 				} else {
-					arr.Elements["exitcode"] = values.NewInt(-1)
+					arr.Set("exitcode", values.NewInt(-1))
 				}
 
 				// Set signal info
-				arr.Elements["signaled"] = values.NewBool(proc.signaled)
-				arr.Elements["stopped"] = values.NewBool(proc.stopped)
-				arr.Elements["termsig"] = values.NewInt(int64(proc.termsig))
-				arr.Elements["stopsig"] = values.NewInt(int64(proc.stopsig))
+				arr.Set("signaled", values.NewBool(proc.signaled))
+				arr.Set("stopped", values.NewBool(proc.stopped))
+				arr.Set("termsig", values.NewInt(int64(proc.termsig)))
+				arr.Set("stopsig", values.NewInt(int64(proc.stopsig)))
 
 				return status, nil
 			},
@@ -934,5 +938,33 @@ func GetSystemFunctions() []*registry.Function {
 				return values.NewBool(false), nil
 			},
 		},
+		{
+			Name:       "gc_collect_cycles",
+			Parameters: []*registry.Parameter{},
+			ReturnType: "int",
+			MinArgs:    0,
+			MaxArgs:    0,
+			IsBuiltin:  true,
+			Builtin: func(ctx registry.BuiltinCallContext, _ []*values.Value) (*values.Value, error) {
+				// Objects are released by Go's collector, which also clears
+				// weak references and WeakMap entries of unreachable objects
+				runtime.GC()
+				if closer, ok := ctx.(generatorCloser); ok {
+					closer.CloseUnreachableGenerators()
+				}
+				return values.NewInt(0), nil
+			},
+		},
+		{
+			Name:       "gc_enabled",
+			Parameters: []*registry.Parameter{},
+			ReturnType: "bool",
+			MinArgs:    0,
+			MaxArgs:    0,
+			IsBuiltin:  true,
+			Builtin: func(_ registry.BuiltinCallContext, _ []*values.Value) (*values.Value, error) {
+				return values.NewBool(true), nil
+			},
+		},
 	}
 }
\ No newline at end of file
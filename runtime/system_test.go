@@ -189,10 +189,10 @@ func TestSystemFunctions(t *testing.T) {
 
 		// Check output array
 		arr := output.Data.(*values.Array)
-		if len(arr.Elements) != 1 {
-			t.Errorf("exec output array should have 1 element, got %d", len(arr.Elements))
-		} else if arr.Elements[int64(0)].ToString() != "Hello World" {
-			t.Errorf("exec output array[0] = %q, want %q", arr.Elements[int64(0)].ToString(), "Hello World")
+		if arr.Len() != 1 {
+			t.Errorf("exec output array should have 1 element, got %d", arr.Len())
+		} else if arr.Get(int64(0)).ToString() != "Hello World" {
+			t.Errorf("exec output array[0] = %q, want %q", arr.Get(int64(0)).ToString(), "Hello World")
 		}
 	})
 
@@ -265,23 +265,23 @@ func TestSystemFunctions(t *testing.T) {
 		// stdin pipe
 		stdin := values.NewArray()
 		stdinArr := stdin.Data.(*values.Array)
-		stdinArr.Elements[int64(0)] = values.NewString("pipe")
-		stdinArr.Elements[int64(1)] = values.NewString("r")
-		descArr.Elements[int64(0)] = stdin
+		stdinArr.Set(int64(0), values.NewString("pipe"))
+		stdinArr.Set(int64(1), values.NewString("r"))
+		descArr.Set(int64(0), stdin)
 
 		// stdout pipe
 		stdout := values.NewArray()
 		stdoutArr := stdout.Data.(*values.Array)
-		stdoutArr.Elements[int64(0)] = values.NewString("pipe")
-		stdoutArr.Elements[int64(1)] = values.NewString("w")
-		descArr.Elements[int64(1)] = stdout
+		stdoutArr.Set(int64(0), values.NewString("pipe"))
+		stdoutArr.Set(int64(1), values.NewString("w"))
+		descArr.Set(int64(1), stdout)
 
 		// stderr pipe
 		stderr := values.NewArray()
 		stderrArr := stderr.Data.(*values.Array)
-		stderrArr.Elements[int64(0)] = values.NewString("pipe")
-		stderrArr.Elements[int64(1)] = values.NewString("w")
-		descArr.Elements[int64(2)] = stderr
+		stderrArr.Set(int64(0), values.NewString("pipe"))
+		stderrArr.Set(int64(1), values.NewString("w"))
+		descArr.Set(int64(2), stderr)
 
 		// Pipes array to be filled
 		pipes := values.NewArray()
@@ -330,15 +330,15 @@ func TestSystemFunctions(t *testing.T) {
 
 		stdin := values.NewArray()
 		stdinArr := stdin.Data.(*values.Array)
-		stdinArr.Elements[int64(0)] = values.NewString("pipe")
-		stdinArr.Elements[int64(1)] = values.NewString("r")
-		descArr.Elements[int64(0)] = stdin
+		stdinArr.Set(int64(0), values.NewString("pipe"))
+		stdinArr.Set(int64(1), values.NewString("r"))
+		descArr.Set(int64(0), stdin)
 
 		stdout := values.NewArray()
 		stdoutArr := stdout.Data.(*values.Array)
-		stdoutArr.Elements[int64(0)] = values.NewString("pipe")
-		stdoutArr.Elements[int64(1)] = values.NewString("w")
-		descArr.Elements[int64(1)] = stdout
+		stdoutArr.Set(int64(0), values.NewString("pipe"))
+		stdoutArr.Set(int64(1), values.NewString("w"))
+		descArr.Set(int64(1), stdout)
 
 		pipes := values.NewArray()
 
@@ -365,7 +365,7 @@ func TestSystemFunctions(t *testing.T) {
 			statusArr := status.Data.(*values.Array)
 
 			// Check if running field exists
-			if running, ok := statusArr.Elements["running"]; ok {
+			if running, ok := statusArr.Lookup("running"); ok {
 				if !running.IsBool() {
 					t.Error("running field should be a boolean")
 				}
@@ -374,7 +374,7 @@ func TestSystemFunctions(t *testing.T) {
 			}
 
 			// Check if pid field exists
-			if pid, ok := statusArr.Elements["pid"]; ok {
+			if pid, ok := statusArr.Lookup("pid"); ok {
 				if !pid.IsInt() {
 					t.Error("pid field should be an integer")
 				}
@@ -402,9 +402,9 @@ func TestSystemFunctions(t *testing.T) {
 
 		stdin := values.NewArray()
 		stdinArr := stdin.Data.(*values.Array)
-		stdinArr.Elements[int64(0)] = values.NewString("pipe")
-		stdinArr.Elements[int64(1)] = values.NewString("r")
-		descArr.Elements[int64(0)] = stdin
+		stdinArr.Set(int64(0), values.NewString("pipe"))
+		stdinArr.Set(int64(1), values.NewString("r"))
+		descArr.Set(int64(0), stdin)
 
 		pipes := values.NewArray()
 
@@ -551,13 +551,13 @@ func TestGetLoadedExtensions(t *testing.T) {
 	}
 
 	arr := result.Data.(*values.Array)
-	if len(arr.Elements) == 0 {
+	if arr.Len() == 0 {
 		t.Fatal("Expected non-empty array of extensions")
 	}
 
 	// Check that "Core" extension is included
 	found := false
-	for _, val := range arr.Elements {
+	for _, val := range arr.All() {
 		if val != nil && val.Type == values.TypeString && val.ToString() == "Core" {
 			found = true
 			break
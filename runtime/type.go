@@ -63,7 +63,7 @@ func GetTypeFunctions() []*registry.Function {
 				if len(args) == 0 || args[0] == nil {
 					return values.NewBool(false), nil
 				}
-				return values.NewBool(args[0].IsObject()), nil
+				return values.NewBool(args[0].IsObject() || args[0].IsCallable()), nil
 			},
 		},
 		{
@@ -164,7 +164,8 @@ func GetTypeFunctions() []*registry.Function {
 					return values.NewString("string"), nil
 				case values.TypeArray:
 					return values.NewString("array"), nil
-				case values.TypeObject:
+				case values.TypeObject, values.TypeCallable:
+					// Closures are objects of class Closure
 					return values.NewString("object"), nil
 				case values.TypeResource:
 					return values.NewString("resource"), nil
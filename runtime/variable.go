@@ -18,6 +18,7 @@ var supportedExtensions = map[string]bool{
 	"mbstring": true,
 	"ctype":    true,
 	"hash":     true, // We support hash functions (md5, sha1, sha256, etc.)
+	"pcov":     true,
 }
 
 // GetVariableFunctions returns variable-related PHP functions
@@ -230,7 +231,7 @@ func GetVariableFunctions() []*registry.Function {
 					return values.NewBool(str == "" || str == "0"), nil
 				case values.TypeArray:
 					arr := value.Data.(*values.Array)
-					return values.NewBool(len(arr.Elements) == 0), nil
+					return values.NewBool(arr.Len() == 0), nil
 				case values.TypeNull:
 					return values.NewBool(true), nil
 				default:
@@ -315,12 +316,15 @@ func GetVariableFunctions() []*registry.Function {
 			MinArgs:    1,
 			MaxArgs:    1,
 			IsBuiltin: true,
-			Builtin: func(_ registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
 				if len(args) == 0 {
 					return values.NewBool(false), nil
 				}
 
 				data := args[0].ToString()
+				if strings.HasPrefix(data, "E:") {
+					return unserializeEnumCase(ctx, data), nil
+				}
 				value, err := unserializeValue(data)
 				if err != nil {
 					// Return false on error, as per PHP behavior
@@ -369,7 +373,7 @@ func GetVariableFunctions() []*registry.Function {
 
 				case values.TypeArray:
 					arr := value.Data.(*values.Array)
-					if len(arr.Elements) != 2 {
+					if arr.Len() != 2 {
 						return values.NewBool(false), nil
 					}
 
@@ -395,6 +399,9 @@ func GetVariableFunctions() []*registry.Function {
 
 					return values.NewBool(false), nil
 
+				case values.TypeCallable:
+					return values.NewBool(true), nil
+
 				default:
 					return values.NewBool(false), nil
 				}
@@ -452,18 +459,23 @@ func varExportValue(value *values.Value, indent int) string {
 		return fmt.Sprintf("'%s'", escaped)
 	case values.TypeArray:
 		arr := value.Data.(*values.Array)
-		if len(arr.Elements) == 0 {
+		if arr.Len() == 0 {
 			return "array ()"
 		}
 
 		result := "array (\n"
-		for key, element := range arr.Elements {
+		for key, element := range arr.All() {
 			indentStr := strings.Repeat("  ", indent+1)
 			result += fmt.Sprintf("%s%v => %s,\n", indentStr, key, varExportValue(element, indent+1))
 		}
 		indentStr := strings.Repeat("  ", indent)
 		result += indentStr + ")"
 		return result
+	case values.TypeObject:
+		if obj := value.Data.(*values.Object); obj.EnumCase {
+			return "\\" + obj.ClassName + "::" + obj.Properties["name"].ToString()
+		}
+		return "NULL"
 	default:
 		return "NULL"
 	}
@@ -492,8 +504,8 @@ func serializeValue(value *values.Value) string {
 		return fmt.Sprintf("s:%d:\"%s\";", len(str), str)
 	case values.TypeArray:
 		arr := value.Data.(*values.Array)
-		result := fmt.Sprintf("a:%d:{", len(arr.Elements))
-		for key, element := range arr.Elements {
+		result := fmt.Sprintf("a:%d:{", arr.Len())
+		for key, element := range arr.All() {
 			// Serialize key
 			if keyInt, ok := key.(int64); ok {
 				result += fmt.Sprintf("i:%d;", keyInt)
@@ -506,6 +518,11 @@ func serializeValue(value *values.Value) string {
 		}
 		result += "}"
 		return result
+	case values.TypeObject:
+		if obj := value.Data.(*values.Object); obj.EnumCase {
+			return serializeEnumCase(obj)
+		}
+		return "N;"
 	default:
 		return "N;"
 	}
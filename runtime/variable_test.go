@@ -265,6 +265,11 @@ func (m *mockBuiltinCallContext) GetCurrentFunctionArgCount() (int, error) { ret
 func (m *mockBuiltinCallContext) GetCurrentFunctionArg(index int) (*values.Value, error) { return nil, nil }
 func (m *mockBuiltinCallContext) GetCurrentFunctionArgs() ([]*values.Value, error) { return nil, nil }
 func (m *mockBuiltinCallContext) ThrowException(exception *values.Value) error { return fmt.Errorf("exception thrown in test mock: %v", exception) }
+func (m *mockBuiltinCallContext) AutoloadClass(name string) bool { return false }
+func (m *mockBuiltinCallContext) CallMethod(method *registry.Function, this *values.Value, className string, args []*values.Value) (*values.Value, error) {
+	return nil, nil
+}
+func (m *mockBuiltinCallContext) InstantiateClass(className string) (*values.Value, error) { return nil, nil }
 func (m *mockBuiltinCallContext) GetHTTPContext() registry.HTTPContext { return &mockHTTPContext{} }
 func (m *mockBuiltinCallContext) ResetHTTPContext() {}
 func (m *mockBuiltinCallContext) RemoveHTTPHeader(name string) {}
@@ -0,0 +1,395 @@
+package runtime
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"weak"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// WeakReference and WeakMap refer to objects through Go weak pointers, so
+// they do not keep them alive: once no PHP value holds an object and the
+// garbage collector has run, its WeakReference reads null and its WeakMap
+// entries are gone. gc_collect_cycles() runs the collector on demand.
+
+type weakObject = weak.Pointer[values.Object]
+
+// weakTable maps objects to values without keeping the objects alive. An
+// entry is dropped when the collector releases its object; entries keep
+// their insertion order.
+type weakTable[T any] struct {
+	mu      sync.Mutex
+	entries map[weakObject]T
+	order   []weakObject
+}
+
+func newWeakTable[T any]() *weakTable[T] {
+	return &weakTable[T]{entries: make(map[weakObject]T)}
+}
+
+func (t *weakTable[T]) get(obj *values.Object) (T, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	value, ok := t.entries[weak.Make(obj)]
+	return value, ok
+}
+
+func (t *weakTable[T]) set(obj *values.Object, value T) {
+	key := weak.Make(obj)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.entries[key]; !ok {
+		t.order = append(t.order, key)
+		runtime.AddCleanup(obj, func(key weakObject) { t.remove(key) }, key)
+	}
+	t.entries[key] = value
+}
+
+// delete removes the entry of obj, reporting whether there was one
+func (t *weakTable[T]) delete(obj *values.Object) bool {
+	return t.remove(weak.Make(obj))
+}
+
+func (t *weakTable[T]) remove(key weakObject) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.entries[key]; !ok {
+		return false
+	}
+	delete(t.entries, key)
+	for i, k := range t.order {
+		if k == key {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// objects returns the objects with entries that are still alive, in
+// insertion order
+func (t *weakTable[T]) objects() []*values.Object {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	objects := make([]*values.Object, 0, len(t.order))
+	for _, key := range t.order {
+		if obj := key.Value(); obj != nil {
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+// keys returns the weak pointers of the table's entries in insertion order
+func (t *weakTable[T]) keys() []weakObject {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]weakObject(nil), t.order...)
+}
+
+var (
+	// weakReferenceTargets maps WeakReference objects to their referents,
+	// and weakReferences each referent to its WeakReference
+	weakReferenceTargets = newWeakTable[weakObject]()
+	weakReferences       = newWeakTable[weakObject]()
+
+	// weakMaps holds the entries of each WeakMap object
+	weakMaps = newWeakTable[*weakTable[*values.Value]]()
+
+	// internalIterators holds the state of each InternalIterator object
+	internalIterators = newWeakTable[internalIterator]()
+)
+
+// internalIterator is the state behind an InternalIterator object, which
+// iterates a builtin Traversable such as WeakMap or DatePeriod
+type internalIterator interface {
+	current() *values.Value
+	key() *values.Value
+	next()
+	valid() bool
+	rewind()
+}
+
+// newInternalIterator returns an InternalIterator object driven by it
+func newInternalIterator(it internalIterator) *values.Value {
+	iterator := values.NewObject("InternalIterator")
+	internalIterators.set(iterator.Data.(*values.Object), it)
+	return iterator
+}
+
+// weakMapIterator walks a snapshot of a WeakMap's keys, reading their
+// values as it goes. The snapshot is weak too, so an iteration left
+// unfinished does not keep the keys alive.
+type weakMapIterator struct {
+	entries  *weakTable[*values.Value]
+	keys     []weakObject
+	position int
+}
+
+// entry returns the entry of the iterator's position, skipping objects
+// released or removed from the map since the iteration began
+func (it *weakMapIterator) entry() (*values.Object, *values.Value) {
+	for ; it.position < len(it.keys); it.position++ {
+		if obj := it.keys[it.position].Value(); obj != nil {
+			if value, ok := it.entries.get(obj); ok {
+				return obj, value
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (it *weakMapIterator) current() *values.Value {
+	if _, value := it.entry(); value != nil {
+		return value
+	}
+	return values.NewNull()
+}
+
+func (it *weakMapIterator) key() *values.Value {
+	if key, _ := it.entry(); key != nil {
+		return objectValue(key)
+	}
+	return values.NewNull()
+}
+
+func (it *weakMapIterator) next() {
+	if key, _ := it.entry(); key != nil {
+		it.position++
+	}
+}
+
+func (it *weakMapIterator) valid() bool {
+	key, _ := it.entry()
+	return key != nil
+}
+
+func (it *weakMapIterator) rewind() {
+	it.keys, it.position = it.entries.keys(), 0
+}
+
+// GetWeakReferenceClasses returns the WeakReference, WeakMap and
+// InternalIterator class descriptors
+func GetWeakReferenceClasses() []*registry.ClassDescriptor {
+	return []*registry.ClassDescriptor{
+		getWeakReferenceClass(),
+		getWeakMapClass(),
+		getInternalIteratorClass(),
+	}
+}
+
+type weakMethodFunc func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error)
+
+func getWeakReferenceClass() *registry.ClassDescriptor {
+	methods := map[string]weakMethodFunc{
+		"__construct": func(ctx registry.BuiltinCallContext, _ *values.Value, _ []*values.Value) (*values.Value, error) {
+			return throwWeakError(ctx, "Error", "Direct instantiation of WeakReference is not allowed, use WeakReference::create instead")
+		},
+		"get": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			if target, ok := weakReferenceTargets.get(this.Data.(*values.Object)); ok {
+				if obj := target.Value(); obj != nil {
+					return objectValue(obj), nil
+				}
+			}
+			return values.NewNull(), nil
+		},
+	}
+	staticMethods := map[string]weakMethodFunc{
+		"create": func(ctx registry.BuiltinCallContext, _ *values.Value, args []*values.Value) (*values.Value, error) {
+			target := weakArg(args, 0)
+			if !target.IsObject() {
+				return throwWeakError(ctx, "TypeError", "WeakReference::create(): Argument #1 ($object) must be of type object, %s given", target.TypeName())
+			}
+			obj := target.Data.(*values.Object)
+			// The same object always gets the same WeakReference while it lives
+			if existing, ok := weakReferences.get(obj); ok {
+				if ref := existing.Value(); ref != nil {
+					return objectValue(ref), nil
+				}
+			}
+			ref := values.NewObject("WeakReference")
+			weakReferenceTargets.set(ref.Data.(*values.Object), weak.Make(obj))
+			weakReferences.set(obj, weak.Make(ref.Data.(*values.Object)))
+			return ref, nil
+		},
+	}
+	return weakClass("WeakReference", nil, methods, staticMethods)
+}
+
+func getWeakMapClass() *registry.ClassDescriptor {
+	methods := map[string]weakMethodFunc{
+		"offsetGet": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			key, err := weakMapKey(ctx, args)
+			if err != nil {
+				return nil, err
+			}
+			value, ok := weakMapEntries(this).get(key)
+			if !ok {
+				return throwWeakError(ctx, "Error", "Object %s not contained in WeakMap", key.ClassName)
+			}
+			return value, nil
+		},
+		"offsetSet": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			if weakArg(args, 0).IsNull() {
+				return throwWeakError(ctx, "Error", "Cannot append to WeakMap")
+			}
+			key, err := weakMapKey(ctx, args)
+			if err != nil {
+				return nil, err
+			}
+			weakMapEntries(this).set(key, weakArg(args, 1))
+			return values.NewNull(), nil
+		},
+		"offsetExists": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			key, err := weakMapKey(ctx, args)
+			if err != nil {
+				return nil, err
+			}
+			value, ok := weakMapEntries(this).get(key)
+			return values.NewBool(ok && !value.IsNull()), nil
+		},
+		"offsetUnset": func(ctx registry.BuiltinCallContext, this *values.Value, args []*values.Value) (*values.Value, error) {
+			key, err := weakMapKey(ctx, args)
+			if err != nil {
+				return nil, err
+			}
+			weakMapEntries(this).delete(key)
+			return values.NewNull(), nil
+		},
+		"count": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return values.NewInt(int64(len(weakMapEntries(this).objects()))), nil
+		},
+		"getIterator": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			entries := weakMapEntries(this)
+			return newInternalIterator(&weakMapIterator{entries: entries, keys: entries.keys()}), nil
+		},
+	}
+	return weakClass("WeakMap", []string{"ArrayAccess", "Countable", "IteratorAggregate", "Traversable"}, methods, nil)
+}
+
+// getInternalIteratorClass returns InternalIterator, the class of the
+// iterators of builtin Traversables such as WeakMap
+func getInternalIteratorClass() *registry.ClassDescriptor {
+	// iterate runs fn on the state of the iterator, if it has any
+	iterate := func(this *values.Value, fn func(it internalIterator) *values.Value) *values.Value {
+		if it, ok := internalIterators.get(this.Data.(*values.Object)); ok {
+			return fn(it)
+		}
+		return values.NewNull()
+	}
+	methods := map[string]weakMethodFunc{
+		"current": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return iterate(this, internalIterator.current), nil
+		},
+		"key": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			return iterate(this, internalIterator.key), nil
+		},
+		"next": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			iterate(this, func(it internalIterator) *values.Value { it.next(); return nil })
+			return values.NewNull(), nil
+		},
+		"valid": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			valid := iterate(this, func(it internalIterator) *values.Value { return values.NewBool(it.valid()) })
+			return values.NewBool(valid.ToBool()), nil
+		},
+		"rewind": func(_ registry.BuiltinCallContext, this *values.Value, _ []*values.Value) (*values.Value, error) {
+			iterate(this, func(it internalIterator) *values.Value { it.rewind(); return nil })
+			return values.NewNull(), nil
+		},
+	}
+	return weakClass("InternalIterator", []string{"Iterator", "Traversable"}, methods, nil)
+}
+
+func weakClass(name string, interfaces []string, methods, staticMethods map[string]weakMethodFunc) *registry.ClassDescriptor {
+	desc := &registry.ClassDescriptor{
+		Name:       name,
+		IsFinal:    true,
+		Interfaces: interfaces,
+		Properties: make(map[string]*registry.PropertyDescriptor),
+		Methods:    make(map[string]*registry.MethodDescriptor),
+		Constants:  make(map[string]*registry.ConstantDescriptor),
+	}
+	for methodName, impl := range methods {
+		desc.Methods[methodName] = weakMethod(name, methodName, impl, false)
+	}
+	for methodName, impl := range staticMethods {
+		desc.Methods[methodName] = weakMethod(name, methodName, impl, true)
+	}
+	return desc
+}
+
+func weakMethod(className, name string, impl weakMethodFunc, static bool) *registry.MethodDescriptor {
+	fn := &registry.Function{
+		Name:       name,
+		IsBuiltin:  true,
+		IsStatic:   static,
+		IsVariadic: true,
+		Visibility: "public",
+		Builtin: func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			if static {
+				return impl(ctx, nil, args)
+			}
+			if len(args) == 0 || args[0] == nil || !args[0].IsObject() {
+				return nil, fmt.Errorf("%s::%s() called on non-object", className, name)
+			}
+			return impl(ctx, args[0], args[1:])
+		},
+	}
+	return &registry.MethodDescriptor{
+		Name:           name,
+		Visibility:     "public",
+		IsStatic:       static,
+		IsVariadic:     true,
+		Parameters:     []*registry.ParameterDescriptor{},
+		Implementation: NewBuiltinMethodImpl(fn),
+	}
+}
+
+// weakMapEntries returns the entries of a WeakMap object, creating them on
+// first use
+func weakMapEntries(this *values.Value) *weakTable[*values.Value] {
+	obj := this.Data.(*values.Object)
+	entries, ok := weakMaps.get(obj)
+	if !ok {
+		entries = newWeakTable[*values.Value]()
+		weakMaps.set(obj, entries)
+	}
+	return entries
+}
+
+// weakMapKey returns the object key passed as the first argument, throwing
+// a TypeError for anything else
+func weakMapKey(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Object, error) {
+	key := weakArg(args, 0)
+	if key.IsReference() {
+		key = key.Deref()
+	}
+	if !key.IsObject() {
+		_, err := throwWeakError(ctx, "TypeError", "WeakMap key must be an object")
+		return nil, err
+	}
+	return key.Data.(*values.Object), nil
+}
+
+func weakArg(args []*values.Value, i int) *values.Value {
+	if i >= len(args) || args[i] == nil {
+		return values.NewNull()
+	}
+	return args[i]
+}
+
+func objectValue(obj *values.Object) *values.Value {
+	return &values.Value{Type: values.TypeObject, Data: obj}
+}
+
+func throwWeakError(ctx registry.BuiltinCallContext, className, format string, args ...interface{}) (*values.Value, error) {
+	message := fmt.Sprintf(format, args...)
+	exception := CreateException(ctx, className, message)
+	if exception == nil {
+		return nil, fmt.Errorf("%s: %s", className, message)
+	}
+	return nil, ctx.ThrowException(exception)
+}
@@ -0,0 +1,589 @@
+package values
+
+import (
+	"fmt"
+	"iter"
+	"math"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// Array is a PHP array: a hash table of int64 and string keys that keeps
+// the order its entries were added in. Lists, whose keys are 0 to n-1 in
+// order, are stored packed in a slice until a write breaks that shape.
+//
+// Copy shares an array's storage with the copy until either side writes to
+// it, so passing arrays by value does not copy their entries. Code that
+// changes an entry's value in place must get it with GetForWrite, which
+// separates the array from its copies first.
+type Array struct {
+	data *arrayData
+}
+
+type arrayData struct {
+	// refs counts the arrays sharing this storage
+	refs atomic.Int32
+
+	// list holds the values of packed arrays, which have no index
+	list []*Value
+
+	// entries holds the entries of hashed arrays in order, with holes left
+	// by deletions until the storage is compacted
+	entries []arrayEntry
+	index   map[interface{}]int
+	holes   int
+
+	nextIndex int64
+}
+
+type arrayEntry struct {
+	key     interface{}
+	value   *Value
+	deleted bool
+}
+
+func newArrayData(capacity int) *arrayData {
+	d := &arrayData{list: make([]*Value, 0, capacity)}
+	d.refs.Store(1)
+	return d
+}
+
+// NewArrayOf creates an empty array with room for capacity entries.
+func NewArrayOf(capacity int) *Array {
+	return &Array{data: newArrayData(capacity)}
+}
+
+// NewList creates a list of vals.
+func NewList(vals ...*Value) *Value {
+	arr := NewArrayOf(len(vals))
+	arr.data.list = append(arr.data.list, vals...)
+	arr.data.nextIndex = int64(len(vals))
+	return &Value{Type: TypeArray, Data: arr}
+}
+
+// NewArrayValue wraps arr in a value.
+func NewArrayValue(arr *Array) *Value {
+	return &Value{Type: TypeArray, Data: arr}
+}
+
+// storage returns the array's storage, creating it for zero arrays
+func (a *Array) storage() *arrayData {
+	if a.data == nil {
+		a.data = newArrayData(0)
+	}
+	return a.data
+}
+
+// NormalizeKey converts a Go or PHP key to the int64 or string an array
+// stores it as. As in PHP, strings holding a decimal integer in canonical
+// form become integers, floats are truncated, booleans become 0 and 1 and
+// null becomes the empty string.
+func NormalizeKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case int64:
+		return k
+	case string:
+		if i, ok := integerKey(k); ok {
+			return i
+		}
+		return k
+	case int:
+		return int64(k)
+	case int32:
+		return int64(k)
+	case int16:
+		return int64(k)
+	case int8:
+		return int64(k)
+	case uint:
+		return int64(k)
+	case uint64:
+		return int64(k)
+	case uint32:
+		return int64(k)
+	case uint16:
+		return int64(k)
+	case uint8:
+		return int64(k)
+	case bool:
+		if k {
+			return int64(1)
+		}
+		return int64(0)
+	case float64:
+		return floatKey(k)
+	case float32:
+		return floatKey(float64(k))
+	case *Value:
+		return ArrayKey(k)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(k)
+	}
+}
+
+// ArrayKey converts a PHP value used as an array key to the int64 or string
+// an array stores it as.
+func ArrayKey(key *Value) interface{} {
+	if key == nil {
+		return ""
+	}
+	key = key.Deref()
+	switch key.Type {
+	case TypeNull:
+		return ""
+	case TypeBool:
+		if key.Data.(bool) {
+			return int64(1)
+		}
+		return int64(0)
+	case TypeInt:
+		return key.Data.(int64)
+	case TypeFloat:
+		return floatKey(key.Data.(float64))
+	case TypeString:
+		s := key.Data.(string)
+		if i, ok := integerKey(s); ok {
+			return i
+		}
+		return s
+	default:
+		return key.ToString()
+	}
+}
+
+// KeyValue returns the PHP value of an array key.
+func KeyValue(key interface{}) *Value {
+	switch k := NormalizeKey(key).(type) {
+	case int64:
+		return NewInt(k)
+	default:
+		return NewString(k.(string))
+	}
+}
+
+// integerKey reports whether s is an integer written as PHP writes it: an
+// optional minus sign and digits without leading zeros, other than "0"
+func integerKey(s string) (int64, bool) {
+	n := len(s)
+	if n == 0 || n > 20 {
+		return 0, false
+	}
+	digits := s
+	if s[0] == '-' {
+		digits = s[1:]
+		if digits == "" || digits == "0" {
+			return 0, false
+		}
+	}
+	if digits[0] == '0' && len(digits) > 1 {
+		return 0, false
+	}
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			return 0, false
+		}
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	return i, err == nil
+}
+
+func floatKey(f float64) int64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return int64(f)
+}
+
+// Len returns the number of entries.
+func (a *Array) Len() int {
+	if a.data == nil {
+		return 0
+	}
+	if a.data.index == nil {
+		return len(a.data.list)
+	}
+	return len(a.data.entries) - a.data.holes
+}
+
+// NextIndex returns the key the next appended value gets: one more than
+// the largest integer key the array has had, and at least 0.
+func (a *Array) NextIndex() int64 {
+	if a.data == nil {
+		return 0
+	}
+	return a.data.nextIndex
+}
+
+// Lookup returns the value stored at key and whether there is one.
+func (a *Array) Lookup(key interface{}) (*Value, bool) {
+	d := a.data
+	if d == nil {
+		return nil, false
+	}
+	key = NormalizeKey(key)
+	if d.index == nil {
+		if i, ok := key.(int64); ok && i >= 0 && i < int64(len(d.list)) {
+			return d.list[i], true
+		}
+		return nil, false
+	}
+	if i, ok := d.index[key]; ok {
+		return d.entries[i].value, true
+	}
+	return nil, false
+}
+
+// Get returns the value stored at key, or nil when there is none.
+func (a *Array) Get(key interface{}) *Value {
+	val, _ := a.Lookup(key)
+	return val
+}
+
+// Has reports whether the array has an entry for key.
+func (a *Array) Has(key interface{}) bool {
+	_, ok := a.Lookup(key)
+	return ok
+}
+
+// GetForWrite returns the value stored at key so that it can be changed in
+// place, separating the array from its copies first.
+func (a *Array) GetForWrite(key interface{}) (*Value, bool) {
+	a.separate()
+	return a.Lookup(key)
+}
+
+// Set stores val at key, keeping the entry's position when the key exists
+// and adding it at the end otherwise.
+func (a *Array) Set(key interface{}, val *Value) {
+	a.separate()
+	d := a.storage()
+	key = NormalizeKey(key)
+	intKey, isInt := key.(int64)
+
+	if d.index == nil {
+		n := int64(len(d.list))
+		switch {
+		case isInt && intKey >= 0 && intKey < n:
+			d.list[intKey] = val
+			return
+		case isInt && intKey == n && d.nextIndex <= n:
+			d.list = append(d.list, val)
+			d.nextIndex = n + 1
+			return
+		}
+		d.hash()
+	}
+
+	if i, ok := d.index[key]; ok {
+		d.entries[i].value = val
+		return
+	}
+	d.index[key] = len(d.entries)
+	d.entries = append(d.entries, arrayEntry{key: key, value: val})
+	if isInt && intKey >= d.nextIndex && intKey < math.MaxInt64 {
+		d.nextIndex = intKey + 1
+	}
+}
+
+// Append adds val at the next integer key and returns the key.
+func (a *Array) Append(val *Value) int64 {
+	key := a.NextIndex()
+	a.Set(key, val)
+	return key
+}
+
+// Delete removes the entry for key and reports whether there was one. The
+// next index is not changed, as in PHP.
+func (a *Array) Delete(key interface{}) bool {
+	if !a.Has(key) {
+		return false
+	}
+	a.separate()
+	d := a.data
+	key = NormalizeKey(key)
+	if d.index == nil {
+		if i := key.(int64); i == int64(len(d.list))-1 {
+			d.list[i] = nil
+			d.list = d.list[:i]
+			return true
+		}
+		d.hash()
+	}
+	i := d.index[key]
+	delete(d.index, key)
+	d.entries[i] = arrayEntry{deleted: true}
+	d.holes++
+	if d.holes > 16 && d.holes > len(d.entries)/2 {
+		d.compact()
+	}
+	return true
+}
+
+// Clear removes all entries and resets the next index.
+func (a *Array) Clear() {
+	if a.data != nil {
+		a.data.refs.Add(-1)
+	}
+	a.data = newArrayData(0)
+}
+
+// Pop removes and returns the last entry. As array_pop() does, it lowers
+// the next index when the entry had the largest integer key.
+func (a *Array) Pop() (interface{}, *Value, bool) {
+	key, val, ok := a.Last()
+	if !ok {
+		return nil, nil, false
+	}
+	a.Delete(key)
+	if i, isInt := key.(int64); isInt && i == a.data.nextIndex-1 {
+		a.data.nextIndex--
+	}
+	return key, val, true
+}
+
+// Shift removes and returns the first entry, then renumbers the integer
+// keys as array_shift() does.
+func (a *Array) Shift() (interface{}, *Value, bool) {
+	key, val, ok := a.First()
+	if !ok {
+		return nil, nil, false
+	}
+	a.Delete(key)
+	a.Renumber()
+	return key, val, true
+}
+
+// Renumber gives the entries with integer keys the keys 0, 1, 2... in
+// order, keeping string keys, and resets the next index to follow them.
+func (a *Array) Renumber() {
+	a.separate()
+	d := a.storage()
+	if d.index == nil {
+		d.nextIndex = int64(len(d.list))
+		return
+	}
+	renumbered := newArrayData(0)
+	var next int64
+	for _, e := range d.entries {
+		if e.deleted {
+			continue
+		}
+		if _, ok := e.key.(int64); ok {
+			e.key = next
+			next++
+		}
+		renumbered.set(e.key, e.value)
+	}
+	renumbered.nextIndex = next
+	a.data = renumbered
+}
+
+// set stores val at key in storage that is not shared
+func (d *arrayData) set(key interface{}, val *Value) {
+	arr := Array{data: d}
+	arr.Set(key, val)
+}
+
+// hash converts packed storage to hashed storage
+func (d *arrayData) hash() {
+	d.index = make(map[interface{}]int, len(d.list)+1)
+	d.entries = make([]arrayEntry, len(d.list), len(d.list)+1)
+	for i, val := range d.list {
+		d.entries[i] = arrayEntry{key: int64(i), value: val}
+		d.index[int64(i)] = i
+	}
+	d.list = nil
+}
+
+// compact drops the holes left by deletions
+func (d *arrayData) compact() {
+	entries := make([]arrayEntry, 0, len(d.entries)-d.holes)
+	for _, e := range d.entries {
+		if !e.deleted {
+			d.index[e.key] = len(entries)
+			entries = append(entries, e)
+		}
+	}
+	d.entries, d.holes = entries, 0
+}
+
+// Copy returns an array with the same entries, sharing the storage until
+// either array is written to.
+func (a *Array) Copy() *Array {
+	d := a.storage()
+	d.refs.Add(1)
+	return &Array{data: d}
+}
+
+// separate gives the array storage of its own before a write, when it
+// shares it with copies
+func (a *Array) separate() {
+	d := a.data
+	if d == nil || d.refs.Load() <= 1 {
+		return
+	}
+	clone := newArrayData(0)
+	clone.nextIndex = d.nextIndex
+	if d.index == nil {
+		clone.list = make([]*Value, len(d.list))
+		for i, val := range d.list {
+			clone.list[i] = copyElement(val)
+		}
+	} else {
+		clone.index = make(map[interface{}]int, len(d.entries)-d.holes)
+		clone.entries = make([]arrayEntry, 0, len(d.entries)-d.holes)
+		for _, e := range d.entries {
+			if !e.deleted {
+				clone.index[e.key] = len(clone.entries)
+				clone.entries = append(clone.entries, arrayEntry{key: e.key, value: copyElement(e.value)})
+			}
+		}
+	}
+	a.data = clone
+	d.refs.Add(-1)
+}
+
+// copyElement copies a value stored in an array being separated, so that
+// changing it in place does not change the copies. References stay shared,
+// as they do when PHP copies an array.
+func copyElement(val *Value) *Value {
+	if val == nil || val.Type == TypeReference {
+		return val
+	}
+	copied := *val
+	if arr, ok := val.Data.(*Array); ok && val.Type == TypeArray {
+		copied.Data = arr.Copy()
+	}
+	return &copied
+}
+
+// All iterates over the entries in order. Changes made to the array while
+// iterating are not seen, as with PHP's foreach by value.
+func (a *Array) All() iter.Seq2[interface{}, *Value] {
+	return func(yield func(interface{}, *Value) bool) {
+		d := a.data
+		if d == nil {
+			return
+		}
+		// Sharing the storage makes writes during the iteration separate
+		d.refs.Add(1)
+		defer d.refs.Add(-1)
+		if d.index == nil {
+			for i, val := range d.list {
+				if !yield(int64(i), val) {
+					return
+				}
+			}
+			return
+		}
+		for _, e := range d.entries {
+			if !e.deleted && !yield(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
+
+// Backward iterates over the entries in reverse order.
+func (a *Array) Backward() iter.Seq2[interface{}, *Value] {
+	return func(yield func(interface{}, *Value) bool) {
+		d := a.data
+		if d == nil {
+			return
+		}
+		d.refs.Add(1)
+		defer d.refs.Add(-1)
+		if d.index == nil {
+			for i := len(d.list) - 1; i >= 0; i-- {
+				if !yield(int64(i), d.list[i]) {
+					return
+				}
+			}
+			return
+		}
+		for i := len(d.entries) - 1; i >= 0; i-- {
+			if e := d.entries[i]; !e.deleted && !yield(e.key, e.value) {
+				return
+			}
+		}
+	}
+}
+
+// Keys returns the keys in order.
+func (a *Array) Keys() []interface{} {
+	keys := make([]interface{}, 0, a.Len())
+	for key := range a.All() {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Values returns the values in order.
+func (a *Array) Values() []*Value {
+	vals := make([]*Value, 0, a.Len())
+	for _, val := range a.All() {
+		vals = append(vals, val)
+	}
+	return vals
+}
+
+// First returns the first entry.
+func (a *Array) First() (interface{}, *Value, bool) {
+	for key, val := range a.All() {
+		return key, val, true
+	}
+	return nil, nil, false
+}
+
+// Last returns the last entry.
+func (a *Array) Last() (interface{}, *Value, bool) {
+	for key, val := range a.Backward() {
+		return key, val, true
+	}
+	return nil, nil, false
+}
+
+// IsList reports whether the keys are 0 to n-1 in order.
+func (a *Array) IsList() bool {
+	d := a.data
+	if d == nil || d.index == nil {
+		return true
+	}
+	var next int64
+	for _, e := range d.entries {
+		if e.deleted {
+			continue
+		}
+		if i, ok := e.key.(int64); !ok || i != next {
+			return false
+		}
+		next++
+	}
+	return true
+}
+
+// Sort reorders the entries, keeping their keys, so that less holds
+// between each entry and the ones before it. The sort is stable.
+func (a *Array) Sort(less func(key1 interface{}, val1 *Value, key2 interface{}, val2 *Value) bool) {
+	keys, vals := a.Keys(), a.Values()
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return less(keys[order[i]], vals[order[i]], keys[order[j]], vals[order[j]])
+	})
+
+	sorted := newArrayData(len(keys))
+	for _, i := range order {
+		sorted.set(keys[i], vals[i])
+	}
+	sorted.nextIndex = a.NextIndex()
+	if a.data != nil {
+		a.data.refs.Add(-1)
+	}
+	a.data = sorted
+}
@@ -0,0 +1,123 @@
+package values
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArrayKeyNormalization(t *testing.T) {
+	tests := []struct {
+		key      interface{}
+		expected interface{}
+	}{
+		{"10", int64(10)},
+		{"-5", int64(-5)},
+		{"01", "01"},
+		{"-0", "-0"},
+		{"1.5", "1.5"},
+		{"", ""},
+		{1.7, int64(1)},
+		{true, int64(1)},
+		{false, int64(0)},
+		{nil, ""},
+		{7, int64(7)},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, NormalizeKey(tt.key), "key %#v", tt.key)
+	}
+}
+
+func TestArrayInsertionOrder(t *testing.T) {
+	arr := NewArrayOf(0)
+	arr.Set("b", NewInt(1))
+	arr.Set(int64(5), NewInt(2))
+	arr.Set("a", NewInt(3))
+	arr.Set("5", NewInt(4))
+	assert.Equal(t, []interface{}{"b", int64(5), "a"}, arr.Keys())
+	assert.Equal(t, int64(4), arr.Get(int64(5)).ToInt())
+
+	// Appending continues after the highest int key, even once it is deleted
+	assert.Equal(t, int64(6), arr.Append(NewInt(5)))
+	arr.Delete(int64(6))
+	assert.Equal(t, int64(7), arr.Append(NewInt(6)))
+	assert.Equal(t, []interface{}{"b", int64(5), "a", int64(7)}, arr.Keys())
+
+	key, val, ok := arr.First()
+	require.True(t, ok)
+	assert.Equal(t, "b", key)
+	assert.Equal(t, int64(1), val.ToInt())
+}
+
+func TestArrayList(t *testing.T) {
+	list := NewList(NewInt(1), NewInt(2), NewInt(3)).Data.(*Array)
+	assert.True(t, list.IsList())
+
+	list.Delete(int64(1))
+	assert.False(t, list.IsList())
+	assert.Equal(t, []interface{}{int64(0), int64(2)}, list.Keys())
+
+	list.Renumber()
+	assert.True(t, list.IsList())
+	assert.Equal(t, int64(2), list.NextIndex())
+
+	_, shifted, ok := list.Shift()
+	require.True(t, ok)
+	assert.Equal(t, int64(1), shifted.ToInt())
+	assert.Equal(t, int64(3), list.Get(int64(0)).ToInt())
+}
+
+func TestArrayCopyOnWrite(t *testing.T) {
+	inner := NewList(NewInt(1))
+	arr := NewArrayOf(0)
+	arr.Set("inner", inner)
+	arr.Set("n", NewInt(1))
+
+	copied := arr.Copy()
+	copied.Set("n", NewInt(2))
+	copied.Get("inner").Data.(*Array).Append(NewInt(2))
+	elem, _ := copied.GetForWrite("inner")
+	elem.Data.(*Array).Append(NewInt(3))
+
+	assert.Equal(t, int64(1), arr.Get("n").ToInt())
+	assert.Equal(t, 1, arr.Get("inner").Data.(*Array).Len())
+	assert.Equal(t, int64(2), copied.Get("n").ToInt())
+
+	// References stay shared between the copies
+	ref := NewReference(NewInt(1))
+	arr.Set("ref", ref)
+	copied = arr.Copy()
+	copied.Set("x", NewNull())
+	ref.Data.(*Reference).Target.Data = int64(9)
+	assert.Equal(t, int64(9), copied.Get("ref").Deref().ToInt())
+}
+
+func TestArrayEquality(t *testing.T) {
+	ab := NewArrayOf(0)
+	ab.Set("a", NewInt(1))
+	ab.Set("b", NewString("2"))
+	ba := NewArrayOf(0)
+	ba.Set("b", NewInt(2))
+	ba.Set("a", NewInt(1))
+	same := ab.Copy()
+
+	// == ignores the order and compares elements loosely, === does neither
+	assert.True(t, NewArrayValue(ab).Equal(NewArrayValue(ba)))
+	assert.False(t, NewArrayValue(ab).Identical(NewArrayValue(ba)))
+	assert.True(t, NewArrayValue(ab).Identical(NewArrayValue(same)))
+
+	ba.Set("b", NewInt(3))
+	assert.False(t, NewArrayValue(ab).Equal(NewArrayValue(ba)))
+}
+
+func TestArraySort(t *testing.T) {
+	arr := NewArrayOf(0)
+	arr.Set("x", NewInt(2))
+	arr.Set("y", NewInt(1))
+	arr.Set("z", NewInt(2))
+	arr.Sort(func(_ interface{}, v1 *Value, _ interface{}, v2 *Value) bool {
+		return v1.ToInt() < v2.ToInt()
+	})
+	assert.Equal(t, []interface{}{"y", "x", "z"}, arr.Keys())
+}
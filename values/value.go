@@ -34,19 +34,13 @@ type Value struct {
 	Data interface{}
 }
 
-// PHP array implementation
-type Array struct {
-	Elements  map[interface{}]*Value // key -> value
-	NextIndex int64                  // for auto-incrementing indices
-	IsIndexed bool                   // optimization hint
-}
-
 // PHP object implementation
 type Object struct {
 	ClassName  string
 	Properties map[string]*Value
 	Methods    map[string]interface{} // function pointers
 	Destructed bool                   // flag to prevent multiple destructor calls
+	EnumCase   bool                   // object is a case of an enum
 }
 
 // Reference wrapper for pass-by-reference
@@ -59,6 +53,8 @@ type Closure struct {
 	Function  interface{}       // Pointer to VM function or compiled function
 	BoundVars map[string]*Value // Variables captured via 'use' clause
 	Name      string            // Optional name for debugging
+	This      *Value            // Object bound as $this, nil when unbound
+	Scope     string            // Class scope for self:: and static::, empty when unscoped
 }
 
 // Goroutine represents a running goroutine
@@ -103,14 +99,7 @@ func NewString(s string) *Value {
 }
 
 func NewArray() *Value {
-	return &Value{
-		Type: TypeArray,
-		Data: &Array{
-			Elements:  make(map[interface{}]*Value),
-			NextIndex: 0,
-			IsIndexed: true,
-		},
-	}
+	return &Value{Type: TypeArray, Data: NewArrayOf(0)}
 }
 
 func NewObject(className string) *Value {
@@ -289,7 +278,7 @@ func (v *Value) ToBool() bool {
 		return s != "" && s != "0"
 	case TypeArray:
 		arr := v.Data.(*Array)
-		return len(arr.Elements) > 0
+		return arr.Len() > 0
 	case TypeObject:
 		return true // Objects are always truthy
 	case TypeResource:
@@ -459,7 +448,7 @@ func (v *Value) ToInt() int64 {
 		return phpStringToInt(v.Data.(string))
 	case TypeArray:
 		arr := v.Data.(*Array)
-		return int64(len(arr.Elements))
+		return int64(arr.Len())
 	case TypeReference:
 		return v.Deref().ToInt()
 	default:
@@ -484,7 +473,7 @@ func (v *Value) ToFloat() float64 {
 		return phpStringToFloat(v.Data.(string))
 	case TypeArray:
 		arr := v.Data.(*Array)
-		return float64(len(arr.Elements))
+		return float64(arr.Len())
 	case TypeReference:
 		return v.Deref().ToFloat()
 	default:
@@ -531,10 +520,7 @@ func (v *Value) ArrayGet(key *Value) *Value {
 		return NewNull()
 	}
 
-	arr := v.Data.(*Array)
-	keyValue := convertArrayKey(key)
-
-	if val, exists := arr.Elements[keyValue]; exists {
+	if val, exists := v.Data.(*Array).Lookup(ArrayKey(key)); exists {
 		return val
 	}
 	return NewNull()
@@ -546,23 +532,11 @@ func (v *Value) ArraySet(key *Value, value *Value) {
 	}
 
 	arr := v.Data.(*Array)
-
 	if key == nil || key.IsNull() {
-		// Auto-increment key
-		arr.Elements[arr.NextIndex] = value
-		arr.NextIndex++
-	} else {
-		keyValue := convertArrayKey(key)
-		arr.Elements[keyValue] = value
-
-		// Update next index for integer keys
-		if key.IsInt() {
-			intKey := key.ToInt()
-			if intKey >= arr.NextIndex {
-				arr.NextIndex = intKey + 1
-			}
-		}
+		arr.Append(value)
+		return
 	}
+	arr.Set(ArrayKey(key), value)
 }
 
 func (v *Value) ArrayUnset(key *Value) {
@@ -570,17 +544,14 @@ func (v *Value) ArrayUnset(key *Value) {
 		return
 	}
 
-	arr := v.Data.(*Array)
-	keyValue := convertArrayKey(key)
-	delete(arr.Elements, keyValue)
+	v.Data.(*Array).Delete(ArrayKey(key))
 }
 
 func (v *Value) ArrayCount() int {
 	if v.Type != TypeArray {
 		return 0
 	}
-	arr := v.Data.(*Array)
-	return len(arr.Elements)
+	return v.Data.(*Array).Len()
 }
 
 // Closure operations
@@ -631,6 +602,11 @@ func (v *Value) Equal(other *Value) bool {
 	v = v.Deref()
 	other = other.Deref()
 
+	// Arrays compare loosely element by element, whatever their order
+	if v.IsArray() && other.IsArray() {
+		return v.arrayEqual(other)
+	}
+
 	// Type coercion rules for ==
 	if v.Type == other.Type {
 		return v.identical(other)
@@ -664,11 +640,6 @@ func (v *Value) Equal(other *Value) bool {
 		return v.ToString() == other.ToString()
 	}
 
-	// Array comparisons
-	if v.IsArray() && other.IsArray() {
-		return v.arrayEqual(other)
-	}
-
 	return false
 }
 
@@ -835,42 +806,16 @@ func (v *Value) Concat(other *Value) *Value {
 
 // Helper functions
 
-func convertArrayKey(key *Value) interface{} {
-	key = key.Deref()
-	switch key.Type {
-	case TypeNull:
-		return ""
-	case TypeBool:
-		if key.Data.(bool) {
-			return int64(1)
-		}
-		return int64(0)
-	case TypeInt:
-		return key.Data.(int64)
-	case TypeFloat:
-		return int64(key.Data.(float64))
-	case TypeString:
-		s := key.Data.(string)
-		// Try to convert to integer if it's a valid integer string
-		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
-			return i
-		}
-		return s
-	default:
-		return key.ToString()
-	}
-}
-
 func (v *Value) arrayEqual(other *Value) bool {
 	arr1 := v.Data.(*Array)
 	arr2 := other.Data.(*Array)
 
-	if len(arr1.Elements) != len(arr2.Elements) {
+	if arr1.Len() != arr2.Len() {
 		return false
 	}
 
-	for key, val1 := range arr1.Elements {
-		val2, exists := arr2.Elements[key]
+	for key, val1 := range arr1.All() {
+		val2, exists := arr2.Lookup(key)
 		if !exists || !val1.Equal(val2) {
 			return false
 		}
@@ -882,38 +827,33 @@ func (v *Value) arrayIdentical(other *Value) bool {
 	arr1 := v.Data.(*Array)
 	arr2 := other.Data.(*Array)
 
-	if len(arr1.Elements) != len(arr2.Elements) {
+	if arr1.Len() != arr2.Len() {
 		return false
 	}
 
-	for key, val1 := range arr1.Elements {
-		val2, exists := arr2.Elements[key]
-		if !exists || !val1.Identical(val2) {
+	// Identical arrays also have their entries in the same order
+	keys2 := arr2.Keys()
+	i := 0
+	for key, val1 := range arr1.All() {
+		val2, exists := arr2.Lookup(key)
+		if !exists || keys2[i] != key || !val1.Identical(val2) {
 			return false
 		}
+		i++
 	}
 	return true
 }
 
 func (v *Value) arrayUnion(other *Value) *Value {
-	result := NewArray()
-	resultArr := result.Data.(*Array)
-
-	// Copy from left operand first
-	arr1 := v.Data.(*Array)
-	for key, val := range arr1.Elements {
-		resultArr.Elements[key] = val
-	}
-
-	// Add from right operand (don't overwrite existing keys)
-	arr2 := other.Data.(*Array)
-	for key, val := range arr2.Elements {
-		if _, exists := resultArr.Elements[key]; !exists {
-			resultArr.Elements[key] = val
+	// The left operand's entries come first, followed by the right
+	// operand's entries for keys the left one does not have
+	resultArr := v.Data.(*Array).Copy()
+	for key, val := range other.Data.(*Array).All() {
+		if !resultArr.Has(key) {
+			resultArr.Set(key, val)
 		}
 	}
-
-	return result
+	return NewArrayValue(resultArr)
 }
 
 // Utility functions
@@ -964,7 +904,7 @@ func (v *Value) String() string {
 		return fmt.Sprintf("string(%q)", v.Data.(string))
 	case TypeArray:
 		arr := v.Data.(*Array)
-		return fmt.Sprintf("array[%d]", len(arr.Elements))
+		return fmt.Sprintf("array[%d]", arr.Len())
 	case TypeObject:
 		obj := v.Data.(*Object)
 		return fmt.Sprintf("object(%s)", obj.ClassName)
@@ -1034,19 +974,10 @@ func (v *Value) appendArrayVarDump(b *strings.Builder, indent int, visited map[*
 	visited[arr] = true
 	defer delete(visited, arr)
 
-	keys := make([]interface{}, 0, len(arr.Elements))
-	for k := range arr.Elements {
-		keys = append(keys, k)
-	}
-	sort.Slice(keys, func(i, j int) bool {
-		return compareArrayKeys(keys[i], keys[j])
-	})
-
-	b.WriteString(fmt.Sprintf("%sarray(%d) {\n", ind, len(arr.Elements)))
-	for _, key := range keys {
+	b.WriteString(fmt.Sprintf("%sarray(%d) {\n", ind, arr.Len()))
+	for key, val := range arr.All() {
 		keyStr := formatArrayKey(key)
 		b.WriteString(fmt.Sprintf("%s  [%s]=>\n", ind, keyStr))
-		val := arr.Elements[key]
 		if val == nil {
 			b.WriteString(strings.Repeat(" ", indent+2) + "NULL\n")
 			continue
@@ -1059,6 +990,10 @@ func (v *Value) appendArrayVarDump(b *strings.Builder, indent int, visited map[*
 func (v *Value) appendObjectVarDump(b *strings.Builder, indent int, visited map[*Array]bool) {
 	obj := v.Data.(*Object)
 	ind := strings.Repeat(" ", indent)
+	if obj.EnumCase {
+		b.WriteString(fmt.Sprintf("%senum(%s::%s)\n", ind, obj.ClassName, obj.Properties["name"].ToString()))
+		return
+	}
 	propKeys := make([]string, 0, len(obj.Properties))
 	for name := range obj.Properties {
 		propKeys = append(propKeys, name)
@@ -1132,19 +1067,8 @@ func (v *Value) appendArrayPrintR(b *strings.Builder, indent int, visited map[*A
 	visited[arr] = true
 	defer delete(visited, arr)
 
-	// Sort keys to ensure consistent output
-	keys := make([]interface{}, 0, len(arr.Elements))
-	for k := range arr.Elements {
-		keys = append(keys, k)
-	}
-	sort.Slice(keys, func(i, j int) bool {
-		return compareArrayKeys(keys[i], keys[j])
-	})
-
 	nextInd := strings.Repeat(" ", (indent+1)*4)
-	for _, key := range keys {
-		val := arr.Elements[key]
-
+	for key, val := range arr.All() {
 		// Format the key
 		keyStr := formatPrintRKey(key)
 		b.WriteString(fmt.Sprintf("%s[%s] => ", nextInd, keyStr))
@@ -1180,7 +1104,14 @@ func (v *Value) appendObjectPrintR(b *strings.Builder, indent int, visited map[*
 		return
 	}
 
-	b.WriteString(fmt.Sprintf("%s Object\n", obj.ClassName))
+	switch {
+	case !obj.EnumCase:
+		b.WriteString(fmt.Sprintf("%s Object\n", obj.ClassName))
+	case obj.Properties["value"] == nil:
+		b.WriteString(fmt.Sprintf("%s Enum\n", obj.ClassName))
+	default:
+		b.WriteString(fmt.Sprintf("%s Enum:%s\n", obj.ClassName, obj.Properties["value"].Type))
+	}
 
 	ind := strings.Repeat(" ", indent*4)
 	b.WriteString(ind + "(\n")
@@ -1305,52 +1236,6 @@ func formatPrintRKey(key interface{}) string {
 	}
 }
 
-func compareArrayKeys(a, b interface{}) bool {
-	k1, kind1 := arrayKeySortValue(a)
-	k2, kind2 := arrayKeySortValue(b)
-	if kind1 != kind2 {
-		return kind1 < kind2
-	}
-	if kind1 == 0 {
-		return k1 < k2
-	}
-	if kind1 == 1 {
-		s1 := a.(string)
-		s2 := b.(string)
-		return s1 < s2
-	}
-	return fmt.Sprint(a) < fmt.Sprint(b)
-}
-
-func arrayKeySortValue(key interface{}) (int64, int) {
-	switch k := key.(type) {
-	case int:
-		return int64(k), 0
-	case int8:
-		return int64(k), 0
-	case int16:
-		return int64(k), 0
-	case int32:
-		return int64(k), 0
-	case int64:
-		return k, 0
-	case uint:
-		return int64(k), 0
-	case uint8:
-		return int64(k), 0
-	case uint16:
-		return int64(k), 0
-	case uint32:
-		return int64(k), 0
-	case uint64:
-		return int64(k), 0
-	case string:
-		return 0, 1
-	default:
-		return 0, 2
-	}
-}
-
 func formatArrayKey(key interface{}) string {
 	switch k := key.(type) {
 	case string:
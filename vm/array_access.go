@@ -0,0 +1,40 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/wudi/hey/values"
+)
+
+// arrayAccessObject returns the object held by value when its class
+// implements ArrayAccess, or nil
+func arrayAccessObject(ctx *ExecutionContext, value *values.Value) *values.Value {
+	if value == nil {
+		return nil
+	}
+	if value.IsReference() {
+		value = value.Deref()
+	}
+	if !value.IsObject() || !instanceOfClass(ctx, value.Data.(*values.Object).ClassName, "ArrayAccess") {
+		return nil
+	}
+	return value
+}
+
+// callMethod calls the method name of object with args. Builtin methods are
+// called directly and user methods in a nested run of the VM.
+func (vm *VirtualMachine) callMethod(ctx *ExecutionContext, frame *CallFrame, object *values.Value, name string, args ...*values.Value) (*values.Value, error) {
+	className := object.Data.(*values.Object).ClassName
+	method := resolveClassMethod(ctx, ctx.ensureClass(className), name)
+	if method == nil {
+		return nil, fmt.Errorf("undefined method %s::%s", className, name)
+	}
+	b := &builtinContext{vm: vm, ctx: ctx, frame: frame}
+	if method.IsBuiltin && method.Builtin != nil {
+		return method.Builtin(b, append([]*values.Value{object}, args...))
+	}
+	callback := values.NewArray()
+	callback.ArraySet(values.NewInt(0), object)
+	callback.ArraySet(values.NewInt(1), values.NewString(name))
+	return b.callValue(callback, args)
+}
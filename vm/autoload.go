@@ -0,0 +1,137 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// classDefined reports whether name resolves to a declared class, interface
+// or trait without triggering autoloading.
+func (ctx *ExecutionContext) classDefined(name string) bool {
+	key := classKey(strings.TrimPrefix(name, "\\"))
+	if key == "" {
+		return false
+	}
+
+	ctx.userSymbolsMu.RLock()
+	_, isClass := ctx.UserClasses[key]
+	_, isInterface := ctx.UserInterfaces[key]
+	_, isTrait := ctx.UserTraits[key]
+	ctx.userSymbolsMu.RUnlock()
+	if isClass || isInterface || isTrait {
+		return true
+	}
+
+	if registry.GlobalRegistry != nil {
+		if _, err := registry.GlobalRegistry.GetClass(key); err == nil {
+			return true
+		}
+		if _, ok := registry.GlobalRegistry.GetInterface(key); ok {
+			return true
+		}
+		if _, ok := registry.GlobalRegistry.GetTrait(key); ok {
+			return true
+		}
+	}
+	return getGlobalClass(key) != nil
+}
+
+// autoloadClass runs the registered autoloaders for name unless the class is
+// already being loaded further up the stack. It reports whether the class is
+// defined afterwards. The first error an autoloader fails with is kept in
+// autoloadErr for the run loop to raise.
+func (ctx *ExecutionContext) autoloadClass(name string) bool {
+	name = strings.TrimPrefix(name, "\\")
+	if name == "" || ctx.classLoader == nil {
+		return false
+	}
+
+	key := classKey(name)
+	ctx.autoloadMu.Lock()
+	if _, busy := ctx.autoloading[key]; busy {
+		ctx.autoloadMu.Unlock()
+		return false
+	}
+	if ctx.autoloading == nil {
+		ctx.autoloading = make(map[string]struct{})
+	}
+	ctx.autoloading[key] = struct{}{}
+	ctx.autoloadMu.Unlock()
+
+	defer func() {
+		ctx.autoloadMu.Lock()
+		delete(ctx.autoloading, key)
+		ctx.autoloadMu.Unlock()
+	}()
+
+	loaded, err := ctx.classLoader(name)
+	if err != nil && ctx.autoloadErr == nil {
+		ctx.autoloadErr = err
+	}
+	return loaded
+}
+
+// takeAutoloadError returns and clears the error of a failed autoloader
+func (ctx *ExecutionContext) takeAutoloadError() error {
+	err := ctx.autoloadErr
+	ctx.autoloadErr = nil
+	return err
+}
+
+// runAutoloaders calls each autoloader of the request in order until one of
+// them defines the requested class. An autoloader that throws stops the chain
+// and its error is returned.
+func (vm *VirtualMachine) runAutoloaders(ctx *ExecutionContext, name string) (bool, error) {
+	if ctx.autoloaders == nil {
+		return false, nil
+	}
+	for _, loader := range ctx.autoloaders.Functions() {
+		if err := vm.callAutoloader(ctx, loader, name); err != nil {
+			return false, err
+		}
+		if ctx.classDefined(name) {
+			return true, nil
+		}
+	}
+	return ctx.classDefined(name), nil
+}
+
+// callAutoloader invokes a single autoloader callable with the class name.
+// An exception escaping the autoloader is returned as an autoloaderException.
+func (vm *VirtualMachine) callAutoloader(ctx *ExecutionContext, loader *values.Value, name string) error {
+	b := &builtinContext{vm: vm, ctx: ctx, frame: ctx.currentFrame()}
+	_, exception, err := b.runCallable(loader, []*values.Value{values.NewString(name)})
+	if err != nil {
+		return err
+	}
+	if exception != nil {
+		return &autoloaderException{exception: exception}
+	}
+	return nil
+}
+
+// autoloaderException carries an exception thrown by an autoloader until the
+// VM raises it in the code that needed the class
+type autoloaderException struct {
+	exception *values.Value
+}
+
+func (e *autoloaderException) Error() string {
+	return fmt.Sprintf("uncaught exception: %s", e.exception.ToString())
+}
+
+// raiseAutoloadError raises the error of a failed autoloader in the current
+// frame. It takes the place of anything the interrupted instruction did about
+// the missing class.
+func (vm *VirtualMachine) raiseAutoloadError(ctx *ExecutionContext) (bool, error) {
+	err := ctx.takeAutoloadError()
+	var thrown *autoloaderException
+	if errors.As(err, &thrown) {
+		return vm.raiseException(ctx, ctx.currentFrame(), thrown.exception)
+	}
+	return false, err
+}
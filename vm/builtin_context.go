@@ -4,11 +4,18 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/wudi/hey/opcodes"
 	"github.com/wudi/hey/registry"
+	runtime2 "github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/runtime/spl"
 	"github.com/wudi/hey/values"
 	heyerrors "github.com/wudi/hey/errors"
 )
 
+// nestedResultSlot is the caller temporary that receives the return value of
+// a user function run from inside a builtin.
+const nestedResultSlot = ^uint32(0)
+
 // builtinContext adapts ExecutionContext operations to the registry's builtin
 // call interface without creating package cycles.
 type builtinContext struct {
@@ -51,6 +58,45 @@ func (b *builtinContext) LookupUserFunction(name string) (*registry.Function, bo
 }
 
 func (b *builtinContext) CallUserFunction(function *registry.Function, args []*values.Value) (*values.Value, error) {
+	return b.callUserFunction(function, nil, "", args)
+}
+
+// coerceArguments applies the parameter types of a user function to the
+// arguments a builtin passes it, throwing a TypeError on mismatch
+func (b *builtinContext) coerceArguments(function *registry.Function, this *values.Value, className string, args []*values.Value) ([]*values.Value, error) {
+	scope := &CallFrame{ClassName: className, This: this}
+	coerced, copied := args, false
+	for i, arg := range args {
+		paramIndex := i
+		if paramIndex >= len(function.Parameters) {
+			if !function.IsVariadic {
+				break
+			}
+			paramIndex = len(function.Parameters) - 1
+		}
+		param := function.Parameters[paramIndex]
+		if param.Type == "" {
+			continue
+		}
+		value, typeErr := b.vm.coerceArgument(b.ctx, scope, function, className, param, i, arg, false)
+		if typeErr != "" {
+			_, err := throwBuiltinError(b, "TypeError", typeErr)
+			return nil, err
+		}
+		if value != arg {
+			if !copied {
+				// Leave the builtin's argument slice untouched
+				coerced, copied = append([]*values.Value(nil), args...), true
+			}
+			coerced[i] = value
+		}
+	}
+	return coerced, nil
+}
+
+// callUserFunction runs a user function to completion in a nested execution
+// loop. When this or className is set the function is invoked as a method.
+func (b *builtinContext) callUserFunction(function *registry.Function, this *values.Value, className string, args []*values.Value) (*values.Value, error) {
 	if b.ctx == nil || b.vm == nil {
 		return nil, fmt.Errorf("no execution context or VM available")
 	}
@@ -63,6 +109,13 @@ func (b *builtinContext) CallUserFunction(function *registry.Function, args []*v
 		return nil, fmt.Errorf("function %s is builtin, not user-defined", function.Name)
 	}
 
+	// Calls made by builtins, such as array_map callbacks, check argument
+	// types in weak mode
+	args, err := b.coerceArguments(function, this, className, args)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create completely isolated execution environment
 	// Save ALL current VM state that might be affected
 	savedStack := make([]*values.Value, len(b.ctx.Stack))
@@ -71,6 +124,18 @@ func (b *builtinContext) CallUserFunction(function *registry.Function, args []*v
 	copy(savedCallStack, b.ctx.CallStack)
 	savedHalted := b.ctx.Halted
 	savedExitCode := b.ctx.ExitCode
+	// Returning from the child writes through the caller's ReturnTarget and
+	// advances its IP; both still belong to the builtin call in progress
+	caller := b.ctx.currentFrame()
+	var savedReturnTarget operandTarget
+	var savedIP int
+	if caller != nil {
+		savedReturnTarget = caller.ReturnTarget
+		savedIP = caller.IP
+		// Collect the child's return value in a scratch temporary
+		caller.ReturnTarget = operandTarget{opType: opcodes.IS_TMP_VAR, slot: nestedResultSlot, valid: true}
+		delete(caller.TempVars, nestedResultSlot)
+	}
 
 	// Reset VM state for isolated execution
 	b.ctx.Stack = nil
@@ -84,7 +149,14 @@ func (b *builtinContext) CallUserFunction(function *registry.Function, args []*v
 	for i, param := range function.Parameters {
 		var arg *values.Value
 
-		if i < len(args) {
+		if function.IsVariadic && i == len(function.Parameters)-1 {
+			// Collect the remaining arguments into the variadic parameter
+			variadicArray := values.NewArray()
+			for argIndex := i; argIndex < len(args); argIndex++ {
+				variadicArray.ArraySet(values.NewInt(int64(argIndex-i)), copyValue(args[argIndex]))
+			}
+			arg = variadicArray
+		} else if i < len(args) {
 			// Normal parameter - copy the value
 			arg = args[i]
 		} else if param.HasDefault && param.DefaultValue != nil {
@@ -101,6 +173,19 @@ func (b *builtinContext) CallUserFunction(function *registry.Function, args []*v
 		child.bindSlotName(uint32(i), param.Name)
 	}
 
+	if this != nil || className != "" {
+		// $this lives in the slot following the parameters, as in execDoFCall
+		child.ClassName = className
+		thisSlot := uint32(len(function.Parameters))
+		child.bindSlotName(thisSlot, "this")
+		if this != nil {
+			child.setLocal(thisSlot, this)
+			child.This = this
+		} else {
+			child.setLocal(thisSlot, values.NewNull())
+		}
+	}
+
 	// Push the frame onto the execution stack
 	b.ctx.pushFrame(child)
 
@@ -146,6 +231,11 @@ func (b *builtinContext) CallUserFunction(function *registry.Function, args []*v
 			b.ctx.CallStack = savedCallStack
 			b.ctx.Halted = savedHalted
 			b.ctx.ExitCode = savedExitCode
+			if caller != nil {
+				caller.ReturnTarget = savedReturnTarget
+				caller.IP = savedIP
+				delete(caller.TempVars, nestedResultSlot)
+			}
 			return nil, err
 		}
 
@@ -180,10 +270,51 @@ func (b *builtinContext) CallUserFunction(function *registry.Function, args []*v
 	b.ctx.CallStack = savedCallStack
 	b.ctx.Halted = savedHalted
 	b.ctx.ExitCode = savedExitCode
+	if caller != nil {
+		if result, ok := caller.TempVars[nestedResultSlot]; ok {
+			userResult = result
+			delete(caller.TempVars, nestedResultSlot)
+		}
+		caller.ReturnTarget = savedReturnTarget
+		caller.IP = savedIP
+	}
 
 	return userResult, nil
 }
 
+// invokeFunction calls fn with args, dispatching to its builtin implementation
+// or running it as a user function. A non-nil this makes it a method call.
+func (b *builtinContext) invokeFunction(fn *registry.Function, this *values.Value, className string, args []*values.Value) (*values.Value, error) {
+	if fn.IsBuiltin {
+		if fn.Builtin == nil {
+			return nil, fmt.Errorf("function %s has no implementation", fn.Name)
+		}
+		if this != nil {
+			args = append([]*values.Value{this}, args...)
+		}
+		return fn.Builtin(b, args)
+	}
+	return b.callUserFunction(fn, this, className, args)
+}
+
+// CallMethod runs method with $this bound to this, or statically when this is
+// nil, in the scope of className.
+func (b *builtinContext) CallMethod(method *registry.Function, this *values.Value, className string, args []*values.Value) (*values.Value, error) {
+	if method == nil {
+		return nil, fmt.Errorf("method is nil")
+	}
+	return b.invokeFunction(method, this, className, args)
+}
+
+// InstantiateClass creates an object of className initialised with its
+// declared property defaults without calling the constructor.
+func (b *builtinContext) InstantiateClass(className string) (*values.Value, error) {
+	if b.ctx == nil {
+		return nil, fmt.Errorf("no execution context available")
+	}
+	return instantiateObject(b.ctx, className)
+}
+
 func (b *builtinContext) LookupUserClass(name string) (*registry.Class, bool) {
 	if b.ctx == nil {
 		return nil, false
@@ -197,6 +328,56 @@ func (b *builtinContext) LookupUserClass(name string) (*registry.Class, bool) {
 	return nil, false
 }
 
+// LookupClassConstant reads a class constant, or an enum case, running the
+// autoloaders if the class is not defined yet.
+func (b *builtinContext) LookupClassConstant(className, name string) (*values.Value, bool) {
+	if b.ctx == nil {
+		return nil, false
+	}
+	cls := b.ctx.ensureClass(strings.TrimPrefix(className, "\\"))
+	if cls == nil || cls.Descriptor == nil {
+		return nil, false
+	}
+	val := lookupClassConstantValue(b.ctx, cls, name)
+	return val, val != nil
+}
+
+// AutoloadClass runs the registered autoloaders for an undefined class and
+// reports whether the class exists afterwards.
+func (b *builtinContext) AutoloadClass(name string) bool {
+	if b.ctx == nil {
+		return false
+	}
+	if b.ctx.classDefined(name) {
+		return true
+	}
+	return b.ctx.autoloadClass(name)
+}
+
+// CloseUnreachableGenerators tears down the suspended generators released by
+// the garbage collector
+func (b *builtinContext) CloseUnreachableGenerators() {
+	if b.ctx != nil && b.vm != nil {
+		b.vm.closeUnreachableGenerators(b.ctx)
+	}
+}
+
+// AutoloadChain returns the autoloaders registered by the running request
+func (b *builtinContext) AutoloadChain() *spl.AutoloadChain {
+	if b.ctx == nil {
+		return nil
+	}
+	return b.ctx.autoloaders
+}
+
+// GoroutineExecutor returns the executor go() runs closures with
+func (b *builtinContext) GoroutineExecutor() runtime2.GoroutineExecutor {
+	if b.vm == nil {
+		return nil
+	}
+	return &GoroutineExecutor{vm: b.vm}
+}
+
 func (b *builtinContext) Halt(exitCode int, message string) error {
 	if b.ctx == nil {
 		return fmt.Errorf("no execution context available")
@@ -0,0 +1,260 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wudi/hey/opcodes"
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// newBoundClosure creates a closure of fn as the CREATE_CLOSURE in frame
+// does: unless fn is static it captures the frame's $this, and it runs in
+// the frame's class scope
+func newBoundClosure(frame *CallFrame, fn *registry.Function, name string) *values.Value {
+	closure := values.NewClosure(fn, nil, name)
+	data := closure.ClosureGet()
+	data.Scope = frame.ClassName
+	if !fn.IsStatic && frame.This != nil && frame.This.IsObject() {
+		data.This = frame.This
+	}
+	return closure
+}
+
+// bindClosureFrame gives the frame running a closure its captured
+// variables and bound $this
+func bindClosureFrame(frame *CallFrame, closure *values.Closure) {
+	fn := frame.Function
+	if fn == nil {
+		return
+	}
+	for name, value := range closure.BoundVars {
+		if slot, ok := fn.VariableSlots["$"+name]; ok {
+			if !value.IsReference() {
+				value = copyValue(value)
+			}
+			frame.setLocal(slot, value)
+			frame.bindSlotName(slot, "$"+name)
+		}
+	}
+	if closure.This != nil {
+		frame.This = closure.This
+		if slot, ok := fn.VariableSlots["$this"]; ok {
+			frame.setLocal(slot, closure.This)
+			frame.bindSlotName(slot, "$this")
+		}
+	}
+}
+
+// callProgram builds code calling callback with args whose result lands in
+// temporary 0, for running callables on a frame of their own
+func callProgram(callback *values.Value, args []*values.Value) ([]*opcodes.Instruction, []*values.Value) {
+	constants := []*values.Value{callback}
+	init := &opcodes.Instruction{Opcode: opcodes.OP_INIT_FCALL}
+	init.OpType1, init.OpType2 = opcodes.EncodeOpTypes(opcodes.IS_CONST, opcodes.IS_UNUSED, opcodes.IS_UNUSED)
+
+	var target, method *values.Value
+	if callback.IsArray() && callback.ArrayCount() == 2 {
+		target, method = callback.ArrayGet(values.NewInt(0)), callback.ArrayGet(values.NewInt(1))
+	} else if className, methodName, ok := strings.Cut(callback.ToString(), "::"); ok && callback.IsString() {
+		target, method = values.NewString(className), values.NewString(methodName)
+	}
+	if target != nil {
+		constants = []*values.Value{target, method}
+		init.Opcode = opcodes.OP_INIT_STATIC_METHOD_CALL
+		if target.IsObject() {
+			init.Opcode = opcodes.OP_INIT_METHOD_CALL
+		}
+		init.Op2 = 1
+		init.OpType1, init.OpType2 = opcodes.EncodeOpTypes(opcodes.IS_CONST, opcodes.IS_CONST, opcodes.IS_UNUSED)
+	}
+
+	instructions := []*opcodes.Instruction{init}
+	for _, arg := range args {
+		send := &opcodes.Instruction{Opcode: opcodes.OP_SEND_VAL, Op2: uint32(len(constants))}
+		send.OpType1, send.OpType2 = opcodes.EncodeOpTypes(opcodes.IS_UNUSED, opcodes.IS_CONST, opcodes.IS_UNUSED)
+		constants = append(constants, arg)
+		instructions = append(instructions, send)
+	}
+	call := &opcodes.Instruction{Opcode: opcodes.OP_DO_FCALL}
+	call.OpType1, call.OpType2 = opcodes.EncodeOpTypes(opcodes.IS_UNUSED, opcodes.IS_UNUSED, opcodes.IS_TMP_VAR)
+	return append(instructions, call), constants
+}
+
+// callValue calls callable with args in a nested run of the VM. Exceptions
+// escaping the call are rethrown in the builtin's caller.
+func (b *builtinContext) callValue(callable *values.Value, args []*values.Value) (*values.Value, error) {
+	result, exception, err := b.runCallable(callable, args)
+	if err != nil {
+		return nil, err
+	}
+	if exception != nil {
+		return nil, b.ThrowException(exception)
+	}
+	return result, nil
+}
+
+// runCallable calls callable with args in a nested run of the VM and returns
+// the exception escaping the call instead of rethrowing it
+func (b *builtinContext) runCallable(callable *values.Value, args []*values.Value) (*values.Value, *values.Value, error) {
+	instructions, constants := callProgram(callable, args)
+	base := newCallFrame("{callback}", nil, instructions, constants)
+	// The callback's arguments are checked under the builtin caller's mode
+	if b.frame != nil {
+		base.StrictTypes = b.frame.StrictTypes
+	}
+	// Any IP past the end stops runUntil; a catch IP must be positive
+	base.pushExceptionHandler(&exceptionHandler{catchIP: len(instructions) + 1})
+	b.ctx.pushFrame(base)
+	err := b.vm.runUntil(b.ctx, base)
+	for {
+		if popped := b.ctx.popFrame(); popped == nil || popped == base {
+			break
+		}
+	}
+	switch {
+	case err != nil:
+		return nil, nil, err
+	case base.pendingException != nil:
+		return nil, base.pendingException, nil
+	case b.ctx.Halted:
+		return values.NewNull(), nil, nil
+	}
+	return base.getTemp(0), nil, nil
+}
+
+func (b *builtinContext) CallClosure(closure *values.Value, args []*values.Value) (*values.Value, error) {
+	return b.callValue(closure, args)
+}
+
+func (b *builtinContext) ClosureFromCallable(callable *values.Value) (*values.Value, error) {
+	if closure, ok := closureFromCallable(b.ctx, callable); ok {
+		return closure, nil
+	}
+	return throwBuiltinError(b, "TypeError", "Closure::fromCallable(): Argument #1 ($callback) must be a valid callback, "+invalidCallbackReason(b.ctx, callable))
+}
+
+// closureFromCallable wraps a callable value in a closure, reporting false
+// when it is not callable
+func closureFromCallable(ctx *ExecutionContext, callable *values.Value) (*values.Value, bool) {
+	if callable.IsReference() {
+		callable = callable.Deref()
+	}
+	switch {
+	case callable.IsCallable():
+		return callable, true
+	case callable.IsString():
+		name := strings.TrimPrefix(callable.ToString(), "\\")
+		if className, methodName, ok := strings.Cut(name, "::"); ok {
+			return staticMethodClosure(ctx, className, methodName)
+		}
+		if fn := lookupFunction(ctx, name); fn != nil {
+			return values.NewClosure(fn, nil, name), true
+		}
+	case callable.IsArray() && callable.ArrayCount() == 2:
+		target, method := callable.ArrayGet(values.NewInt(0)), callable.ArrayGet(values.NewInt(1))
+		if target.IsObject() {
+			return boundMethodClosure(ctx, target, method.ToString())
+		}
+		return staticMethodClosure(ctx, target.ToString(), method.ToString())
+	case callable.IsObject():
+		return boundMethodClosure(ctx, callable, "__invoke")
+	}
+	return nil, false
+}
+
+// lookupFunction finds a user or builtin function by name
+func lookupFunction(ctx *ExecutionContext, name string) *registry.Function {
+	ctx.userSymbolsMu.RLock()
+	fn := ctx.UserFunctions[strings.ToLower(name)]
+	ctx.userSymbolsMu.RUnlock()
+	if fn == nil && registry.GlobalRegistry != nil {
+		fn, _ = registry.GlobalRegistry.GetFunction(name)
+	}
+	return fn
+}
+
+func boundMethodClosure(ctx *ExecutionContext, object *values.Value, methodName string) (*values.Value, bool) {
+	className := object.Data.(*values.Object).ClassName
+	if resolveClassMethod(ctx, ctx.ensureClass(className), methodName) == nil {
+		return nil, false
+	}
+	boundVars := map[string]*values.Value{
+		"object": object,
+		"method": values.NewString(methodName),
+	}
+	return values.NewClosure("bound_method", boundVars, fmt.Sprintf("%s->%s", className, methodName)), true
+}
+
+func staticMethodClosure(ctx *ExecutionContext, className, methodName string) (*values.Value, bool) {
+	cls := ctx.ensureClass(strings.TrimPrefix(className, "\\"))
+	if cls == nil || resolveClassMethod(ctx, cls, methodName) == nil {
+		return nil, false
+	}
+	boundVars := map[string]*values.Value{
+		"class":  values.NewString(cls.Name),
+		"method": values.NewString(methodName),
+	}
+	return values.NewClosure("static_method", boundVars, fmt.Sprintf("%s::%s", cls.Name, methodName)), true
+}
+
+// invalidCallbackReason explains why value is not a valid callback, in the
+// words of PHP's "must be a valid callback" errors
+func invalidCallbackReason(ctx *ExecutionContext, value *values.Value) string {
+	var target *values.Value
+	var method string
+	switch {
+	case value.IsString():
+		name := strings.TrimPrefix(value.ToString(), "\\")
+		className, methodName, ok := strings.Cut(name, "::")
+		if !ok {
+			return fmt.Sprintf("function \"%s\" not found or invalid function name", name)
+		}
+		target, method = values.NewString(className), methodName
+	case value.IsArray():
+		if value.ArrayCount() != 2 {
+			return "array callback must have exactly two members"
+		}
+		target, method = value.ArrayGet(values.NewInt(0)), value.ArrayGet(values.NewInt(1)).ToString()
+	default:
+		return "no array or string given"
+	}
+
+	className := target.ToString()
+	if target.IsObject() {
+		className = target.Data.(*values.Object).ClassName
+	} else if cls := ctx.ensureClass(strings.TrimPrefix(className, "\\")); cls == nil {
+		return fmt.Sprintf("class \"%s\" not found", className)
+	} else {
+		className = cls.Name
+	}
+	return fmt.Sprintf("class %s does not have a method \"%s\"", className, method)
+}
+
+// initClosureMethodCall prepares a method call on a closure value, whose
+// methods are those of the Closure class
+func (vm *VirtualMachine) initClosureMethodCall(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction, closure *values.Value) (bool, error) {
+	opType2, op2 := decodeOperand(inst, 2)
+	methodVal, err := vm.readOperand(ctx, frame, opType2, op2)
+	if err != nil {
+		return false, err
+	}
+	methodName := methodVal.ToString()
+	cls := ctx.ensureClass("Closure")
+	targetFn := resolveClassMethod(ctx, cls, methodName)
+	if cls == nil || targetFn == nil {
+		return vm.throwError(ctx, frame, "Error", fmt.Sprintf("Call to undefined method Closure::%s()", methodName))
+	}
+	frame.pushPendingCall(&PendingCall{
+		Callee:      closure,
+		Function:    targetFn,
+		ClosureName: methodName,
+		Args:        make([]*values.Value, 0),
+		Method:      true,
+		This:        closure,
+		ClassName:   cls.Name,
+		MethodName:  methodName,
+	})
+	return true, nil
+}
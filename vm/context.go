@@ -11,6 +11,7 @@ import (
 
 	"github.com/wudi/hey/opcodes"
 	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/runtime/spl"
 	"github.com/wudi/hey/values"
 )
 
@@ -49,6 +50,35 @@ type ExecutionContext struct {
 	Constants    []*values.Value
 	currentClass *classRuntime
 
+	// Class autoloading: autoloaders is the spl_autoload_register chain of
+	// this request, classLoader runs it, autoloading tracks classes currently
+	// being loaded to stop recursion and autoloadErr holds the error of a
+	// failed autoloader until the run loop raises it. instructionCount
+	// numbers the instructions run, so that the class lookups of one
+	// instruction run the autoloaders at most once.
+	autoloaders      *spl.AutoloadChain
+	classLoader      func(name string) (bool, error)
+	autoloadMu       sync.Mutex
+	autoloading      map[string]struct{}
+	autoloadErr      error
+	instructionCount uint64
+
+	// Property overloading guards: a __get/__set/__isset/__unset call in
+	// progress for an object and property falls through to the real property.
+	propertyGuardMu sync.Mutex
+	propertyGuards  map[propertyGuard]struct{}
+
+	// Fibers: the state behind each Fiber object and the fiber now running
+	fibers       map[*values.Object]*fiber
+	currentFiber *fiber
+
+	// Generators: those with a suspended coroutine, and the count at which
+	// starting another one first tears down the unreachable ones. Coroutines
+	// are numbered in creation order.
+	generators       map[*generatorBody]struct{}
+	generatorSweepAt int
+	coroutineSeq     int
+
 	debugLog []string
 
 	// Error reporting level for @ operator support
@@ -78,6 +108,9 @@ func NewExecutionContext() *ExecutionContext {
 		UserClasses:      make(map[string]*registry.Class),
 		UserInterfaces:   make(map[string]*registry.Interface),
 		UserTraits:       make(map[string]*registry.Trait),
+		fibers:           make(map[*values.Object]*fiber),
+		generators:       make(map[*generatorBody]struct{}),
+		autoloaders:      spl.NewAutoloadChain(),
 		debugLog:         make([]string, 0, 64),
 		ErrorReportingLevel: 1, // Default: show errors (1 = on, 0 = off/silenced)
 		ctx:              ctx,
@@ -121,6 +154,15 @@ type classRuntime struct {
 	StaticProps map[string]*values.Value
 	Constants   map[string]*values.Value
 	Descriptor  *registry.Class
+	// EnumCases and EnumMethods hold the case instances and builtin
+	// methods of enums, see enum.go
+	EnumCases   map[string]*values.Value
+	EnumMethods map[string]*registry.Function
+
+	// autoloadFailed is set on a placeholder for an unknown class when the
+	// autoloaders did not define it during instruction autoloadFailedAt
+	autoloadFailed   bool
+	autoloadFailedAt uint64
 }
 
 type propertyRuntime struct {
@@ -190,6 +232,8 @@ func (ctx *ExecutionContext) popFrame() *CallFrame {
 	}
 	idx := len(ctx.CallStack) - 1
 	frame := ctx.CallStack[idx]
+	// Clear the slot so the popped frame and its values can be collected
+	ctx.CallStack[idx] = nil
 	ctx.CallStack = ctx.CallStack[:idx]
 	return frame
 }
@@ -251,11 +295,22 @@ type CallFrame struct {
 
 	This *values.Value
 
+	// StrictTypes is set when the code running in this frame was compiled
+	// with declare(strict_types=1)
+	StrictTypes bool
+
 	// Generator context for generator functions
 	Generator interface{}
 
 	// Generator state
 	generatorIndex int // Auto-incrementing index for generator keys
+
+	// debugLine is the last source line an attached debugger saw the frame
+	// reach
+	debugLine int
+	// coverageLine is the last source line code coverage recorded for the
+	// frame
+	coverageLine int
 }
 
 // SetGenerator sets the generator reference for this call frame
@@ -287,6 +342,7 @@ type PendingCall struct {
 	MethodName  string
 	IsMagicMethod bool  // Flag to indicate magic method calls (__call, __callStatic)
 	IsNullMethod  bool  // Flag to indicate method calls on null objects (for WordPress compatibility)
+	Closure       *values.Closure // Closure being invoked, whose captured variables the frame receives
 }
 
 // newCallFrame constructs an initialized call frame.
@@ -305,6 +361,7 @@ func newCallFrame(name string, fn *registry.Function, instructions []*opcodes.In
 		Iterators:    make(map[uint32]*foreachIterator),
 		exHandlers:   make([]*exceptionHandler, 0, 4),
 		pendingCalls: make([]*PendingCall, 0, 4),
+		StrictTypes:  fn != nil && fn.StrictTypes,
 	}
 }
 
@@ -420,6 +477,8 @@ func (f *CallFrame) popPendingCall() *PendingCall {
 	}
 	idx := len(f.pendingCalls) - 1
 	call := f.pendingCalls[idx]
+	// Clear the slot so the popped call and its arguments can be collected
+	f.pendingCalls[idx] = nil
 	f.pendingCalls = f.pendingCalls[:idx]
 	return call
 }
@@ -508,8 +567,23 @@ func (ctx *ExecutionContext) ensureClass(name string) *classRuntime {
 	key := classKey(name)
 
 	// Try to load existing class first
+	autoloadFailed := false
 	if val, ok := ctx.ClassTable.Load(key); ok {
-		return val.(*classRuntime)
+		cls := val.(*classRuntime)
+		// A placeholder created before the class was known may now be
+		// resolvable through an autoloader; rebuild it if so. The
+		// autoloaders are not run again within the instruction that
+		// already failed to load the class.
+		if cls.Descriptor != nil || ctx.classDefined(name) || cls.autoloadTried(ctx) {
+			return cls
+		}
+		if !ctx.autoloadClass(name) {
+			cls.autoloadFailed, cls.autoloadFailedAt = true, ctx.instructionCount
+			return cls
+		}
+		ctx.ClassTable.Delete(key)
+	} else if !ctx.classDefined(name) {
+		autoloadFailed = !ctx.autoloadClass(name)
 	}
 
 	// Create new class
@@ -537,10 +611,14 @@ func (ctx *ExecutionContext) ensureClass(name string) *classRuntime {
 	}
 	if cls.Descriptor == nil {
 		if cached := getGlobalClass(name); cached != nil {
-			populateRuntimeFromClassDef(cls, cached)
+			populateRuntimeFromClassDef(cls, cloneClassDefinition(cached))
 		}
 	}
 
+	if autoloadFailed && cls.Descriptor == nil {
+		cls.autoloadFailed, cls.autoloadFailedAt = true, ctx.instructionCount
+	}
+
 	// Use LoadOrStore to handle race conditions
 	if actual, loaded := ctx.ClassTable.LoadOrStore(key, cls); loaded {
 		// Another goroutine created it first, use theirs
@@ -557,6 +635,12 @@ func (ctx *ExecutionContext) ensureClass(name string) *classRuntime {
 	return cls
 }
 
+// autoloadTried reports whether the running instruction already failed to
+// autoload the class of this placeholder
+func (cls *classRuntime) autoloadTried(ctx *ExecutionContext) bool {
+	return cls.autoloadFailed && cls.autoloadFailedAt == ctx.instructionCount
+}
+
 func (ctx *ExecutionContext) getClass(name string) (*classRuntime, bool) {
 	if val, ok := ctx.ClassTable.Load(classKey(name)); ok {
 		return val.(*classRuntime), true
@@ -625,6 +709,9 @@ func populateRuntimeFromClassDef(target *classRuntime, def *registry.Class) {
 			target.Constants[constName] = copyValue(constant.Value)
 		}
 	}
+	if def.IsEnum {
+		populateEnumCases(target)
+	}
 }
 
 func classFromDescriptor(desc *registry.ClassDescriptor) *registry.Class {
@@ -641,6 +728,10 @@ func classFromDescriptor(desc *registry.ClassDescriptor) *registry.Class {
 		Properties: make(map[string]*registry.Property),
 		Methods:    make(map[string]*registry.Function),
 		Constants:  make(map[string]*registry.ClassConstant),
+
+		IsEnum:          desc.IsEnum,
+		EnumBackingType: desc.EnumBackingType,
+		EnumCases:       desc.EnumCases,
 	}
 	if desc.Properties != nil {
 		for name, prop := range desc.Properties {
@@ -669,6 +760,10 @@ func classFromDescriptor(desc *registry.ClassDescriptor) *registry.Class {
 				Name:       method.Name,
 				Parameters: make([]*registry.Parameter, 0, len(method.Parameters)),
 				IsVariadic: method.IsVariadic,
+				IsStatic:   method.IsStatic,
+				IsAbstract: method.IsAbstract,
+				IsFinal:    method.IsFinal,
+				Visibility: method.Visibility,
 			}
 			for _, paramDesc := range method.Parameters {
 				fn.Parameters = append(fn.Parameters, &registry.Parameter{
@@ -717,6 +812,10 @@ func descriptorFromClass(class *registry.Class) *registry.ClassDescriptor {
 		Properties: make(map[string]*registry.PropertyDescriptor),
 		Methods:    make(map[string]*registry.MethodDescriptor),
 		Constants:  make(map[string]*registry.ConstantDescriptor),
+
+		IsEnum:          class.IsEnum,
+		EnumBackingType: class.EnumBackingType,
+		EnumCases:       class.EnumCases,
 	}
 	if class.Properties != nil {
 		for name, prop := range class.Properties {
@@ -770,12 +869,16 @@ func descriptorFromClass(class *registry.Class) *registry.ClassDescriptor {
 				Constants:    method.Constants,
 				Parameters:   paramInfos,
 			}
+			visibility := method.Visibility
+			if visibility == "" {
+				visibility = "public"
+			}
 			desc.Methods[name] = &registry.MethodDescriptor{
 				Name:           method.Name,
-				Visibility:     "public",
-				IsStatic:       false,
-				IsAbstract:     false,
-				IsFinal:        false,
+				Visibility:     visibility,
+				IsStatic:       method.IsStatic,
+				IsAbstract:     method.IsAbstract,
+				IsFinal:        method.IsFinal,
 				IsVariadic:     method.IsVariadic,
 				Parameters:     params,
 				Implementation: impl,
@@ -1053,7 +1156,11 @@ func (ctx *ExecutionContext) GetHTTPContext() registry.HTTPContext {
 }
 
 func (ctx *ExecutionContext) ResetHTTPContext() {
-	ctx.HTTPContext = NewHTTPContext()
+	if ctx.HTTPContext == nil {
+		ctx.HTTPContext = NewHTTPContext()
+		return
+	}
+	ctx.HTTPContext = ctx.HTTPContext.newResponse()
 }
 
 func (ctx *ExecutionContext) RemoveHTTPHeader(name string) {
@@ -1062,7 +1169,7 @@ func (ctx *ExecutionContext) RemoveHTTPHeader(name string) {
 	}
 	nameLower := strings.ToLower(name)
 	headers := ctx.HTTPContext.GetHeaders()
-	ctx.HTTPContext = NewHTTPContext()
+	ctx.HTTPContext = ctx.HTTPContext.newResponse()
 	for _, h := range headers {
 		if strings.ToLower(h.Name) != nameLower {
 			ctx.HTTPContext.AddHeader(h.Name, h.Value, false)
@@ -0,0 +1,170 @@
+package vm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/wudi/hey/values"
+)
+
+// coroutine runs code on a call stack of its own in a separate goroutine.
+// Control is handed back and forth over channels so only one side touches
+// the execution context at a time, which lets the code stop and later carry
+// on from any depth of nested calls, including callbacks run by builtins.
+// Fibers and generators are built on it.
+type coroutine struct {
+	ctx *ExecutionContext
+	// seq orders the coroutines of a request by creation
+	seq int
+
+	// calls and stack hold the call stack and value stack of whichever side
+	// is not running: the coroutine's while suspended, its resumer's otherwise
+	calls []*CallFrame
+	stack []*values.Value
+
+	resume chan coroutineTransfer // resumer to coroutine
+	yield  chan coroutineTransfer // coroutine to resumer
+
+	// alive is set while the goroutine exists, active while it runs and
+	// closing while it is torn down
+	alive   bool
+	active  bool
+	closing bool
+}
+
+// coroutineTransfer is what is handed over on a switch. exception carries
+// an exception thrown into the coroutine at its suspension point, or one
+// that escaped it; done is set when the coroutine has finished and teardown
+// when it is resumed only to be unwound.
+type coroutineTransfer struct {
+	key       *values.Value
+	value     *values.Value
+	exception *values.Value
+	err       error
+	done      bool
+	teardown  bool
+}
+
+func newCoroutine(ctx *ExecutionContext) *coroutine {
+	ctx.coroutineSeq++
+	return &coroutine{
+		ctx:    ctx,
+		seq:    ctx.coroutineSeq,
+		resume: make(chan coroutineTransfer),
+		yield:  make(chan coroutineTransfer),
+	}
+}
+
+// swapStacks exchanges the active stacks with the saved ones
+func (co *coroutine) swapStacks() {
+	co.ctx.frameMu.Lock()
+	defer co.ctx.frameMu.Unlock()
+	co.ctx.CallStack, co.calls = co.calls, co.ctx.CallStack
+	co.ctx.Stack, co.stack = co.stack, co.ctx.Stack
+}
+
+// switchIn runs the coroutine until it suspends or finishes and returns
+// what it handed over. A non-nil start launches the coroutine's goroutine
+// instead of resuming it with in.
+func (co *coroutine) switchIn(in coroutineTransfer, start func()) coroutineTransfer {
+	co.swapStacks()
+	co.active = true
+	if start != nil {
+		co.alive = true
+		go start()
+	} else {
+		co.resume <- in
+	}
+	out := <-co.yield
+	co.active = false
+	if out.done {
+		co.alive = false
+	}
+	co.swapStacks()
+	return out
+}
+
+// teardown unwinds a suspended coroutine and waits for its goroutine to
+// end. The coroutine is resumed with an exception that no catch block
+// matches, so only its finally blocks run.
+func (co *coroutine) teardown() {
+	if !co.alive || co.active {
+		return
+	}
+	co.closing = true
+	for co.alive {
+		co.switchIn(coroutineTransfer{teardown: true}, nil)
+	}
+	co.closing = false
+}
+
+// newTeardownException creates the exception a coroutine is unwound with on
+// teardown. Its class does not exist, so no catch block matches it.
+func newTeardownException() *values.Value {
+	return &values.Value{Type: values.TypeObject, Data: &values.Object{
+		ClassName:  "{coroutine teardown}",
+		Properties: make(map[string]*values.Value),
+	}}
+}
+
+// suspend hands out to the resumer and waits to be resumed
+func (co *coroutine) suspend(out coroutineTransfer) coroutineTransfer {
+	co.yield <- out
+	return <-co.resume
+}
+
+// runCoroutine is the body of a coroutine's goroutine. base starts its call
+// stack, with frames pushed above it. A value returned to base lands in its
+// temporary 0; base's exception handler stops uncaught exceptions from
+// unwinding into the resumer's frames, which rethrows them instead.
+func (vm *VirtualMachine) runCoroutine(co *coroutine, base *CallFrame, frames ...*CallFrame) {
+	out := coroutineTransfer{done: true}
+	defer func() {
+		if r := recover(); r != nil {
+			out = coroutineTransfer{done: true, err: fmt.Errorf("coroutine panicked: %v", r)}
+		}
+		co.yield <- out
+	}()
+
+	// Any IP past the end stops runUntil; a catch IP must be positive
+	base.pushExceptionHandler(&exceptionHandler{catchIP: len(base.Instructions) + 1})
+	co.ctx.pushFrame(base)
+	for _, frame := range frames {
+		co.ctx.pushFrame(frame)
+	}
+
+	if err := vm.runUntil(co.ctx, base); err != nil {
+		out.err = err
+		return
+	}
+	if base.pendingException != nil {
+		out.exception = base.pendingException
+		return
+	}
+	out.value = base.getTemp(0)
+}
+
+// closeCoroutines tears down the coroutines of a request that are still
+// suspended when it ends, in the order they were created. The main script
+// has halted by then, so the halt is lifted while their finally blocks run.
+func (vm *VirtualMachine) closeCoroutines(ctx *ExecutionContext) {
+	type suspended struct {
+		co    *coroutine
+		close func()
+	}
+	var pending []suspended
+	for g := range ctx.generators {
+		pending = append(pending, suspended{g.co, g.close})
+	}
+	for _, f := range ctx.fibers {
+		pending = append(pending, suspended{f.co, f.close})
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].co.seq < pending[j].co.seq })
+
+	halted := ctx.Halted
+	ctx.Halted = false
+	defer func() { ctx.Halted = halted || ctx.Halted }()
+	for _, p := range pending {
+		p.close()
+	}
+}
@@ -0,0 +1,334 @@
+package vm
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/wudi/hey/opcodes"
+	"github.com/wudi/hey/registry"
+)
+
+// Coverage records the lines of each file a script runs and, when asked
+// to, which way each of its conditional jumps goes. Every line of the code
+// the VM loads is known, so lines that never run are reported too. Attach
+// it with VirtualMachine.SetCoverage.
+type Coverage struct {
+	mu       sync.Mutex
+	branches bool
+	running  bool
+
+	files      map[string]*fileCoverage
+	registered map[*opcodes.Instruction]bool
+}
+
+type fileCoverage struct {
+	lines     map[int]int
+	branches  map[*opcodes.Instruction]*BranchCoverage
+	functions []*FunctionCoverage
+}
+
+// FileCoverage is the coverage of one file.
+type FileCoverage struct {
+	File string
+	// Lines maps each executable line to the number of times it ran
+	Lines map[int]int
+	// Branches lists the conditional jumps of the file by line, when
+	// branches are recorded
+	Branches []BranchCoverage
+	// Functions lists the functions and methods of the file by line
+	Functions []FunctionCoverage
+}
+
+// BranchCoverage counts the times the condition of a conditional jump was
+// true and false.
+type BranchCoverage struct {
+	Line  int
+	True  int
+	False int
+}
+
+// FunctionCoverage is a function or method, with the executable lines of
+// its body.
+type FunctionCoverage struct {
+	Name       string
+	Class      string
+	Visibility string
+	Line       int
+	EndLine    int
+	Lines      []int
+}
+
+// loadedCode is the code of a file run by Execute, kept so coverage started
+// later knows about it
+type loadedCode struct {
+	instructions []*opcodes.Instruction
+	functions    map[string]*registry.Function
+	classes      map[string]*registry.Class
+}
+
+// NewCoverage creates a coverage recorder that starts recording at once.
+// branches asks for the conditional jumps to be recorded as well.
+func NewCoverage(branches bool) *Coverage {
+	return &Coverage{
+		branches:   branches,
+		running:    true,
+		files:      make(map[string]*fileCoverage),
+		registered: make(map[*opcodes.Instruction]bool),
+	}
+}
+
+// SetCoverage attaches c to the VM, or detaches it when c is nil. The code
+// already run by the VM is registered with c. The pcov and Xdebug functions
+// record into a coverage of their own, so c sees every line whatever the
+// script does with them.
+func (vm *VirtualMachine) SetCoverage(c *Coverage) {
+	vm.coverage = c
+	vm.registerLoadedCode(c)
+}
+
+// registerLoadedCode registers the code already run by the VM with c
+func (vm *VirtualMachine) registerLoadedCode(c *Coverage) {
+	if c != nil {
+		for _, code := range vm.loadedCode {
+			c.register(code)
+		}
+	}
+}
+
+// Coverage returns the coverage recorder attached to the VM, if any.
+func (vm *VirtualMachine) Coverage() *Coverage {
+	return vm.coverage
+}
+
+// Start resumes recording.
+func (c *Coverage) Start() {
+	c.mu.Lock()
+	c.running = true
+	c.mu.Unlock()
+}
+
+// Stop pauses recording.
+func (c *Coverage) Stop() {
+	c.mu.Lock()
+	c.running = false
+	c.mu.Unlock()
+}
+
+// Running reports whether lines are being recorded.
+func (c *Coverage) Running() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+// Clear resets the counts of the lines and branches run so far. With files
+// set, the files seen so far are forgotten as well.
+func (c *Coverage) Clear(files bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if files {
+		c.files = make(map[string]*fileCoverage)
+		c.registered = make(map[*opcodes.Instruction]bool)
+		return
+	}
+	for _, file := range c.files {
+		for line := range file.lines {
+			file.lines[line] = 0
+		}
+		for _, branch := range file.branches {
+			branch.True, branch.False = 0, 0
+		}
+	}
+}
+
+// Files returns the coverage of each file, sorted by name.
+func (c *Coverage) Files() []FileCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make([]FileCoverage, 0, len(c.files))
+	for name, file := range c.files {
+		fc := FileCoverage{File: name, Lines: make(map[int]int, len(file.lines))}
+		for line, hits := range file.lines {
+			fc.Lines[line] = hits
+		}
+		for _, branch := range file.branches {
+			fc.Branches = append(fc.Branches, *branch)
+		}
+		sort.SliceStable(fc.Branches, func(i, j int) bool { return fc.Branches[i].Line < fc.Branches[j].Line })
+		for _, fn := range file.functions {
+			fc.Functions = append(fc.Functions, *fn)
+		}
+		sort.SliceStable(fc.Functions, func(i, j int) bool { return fc.Functions[i].Line < fc.Functions[j].Line })
+		result = append(result, fc)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].File < result[j].File })
+	return result
+}
+
+// file returns the coverage of name, creating it. c.mu must be held.
+func (c *Coverage) file(name string) *fileCoverage {
+	file, ok := c.files[name]
+	if !ok {
+		file = &fileCoverage{lines: make(map[int]int), branches: make(map[*opcodes.Instruction]*BranchCoverage)}
+		c.files[name] = file
+	}
+	return file
+}
+
+// register marks the lines of code as executable
+func (c *Coverage) register(code loadedCode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.registerInstructions(code.instructions, nil, "")
+	for _, fn := range code.functions {
+		c.registerInstructions(fn.Instructions, fn, "")
+	}
+	for _, class := range code.classes {
+		for _, method := range class.Methods {
+			c.registerInstructions(method.Instructions, method, class.Name)
+		}
+	}
+}
+
+// registerInstructions registers the body of fn, a method of class, or the
+// top-level code of a file when fn is nil. c.mu must be held.
+func (c *Coverage) registerInstructions(instructions []*opcodes.Instruction, fn *registry.Function, class string) {
+	if len(instructions) == 0 || c.registered[instructions[0]] {
+		return
+	}
+	c.registered[instructions[0]] = true
+
+	var function *FunctionCoverage
+	var file *fileCoverage
+	seen := make(map[int]bool)
+	for _, inst := range instructions {
+		if inst.Line <= 0 || inst.Filename == "" || isBookkeepingOpcode(inst.Opcode) {
+			continue
+		}
+		if file == nil {
+			file = c.file(inst.Filename)
+			if fn != nil && !fn.IsBuiltin {
+				function = &FunctionCoverage{Name: fn.Name, Class: class, Visibility: fn.Visibility, Line: inst.Line}
+				if fn.IsAnonymous {
+					function.Name = "{closure}"
+				}
+				file.functions = append(file.functions, function)
+			}
+		}
+		if _, ok := file.lines[inst.Line]; !ok {
+			file.lines[inst.Line] = 0
+		}
+		if c.branches && isConditionalJump(inst.Opcode) {
+			file.branches[inst] = &BranchCoverage{Line: inst.Line}
+		}
+		if function != nil && !seen[inst.Line] {
+			seen[inst.Line] = true
+			function.Lines = append(function.Lines, inst.Line)
+			function.Line = min(function.Line, inst.Line)
+			function.EndLine = max(function.EndLine, inst.Line)
+		}
+	}
+	if function != nil {
+		sort.Ints(function.Lines)
+	}
+}
+
+// recordLine runs before each instruction while coverage is recorded. Like
+// the debugger, it counts a line once each time a frame reaches it.
+func (vm *VirtualMachine) recordLine(frame *CallFrame, inst *opcodes.Instruction) {
+	if inst.Line <= 0 || inst.Line == frame.coverageLine || inst.Filename == "" || isBookkeepingOpcode(inst.Opcode) {
+		return
+	}
+	frame.coverageLine = inst.Line
+	for _, c := range [...]*Coverage{vm.coverage, vm.scriptCoverage} {
+		if c != nil {
+			c.recordLine(inst)
+		}
+	}
+}
+
+// recordBranch counts which way the conditional jump inst went
+func (vm *VirtualMachine) recordBranch(inst *opcodes.Instruction, taken bool) {
+	for _, c := range [...]*Coverage{vm.coverage, vm.scriptCoverage} {
+		if c != nil {
+			c.recordBranch(inst, taken)
+		}
+	}
+}
+
+func (c *Coverage) recordLine(inst *opcodes.Instruction) {
+	c.mu.Lock()
+	if c.running {
+		c.file(inst.Filename).lines[inst.Line]++
+	}
+	c.mu.Unlock()
+}
+
+// recordBranch counts which way the conditional jump inst went. Jumps on
+// zero are taken when their condition is false.
+func (c *Coverage) recordBranch(inst *opcodes.Instruction, taken bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running || !c.branches {
+		return
+	}
+	file := c.file(inst.Filename)
+	branch, ok := file.branches[inst]
+	if !ok {
+		branch = &BranchCoverage{Line: inst.Line}
+		file.branches[inst] = branch
+	}
+	onZero := inst.Opcode == opcodes.OP_JMPZ || inst.Opcode == opcodes.OP_JMPZ_EX
+	if taken != onZero {
+		branch.True++
+	} else {
+		branch.False++
+	}
+}
+
+func isConditionalJump(op opcodes.Opcode) bool {
+	switch op {
+	case opcodes.OP_JMPZ, opcodes.OP_JMPNZ, opcodes.OP_JMPZ_EX, opcodes.OP_JMPNZ_EX:
+		return true
+	}
+	return false
+}
+
+// StartCoverage creates the script's coverage recorder on first use and
+// starts recording.
+func (b *builtinContext) StartCoverage(branches bool) {
+	if b.vm.scriptCoverage == nil {
+		c := NewCoverage(branches)
+		b.vm.registerLoadedCode(c)
+		b.vm.scriptCoverage = c
+		return
+	}
+	b.vm.scriptCoverage.Start()
+}
+
+func (b *builtinContext) StopCoverage() {
+	if b.vm.scriptCoverage != nil {
+		b.vm.scriptCoverage.Stop()
+	}
+}
+
+func (b *builtinContext) CoverageStarted() bool {
+	return b.vm.scriptCoverage != nil && b.vm.scriptCoverage.Running()
+}
+
+func (b *builtinContext) ClearCoverage(files bool) {
+	if b.vm.scriptCoverage != nil {
+		b.vm.scriptCoverage.Clear(files)
+	}
+}
+
+func (b *builtinContext) CollectCoverage() map[string]map[int]int {
+	collected := make(map[string]map[int]int)
+	if b.vm.scriptCoverage == nil {
+		return collected
+	}
+	for _, file := range b.vm.scriptCoverage.Files() {
+		collected[file.File] = file.Lines
+	}
+	return collected
+}
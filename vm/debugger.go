@@ -0,0 +1,513 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/wudi/hey/opcodes"
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// ErrDebuggerTerminated is returned by Execute when the attached debugger
+// ends the script.
+var ErrDebuggerTerminated = errors.New("script terminated by the debugger")
+
+// DebugAction tells a stopped script how to resume.
+type DebugAction int
+
+const (
+	// DebugContinue runs until the next breakpoint
+	DebugContinue DebugAction = iota
+	// DebugStepIn stops at the next line, entering calls
+	DebugStepIn
+	// DebugStepOver stops at the next line of the current function or of
+	// its callers
+	DebugStepOver
+	// DebugStepOut stops once the current function has returned
+	DebugStepOut
+)
+
+// Reasons for which a script stops, as reported in DebugStop.Reason.
+const (
+	StopReasonEntry      = "entry"
+	StopReasonBreakpoint = "breakpoint"
+	StopReasonStep       = "step"
+	StopReasonPause      = "pause"
+)
+
+// Breakpoint stops the script when it reaches a line of a file. A
+// breakpoint with a condition only stops when the PHP expression is true in
+// the scope of the line.
+type Breakpoint struct {
+	File      string
+	Line      int
+	Condition string
+
+	// Hits counts the times the breakpoint stopped the script
+	Hits int
+	// Err reports why the condition could not be compiled; such
+	// breakpoints never stop the script
+	Err error
+
+	condition *registry.Function
+}
+
+// Debugger lets a client such as an editor control a script run by the VM:
+// it stops the script at line breakpoints and steps through it line by
+// line. Line numbers and files come from the instructions' source
+// locations. Attach it with VirtualMachine.SetDebugger before Execute.
+type Debugger struct {
+	// Stopped is called on the script's goroutine each time the script
+	// stops. The script stays stopped, and can be inspected through stop,
+	// until Stopped returns how to resume.
+	Stopped func(stop *DebugStop) DebugAction
+
+	// Compile compiles a PHP expression into a function returning its
+	// value. It is needed for conditional breakpoints and Evaluate.
+	Compile func(expression string) (*registry.Function, error)
+
+	mu          sync.Mutex
+	breakpoints map[string]map[int]*Breakpoint
+	paths       map[string]string
+
+	entry      bool
+	pause      bool
+	terminated bool
+	evaluating bool
+
+	action    DebugAction
+	stepDepth int
+}
+
+// NewDebugger creates a debugger without breakpoints that lets the script
+// run until one is set.
+func NewDebugger() *Debugger {
+	return &Debugger{
+		breakpoints: make(map[string]map[int]*Breakpoint),
+		paths:       make(map[string]string),
+	}
+}
+
+// SetDebugger attaches d to the VM, or detaches the debugger when d is nil.
+func (vm *VirtualMachine) SetDebugger(d *Debugger) {
+	vm.debugger = d
+}
+
+// SetBreakpoints replaces the breakpoints of file. Conditions that fail to
+// compile are reported in the breakpoint's Err.
+func (d *Debugger) SetBreakpoints(file string, breakpoints []*Breakpoint) {
+	lines := make(map[int]*Breakpoint, len(breakpoints))
+	for _, bp := range breakpoints {
+		bp.File, bp.condition, bp.Err = file, nil, nil
+		if strings.TrimSpace(bp.Condition) != "" {
+			if d.Compile == nil {
+				bp.Err = errors.New("conditions are not supported")
+			} else {
+				bp.condition, bp.Err = d.Compile(bp.Condition)
+			}
+		}
+		lines[bp.Line] = bp
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := d.normalizePath(file)
+	if len(lines) == 0 {
+		delete(d.breakpoints, key)
+		return
+	}
+	d.breakpoints[key] = lines
+}
+
+// StopOnEntry makes the script stop before its first line.
+func (d *Debugger) StopOnEntry() {
+	d.mu.Lock()
+	d.entry = true
+	d.mu.Unlock()
+}
+
+// Pause stops the running script at the next line it reaches.
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	d.pause = true
+	d.mu.Unlock()
+}
+
+// Terminate ends the script at the next line it reaches. A stopped script
+// ends as soon as it resumes.
+func (d *Debugger) Terminate() {
+	d.mu.Lock()
+	d.terminated = true
+	d.mu.Unlock()
+}
+
+// normalizePath returns the absolute, cleaned form of path, the key of
+// breakpoints. The caller holds d.mu.
+func (d *Debugger) normalizePath(path string) string {
+	if normalized, ok := d.paths[path]; ok {
+		return normalized
+	}
+	normalized := filepath.Clean(path)
+	if abs, err := filepath.Abs(normalized); err == nil {
+		normalized = abs
+	}
+	d.paths[path] = normalized
+	return normalized
+}
+
+// debugInstruction runs before each instruction while a debugger is
+// attached. The debugger only considers the first instruction of each line
+// a frame reaches, so a line is stopped at once however many instructions
+// it compiles to.
+func (vm *VirtualMachine) debugInstruction(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) error {
+	if inst.Line <= 0 || inst.Line == frame.debugLine || isBookkeepingOpcode(inst.Opcode) {
+		return nil
+	}
+	frame.debugLine = inst.Line
+
+	d := vm.debugger
+	reason, bp := d.stopReason(ctx, inst)
+	if reason == "" && bp != nil && d.conditionHolds(vm, ctx, frame, bp) {
+		reason = StopReasonBreakpoint
+	}
+	if reason == "" {
+		return d.checkTerminated()
+	}
+	if bp != nil {
+		bp.Hits++
+	}
+
+	action := DebugContinue
+	if d.Stopped != nil {
+		action = d.Stopped(&DebugStop{
+			Reason:     reason,
+			File:       inst.Filename,
+			Line:       inst.Line,
+			Breakpoint: bp,
+			vm:         vm,
+			ctx:        ctx,
+			frames:     debugFrames(ctx),
+		})
+	}
+
+	d.mu.Lock()
+	d.action, d.stepDepth = action, len(ctx.CallStack)
+	d.mu.Unlock()
+	return d.checkTerminated()
+}
+
+// stopReason tells why the script stops at inst, if it does. A conditional
+// breakpoint on the line is returned without a reason, since its condition
+// must be evaluated without holding d.mu.
+func (d *Debugger) stopReason(ctx *ExecutionContext, inst *opcodes.Instruction) (string, *Breakpoint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.evaluating || d.terminated {
+		return "", nil
+	}
+
+	var bp *Breakpoint
+	if lines, ok := d.breakpoints[d.normalizePath(inst.Filename)]; ok {
+		if bp = lines[inst.Line]; bp != nil && bp.Err != nil {
+			bp = nil
+		}
+	}
+	switch {
+	case d.entry:
+		d.entry = false
+		return StopReasonEntry, nil
+	case bp != nil && bp.condition == nil:
+		return StopReasonBreakpoint, bp
+	case d.pause:
+		d.pause = false
+		return StopReasonPause, nil
+	}
+
+	depth := len(ctx.CallStack)
+	switch d.action {
+	case DebugStepIn:
+		return StopReasonStep, nil
+	case DebugStepOver:
+		if depth <= d.stepDepth {
+			return StopReasonStep, nil
+		}
+	case DebugStepOut:
+		if depth < d.stepDepth {
+			return StopReasonStep, nil
+		}
+	}
+	return "", bp
+}
+
+// conditionHolds evaluates the condition of bp in frame. Conditions that
+// fail to evaluate do not stop the script.
+func (d *Debugger) conditionHolds(vm *VirtualMachine, ctx *ExecutionContext, frame *CallFrame, bp *Breakpoint) bool {
+	result, err := vm.evaluateInFrame(ctx, frame, bp.condition)
+	return err == nil && result.ToBool()
+}
+
+func (d *Debugger) checkTerminated() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.terminated {
+		return ErrDebuggerTerminated
+	}
+	return nil
+}
+
+// isBookkeepingOpcode reports whether op declares a function or class, or
+// one of a class's members, or releases temporaries. The compiler locates
+// declarations at their opening or closing line, and releases after the
+// statement they end, where the debugger should not stop.
+func isBookkeepingOpcode(op opcodes.Opcode) bool {
+	switch op {
+	case opcodes.OP_DECLARE_FUNCTION, opcodes.OP_DECLARE_CLASS, opcodes.OP_DECLARE_INTERFACE,
+		opcodes.OP_DECLARE_TRAIT, opcodes.OP_DECLARE_ENUM, opcodes.OP_DECLARE_PROPERTY,
+		opcodes.OP_DECLARE_CONSTANT, opcodes.OP_INIT_CLASS_TABLE, opcodes.OP_ADD_INTERFACE,
+		opcodes.OP_SET_CLASS_PARENT, opcodes.OP_SET_CURRENT_CLASS, opcodes.OP_CLEAR_CURRENT_CLASS,
+		opcodes.OP_USE_TRAIT, opcodes.OP_FREE:
+		return true
+	}
+	return false
+}
+
+// debugFrames lists the frames of the script's call stack, innermost
+// first, leaving out the frames the VM runs callbacks on
+func debugFrames(ctx *ExecutionContext) []*CallFrame {
+	ctx.frameMu.Lock()
+	defer ctx.frameMu.Unlock()
+	frames := make([]*CallFrame, 0, len(ctx.CallStack))
+	for i := len(ctx.CallStack) - 1; i >= 0; i-- {
+		frame := ctx.CallStack[i]
+		if frame == nil || (strings.HasPrefix(frame.FunctionName, "{") && frame.FunctionName != "{main}") {
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// DebugStop describes where a script stopped and lets the debugger's client
+// inspect it. Its methods must be called from the Stopped handler, while
+// the script is stopped.
+type DebugStop struct {
+	Reason     string
+	File       string
+	Line       int
+	Breakpoint *Breakpoint
+
+	vm     *VirtualMachine
+	ctx    *ExecutionContext
+	frames []*CallFrame
+}
+
+// DebugFrame is a function call on the stack of a stopped script.
+type DebugFrame struct {
+	Name string
+	File string
+	Line int
+}
+
+// DebugVariable is a named value shown by the debugger: a variable, an
+// array element or an object property.
+type DebugVariable struct {
+	Name  string
+	Value *values.Value
+}
+
+// Frames returns the call stack, innermost call first. Frames are
+// identified by their index in the other methods.
+func (s *DebugStop) Frames() []DebugFrame {
+	frames := make([]DebugFrame, len(s.frames))
+	for i, frame := range s.frames {
+		frames[i] = DebugFrame{Name: frameName(frame)}
+		if frame.IP >= 0 && frame.IP < len(frame.Instructions) {
+			inst := frame.Instructions[frame.IP]
+			frames[i].File, frames[i].Line = inst.Filename, inst.Line
+		}
+	}
+	return frames
+}
+
+// frameName names the function a frame runs: name, Class->name for
+// methods called on an object, Class::name for static ones, or {closure}
+func frameName(frame *CallFrame) string {
+	switch {
+	case frame.Function == nil:
+		return frame.FunctionName
+	case frame.Function.IsAnonymous:
+		return "{closure}"
+	case frame.ClassName != "":
+		separator := "::"
+		if frame.This != nil {
+			separator = "->"
+		}
+		return frame.ClassName + separator + frame.Function.Name
+	}
+	return frame.FunctionName
+}
+
+// Locals returns the variables of a frame sorted by name
+func (s *DebugStop) Locals(frame int) []DebugVariable {
+	if frame < 0 || frame >= len(s.frames) {
+		return nil
+	}
+	f := s.frames[frame]
+	variables := make([]DebugVariable, 0, len(f.SlotNames))
+	for slot, name := range f.SlotNames {
+		// Parameters are bound without their "$"
+		if value, ok := f.Locals[slot]; ok {
+			variables = append(variables, DebugVariable{Name: "$" + sanitizeVariableName(name), Value: value.Deref()})
+		}
+	}
+	sortDebugVariables(variables)
+	return variables
+}
+
+// Globals returns the global variables sorted by name
+func (s *DebugStop) Globals() []DebugVariable {
+	seen := make(map[string]struct{})
+	var variables []DebugVariable
+	s.ctx.GlobalVars.Range(func(key, value interface{}) bool {
+		name := "$" + sanitizeVariableName(key.(string))
+		if _, ok := seen[name]; !ok && name != "$" {
+			seen[name] = struct{}{}
+			variables = append(variables, DebugVariable{Name: name, Value: value.(*values.Value).Deref()})
+		}
+		return true
+	})
+	sortDebugVariables(variables)
+	return variables
+}
+
+// Evaluate computes a PHP expression in the scope of a frame
+func (s *DebugStop) Evaluate(frame int, expression string) (*values.Value, error) {
+	if frame < 0 || frame >= len(s.frames) {
+		return nil, fmt.Errorf("no frame %d", frame)
+	}
+	d := s.vm.debugger
+	if d.Compile == nil {
+		return nil, errors.New("expressions cannot be evaluated")
+	}
+	fn, err := d.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	return s.vm.evaluateInFrame(s.ctx, s.frames[frame], fn)
+}
+
+// DebugChildren returns the elements of an array or the properties of an
+// object, for expanding the value in a debugger
+func DebugChildren(value *values.Value) []DebugVariable {
+	value = value.Deref()
+	switch {
+	case value.IsArray():
+		arr := value.Data.(*values.Array)
+		children := make([]DebugVariable, 0, arr.Len())
+		for key, val := range arr.All() {
+			children = append(children, DebugVariable{Name: fmt.Sprint(key), Value: val.Deref()})
+		}
+		return children
+	case value.IsObject():
+		obj := value.Data.(*values.Object)
+		children := make([]DebugVariable, 0, len(obj.Properties))
+		for name, property := range obj.Properties {
+			children = append(children, DebugVariable{Name: name, Value: property.Deref()})
+		}
+		sortDebugVariables(children)
+		return children
+	}
+	return nil
+}
+
+func sortDebugVariables(variables []DebugVariable) {
+	sort.Slice(variables, func(i, j int) bool { return variables[i].Name < variables[j].Name })
+}
+
+// evaluateInFrame calls fn, a function compiled by Debugger.Compile, with
+// the variables and $this of scope. The debugger does not stop in the
+// code it runs.
+func (vm *VirtualMachine) evaluateInFrame(ctx *ExecutionContext, scope *CallFrame, fn *registry.Function) (*values.Value, error) {
+	d := vm.debugger
+	d.mu.Lock()
+	d.evaluating = true
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.evaluating = false
+		d.mu.Unlock()
+	}()
+
+	frame := newCallFrame(fn.Name, fn, fn.Instructions, fn.Constants)
+	frame.ClassName = scope.ClassName
+	frame.This = scope.This
+	for name, slot := range fn.VariableSlots {
+		if value := scopeVariable(ctx, scope, name); value != nil {
+			frame.setLocal(slot, value)
+			frame.bindSlotName(slot, name)
+		}
+	}
+
+	// The result lands in temporary 0 of base, as with callValue
+	base := newCallFrame("{debug}", nil, nil, nil)
+	base.setReturnTarget(opcodes.IS_TMP_VAR, 0)
+	base.pushExceptionHandler(&exceptionHandler{catchIP: 1})
+	ctx.pushFrame(base)
+	ctx.pushFrame(frame)
+	halted := ctx.Halted
+	err := vm.runUntil(ctx, base)
+	for {
+		if popped := ctx.popFrame(); popped == nil || popped == base {
+			break
+		}
+	}
+	ctx.Halted = halted
+
+	switch {
+	case err != nil:
+		return nil, err
+	case base.pendingException != nil:
+		return nil, fmt.Errorf("uncaught %s", describeException(base.pendingException))
+	}
+	return base.getTemp(0), nil
+}
+
+// scopeVariable finds the variable name, such as "$x", in frame, falling
+// back to the globals for top-level code
+func scopeVariable(ctx *ExecutionContext, frame *CallFrame, name string) *values.Value {
+	if name == "$this" {
+		return frame.This
+	}
+	for _, key := range []string{name, sanitizeVariableName(name)} {
+		if slot, ok := frame.NameSlots[key]; ok {
+			if value, ok := frame.Locals[slot]; ok {
+				return value
+			}
+		}
+	}
+	if frame.Function == nil {
+		for _, variant := range globalNameVariants(name) {
+			if value, ok := ctx.GlobalVars.Load(variant); ok {
+				return value.(*values.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// describeException renders an exception object as "Class: message"
+func describeException(exception *values.Value) string {
+	if !exception.IsObject() {
+		return exception.ToString()
+	}
+	obj := exception.Data.(*values.Object)
+	if message, ok := obj.Properties["message"]; ok && message.ToString() != "" {
+		return obj.ClassName + ": " + message.ToString()
+	}
+	return obj.ClassName
+}
@@ -0,0 +1,208 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	heyerrors "github.com/wudi/hey/errors"
+	"github.com/wudi/hey/registry"
+	runtime2 "github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/values"
+)
+
+// enumMu guards the lazily built case singletons and builtin methods of
+// enum class runtimes
+var enumMu sync.Mutex
+
+// enumCase returns the shared instance of an enum case. The compiler stores
+// each case as a class constant holding a case object; every execution
+// context replaces those with one object per case so that cases compare
+// identical with ===.
+func (cls *classRuntime) enumCase(name string) *values.Value {
+	if cls == nil || cls.Descriptor == nil || !cls.Descriptor.IsEnum {
+		return nil
+	}
+	enumMu.Lock()
+	defer enumMu.Unlock()
+	if cls.EnumCases == nil {
+		cls.EnumCases = make(map[string]*values.Value, len(cls.Descriptor.EnumCases))
+		for _, caseName := range cls.Descriptor.EnumCases {
+			obj := &values.Object{
+				ClassName:  cls.Descriptor.Name,
+				Properties: map[string]*values.Value{"name": values.NewString(caseName)},
+				Methods:    make(map[string]interface{}),
+				EnumCase:   true,
+			}
+			if constant, ok := cls.Descriptor.Constants[caseName]; ok && constant.Value != nil && constant.Value.IsObject() {
+				if value, ok := constant.Value.Data.(*values.Object).Properties["value"]; ok {
+					obj.Properties["value"] = copyValue(value)
+				}
+			}
+			cls.EnumCases[caseName] = &values.Value{Type: values.TypeObject, Data: obj}
+		}
+	}
+	return cls.EnumCases[name]
+}
+
+// populateEnumCases points the case constants of an enum at its case
+// instances
+func populateEnumCases(cls *classRuntime) {
+	for _, caseName := range cls.Descriptor.EnumCases {
+		cls.Constants[caseName] = cls.enumCase(caseName)
+	}
+}
+
+// resolveEnumCase maps a case object read from compiled constants, such as
+// const DEFAULT = self::Active, to the shared case instance.
+func resolveEnumCase(ctx *ExecutionContext, val *values.Value) *values.Value {
+	if val == nil || !val.IsObject() {
+		return val
+	}
+	obj := val.Data.(*values.Object)
+	if !obj.EnumCase {
+		return val
+	}
+	nameVal, ok := obj.Properties["name"]
+	if !ok {
+		return val
+	}
+	if instance := ctx.ensureClass(obj.ClassName).enumCase(nameVal.ToString()); instance != nil {
+		return instance
+	}
+	return val
+}
+
+// enumMethod returns the builtin static methods every enum provides:
+// cases(), and from() and tryFrom() for backed enums
+func enumMethod(cls *classRuntime, method string) *registry.Function {
+	if cls == nil || cls.Descriptor == nil || !cls.Descriptor.IsEnum {
+		return nil
+	}
+	method = strings.ToLower(method)
+	backed := cls.Descriptor.EnumBackingType != ""
+	if method != "cases" && (!backed || method != "from" && method != "tryfrom") {
+		return nil
+	}
+
+	enumMu.Lock()
+	defer enumMu.Unlock()
+	if fn, ok := cls.EnumMethods[method]; ok {
+		return fn
+	}
+	if cls.EnumMethods == nil {
+		cls.EnumMethods = make(map[string]*registry.Function)
+	}
+
+	fn := &registry.Function{
+		IsBuiltin:  true,
+		IsStatic:   true,
+		Visibility: "public",
+	}
+	switch method {
+	case "cases":
+		fn.Name = "cases"
+		fn.ReturnType = "array"
+		fn.Builtin = func(_ registry.BuiltinCallContext, _ []*values.Value) (*values.Value, error) {
+			result := values.NewArray()
+			for _, caseName := range cls.Descriptor.EnumCases {
+				result.ArraySet(nil, cls.enumCase(caseName))
+			}
+			return result, nil
+		}
+	default:
+		fn.Name = map[string]string{"from": "from", "tryfrom": "tryFrom"}[method]
+		fn.Parameters = []*registry.Parameter{{Name: "value", Type: "int|string"}}
+		fn.ReturnType = "static"
+		fn.MinArgs, fn.MaxArgs = 1, 1
+		try := method == "tryfrom"
+		fn.Builtin = func(ctx registry.BuiltinCallContext, args []*values.Value) (*values.Value, error) {
+			return enumFrom(ctx, cls, fn.Name, args, try)
+		}
+	}
+	cls.EnumMethods[method] = fn
+	return fn
+}
+
+// enumFrom implements BackedEnum::from() and BackedEnum::tryFrom()
+func enumFrom(ctx registry.BuiltinCallContext, cls *classRuntime, method string, args []*values.Value, try bool) (*values.Value, error) {
+	className := cls.Descriptor.Name
+	if len(args) == 0 {
+		return throwBuiltinError(ctx, "ArgumentCountError", fmt.Sprintf("%s::%s() expects exactly 1 argument, 0 given", className, method))
+	}
+	arg := args[0].Deref()
+	backingType := cls.Descriptor.EnumBackingType
+
+	var key *values.Value
+	switch {
+	case arg.IsArray() || arg.IsObject() || arg.IsResource():
+		key = nil
+	case backingType == "int" && arg.IsString() && !arg.IsNumericString():
+		key = nil
+	case backingType == "int":
+		key = values.NewInt(arg.ToInt())
+	default:
+		key = values.NewString(arg.ToString())
+	}
+	if key == nil {
+		given := arg.TypeName()
+		if arg.IsObject() {
+			given = arg.Data.(*values.Object).ClassName
+		}
+		return throwBuiltinError(ctx, "TypeError", fmt.Sprintf("%s::%s(): Argument #1 ($value) must be of type %s, %s given", className, method, backingType, given))
+	}
+
+	for _, caseName := range cls.Descriptor.EnumCases {
+		instance := cls.enumCase(caseName)
+		if value := instance.Data.(*values.Object).Properties["value"]; value != nil && value.ToString() == key.ToString() {
+			return instance, nil
+		}
+	}
+	if try {
+		return values.NewNull(), nil
+	}
+	shown := key.ToString()
+	if backingType == "string" {
+		shown = `"` + shown + `"`
+	}
+	return throwBuiltinError(ctx, "ValueError", fmt.Sprintf("%s is not a valid backing value for enum %s", shown, className))
+}
+
+func throwBuiltinError(ctx registry.BuiltinCallContext, className, message string) (*values.Value, error) {
+	exception := runtime2.CreateException(ctx, className, message)
+	if exception == nil {
+		return nil, fmt.Errorf("%s", message)
+	}
+	return nil, ctx.ThrowException(exception)
+}
+
+// throwError raises a catchable Error (or subclass) from an opcode handler
+func (vm *VirtualMachine) throwError(ctx *ExecutionContext, frame *CallFrame, className, message string) (bool, error) {
+	exception := runtime2.CreateException(&builtinContext{vm: vm, ctx: ctx, frame: frame}, className, message)
+	if exception == nil {
+		return false, fmt.Errorf("%s", message)
+	}
+	return vm.raiseException(ctx, frame, exception)
+}
+
+// thrownError reports whether err is an exception raised in a frame that
+// handles it, so that the run loop should carry on from its handler
+func thrownError(ctx *ExecutionContext, err error) (bool, error) {
+	if errors.Is(err, heyerrors.ErrExceptionThrown) {
+		if current := ctx.currentFrame(); current != nil && current.pendingException != nil {
+			return false, nil
+		}
+		return false, errors.New("exception thrown but not set")
+	}
+	return false, err
+}
+
+// enumPropertyWriteError describes why a property of an enum case cannot
+// be written
+func enumPropertyWriteError(obj *values.Object, propName string) string {
+	if _, ok := obj.Properties[propName]; ok {
+		return fmt.Sprintf("Cannot modify readonly property %s::$%s", obj.ClassName, propName)
+	}
+	return fmt.Sprintf("Cannot create dynamic property %s::$%s", obj.ClassName, propName)
+}
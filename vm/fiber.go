@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"fmt"
+
+	runtime2 "github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/values"
+)
+
+// fiber is the VM side of a Fiber object. Its callback runs as a coroutine,
+// so Fiber::suspend() can be called from any depth of nested calls.
+type fiber struct {
+	co       *coroutine
+	object   *values.Value
+	callback *values.Value
+	status   string
+
+	// previous is the fiber that resumed this one, nil for the main script
+	previous *fiber
+
+	result *values.Value
+	threw  bool
+}
+
+// switchToFiber runs f until it suspends or terminates and returns the
+// value it suspended with. start launches the fiber with args instead of
+// resuming it.
+func (b *builtinContext) switchToFiber(f *fiber, in coroutineTransfer, start bool, args []*values.Value) (*values.Value, error) {
+	ctx := f.co.ctx
+	f.previous, ctx.currentFiber = ctx.currentFiber, f
+	f.status = runtime2.FiberStatusRunning
+	var launch func()
+	if start {
+		instructions, constants := callProgram(f.callback, args)
+		base := newCallFrame("{fiber}", nil, instructions, constants)
+		launch = func() { b.vm.runCoroutine(f.co, base) }
+	}
+	out := f.co.switchIn(in, launch)
+	ctx.currentFiber, f.previous = f.previous, nil
+
+	if !out.done {
+		f.status = runtime2.FiberStatusSuspended
+		return out.value, nil
+	}
+	f.status = runtime2.FiberStatusTerminated
+	switch {
+	case out.err != nil:
+		return nil, out.err
+	case out.exception != nil:
+		f.threw = true
+		return nil, b.ThrowException(out.exception)
+	}
+	f.result = out.value
+	return values.NewNull(), nil
+}
+
+// close tears down the fiber if it is suspended, running its finally blocks
+func (f *fiber) close() {
+	if f.status != runtime2.FiberStatusSuspended {
+		return
+	}
+	ctx := f.co.ctx
+	f.previous, ctx.currentFiber = ctx.currentFiber, f
+	f.status = runtime2.FiberStatusRunning
+	f.co.teardown()
+	ctx.currentFiber, f.previous = f.previous, nil
+	f.status = runtime2.FiberStatusTerminated
+}
+
+func (b *builtinContext) fiberOf(object *values.Value) (*fiber, error) {
+	if object != nil && object.IsObject() {
+		if f, ok := b.ctx.fibers[object.Data.(*values.Object)]; ok {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("Fiber has not been constructed")
+}
+
+func (b *builtinContext) NewFiber(object, callback *values.Value) error {
+	if !isCallableValue(b.ctx, callback) {
+		_, err := throwBuiltinError(b, "TypeError", "Fiber::__construct(): Argument #1 ($callback) must be a valid callback, "+invalidCallbackReason(b.ctx, callback))
+		return err
+	}
+	if b.ctx.fibers == nil {
+		b.ctx.fibers = make(map[*values.Object]*fiber)
+	}
+	b.ctx.fibers[object.Data.(*values.Object)] = &fiber{
+		co:       newCoroutine(b.ctx),
+		object:   object,
+		callback: callback,
+		status:   runtime2.FiberStatusInit,
+	}
+	return nil
+}
+
+func (b *builtinContext) StartFiber(object *values.Value, args []*values.Value) (*values.Value, error) {
+	f, err := b.fiberOf(object)
+	if err != nil {
+		return nil, err
+	}
+	if f.status != runtime2.FiberStatusInit {
+		return throwBuiltinError(b, "FiberError", "Cannot start a fiber that has already been started")
+	}
+	return b.switchToFiber(f, coroutineTransfer{}, true, args)
+}
+
+func (b *builtinContext) ResumeFiber(object, value *values.Value, throw bool) (*values.Value, error) {
+	f, err := b.fiberOf(object)
+	if err != nil {
+		return nil, err
+	}
+	if throw && !value.IsObject() {
+		return throwBuiltinError(b, "TypeError", fmt.Sprintf("Fiber::throw(): Argument #1 ($exception) must be of type Throwable, %s given", value.TypeName()))
+	}
+	if f.status != runtime2.FiberStatusSuspended {
+		return throwBuiltinError(b, "FiberError", "Cannot resume a fiber that is not suspended")
+	}
+	in := coroutineTransfer{value: value}
+	if throw {
+		in = coroutineTransfer{exception: value}
+	}
+	return b.switchToFiber(f, in, false, nil)
+}
+
+func (b *builtinContext) SuspendFiber(value *values.Value) (*values.Value, error) {
+	f := b.ctx.currentFiber
+	if f == nil {
+		return throwBuiltinError(b, "FiberError", "Cannot suspend outside of fiber")
+	}
+	if f.co.closing {
+		return throwBuiltinError(b, "FiberError", "Cannot suspend in a force-closed fiber")
+	}
+	in := f.co.suspend(coroutineTransfer{value: value})
+	if in.teardown {
+		return nil, b.ThrowException(newTeardownException())
+	}
+	if in.exception != nil {
+		return nil, b.ThrowException(in.exception)
+	}
+	return in.value, nil
+}
+
+func (b *builtinContext) FiberReturn(object *values.Value) (*values.Value, error) {
+	f, err := b.fiberOf(object)
+	if err != nil {
+		return nil, err
+	}
+	var reason string
+	switch {
+	case f.status == runtime2.FiberStatusInit:
+		reason = "The fiber has not been started"
+	case f.status != runtime2.FiberStatusTerminated:
+		reason = "The fiber has not returned"
+	case f.threw:
+		reason = "The fiber threw an exception"
+	default:
+		return f.result, nil
+	}
+	return throwBuiltinError(b, "FiberError", "Cannot get fiber return value: "+reason)
+}
+
+func (b *builtinContext) FiberStatus(object *values.Value) string {
+	if f, err := b.fiberOf(object); err == nil {
+		return f.status
+	}
+	return runtime2.FiberStatusInit
+}
+
+func (b *builtinContext) CurrentFiber() *values.Value {
+	if f := b.ctx.currentFiber; f != nil {
+		return f.object
+	}
+	return values.NewNull()
+}
@@ -0,0 +1,259 @@
+package vm
+
+import (
+	"errors"
+	"runtime"
+	"weak"
+
+	"github.com/wudi/hey/opcodes"
+	runtime2 "github.com/wudi/hey/runtime"
+	"github.com/wudi/hey/values"
+)
+
+// generatorBody runs the frame of a generator function as a coroutine that
+// suspends at each yield. object points weakly at the Generator object, so
+// that a suspended generator can be torn down once nothing refers to it.
+type generatorBody struct {
+	vm      *VirtualMachine
+	co      *coroutine
+	frame   *CallFrame
+	object  weak.Pointer[values.Object]
+	started bool
+}
+
+// minGeneratorSweep is the number of suspended generators of a request
+// above which starting another one first looks for unreachable ones
+const minGeneratorSweep = 64
+
+// newGenerator wraps frame, a call of a generator function with its
+// arguments bound, in a Generator object
+func (vm *VirtualMachine) newGenerator(ctx *ExecutionContext, frame *CallFrame) *values.Value {
+	body := &generatorBody{vm: vm, co: newCoroutine(ctx), frame: frame}
+	frame.Generator = body
+
+	obj := &values.Object{
+		ClassName:  "Generator",
+		Properties: make(map[string]*values.Value),
+	}
+	obj.Properties["__channel_generator"] = &values.Value{
+		Type: values.TypeResource,
+		Data: runtime2.NewGenerator(body),
+	}
+	// Store function name for debugging/reflection
+	obj.Properties["function"] = values.NewString(frame.FunctionName)
+	body.object = weak.Make(obj)
+	return &values.Value{Type: values.TypeObject, Data: obj}
+}
+
+func (g *generatorBody) Resume(sent, exception *values.Value) runtime2.GeneratorStep {
+	var launch func()
+	if !g.started {
+		g.started = true
+		// The function's return value lands in the base frame's temporary 0
+		base := newCallFrame("{generator}", nil, nil, nil)
+		base.ReturnTarget = operandTarget{opType: opcodes.IS_TMP_VAR, slot: 0, valid: true}
+		launch = func() { g.vm.runCoroutine(g.co, base, g.frame) }
+		g.vm.trackGenerator(g.co.ctx, g)
+	}
+	out := g.co.switchIn(coroutineTransfer{value: sent, exception: exception}, launch)
+	if out.done {
+		delete(g.co.ctx.generators, g)
+	}
+	if !out.done {
+		return runtime2.GeneratorStep{Key: out.key, Value: out.value}
+	}
+	return runtime2.GeneratorStep{Done: true, Return: out.value, Exception: out.exception, Err: out.err}
+}
+
+// yield suspends the generator running frame with the pair key => value and
+// stores what it is resumed with in the yield's result
+func (vm *VirtualMachine) yield(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction, key, value *values.Value) (bool, error) {
+	body, ok := frame.Generator.(*generatorBody)
+	if !ok {
+		return false, errors.New("yield called outside generator context")
+	}
+	if body.co.closing {
+		return vm.throwError(ctx, frame, "Error", "Cannot yield from finally in a force-closed generator")
+	}
+	in := body.co.suspend(coroutineTransfer{key: key, value: value})
+	if in.teardown {
+		return vm.raiseException(ctx, frame, newTeardownException())
+	}
+	if in.exception != nil {
+		return vm.raiseException(ctx, frame, in.exception)
+	}
+	sent := in.value
+	if sent == nil {
+		sent = values.NewNull()
+	}
+	if resultType, resultSlot := decodeResult(inst); resultType != opcodes.IS_UNUSED {
+		if err := vm.writeOperand(ctx, frame, resultType, resultSlot, sent); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// yieldFromGenerator delegates to inner until it finishes, passing sent
+// values and thrown exceptions on, and returns its return value
+func (vm *VirtualMachine) yieldFromGenerator(ctx *ExecutionContext, frame *CallFrame, inner *runtime2.Generator) (*values.Value, error) {
+	body, ok := frame.Generator.(*generatorBody)
+	if !ok {
+		return nil, errors.New("yield from called outside generator context")
+	}
+	b := &builtinContext{vm: vm, ctx: ctx, frame: frame}
+	for {
+		valid, err := inner.Valid(b)
+		if err != nil {
+			return nil, err
+		}
+		if !valid {
+			return inner.GetReturn(b)
+		}
+		key, err := inner.Key(b)
+		if err != nil {
+			return nil, err
+		}
+		value, err := inner.Current(b)
+		if err != nil {
+			return nil, err
+		}
+		if body.co.closing {
+			_, err := throwBuiltinError(b, "Error", "Cannot yield from finally in a force-closed generator")
+			return nil, err
+		}
+		in := body.co.suspend(coroutineTransfer{key: key, value: value})
+		if in.teardown {
+			return nil, b.ThrowException(newTeardownException())
+		}
+		if in.exception != nil {
+			_, err = inner.Throw(b, in.exception)
+		} else {
+			_, err = inner.Send(b, in.value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// yieldFromIterator yields each pair of an Iterator, or of the iterator an
+// IteratorAggregate provides. Values sent in are dropped and exceptions
+// thrown in are raised at the yield from.
+func (vm *VirtualMachine) yieldFromIterator(ctx *ExecutionContext, frame *CallFrame, iterable *values.Value) (bool, error) {
+	var err error
+	for depth := 0; depth < 32 && iterable.IsObject() && instanceOfClass(ctx, iterable.Data.(*values.Object).ClassName, "IteratorAggregate"); depth++ {
+		if iterable, err = vm.callMethod(ctx, frame, iterable, "getIterator"); err != nil {
+			return thrownError(ctx, err)
+		}
+	}
+	if !iterable.IsObject() {
+		return vm.throwError(ctx, frame, "TypeError", "getIterator() must return a Traversable")
+	}
+
+	if _, err := vm.callMethod(ctx, frame, iterable, "rewind"); err != nil {
+		return thrownError(ctx, err)
+	}
+	for {
+		valid, err := vm.callMethod(ctx, frame, iterable, "valid")
+		if err != nil {
+			return thrownError(ctx, err)
+		}
+		if !valid.ToBool() {
+			return true, nil
+		}
+		value, err := vm.callMethod(ctx, frame, iterable, "current")
+		if err != nil {
+			return thrownError(ctx, err)
+		}
+		key, err := vm.callMethod(ctx, frame, iterable, "key")
+		if err != nil {
+			return thrownError(ctx, err)
+		}
+		if advance, err := vm.yield(ctx, frame, &opcodes.Instruction{}, key, value); !advance || err != nil {
+			return advance, err
+		}
+		if _, err := vm.callMethod(ctx, frame, iterable, "next"); err != nil {
+			return thrownError(ctx, err)
+		}
+	}
+}
+
+// trackGenerator records a generator whose coroutine is starting. Past a
+// threshold that grows with the number of live generators, the collector is
+// run first to tear down the generators nothing refers to any more.
+func (vm *VirtualMachine) trackGenerator(ctx *ExecutionContext, g *generatorBody) {
+	if ctx.generators == nil {
+		ctx.generators = make(map[*generatorBody]struct{})
+	}
+	if len(ctx.generators) >= max(ctx.generatorSweepAt, minGeneratorSweep) {
+		runtime.GC()
+		vm.closeUnreachableGenerators(ctx)
+		ctx.generatorSweepAt = 2 * len(ctx.generators)
+	}
+	ctx.generators[g] = struct{}{}
+}
+
+// closeUnreachableGenerators tears down the suspended generators whose
+// Generator object the collector has released, running their finally blocks
+func (vm *VirtualMachine) closeUnreachableGenerators(ctx *ExecutionContext) {
+	for g := range ctx.generators {
+		if g.object.Value() == nil {
+			g.close()
+		}
+	}
+}
+
+// close tears down the generator if it is suspended
+func (g *generatorBody) close() {
+	g.co.teardown()
+	if !g.co.alive {
+		delete(g.co.ctx.generators, g)
+	}
+}
+
+// isSuspendedGenerator reports whether value holds a Generator object while
+// the request has suspended generators, so that dropping it may release one
+func isSuspendedGenerator(ctx *ExecutionContext, value *values.Value) bool {
+	if value == nil || ctx == nil || len(ctx.generators) == 0 {
+		return false
+	}
+	if value.IsReference() {
+		value = value.Deref()
+	}
+	obj, ok := value.Data.(*values.Object)
+	return ok && value.IsObject() && obj.ClassName == "Generator"
+}
+
+// holdsSuspendedGenerator reports whether the locals, temporaries or foreach
+// iterators of frame hold a Generator object that may be suspended
+func holdsSuspendedGenerator(ctx *ExecutionContext, frame *CallFrame) bool {
+	if ctx == nil || len(ctx.generators) == 0 {
+		return false
+	}
+	for _, value := range frame.Locals {
+		if isSuspendedGenerator(ctx, value) {
+			return true
+		}
+	}
+	for _, value := range frame.TempVars {
+		if isSuspendedGenerator(ctx, value) {
+			return true
+		}
+	}
+	for _, it := range frame.Iterators {
+		if it != nil && isSuspendedGenerator(ctx, it.generator) {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseGenerators runs the collector after a variable holding a suspended
+// generator was unset or overwritten, and tears the generator down when that
+// was its last reference. It is also run when a frame holding one returns
+// and when a statement frees a temporary or iterator holding one.
+func (vm *VirtualMachine) releaseGenerators(ctx *ExecutionContext) {
+	runtime.GC()
+	vm.closeUnreachableGenerators(ctx)
+}
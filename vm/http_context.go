@@ -15,6 +15,8 @@ type HTTPContext struct {
 	headersSent    bool
 	headersSentAt  string
 	requestHeaders map[string]string
+	requestBody    []byte
+	uploadedFiles  map[string]bool
 }
 
 
@@ -24,9 +26,23 @@ func NewHTTPContext() *HTTPContext {
 		responseCode:   200,
 		headersSent:    false,
 		requestHeaders: make(map[string]string),
+		uploadedFiles:  make(map[string]bool),
 	}
 }
 
+// newResponse returns an empty response context for the same request, so
+// removing headers does not lose the request body or uploads
+func (h *HTTPContext) newResponse() *HTTPContext {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	fresh := NewHTTPContext()
+	fresh.requestHeaders = h.requestHeaders
+	fresh.requestBody = h.requestBody
+	fresh.uploadedFiles = h.uploadedFiles
+	return fresh
+}
+
 func (h *HTTPContext) AddHeader(name, value string, replace bool) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -129,6 +145,52 @@ func (h *HTTPContext) GetRequestHeaders() map[string]string {
 	return result
 }
 
+// SetRequestBody stores the raw request body read through php://input
+func (h *HTTPContext) SetRequestBody(body []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requestBody = body
+}
+
+func (h *HTTPContext) GetRequestBody() []byte {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.requestBody
+}
+
+// AddUploadedFile records a temporary file created for a POST upload
+func (h *HTTPContext) AddUploadedFile(path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.uploadedFiles[path] = true
+}
+
+func (h *HTTPContext) IsUploadedFile(path string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.uploadedFiles[path]
+}
+
+// RemoveUploadedFile forgets an upload once it has been moved away
+func (h *HTTPContext) RemoveUploadedFile(path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.uploadedFiles, path)
+}
+
+// GetUploadedFiles returns the uploads that are still in the temporary
+// directory
+func (h *HTTPContext) GetUploadedFiles() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	result := make([]string, 0, len(h.uploadedFiles))
+	for path := range h.uploadedFiles {
+		result = append(result, path)
+	}
+	return result
+}
+
 func (h *HTTPContext) FormatHeadersForFastCGI() string {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
@@ -5,9 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/wudi/hey/compiler/lexer"
 	"github.com/wudi/hey/compiler/parser"
@@ -97,6 +96,9 @@ func (vm *VirtualMachine) writeOperand(ctx *ExecutionContext, frame *CallFrame,
 	case opcodes.IS_VAR, opcodes.IS_CV:
 		// Check if the current value is a reference
 		currentVal := frame.getLocal(operand)
+		if isSuspendedGenerator(ctx, currentVal) && currentVal.Deref() != value.Deref() {
+			defer vm.releaseGenerators(ctx)
+		}
 		if currentVal != nil && currentVal.IsReference() && !value.IsReference() {
 			// If writing a non-reference value to a reference variable,
 			// update the target instead of replacing the reference
@@ -148,6 +150,16 @@ func (vm *VirtualMachine) writeOperand(ctx *ExecutionContext, frame *CallFrame,
 	}
 }
 
+// fetchedValue is val as read into a temporary. Arrays are not copied, so
+// the builtins that sort or push onto an array change the variable's own;
+// assigning the temporary copies it.
+func fetchedValue(val *values.Value) *values.Value {
+	if val != nil && val.IsArray() {
+		return val
+	}
+	return copyValue(val)
+}
+
 func copyValue(val *values.Value) *values.Value {
 	if val == nil {
 		return values.NewNull()
@@ -163,6 +175,9 @@ func copyValue(val *values.Value) *values.Value {
 		return values.NewFloat(val.Data.(float64))
 	case values.TypeString:
 		return values.NewString(val.Data.(string))
+	case values.TypeArray:
+		// Arrays are values: the copy shares storage until either is written
+		return values.NewArrayValue(val.Data.(*values.Array).Copy())
 	default:
 		return val
 	}
@@ -181,26 +196,6 @@ func (vm *VirtualMachine) writableOperand(frame *CallFrame, opType opcodes.OpTyp
 	}
 }
 
-// implementsArrayAccess checks if an object implements the ArrayAccess interface
-func implementsArrayAccess(obj *values.Object) bool {
-	if obj == nil || obj.ClassName == "" {
-		return false
-	}
-
-	class, err := registry.GlobalRegistry.GetClass(obj.ClassName)
-	if err != nil || class == nil {
-		return false
-	}
-
-	// Check if the class implements ArrayAccess interface
-	for _, iface := range class.Interfaces {
-		if iface == "ArrayAccess" {
-			return true
-		}
-	}
-	return false
-}
-
 func ensureArrayValue(val *values.Value) *values.Value {
 	if val == nil {
 		return values.NewArray()
@@ -216,6 +211,16 @@ func ensureArrayValue(val *values.Value) *values.Value {
 	return actual
 }
 
+// readDimKey reads the key operand of an array write. It returns nil for
+// $arr[], which has no key operand.
+func (vm *VirtualMachine) readDimKey(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (*values.Value, error) {
+	keyType, keyOp := decodeOperand(inst, 2)
+	if keyType == opcodes.IS_UNUSED {
+		return nil, nil
+	}
+	return vm.readOperand(ctx, frame, keyType, keyOp)
+}
+
 func ensureArrayElement(arr *values.Value, key *values.Value) *values.Value {
 	if arr == nil {
 		arr = values.NewArray()
@@ -225,57 +230,22 @@ func ensureArrayElement(arr *values.Value, key *values.Value) *values.Value {
 	}
 	actual := arr.Data.(*values.Array)
 
-	if key == nil || key.IsNull() {
-		index := actual.NextIndex
-		if elem, exists := actual.Elements[index]; exists {
-			return elem
-		}
+	// A nil key is $arr[]; a null key is the key ""
+	if key == nil {
 		null := values.NewNull()
-		actual.Elements[index] = null
-		actual.NextIndex++
+		actual.Append(null)
 		return null
 	}
 
-	mapKey := normalizeArrayKey(key)
-	if elem, exists := actual.Elements[mapKey]; exists {
+	mapKey := values.ArrayKey(key)
+	if elem, exists := actual.GetForWrite(mapKey); exists {
 		return elem
 	}
 	null := values.NewNull()
-	actual.Elements[mapKey] = null
-	if idx, ok := asInt64(mapKey); ok && idx >= actual.NextIndex {
-		actual.NextIndex = idx + 1
-	}
+	actual.Set(mapKey, null)
 	return null
 }
 
-func normalizeArrayKey(key *values.Value) interface{} {
-	if key == nil {
-		return ""
-	}
-	val := key.Deref()
-	switch val.Type {
-	case values.TypeNull:
-		return ""
-	case values.TypeBool:
-		if val.Data.(bool) {
-			return int64(1)
-		}
-		return int64(0)
-	case values.TypeInt:
-		return val.Data.(int64)
-	case values.TypeFloat:
-		return int64(val.Data.(float64))
-	case values.TypeString:
-		s := val.Data.(string)
-		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
-			return i
-		}
-		return s
-	default:
-		return val.ToString()
-	}
-}
-
 func resolveRuntimeClassName(ctx *ExecutionContext, frame *CallFrame, raw string) (string, error) {
 	if raw == "" {
 		return raw, nil
@@ -306,7 +276,8 @@ func resolveRuntimeClassName(ctx *ExecutionContext, frame *CallFrame, raw string
 		}
 		return cls.Parent, nil
 	default:
-		return raw, nil
+		// Fully qualified names (\Foo\Bar) refer to the same class as Foo\Bar
+		return strings.TrimPrefix(raw, "\\"), nil
 	}
 }
 
@@ -316,18 +287,26 @@ func lookupClassConstantValue(ctx *ExecutionContext, cls *classRuntime, name str
 	}
 	if cls.Constants != nil {
 		if val, ok := cls.Constants[name]; ok {
-			return copyValue(val)
+			return resolveEnumCase(ctx, copyValue(val))
 		}
 	}
 	if cls.Descriptor != nil && cls.Descriptor.Constants != nil {
 		if val, ok := cls.Descriptor.Constants[name]; ok && val.Value != nil {
-			return copyValue(val.Value)
+			return resolveEnumCase(ctx, copyValue(val.Value))
 		}
 	}
 	if cls.Parent != "" {
 		parent := ctx.ensureClass(cls.Parent)
 		return lookupClassConstantValue(ctx, parent, name)
 	}
+	// Builtin interfaces such as DateTimeInterface carry constants too
+	if cls.Descriptor == nil && registry.GlobalRegistry != nil {
+		if iface, ok := registry.GlobalRegistry.GetInterface(cls.Name); ok && iface.Constants != nil {
+			if c, ok := iface.Constants[name]; ok && c.Value != nil {
+				return copyValue(c.Value)
+			}
+		}
+	}
 	return nil
 }
 
@@ -352,6 +331,9 @@ func resolveClassMethod(ctx *ExecutionContext, cls *classRuntime, method string)
 			}
 		}
 	}
+	if fn := enumMethod(cls, method); fn != nil {
+		return fn
+	}
 	if cls.Parent != "" {
 		parent := ctx.ensureClass(cls.Parent)
 		return resolveClassMethod(ctx, parent, method)
@@ -496,7 +478,7 @@ func (vm *VirtualMachine) exceptionMatchesType(exception *values.Value, typeName
 
 	// Check for exact match or inheritance chain
 	if registry.GlobalRegistry != nil {
-		return registry.GlobalRegistry.IsInstanceOf(obj.ClassName, typeName)
+		return registry.GlobalRegistry.IsInstanceOf(obj.ClassName, strings.TrimPrefix(typeName, "\\"))
 	}
 	return false
 }
@@ -516,7 +498,7 @@ func (vm *VirtualMachine) execInstanceof(ctx *ExecutionContext, frame *CallFrame
 		return false, err
 	}
 
-	targetClassName := classVal.ToString()
+	targetClassName := strings.TrimPrefix(classVal.ToString(), "\\")
 	isMatch := false
 
 	// Check if the object is an instance of the specified class
@@ -534,26 +516,28 @@ func (vm *VirtualMachine) execInstanceof(ctx *ExecutionContext, frame *CallFrame
 					isMatch = true
 					break
 				}
+				// Check interfaces implemented at this level of the hierarchy
+				if currentClass.Descriptor != nil {
+					for _, iface := range currentClass.Descriptor.Interfaces {
+						if strings.EqualFold(iface, targetClassName) {
+							isMatch = true
+							break
+						}
+					}
+				}
 				// Check parent class
-				if currentClass.Parent != "" {
+				if !isMatch && currentClass.Parent != "" {
 					currentClass = ctx.ensureClass(currentClass.Parent)
 				} else {
 					break
 				}
 			}
-
-			// Check interfaces implemented by the class
-			if !isMatch && currentClass != nil && currentClass.Descriptor != nil {
-				for _, iface := range currentClass.Descriptor.Interfaces {
-					if strings.EqualFold(iface, targetClassName) {
-						isMatch = true
-						break
-					}
-				}
-			}
 		}
 	}
-	// Non-objects are never instances of classes
+	// Closures are instances of Closure only, and other non-objects of no class
+	if objectVal != nil && objectVal.IsCallable() {
+		isMatch = strings.EqualFold(targetClassName, "Closure")
+	}
 
 	// Store the result
 	resultType, resultSlot := decodeResult(inst)
@@ -634,13 +618,13 @@ func (vm *VirtualMachine) execDeclareProperty(ctx *ExecutionContext, frame *Call
 	defaultValue := values.NewNull()
 	if metadata != nil && metadata.IsArray() {
 		metaArr := metadata.Data.(*values.Array)
-		if v, ok := metaArr.Elements["visibility"]; ok && v != nil {
+		if v, ok := metaArr.Lookup("visibility"); ok && v != nil {
 			visibility = v.ToString()
 		}
-		if v, ok := metaArr.Elements["static"]; ok && v != nil {
+		if v, ok := metaArr.Lookup("static"); ok && v != nil {
 			isStatic = v.ToBool()
 		}
-		if v, ok := metaArr.Elements["defaultValue"]; ok && v != nil {
+		if v, ok := metaArr.Lookup("defaultValue"); ok && v != nil {
 			defaultValue = copyValue(v)
 		}
 	}
@@ -768,15 +752,59 @@ func (vm *VirtualMachine) execAssignObj(ctx *ExecutionContext, frame *CallFrame,
 	}
 	obj := objVal.Data.(*values.Object)
 
+	if obj.EnumCase {
+		return vm.throwError(ctx, frame, "Error", enumPropertyWriteError(obj, propName))
+	}
+
 	// Check readonly property enforcement
 	if err := vm.checkReadonlyProperty(ctx, obj, propName); err != nil {
 		return false, err
 	}
 
+	if handled, err := vm.assignOverloadedProperty(ctx, frame, objVal, propName, value); handled || err != nil {
+		return err == nil, err
+	}
+	// Object properties keep one slot per name, so a hidden ancestor private
+	// cannot be shadowed by a dynamic property and the write fails as well
+	if msg, _ := inaccessibleProperty(ctx, frame, obj, propName); msg != "" {
+		return vm.throwError(ctx, frame, "Error", msg)
+	}
+
+	value, typeErr := vm.checkPropertyType(ctx, frame, obj, propName, value)
+	if typeErr != "" {
+		return vm.throwError(ctx, frame, "TypeError", typeErr)
+	}
+
 	obj.Properties[propName] = copyValue(value)
 	return true, nil
 }
 
+// assignOverloadedProperty routes a write to __set when the property is
+// undeclared and missing, or not accessible from the current scope.
+func (vm *VirtualMachine) assignOverloadedProperty(ctx *ExecutionContext, frame *CallFrame, objVal *values.Value, propName string, value *values.Value) (bool, error) {
+	obj := objVal.Data.(*values.Object)
+	if propertyAccessible(ctx, frame, obj, propName) {
+		if _, exists := obj.Properties[propName]; exists {
+			return false, nil
+		}
+		// Declared but uninitialised properties (e.g. readonly) are written directly
+		if prop, _ := declaredProperty(ctx, obj.ClassName, propName); prop != nil {
+			return false, nil
+		}
+	}
+	_, handled, err := vm.callPropertyMagic(ctx, frame, objVal, "__set", propName, copyValue(value))
+	return handled, err
+}
+
+// fetchOverloadedProperty routes a read to __get when the property is missing
+// or not accessible from the current scope.
+func (vm *VirtualMachine) fetchOverloadedProperty(ctx *ExecutionContext, frame *CallFrame, objVal *values.Value, propName string) (*values.Value, bool, error) {
+	if !needsPropertyMagic(ctx, frame, objVal.Data.(*values.Object), propName) {
+		return nil, false, nil
+	}
+	return vm.callPropertyMagic(ctx, frame, objVal, "__get", propName)
+}
+
 func (vm *VirtualMachine) execAssignObjOp(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
 	// Read object (operand 1)
 	objType, objOp := decodeOperand(inst, 1)
@@ -807,13 +835,23 @@ func (vm *VirtualMachine) execAssignObjOp(ctx *ExecutionContext, frame *CallFram
 	// Get current property value (left operand)
 	obj := objVal.Data.(*values.Object)
 
+	if obj.EnumCase {
+		return vm.throwError(ctx, frame, "Error", enumPropertyWriteError(obj, propName))
+	}
+
 	// Check readonly property enforcement for compound assignment
 	if err := vm.checkReadonlyProperty(ctx, obj, propName); err != nil {
 		return false, err
 	}
 
 	left, exists := obj.Properties[propName]
-	if !exists {
+	if overloaded, handled, err := vm.fetchOverloadedProperty(ctx, frame, objVal, propName); err != nil {
+		return false, err
+	} else if handled {
+		left = overloaded
+	} else if msg, _ := inaccessibleProperty(ctx, frame, obj, propName); msg != "" {
+		return vm.throwError(ctx, frame, "Error", msg)
+	} else if !exists {
 		left = values.NewNull()
 	}
 
@@ -849,6 +887,13 @@ func (vm *VirtualMachine) execAssignObjOp(ctx *ExecutionContext, frame *CallFram
 	}
 
 	// Store the result back to the object property
+	if handled, err := vm.assignOverloadedProperty(ctx, frame, objVal, propName, result); handled || err != nil {
+		return err == nil, err
+	}
+	result, typeErr := vm.checkPropertyType(ctx, frame, obj, propName, result)
+	if typeErr != "" {
+		return vm.throwError(ctx, frame, "TypeError", typeErr)
+	}
 	obj.Properties[propName] = copyValue(result)
 	return true, nil
 }
@@ -870,9 +915,21 @@ func (vm *VirtualMachine) execFetchObj(ctx *ExecutionContext, frame *CallFrame,
 	}
 	propName := propVal.ToString()
 	obj := objVal.Data.(*values.Object)
+	if val, handled, err := vm.fetchOverloadedProperty(ctx, frame, objVal, propName); err != nil {
+		return false, err
+	} else if handled {
+		resType, resSlot := decodeResult(inst)
+		return vm.writeOperand(ctx, frame, resType, resSlot, val) == nil, nil
+	}
+	if msg, hidden := inaccessibleProperty(ctx, frame, obj, propName); hidden {
+		resType, resSlot := decodeResult(inst)
+		return vm.writeOperand(ctx, frame, resType, resSlot, values.NewNull()) == nil, nil
+	} else if msg != "" {
+		return vm.throwError(ctx, frame, "Error", msg)
+	}
 	if val, exists := obj.Properties[propName]; exists {
 		resType, resSlot := decodeResult(inst)
-		return vm.writeOperand(ctx, frame, resType, resSlot, copyValue(val)) == nil, nil
+		return vm.writeOperand(ctx, frame, resType, resSlot, fetchedValue(val)) == nil, nil
 	}
 	if cls, ok := ctx.getClass(obj.ClassName); ok {
 		if prop, ok := cls.Properties[propName]; ok && !prop.IsStatic {
@@ -903,13 +960,17 @@ func (vm *VirtualMachine) execFetchObjIs(ctx *ExecutionContext, frame *CallFrame
 	propName := propVal.ToString()
 	obj := objVal.Data.(*values.Object)
 	exists := false
-	if val, ok := obj.Properties[propName]; ok {
-		exists = !val.Deref().IsNull()
-	} else if cls, ok := ctx.getClass(obj.ClassName); ok {
-		if prop, ok := cls.Properties[propName]; ok && !prop.IsStatic {
-			exists = prop.Default != nil && !prop.Default.Deref().IsNull()
+	if needsPropertyMagic(ctx, frame, obj, propName) {
+		if result, handled, err := vm.callPropertyMagic(ctx, frame, objVal, "__isset", propName); err != nil {
+			return false, err
+		} else if handled {
+			resType, resSlot := decodeResult(inst)
+			return vm.writeOperand(ctx, frame, resType, resSlot, values.NewBool(result.ToBool())) == nil, nil
 		}
 	}
+	if val, ok := obj.Properties[propName]; ok && propertyAccessible(ctx, frame, obj, propName) {
+		exists = !val.Deref().IsNull()
+	}
 	resType, resSlot := decodeResult(inst)
 	if err := vm.writeOperand(ctx, frame, resType, resSlot, values.NewBool(exists)); err != nil {
 		return false, err
@@ -917,6 +978,38 @@ func (vm *VirtualMachine) execFetchObjIs(ctx *ExecutionContext, frame *CallFrame
 	return true, nil
 }
 
+func (vm *VirtualMachine) execFetchObjUnset(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
+	objType, objOp := decodeOperand(inst, 1)
+	objVal, err := vm.readOperand(ctx, frame, objType, objOp)
+	if err != nil {
+		return false, err
+	}
+	objVal = resolveThis(frame, objVal)
+	if objVal == nil || !objVal.IsObject() {
+		// unset() on a property of a non-object is a no-op
+		return true, nil
+	}
+	propType, propOp := decodeOperand(inst, 2)
+	propVal, err := vm.readOperand(ctx, frame, propType, propOp)
+	if err != nil {
+		return false, err
+	}
+	propName := propVal.ToString()
+	obj := objVal.Data.(*values.Object)
+	if needsPropertyMagic(ctx, frame, obj, propName) {
+		if _, handled, err := vm.callPropertyMagic(ctx, frame, objVal, "__unset", propName); handled || err != nil {
+			return err == nil, err
+		}
+	}
+	if msg, hidden := inaccessibleProperty(ctx, frame, obj, propName); hidden {
+		return true, nil
+	} else if msg != "" {
+		return vm.throwError(ctx, frame, "Error", msg)
+	}
+	delete(obj.Properties, propName)
+	return true, nil
+}
+
 func (vm *VirtualMachine) execFetchStaticProp(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
 	classType, classOp := decodeOperand(inst, 1)
 	classVal, err := vm.readOperand(ctx, frame, classType, classOp)
@@ -1076,6 +1169,14 @@ func (vm *VirtualMachine) execFetchClassConstant(ctx *ExecutionContext, frame *C
 		constVal = constVal.Deref()
 	}
 	constName := constVal.ToString()
+	resType, resSlot := decodeResult(inst)
+	if strings.EqualFold(constName, "class") {
+		// Foo::class resolves the name without loading the class
+		if err := vm.writeOperand(ctx, frame, resType, resSlot, values.NewString(className)); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
 	cls := ctx.ensureClass(className)
 	if cls == nil {
 		return false, fmt.Errorf("class %s not found", className)
@@ -1084,7 +1185,6 @@ func (vm *VirtualMachine) execFetchClassConstant(ctx *ExecutionContext, frame *C
 	if result == nil {
 		return false, fmt.Errorf("undefined class constant %s::%s", className, constName)
 	}
-	resType, resSlot := decodeResult(inst)
 	if err := vm.writeOperand(ctx, frame, resType, resSlot, result); err != nil {
 		return false, err
 	}
@@ -1103,10 +1203,11 @@ func (vm *VirtualMachine) execFetchConstant(ctx *ExecutionContext, frame *CallFr
 	}
 	constName := constVal.ToString()
 
-	// Look up the constant in the registry
+	// Look up the constant in the registry, where fully qualified names are
+	// kept without their leading backslash
 	var result *values.Value
 	if registry.GlobalRegistry != nil {
-		if constDesc, exists := registry.GlobalRegistry.GetConstant(constName); exists {
+		if constDesc, exists := registry.GlobalRegistry.GetConstant(strings.TrimPrefix(constName, "\\")); exists {
 			result = constDesc.Value
 		}
 	}
@@ -1172,6 +1273,14 @@ func (vm *VirtualMachine) execFetchLateStaticConstant(ctx *ExecutionContext, fra
 	}
 	constName := constVal.ToString()
 
+	if strings.EqualFold(constName, "class") {
+		resType, resSlot := decodeResult(inst)
+		if err := vm.writeOperand(ctx, frame, resType, resSlot, values.NewString(className)); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
 	cls := ctx.ensureClass(className)
 	if cls == nil {
 		return false, fmt.Errorf("class %s not found", className)
@@ -1189,50 +1298,6 @@ func (vm *VirtualMachine) execFetchLateStaticConstant(ctx *ExecutionContext, fra
 	return true, nil
 }
 
-func makeKeyValue(key interface{}) *values.Value {
-	switch k := key.(type) {
-	case string:
-		return values.NewString(k)
-	case int:
-		return values.NewInt(int64(k))
-	case int64:
-		return values.NewInt(k)
-	case uint32:
-		return values.NewInt(int64(k))
-	case uint64:
-		return values.NewInt(int64(k))
-	default:
-		return values.NewString(fmt.Sprintf("%v", k))
-	}
-}
-
-func asInt64(key interface{}) (int64, bool) {
-	switch k := key.(type) {
-	case int:
-		return int64(k), true
-	case int8:
-		return int64(k), true
-	case int16:
-		return int64(k), true
-	case int32:
-		return int64(k), true
-	case int64:
-		return k, true
-	case uint:
-		return int64(k), true
-	case uint8:
-		return int64(k), true
-	case uint16:
-		return int64(k), true
-	case uint32:
-		return int64(k), true
-	case uint64:
-		return int64(k), true
-	default:
-		return 0, false
-	}
-}
-
 func (vm *VirtualMachine) execAssign(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction, isVarAssign bool) (bool, error) {
 	opType1, op1 := decodeOperand(inst, 1)
 	value, err := vm.readOperand(ctx, frame, opType1, op1)
@@ -1390,24 +1455,12 @@ func (vm *VirtualMachine) execAssignDimRef(ctx *ExecutionContext, frame *CallFra
 
 	// Set array element as reference
 	arrayData := arrayVal.Data.(*values.Array)
-	if indexVal.IsNull() {
+	if opType2 == opcodes.IS_UNUSED {
 		// Append to array with auto-increment index
-		arrayData.Elements[arrayData.NextIndex] = ref
-		arrayData.NextIndex++
+		arrayData.Append(ref)
 	} else {
 		// Set at specific index
-		key := indexVal.ToString()
-		// Try to convert to integer if possible
-		if intKey, err := strconv.ParseInt(key, 10, 64); err == nil {
-			arrayData.Elements[intKey] = ref
-			if intKey >= arrayData.NextIndex {
-				arrayData.NextIndex = intKey + 1
-			}
-		} else {
-			// Use string key
-			arrayData.Elements[key] = ref
-			arrayData.IsIndexed = false
-		}
+		arrayData.Set(values.ArrayKey(indexVal), ref)
 	}
 
 	return true, nil
@@ -1444,33 +1497,17 @@ func (vm *VirtualMachine) execFetchDimRef(ctx *ExecutionContext, frame *CallFram
 	arrayData := arrayVal.Data.(*values.Array)
 	var elementVal *values.Value
 
-	if indexVal.IsNull() {
+	if opType2 == opcodes.IS_UNUSED {
 		return false, fmt.Errorf("cannot fetch reference to array element with null index")
 	} else {
 		// Get at specific index
-		key := indexVal.ToString()
-		// Try to convert to integer if possible
-		if intKey, err := strconv.ParseInt(key, 10, 64); err == nil {
-			if element, exists := arrayData.Elements[intKey]; exists {
-				elementVal = element
-			} else {
-				// Create a new element if it doesn't exist
-				elementVal = values.NewNull()
-				arrayData.Elements[intKey] = elementVal
-				if intKey >= arrayData.NextIndex {
-					arrayData.NextIndex = intKey + 1
-				}
-			}
+		key := values.ArrayKey(indexVal)
+		if element, exists := arrayData.GetForWrite(key); exists {
+			elementVal = element
 		} else {
-			// Use string key
-			if element, exists := arrayData.Elements[key]; exists {
-				elementVal = element
-			} else {
-				// Create a new element if it doesn't exist
-				elementVal = values.NewNull()
-				arrayData.Elements[key] = elementVal
-				arrayData.IsIndexed = false
-			}
+			// Create a new element if it doesn't exist
+			elementVal = values.NewNull()
+			arrayData.Set(key, elementVal)
 		}
 	}
 
@@ -1730,26 +1767,36 @@ func (vm *VirtualMachine) execComparison(ctx *ExecutionContext, frame *CallFrame
 		return false, err
 	}
 
+	// Date objects compare by the instant they represent
+	compare, equal := left.Compare, left.Equal
+	if l, r := left.Deref(), right.Deref(); l.IsObject() && r.IsObject() {
+		b := &builtinContext{vm: vm, ctx: ctx, frame: frame}
+		if cmp, ok := runtime2.CompareDateObjects(b, l, r); ok {
+			compare = func(*values.Value) int { return cmp }
+			equal = func(*values.Value) bool { return cmp == 0 }
+		}
+	}
+
 	var result *values.Value
 	switch inst.Opcode {
 	case opcodes.OP_IS_EQUAL:
-		result = values.NewBool(left.Equal(right))
+		result = values.NewBool(equal(right))
 	case opcodes.OP_IS_NOT_EQUAL:
-		result = values.NewBool(!left.Equal(right))
+		result = values.NewBool(!equal(right))
 	case opcodes.OP_IS_IDENTICAL:
 		result = values.NewBool(left.Identical(right))
 	case opcodes.OP_IS_NOT_IDENTICAL:
 		result = values.NewBool(!left.Identical(right))
 	case opcodes.OP_IS_SMALLER:
-		result = values.NewBool(left.Compare(right) < 0)
+		result = values.NewBool(compare(right) < 0)
 	case opcodes.OP_IS_SMALLER_OR_EQUAL:
-		result = values.NewBool(left.Compare(right) <= 0)
+		result = values.NewBool(compare(right) <= 0)
 	case opcodes.OP_IS_GREATER:
-		result = values.NewBool(left.Compare(right) > 0)
+		result = values.NewBool(compare(right) > 0)
 	case opcodes.OP_IS_GREATER_OR_EQUAL:
-		result = values.NewBool(left.Compare(right) >= 0)
+		result = values.NewBool(compare(right) >= 0)
 	case opcodes.OP_SPACESHIP:
-		result = values.NewInt(int64(left.Compare(right)))
+		result = values.NewInt(int64(compare(right)))
 	default:
 		return false, fmt.Errorf("unsupported comparison opcode %s", inst.Opcode)
 	}
@@ -1928,7 +1975,7 @@ func (vm *VirtualMachine) execFetch(ctx *ExecutionContext, frame *CallFrame, ins
 		return false, err
 	}
 	resType, resSlot := decodeResult(inst)
-	if err := vm.writeOperand(ctx, frame, resType, resSlot, copyValue(val)); err != nil {
+	if err := vm.writeOperand(ctx, frame, resType, resSlot, fetchedValue(val)); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -2090,32 +2137,7 @@ func (vm *VirtualMachine) execFeReset(ctx *ExecutionContext, frame *CallFrame, i
 
 	if iterable != nil && iterable.IsArray() {
 		arr := iterable.Data.(*values.Array)
-		intKeys := make([]interface{}, 0)
-		stringKeys := make([]interface{}, 0)
-		otherKeys := make([]interface{}, 0)
-		for key := range arr.Elements {
-			if _, ok := asInt64(key); ok {
-				intKeys = append(intKeys, key)
-				continue
-			}
-			if _, ok := key.(string); ok {
-				stringKeys = append(stringKeys, key)
-				continue
-			}
-			otherKeys = append(otherKeys, key)
-		}
-
-		sort.Slice(intKeys, func(i, j int) bool {
-			iVal, _ := asInt64(intKeys[i])
-			jVal, _ := asInt64(intKeys[j])
-			return iVal < jVal
-		})
-		sort.Slice(stringKeys, func(i, j int) bool {
-			return stringKeys[i].(string) < stringKeys[j].(string)
-		})
-
-		orderedKeys := append(intKeys, stringKeys...)
-		orderedKeys = append(orderedKeys, otherKeys...)
+		orderedKeys := arr.Keys()
 
 		if byReference {
 			// For reference foreach, store array reference and keys
@@ -2123,20 +2145,29 @@ func (vm *VirtualMachine) execFeReset(ctx *ExecutionContext, frame *CallFrame, i
 			iterator.orderedKeys = orderedKeys
 			// Still populate keys for key access
 			for _, key := range orderedKeys {
-				if _, ok := arr.Elements[key]; ok {
-					iterator.keys = append(iterator.keys, makeKeyValue(key))
+				if _, ok := arr.Lookup(key); ok {
+					iterator.keys = append(iterator.keys, values.KeyValue(key))
 				}
 			}
 		} else {
 			// For normal foreach, copy values as before
 			for _, key := range orderedKeys {
-				if val, ok := arr.Elements[key]; ok {
-					iterator.keys = append(iterator.keys, makeKeyValue(key))
+				if val, ok := arr.Lookup(key); ok {
+					iterator.keys = append(iterator.keys, values.KeyValue(key))
 					iterator.values = append(iterator.values, copyValue(val))
 				}
 			}
 		}
 	} else if iterable != nil && iterable.IsObject() {
+		// An IteratorAggregate is traversed through the iterator it provides
+		for depth := 0; depth < 32 && iterable.IsObject() && instanceOfClass(ctx, iterable.Data.(*values.Object).ClassName, "IteratorAggregate"); depth++ {
+			if iterable, err = vm.callMethod(ctx, frame, iterable, "getIterator"); err != nil {
+				return thrownError(ctx, err)
+			}
+		}
+		if !iterable.IsObject() {
+			return vm.throwError(ctx, frame, "TypeError", "getIterator() must return a Traversable")
+		}
 		obj := iterable.Data.(*values.Object)
 		// Check if this is a Generator object
 		if obj.ClassName == "Generator" {
@@ -2172,80 +2203,60 @@ func (vm *VirtualMachine) execFeFetch(ctx *ExecutionContext, frame *CallFrame, i
 
 	if iterator != nil && iterator.generator != nil {
 		// Handle Generator iteration
-		obj := iterator.generator.Data.(*values.Object)
-
-		// Get generator
-		if genVal, ok := obj.Properties["__channel_generator"]; ok {
-			if gen, ok := genVal.Data.(*runtime2.Generator); ok {
-				if iterator.isFirst {
-					iterator.isFirst = false
-					// Start the generator and get first value
-					if gen.Next() {
-						nextValue = gen.Current()
-						nextKey = gen.Key()
-					} else {
-						nextValue = values.NewNull()
-						nextKey = values.NewNull()
-					}
-				} else {
-					// Advance to next value
-					if gen.Next() {
-						nextValue = gen.Current()
-						nextKey = gen.Key()
-					} else {
-						nextValue = values.NewNull()
-						nextKey = values.NewNull()
-					}
+		gen := runtime2.GeneratorOf(iterator.generator)
+		if gen == nil {
+			return false, fmt.Errorf("invalid generator object")
+		}
+		b := &builtinContext{vm: vm, ctx: ctx, frame: frame}
+		var err error
+		if iterator.isFirst {
+			iterator.isFirst = false
+			if gen.Finished() {
+				return vm.throwError(ctx, frame, "Exception", "Cannot traverse an already closed generator")
+			}
+			err = gen.Rewind(b)
+		} else {
+			err = gen.Next(b)
+		}
+		if err == nil {
+			var valid bool
+			if valid, err = gen.Valid(b); err == nil && valid {
+				if nextValue, err = gen.Current(b); err == nil {
+					nextKey, err = gen.Key(b)
 				}
 			}
 		}
+		if err != nil {
+			return thrownError(ctx, err)
+		}
 	} else if iterator != nil && iterator.iteratorObject != nil {
-		// Handle Iterator interface objects (ArrayIterator, RecursiveIteratorIterator, etc.)
-		obj := iterator.iteratorObject.Data.(*values.Object)
-		cls := ctx.ensureClass(obj.ClassName)
-
-		// On first call, rewind the iterator
+		// Handle Iterator interface objects, builtin (ArrayIterator, etc.) or user-defined
+		object := iterator.iteratorObject
+		var err error
 		if iterator.isFirst {
 			iterator.isFirst = false
-			// Call rewind() method
-			if rewindMethod := resolveClassMethod(ctx, cls, "rewind"); rewindMethod != nil {
-				rewindMethod.Builtin(nil, []*values.Value{iterator.iteratorObject})
-			}
+			_, err = vm.callMethod(ctx, frame, object, "rewind")
 		} else {
-			// Call next() method to advance iterator
-			if nextMethod := resolveClassMethod(ctx, cls, "next"); nextMethod != nil {
-				nextMethod.Builtin(nil, []*values.Value{iterator.iteratorObject})
-			}
+			_, err = vm.callMethod(ctx, frame, object, "next")
 		}
-
-		// Check if iterator is valid
-		if validMethod := resolveClassMethod(ctx, cls, "valid"); validMethod != nil {
-			validResult, err := validMethod.Builtin(nil, []*values.Value{iterator.iteratorObject})
-			if err == nil && validResult.ToBool() {
-				// Get current value
-				if currentMethod := resolveClassMethod(ctx, cls, "current"); currentMethod != nil {
-					currentResult, err := currentMethod.Builtin(nil, []*values.Value{iterator.iteratorObject})
-					if err == nil {
-						nextValue = currentResult
-					}
-				}
-
-				// Get current key
-				if keyMethod := resolveClassMethod(ctx, cls, "key"); keyMethod != nil {
-					keyResult, err := keyMethod.Builtin(nil, []*values.Value{iterator.iteratorObject})
-					if err == nil {
-						nextKey = keyResult
-					}
+		if err == nil {
+			var valid *values.Value
+			if valid, err = vm.callMethod(ctx, frame, object, "valid"); err == nil && valid.ToBool() {
+				if nextValue, err = vm.callMethod(ctx, frame, object, "current"); err == nil {
+					nextKey, err = vm.callMethod(ctx, frame, object, "key")
 				}
 			}
 		}
+		if err != nil {
+			return thrownError(ctx, err)
+		}
 	} else if iterator != nil && ((iterator.byReference && iterator.sourceArray != nil && iterator.index < len(iterator.orderedKeys)) || (!iterator.byReference && iterator.index < len(iterator.values))) {
 		// Handle array iteration
 		if iterator.byReference && iterator.sourceArray != nil {
 			// For reference foreach, create a shared reference container
 			if iterator.index < len(iterator.orderedKeys) {
 				key := iterator.orderedKeys[iterator.index]
-				if val, ok := iterator.sourceArray.Elements[key]; ok {
+				if val, ok := iterator.sourceArray.Lookup(key); ok {
 					// Create a shared container for bidirectional updates between
 					// the array element and the foreach variable
 					var sharedContainer *values.Value
@@ -2263,7 +2274,7 @@ func (vm *VirtualMachine) execFeFetch(ctx *ExecutionContext, frame *CallFrame, i
 					foreachVarRef := values.NewReference(sharedContainer)
 
 					// Update the array element to point to shared container
-					iterator.sourceArray.Elements[key] = arrayElemRef
+					iterator.sourceArray.Set(key, arrayElemRef)
 
 					// Return reference for foreach variable assignment
 					nextValue = foreachVarRef
@@ -2307,11 +2318,34 @@ func (vm *VirtualMachine) execFeFree(ctx *ExecutionContext, frame *CallFrame, in
 	opType1, op1 := decodeOperand(inst, 1)
 	_ = opType1
 	if frame.Iterators != nil {
+		if it := frame.Iterators[op1]; it != nil && isSuspendedGenerator(ctx, it.generator) {
+			defer vm.releaseGenerators(ctx)
+		}
 		delete(frame.Iterators, op1)
 	}
 	return true, nil
 }
 
+// execFree drops the temporaries and foreach iterators from op1 up to op2
+// that a statement left behind, so the values they hold do not outlive it
+func (vm *VirtualMachine) execFree(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
+	released := false
+	for slot := inst.Op1; slot < inst.Op2; slot++ {
+		released = released || isSuspendedGenerator(ctx, frame.TempVars[slot])
+		delete(frame.TempVars, slot)
+		if frame.Iterators != nil {
+			if it := frame.Iterators[slot]; it != nil {
+				released = released || isSuspendedGenerator(ctx, it.generator)
+			}
+			delete(frame.Iterators, slot)
+		}
+	}
+	if released {
+		vm.releaseGenerators(ctx)
+	}
+	return true, nil
+}
+
 func (vm *VirtualMachine) execInitArray(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
 	resType, resSlot := decodeResult(inst)
 	arr := values.NewArray()
@@ -2328,8 +2362,6 @@ func (vm *VirtualMachine) execAddArrayElement(ctx *ExecutionContext, frame *Call
 	if err != nil {
 		return false, err
 	}
-	arrVal = ensureArrayValue(arrVal)
-	arr := arrVal.Data.(*values.Array)
 
 	opType1, op1 := decodeOperand(inst, 1)
 	var key *values.Value
@@ -2345,11 +2377,24 @@ func (vm *VirtualMachine) execAddArrayElement(ctx *ExecutionContext, frame *Call
 		return false, err
 	}
 
-	if key == nil || key.IsNull() || opType1 == opcodes.IS_UNUSED {
-		arr.Elements[arr.NextIndex] = copyValue(val)
-		arr.NextIndex++
+	// $object[] = $value appends through ArrayAccess::offsetSet(null, $value)
+	if object := arrayAccessObject(ctx, arrVal); object != nil {
+		if key == nil {
+			key = values.NewNull()
+		}
+		if _, err := vm.callMethod(ctx, frame, object, "offsetSet", key, val); err != nil {
+			return thrownError(ctx, err)
+		}
+		return true, nil
+	}
+
+	arrVal = ensureArrayValue(arrVal)
+	arr := arrVal.Data.(*values.Array)
+
+	if key == nil {
+		arr.Append(copyValue(val))
 	} else {
-		arr.Elements[keyInterface(key)] = copyValue(val)
+		arr.Set(values.ArrayKey(key), copyValue(val))
 	}
 
 	return true, nil
@@ -2374,11 +2419,13 @@ func (vm *VirtualMachine) execArrayUnpack(ctx *ExecutionContext, frame *CallFram
 	}
 	dest := targetArr.Data.(*values.Array)
 	src := srcVal.Data.(*values.Array)
-	for key, val := range src.Elements {
-		dest.Elements[key] = copyValue(val)
-	}
-	if src.NextIndex > dest.NextIndex {
-		dest.NextIndex = src.NextIndex
+	// Integer keys are renumbered and string keys kept, as in PHP 8.1
+	for key, val := range src.All() {
+		if _, isInt := key.(int64); isInt {
+			dest.Append(copyValue(val))
+		} else {
+			dest.Set(key, copyValue(val))
+		}
 	}
 	return true, nil
 }
@@ -2391,46 +2438,21 @@ func (vm *VirtualMachine) execFetchDim(ctx *ExecutionContext, frame *CallFrame,
 	}
 
 	// Handle ArrayAccess objects
-	if arrVal != nil && arrVal.IsObject() {
-		obj := arrVal.Data.(*values.Object)
-		if implementsArrayAccess(obj) {
-			keyType, keyOp := decodeOperand(inst, 2)
-			keyVal, err := vm.readOperand(ctx, frame, keyType, keyOp)
-			if err != nil {
-				return false, err
-			}
-
-			// Call offsetGet(index) method
-			class, err := registry.GlobalRegistry.GetClass(obj.ClassName)
-			if err != nil {
-				return false, err
-			}
-
-			if method, ok := class.Methods["offsetGet"]; ok {
-				var function *registry.Function
-				// Handle both runtime.BuiltinMethodImpl and spl.BuiltinMethodImpl
-				switch impl := method.Implementation.(type) {
-				case *runtime2.BuiltinMethodImpl:
-					function = impl.GetFunction()
-				case interface{ GetFunction() *registry.Function }:
-					function = impl.GetFunction()
-				default:
-					return false, fmt.Errorf("unsupported method implementation type")
-				}
-
-				builtinCtx := &builtinContext{vm: vm, ctx: ctx, frame: frame}
-				result, err := function.Builtin(builtinCtx, []*values.Value{arrVal, keyVal})
-				if err != nil {
-					return false, err
-				}
-
-				resType, resSlot := decodeResult(inst)
-				if err := vm.writeOperand(ctx, frame, resType, resSlot, copyValue(result)); err != nil {
-					return false, err
-				}
-				return true, nil
-			}
+	if object := arrayAccessObject(ctx, arrVal); object != nil {
+		keyType, keyOp := decodeOperand(inst, 2)
+		keyVal, err := vm.readOperand(ctx, frame, keyType, keyOp)
+		if err != nil {
+			return false, err
+		}
+		result, err := vm.callMethod(ctx, frame, object, "offsetGet", keyVal)
+		if err != nil {
+			return thrownError(ctx, err)
 		}
+		resType, resSlot := decodeResult(inst)
+		if err := vm.writeOperand(ctx, frame, resType, resSlot, copyValue(result)); err != nil {
+			return false, err
+		}
+		return true, nil
 	}
 
 	if arrVal == nil || !arrVal.IsArray() {
@@ -2449,7 +2471,7 @@ func (vm *VirtualMachine) execFetchDim(ctx *ExecutionContext, frame *CallFrame,
 
 	result := arrVal.ArrayGet(keyVal)
 	resType, resSlot := decodeResult(inst)
-	if err := vm.writeOperand(ctx, frame, resType, resSlot, copyValue(result)); err != nil {
+	if err := vm.writeOperand(ctx, frame, resType, resSlot, fetchedValue(result)); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -2469,9 +2491,14 @@ func (vm *VirtualMachine) execFetchDimIs(ctx *ExecutionContext, frame *CallFrame
 	}
 
 	exists := false
-	if arrVal != nil && arrVal.IsArray() {
-		key := keyInterface(keyVal)
-		_, exists = arrVal.Data.(*values.Array).Elements[key]
+	if object := arrayAccessObject(ctx, arrVal); object != nil {
+		result, err := vm.callMethod(ctx, frame, object, "offsetExists", keyVal)
+		if err != nil {
+			return thrownError(ctx, err)
+		}
+		exists = result.ToBool()
+	} else if arrVal != nil && arrVal.IsArray() {
+		_, exists = arrVal.Data.(*values.Array).Lookup(values.ArrayKey(keyVal))
 	}
 
 	resType, resSlot := decodeResult(inst)
@@ -2494,7 +2521,11 @@ func (vm *VirtualMachine) execFetchDimUnset(ctx *ExecutionContext, frame *CallFr
 		return false, err
 	}
 
-	if arrVal != nil && arrVal.IsArray() {
+	if object := arrayAccessObject(ctx, arrVal); object != nil {
+		if _, err := vm.callMethod(ctx, frame, object, "offsetUnset", keyVal); err != nil {
+			return thrownError(ctx, err)
+		}
+	} else if arrVal != nil && arrVal.IsArray() {
 		arrVal.ArrayUnset(keyVal)
 	}
 
@@ -2509,8 +2540,7 @@ func (vm *VirtualMachine) execFetchDimWrite(ctx *ExecutionContext, frame *CallFr
 	}
 	baseVal = ensureArrayValue(baseVal)
 
-	keyType, keyOp := decodeOperand(inst, 2)
-	keyVal, err := vm.readOperand(ctx, frame, keyType, keyOp)
+	keyVal, err := vm.readDimKey(ctx, frame, inst)
 	if err != nil {
 		return false, err
 	}
@@ -2535,65 +2565,27 @@ func (vm *VirtualMachine) execAssignDim(ctx *ExecutionContext, frame *CallFrame,
 	}
 
 	// Check if this is an ArrayAccess object
-	actual := baseVal
-	if actual.IsReference() {
-		actual = actual.Deref()
-	}
-
-	if actual.IsObject() {
-		obj := actual.Data.(*values.Object)
-		if implementsArrayAccess(obj) {
-			// Handle ArrayAccess objects by calling offsetSet method
-			keyType, keyOp := decodeOperand(inst, 2)
-			keyVal, err := vm.readOperand(ctx, frame, keyType, keyOp)
-			if err != nil {
-				return false, err
-			}
-
-			resType, resSlot := decodeResult(inst)
-			value, err := vm.readOperand(ctx, frame, resType, resSlot)
-			if err != nil {
-				return false, err
-			}
-
-			// Call offsetSet(index, value) method
-			class, err := registry.GlobalRegistry.GetClass(obj.ClassName)
-			if err != nil {
-				return false, err
-			}
-
-			if method, ok := class.Methods["offsetSet"]; ok {
-				var function *registry.Function
-				// Handle both runtime.BuiltinMethodImpl and spl.BuiltinMethodImpl
-				switch impl := method.Implementation.(type) {
-				case *runtime2.BuiltinMethodImpl:
-					function = impl.GetFunction()
-				case interface{ GetFunction() *registry.Function }:
-					function = impl.GetFunction()
-				default:
-					return false, fmt.Errorf("unsupported method implementation type")
-				}
-
-				builtinCtx := &builtinContext{vm: vm, ctx: ctx, frame: frame}
-				_, err := function.Builtin(builtinCtx, []*values.Value{actual, keyVal, value})
-				if err != nil {
-					return false, err
-				}
-
-				// Write the assigned value back
-				if err := vm.writeOperand(ctx, frame, resType, resSlot, value); err != nil {
-					return false, err
-				}
-				return true, nil
-			}
+	if object := arrayAccessObject(ctx, baseVal); object != nil {
+		keyType, keyOp := decodeOperand(inst, 2)
+		keyVal, err := vm.readOperand(ctx, frame, keyType, keyOp)
+		if err != nil {
+			return false, err
 		}
+		resType, resSlot := decodeResult(inst)
+		value, err := vm.readOperand(ctx, frame, resType, resSlot)
+		if err != nil {
+			return false, err
+		}
+		if _, err := vm.callMethod(ctx, frame, object, "offsetSet", keyVal, value); err != nil {
+			return thrownError(ctx, err)
+		}
+		return true, nil
 	}
 
 	// Regular array handling
 	baseVal = ensureArrayValue(baseVal)
 
-	keyType, keyOp := decodeOperand(inst, 2)
-	keyVal, err := vm.readOperand(ctx, frame, keyType, keyOp)
+	keyVal, err := vm.readDimKey(ctx, frame, inst)
 	if err != nil {
 		return false, err
 	}
@@ -2648,6 +2640,14 @@ func (vm *VirtualMachine) execNew(ctx *ExecutionContext, frame *CallFrame, inst
 	if err != nil {
 		return false, err
 	}
+	cls := ctx.ensureClass(className)
+	if cls.Descriptor == nil && !ctx.classDefined(className) {
+		// Stop here rather than look the class up again for its constructor
+		return vm.throwError(ctx, frame, "Error", fmt.Sprintf("Class \"%s\" not found", className))
+	}
+	if cls.Descriptor != nil && cls.Descriptor.IsEnum {
+		return vm.throwError(ctx, frame, "Error", fmt.Sprintf("Cannot instantiate enum %s", cls.Descriptor.Name))
+	}
 	obj, err := instantiateObject(ctx, className)
 	if err != nil {
 		return false, err
@@ -2686,6 +2686,10 @@ func (vm *VirtualMachine) execUnsetVar(ctx *ExecutionContext, frame *CallFrame,
 			}
 		}
 		ctx.recordAssignment(frame, op1, values.NewNull())
+		if isSuspendedGenerator(ctx, val) {
+			val = nil
+			vm.releaseGenerators(ctx)
+		}
 		return true, nil
 	default:
 		return false, fmt.Errorf("unset requires variable operand, got %d", opType1)
@@ -2829,6 +2833,13 @@ func (vm *VirtualMachine) execInitFCall(ctx *ExecutionContext, frame *CallFrame,
 			if fn, ok := closure.Function.(*registry.Function); ok {
 				pending.Function = fn
 				pending.ClosureName = fn.Name
+				pending.Closure = closure
+				pending.This = closure.This
+				pending.ClassName = closure.Scope
+				pending.CallingClass = closure.Scope
+				if closure.This != nil {
+					pending.CallingClass = closure.This.Data.(*values.Object).ClassName
+				}
 			} else if fnType, ok := closure.Function.(string); ok {
 				// Handle special first-class callable types
 				switch fnType {
@@ -2879,13 +2890,15 @@ func (vm *VirtualMachine) execInitFCall(ctx *ExecutionContext, frame *CallFrame,
 			}
 		}
 	} else {
-		name := callee.ToString()
+		// A fully qualified name like \Foo\bar() names the same function as Foo\bar()
+		name := strings.TrimPrefix(callee.ToString(), "\\")
 		pending.ClosureName = name
-		if fn, ok := ctx.UserFunctions[strings.ToLower(name)]; ok {
-			pending.Function = fn
-		} else if registry.GlobalRegistry != nil {
-			if fn, ok := registry.GlobalRegistry.GetFunction(name); ok {
-				pending.Function = fn
+		pending.Function = lookupFunction(ctx, name)
+		if pending.Function == nil && inst.Opcode == opcodes.OP_INIT_FCALL_BY_NAME {
+			// An unqualified call in a namespace falls back to the global function
+			if idx := strings.LastIndex(name, "\\"); idx >= 0 {
+				pending.ClosureName = name[idx+1:]
+				pending.Function = lookupFunction(ctx, name[idx+1:])
 			}
 		}
 		if pending.Function == nil {
@@ -2925,6 +2938,9 @@ func (vm *VirtualMachine) execInitMethodCall(ctx *ExecutionContext, frame *CallF
 	if err != nil {
 		return false, err
 	}
+	if objectVal != nil && objectVal.IsCallable() {
+		return vm.initClosureMethodCall(ctx, frame, inst, objectVal)
+	}
 	if objectVal == nil || !objectVal.IsObject() {
 		// Get method name for better error reporting
 		opType2, op2 := decodeOperand(inst, 2)
@@ -3188,7 +3204,7 @@ func (vm *VirtualMachine) execSendArg(ctx *ExecutionContext, frame *CallFrame, i
 			if err != nil {
 				return false, err
 			}
-			pending.Args = append(pending.Args, copyValue(val))
+			pending.Args = append(pending.Args, sendValue(pending, val))
 		}
 
 		pending.ArgNames = append(pending.ArgNames, "")
@@ -3200,12 +3216,22 @@ func (vm *VirtualMachine) execSendArg(ctx *ExecutionContext, frame *CallFrame, i
 		if err != nil {
 			return false, err
 		}
-		pending.Args = append(pending.Args, copyValue(val))
+		pending.Args = append(pending.Args, sendValue(pending, val))
 		pending.ArgNames = append(pending.ArgNames, "")
 		return true, nil
 	}
 }
 
+// sendValue copies val for a parameter passed by value. Builtins get arrays
+// without a copy, as the ones that sort or push onto an array change their
+// argument in place.
+func sendValue(pending *PendingCall, val *values.Value) *values.Value {
+	if val != nil && val.IsArray() && pending.Function != nil && pending.Function.IsBuiltin {
+		return val
+	}
+	return copyValue(val)
+}
+
 func (vm *VirtualMachine) execSendNamedArg(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
 	pending := frame.currentPendingCall()
 	if pending == nil {
@@ -3227,7 +3253,7 @@ func (vm *VirtualMachine) execSendNamedArg(ctx *ExecutionContext, frame *CallFra
 	}
 
 	// Store the argument with its name
-	pending.Args = append(pending.Args, copyValue(val))
+	pending.Args = append(pending.Args, sendValue(pending, val))
 	pending.ArgNames = append(pending.ArgNames, nameVal.ToString())
 	return true, nil
 }
@@ -3305,68 +3331,6 @@ func (vm *VirtualMachine) resolveNamedArguments(fn *registry.Function, args []*v
 	return result, nil
 }
 
-// validateParameterType validates that a parameter value matches the expected type(s)
-func (vm *VirtualMachine) validateParameterType(param *registry.Parameter, value *values.Value, functionName string, paramIndex int) error {
-	paramType := param.Type
-	valueType := value.Type.String()
-
-	// Handle union types (e.g., "int|string")
-	if strings.Contains(paramType, "|") {
-		allowedTypes := strings.Split(paramType, "|")
-		for _, allowedType := range allowedTypes {
-			allowedType = strings.TrimSpace(allowedType)
-			if vm.isTypeMatch(allowedType, value) {
-				return nil // Type matches one of the union options
-			}
-		}
-		// None of the union types matched
-		return fmt.Errorf("TypeError: %s(): Argument #%d ($%s) must be of type %s, %s given",
-			functionName, paramIndex+1, param.Name, paramType, valueType)
-	}
-
-	// Handle single types
-	if !vm.isTypeMatch(paramType, value) {
-		return fmt.Errorf("TypeError: %s(): Argument #%d ($%s) must be of type %s, %s given",
-			functionName, paramIndex+1, param.Name, paramType, valueType)
-	}
-
-	return nil
-}
-
-// isTypeMatch checks if a value matches a specific type
-func (vm *VirtualMachine) isTypeMatch(expectedType string, value *values.Value) bool {
-	valueType := value.Type.String()
-
-	// Handle nullable types (e.g., ?string)
-	if strings.HasPrefix(expectedType, "?") {
-		underlyingType := strings.TrimPrefix(expectedType, "?")
-		// Nullable types accept null or the underlying type
-		return valueType == "null" || vm.isTypeMatch(underlyingType, value)
-	}
-
-	switch expectedType {
-	case "int":
-		return valueType == "int"
-	case "string":
-		return valueType == "string"
-	case "float":
-		return valueType == "float"
-	case "bool":
-		return valueType == "bool"
-	case "array":
-		return valueType == "array"
-	case "object":
-		return valueType == "object"
-	case "null":
-		return valueType == "null"
-	case "mixed":
-		return true // mixed accepts any type
-	default:
-		// Handle class names and other complex types
-		return valueType == expectedType
-	}
-}
-
 func (vm *VirtualMachine) execDoFCall(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
 	pending := frame.popPendingCall()
 	if pending == nil {
@@ -3426,15 +3390,21 @@ func (vm *VirtualMachine) execDoFCall(ctx *ExecutionContext, frame *CallFrame, i
 			return false, err
 		}
 		args := resolvedArgs
+		if !pending.Method {
+			if typeErr := vm.checkBuiltinArguments(ctx, frame, fn, args); typeErr != "" {
+				return vm.throwError(ctx, frame, "TypeError", typeErr)
+			}
+		}
 
 		// For method calls, prepend the 'this' object as the first argument
-		if pending.Method && pending.This != nil {
+		if pending.Method && pending.This != nil && !fn.IsStatic {
 			args = append([]*values.Value{pending.This}, args...)
 		}
 		ret, err := fn.Builtin(ctxBuiltin, args)
 		if err != nil {
 			if errors.Is(err, heyerrors.ErrExceptionThrown) {
-				if frame.pendingException != nil {
+				// The handler may live in a caller frame that the unwind returned to
+				if current := ctx.currentFrame(); current != nil && current.pendingException != nil {
 					return false, nil
 				}
 				return false, fmt.Errorf("exception thrown but not set")
@@ -3457,43 +3427,6 @@ func (vm *VirtualMachine) execDoFCall(ctx *ExecutionContext, frame *CallFrame, i
 		return true, nil
 	}
 
-	// Handle generator functions
-	if pending.Function.IsGenerator {
-		// Resolve named arguments for generators too
-		genResolvedArgs, err := vm.resolveNamedArguments(pending.Function, pending.Args, pending.ArgNames)
-		if err != nil {
-			return false, err
-		}
-		// Create generator
-		gen := runtime2.NewGenerator(pending.Function, genResolvedArgs, vm)
-
-		// Create Generator object
-		generatorObj := &values.Object{
-			ClassName:  "Generator",
-			Properties: make(map[string]*values.Value),
-		}
-		generatorObj.Properties["__channel_generator"] = &values.Value{
-			Type: values.TypeResource,
-			Data: gen,
-		}
-		// Store function name for debugging/reflection
-		generatorObj.Properties["function"] = values.NewString(pending.Function.Name)
-
-		generator := &values.Value{
-			Type: values.TypeObject,
-			Data: generatorObj,
-		}
-
-		// Return generator object
-		if frame.ReturnTarget.valid {
-			if err := vm.writeOperand(ctx, frame, resultType, resultSlot, generator); err != nil {
-				return false, err
-			}
-		}
-		frame.resetReturnTarget()
-		return true, nil
-	}
-
 	child := newCallFrame(pending.Function.Name, pending.Function, pending.Function.Instructions, pending.Function.Constants)
 	child.ClassName = pending.ClassName
 	child.CallingClass = pending.CallingClass
@@ -3533,10 +3466,18 @@ func (vm *VirtualMachine) execDoFCall(ctx *ExecutionContext, frame *CallFrame, i
 		// Handle variadic parameter (last parameter when function is variadic)
 		if pending.Function.IsVariadic && i == len(pending.Function.Parameters)-1 {
 			// This is the variadic parameter - collect all remaining arguments into an array
+			// Variadic types apply to each collected argument
 			variadicArray := values.NewArray()
 			startIndex := i // Start collecting from current parameter index
 			for argIndex := startIndex; argIndex < len(functionArgs); argIndex++ {
-				variadicArray.ArraySet(values.NewInt(int64(argIndex-startIndex)), copyValue(functionArgs[argIndex]))
+				element := copyValue(functionArgs[argIndex])
+				if param.Type != "" {
+					var typeErr string
+					if element, typeErr = vm.coerceArgument(ctx, child, pending.Function, pending.ClassName, param, argIndex, element, frame.StrictTypes); typeErr != "" {
+						return vm.throwError(ctx, frame, "TypeError", callSiteMessage(typeErr, inst))
+					}
+				}
+				variadicArray.ArraySet(values.NewInt(int64(argIndex-startIndex)), element)
 			}
 			arg = variadicArray
 		} else if i < len(functionArgs) {
@@ -3554,16 +3495,11 @@ func (vm *VirtualMachine) execDoFCall(ctx *ExecutionContext, frame *CallFrame, i
 			arg = values.NewNull()
 		}
 
-		// Validate parameter type
-		if param.Type != "" {
-			// For reference parameters, validate the dereferenced value
-			validateArg := arg
-			if param.IsReference && arg != nil && arg.IsReference() {
-				ref := arg.Data.(*values.Reference)
-				validateArg = ref.Target
-			}
-			if err := vm.validateParameterType(param, validateArg, pending.Function.Name, i); err != nil {
-				return false, err
+		// Apply the parameter type using the strictness of the calling code
+		if param.Type != "" && !(pending.Function.IsVariadic && i == len(pending.Function.Parameters)-1) {
+			var typeErr string
+			if arg, typeErr = vm.coerceArgument(ctx, child, pending.Function, pending.ClassName, param, i, arg, frame.StrictTypes); typeErr != "" {
+				return vm.throwError(ctx, frame, "TypeError", callSiteMessage(typeErr, inst))
 			}
 		}
 
@@ -3582,6 +3518,21 @@ func (vm *VirtualMachine) execDoFCall(ctx *ExecutionContext, frame *CallFrame, i
 			child.setLocal(thisSlot, values.NewNull())
 		}
 	}
+	if pending.Closure != nil {
+		bindClosureFrame(child, pending.Closure)
+	}
+
+	// Calling a generator function only binds its arguments; the body runs
+	// as the generator is iterated
+	if pending.Function.IsGenerator {
+		if frame.ReturnTarget.valid {
+			if err := vm.writeOperand(ctx, frame, resultType, resultSlot, vm.newGenerator(ctx, child)); err != nil {
+				return false, err
+			}
+		}
+		frame.resetReturnTarget()
+		return true, nil
+	}
 
 	ctx.pushFrame(child)
 	return false, nil
@@ -3633,6 +3584,12 @@ func (vm *VirtualMachine) execReturn(ctx *ExecutionContext, frame *CallFrame, in
 		returnVal = copyValue(returnVal)
 	}
 
+	// Return types are enforced using the strictness of the returning code
+	returnVal, typeErr := vm.checkReturnType(ctx, frame, returnVal)
+	if typeErr != "" {
+		return vm.throwError(ctx, frame, "TypeError", typeErr)
+	}
+
 	if err := vm.handleReturn(ctx, returnVal); err != nil {
 		return false, err
 	}
@@ -3662,7 +3619,7 @@ func (vm *VirtualMachine) execCreateClosure(ctx *ExecutionContext, frame *CallFr
 		return false, fmt.Errorf("unknown function %s for closure", name)
 	}
 
-	closure := values.NewClosure(targetFn, nil, name)
+	closure := newBoundClosure(frame, targetFn, name)
 	resType, resSlot := decodeResult(inst)
 	if err := vm.writeOperand(ctx, frame, resType, resSlot, closure); err != nil {
 		return false, err
@@ -3717,41 +3674,42 @@ func (vm *VirtualMachine) execBindUseVar(ctx *ExecutionContext, frame *CallFrame
 		cleanVarName = varName[1:]
 	}
 
+	if opcodes.DecodeExtendedFlags(inst.OpType2)&opcodes.EXT_FLAG_REFERENCE != 0 && (varValueType == opcodes.IS_CV || varValueType == opcodes.IS_VAR) {
+		// use (&$var): the variable becomes a reference shared with the closure
+		ref := frame.getLocal(varValueOp)
+		if ref == nil || !ref.IsReference() {
+			ref = values.NewReference(copyValue(varValue))
+			frame.setLocal(varValueOp, ref)
+			if globalName, ok := frame.globalSlotName(varValueOp); ok {
+				ctx.bindGlobalValue(globalName, ref)
+			}
+		}
+		closure.BoundVars[cleanVarName] = ref
+		return true, nil
+	}
+
 	closure.BoundVars[cleanVarName] = copyValue(varValue)
 
 	return true, nil
 }
 
-// execCreateFuncCallable creates a first-class callable for a function reference
+// execCreateFuncCallable creates a first-class callable for a function
+// reference, strlen(...), or any other callable value, $callable(...)
 func (vm *VirtualMachine) execCreateFuncCallable(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
-	// Get function name from operand 1
 	opType1, op1 := decodeOperand(inst, 1)
-	if opType1 != opcodes.IS_CONST {
-		return false, fmt.Errorf("function callable creation requires const function name")
-	}
-	if int(op1) >= len(frame.Constants) {
-		return false, fmt.Errorf("constant index %d out of range", op1)
+	callable, err := vm.readOperand(ctx, frame, opType1, op1)
+	if err != nil {
+		return false, err
 	}
-	functionName := frame.Constants[op1].ToString()
 
-	// Look up the function
-	var targetFn *registry.Function
-	if fn, ok := ctx.UserFunctions[strings.ToLower(functionName)]; ok {
-		targetFn = fn
-	} else if registry.GlobalRegistry != nil {
-		if fn, ok := registry.GlobalRegistry.GetFunction(functionName); ok {
-			targetFn = fn
+	closure, ok := closureFromCallable(ctx, callable)
+	if !ok {
+		if callable.IsString() && !strings.Contains(callable.ToString(), "::") {
+			return vm.throwError(ctx, frame, "Error", fmt.Sprintf("Call to undefined function %s()", callable.ToString()))
 		}
+		return vm.throwError(ctx, frame, "Error", "Value not callable")
 	}
 
-	if targetFn == nil {
-		return false, fmt.Errorf("unknown function %s for first-class callable", functionName)
-	}
-
-	// Create a closure for the function
-	closure := values.NewClosure(targetFn, nil, functionName)
-
-	// Store result
 	resType, resSlot := decodeResult(inst)
 	if err := vm.writeOperand(ctx, frame, resType, resSlot, closure); err != nil {
 		return false, err
@@ -3767,30 +3725,38 @@ func (vm *VirtualMachine) execCreateMethodCallable(ctx *ExecutionContext, frame
 	if err != nil {
 		return false, err
 	}
-	if !objVal.IsObject() {
-		return false, fmt.Errorf("method callable requires object")
-	}
 
 	// Get method name from operand 2
 	methodType, methodOp := decodeOperand(inst, 2)
-	if methodType != opcodes.IS_CONST {
-		return false, fmt.Errorf("method callable creation requires const method name")
-	}
-	if int(methodOp) >= len(frame.Constants) {
-		return false, fmt.Errorf("constant index %d out of range", methodOp)
+	methodVal, err := vm.readOperand(ctx, frame, methodType, methodOp)
+	if err != nil {
+		return false, err
 	}
-	methodName := frame.Constants[methodOp].ToString()
-
-	// Create a bound method callable
-	// We'll store both the object and method name in the closure
-	boundVars := map[string]*values.Value{
-		"object": objVal,
-		"method": values.NewString(methodName),
+	methodName := methodVal.ToString()
+
+	var closure *values.Value
+	switch {
+	case objVal.IsCallable() && strings.EqualFold(methodName, "__invoke"):
+		closure = objVal
+	case objVal.IsObject():
+		var ok bool
+		closure, ok = boundMethodClosure(ctx, objVal, methodName)
+		className := objVal.Data.(*values.Object).ClassName
+		if !ok && resolveClassMethod(ctx, ctx.ensureClass(className), "__call") == nil {
+			return vm.throwError(ctx, frame, "Error", fmt.Sprintf("Call to undefined method %s::%s()", className, methodName))
+		}
+		if !ok {
+			// Calls of the closure go through __call
+			boundVars := map[string]*values.Value{
+				"object": objVal,
+				"method": values.NewString(methodName),
+			}
+			closure = values.NewClosure("bound_method", boundVars, fmt.Sprintf("%s->%s", className, methodName))
+		}
+	default:
+		return vm.throwError(ctx, frame, "Error", fmt.Sprintf("Call to a member function %s() on %s", methodName, objVal.TypeName()))
 	}
 
-	closure := values.NewClosure("bound_method", boundVars, fmt.Sprintf("%s->%s", objVal.Data.(*values.Object).ClassName, methodName))
-
-	// Store result
 	resType, resSlot := decodeResult(inst)
 	if err := vm.writeOperand(ctx, frame, resType, resSlot, closure); err != nil {
 		return false, err
@@ -3802,34 +3768,34 @@ func (vm *VirtualMachine) execCreateMethodCallable(ctx *ExecutionContext, frame
 func (vm *VirtualMachine) execCreateStaticCallable(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
 	// Get class name from operand 1
 	classType, classOp := decodeOperand(inst, 1)
-	if classType != opcodes.IS_CONST {
-		return false, fmt.Errorf("static callable creation requires const class name")
+	classVal, err := vm.readOperand(ctx, frame, classType, classOp)
+	if err != nil {
+		return false, err
 	}
-	if int(classOp) >= len(frame.Constants) {
-		return false, fmt.Errorf("constant index %d out of range", classOp)
+	className := classVal.ToString()
+	if strings.EqualFold(className, "static") {
+		className = scopeClass(ctx, frame, "static")
+	} else if className, err = resolveRuntimeClassName(ctx, frame, className); err != nil {
+		return false, err
 	}
-	className := frame.Constants[classOp].ToString()
 
 	// Get method name from operand 2
 	methodType, methodOp := decodeOperand(inst, 2)
-	if methodType != opcodes.IS_CONST {
-		return false, fmt.Errorf("static callable creation requires const method name")
-	}
-	if int(methodOp) >= len(frame.Constants) {
-		return false, fmt.Errorf("constant index %d out of range", methodOp)
+	methodVal, err := vm.readOperand(ctx, frame, methodType, methodOp)
+	if err != nil {
+		return false, err
 	}
-	methodName := frame.Constants[methodOp].ToString()
+	methodName := methodVal.ToString()
 
-	// Create a static method callable
-	// We'll store the class and method name in the closure
-	boundVars := map[string]*values.Value{
-		"class":  values.NewString(className),
-		"method": values.NewString(methodName),
+	cls := ctx.ensureClass(className)
+	if cls == nil {
+		return vm.throwError(ctx, frame, "Error", fmt.Sprintf("Class \"%s\" not found", className))
+	}
+	closure, ok := staticMethodClosure(ctx, cls.Name, methodName)
+	if !ok {
+		return vm.throwError(ctx, frame, "Error", fmt.Sprintf("Call to undefined method %s::%s()", cls.Name, methodName))
 	}
 
-	closure := values.NewClosure("static_method", boundVars, fmt.Sprintf("%s::%s", className, methodName))
-
-	// Store result
 	resType, resSlot := decodeResult(inst)
 	if err := vm.writeOperand(ctx, frame, resType, resSlot, closure); err != nil {
 		return false, err
@@ -3838,7 +3804,7 @@ func (vm *VirtualMachine) execCreateStaticCallable(ctx *ExecutionContext, frame
 }
 
 func (vm *VirtualMachine) execInclude(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
-	if vm.CompilerCallback == nil {
+	if vm.CompilerCallback == nil && vm.IncludeCallback == nil {
 		return false, errors.New("include executed without compiler callback")
 	}
 
@@ -3863,7 +3829,14 @@ func (vm *VirtualMachine) execInclude(ctx *ExecutionContext, frame *CallFrame, i
 		}
 	}
 
-	source, err := os.ReadFile(path)
+	// With an include callback the callback (or its cache) reads the file,
+	// so only check here that it exists and is a regular file.
+	var source []byte
+	if vm.IncludeCallback != nil {
+		err = checkIncludeFile(path)
+	} else {
+		source, err = os.ReadFile(path)
+	}
 	if err != nil {
 		if inst.Opcode == opcodes.OP_REQUIRE || inst.Opcode == opcodes.OP_REQUIRE_ONCE {
 			errMsg := err.Error()
@@ -3885,14 +3858,19 @@ func (vm *VirtualMachine) execInclude(ctx *ExecutionContext, frame *CallFrame, i
 		ctx.MarkFileIncluded(path)
 	}
 
-	lex := lexer.New(string(source))
-	prs := parser.New(lex)
-	program := prs.ParseProgram()
-	if program == nil {
-		return false, fmt.Errorf("failed to parse included file %s", path)
+	isRequired := inst.Opcode == opcodes.OP_REQUIRE || inst.Opcode == opcodes.OP_REQUIRE_ONCE
+	var resultVal *values.Value
+	if vm.IncludeCallback != nil {
+		resultVal, err = vm.IncludeCallback(ctx, path, isRequired)
+	} else {
+		lex := lexer.New(string(source))
+		prs := parser.New(lex)
+		program := prs.ParseProgram()
+		if program == nil {
+			return false, fmt.Errorf("failed to parse included file %s", path)
+		}
+		resultVal, err = vm.CompilerCallback(ctx, program, path, isRequired)
 	}
-
-	resultVal, err := vm.CompilerCallback(ctx, program, path, inst.Opcode == opcodes.OP_REQUIRE || inst.Opcode == opcodes.OP_REQUIRE_ONCE)
 	if err != nil {
 		return false, err
 	}
@@ -3908,18 +3886,47 @@ func (vm *VirtualMachine) execInclude(ctx *ExecutionContext, frame *CallFrame, i
 	return true, nil
 }
 
-func keyInterface(val *values.Value) interface{} {
-	if val == nil {
-		return nil
+// checkIncludeFile reports the error reading path would fail with, without
+// reading it.
+func checkIncludeFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return &os.PathError{Op: "open", Path: path, Err: pathErr.Err}
+		}
+		return err
 	}
-	switch val.Type {
-	case values.TypeInt:
-		return val.ToInt()
-	case values.TypeString:
-		return val.ToString()
-	default:
-		return val.ToString()
+	if info.IsDir() {
+		return &os.PathError{Op: "read", Path: path, Err: syscall.EISDIR}
+	}
+	return nil
+}
+
+// execDeclare applies a declare() directive to the file running in frame.
+// Only strict_types has a runtime effect; ticks compiles to OP_TICKS.
+func (vm *VirtualMachine) execDeclare(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
+	nameType, nameOp := decodeOperand(inst, 1)
+	if nameType != opcodes.IS_CONST || int(nameOp) >= len(frame.Constants) {
+		return false, fmt.Errorf("DECLARE requires directive name constant")
+	}
+	valueType, valueOp := decodeOperand(inst, 2)
+	value, err := vm.readOperand(ctx, frame, valueType, valueOp)
+	if err != nil {
+		return false, err
+	}
+	if strings.EqualFold(frame.Constants[nameOp].ToString(), "strict_types") {
+		frame.StrictTypes = value != nil && value.ToInt() == 1
 	}
+	return true, nil
+}
+
+// callSiteMessage appends the location of a call to an argument TypeError
+func callSiteMessage(message string, inst *opcodes.Instruction) string {
+	if inst.Filename == "" || inst.Line <= 0 {
+		return message
+	}
+	return fmt.Sprintf("%s, called in %s on line %d", message, inst.Filename, inst.Line)
 }
 
 func (vm *VirtualMachine) execDeclareInterface(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
@@ -4029,6 +4036,9 @@ func (vm *VirtualMachine) execClone(ctx *ExecutionContext, frame *CallFrame, ins
 
 	// Get the original object
 	originalObj := objectVal.Data.(*values.Object)
+	if originalObj.EnumCase {
+		return vm.throwError(ctx, frame, "Error", fmt.Sprintf("Trying to clone an uncloneable object of class %s", originalObj.ClassName))
+	}
 
 	// Create a shallow copy of the object
 	clonedObj = values.NewObject(originalObj.ClassName)
@@ -4238,6 +4248,9 @@ func (vm *VirtualMachine) CallAllDestructors(ctx *ExecutionContext) {
 		}
 		return true
 	})
+
+	// Unwind the generators and fibers still suspended
+	vm.closeCoroutines(ctx)
 }
 
 // checkReadonlyProperty validates that a property assignment is allowed based on readonly semantics
@@ -4331,7 +4344,7 @@ func (vm *VirtualMachine) execFetchListR(ctx *ExecutionContext, frame *CallFrame
 		var key interface{}
 		key = int64(index)
 
-		if val, exists := arrayData.Elements[key]; exists {
+		if val, exists := arrayData.Lookup(key); exists {
 			result = val
 		} else {
 			result = values.NewNull()
@@ -0,0 +1,324 @@
+package vm
+
+import (
+	"fmt"
+	"runtime/metrics"
+	"strings"
+	"time"
+)
+
+// allocatedBytes is the runtime metric counting the bytes allocated on the
+// heap since the process started
+const allocatedBytes = "/gc/heap/allocs:bytes"
+
+// Profiler measures how long each PHP function of a script runs, how many
+// times it is called and how much memory it allocates. Attach it with
+// VirtualMachine.SetProfiler before Execute and call Stop once the script
+// has finished.
+//
+// Every call is traced: the profiler notices each time the call stack
+// changes, so a function's wall time includes the builtins it calls.
+// Memory is counted in bytes allocated by the process while the function
+// runs, which includes allocations made concurrently by other goroutines.
+type Profiler struct {
+	started time.Time
+	last    time.Time
+	lastMem uint64
+	memory  []metrics.Sample
+
+	// top is the topmost frame of the call stack when it was last seen
+	top   *CallFrame
+	stack []profileEntry
+
+	functions   []*ProfileFunction
+	functionIDs map[profileFunctionKey]int
+	nodes       []*profileNode
+	nodeIDs     map[profileNodeKey]int
+	calls       map[profileCallKey]*ProfileCall
+	callOrder   []profileCallKey
+
+	duration time.Duration
+	stopped  bool
+}
+
+// profileEntry is a call on the profiler's copy of the call stack
+type profileEntry struct {
+	frame    *CallFrame
+	function int
+	node     int
+	line     int // line of the caller that made the call
+	started  time.Time
+	startMem uint64
+}
+
+type profileFunctionKey struct {
+	name string
+	file string
+	line int
+}
+
+// profileNode is a distinct call path, identified by its parent path and
+// the function called last
+type profileNode struct {
+	parent   int
+	function int
+	sample   ProfileSample
+}
+
+type profileNodeKey struct {
+	parent   int
+	function int
+}
+
+type profileCallKey struct {
+	caller int
+	callee int
+	line   int
+}
+
+// Profile holds the measurements of a profiled script. Durations and
+// memory are inclusive unless named Self.
+type Profile struct {
+	Started  time.Time
+	Duration time.Duration
+
+	// Functions lists the functions called, identified by their index in
+	// the other fields
+	Functions []*ProfileFunction
+	// Samples gives the self costs of each distinct call stack
+	Samples []ProfileSample
+	// Calls gives the costs of the calls made from one function to another
+	// at a line of the caller
+	Calls []*ProfileCall
+}
+
+// ProfileFunction is a function called by a profiled script, located at
+// the first line of its body. Recursive calls only count once in its
+// inclusive costs.
+type ProfileFunction struct {
+	Name string
+	File string
+	Line int
+
+	Calls      int
+	Time       time.Duration
+	SelfTime   time.Duration
+	Memory     int64
+	SelfMemory int64
+}
+
+// ProfileSample is a call stack, innermost function first, with the time
+// and memory spent in its innermost function itself
+type ProfileSample struct {
+	Stack  []int
+	Calls  int
+	Time   time.Duration
+	Memory int64
+}
+
+// ProfileCall counts the calls from Caller to Callee made at Line
+type ProfileCall struct {
+	Caller int
+	Callee int
+	Line   int
+	Calls  int
+	Time   time.Duration
+	Memory int64
+}
+
+// NewProfiler creates a profiler that starts measuring at once.
+func NewProfiler() *Profiler {
+	p := &Profiler{
+		memory:      []metrics.Sample{{Name: allocatedBytes}},
+		functionIDs: make(map[profileFunctionKey]int),
+		nodeIDs:     make(map[profileNodeKey]int),
+		calls:       make(map[profileCallKey]*ProfileCall),
+	}
+	p.started, p.lastMem = time.Now(), p.readMemory()
+	p.last = p.started
+	return p
+}
+
+// SetProfiler attaches p to the VM, or detaches the profiler when p is nil.
+func (vm *VirtualMachine) SetProfiler(p *Profiler) {
+	vm.profiler = p
+}
+
+func (p *Profiler) readMemory() uint64 {
+	metrics.Read(p.memory)
+	if p.memory[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return p.memory[0].Value.Uint64()
+}
+
+// observe runs before each instruction while a profiler is attached. It
+// catches up with the calls made and returned from since the call stack
+// last changed.
+func (p *Profiler) observe(ctx *ExecutionContext) {
+	ctx.frameMu.Lock()
+	defer ctx.frameMu.Unlock()
+	var top *CallFrame
+	if len(ctx.CallStack) > 0 {
+		top = ctx.CallStack[len(ctx.CallStack)-1]
+	}
+	if top == p.top || p.stopped {
+		return
+	}
+	p.top = top
+
+	// The frames the VM runs callbacks on are not functions of the script
+	frames := make([]*CallFrame, 0, len(ctx.CallStack))
+	for _, frame := range ctx.CallStack {
+		if frame != nil && (!strings.HasPrefix(frame.FunctionName, "{") || frame.FunctionName == "{main}") {
+			frames = append(frames, frame)
+		}
+	}
+	common := 0
+	for common < len(frames) && common < len(p.stack) && p.stack[common].frame == frames[common] {
+		common++
+	}
+	if common == len(frames) && common == len(p.stack) {
+		return
+	}
+
+	now, mem := time.Now(), p.readMemory()
+	p.tick(now, mem)
+	for len(p.stack) > common {
+		p.exit(now, mem)
+	}
+	for _, frame := range frames[common:] {
+		p.enter(frame, now, mem)
+	}
+}
+
+// tick charges the time and memory since the last change of the call stack
+// to the function on top of it
+func (p *Profiler) tick(now time.Time, mem uint64) {
+	if len(p.stack) > 0 {
+		top := p.stack[len(p.stack)-1]
+		elapsed, allocated := now.Sub(p.last), int64(mem-p.lastMem)
+		sample := &p.nodes[top.node].sample
+		sample.Time += elapsed
+		sample.Memory += allocated
+		function := p.functions[top.function]
+		function.SelfTime += elapsed
+		function.SelfMemory += allocated
+	}
+	p.last, p.lastMem = now, mem
+}
+
+func (p *Profiler) enter(frame *CallFrame, now time.Time, mem uint64) {
+	function := p.functionID(frame)
+	parent, line := -1, 0
+	if len(p.stack) > 0 {
+		caller := p.stack[len(p.stack)-1]
+		parent = caller.node
+		if ip := caller.frame.IP; ip >= 0 && ip < len(caller.frame.Instructions) {
+			line = caller.frame.Instructions[ip].Line
+		}
+	}
+
+	key := profileNodeKey{parent: parent, function: function}
+	node, ok := p.nodeIDs[key]
+	if !ok {
+		node = len(p.nodes)
+		p.nodes = append(p.nodes, &profileNode{parent: parent, function: function})
+		p.nodeIDs[key] = node
+	}
+	p.nodes[node].sample.Calls++
+	p.functions[function].Calls++
+	p.stack = append(p.stack, profileEntry{
+		frame:    frame,
+		function: function,
+		node:     node,
+		line:     line,
+		started:  now,
+		startMem: mem,
+	})
+}
+
+func (p *Profiler) exit(now time.Time, mem uint64) {
+	entry := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	elapsed, allocated := now.Sub(entry.started), int64(mem-entry.startMem)
+
+	recursive := false
+	for _, caller := range p.stack {
+		if caller.function == entry.function {
+			recursive = true
+			break
+		}
+	}
+	if !recursive {
+		function := p.functions[entry.function]
+		function.Time += elapsed
+		function.Memory += allocated
+	}
+
+	if len(p.stack) == 0 {
+		return
+	}
+	key := profileCallKey{caller: p.stack[len(p.stack)-1].function, callee: entry.function, line: entry.line}
+	call, ok := p.calls[key]
+	if !ok {
+		call = &ProfileCall{Caller: key.caller, Callee: key.callee, Line: key.line}
+		p.calls[key] = call
+		p.callOrder = append(p.callOrder, key)
+	}
+	call.Calls++
+	call.Time += elapsed
+	call.Memory += allocated
+}
+
+// functionID returns the index of the function frame runs, adding it on
+// its first call
+func (p *Profiler) functionID(frame *CallFrame) int {
+	key := profileFunctionKey{name: frameName(frame)}
+	if len(frame.Instructions) > 0 {
+		key.file, key.line = frame.Instructions[0].Filename, frame.Instructions[0].Line
+	}
+	if frame.Function != nil && frame.Function.IsAnonymous {
+		key.name = fmt.Sprintf("{closure:%s:%d}", key.file, key.line)
+	}
+	if id, ok := p.functionIDs[key]; ok {
+		return id
+	}
+	id := len(p.functions)
+	p.functions = append(p.functions, &ProfileFunction{Name: key.name, File: key.file, Line: key.line})
+	p.functionIDs[key] = id
+	return id
+}
+
+// Stop ends the measurements, counting the calls still running as returned
+// now, and returns the profile.
+func (p *Profiler) Stop() *Profile {
+	if !p.stopped {
+		now, mem := time.Now(), p.readMemory()
+		p.tick(now, mem)
+		for len(p.stack) > 0 {
+			p.exit(now, mem)
+		}
+		p.duration = now.Sub(p.started)
+		p.stopped = true
+	}
+
+	profile := &Profile{
+		Started:   p.started,
+		Duration:  p.duration,
+		Functions: p.functions,
+		Samples:   make([]ProfileSample, len(p.nodes)),
+		Calls:     make([]*ProfileCall, len(p.callOrder)),
+	}
+	for i, node := range p.nodes {
+		sample := node.sample
+		for id := i; id >= 0; id = p.nodes[id].parent {
+			sample.Stack = append(sample.Stack, p.nodes[id].function)
+		}
+		profile.Samples[i] = sample
+	}
+	for i, key := range p.callOrder {
+		profile.Calls[i] = p.calls[key]
+	}
+	return profile
+}
@@ -0,0 +1,161 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// propertyGuard identifies a magic property method running for one property
+// of one object; while it is set the same access reaches the real property.
+type propertyGuard struct {
+	obj    *values.Object
+	method string
+	name   string
+}
+
+// declaredProperty finds the declaration of a non-static property in the
+// class hierarchy and returns it with the name of the declaring class.
+func declaredProperty(ctx *ExecutionContext, className, propName string) (*registry.Property, string) {
+	cls := ctx.ensureClass(className)
+	for depth := 0; cls != nil && depth < 64; depth++ {
+		if cls.Descriptor != nil && cls.Descriptor.Properties != nil {
+			if prop, ok := cls.Descriptor.Properties[propName]; ok && !prop.IsStatic {
+				return prop, cls.Name
+			}
+		}
+		if cls.Parent == "" {
+			break
+		}
+		cls = ctx.ensureClass(cls.Parent)
+	}
+	return nil, ""
+}
+
+// classIsA reports whether className is target or one of its descendants.
+func classIsA(ctx *ExecutionContext, className, target string) bool {
+	cls := ctx.ensureClass(className)
+	for depth := 0; cls != nil && depth < 64; depth++ {
+		if strings.EqualFold(cls.Name, target) {
+			return true
+		}
+		if cls.Parent == "" {
+			break
+		}
+		cls = ctx.ensureClass(cls.Parent)
+	}
+	return false
+}
+
+// methodScope returns the class that declares the method running in frame.
+// Frames record the called class, so the method is looked up from there up
+// the hierarchy; frames that do not run a method keep their class.
+func methodScope(ctx *ExecutionContext, frame *CallFrame) string {
+	if frame == nil {
+		return ""
+	}
+	if frame.ClassName == "" || frame.Function == nil {
+		return frame.ClassName
+	}
+	cls := ctx.ensureClass(frame.ClassName)
+	for depth := 0; cls != nil && depth < 64; depth++ {
+		if cls.Descriptor != nil && cls.Descriptor.Methods[frame.Function.Name] == frame.Function {
+			return cls.Name
+		}
+		if cls.Parent == "" {
+			break
+		}
+		cls = ctx.ensureClass(cls.Parent)
+	}
+	return frame.ClassName
+}
+
+// propertyAccessible reports whether a declared property may be used directly
+// from the scope of frame. Undeclared properties are always accessible.
+// Private properties are only accessible from the declaring class, protected
+// ones from anywhere in its hierarchy.
+func propertyAccessible(ctx *ExecutionContext, frame *CallFrame, obj *values.Object, propName string) bool {
+	prop, declaringClass := declaredProperty(ctx, obj.ClassName, propName)
+	if prop == nil {
+		return true
+	}
+	switch strings.ToLower(prop.Visibility) {
+	case "private":
+		return strings.EqualFold(methodScope(ctx, frame), declaringClass)
+	case "protected":
+		scope := methodScope(ctx, frame)
+		if scope == "" {
+			return false
+		}
+		return classIsA(ctx, scope, declaringClass) || classIsA(ctx, declaringClass, scope)
+	default:
+		return true
+	}
+}
+
+// needsPropertyMagic reports whether an access to propName should be routed to
+// a magic method: the property is missing from the object or out of scope.
+func needsPropertyMagic(ctx *ExecutionContext, frame *CallFrame, obj *values.Object, propName string) bool {
+	if _, exists := obj.Properties[propName]; !exists {
+		return true
+	}
+	return !propertyAccessible(ctx, frame, obj, propName)
+}
+
+// inaccessibleProperty describes a use of propName on obj from the scope of
+// frame that no magic method handled. msg is the Error to raise, or empty when
+// the property may be used directly. hidden is set for a private property
+// declared by an ancestor of the object's class: outside that class it does
+// not exist, so reads see NULL instead of the ancestor's value.
+func inaccessibleProperty(ctx *ExecutionContext, frame *CallFrame, obj *values.Object, propName string) (msg string, hidden bool) {
+	if propertyAccessible(ctx, frame, obj, propName) {
+		return "", false
+	}
+	prop, declaringClass := declaredProperty(ctx, obj.ClassName, propName)
+	visibility := strings.ToLower(prop.Visibility)
+	msg = fmt.Sprintf("Cannot access %s property %s::$%s", visibility, obj.ClassName, propName)
+	return msg, visibility == "private" && !strings.EqualFold(declaringClass, obj.ClassName)
+}
+
+// callPropertyMagic invokes __get, __set, __isset or __unset on objVal for
+// propName. handled is false when the class has no such method or the same
+// call is already in progress, in which case the caller accesses the
+// property directly.
+func (vm *VirtualMachine) callPropertyMagic(ctx *ExecutionContext, frame *CallFrame, objVal *values.Value, method, propName string, extra ...*values.Value) (result *values.Value, handled bool, err error) {
+	obj := objVal.Data.(*values.Object)
+	fn := resolveClassMethod(ctx, ctx.ensureClass(obj.ClassName), method)
+	if fn == nil {
+		return nil, false, nil
+	}
+
+	guard := propertyGuard{obj: obj, method: method, name: propName}
+	ctx.propertyGuardMu.Lock()
+	if _, busy := ctx.propertyGuards[guard]; busy {
+		ctx.propertyGuardMu.Unlock()
+		return nil, false, nil
+	}
+	if ctx.propertyGuards == nil {
+		ctx.propertyGuards = make(map[propertyGuard]struct{})
+	}
+	ctx.propertyGuards[guard] = struct{}{}
+	ctx.propertyGuardMu.Unlock()
+
+	defer func() {
+		ctx.propertyGuardMu.Lock()
+		delete(ctx.propertyGuards, guard)
+		ctx.propertyGuardMu.Unlock()
+	}()
+
+	args := append([]*values.Value{values.NewString(propName)}, extra...)
+	b := &builtinContext{vm: vm, ctx: ctx, frame: frame}
+	result, err = b.invokeFunction(fn, objVal, obj.ClassName, args)
+	if err != nil {
+		return nil, true, err
+	}
+	if result == nil {
+		result = values.NewNull()
+	}
+	return result, true, nil
+}
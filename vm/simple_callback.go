@@ -47,6 +47,13 @@ func (b *builtinContext) SimpleCallUserFunction(function *registry.Function, arg
 		OutputWriter: b.ctx.OutputWriter,
 		Halted:       false,
 		ExitCode:     0,
+
+		// Callbacks run inside a fiber may suspend it
+		fibers:       b.ctx.fibers,
+		currentFiber: b.ctx.currentFiber,
+		generators:   b.ctx.generators,
+
+		autoloaders: b.ctx.autoloaders,
 	}
 
 	// Create call frame for the user function
@@ -0,0 +1,428 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/wudi/hey/registry"
+	"github.com/wudi/hey/values"
+)
+
+// Type declarations on parameters, return values and properties are
+// enforced as PHP does. In strict mode (declare(strict_types=1) in the file
+// making the call, returning or writing the property) a value must match
+// exactly, except that int widens to float. In weak mode scalars are
+// coerced to the declared scalar type when the conversion is meaningful.
+
+// coerceType checks value against a declared type and returns the value to
+// store, converted when weak mode requires it. The boolean is false when
+// the value is not accepted. scope supplies the classes self, static and
+// parent refer to.
+func (vm *VirtualMachine) coerceType(ctx *ExecutionContext, scope *CallFrame, declared string, value *values.Value, strict bool) (*values.Value, bool) {
+	if declared == "" {
+		return value, true
+	}
+	if value == nil {
+		value = values.NewNull()
+	}
+	members := splitUnionType(strings.TrimPrefix(declared, "?"))
+	if strings.HasPrefix(declared, "?") {
+		members = append(members, "null")
+	}
+	for _, member := range members {
+		if typeAccepts(ctx, scope, member, value) {
+			return value, true
+		}
+	}
+
+	accepts := make(map[string]bool, len(members))
+	for _, member := range members {
+		accepts[strings.ToLower(member)] = true
+	}
+	if value.IsInt() && accepts["float"] {
+		return values.NewFloat(float64(value.ToInt())), true
+	}
+	if strict || !(value.IsInt() || value.IsFloat() || value.IsString() || value.IsBool()) {
+		return nil, false
+	}
+
+	// Weak mode tries the scalar types in PHP's order of preference
+	if accepts["int"] {
+		if coerced, ok := coerceToInt(value, !accepts["float"] && !accepts["string"]); ok {
+			return coerced, true
+		}
+	}
+	if accepts["float"] {
+		if value.IsBool() {
+			return values.NewFloat(value.ToFloat()), true
+		}
+		if value.IsString() && value.IsNumericString() && strings.TrimSpace(value.ToString()) != "" {
+			return values.NewFloat(value.ToFloat()), true
+		}
+	}
+	if accepts["string"] && !value.IsString() {
+		return values.NewString(value.ToString()), true
+	}
+	if accepts["bool"] {
+		return values.NewBool(value.ToBool()), true
+	}
+	return nil, false
+}
+
+// coerceToInt converts a scalar for an int declaration. Floats and numeric
+// strings with a fractional part are only truncated when lossy is set,
+// otherwise another member of the union gets the chance to accept them.
+func coerceToInt(value *values.Value, lossy bool) (*values.Value, bool) {
+	var f float64
+	switch {
+	case value.IsBool():
+		return values.NewInt(value.ToInt()), true
+	case value.IsFloat():
+		f = value.ToFloat()
+	case value.IsString():
+		s := strings.TrimSpace(value.ToString())
+		if s == "" || !value.IsNumericString() {
+			return nil, false
+		}
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return values.NewInt(i), true
+		}
+		f = value.ToFloat()
+	default:
+		return nil, false
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return nil, false
+	}
+	if f != math.Trunc(f) && !lossy {
+		return nil, false
+	}
+	return values.NewInt(int64(f)), true
+}
+
+// splitUnionType splits a union at its top level, keeping the parentheses
+// of intersection members such as (A&B)|null.
+func splitUnionType(declared string) []string {
+	var members []string
+	depth, start := 0, 0
+	for i, r := range declared {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '|':
+			if depth == 0 {
+				members = append(members, strings.TrimSpace(declared[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(members, strings.TrimSpace(declared[start:]))
+}
+
+// typeAccepts reports whether value satisfies a single member of a type
+// declaration without any conversion
+func typeAccepts(ctx *ExecutionContext, scope *CallFrame, member string, value *values.Value) bool {
+	member = strings.TrimSuffix(strings.TrimPrefix(member, "("), ")")
+	if strings.Contains(member, "&") {
+		for _, part := range strings.Split(member, "&") {
+			if !typeAccepts(ctx, scope, strings.TrimSpace(part), value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch strings.ToLower(member) {
+	case "mixed":
+		return true
+	case "null", "void":
+		return value.IsNull()
+	case "int":
+		return value.IsInt()
+	case "float":
+		return value.IsFloat()
+	case "string":
+		return value.IsString()
+	case "bool":
+		return value.IsBool()
+	case "false":
+		return value.IsBool() && !value.ToBool()
+	case "true":
+		return value.IsBool() && value.ToBool()
+	case "array":
+		return value.IsArray()
+	case "object":
+		return value.IsObject() || value.IsClosure()
+	case "iterable":
+		return value.IsArray() || value.IsObject() && instanceOfClass(ctx, value.Data.(*values.Object).ClassName, "Traversable")
+	case "callable":
+		return isCallableValue(ctx, value)
+	case "never":
+		return false
+	case "self", "static", "parent":
+		target := scopeClass(ctx, scope, strings.ToLower(member))
+		if target == "" {
+			// Outside a class scope there is nothing to compare against
+			return value.IsObject()
+		}
+		return value.IsObject() && instanceOfClass(ctx, value.Data.(*values.Object).ClassName, target)
+	}
+
+	target := strings.TrimPrefix(member, "\\")
+	if value.IsClosure() {
+		return strings.EqualFold(target, "Closure")
+	}
+	return value.IsObject() && instanceOfClass(ctx, value.Data.(*values.Object).ClassName, target)
+}
+
+// scopeClass resolves self, static or parent for a frame
+func scopeClass(ctx *ExecutionContext, scope *CallFrame, keyword string) string {
+	if scope == nil {
+		return ""
+	}
+	switch keyword {
+	case "static":
+		if scope.This != nil && scope.This.IsObject() {
+			return scope.This.Data.(*values.Object).ClassName
+		}
+		if scope.CallingClass != "" {
+			return scope.CallingClass
+		}
+		return scope.ClassName
+	case "parent":
+		if scope.ClassName == "" {
+			return ""
+		}
+		if cls := ctx.ensureClass(scope.ClassName); cls != nil {
+			return cls.Parent
+		}
+		return ""
+	default:
+		return scope.ClassName
+	}
+}
+
+// isCallableValue reports whether value can be called: a closure, the name
+// of a function or method, or an [object-or-class, method] pair
+func isCallableValue(ctx *ExecutionContext, value *values.Value) bool {
+	switch {
+	case value.IsCallable():
+		return true
+	case value.IsString():
+		name := strings.TrimPrefix(value.ToString(), "\\")
+		if strings.Contains(name, "::") {
+			return true
+		}
+		ctx.userSymbolsMu.RLock()
+		_, ok := ctx.UserFunctions[strings.ToLower(name)]
+		ctx.userSymbolsMu.RUnlock()
+		if !ok && registry.GlobalRegistry != nil {
+			_, ok = registry.GlobalRegistry.GetFunction(name)
+		}
+		return ok
+	case value.IsArray():
+		return value.ArrayCount() == 2
+	case value.IsObject():
+		return resolveClassMethod(ctx, ctx.ensureClass(value.Data.(*values.Object).ClassName), "__invoke") != nil
+	}
+	return false
+}
+
+// instanceOfClass reports whether className is target, extends it or
+// implements it, directly or through interface inheritance
+func instanceOfClass(ctx *ExecutionContext, className, target string) bool {
+	if strings.EqualFold(className, target) {
+		return true
+	}
+	cls := ctx.ensureClass(className)
+	for depth := 0; cls != nil && depth < 64; depth++ {
+		if strings.EqualFold(cls.Name, target) {
+			return true
+		}
+		if cls.Descriptor != nil {
+			for _, iface := range cls.Descriptor.Interfaces {
+				if interfaceExtends(ctx, iface, target, 0) {
+					return true
+				}
+			}
+		}
+		if cls.Parent == "" {
+			break
+		}
+		cls = ctx.ensureClass(cls.Parent)
+	}
+	return false
+}
+
+func interfaceExtends(ctx *ExecutionContext, name, target string, depth int) bool {
+	if strings.EqualFold(name, target) {
+		return true
+	}
+	if depth > 32 {
+		return false
+	}
+	ctx.userSymbolsMu.RLock()
+	iface, ok := ctx.UserInterfaces[strings.ToLower(name)]
+	ctx.userSymbolsMu.RUnlock()
+	if !ok && registry.GlobalRegistry != nil {
+		iface, ok = registry.GlobalRegistry.GetInterface(name)
+	}
+	if !ok || iface == nil {
+		return false
+	}
+	for _, parent := range iface.Extends {
+		if interfaceExtends(ctx, parent, target, depth+1) {
+			return true
+		}
+	}
+	return false
+}
+
+// typeNameOf names the type of a value the way TypeError messages do
+func typeNameOf(value *values.Value) string {
+	switch {
+	case value == nil || value.IsNull():
+		return "null"
+	case value.IsObject():
+		return value.Data.(*values.Object).ClassName
+	case value.IsClosure():
+		return "Closure"
+	}
+	return value.Type.String()
+}
+
+// parameterType is the declared type of a parameter, made nullable when
+// the parameter defaults to null
+func parameterType(param *registry.Parameter) string {
+	declared := param.Type
+	if declared == "" || !param.HasDefault || param.DefaultValue == nil || !param.DefaultValue.IsNull() {
+		return declared
+	}
+	switch lower := strings.ToLower(declared); {
+	case strings.HasPrefix(declared, "?") || lower == "mixed" || lower == "null":
+		return declared
+	case strings.Contains(declared, "|"):
+		for _, member := range splitUnionType(lower) {
+			if member == "null" {
+				return declared
+			}
+		}
+		return declared + "|null"
+	}
+	return "?" + declared
+}
+
+// functionDisplayName names a function in error messages: name, Class::name
+// or {closure}
+func functionDisplayName(fn *registry.Function, className string) string {
+	if fn.IsAnonymous {
+		return "{closure}"
+	}
+	if className != "" {
+		return className + "::" + fn.Name
+	}
+	return fn.Name
+}
+
+// coerceArgument applies a parameter's type declaration to an argument.
+// On failure it returns the TypeError message.
+func (vm *VirtualMachine) coerceArgument(ctx *ExecutionContext, scope *CallFrame, fn *registry.Function, className string, param *registry.Parameter, position int, arg *values.Value, strict bool) (*values.Value, string) {
+	declared := parameterType(param)
+	target := arg
+	if arg != nil && arg.IsReference() {
+		target = arg.Deref()
+	}
+	coerced, ok := vm.coerceType(ctx, scope, declared, target, strict)
+	if !ok {
+		return nil, fmt.Sprintf("%s(): Argument #%d ($%s) must be of type %s, %s given",
+			functionDisplayName(fn, className), position+1, strings.TrimPrefix(param.Name, "$"), declared, typeNameOf(target))
+	}
+	if coerced == target {
+		return arg, ""
+	}
+	if arg.IsReference() {
+		// Coercion of a by-reference argument is visible to the caller
+		ref := arg.Data.(*values.Reference)
+		ref.Target.Type, ref.Target.Data = coerced.Type, coerced.Data
+		return arg, ""
+	}
+	return coerced, ""
+}
+
+// checkBuiltinArguments applies the scalar parameter types of a builtin
+// function to its arguments when the calling code is in strict mode.
+// Builtins convert scalars themselves, so weak mode leaves the arguments as
+// they are. On failure it returns the TypeError message.
+func (vm *VirtualMachine) checkBuiltinArguments(ctx *ExecutionContext, frame *CallFrame, fn *registry.Function, args []*values.Value) string {
+	if !frame.StrictTypes {
+		return ""
+	}
+	for i, arg := range args {
+		if i >= len(fn.Parameters) {
+			break
+		}
+		param := fn.Parameters[i]
+		if arg == nil || !isScalarType(parameterType(param)) {
+			continue
+		}
+		if _, typeErr := vm.coerceArgument(ctx, frame, fn, "", param, i, arg, true); typeErr != "" {
+			return typeErr
+		}
+	}
+	return ""
+}
+
+// isScalarType reports whether a declaration only admits scalars and null
+func isScalarType(declared string) bool {
+	if declared == "" {
+		return false
+	}
+	for _, member := range splitUnionType(strings.TrimPrefix(declared, "?")) {
+		switch strings.ToLower(member) {
+		case "int", "float", "string", "bool", "false", "true", "null":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// checkReturnType applies a function's return type to the value it returns.
+// On failure it returns the TypeError message.
+func (vm *VirtualMachine) checkReturnType(ctx *ExecutionContext, frame *CallFrame, value *values.Value) (*values.Value, string) {
+	fn := frame.Function
+	if fn == nil || fn.ReturnType == "" || fn.IsGenerator || value != nil && value.IsReference() {
+		return value, ""
+	}
+	name := functionDisplayName(fn, frame.ClassName)
+	switch strings.ToLower(fn.ReturnType) {
+	case "void":
+		return value, ""
+	case "never":
+		return nil, fmt.Sprintf("%s(): never-returning function must not implicitly return", name)
+	}
+	coerced, ok := vm.coerceType(ctx, frame, fn.ReturnType, value, frame.StrictTypes)
+	if !ok {
+		return nil, fmt.Sprintf("%s(): Return value must be of type %s, %s returned", name, fn.ReturnType, typeNameOf(value))
+	}
+	return coerced, ""
+}
+
+// checkPropertyType applies the type of a declared property to a value
+// being written to it. On failure it returns the TypeError message.
+func (vm *VirtualMachine) checkPropertyType(ctx *ExecutionContext, frame *CallFrame, obj *values.Object, propName string, value *values.Value) (*values.Value, string) {
+	prop, declaringClass := declaredProperty(ctx, obj.ClassName, propName)
+	if prop == nil || prop.Type == "" {
+		return value, ""
+	}
+	scope := &CallFrame{ClassName: declaringClass}
+	coerced, ok := vm.coerceType(ctx, scope, prop.Type, value, frame.StrictTypes)
+	if !ok {
+		return nil, fmt.Sprintf("Cannot assign %s to property %s::$%s of type %s", typeNameOf(value), declaringClass, propName, prop.Type)
+	}
+	return coerced, ""
+}
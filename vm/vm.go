@@ -3,7 +3,9 @@ package vm
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 
@@ -27,6 +29,11 @@ const (
 // execute additional source files on demand.
 type CompilerCallback func(ctx *ExecutionContext, program *ast.Program, filePath string, isRequired bool) (*values.Value, error)
 
+// IncludeCallback takes over include/require once the file has been
+// resolved and found readable, without parsing it first. It lets callers
+// execute previously compiled code for the file.
+type IncludeCallback func(ctx *ExecutionContext, filePath string, isRequired bool) (*values.Value, error)
+
 // HotSpot describes an instruction pointer that was executed frequently.
 type HotSpot struct {
 	IP    int
@@ -41,12 +48,23 @@ type VirtualMachine struct {
 	breakpoints map[int]struct{}
 	watchVars   map[string]struct{}
 
+	debugger *Debugger
+	profiler *Profiler
+	coverage *Coverage
+	// scriptCoverage is the recorder the pcov and Xdebug functions drive,
+	// kept apart from the one attached with SetCoverage
+	scriptCoverage *Coverage
+
+	// loadedCode lists the code run by Execute, for coverage started later
+	loadedCode []loadedCode
+
 	profile *profileState
 
 	advancedProfiling bool
 	DebugMode         bool
 
 	CompilerCallback CompilerCallback
+	IncludeCallback  IncludeCallback
 
 	mu          sync.RWMutex
 	lastContext *ExecutionContext
@@ -76,16 +94,19 @@ func (e *GoroutineExecutor) ExecuteFunction(fn *registry.Function, boundVarsMap
 	ctx := NewExecutionContext()
 
 	// Copy global state (classes, functions, etc.) from the main VM context
-	if e.vm.lastContext != nil {
+	e.vm.mu.RLock()
+	mainCtx := e.vm.lastContext
+	e.vm.mu.RUnlock()
+	if mainCtx != nil {
 		// Copy ClassTable from main context (sync.Map is thread-safe for reads)
-		e.vm.lastContext.ClassTable.Range(func(key, value interface{}) bool {
+		mainCtx.ClassTable.Range(func(key, value interface{}) bool {
 			// Create a deep copy of the class runtime to avoid shared state
 			ctx.ClassTable.Store(key, copyClassRuntime(value.(*classRuntime)))
 			return true
 		})
 
 		// Copy GlobalVars from main context
-		e.vm.lastContext.GlobalVars.Range(func(key, value interface{}) bool {
+		mainCtx.GlobalVars.Range(func(key, value interface{}) bool {
 			// Deep copy all global variables to prevent race conditions
 			ctx.GlobalVars.Store(key, copyValue(value.(*values.Value)))
 			return true
@@ -180,13 +201,45 @@ func storeGlobalClass(name string, class *registry.Class) *registry.Class {
 	globalClassMu.Lock()
 	defer globalClassMu.Unlock()
 	if existing, ok := globalClasses[key]; ok && existing != nil {
-		mergeClassDefinitions(existing, class)
-		return existing
+		// Compiled scripts are cached and run by concurrent requests, so a
+		// stored class is never written to; merging goes into a copy
+		if existing == class {
+			return existing
+		}
+		merged := cloneClassDefinition(existing)
+		mergeClassDefinitions(merged, class)
+		globalClasses[key] = merged
+		return merged
 	}
 	globalClasses[key] = class
 	return class
 }
 
+// cloneClassDefinition copies class with member maps, properties and
+// constants of its own. Methods are shared.
+func cloneClassDefinition(class *registry.Class) *registry.Class {
+	clone := *class
+	clone.Methods = maps.Clone(class.Methods)
+	clone.MethodOrder = slices.Clone(class.MethodOrder)
+	clone.PropertyOrder = slices.Clone(class.PropertyOrder)
+	clone.ConstantOrder = slices.Clone(class.ConstantOrder)
+	if class.Properties != nil {
+		clone.Properties = make(map[string]*registry.Property, len(class.Properties))
+		for name, prop := range class.Properties {
+			copied := *prop
+			clone.Properties[name] = &copied
+		}
+	}
+	if class.Constants != nil {
+		clone.Constants = make(map[string]*registry.ClassConstant, len(class.Constants))
+		for name, constant := range class.Constants {
+			copied := *constant
+			clone.Constants[name] = &copied
+		}
+	}
+	return &clone
+}
+
 func getGlobalClass(name string) *registry.Class {
 	globalClassMu.RLock()
 	defer globalClassMu.RUnlock()
@@ -224,6 +277,21 @@ func mergeClassDefinitions(target, source *registry.Class) {
 			target.Methods[name] = method
 		}
 	}
+	target.MethodOrder = mergeMemberOrder(source.MethodOrder, target.MethodOrder)
+	target.PropertyOrder = mergeMemberOrder(source.PropertyOrder, target.PropertyOrder)
+	target.ConstantOrder = mergeMemberOrder(source.ConstantOrder, target.ConstantOrder)
+}
+
+// mergeMemberOrder returns the declaration order of a merged class: the
+// latest declaration first, then the names only the earlier one has
+func mergeMemberOrder(latest, earlier []string) []string {
+	order := slices.Clone(latest)
+	for _, name := range earlier {
+		if !slices.Contains(order, name) {
+			order = append(order, name)
+		}
+	}
+	return order
 }
 
 // NewVirtualMachine constructs a VM with basic instrumentation disabled.
@@ -236,10 +304,6 @@ func NewVirtualMachine() *VirtualMachine {
 		DebugMode:   false,
 	}
 
-	// Register the goroutine executor
-	executor := &GoroutineExecutor{vm: vm}
-	runtime2.SetGoroutineExecutor(executor)
-
 	return vm
 }
 
@@ -323,7 +387,9 @@ func (vm *VirtualMachine) Execute(ctx *ExecutionContext, instructions []*opcodes
 	}
 	mergedClasses := make(map[string]*registry.Class, len(classes))
 	for name, class := range classes {
-		merged := storeGlobalClass(name, class)
+		// Class declarations update the definition as they run, so each
+		// request works on a copy of its own
+		merged := cloneClassDefinition(storeGlobalClass(name, class))
 		lower := strings.ToLower(name)
 		ctx.UserClasses[lower] = merged
 		mergedClasses[name] = merged
@@ -340,12 +406,33 @@ func (vm *VirtualMachine) Execute(ctx *ExecutionContext, instructions []*opcodes
 	for name, iface := range interfaces {
 		lower := strings.ToLower(name)
 		ctx.UserInterfaces[lower] = iface
+		if registry.GlobalRegistry != nil {
+			_ = registry.GlobalRegistry.RegisterInterface(iface)
+		}
 	}
 
 	// Copy traits to context
 	for name, trait := range traits {
 		lower := strings.ToLower(name)
 		ctx.UserTraits[lower] = trait
+		if registry.GlobalRegistry != nil {
+			_ = registry.GlobalRegistry.RegisterTrait(trait)
+		}
+	}
+
+	if ctx.classLoader == nil {
+		ctx.classLoader = func(name string) (bool, error) {
+			return vm.runAutoloaders(ctx, name)
+		}
+	}
+
+	code := loadedCode{instructions: instructions, functions: functions, classes: classes}
+	vm.loadedCode = append(vm.loadedCode, code)
+	if vm.coverage != nil {
+		vm.coverage.register(code)
+	}
+	if vm.scriptCoverage != nil {
+		vm.scriptCoverage.register(code)
 	}
 
 	mainFrame := newCallFrame("{main}", nil, instructions, constants)
@@ -359,6 +446,13 @@ func (vm *VirtualMachine) Execute(ctx *ExecutionContext, instructions []*opcodes
 }
 
 func (vm *VirtualMachine) run(ctx *ExecutionContext) error {
+	return vm.runUntil(ctx, nil)
+}
+
+// runUntil executes instructions until the script halts or, when base is
+// set, until base runs past its last instruction. Fibers run their call
+// stack on top of such a base frame.
+func (vm *VirtualMachine) runUntil(ctx *ExecutionContext, base *CallFrame) error {
 	for {
 		if err := ctx.CheckTimeout(); err != nil {
 			return err
@@ -371,6 +465,9 @@ func (vm *VirtualMachine) run(ctx *ExecutionContext) error {
 		}
 
 		if frame.IP < 0 || frame.IP >= len(frame.Instructions) {
+			if frame == base {
+				return nil
+			}
 			if err := vm.handleReturn(ctx, values.NewNull()); err != nil {
 				return err
 			}
@@ -379,14 +476,31 @@ func (vm *VirtualMachine) run(ctx *ExecutionContext) error {
 
 		inst := frame.Instructions[frame.IP]
 		vm.profile.observe(frame.IP, inst.Opcode)
+		if vm.profiler != nil {
+			vm.profiler.observe(ctx)
+		}
 
 		if vm.debugLevel != DebugLevelNone {
 			if _, ok := vm.breakpoints[frame.IP]; ok {
 				vm.recordDebug(ctx, fmt.Sprintf("breakpoint hit at %d (%s)", frame.IP, inst.Opcode))
 			}
 		}
+		if vm.debugger != nil {
+			if err := vm.debugInstruction(ctx, frame, inst); err != nil {
+				return err
+			}
+		}
+		if vm.coverage != nil || vm.scriptCoverage != nil {
+			vm.recordLine(frame, inst)
+		}
 
+		ip := frame.IP
+		ctx.instructionCount++
 		advance, err := vm.executeInstruction(ctx, frame, inst)
+		if ctx.autoloadErr != nil {
+			// An autoloader failed while the instruction ran
+			advance, err = vm.raiseAutoloadError(ctx)
+		}
 		if err != nil {
 			return vm.decorateError(frame, inst, err)
 		}
@@ -398,6 +512,9 @@ func (vm *VirtualMachine) run(ctx *ExecutionContext) error {
 		if advance {
 			frame.IP++
 		}
+		if (vm.coverage != nil || vm.scriptCoverage != nil) && isConditionalJump(inst.Opcode) {
+			vm.recordBranch(inst, frame.IP != ip+1)
+		}
 	}
 }
 
@@ -479,6 +596,8 @@ func (vm *VirtualMachine) executeInstruction(ctx *ExecutionContext, frame *CallF
 		return vm.execBindVarName(ctx, frame, inst)
 	case opcodes.OP_DECLARE_FUNCTION:
 		return vm.execDeclareFunction(ctx, frame, inst)
+	case opcodes.OP_DECLARE:
+		return vm.execDeclare(ctx, frame, inst)
 	case opcodes.OP_INIT_ARRAY:
 		return vm.execInitArray(ctx, frame, inst)
 	case opcodes.OP_ADD_ARRAY_ELEMENT:
@@ -527,6 +646,8 @@ func (vm *VirtualMachine) executeInstruction(ctx *ExecutionContext, frame *CallF
 		return vm.execFetchObj(ctx, frame, inst)
 	case opcodes.OP_FETCH_OBJ_IS:
 		return vm.execFetchObjIs(ctx, frame, inst)
+	case opcodes.OP_FETCH_OBJ_UNSET:
+		return vm.execFetchObjUnset(ctx, frame, inst)
 	case opcodes.OP_FETCH_STATIC_PROP_R:
 		return vm.execFetchStaticProp(ctx, frame, inst)
 	case opcodes.OP_FETCH_STATIC_PROP_W:
@@ -555,6 +676,8 @@ func (vm *VirtualMachine) executeInstruction(ctx *ExecutionContext, frame *CallF
 		return vm.execFeFetch(ctx, frame, inst)
 	case opcodes.OP_FE_FREE:
 		return vm.execFeFree(ctx, frame, inst)
+	case opcodes.OP_FREE:
+		return vm.execFree(ctx, frame, inst)
 	case opcodes.OP_INIT_FCALL, opcodes.OP_INIT_FCALL_BY_NAME:
 		return vm.execInitFCall(ctx, frame, inst)
 	case opcodes.OP_INIT_METHOD_CALL:
@@ -632,6 +755,13 @@ func (vm *VirtualMachine) handleReturn(ctx *ExecutionContext, value *values.Valu
 		ctx.Halted = true
 		return nil
 	}
+	if completed.Generator == nil && completed.FunctionName != "{main}" && holdsSuspendedGenerator(ctx, completed) {
+		// The returning function's variables may have held the last
+		// reference to a generator; the frame itself is still on the Go
+		// stack, so its values are dropped before collecting
+		completed.Locals, completed.TempVars, completed.Iterators = nil, nil, nil
+		defer vm.releaseGenerators(ctx)
+	}
 
 	caller := ctx.currentFrame()
 	if caller == nil {
@@ -642,9 +772,10 @@ func (vm *VirtualMachine) handleReturn(ctx *ExecutionContext, value *values.Valu
 		return nil
 	}
 
-	// Special handling for include/require: when returning from one {main} frame to another {main} frame,
-	// push the return value onto the stack instead of writing to ReturnTarget (which doesn't exist for includes)
-	if completed.FunctionName == "{main}" && caller.FunctionName == "{main}" {
+	// Special handling for include/require: when an included {main} frame returns, push the return
+	// value onto the stack instead of writing to ReturnTarget (which doesn't exist for includes).
+	// This applies to includes issued from inside functions too, so the nested run stops here.
+	if completed.FunctionName == "{main}" {
 		ctx.Stack = append(ctx.Stack, value)
 		ctx.Halted = true // Signal that include file has completed
 		return nil
@@ -702,6 +833,13 @@ func (vm *VirtualMachine) recordDebug(ctx *ExecutionContext, message string) {
 }
 
 func (vm *VirtualMachine) raiseException(ctx *ExecutionContext, frame *CallFrame, value *values.Value) (bool, error) {
+	// An exception thrown by an autoloader wins over the error the code that
+	// needed the class raises about it missing
+	var thrown *autoloaderException
+	if errors.As(ctx.autoloadErr, &thrown) {
+		ctx.autoloadErr = nil
+		value = thrown.exception
+	}
 
 	for {
 		if frame == nil {
@@ -730,12 +868,6 @@ func (vm *VirtualMachine) execYield(ctx *ExecutionContext, frame *CallFrame, ins
 	keyType := opcodes.DecodeOpType1(inst.OpType1)
 	valueType := opcodes.DecodeOpType2(inst.OpType1)
 
-	// Get the generator context from call frame
-	generator := frame.Generator
-	if generator == nil {
-		return false, fmt.Errorf("yield called outside generator context")
-	}
-
 	// Get key value (if any)
 	var keyValue *values.Value
 	if keyType != opcodes.IS_UNUSED {
@@ -744,6 +876,11 @@ func (vm *VirtualMachine) execYield(ctx *ExecutionContext, frame *CallFrame, ins
 		if err != nil {
 			return false, fmt.Errorf("error getting yield key: %v", err)
 		}
+		keyValue = copyValue(keyValue)
+		// Auto keys continue after the largest integer key used so far
+		if keyValue.IsInt() && keyValue.ToInt() >= int64(frame.generatorIndex) {
+			frame.generatorIndex = int(keyValue.ToInt()) + 1
+		}
 	} else {
 		// Auto-increment key for generators without explicit keys
 		keyValue = values.NewInt(int64(frame.generatorIndex))
@@ -758,27 +895,13 @@ func (vm *VirtualMachine) execYield(ctx *ExecutionContext, frame *CallFrame, ins
 		if err != nil {
 			return false, fmt.Errorf("error getting yield value: %v", err)
 		}
+		yieldValue = copyValue(yieldValue)
 	} else {
 		yieldValue = values.NewNull()
 	}
 
-	// Use the Yield method to store key and value
-	gen := generator.(*runtime2.Generator)
-	gen.Yield(keyValue, yieldValue)
-
-	// Store result if needed (yield expression result)
-	resultType := opcodes.DecodeResultType(inst.OpType2)
-	if resultType != opcodes.IS_UNUSED {
-		// For now, yield result is always null (sent value)
-		// In full PHP implementation, this would be the value sent via send() method
-		result := yieldValue
-		err := vm.writeOperand(ctx, frame, resultType, inst.Result, result)
-		if err != nil {
-			return false, fmt.Errorf("error storing yield result: %v", err)
-		}
-	}
-
-	return true, nil
+	// The yield evaluates to the value sent in when the generator is resumed
+	return vm.yield(ctx, frame, inst, keyValue, yieldValue)
 }
 
 func (vm *VirtualMachine) execYieldFrom(ctx *ExecutionContext, frame *CallFrame, inst *opcodes.Instruction) (bool, error) {
@@ -788,43 +911,42 @@ func (vm *VirtualMachine) execYieldFrom(ctx *ExecutionContext, frame *CallFrame,
 	if err != nil {
 		return false, fmt.Errorf("error reading yield from operand: %v", err)
 	}
-
-	// Get the generator context from call frame
-	generator := frame.Generator
-	if generator == nil {
-		return false, fmt.Errorf("yield from called outside generator context")
+	if iterable.IsReference() {
+		iterable = iterable.Deref()
 	}
 
-	gen := generator.(*runtime2.Generator)
-
-	// Start delegation - this sets up the generator to delegate to the iterable
-	if err := gen.StartDelegation(iterable); err != nil {
-		return false, fmt.Errorf("error starting delegation: %v", err)
+	// Arrays yield their own keys; the yield from evaluates to null
+	result := values.NewNull()
+	if iterable.IsArray() {
+		arr := iterable.Data.(*values.Array)
+		for _, key := range arr.Keys() {
+			keyValue := values.KeyValue(key)
+			if advance, err := vm.yield(ctx, frame, &opcodes.Instruction{}, keyValue, iterable.ArrayGet(keyValue)); !advance || err != nil {
+				return advance, err
+			}
+		}
+	} else if inner := runtime2.GeneratorOf(iterable); inner != nil {
+		// A delegated generator's return value is the result of yield from
+		if result, err = vm.yieldFromGenerator(ctx, frame, inner); err != nil {
+			return thrownError(ctx, err)
+		}
+	} else if iterable.IsObject() && instanceOfClass(ctx, iterable.Data.(*values.Object).ClassName, "Traversable") {
+		if advance, err := vm.yieldFromIterator(ctx, frame, iterable); !advance || err != nil {
+			return advance, err
+		}
+	} else {
+		return vm.throwError(ctx, frame, "Error", "Can use \"yield from\" only with arrays and Traversables")
 	}
 
-	// Store the final result (typically null, as yield from returns the final value)
 	resultType, resultSlot := decodeResult(inst)
 	if resultType != opcodes.IS_UNUSED {
-		finalResult := values.NewNull() // For now, always return null
-		if err := vm.writeOperand(ctx, frame, resultType, resultSlot, finalResult); err != nil {
+		if err := vm.writeOperand(ctx, frame, resultType, resultSlot, result); err != nil {
 			return false, fmt.Errorf("error storing yield from result: %v", err)
 		}
 	}
-
-	// The delegation will be handled by the generator's Next() method
-	// We suspend here just like a regular yield would
 	return true, nil
 }
 
-// ExecuteGeneratorFunction provides a stub implementation for generators
-// This is a temporary placeholder to prevent infinite loops in tests
-func (vm *VirtualMachine) ExecuteGeneratorFunction(function *registry.Function, args []*values.Value, generator *runtime2.Generator) (bool, error) {
-	// For now, return false to indicate no more values
-	// This prevents infinite loops while maintaining compatibility
-	// A full generator implementation would execute the function and handle yields
-	return false, nil
-}
-
 // ExecuteFunction executes a function in the given context and frame
 func (vm *VirtualMachine) ExecuteFunction(ctxInterface, frameInterface interface{}) error {
 	ctx, ok := ctxInterface.(*ExecutionContext)
@@ -841,8 +963,9 @@ func (vm *VirtualMachine) ExecuteFunction(ctxInterface, frameInterface interface
 	ctx.CallStack = append(ctx.CallStack, frame)
 	defer func() {
 		// Pop frame when done
-		if len(ctx.CallStack) > 0 {
-			ctx.CallStack = ctx.CallStack[:len(ctx.CallStack)-1]
+		if idx := len(ctx.CallStack) - 1; idx >= 0 {
+			ctx.CallStack[idx] = nil
+			ctx.CallStack = ctx.CallStack[:idx]
 		}
 	}()
 
@@ -2,9 +2,14 @@ package vmfactory
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/wudi/hey/compiler/ast"
+	"github.com/wudi/hey/compiler/lexer"
+	"github.com/wudi/hey/compiler/parser"
 	"github.com/wudi/hey/opcodes"
+	"github.com/wudi/hey/pkg/fpm/opcache"
 	"github.com/wudi/hey/registry"
 	"github.com/wudi/hey/values"
 	"github.com/wudi/hey/vm"
@@ -25,10 +30,21 @@ type Compiler interface {
 // CompilerFactory creates compiler instances to avoid direct import
 type CompilerFactory func() Compiler
 
+// ParseError reports the parser errors for a script compiled with CompileFile.
+type ParseError struct {
+	File   string
+	Errors []string
+}
+
+func (e *ParseError) Error() string {
+	return strings.Join(e.Errors, "\n")
+}
+
 // VMFactory creates VirtualMachine instances with pre-configured compiler callbacks.
 // This eliminates the need for manual CompilerCallback setup in every usage.
 type VMFactory struct {
 	compilerFactory CompilerFactory
+	opcache         opcache.Cache
 }
 
 // NewVMFactory creates a new VM factory with the provided compiler factory.
@@ -39,48 +55,164 @@ func NewVMFactory(compilerFactory CompilerFactory) *VMFactory {
 	}
 }
 
+// SetOpcache makes CompileFile and include/require in VMs created afterwards
+// reuse compiled scripts from cache, recompiling a file only when it changes.
+// The cache may be the in-memory OpcacheManager or an on-disk FileCache.
+func (f *VMFactory) SetOpcache(cache opcache.Cache) {
+	f.opcache = cache
+}
+
 // CreateVM creates a new VirtualMachine with properly configured CompilerCallback.
 // This replaces the manual setupCompilerCallback pattern throughout the codebase.
 func (f *VMFactory) CreateVM() *vm.VirtualMachine {
 	vmachine := vm.NewVirtualMachine()
 	vmachine.CompilerCallback = f.createCompilerCallback(vmachine)
+	if f.opcache != nil {
+		vmachine.IncludeCallback = f.createIncludeCallback(vmachine)
+	}
 	return vmachine
 }
 
+// CompileFile lexes, parses and compiles the script at path, going through
+// the opcache when one is set. Parser errors are returned as *ParseError.
+func (f *VMFactory) CompileFile(path string) (*opcache.CompiledScript, error) {
+	compiled, err := f.loadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(compiled.ParseErrors) != 0 {
+		return nil, &ParseError{File: path, Errors: compiled.ParseErrors}
+	}
+	return compiled, nil
+}
+
+// loadFile compiles path, or fetches it from the opcache. Parser errors are
+// recorded on the result rather than failing, since include/require runs
+// whatever the parser recovered.
+func (f *VMFactory) loadFile(path string) (*opcache.CompiledScript, error) {
+	if f.opcache != nil {
+		return f.opcache.Load(path, func(source []byte) (*opcache.CompiledScript, error) {
+			return f.compileSource(path, source)
+		})
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.compileSource(path, source)
+}
+
+func (f *VMFactory) compileSource(path string, source []byte) (*opcache.CompiledScript, error) {
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return &opcache.CompiledScript{ParseErrors: p.Errors()}, nil
+	}
+	return f.compileProgram(program, path)
+}
+
+func (f *VMFactory) compileProgram(program *ast.Program, filePath string) (*opcache.CompiledScript, error) {
+	comp := f.compilerFactory()
+	if filePath != "" {
+		comp.SetCurrentFile(filePath)
+	}
+	if err := comp.Compile(program); err != nil {
+		return nil, fmt.Errorf("compilation error in %s: %w", filePath, err)
+	}
+	return &opcache.CompiledScript{
+		Instructions: comp.GetBytecode(),
+		Constants:    comp.GetConstants(),
+		Functions:    comp.Functions(),
+		Classes:      comp.Classes(),
+		Interfaces:   comp.Interfaces(),
+		Traits:       comp.Traits(),
+	}, nil
+}
+
+// expressionFunction names the function CompileExpression wraps
+// expressions in
+const expressionFunction = "__hey_expression"
+
+// CompileExpression compiles a PHP expression into a function returning its
+// value. The function reads the expression's variables from its locals,
+// which the caller fills in, as the debugger does with those of the frame
+// it evaluates in.
+func (f *VMFactory) CompileExpression(expression string) (*registry.Function, error) {
+	p := parser.New(lexer.New("<?php function " + expressionFunction + "() { return (" + expression + "); }"))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.Errors()}
+	}
+	comp := f.compilerFactory()
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+	fn, ok := comp.Functions()[expressionFunction]
+	if !ok {
+		return nil, fmt.Errorf("invalid expression: %s", expression)
+	}
+	return fn, nil
+}
+
 // createCompilerCallback returns the standard compiler callback implementation.
 // This consolidates the duplicated callback logic from cmd/hey/main.go.
 func (f *VMFactory) createCompilerCallback(vmachine *vm.VirtualMachine) vm.CompilerCallback {
 	return func(ctx *vm.ExecutionContext, program *ast.Program, filePath string, isRequired bool) (*values.Value, error) {
-		comp := f.compilerFactory()
-		if filePath != "" {
-			comp.SetCurrentFile(filePath)
-		}
-		if err := comp.Compile(program); err != nil {
-			return nil, fmt.Errorf("compilation error in %s: %w", filePath, err)
+		compiled, err := f.compileProgram(program, filePath)
+		if err != nil {
+			return nil, err
 		}
+		return executeInclude(vmachine, ctx, compiled, filePath)
+	}
+}
 
-		// Execute the included file directly in the same context
-		// This ensures variables defined in the include are accessible to the caller
-		err := vmachine.Execute(ctx, comp.GetBytecode(), comp.GetConstants(),
-			comp.Functions(), comp.Classes(), comp.Interfaces(), comp.Traits())
+// createIncludeCallback returns the include callback used with an opcache,
+// which skips parsing entirely when the file is already cached.
+func (f *VMFactory) createIncludeCallback(vmachine *vm.VirtualMachine) vm.IncludeCallback {
+	return func(ctx *vm.ExecutionContext, filePath string, isRequired bool) (*values.Value, error) {
+		compiled, err := f.loadFile(filePath)
 		if err != nil {
-			return nil, fmt.Errorf("execution error in %s: %w", filePath, err)
+			return nil, err
 		}
-
-		// After include execution, check the stack for return value
-		// The handleReturn function pushes return values onto the stack when returning from include files
-		if ctx.Halted && len(ctx.Stack) > 0 {
-			returnValue := ctx.Stack[len(ctx.Stack)-1]
-			ctx.Stack = ctx.Stack[:len(ctx.Stack)-1]
-			ctx.Halted = false // Reset Halted state so main script can continue
-			if returnValue.IsNull() {
-				return values.NewInt(1), nil
+		if len(compiled.ParseErrors) != 0 {
+			// Keep the uncached behaviour of running what the parser recovered
+			source, err := os.ReadFile(filePath)
+			if err != nil {
+				return nil, err
+			}
+			program := parser.New(lexer.New(string(source))).ParseProgram()
+			if compiled, err = f.compileProgram(program, filePath); err != nil {
+				return nil, err
 			}
-			return returnValue, nil
 		}
+		return executeInclude(vmachine, ctx, compiled, filePath)
+	}
+}
 
-		// No return statement in included file, return 1 by default
-		ctx.Halted = false // Reset Halted state
-		return values.NewInt(1), nil
+// executeInclude runs an included file's code in the including context and
+// returns the value of the include expression.
+func executeInclude(vmachine *vm.VirtualMachine, ctx *vm.ExecutionContext, compiled *opcache.CompiledScript, filePath string) (*values.Value, error) {
+	// Execute the included file directly in the same context
+	// This ensures variables defined in the include are accessible to the caller
+	err := vmachine.Execute(ctx, compiled.Instructions, compiled.Constants,
+		compiled.Functions, compiled.Classes, compiled.Interfaces, compiled.Traits)
+	if err != nil {
+		return nil, fmt.Errorf("execution error in %s: %w", filePath, err)
 	}
+
+	// After include execution, check the stack for return value
+	// The handleReturn function pushes return values onto the stack when returning from include files
+	if ctx.Halted && len(ctx.Stack) > 0 {
+		returnValue := ctx.Stack[len(ctx.Stack)-1]
+		ctx.Stack = ctx.Stack[:len(ctx.Stack)-1]
+		ctx.Halted = false // Reset Halted state so main script can continue
+		if returnValue.IsNull() {
+			return values.NewInt(1), nil
+		}
+		return returnValue, nil
+	}
+
+	// No return statement in included file, return 1 by default
+	ctx.Halted = false // Reset Halted state
+	return values.NewInt(1), nil
 }